@@ -0,0 +1,36 @@
+package main
+
+import "fmt"
+
+// iconAltTexts holds the icon alt text per Steam language: the format for a
+// named achievement and the text used when the name is not known (hidden
+// achievements). The description is never used, so hidden achievements do
+// not leak through alt texts.
+var iconAltTexts = map[string]struct {
+	named   string
+	unnamed string
+}{
+	"french":  {"Icône du succès « %s »", "Icône d'un succès secret"},
+	"english": {"Icon of the achievement “%s”", "Icon of a secret achievement"},
+	"german":  {"Symbol der Errungenschaft „%s“", "Symbol einer geheimen Errungenschaft"},
+	"spanish": {"Icono del logro «%s»", "Icono de un logro secreto"},
+}
+
+func iconAlt(lang string, a Achievement) string {
+	t, ok := iconAltTexts[lang]
+	if !ok {
+		t = iconAltTexts["french"]
+	}
+	if a.Name == "" {
+		return t.unnamed
+	}
+	return fmt.Sprintf(t.named, a.Name)
+}
+
+// applyAccessibility fills IconAlt and DescriptionHidden on items.
+func applyAccessibility(lang string, items []Achievement) {
+	for i := range items {
+		items[i].IconAlt = iconAlt(lang, items[i])
+		items[i].DescriptionHidden = items[i].Hidden && items[i].Description == ""
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIconAltPerLanguage(t *testing.T) {
+	named := Achievement{APIName: "A", Name: "Facile", Description: "Tuer un slime"}
+	secret := Achievement{APIName: "B", Hidden: true, Description: "Vaincre le boss"}
+	tests := []struct {
+		lang          string
+		named, secret string
+	}{
+		{"french", "Icône du succès « Facile »", "Icône d'un succès secret"},
+		{"english", "Icon of the achievement “Facile”", "Icon of a secret achievement"},
+		{"german", "Symbol der Errungenschaft „Facile“", "Symbol einer geheimen Errungenschaft"},
+		{"spanish", "Icono del logro «Facile»", "Icono de un logro secreto"},
+		// A language without texts gets the French ones.
+		{"japanese", "Icône du succès « Facile »", "Icône d'un succès secret"},
+	}
+	for _, tt := range tests {
+		if got := iconAlt(tt.lang, named); got != tt.named {
+			t.Errorf("%s named: %q, want %q", tt.lang, got, tt.named)
+		}
+		got := iconAlt(tt.lang, secret)
+		if got != tt.secret {
+			t.Errorf("%s secret: %q, want %q", tt.lang, got, tt.secret)
+		}
+		if strings.Contains(got, "boss") {
+			t.Errorf("%s alt text leaks the description: %q", tt.lang, got)
+		}
+	}
+}
+
+func TestApplyAccessibility(t *testing.T) {
+	items := []Achievement{
+		{APIName: "A", Name: "Facile", Description: "d"},
+		{APIName: "B", Name: "Dur", Hidden: true},
+		{APIName: "C", Name: "Cache", Hidden: true, Description: "d"},
+	}
+	applyAccessibility("english", items)
+	for i, want := range []bool{false, true, false} {
+		if items[i].DescriptionHidden != want || items[i].IconAlt == "" {
+			t.Errorf("%s: descriptionHidden %v, iconAlt %q", items[i].APIName, items[i].DescriptionHidden, items[i].IconAlt)
+		}
+	}
+
+	// A redacted achievement says nothing, its icon included.
+	redactAchievement(&items[1])
+	if items[1].IconAlt != "" || items[1].Icon != "" || items[1].DescriptionHidden {
+		t.Errorf("redacted = %+v", items[1])
+	}
+}
+
+func TestHTMLExportIconAlt(t *testing.T) {
+	items := []Achievement{
+		{APIName: "A", Name: `Le "boss" <final>`, Icon: "https://cdn/a.jpg"},
+		{APIName: "B", Redacted: true},
+	}
+	applyAccessibility("french", items[:1])
+	var b strings.Builder
+	if err := encodeAchievementsHTML(&b, achievementExport{AppID: 105600, Items: items, Charset: charsetUTF8, NumLocale: numLocaleFrench}); err != nil {
+		t.Fatal(err)
+	}
+	body := b.String()
+	want := `<img src="https://cdn/a.jpg" alt="Icône du succès « Le &#34;boss&#34; &lt;final&gt; »" width="32" height="32">`
+	if !strings.Contains(body, want) {
+		t.Errorf("icon of A missing or unescaped, want %s in\n%s", want, body)
+	}
+	if strings.Count(body, "<img") != 1 {
+		t.Errorf("%d icons, want none for the redacted achievement", strings.Count(body, "<img"))
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /achievements negotiates its format: ?format=json (the default), csv,
+// xlsx, html or md, or Accept: text/csv without ?format=. The CSV (apiName,
+// name, description, hidden, globalPct, plus achieved and unlockTime on a
+// player's list) starts with a UTF-8 BOM so spreadsheet tools read the
+// accents right, is separated by ";" in the fr number locale (whose
+// decimals take the comma, as French spreadsheets expect), and is sent as
+// an attachment like the XLSX; the HTML is a plain table to read or print,
+// icons with their alt text and the time of X-Data-Fetched-At in the
+// footer, and the Markdown a checklist, both with unlock dates spelled out
+// in ?tz= (UTC by default). They ignore the payload profile, and the CSV
+// and HTML can be sent in latin-1, see charset.go.
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatHTML = "html"
+	formatMD   = "md"
+)
+
+const utf8BOM = "\ufeff"
+
+// achievementExport is what the CSV and HTML encoders are given. They
+// always write UTF-8; Charset is what the body is sent in, see charset.go.
+type achievementExport struct {
+	AppID   AppID
+	Items   []Achievement
+	Charset string
+	// Unlocks is set when Items carry a player's unlocks.
+	Unlocks bool
+	// NumLocale formats the percentages of the CSV and HTML, and the dates
+	// of the HTML and Markdown, shown in TimeZone.
+	NumLocale string
+	TimeZone  *time.Location
+	// FetchedAt is the data time of the X-Data-Fetched-At header, shown
+	// in the HTML footer; zero when the response has none.
+	FetchedAt time.Time
+}
+
+// Pct is a percentage as the HTML table shows it: "2,3 %" or "2.3%".
+func (e achievementExport) Pct(v float64) string { return formatPercent(v, e.NumLocale) }
+
+// Unlocked is the unlock date of a as the HTML and Markdown show it, or
+// "oui"/"yes" when Steam did not say when.
+func (e achievementExport) Unlocked(a Achievement) string {
+	if !a.UnlockTime.Known() {
+		if e.NumLocale == numLocaleFrench {
+			return "oui"
+		}
+		return "yes"
+	}
+	loc := e.TimeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+	return formatDateTime(a.UnlockTime.Time(), e.NumLocale, loc)
+}
+
+// DataNote is the HTML footer line giving FetchedAt.
+func (e achievementExport) DataNote() string {
+	loc := e.TimeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+	if e.NumLocale == numLocaleFrench {
+		return "Données Steam du " + formatDateTime(e.FetchedAt, e.NumLocale, loc)
+	}
+	return "Steam data of " + formatDateTime(e.FetchedAt, e.NumLocale, loc)
+}
+
+func (e achievementExport) columns() []string {
+	cols := []string{"apiName", "name", "description", "hidden", "globalPct"}
+	if e.Unlocks {
+		cols = append(cols, "achieved", "unlockTime")
+	}
+	return cols
+}
+
+var responseEncoders = map[string]responseEncoder{
+	formatJSON: jsonEncoder,
+	formatCSV:  {contentType: "text/csv; charset=utf-8", encode: encodeAchievementsCSV},
+	formatHTML: {contentType: "text/html; charset=utf-8", encode: encodeAchievementsHTML},
+	formatMD:   {contentType: "text/markdown; charset=utf-8", encode: encodeAchievementsMarkdown},
+	formatXLSX: {contentType: xlsxContentType, encode: encodeAchievementsXLSX},
+}
+
+func supportedFormats() []string {
+	out := make([]string, 0, len(responseEncoders))
+	for f := range responseEncoders {
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// acceptedFormat picks the format of the highest-weighted media type of an
+// Accept header; anything it cannot map gets JSON.
+func acceptedFormat(accept string) string {
+	best, bestQ := formatJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		var format string
+		switch mediaType {
+		case "text/csv":
+			format = formatCSV
+		case xlsxContentType:
+			format = formatXLSX
+		case "text/markdown":
+			format = formatMD
+		case "application/json", "application/*", "*/*":
+			format = formatJSON
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// negotiateFormat reads ?format= or, without it, Accept. An unknown
+// ?format= is answered 406 and ok is false.
+func negotiateFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	raw := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if raw == "" {
+		addVary(w.Header(), "Accept")
+		return acceptedFormat(r.Header.Get("Accept")), true
+	}
+	if _, ok := responseEncoders[raw]; !ok {
+		formats := supportedFormats()
+		writeJSONStatus(w, http.StatusNotAcceptable, map[string]any{
+			"error":     "unsupported_format",
+			"details":   "Format inconnu: " + raw + ". Formats acceptes: " + strings.Join(formats, ", "),
+			"supported": formats,
+		})
+		return "", false
+	}
+	return raw, true
+}
+
+// export writes items in the CSV, XLSX or HTML format of q and reports
+// whether it did; JSON is left to the caller, which knows the envelope.
+func (q achievementQuery) export(w http.ResponseWriter, appID AppID, items []Achievement) bool {
+	if q.format == "" || q.format == formatJSON {
+		return false
+	}
+	enc := responseEncoders[q.format]
+	export := achievementExport{AppID: appID, Items: items, Charset: charsetUTF8, Unlocks: q.unlocks,
+		NumLocale: numberLocaleFor(q.numLocale, q.lang), TimeZone: q.tz, FetchedAt: dataFetchedAt(w)}
+	if len(q.charsets) > 0 && q.charsets[0] == charsetLatin1 {
+		var buf bytes.Buffer
+		latin1 := export
+		latin1.Charset = charsetLatin1
+		latin1.Items = make([]Achievement, len(items))
+		for i, a := range items {
+			a.APIName, a.Name, a.Description = foldLatin1(a.APIName), foldLatin1(a.Name), foldLatin1(a.Description)
+			latin1.Items[i] = a
+		}
+		_ = enc.encode(&buf, latin1)
+		body, substituted, runes := toLatin1(buf.Bytes())
+		switch {
+		case float64(substituted) <= latin1MaxSubstituted*float64(runes):
+			w.Header().Set("X-Charset-Substitutions", strconv.Itoa(substituted))
+			enc = responseEncoder{contentType: withCharset(enc.contentType, charsetLatin1), encode: func(w io.Writer, _ any) error {
+				_, err := w.Write(body)
+				return err
+			}}
+		case !slices.Contains(q.charsets, charsetUTF8):
+			writeJSONStatus(w, http.StatusNotAcceptable, map[string]any{
+				"error":     "unrepresentable_charset",
+				"details":   fmt.Sprintf("%d caracteres sur %d n'existent pas en %s, demande %s", substituted, runes, charsetLatin1, charsetUTF8),
+				"supported": []string{charsetUTF8},
+			})
+			return true
+		}
+	}
+	if q.format == formatCSV || q.format == formatXLSX {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="achievements-%d.%s"`, appID, q.format))
+	}
+	writeEncoded(w, http.StatusOK, enc, export)
+	return true
+}
+
+func encodeAchievementsCSV(w io.Writer, v any) error {
+	e := v.(achievementExport)
+	if e.Charset == charsetUTF8 {
+		if _, err := io.WriteString(w, utf8BOM); err != nil {
+			return err
+		}
+	}
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if e.NumLocale == numLocaleFrench {
+		cw.Comma = ';'
+	}
+	cw.Write(e.columns())
+	for _, a := range e.Items {
+		row := []string{a.APIName, a.Name, a.Description, strconv.FormatBool(a.Hidden), formatDecimal(a.GlobalPct, e.NumLocale)}
+		if e.Unlocks {
+			unlocked := ""
+			if a.UnlockTime.Known() {
+				unlocked = a.UnlockTime.Time().Format(time.RFC3339)
+			}
+			row = append(row, strconv.FormatBool(a.Achieved), unlocked)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var achievementsHTMLTemplate = newPageTemplate("achievements.html", nil, `<!doctype html>
+<html lang="fr">
+<head>
+<meta charset="{{.Charset}}">
+<title>Succès de l'app {{.AppID}} - Steam Completion Tracker</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #1b2838; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4rem .6rem; border-bottom: 1px solid #ddd; vertical-align: top; }
+td.icon { width: 32px; }
+td.pct { text-align: right; white-space: nowrap; }
+.hidden { color: #777; }
+footer { margin-top: 1rem; color: #777; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Succès de l'app {{.AppID}}</h1>
+<p>{{len .Items}} succès</p>
+<table>
+<tr><th></th><th>Nom</th><th>Description</th><th>Caché</th><th>Joueurs</th>{{if .Unlocks}}<th>Débloqué</th>{{end}}</tr>
+{{range .Items}}<tr{{if .Hidden}} class="hidden"{{end}}>
+<td class="icon">{{if .Icon}}<img src="{{.Icon}}" alt="{{.IconAlt}}" width="32" height="32">{{end}}</td><td>{{.Name}}<br><code>{{.APIName}}</code></td><td>{{.Description}}</td><td>{{if .Hidden}}oui{{end}}</td><td class="pct">{{$.Pct .GlobalPct}}</td>{{if $.Unlocks}}<td>{{if .Achieved}}{{$.Unlocked .}}{{end}}</td>{{end}}
+</tr>
+{{end}}</table>
+{{if not .FetchedAt.IsZero}}<footer><p>{{.DataNote}}</p></footer>
+{{end}}</body>
+</html>
+`)
+
+func encodeAchievementsHTML(w io.Writer, v any) error {
+	return achievementsHTMLTemplate.Execute(w, v.(achievementExport))
+}
+
+// markdownEscaper keeps names and descriptions from being read as markup.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "\n", " ", "\r", "")
+
+// encodeAchievementsMarkdown writes a checklist, one achievement per item,
+// ticked when unlocked:
+//
+//   - [x] **Name** - description (15 janvier 2025 à 14:05)
+func encodeAchievementsMarkdown(w io.Writer, v any) error {
+	e := v.(achievementExport)
+	var b strings.Builder
+	title := "Succès de l'app %d\n\n"
+	if e.NumLocale != numLocaleFrench {
+		title = "Achievements of app %d\n\n"
+	}
+	fmt.Fprintf(&b, "# "+title, e.AppID)
+	for _, a := range e.Items {
+		box := " "
+		if a.Achieved {
+			box = "x"
+		}
+		fmt.Fprintf(&b, "- [%s] **%s**", box, markdownEscaper.Replace(a.Name))
+		if a.Description != "" {
+			b.WriteString(" - " + markdownEscaper.Replace(a.Description))
+		}
+		if e.Unlocks && a.Achieved && a.UnlockTime.Known() {
+			b.WriteString(" (" + e.Unlocked(a) + ")")
+		} else if !e.Unlocks {
+			b.WriteString(" (" + e.Pct(a.GlobalPct) + ")")
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// handleAchievementsExport is /achievements/export: the list of ?appId= as
+// a spreadsheet download, ?format=csv (the default) or xlsx, merged with
+// the unlocks of ?steamId= when given. It is /achievements or
+// /players/{steamid}/achievements with the format set.
+func (s *Server) handleAchievementsExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := strings.ToLower(strings.TrimSpace(q.Get("format")))
+	if format == "" {
+		format = formatCSV
+	}
+	if format != formatCSV && format != formatXLSX {
+		writeJSONStatus(w, http.StatusNotAcceptable, map[string]any{
+			"error":     "unsupported_format",
+			"details":   "Format inconnu: " + format + ". Formats acceptes: " + formatCSV + ", " + formatXLSX,
+			"supported": []string{formatCSV, formatXLSX},
+		})
+		return
+	}
+	identifier := strings.TrimSpace(q.Get("steamId"))
+	q.Set("format", format)
+	q.Del("steamId")
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = q.Encode()
+	if identifier == "" {
+		s.handleAchievements(w, r)
+		return
+	}
+	steamID, err := s.resolveSteamIDInput(identifier)
+	if err != nil {
+		writeIdentifierError(w, err)
+		return
+	}
+	s.servePlayerAchievements(w, r.WithContext(withUpstreamFeature(r.Context(), featurePlayerFetch)), steamID)
+}
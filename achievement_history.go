@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AchievementHistory is the payload of /achievements/{apiName}/history:
+// every percentage snapshot recorded for one achievement, oldest first,
+// optionally bounded by ?from= and ?to= (YYYY-MM-DD, UTC, inclusive).
+// Past HISTORY_FULL_RESOLUTION the points are daily or monthly averages,
+// as their source says (see history_compaction.go).
+type AchievementHistory struct {
+	AppID    AppID          `json:"appId"`
+	APIName  string         `json:"apiName"`
+	Name     string         `json:"name"`
+	Redacted bool           `json:"redacted,omitempty"`
+	From     string         `json:"from,omitempty"`
+	To       string         `json:"to,omitempty"`
+	Points   []historyPoint `json:"points"`
+}
+
+type historyPoint struct {
+	RecordedAt time.Time `json:"recordedAt"`
+	GlobalPct  float64   `json:"globalPct"`
+	Source     string    `json:"source"`
+}
+
+// parseHistoryRange reads the optional bounds of a history query; a
+// missing from starts at the first snapshot, a missing to ends now.
+func parseHistoryRange(from, to string) (time.Time, time.Time, error) {
+	start, end := time.Unix(0, 0).UTC(), time.Now().UTC()
+	if from = strings.TrimSpace(from); from != "" {
+		t, err := time.Parse(archiveDateLayout, from)
+		if err != nil {
+			return start, end, fmt.Errorf("from must be a date formatted YYYY-MM-DD")
+		}
+		start = t
+	}
+	if to = strings.TrimSpace(to); to != "" {
+		t, err := time.Parse(archiveDateLayout, to)
+		if err != nil {
+			return start, end, fmt.Errorf("to must be a date formatted YYYY-MM-DD")
+		}
+		end = t.Add(24*time.Hour - time.Second)
+	}
+	if end.Before(start) {
+		return start, end, fmt.Errorf("to must not be before from")
+	}
+	return start, end, nil
+}
+
+// achievementName is the last known name of apiName in appID; ok is false
+// when the achievement was never seen.
+func (s *Server) achievementName(appID AppID, apiName string) (string, bool, error) {
+	var name string
+	err := s.db.QueryRow(`SELECT name FROM achievement_schema_history WHERE app_id=? AND api_name=?`, appID, apiName).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return name, err == nil, err
+}
+
+func (s *Server) readAchievementHistory(appID AppID, apiName string, start, end time.Time) ([]historyPoint, error) {
+	rows, err := s.db.Query(`
+		SELECT recorded_at, percent, source FROM global_percent_history
+		WHERE app_id=? AND api_name=? AND recorded_at BETWEEN ? AND ?
+		ORDER BY recorded_at
+	`, appID, apiName, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	points := make([]historyPoint, 0)
+	for rows.Next() {
+		var sec int64
+		var p historyPoint
+		if err := rows.Scan(&sec, &p.GlobalPct, &p.Source); err != nil {
+			return nil, err
+		}
+		p.RecordedAt = time.Unix(sec, 0).UTC()
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (s *Server) handleAchievementHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	appID := defaultGlobalAppID
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		v, err := parseAppID(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
+		}
+		appID = v
+	}
+	if !s.appAllowed(appID) {
+		writeAppNotAllowed(w, appID)
+		return
+	}
+	start, end, err := parseHistoryRange(q.Get("from"), q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	apiName := r.PathValue("apiName")
+	p := s.policyFor(w, appID)
+	name, known, err := s.achievementName(appID, apiName)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	points, err := s.readAchievementHistory(appID, apiName, start, end)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	// A hidden achievement answers like one that does not exist.
+	if p.hidden(apiName) || (!known && len(points) == 0) {
+		writeError(w, http.StatusNotFound, "unknown_achievement", fmt.Sprintf("Aucun succes %q connu pour l'app %d", apiName, appID))
+		return
+	}
+
+	resp := AchievementHistory{AppID: appID, APIName: apiName, Name: name, Points: points}
+	if p.redacted(apiName) {
+		resp.Name, resp.Redacted = "", true
+	}
+	if q.Get("from") != "" {
+		resp.From = start.Format(archiveDateLayout)
+	}
+	if q.Get("to") != "" {
+		resp.To = end.Format(archiveDateLayout)
+	}
+	setSurrogateKeys(w, appSurrogateKey(appID))
+	writeJSON(w, resp)
+}
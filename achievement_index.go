@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+)
+
+// achievementIndex is built once per list so filtering does not rescan or
+// re-lowercase every achievement per request. The shared per-app lists
+// keep theirs in the schema cache entry, built when the schema or the
+// percentages are stored (see indexAppLists).
+type achievementIndex struct {
+	appID  AppID
+	items  []Achievement
+	text   []string         // lowercased "name\ndescription\napiName"
+	byTag  map[string][]int // positions, ascending
+	byTier map[string][]int // positions per rarity tier, ascending
+	byPct  []int            // positions sorted by GlobalPct
+
+	sources appListSources
+}
+
+// appListSources are the generations of the cache entries a per-app list
+// is merged from: its schema, the fallbackLang schema filling the
+// untranslated texts (0 when not used) and the percentages.
+type appListSources struct{ schema, ref, pct uint64 }
+
+func newAchievementIndex(o *achievementOverlay, appID AppID, lang string, items []Achievement) *achievementIndex {
+	o.applyTags(appID, items)
+	applyAccessibility(lang, items)
+
+	idx := &achievementIndex{
+		appID:  appID,
+		items:  items,
+		text:   make([]string, len(items)),
+		byTag:  make(map[string][]int),
+		byTier: make(map[string][]int, len(rarityTiers)),
+		byPct:  make([]int, len(items)),
+	}
+	for i, a := range items {
+		idx.text[i] = strings.ToLower(a.Name + "\n" + a.Description + "\n" + a.APIName)
+		for _, t := range a.Tags {
+			idx.byTag[t] = append(idx.byTag[t], i)
+		}
+		tier := rarityOf(a.GlobalPct)
+		idx.byTier[tier] = append(idx.byTier[tier], i)
+		idx.byPct[i] = i
+	}
+	sort.SliceStable(idx.byPct, func(i, j int) bool {
+		return items[idx.byPct[i]].GlobalPct < items[idx.byPct[j]].GlobalPct
+	})
+	return idx
+}
+
+// pctRange returns the positions with min <= GlobalPct <= max.
+func (idx *achievementIndex) pctRange(min, max *float64) []int {
+	lo, hi := 0, len(idx.byPct)
+	if min != nil {
+		lo = sort.Search(len(idx.byPct), func(i int) bool { return idx.items[idx.byPct[i]].GlobalPct >= *min })
+	}
+	if max != nil {
+		hi = sort.Search(len(idx.byPct), func(i int) bool { return idx.items[idx.byPct[i]].GlobalPct > *max })
+	}
+	if lo >= hi {
+		return nil
+	}
+	return idx.byPct[lo:hi]
+}
+
+// appAchievementIndex is appAchievementList indexed for the query
+// pipeline. The caches are read as appAchievementList does, for their TTL
+// and revalidation; a shared list then uses the index of its schema entry
+// while the entries it was merged from are unchanged. Isolated lists are
+// indexed per request.
+func (s *Server) appAchievementIndex(ctx context.Context, appID AppID, lang string) (*achievementIndex, error) {
+	if cacheNamespace(ctx) != "" {
+		items, err := s.appAchievementList(ctx, appID, lang)
+		if err != nil {
+			return nil, err
+		}
+		return newAchievementIndex(s.overlay, appID, lang, items), nil
+	}
+
+	schema, err := s.fetchSchemaForGameCached(ctx, appID, lang)
+	if err != nil {
+		return nil, err
+	}
+	if lang != fallbackLang && hasUntranslated(schema) {
+		if _, err := s.fetchSchemaForGameCached(ctx, appID, fallbackLang); err != nil {
+			log.Printf("%s fallback schema app %d: %v", fallbackLang, appID, err)
+		}
+	}
+	if _, err := s.fetchGlobalPercentagesCached(ctx, appID); err != nil {
+		log.Printf("%s global pct app %d: %v", s.sourceFor(appID).Name(), appID, err)
+	}
+
+	key := schemaCacheKey(ctx, appID, lang)
+	s.cacheMu.RLock()
+	idx := s.appSchemaCache[key].index
+	_, _, _, src, ok := s.appListPartsLocked(key)
+	s.cacheMu.RUnlock()
+	if ok && idx != nil && idx.sources == src {
+		return idx, nil
+	}
+	if idx := s.indexAppList(key); idx != nil {
+		return idx, nil
+	}
+	// Dropped from the cache meanwhile.
+	items, err := s.appAchievementList(ctx, appID, lang)
+	if err != nil {
+		return nil, err
+	}
+	return newAchievementIndex(s.overlay, appID, lang, items), nil
+}
+
+// achievementIndexFor is achievementList indexed, in the default language.
+func (s *Server) achievementIndexFor(ctx context.Context, appID AppID) (*achievementIndex, error) {
+	if appID == defaultGlobalAppID {
+		return s.globalAchievementIndex(ctx, defaultLang)
+	}
+	return s.appAchievementIndex(ctx, appID, defaultLang)
+}
+
+// appListPartsLocked returns the cached parts of the shared list of key, a
+// schema key, with their generations. ok is false when the schema is not
+// cached. The caller holds cacheMu.
+func (s *Server) appListPartsLocked(key CacheKey) (schema, ref []Achievement, pcts map[string]float64, src appListSources, ok bool) {
+	entry, ok := s.appSchemaCache[key]
+	if !ok {
+		return nil, nil, nil, src, false
+	}
+	schema, src.schema = entry.items, entry.generation
+	if key.Lang != fallbackLang && hasUntranslated(schema) {
+		r := s.appSchemaCache[CacheKey{Kind: cacheKindSchema, AppID: key.AppID, Lang: fallbackLang}]
+		ref, src.ref = r.items, r.generation
+	}
+	p := s.appGlobalPctMap[CacheKey{Kind: cacheKindGlobalPct, AppID: key.AppID}]
+	return schema, ref, p.items, appListSources{src.schema, src.ref, p.generation}, true
+}
+
+// indexAppList builds the index of the shared list of key from the cache
+// and keeps it in the schema entry, unless one of its parts was stored
+// again meanwhile. It returns nil when the schema is not cached.
+func (s *Server) indexAppList(key CacheKey) *achievementIndex {
+	s.cacheMu.RLock()
+	schema, ref, pcts, src, ok := s.appListPartsLocked(key)
+	s.cacheMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	items := make([]Achievement, len(schema))
+	copy(items, schema)
+	if ref != nil {
+		fillUntranslated(items, ref)
+	}
+	for i := range items {
+		items[i].GlobalPct = pcts[items[i].APIName]
+	}
+	idx := newAchievementIndex(s.overlay, key.AppID, key.Lang, items)
+	idx.sources = src
+
+	s.cacheMu.Lock()
+	if _, _, _, now, ok := s.appListPartsLocked(key); ok && now == src {
+		entry := s.appSchemaCache[key]
+		entry.index = idx
+		s.appSchemaCache[key] = entry
+	}
+	s.cacheMu.Unlock()
+	return idx
+}
+
+// indexAppLists rebuilds, after a store, the indexes of the shared lists
+// of appID that are missing or older than their cached parts. The default
+// game is listed from the database, see globalAchievementIndex.
+func (s *Server) indexAppLists(appID AppID) {
+	if appID == defaultGlobalAppID {
+		return
+	}
+	var keys []CacheKey
+	s.cacheMu.RLock()
+	for k, e := range s.appSchemaCache {
+		if k.AppID != appID || k.NS != "" {
+			continue
+		}
+		if _, _, _, src, _ := s.appListPartsLocked(k); e.index == nil || e.index.sources != src {
+			keys = append(keys, k)
+		}
+	}
+	s.cacheMu.RUnlock()
+	for _, k := range keys {
+		s.indexAppList(k)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyIndexRarity(t *testing.T) {
+	items := []Achievement{
+		{APIName: "COMMON", GlobalPct: 80},
+		{APIName: "UNCOMMON", GlobalPct: 20},
+		{APIName: "RARE", GlobalPct: 12},
+		{APIName: "ULTRA", GlobalPct: 0.4},
+	}
+	tests := map[string]string{
+		"rarity=rare":                      "RARE",
+		"rarity=ultrarare&rarity=uncommon": "UNCOMMON,ULTRA",
+		"rarity=common&rarity=common":      "COMMON",
+		"rarity=rare&maxPct=10":            "",
+		"rarity=ultraRare&q=ultra":         "ULTRA",
+	}
+	for query, want := range tests {
+		q, err := parseAchievementQuery(httptest.NewRequest(http.MethodGet, "/api/v1/achievements?"+query, nil))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		idx := newAchievementIndex(&achievementOverlay{}, 440, defaultLang, append([]Achievement(nil), items...))
+		got, _ := q.applyIndex(&achievementOverlay{}, nil, idx)
+		var names []string
+		for _, a := range got {
+			names = append(names, a.APIName)
+		}
+		if strings.Join(names, ",") != want {
+			t.Errorf("%s = %v, want %s", query, names, want)
+		}
+	}
+	if _, err := parseAchievementQuery(httptest.NewRequest(http.MethodGet, "/api/v1/achievements?rarity=legendary", nil)); err == nil {
+		t.Errorf("rarity=legendary accepted")
+	}
+}
+
+// The shared list keeps the index built when its parts were stored, and a
+// new store of any part replaces it.
+func TestAppIndexKeptInCacheEntry(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	key := schemaCacheKey(ctx, 440, defaultLang)
+	now := time.Now()
+	s.storeSchema(key, []Achievement{{APIName: "A", Name: "Facile", Description: "d"}, {APIName: "B"}}, now)
+	s.storeGlobalPercentages(globalPctCacheKey(ctx, 440), map[string]float64{"A": 80, "B": 2.5}, now)
+
+	s.cacheMu.RLock()
+	built := s.appSchemaCache[key].index
+	s.cacheMu.RUnlock()
+	if built == nil || built.items[1].GlobalPct != 2.5 {
+		t.Fatalf("no index with the percentages after the stores: %+v", built)
+	}
+	idx, err := s.appAchievementIndex(ctx, 440, defaultLang)
+	if err != nil || idx != built {
+		t.Fatalf("request got %p, %v; want the stored index %p", idx, err, built)
+	}
+
+	// The fallback schema fills B, the one without texts.
+	s.storeSchema(schemaCacheKey(ctx, 440, fallbackLang), []Achievement{{APIName: "A", Name: "Easy", Description: "d"}, {APIName: "B", Name: "Hard", Description: "h"}}, now)
+	s.storeGlobalPercentages(globalPctCacheKey(ctx, 440), map[string]float64{"A": 60, "B": 4}, now)
+	idx, err = s.appAchievementIndex(ctx, 440, defaultLang)
+	if err != nil || idx == built {
+		t.Fatalf("index not rebuilt after the stores: %v", err)
+	}
+	if idx.items[1].Name != "Hard" || idx.items[0].GlobalPct != 60 || idx.byTier[rarityUltraRare][0] != 1 {
+		t.Errorf("rebuilt index = %+v", idx.items)
+	}
+	if again, _ := s.appAchievementIndex(ctx, 440, defaultLang); again != idx {
+		t.Errorf("second request rebuilt the index")
+	}
+	s.cacheMu.RLock()
+	shared := s.appSchemaCache[key].items
+	s.cacheMu.RUnlock()
+	if shared[1].Name != "" || shared[1].GlobalPct != 0 {
+		t.Errorf("indexing wrote into the cached schema: %+v", shared[1])
+	}
+
+	// Isolated lists are indexed per request.
+	s.testMode = true
+	isoCtx, _ := isolationCtx(s, "run-1")
+	s.storeSchema(schemaCacheKey(isoCtx, 440, defaultLang), []Achievement{{APIName: "A", Name: "Facile", Description: "d"}}, now)
+	s.storeGlobalPercentages(globalPctCacheKey(isoCtx, 440), map[string]float64{"A": 1}, now)
+	first, err := s.appAchievementIndex(isoCtx, 440, defaultLang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := s.appAchievementIndex(isoCtx, 440, defaultLang); second == first {
+		t.Errorf("isolated index shared between requests")
+	}
+}
+
+// syntheticGame is a 5,000 achievement game with varied texts, tags and
+// percentages.
+func syntheticGame() (*achievementOverlay, []Achievement) {
+	o := &achievementOverlay{apps: map[AppID]map[string]overlayEntry{440: {}}}
+	tags := []string{"boss", "story", "collect", "pvp", "speedrun"}
+	items := make([]Achievement, 5000)
+	for i := range items {
+		name := fmt.Sprintf("ACH_%04d", i)
+		items[i] = Achievement{APIName: name, Name: fmt.Sprintf("Succes numero %d", i), Description: fmt.Sprintf("Vaincre %d ennemis du niveau %d", i*3, i%50), GlobalPct: float64((i*7919)%10000) / 100}
+		o.apps[440][name] = overlayEntry{Tags: []string{tags[i%len(tags)]}}
+	}
+	return o, items
+}
+
+// BenchmarkAchievementFilter compares indexing the list per request, as
+// the lists did before the index was kept in the cache entry, with
+// filtering the kept index.
+func BenchmarkAchievementFilter(b *testing.B) {
+	o, items := syntheticGame()
+	queries := map[string]string{
+		"search":  "q=numero+42",
+		"tag":     "tag=boss&tag=pvp",
+		"pct":     "minPct=1&maxPct=4.5",
+		"rarity":  "rarity=ultraRare",
+		"typical": "q=vaincre&tag=story&rarity=rare",
+	}
+	for name, raw := range queries {
+		q, err := parseAchievementQuery(httptest.NewRequest(http.MethodGet, "/api/v1/achievements?"+raw, nil))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name+"/per-request", func(b *testing.B) {
+			list := make([]Achievement, len(items))
+			for i := 0; i < b.N; i++ {
+				copy(list, items)
+				q.apply(o, nil, 440, defaultLang, list)
+			}
+		})
+		idx := newAchievementIndex(o, 440, defaultLang, append([]Achievement(nil), items...))
+		b.Run(name+"/cached", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				q.applyIndex(o, nil, idx)
+			}
+		})
+	}
+}
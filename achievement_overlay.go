@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Overlay file (data/achievement_overlay.json) adds curated metadata that
+// Steam does not provide, keyed by appId then apiName:
+//
+//	{
+//	  "apps": {
+//	    "105600": {
+//	      "EYE_ON_YOU": { "tags": ["boss", "pre-hardmode"] }
+//	    }
+//	  }
+//	}
+//
+// Tags are matched case-insensitively and stored lowercased. The file is
+// optional: without it no achievement carries tags.
+const defaultOverlayFile = "data/achievement_overlay.json"
+
+type overlayEntry struct {
+	Tags []string `json:"tags"`
+}
+
+type achievementOverlay struct {
+	apps map[AppID]map[string]overlayEntry
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+func loadAchievementOverlay(path string) (*achievementOverlay, error) {
+	o := &achievementOverlay{apps: make(map[AppID]map[string]overlayEntry)}
+	b, err := readDataFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Apps map[string]map[string]overlayEntry `json:"apps"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for key, entries := range raw.Apps {
+		appID, err := parseAppID(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: app key %q is not a positive integer", path, key)
+		}
+		clean := make(map[string]overlayEntry, len(entries))
+		for apiName, e := range entries {
+			clean[apiName] = overlayEntry{Tags: normalizeTags(e.Tags)}
+		}
+		o.apps[appID] = clean
+	}
+	return o, nil
+}
+
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// applyTags fills Tags on items from the overlay of appID. A nil overlay
+// has no tags.
+func (o *achievementOverlay) applyTags(appID AppID, items []Achievement) {
+	if o == nil {
+		return
+	}
+	entries := o.apps[appID]
+	if len(entries) == 0 {
+		return
+	}
+	for i := range items {
+		if e, ok := entries[items[i].APIName]; ok && len(e.Tags) > 0 {
+			items[i].Tags = e.Tags
+		}
+	}
+}
+
+// tagCounts lists every tag known for appID with its achievement count,
+// leaving out the achievements hidden by p. A nil overlay has none.
+func (o *achievementOverlay) tagCounts(appID AppID, p *appPolicy) []TagCount {
+	if o == nil {
+		return []TagCount{}
+	}
+	counts := make(map[string]int)
+	for apiName, e := range o.apps[appID] {
+		if p.hidden(apiName) {
+			continue
+		}
+		for _, t := range e.Tags {
+			counts[t]++
+		}
+	}
+	out := make([]TagCount, 0, len(counts))
+	for t, n := range counts {
+		out = append(out, TagCount{Tag: t, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Tag < out[j].Tag })
+	return out
+}
+
+func (o *achievementOverlay) hasTag(appID AppID, tag string) bool {
+	if o == nil {
+		return false
+	}
+	for _, e := range o.apps[appID] {
+		for _, t := range e.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
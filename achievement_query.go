@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tagModeAny = "any"
+	tagModeAll = "all"
+)
+
+// Payload profiles. "lite" is picked by ?profile=lite or a Save-Data: on
+// request header; ?profile=full overrides Save-Data.
+const (
+	profileFull = "full"
+	profileLite = "lite"
+)
+
+// LiteAchievement is the lite projection: enough to draw a list, while
+// descriptions need a follow-up fetch of the full profile.
+type LiteAchievement struct {
+	APIName    string          `json:"apiName"`
+	Name       string          `json:"name"`
+	GlobalPct  float64         `json:"globalPct"`
+	Icon       string          `json:"icon"`
+	IconAlt    string          `json:"iconAlt"`
+	Rarity     string          `json:"rarity"`
+	Achieved   bool            `json:"achieved,omitempty"`
+	UnlockTime apiTime         `json:"unlockTime"`
+	Redacted   bool            `json:"redacted,omitempty"`
+	Color      json.RawMessage `json:"color,omitempty"`
+}
+
+// achievementQuery holds the list filters shared by the achievement
+// endpoints: ?q= (name/description search), ?minPct=/?maxPct= and
+// ?tag= (repeatable) with ?tagMode=any|all, ?rarity= (repeatable, any of
+// the tiers), ?hidden=, plus the payload profile and the order
+// (?sort=/?order=, see achievement_sort.go).
+type achievementQuery struct {
+	search  string
+	minPct  *float64
+	maxPct  *float64
+	tags    []string
+	tagMode string
+	rarity  []string
+	hidden  string
+	order   achievementOrder
+	profile string
+	// baseline is the population of GlobalPct, see pct_baseline.go.
+	baseline string
+	// lang, format and charsets are only read by /achievements (format and
+	// charsets also by the player lists), see languages.go,
+	// achievement_export.go and charset.go.
+	lang     string
+	format   string
+	charsets []string
+	// numLocale is ?numlocale=, "" to follow lang; see number_format.go.
+	numLocale string
+	// tz is ?tz=, the zone of the dates of the exports; see date_format.go.
+	tz *time.Location
+	// unlocks is set on a player's list, exported with their columns.
+	unlocks bool
+
+	normalized []string
+}
+
+func parseAchievementQuery(r *http.Request) (achievementQuery, error) {
+	v := r.URL.Query()
+	q := achievementQuery{
+		search:  strings.ToLower(strings.TrimSpace(v.Get("q"))),
+		tags:    normalizeTags(v["tag"]),
+		tagMode: tagModeAny,
+		profile: profileFull,
+		lang:    defaultLang,
+	}
+
+	profile, err := profileParam.fromQuery(v, "", &q.normalized)
+	if err != nil {
+		return q, err
+	}
+	if profile != "" {
+		q.profile = profile
+	} else if strings.EqualFold(strings.TrimSpace(r.Header.Get("Save-Data")), "on") {
+		q.profile = profileLite
+	}
+	if q.tagMode, err = tagModeParam.fromQuery(v, tagModeAny, &q.normalized); err != nil {
+		return q, err
+	}
+	if q.baseline, err = baselineParam.fromQuery(v, baselinePlayers, &q.normalized); err != nil {
+		return q, err
+	}
+	if q.hidden, err = hiddenParam.fromQuery(v, hiddenInclude, &q.normalized); err != nil {
+		return q, err
+	}
+	if q.numLocale, err = numLocaleParam.fromQuery(v, "", &q.normalized); err != nil {
+		return q, err
+	}
+	for _, raw := range v["rarity"] {
+		tier, err := rarityParam.normalize(raw)
+		if err != nil {
+			return q, err
+		}
+		if tier != "" && !slices.Contains(q.rarity, tier) {
+			q.rarity = append(q.rarity, tier)
+			q.normalized = append(q.normalized, rarityParam.name+"="+tier)
+		}
+	}
+	if q.tz, err = timeZoneFor(r); err != nil {
+		return q, err
+	}
+	key, err := sortParam.fromQuery(v, sortPct, &q.normalized)
+	if err != nil {
+		return q, err
+	}
+	dir, err := orderParam.fromQuery(v, "", &q.normalized)
+	if err != nil {
+		return q, err
+	}
+	q.order = newAchievementOrder(key, dir)
+
+	for _, bound := range []struct {
+		name string
+		dst  **float64
+	}{{"minPct", &q.minPct}, {"maxPct", &q.maxPct}} {
+		raw := strings.TrimSpace(v.Get(bound.name))
+		if raw == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || f < 0 || f > 100 {
+			return q, fmt.Errorf("%s must be a number between 0 and 100", bound.name)
+		}
+		*bound.dst = &f
+	}
+	if q.minPct != nil && q.maxPct != nil && *q.minPct > *q.maxPct {
+		return q, fmt.Errorf("minPct must not exceed maxPct")
+	}
+	return q, nil
+}
+
+// apply tags items from the overlay, then filters them. Requested tags that
+// no achievement of appID carries are returned as unknown; they match
+// nothing.
+func (q achievementQuery) apply(o *achievementOverlay, p *appPolicy, appID AppID, lang string, items []Achievement) ([]Achievement, []string) {
+	return q.applyIndex(o, p, newAchievementIndex(o, appID, lang, items))
+}
+
+// applyIndex filters an indexed list. Each active filter (percentage range,
+// rarity tiers, tags) votes for the positions it keeps; a position is kept when every
+// filter voted for it and the search text matches. The visibility policy p
+// comes first: hidden positions are never kept and redacted ones are
+// returned redacted, matching no search.
+func (q achievementQuery) applyIndex(o *achievementOverlay, p *appPolicy, idx *achievementIndex) ([]Achievement, []string) {
+	unknown := make([]string, 0)
+	for _, t := range q.tags {
+		if len(idx.byTag[t]) == 0 && !o.hasTag(idx.appID, t) {
+			unknown = append(unknown, t)
+		}
+	}
+
+	n := len(idx.items)
+	votes := make([]int, n)
+	need := 0
+	if q.minPct != nil || q.maxPct != nil {
+		need++
+		for _, i := range idx.pctRange(q.minPct, q.maxPct) {
+			votes[i]++
+		}
+	}
+	if len(q.rarity) > 0 {
+		need++
+		for _, t := range q.rarity {
+			for _, i := range idx.byTier[t] {
+				votes[i]++
+			}
+		}
+	}
+	if len(q.tags) > 0 {
+		need++
+		tagHits := make([]int, n)
+		for _, t := range q.tags {
+			for _, i := range idx.byTag[t] {
+				tagHits[i]++
+			}
+		}
+		for i, h := range tagHits {
+			if (q.tagMode == tagModeAny && h > 0) || h == len(q.tags) {
+				votes[i]++
+			}
+		}
+	}
+
+	out := make([]Achievement, 0)
+	for i, v := range votes {
+		a := idx.items[i]
+		if v < need || p.hidden(a.APIName) || !keepHidden(q.hidden, a.Hidden) {
+			continue
+		}
+		redacted := p.redacted(a.APIName)
+		if q.search != "" && (redacted || !strings.Contains(idx.text[i], q.search)) {
+			continue
+		}
+		if redacted {
+			redactAchievement(&a)
+		}
+		out = append(out, a)
+	}
+	return out, unknown
+}
+
+// project shapes the filtered items for the payload profile.
+func (q achievementQuery) project(items []Achievement) any {
+	applyRarity(items)
+	if q.profile != profileLite {
+		return items
+	}
+	out := make([]LiteAchievement, len(items))
+	for i, a := range items {
+		out[i] = LiteAchievement{APIName: a.APIName, Name: a.Name, GlobalPct: a.GlobalPct, Icon: a.Icon, IconAlt: a.IconAlt, Rarity: a.Rarity, Achieved: a.Achieved, UnlockTime: a.UnlockTime, Redacted: a.Redacted, Color: a.Color}
+	}
+	return out
+}
+
+// write sends v (built from q.project) in the format of the profile.
+func (q achievementQuery) write(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Add("Vary", "Save-Data")
+	w.Header().Set("X-Payload-Profile", q.profile)
+	if q.profile == profileLite {
+		writeCompactJSON(w, r, v)
+		return
+	}
+	writeJSON(w, v)
+}
+
+// writeDebug reports how enum values were understood and the filters that
+// could not match anything.
+func (q achievementQuery) writeDebug(w http.ResponseWriter, unknownTags []string) {
+	writeNormalizedParams(w, q.normalized)
+	if len(unknownTags) > 0 {
+		w.Header().Set("X-Unknown-Tags", strings.Join(unknownTags, ","))
+	}
+}
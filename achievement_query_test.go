@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPayloadProfileNegotiation(t *testing.T) {
+	tests := []struct {
+		query, saveData, want string
+	}{
+		{"", "", profileFull},
+		{"", "on", profileLite},
+		{"", " ON ", profileLite},
+		{"", "off", profileFull},
+		{"profile=lite", "", profileLite},
+		{"profile=LITE", "off", profileLite},
+		{"profile=full", "on", profileFull},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements?"+tt.query, nil)
+		if tt.saveData != "" {
+			r.Header.Set("Save-Data", tt.saveData)
+		}
+		q, err := parseAchievementQuery(r)
+		if err != nil || q.profile != tt.want {
+			t.Errorf("?%s with Save-Data %q = %q, %v; want %s", tt.query, tt.saveData, q.profile, err, tt.want)
+		}
+	}
+	if _, err := parseAchievementQuery(httptest.NewRequest(http.MethodGet, "/api/v1/achievements?profile=tiny", nil)); err == nil {
+		t.Errorf("profile=tiny accepted")
+	}
+}
+
+func liteFixture() []Achievement {
+	return []Achievement{
+		{APIName: "A", Name: "Facile", Description: "Tuer un slime", Icon: "https://cdn/a.jpg", IconGray: "https://cdn/a_gray.jpg", IconAlt: "Icone de Facile", GlobalPct: 80},
+		{APIName: "B", Name: "Dur", Description: "Vaincre le boss", Icon: "https://cdn/b.jpg", Hidden: true, GlobalPct: 2.5, Achieved: true, UnlockTime: apiTime(1700000000)},
+	}
+}
+
+func TestProjectLite(t *testing.T) {
+	q := achievementQuery{profile: profileLite}
+	b, err := json.Marshal(q.project(liteFixture()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	json.Unmarshal(b, &got)
+	wantKeys := [][]string{
+		{"apiName", "globalPct", "icon", "iconAlt", "name", "rarity", "unlockTime"},
+		{"achieved", "apiName", "globalPct", "icon", "iconAlt", "name", "rarity", "unlockTime"},
+	}
+	for i, a := range got {
+		var keys []string
+		for k := range a {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, wantKeys[i]) {
+			t.Errorf("lite item %d keys = %v, want %v", i, keys, wantKeys[i])
+		}
+	}
+	if got[0]["rarity"] != rarityCommon || got[0]["unlockTime"] != nil || got[1]["rarity"] != rarityUltraRare || got[1]["icon"] != "https://cdn/b.jpg" {
+		t.Errorf("lite items = %v", got)
+	}
+
+	// The full profile keeps the whole achievement, rarity included.
+	full, _ := json.Marshal(achievementQuery{profile: profileFull}.project(liteFixture()))
+	for _, want := range []string{`"description":"Tuer un slime"`, `"iconGray":"https://cdn/a_gray.jpg"`, `"rarity":"common"`} {
+		if !strings.Contains(string(full), want) {
+			t.Errorf("%s missing from the full profile %s", want, full)
+		}
+	}
+}
+
+func writeProfile(t *testing.T, profile, acceptEncoding string, items []Achievement) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	q := achievementQuery{profile: profile}
+	w := httptest.NewRecorder()
+	q.write(w, r, q.project(items))
+	return w
+}
+
+func TestWriteProfiles(t *testing.T) {
+	gz := writeProfile(t, profileLite, "gzip, deflate", liteFixture())
+	h := gz.Header()
+	if h.Get("X-Payload-Profile") != profileLite || h.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("lite headers = %v", h)
+	}
+	if vary := strings.Join(h.Values("Vary"), ","); !strings.Contains(vary, "Save-Data") || !strings.Contains(vary, "Accept-Encoding") {
+		t.Errorf("Vary = %q, want Save-Data and Accept-Encoding", vary)
+	}
+	if h.Get("Content-Length") != strconv.Itoa(gz.Body.Len()) || h.Get("ETag") == "" {
+		t.Errorf("Content-Length %q for %d bytes, ETag %q", h.Get("Content-Length"), gz.Body.Len(), h.Get("ETag"))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(gz.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	var compact bytes.Buffer
+	json.Compact(&compact, body)
+	if !bytes.Equal(bytes.TrimSpace(body), compact.Bytes()) {
+		t.Errorf("lite body is not compact JSON: %s", body)
+	}
+
+	plain := writeProfile(t, profileLite, "gzip;q=0", liteFixture())
+	if plain.Header().Get("Content-Encoding") != "" || !bytes.Equal(plain.Body.Bytes(), body) {
+		t.Errorf("lite without gzip: encoding %q, body %s", plain.Header().Get("Content-Encoding"), plain.Body)
+	}
+
+	full := writeProfile(t, profileFull, "gzip", liteFixture())
+	if full.Header().Get("X-Payload-Profile") != profileFull || full.Header().Get("Content-Encoding") != "" || !strings.Contains(full.Body.String(), "\n  ") {
+		t.Errorf("full profile: headers %v", full.Header())
+	}
+	if !slices.Contains(full.Header().Values("Vary"), "Save-Data") {
+		t.Errorf("full profile Vary = %v, want Save-Data", full.Header().Values("Vary"))
+	}
+}
+
+// On a big game, the gzipped lite payload is a fraction of the full one.
+func TestLitePayloadSize(t *testing.T) {
+	_, items := syntheticGame()
+	full := writeProfile(t, profileFull, "", append([]Achievement(nil), items...)).Body.Len()
+	lite := writeProfile(t, profileLite, "gzip", append([]Achievement(nil), items...)).Body.Len()
+	if lite*5 > full {
+		t.Errorf("lite gzip %d bytes, full %d: less than 80%% saved", lite, full)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "sort"
+
+// ?sort=pct|name|rarity with ?order=asc|desc orders achievement lists.
+// rarity is the reverse of pct, so its default (desc) lists the rarest
+// first; pct defaults to desc and name to asc. Ties are broken by name and
+// apiName so the order is total, which cursors rely on.
+const (
+	sortPct    = "pct"
+	sortName   = "name"
+	sortRarity = "rarity"
+
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// ?hidden= keeps every achievement (true or include, the default), drops
+// the hidden ones (false or exclude) or keeps only them (only).
+const (
+	hiddenInclude = "true"
+	hiddenExclude = "false"
+	hiddenOnly    = "only"
+)
+
+var (
+	sortParam   = enumParam{name: "sort", accepted: []string{sortPct, sortName, sortRarity}, synonyms: map[string]string{"globalpct": sortPct, "percent": sortPct, "rare": sortRarity}}
+	orderParam  = enumParam{name: "order", accepted: []string{orderAsc, orderDesc}, synonyms: map[string]string{"ascending": orderAsc, "descending": orderDesc}}
+	hiddenParam = enumParam{name: "hidden", accepted: []string{hiddenInclude, hiddenExclude, hiddenOnly}, synonyms: withSynonyms(boolSynonyms, map[string]string{"include": hiddenInclude, "exclude": hiddenExclude})}
+)
+
+type achievementOrder struct {
+	key string
+	dir string
+}
+
+func newAchievementOrder(key, dir string) achievementOrder {
+	if dir == "" {
+		dir = orderDesc
+		if key == sortName {
+			dir = orderAsc
+		}
+	}
+	return achievementOrder{key: key, dir: dir}
+}
+
+// spec names the order in cursors, e.g. "globalPct:desc,name:asc,apiName:asc".
+func (o achievementOrder) spec() string {
+	if o.key == sortName {
+		return "name:" + o.dir + ",apiName:asc"
+	}
+	pct := orderDesc
+	if (o.key == sortPct) != (o.dir == orderDesc) {
+		pct = orderAsc
+	}
+	return "globalPct:" + pct + ",name:asc,apiName:asc"
+}
+
+func (o achievementOrder) less(a, b Achievement) bool {
+	if o.key == sortName {
+		if a.Name != b.Name {
+			return (a.Name < b.Name) == (o.dir == orderAsc)
+		}
+		return a.APIName < b.APIName
+	}
+	if a.GlobalPct != b.GlobalPct {
+		mostFirst := (o.key == sortPct) == (o.dir == orderDesc)
+		return (a.GlobalPct > b.GlobalPct) == mostFirst
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.APIName < b.APIName
+}
+
+func (o achievementOrder) sort(items []Achievement) {
+	sort.Slice(items, func(i, j int) bool { return o.less(items[i], items[j]) })
+}
+
+func keepHidden(mode string, hidden bool) bool {
+	switch mode {
+	case hiddenExclude:
+		return !hidden
+	case hiddenOnly:
+		return hidden
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// Rarity tiers of the global percentage, computed on output so that every
+// front end draws the same lines: common above 50%, uncommon from 20%, rare
+// from 5%, ultraRare below.
+const (
+	rarityCommon    = "common"
+	rarityUncommon  = "uncommon"
+	rarityRare      = "rare"
+	rarityUltraRare = "ultraRare"
+)
+
+// rarityTiers lists the tiers from the most common, with their bounds.
+var rarityTiers = []struct {
+	name           string
+	minPct, maxPct float64
+}{
+	{rarityCommon, 50, 100},
+	{rarityUncommon, 20, 50},
+	{rarityRare, 5, 20},
+	{rarityUltraRare, 0, 5},
+}
+
+// statsTopN is how many achievements the rarest and mostCommon lists hold.
+const statsTopN = 5
+
+func rarityOf(pct float64) string {
+	switch {
+	case pct > 50:
+		return rarityCommon
+	case pct >= 20:
+		return rarityUncommon
+	case pct >= 5:
+		return rarityRare
+	}
+	return rarityUltraRare
+}
+
+// applyRarity fills Rarity on items, after any rescaling of GlobalPct.
+func applyRarity(items []Achievement) {
+	for i := range items {
+		items[i].Rarity = rarityOf(items[i].GlobalPct)
+	}
+}
+
+type rarityTierCount struct {
+	Tier   string  `json:"tier"`
+	MinPct float64 `json:"minPct"`
+	MaxPct float64 `json:"maxPct"`
+	Count  int     `json:"count"`
+}
+
+type achievementStatsEntry struct {
+	APIName   string  `json:"apiName"`
+	Name      string  `json:"name"`
+	GlobalPct float64 `json:"globalPct"`
+	Rarity    string  `json:"rarity"`
+	Redacted  bool    `json:"redacted,omitempty"`
+}
+
+// AchievementStats summarizes a list: the "stats" bootstrap component and
+// /achievements/stats, which adds AppID.
+type AchievementStats struct {
+	AppID           AppID                   `json:"appId,omitempty"`
+	Total           int                     `json:"total"`
+	Hidden          int                     `json:"hidden"`
+	MeanGlobalPct   float64                 `json:"meanGlobalPct"`
+	MedianGlobalPct float64                 `json:"medianGlobalPct"`
+	Tiers           []rarityTierCount       `json:"tiers"`
+	Rarest          []achievementStatsEntry `json:"rarest"`
+	MostCommon      []achievementStatsEntry `json:"mostCommon"`
+}
+
+func computeAchievementStats(items []Achievement) AchievementStats {
+	st := AchievementStats{Total: len(items), Tiers: make([]rarityTierCount, len(rarityTiers)),
+		Rarest: []achievementStatsEntry{}, MostCommon: []achievementStatsEntry{}}
+	for i, t := range rarityTiers {
+		st.Tiers[i] = rarityTierCount{Tier: t.name, MinPct: t.minPct, MaxPct: t.maxPct}
+	}
+	if len(items) == 0 {
+		return st
+	}
+
+	sorted := append([]Achievement(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].GlobalPct != sorted[j].GlobalPct {
+			return sorted[i].GlobalPct < sorted[j].GlobalPct
+		}
+		return sorted[i].APIName < sorted[j].APIName
+	})
+	sum := 0.0
+	for _, a := range sorted {
+		if a.Hidden {
+			st.Hidden++
+		}
+		sum += a.GlobalPct
+		tier := rarityOf(a.GlobalPct)
+		for i := range st.Tiers {
+			if st.Tiers[i].Tier == tier {
+				st.Tiers[i].Count++
+			}
+		}
+	}
+
+	n := len(sorted)
+	st.MeanGlobalPct = sum / float64(n)
+	mid := n / 2
+	if n%2 == 0 {
+		st.MedianGlobalPct = (sorted[mid-1].GlobalPct + sorted[mid].GlobalPct) / 2
+	} else {
+		st.MedianGlobalPct = sorted[mid].GlobalPct
+	}
+	entry := func(a Achievement) achievementStatsEntry {
+		return achievementStatsEntry{APIName: a.APIName, Name: a.Name, GlobalPct: a.GlobalPct, Rarity: rarityOf(a.GlobalPct), Redacted: a.Redacted}
+	}
+	for i := 0; i < n && i < statsTopN; i++ {
+		st.Rarest = append(st.Rarest, entry(sorted[i]))
+		st.MostCommon = append(st.MostCommon, entry(sorted[n-1-i]))
+	}
+	return st
+}
+
+// handleAchievementStats summarizes the list /achievements?appId= would
+// return, with the same filters and ?baseline=.
+func (s *Server) handleAchievementStats(w http.ResponseWriter, r *http.Request) {
+	query, err := parseAchievementQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	appID := defaultGlobalAppID
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		if appID, err = parseAppID(raw); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
+		}
+	}
+	if !s.appAllowed(appID) {
+		writeAppNotAllowed(w, appID)
+		return
+	}
+	factor, ok := s.percentageBaseline(w, appID, &query)
+	if !ok {
+		return
+	}
+	setSurrogateKeys(w, appSurrogateKey(appID))
+
+	ctx, stale := withStaleTracking(r.Context())
+	idx, err := s.achievementIndexFor(ctx, appID)
+	if errors.Is(err, apperr.ErrReadOnly) {
+		writeReadOnly(w)
+		return
+	}
+	if err != nil {
+		writeSyncError(w, err, fmt.Sprintf("achievement stats, appID=%d", appID))
+		return
+	}
+	if len(idx.items) == 0 && appID != defaultGlobalAppID {
+		writeNoAchievements(w, appID, s.emptySchemaStatus(r.Context(), appID))
+		return
+	}
+	items, unknownTags := query.applyIndex(s.overlay, s.policyFor(w, appID), idx)
+	rescaleGlobalPct(items, factor)
+	query.writeDebug(w, unknownTags)
+	switch {
+	case stale.Load():
+		writeStaleHeaders(w)
+		w.Header().Set("Cache-Control", "no-cache")
+	case appID == defaultGlobalAppID:
+		w.Header().Set("Cache-Control", cacheControlMaxAge(time.Until(s.globalListExpiry(r.Context()))))
+	}
+	st := computeAchievementStats(items)
+	st.AppID = appID
+	writeJSON(w, st)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ?format=xlsx writes the CSV columns as a one-sheet workbook, by hand:
+// the handful of parts Excel and LibreOffice need, strings inline rather
+// than in a shared table, unlock times as real dates (style 1) and
+// percentages as numbers with two decimals (style 2), which the
+// spreadsheet shows with its own decimal separator.
+const formatXLSX = "xlsx"
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+var xlsxStaticParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="2" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs></styleSheet>`},
+}
+
+type xlsxRow struct{ cells []string }
+
+func (r *xlsxRow) str(v string) {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(v))
+	r.cells = append(r.cells, `<c t="inlineStr"><is><t xml:space="preserve">`+b.String()+`</t></is></c>`)
+}
+
+func (r *xlsxRow) pct(v float64) {
+	r.cells = append(r.cells, `<c s="2"><v>`+strconv.FormatFloat(v, 'f', -1, 64)+`</v></c>`)
+}
+
+func (r *xlsxRow) boolean(v bool) {
+	n := "0"
+	if v {
+		n = "1"
+	}
+	r.cells = append(r.cells, `<c t="b"><v>`+n+`</v></c>`)
+}
+
+// date writes t as an Excel serial day, UTC; an unknown time is left empty.
+func (r *xlsxRow) date(t apiTime) {
+	if !t.Known() {
+		r.cells = append(r.cells, `<c/>`)
+		return
+	}
+	serial := float64(t)/86400 + 25569
+	r.cells = append(r.cells, `<c s="1"><v>`+strconv.FormatFloat(serial, 'f', 6, 64)+`</v></c>`)
+}
+
+func encodeAchievementsXLSX(w io.Writer, v any) error {
+	e := v.(achievementExport)
+	header := xlsxRow{}
+	for _, h := range e.columns() {
+		header.str(h)
+	}
+	rows := []xlsxRow{header}
+	for _, a := range e.Items {
+		row := xlsxRow{}
+		row.str(a.APIName)
+		row.str(a.Name)
+		row.str(a.Description)
+		row.boolean(a.Hidden)
+		row.pct(a.GlobalPct)
+		if e.Unlocks {
+			row.boolean(a.Achieved)
+			row.date(a.UnlockTime)
+		}
+		rows = append(rows, row)
+	}
+
+	zw := zip.NewWriter(w)
+	for _, p := range xlsxStaticParts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return err
+		}
+	}
+	f, err := zw.Create("xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="App %d" sheetId="1" r:id="rId1"/></sheets></workbook>`, e.AppID)
+	if f, err = zw.Create("xl/worksheets/sheet1.xml"); err != nil {
+		return err
+	}
+	io.WriteString(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" state="frozen"/></sheetView></sheetViews><sheetData>`)
+	for _, row := range rows {
+		io.WriteString(f, "<row>"+strings.Join(row.cells, "")+"</row>")
+	}
+	io.WriteString(f, `</sheetData></worksheet>`)
+	return zw.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requireAdmin gates a handler behind ADMIN_TOKEN (Bearer or X-Admin-Token).
+// Admin routes answer 404 when no token is configured.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !s.isAdminRequest(r) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Jeton admin manquant ou invalide")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) isAdminRequest(r *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	token := strings.TrimSpace(r.Header.Get("X-Admin-Token"))
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+type adminConfigResponse struct {
+	Effective  runtimeConfigView `json:"effective"`
+	Startup    runtimeConfigView `json:"startup"`
+	Overridden []string          `json:"overridden"`
+	Persisted  bool              `json:"persisted"`
+	Note       string            `json:"note"`
+}
+
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, s.adminConfigSnapshot())
+	case http.MethodPatch:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		var patch map[string]any
+		if err := json.Unmarshal(body, &patch); err != nil || len(patch) == 0 {
+			writeError(w, http.StatusBadRequest, "invalid_body", "Corps JSON attendu, ex: {\"cacheTtl\":\"2h\"}")
+			return
+		}
+
+		// CompareAndSwap so two concurrent PATCHes cannot lose each other's keys.
+		for {
+			cur := s.runtime.Load()
+			next, err := cur.withPatch(patch)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_setting", err.Error())
+				return
+			}
+			if s.runtime.CompareAndSwap(cur, next) {
+				break
+			}
+		}
+		s.bumpGeneration(generationConfig)
+		shapeChanges.tick()
+		log.Printf("admin: runtime config updated: %s", strings.TrimSpace(string(body)))
+		writeJSON(w, s.adminConfigSnapshot())
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET or PATCH only")
+	}
+}
+
+func (s *Server) adminConfigSnapshot() adminConfigResponse {
+	eff := s.cfg().view()
+	start := s.startupConfig.view()
+
+	overridden := make([]string, 0)
+	if eff.CacheTTL != start.CacheTTL {
+		overridden = append(overridden, "cacheTtl")
+	}
+	if eff.AppMetaCacheTTL != start.AppMetaCacheTTL {
+		overridden = append(overridden, "appMetaCacheTtl")
+	}
+	if eff.CORSAllowOrigin != start.CORSAllowOrigin {
+		overridden = append(overridden, "corsAllowOrigin")
+	}
+	if eff.LogLevel != start.LogLevel {
+		overridden = append(overridden, "logLevel")
+	}
+	if eff.ReadOnly != start.ReadOnly {
+		overridden = append(overridden, "readOnly")
+	}
+	if eff.RateLimitRPS != start.RateLimitRPS {
+		overridden = append(overridden, "rateLimitRps")
+	}
+	if eff.RateLimitBurst != start.RateLimitBurst {
+		overridden = append(overridden, "rateLimitBurst")
+	}
+	if eff.StaleWhileRevalidate != start.StaleWhileRevalidate {
+		overridden = append(overridden, "staleWhileRevalidate")
+	}
+
+	return adminConfigResponse{
+		Effective:  eff,
+		Startup:    start,
+		Overridden: overridden,
+		Persisted:  false,
+		Note:       "Runtime changes are kept in memory only and reset on restart",
+	}
+}
+
+type adminCacheEntry struct {
+	Key        string    `json:"key"`
+	Kind       string    `json:"kind"`
+	AppID      AppID     `json:"appId"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Items      int       `json:"items"`
+	Generation uint64    `json:"generation,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	FetchedAt  time.Time `json:"fetchedAt"`
+	AgeSeconds int64     `json:"ageSeconds"`
+	// Notes are the admin notes of the game, see admin_notes.go.
+	Notes []adminNote `json:"notes,omitempty"`
+}
+
+func (s *Server) handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	out := make([]adminCacheEntry, 0)
+
+	s.cacheMu.RLock()
+	for k, e := range s.appSchemaCache {
+		out = append(out, adminCacheEntry{Key: k.String(), Kind: "schema", AppID: k.AppID, Namespace: k.NS, Items: len(e.items), Generation: e.generation, FetchedAt: e.fetchedAt})
+	}
+	for k, e := range s.appGlobalPctMap {
+		out = append(out, adminCacheEntry{Key: k.String(), Kind: "global_pct", AppID: k.AppID, Namespace: k.NS, Items: len(e.items), Generation: e.generation, FetchedAt: e.fetchedAt})
+	}
+	for k, e := range s.appStatusCache {
+		out = append(out, adminCacheEntry{Key: k.String(), Kind: "status", AppID: k.AppID, Status: e.status, FetchedAt: e.fetchedAt})
+	}
+	s.cacheMu.RUnlock()
+
+	notes, err := s.gameNotes()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	for i := range out {
+		out[i].AgeSeconds = int64(now.Sub(out[i].FetchedAt).Seconds())
+		out[i].Notes = notes[out[i].AppID]
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		if out[i].AppID != out[j].AppID {
+			return out[i].AppID < out[j].AppID
+		}
+		return out[i].Key < out[j].Key
+	})
+
+	writeJSON(w, out)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Admin notes are short internal remarks ("pic le 2024-12-19: week-end
+// gratuit") attached to a game (id: appId) or an achievement (id:
+// appId:apiName, or apiName alone for the default app). They live in the
+// database and are only served by admin routes and the admin cache view,
+// never by a public response.
+const (
+	noteScopeGame        = "game"
+	noteScopeAchievement = "achievement"
+
+	maxNoteChars       = 2000
+	maxNoteAuthorChars = 64
+	maxNotesPerObject  = 20
+	defaultNoteAuthor  = "admin"
+)
+
+var noteScopeParam = enumParam{name: "scope", accepted: []string{noteScopeGame, noteScopeAchievement}, synonyms: map[string]string{"app": noteScopeGame, "games": noteScopeGame, "achievements": noteScopeAchievement}}
+
+type adminNote struct {
+	ID        int64     `json:"id"`
+	Scope     string    `json:"scope"`
+	ObjectID  string    `json:"objectId"`
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type adminNotesResponse struct {
+	Scope    string      `json:"scope"`
+	ObjectID string      `json:"objectId"`
+	Notes    []adminNote `json:"notes"`
+}
+
+// noteObjectID validates the {id} of scope and returns its canonical form.
+func noteObjectID(scope, raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if scope == noteScopeGame {
+		appID, err := parseAppID(raw)
+		if err != nil {
+			return "", err
+		}
+		return appID.String(), nil
+	}
+	appID := defaultGlobalAppID
+	apiName := raw
+	if app, name, ok := strings.Cut(raw, ":"); ok {
+		v, err := parseAppID(app)
+		if err != nil {
+			return "", err
+		}
+		appID, apiName = v, name
+	}
+	if apiName == "" || len(apiName) > 128 {
+		return "", fmt.Errorf("achievement id must be appId:apiName or apiName")
+	}
+	return appID.String() + ":" + apiName, nil
+}
+
+func scanNotes(rows *sql.Rows) ([]adminNote, error) {
+	defer rows.Close()
+	out := make([]adminNote, 0)
+	for rows.Next() {
+		var n adminNote
+		var created, updated int64
+		if err := rows.Scan(&n.ID, &n.Scope, &n.ObjectID, &n.Author, &n.Text, &created, &updated); err != nil {
+			return nil, err
+		}
+		n.CreatedAt, n.UpdatedAt = time.Unix(created, 0).UTC(), time.Unix(updated, 0).UTC()
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+const noteColumns = `id, scope, object_id, author, body, created_at, updated_at`
+
+func (s *Server) notesFor(scope, objectID string) ([]adminNote, error) {
+	rows, err := s.db.Query(`SELECT `+noteColumns+` FROM admin_notes WHERE scope=? AND object_id=? ORDER BY id`, scope, objectID)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotes(rows)
+}
+
+// notesByScope lists the notes of scope ("" for every scope) by object.
+func (s *Server) notesByScope(scope string) ([]adminNote, error) {
+	rows, err := s.db.Query(`SELECT `+noteColumns+` FROM admin_notes WHERE ?='' OR scope=? ORDER BY scope, object_id, id`, scope, scope)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotes(rows)
+}
+
+// gameNotes groups the game notes by app, for the admin views.
+func (s *Server) gameNotes() (map[AppID][]adminNote, error) {
+	notes, err := s.notesByScope(noteScopeGame)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[AppID][]adminNote)
+	for _, n := range notes {
+		if appID, err := parseAppID(n.ObjectID); err == nil {
+			out[appID] = append(out[appID], n)
+		}
+	}
+	return out, nil
+}
+
+func (s *Server) handleAdminNotes(w http.ResponseWriter, r *http.Request) {
+	var notes []string
+	scope, err := noteScopeParam.fromQuery(r.URL.Query(), "", &notes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	list, err := s.notesByScope(scope)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	writeNormalizedParams(w, notes)
+	writeJSON(w, list)
+}
+
+type noteInput struct {
+	Text   string `json:"text"`
+	Author string `json:"author"`
+	// NoteID edits that note instead of adding one.
+	NoteID int64 `json:"noteId"`
+}
+
+func (in *noteInput) validate() error {
+	in.Text = strings.TrimSpace(in.Text)
+	in.Author = strings.TrimSpace(in.Author)
+	if in.Author == "" {
+		in.Author = defaultNoteAuthor
+	}
+	if in.Text == "" {
+		return fmt.Errorf("text est obligatoire")
+	}
+	if utf8.RuneCountInString(in.Text) > maxNoteChars {
+		return fmt.Errorf("text depasse %d caracteres", maxNoteChars)
+	}
+	if utf8.RuneCountInString(in.Author) > maxNoteAuthorChars {
+		return fmt.Errorf("author depasse %d caracteres", maxNoteAuthorChars)
+	}
+	return nil
+}
+
+// handleAdminObjectNotes serves GET, PUT and DELETE on the notes of one
+// game or achievement. PUT adds a note, or edits noteId; DELETE removes
+// ?noteId= or, without it, every note of the object.
+func (s *Server) handleAdminObjectNotes(w http.ResponseWriter, r *http.Request) {
+	scope, err := noteScopeParam.normalize(r.PathValue("scope"))
+	if err != nil || scope == "" {
+		writeError(w, http.StatusBadRequest, "invalid_scope", "scope doit etre game ou achievement")
+		return
+	}
+	objectID, err := noteObjectID(scope, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if !s.putNote(w, r, scope, objectID) {
+			return
+		}
+	case http.MethodDelete:
+		if !s.deleteNotes(w, r, scope, objectID) {
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET, PUT or DELETE only")
+		return
+	}
+
+	list, err := s.notesFor(scope, objectID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	writeJSON(w, adminNotesResponse{Scope: scope, ObjectID: objectID, Notes: list})
+}
+
+func (s *Server) putNote(w http.ResponseWriter, r *http.Request, scope, objectID string) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 16<<10))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return false
+	}
+	var in noteInput
+	if err := json.Unmarshal(body, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Corps JSON attendu, ex: {\"text\":\"...\",\"author\":\"...\"}")
+		return false
+	}
+	if err := in.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_note", err.Error())
+		return false
+	}
+
+	existing, err := s.notesFor(scope, objectID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return false
+	}
+	now := time.Now().Unix()
+	if in.NoteID != 0 {
+		if !hasNote(existing, in.NoteID) {
+			writeError(w, http.StatusNotFound, "unknown_note", "Aucune note "+strconv.FormatInt(in.NoteID, 10)+" sur cet objet")
+			return false
+		}
+		err = s.writes.exec(r.Context(), `UPDATE admin_notes SET body=?, author=?, updated_at=? WHERE id=? AND scope=? AND object_id=?`,
+			in.Text, in.Author, now, in.NoteID, scope, objectID)
+	} else {
+		if len(existing) >= maxNotesPerObject {
+			writeError(w, http.StatusConflict, "too_many_notes", fmt.Sprintf("Maximum %d notes par objet: supprime ou modifie une note existante", maxNotesPerObject))
+			return false
+		}
+		err = s.writes.exec(r.Context(), `INSERT INTO admin_notes(scope, object_id, author, body, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?)`,
+			scope, objectID, in.Author, in.Text, now, now)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) deleteNotes(w http.ResponseWriter, r *http.Request, scope, objectID string) bool {
+	raw := strings.TrimSpace(r.URL.Query().Get("noteId"))
+	var err error
+	if raw == "" {
+		err = s.writes.exec(r.Context(), `DELETE FROM admin_notes WHERE scope=? AND object_id=?`, scope, objectID)
+	} else {
+		id, parseErr := strconv.ParseInt(raw, 10, 64)
+		if parseErr != nil || id <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_query", "noteId doit etre un entier positif")
+			return false
+		}
+		existing, listErr := s.notesFor(scope, objectID)
+		if listErr != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", listErr.Error())
+			return false
+		}
+		if !hasNote(existing, id) {
+			writeError(w, http.StatusNotFound, "unknown_note", "Aucune note "+raw+" sur cet objet")
+			return false
+		}
+		err = s.writes.exec(r.Context(), `DELETE FROM admin_notes WHERE id=? AND scope=? AND object_id=?`, id, scope, objectID)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return false
+	}
+	return true
+}
+
+func hasNote(notes []adminNote, id int64) bool {
+	for _, n := range notes {
+		if n.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const noteSentinel = "NOTE-SENTINEL-7f3a"
+
+func notesMux(t *testing.T, s *Server) func(method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	s.adminToken = "secret"
+	mux := http.NewServeMux()
+	if err := registerAPIRoutes(mux, s.apiRoutes(), apiV1); err != nil {
+		t.Fatal(err)
+	}
+	return func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if strings.Contains(path, "/admin/") {
+			r.Header.Set("X-Admin-Token", "secret")
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+}
+
+func decodeNotes(t *testing.T, w *httptest.ResponseRecorder) adminNotesResponse {
+	t.Helper()
+	var got adminNotesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	return got
+}
+
+func TestAdminNotesCRUD(t *testing.T) {
+	call := notesMux(t, newTestServer(t))
+
+	w := call("PUT", "/api/v1/admin/notes/game/105600", `{"text":"  pic le 2024-12-19: week-end gratuit ","author":"mod"}`)
+	got := decodeNotes(t, w)
+	if w.Code != http.StatusOK || got.ObjectID != "105600" || len(got.Notes) != 1 || got.Notes[0].Text != "pic le 2024-12-19: week-end gratuit" || got.Notes[0].Author != "mod" {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+	id := got.Notes[0].ID
+
+	// An achievement of the default app has two equivalent ids.
+	call("PUT", "/api/v1/admin/notes/achievement/A", `{"text":"note A"}`)
+	got = decodeNotes(t, call("GET", "/api/v1/admin/notes/achievements/105600:A", ""))
+	if got.Scope != noteScopeAchievement || got.ObjectID != "105600:A" || len(got.Notes) != 1 || got.Notes[0].Author != defaultNoteAuthor {
+		t.Errorf("achievement notes %+v", got)
+	}
+
+	got = decodeNotes(t, call("PUT", "/api/v1/admin/notes/game/105600", fmt.Sprintf(`{"text":"corrigee","noteId":%d}`, id)))
+	if len(got.Notes) != 1 || got.Notes[0].Text != "corrigee" || got.Notes[0].Author != defaultNoteAuthor {
+		t.Errorf("edit: %+v", got)
+	}
+	if w := call("PUT", "/api/v1/admin/notes/game/440", fmt.Sprintf(`{"text":"x","noteId":%d}`, id)); w.Code != http.StatusNotFound {
+		t.Errorf("edit through another object: %d %s", w.Code, w.Body)
+	}
+
+	var list []adminNote
+	json.Unmarshal(call("GET", "/api/v1/admin/notes?scope=game", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ObjectID != "105600" {
+		t.Errorf("list ?scope=game: %+v", list)
+	}
+	json.Unmarshal(call("GET", "/api/v1/admin/notes", "").Body.Bytes(), &list)
+	if len(list) != 2 {
+		t.Errorf("list of every scope: %+v", list)
+	}
+
+	if w := call("DELETE", fmt.Sprintf("/api/v1/admin/notes/game/105600?noteId=%d", id+100), ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE unknown note: %d", w.Code)
+	}
+	if got := decodeNotes(t, call("DELETE", fmt.Sprintf("/api/v1/admin/notes/game/105600?noteId=%d", id), "")); len(got.Notes) != 0 {
+		t.Errorf("after DELETE ?noteId=: %+v", got)
+	}
+	call("PUT", "/api/v1/admin/notes/achievement/A", `{"text":"note A bis"}`)
+	if got := decodeNotes(t, call("DELETE", "/api/v1/admin/notes/achievement/105600:A", "")); len(got.Notes) != 0 {
+		t.Errorf("after DELETE of every note: %+v", got)
+	}
+}
+
+func TestAdminNotesLimits(t *testing.T) {
+	call := notesMux(t, newTestServer(t))
+	for _, tt := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"PUT", "/api/v1/admin/notes/game/105600", `{"text":"   "}`, http.StatusBadRequest},
+		{"PUT", "/api/v1/admin/notes/game/105600", `{"text":"` + strings.Repeat("é", maxNoteChars+1) + `"}`, http.StatusBadRequest},
+		{"PUT", "/api/v1/admin/notes/game/105600", `{"text":"a","author":"` + strings.Repeat("m", maxNoteAuthorChars+1) + `"}`, http.StatusBadRequest},
+		{"PUT", "/api/v1/admin/notes/game/105600", `texte`, http.StatusBadRequest},
+		{"PUT", "/api/v1/admin/notes/user/105600", `{"text":"a"}`, http.StatusBadRequest},
+		{"PUT", "/api/v1/admin/notes/game/terraria", `{"text":"a"}`, http.StatusBadRequest},
+		{"PUT", "/api/v1/admin/notes/achievement/105600:", `{"text":"a"}`, http.StatusBadRequest},
+		{"DELETE", "/api/v1/admin/notes/game/105600?noteId=-1", ``, http.StatusBadRequest},
+		{"GET", "/api/v1/admin/notes?scope=user", ``, http.StatusBadRequest},
+	} {
+		if w := call(tt.method, tt.path, tt.body); w.Code != tt.code {
+			t.Errorf("%s %s %.40s: %d %s, want %d", tt.method, tt.path, tt.body, w.Code, w.Body, tt.code)
+		}
+	}
+	if w := call("PUT", "/api/v1/admin/notes/game/105600", `{"text":"`+strings.Repeat("é", maxNoteChars)+`"}`); w.Code != http.StatusOK {
+		t.Errorf("note of the maximum length: %d %s", w.Code, w.Body)
+	}
+	for i := 1; i < maxNotesPerObject; i++ {
+		call("PUT", "/api/v1/admin/notes/game/105600", fmt.Sprintf(`{"text":"note %d"}`, i))
+	}
+	if w := call("PUT", "/api/v1/admin/notes/game/105600", `{"text":"une de trop"}`); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "too_many_notes") {
+		t.Errorf("note %d: %d %s", maxNotesPerObject+1, w.Code, w.Body)
+	}
+	// Another object has its own allowance.
+	if w := call("PUT", "/api/v1/admin/notes/game/440", `{"text":"a"}`); w.Code != http.StatusOK {
+		t.Errorf("first note of 440: %d", w.Code)
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/admin/notes", nil)
+	w := httptest.NewRecorder()
+	mux := http.NewServeMux()
+	registerAPIRoutes(mux, (&Server{adminToken: "secret"}).apiRoutes(), apiV1)
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without the admin token: %d", w.Code)
+	}
+}
+
+// Notes stay in the admin views: a sentinel note on every object is absent
+// from every public response.
+func TestAdminNotesNeverPublic(t *testing.T) {
+	f := fakeSteamGame(t)
+	f.handle("GetPlayerAchievements", http.StatusOK, fakePlayerJSON)
+	s := newTestServer(t)
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	call := notesMux(t, s)
+	for _, path := range []string{"game/105600", "game/440", "achievement/A", "achievement/B", "achievement/440:A"} {
+		if w := call("PUT", "/api/v1/admin/notes/"+path, `{"text":"`+noteSentinel+`","author":"`+noteSentinel+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: %d %s", path, w.Code, w.Body)
+		}
+	}
+
+	const players = "steamId=76561197960287930&steamids=76561197960287930,76561197960287931"
+	scanned := 0
+	for _, rt := range s.apiRoutes() {
+		if rt.Admin || rt.Method != http.MethodGet || rt.Path == "/events" {
+			continue
+		}
+		path := strings.NewReplacer("{apiName}", "A", "{steamid}", "76561197960287930").Replace(rt.Path)
+		for _, query := range []string{rt.Example, "?appId=105600&" + players + "&q=a&from=2024-01-01&to=2030-01-01", "?appId=440&" + players + "&format=csv"} {
+			w := call("GET", "/api/v1"+path+query, "")
+			if w.Code == http.StatusOK {
+				scanned++
+			}
+			if strings.Contains(w.Body.String(), noteSentinel) || strings.Contains(fmt.Sprint(w.Header()), noteSentinel) {
+				t.Errorf("GET %s%s: the note is public: %.200s", path, query, w.Body)
+			}
+		}
+	}
+	for _, path := range []string{"/api/v1/openapi.json", "/api/v1/achievements?format=html", "/api/v1/achievements/export?format=xlsx"} {
+		if w := call("GET", path, ""); strings.Contains(w.Body.String(), noteSentinel) {
+			t.Errorf("GET %s: the note is public", path)
+		}
+	}
+
+	// The admin cache view, filled by the requests above, shows the notes of
+	// each game inline.
+	var cache []adminCacheEntry
+	json.Unmarshal(call("GET", "/api/v1/admin/cache", "").Body.Bytes(), &cache)
+	inline := 0
+	for _, e := range cache {
+		if len(e.Notes) > 0 && e.Notes[0].Text == noteSentinel && e.Notes[0].ObjectID == e.AppID.String() {
+			inline++
+		}
+	}
+	if inline == 0 {
+		t.Errorf("no note in /admin/cache: %+v", cache)
+	}
+
+	if scanned < 30 {
+		t.Errorf("only %d public responses scanned", scanned)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"os"
+)
+
+type storageFile struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// handleAdminStorage is GET /admin/storage: what the database and the
+// files next to it weigh, the rows of every table and what the history
+// compaction last did.
+func (s *Server) handleAdminStorage(w http.ResponseWriter, r *http.Request) {
+	var dbFile string
+	if err := s.db.QueryRow(`SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&dbFile); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	files := make([]storageFile, 0, 5)
+	for _, path := range []string{dbFile, dbFile + "-wal", dbFile + "-shm", s.cacheFile, s.stateFile} {
+		if path == "" || path == "-wal" || path == "-shm" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil {
+			files = append(files, storageFile{Path: path, Bytes: fi.Size()})
+		}
+	}
+
+	var pageCount, pageSize, freePages int64
+	for _, p := range []struct {
+		pragma string
+		dst    *int64
+	}{{"page_count", &pageCount}, {"page_size", &pageSize}, {"freelist_count", &freePages}} {
+		if err := s.db.QueryRow(`PRAGMA ` + p.pragma).Scan(p.dst); err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+	}
+
+	tables, err := s.tableRowCounts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+
+	c := s.compactor
+	writeJSON(w, map[string]any{
+		"files":     files,
+		"pageSize":  pageSize,
+		"pages":     pageCount,
+		"freePages": freePages,
+		"tables":    tables,
+		"historyCompaction": map[string]any{
+			"enabled":        c.interval > 0,
+			"interval":       c.interval.String(),
+			"fullResolution": c.fullResolution.String(),
+			"dailyUntil":     c.dailyUntil.String(),
+			"last":           c.stats(),
+		},
+		"historySnapshots": s.history.stats(),
+	})
+}
+
+func (s *Server) tableRowCounts() (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(names))
+	for _, name := range names {
+		var n int64
+		// Names come from sqlite_master, quoted all the same.
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM "` + name + `"`).Scan(&n); err != nil {
+			return nil, err
+		}
+		counts[name] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	alertUpstreamDown      = "upstream_down"
+	alertUpstreamRecovered = "upstream_recovered"
+	alertBreakerOpen       = "breaker_open"
+	alertBreakerClosed     = "breaker_closed"
+	alertNotReady          = "not_ready"
+	alertReady             = "ready"
+)
+
+const defaultAlertFailureThreshold = 5 * time.Minute
+const defaultAlertMinInterval = 15 * time.Minute
+const alertRetryDelay = 10 * time.Second
+
+type alertPayload struct {
+	Type     string    `json:"type"`
+	AppID    AppID     `json:"appId,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration"`
+	At       time.Time `json:"at"`
+}
+
+// alertNotifier posts alerts to ALERT_WEBHOOK_URL in the background, one at
+// a time and in the order they were raised. Alerts of one type are sent at
+// most once per minInterval.
+type alertNotifier struct {
+	url         string
+	minInterval time.Duration
+	client      *http.Client
+	now         func() time.Time
+	queue       chan alertPayload
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+}
+
+const alertQueueSize = 32
+
+func newAlertNotifier(url string, minInterval time.Duration) *alertNotifier {
+	n := &alertNotifier{
+		url:         url,
+		minInterval: minInterval,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		now:         time.Now,
+		lastSent:    make(map[string]time.Time),
+	}
+	if url != "" {
+		n.queue = make(chan alertPayload, alertQueueSize)
+		go n.deliver()
+	}
+	return n
+}
+
+func (n *alertNotifier) notify(p alertPayload) {
+	if n == nil || n.url == "" {
+		return
+	}
+	now := n.now()
+	n.mu.Lock()
+	if last, ok := n.lastSent[p.Type]; ok && now.Sub(last) < n.minInterval {
+		n.mu.Unlock()
+		return
+	}
+	n.lastSent[p.Type] = now
+	n.mu.Unlock()
+
+	p.At = now.UTC()
+	select {
+	case n.queue <- p:
+	default:
+		log.Printf("alert webhook %s: queue full, dropped", p.Type)
+	}
+}
+
+func (n *alertNotifier) deliver() {
+	for p := range n.queue {
+		body, err := json.Marshal(p)
+		if err != nil {
+			continue
+		}
+		for attempt := 1; attempt <= 2; attempt++ {
+			err := n.post(body)
+			if err == nil {
+				break
+			}
+			log.Printf("alert webhook %s (attempt %d): %v", p.Type, attempt, err)
+			if attempt == 1 {
+				time.Sleep(alertRetryDelay)
+			}
+		}
+	}
+}
+
+func (n *alertNotifier) post(body []byte) error {
+	res, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// upstreamMonitor watches every Steam call made through steamHTTPClient.
+// Once calls keep failing for longer than threshold it raises
+// upstream_down, and upstream_recovered on the next success.
+type upstreamMonitor struct {
+	threshold time.Duration
+	alerts    *alertNotifier
+	now       func() time.Time
+
+	mu           sync.Mutex
+	failingSince time.Time
+	down         bool
+	lastError    string
+	lastErrorAt  time.Time
+}
+
+var upstreamHealth *upstreamMonitor
+
+func newUpstreamMonitor(threshold time.Duration, alerts *alertNotifier) *upstreamMonitor {
+	return &upstreamMonitor{threshold: threshold, alerts: alerts, now: time.Now}
+}
+
+// isUpstreamFailure tells outages from answers: 429 and 5xx count, other
+// statuses (private profile, bad key) are Steam working as intended.
+func isUpstreamFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+func (m *upstreamMonitor) record(req *http.Request, res *http.Response, err error) {
+	if m == nil {
+		return
+	}
+	appID, _ := parseAppID(req.URL.Query().Get("appid"))
+	now := m.now()
+
+	m.mu.Lock()
+	if !isUpstreamFailure(res, err) {
+		wasDown, since := m.down, m.failingSince
+		m.failingSince, m.down = time.Time{}, false
+		m.mu.Unlock()
+		if wasDown {
+			m.alerts.notify(alertPayload{Type: alertUpstreamRecovered, AppID: appID, Duration: now.Sub(since).Round(time.Second).String()})
+		}
+		return
+	}
+
+	if m.failingSince.IsZero() {
+		m.failingSince = now
+	}
+	m.lastError, m.lastErrorAt = upstreamFailureSummary(res, err), now
+	since := m.failingSince
+	raise := !m.down && now.Sub(since) >= m.threshold
+	if raise {
+		m.down = true
+	}
+	m.mu.Unlock()
+
+	if raise {
+		m.alerts.notify(alertPayload{Type: alertUpstreamDown, AppID: appID, Error: upstreamFailureSummary(res, err), Duration: now.Sub(since).Round(time.Second).String()})
+	}
+}
+
+func upstreamFailureSummary(res *http.Response, err error) string {
+	if err != nil {
+		return redactError(err).Error()
+	}
+	return "status " + strconv.Itoa(res.StatusCode)
+}
+
+// state reports what the monitor has seen, without calling Steam: failing
+// calls make it degraded, and down once the alert threshold is passed.
+func (m *upstreamMonitor) state() (status string, lastError string, lastErrorAt time.Time) {
+	if m == nil {
+		return componentOK, "", time.Time{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.down:
+		status = componentDown
+	case !m.failingSince.IsZero():
+		status = componentDegraded
+	default:
+		status = componentOK
+	}
+	return status, m.lastError, m.lastErrorAt
+}
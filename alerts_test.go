@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// alertSink is a webhook that hands every alert it gets to the test.
+func alertSink(t *testing.T, clock *fakeClock) (*alertNotifier, chan alertPayload) {
+	t.Helper()
+	got := make(chan alertPayload, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p alertPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		got <- p
+	}))
+	t.Cleanup(srv.Close)
+	n := newAlertNotifier(srv.URL, 15*time.Minute)
+	n.now = clock.now
+	return n, got
+}
+
+func TestAlertSequence(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)}
+	alerts, got := alertSink(t, clock)
+	monitor := newUpstreamMonitor(5*time.Minute, alerts)
+	monitor.now = clock.now
+	b := newUpstreamBreaker(3, 2*time.Minute)
+	b.now, b.alerts = clock.now, alerts
+	rd := &readiness{critical: map[string]bool{"steam": true}, alerts: alerts, now: clock.now}
+
+	req := httptest.NewRequest(http.MethodGet, "https://api.steampowered.com/x?appid=105600", nil)
+	failing := &http.Response{StatusCode: http.StatusBadGateway}
+	fail := func() {
+		probe, err := b.allow()
+		if err != nil {
+			return
+		}
+		monitor.record(req, failing, nil)
+		b.done(probe, callFailed)
+	}
+	succeed := func() {
+		probe, err := b.allow()
+		if err != nil {
+			t.Fatalf("call refused at %v: %v", clock.t, err)
+		}
+		b.done(probe, callSucceeded)
+		monitor.record(req, &http.Response{StatusCode: http.StatusOK}, nil)
+	}
+	ready := func() {
+		status, lastErr, _ := monitor.state()
+		st := readyResponse{Status: componentOK, Components: map[string]readyComponent{"steam": {Status: status, Critical: true, LastError: lastErr}}}
+		if status == componentDown {
+			st.Status = componentDown
+		}
+		rd.observe(st)
+	}
+
+	var sequence []string
+	expect := func(step, typ string) {
+		t.Helper()
+		select {
+		case p := <-got:
+			sequence = append(sequence, p.Type)
+			if p.Type != typ {
+				t.Fatalf("%s: alert %q, want %q (so far %v)", step, p.Type, typ, sequence)
+			}
+			if !p.At.Equal(clock.t) {
+				t.Errorf("%s: alert at %v, want the fake clock %v", step, p.At, clock.t)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no %q alert (so far %v)", step, typ, sequence)
+		}
+	}
+
+	ready() // ok at start: remembered, nothing sent
+	fail()
+	fail()
+	ready()
+	fail()
+	expect("third failure in a row", alertBreakerOpen)
+	clock.advance(time.Minute)
+	fail() // refused by the open breaker, never sent
+	clock.advance(4 * time.Minute)
+	fail() // probe, still failing
+	expect("failing for 5m", alertUpstreamDown)
+	ready()
+	expect("steam down and critical", alertNotReady)
+	ready() // still down: no repeat
+	clock.advance(3 * time.Minute)
+	succeed()
+	expect("probe answered", alertBreakerClosed)
+	expect("first success", alertUpstreamRecovered)
+	ready()
+	expect("steam back", alertReady)
+
+	// A second trip within ALERT_MIN_INTERVAL of the first is not sent.
+	for i := 0; i < 3; i++ {
+		fail()
+	}
+	clock.advance(16 * time.Minute)
+	succeed()
+	expect("second close, 16m after the first", alertBreakerClosed)
+
+	want := []string{alertBreakerOpen, alertUpstreamDown, alertNotReady, alertBreakerClosed, alertUpstreamRecovered, alertReady, alertBreakerClosed}
+	if strings.Join(sequence, ",") != strings.Join(want, ",") {
+		t.Fatalf("alerts = %v, want %v", sequence, want)
+	}
+	select {
+	case p := <-got:
+		t.Fatalf("unexpected alert %q", p.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// A server that starts unready, with a cold cache, has nothing to alert
+// about until it has been ready once.
+func TestReadinessAlertsOnlyAfterReady(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)}
+	alerts, got := alertSink(t, clock)
+	rd := &readiness{alerts: alerts, now: clock.now}
+
+	cold := readyResponse{Status: componentDown, Reasons: []string{"Cache vide"}}
+	rd.observe(cold)
+	rd.observe(readyResponse{Status: componentDegraded})
+	rd.observe(readyResponse{Status: componentDown, Components: map[string]readyComponent{
+		"storage": {Status: componentDown, Critical: true, LastError: "database is locked"},
+		"redis":   {Status: componentDown},
+	}})
+	select {
+	case p := <-got:
+		if p.Type != alertNotReady || p.Error != "storage: database is locked" {
+			t.Fatalf("alert = %+v, want not_ready naming storage only", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no not_ready alert")
+	}
+	clock.advance(90 * time.Second)
+	rd.observe(readyResponse{Status: componentOK})
+	select {
+	case p := <-got:
+		if p.Type != alertReady || p.Duration != "1m30s" {
+			t.Fatalf("alert = %+v, want ready after 1m30s", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no ready alert")
+	}
+}
+
+func TestAlertsWithoutWebhook(t *testing.T) {
+	var n *alertNotifier
+	n.notify(alertPayload{Type: alertBreakerOpen})
+	newAlertNotifier("", time.Minute).notify(alertPayload{Type: alertBreakerOpen})
+	b := newUpstreamBreaker(1, time.Minute)
+	b.done(false, callFailed)
+	if _, err := b.allow(); err == nil {
+		t.Fatalf("breaker not open after the threshold")
+	}
+	rd := &readiness{now: time.Now}
+	rd.observe(readyResponse{Status: componentOK})
+	rd.observe(readyResponse{Status: componentDown})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// ALLOWED_APPIDS=105600,413150 restricts the apps the public endpoints
+// accept in ?appId=, so the server cannot be used as an open proxy to
+// Steam. The default app and the games of the games file are always
+// allowed. Unset, every app is accepted.
+func parseAllowedAppIDs(raw string) (map[AppID]bool, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	out := make(map[AppID]bool)
+	for _, part := range strings.Split(raw, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		appID, err := parseAppID(part)
+		if err != nil {
+			return nil, fmt.Errorf("ALLOWED_APPIDS: %q is not a positive integer", strings.TrimSpace(part))
+		}
+		out[appID] = true
+	}
+	return out, nil
+}
+
+func (s *Server) appAllowed(appID AppID) bool {
+	if s.allowedApps == nil || appID == defaultGlobalAppID {
+		return true
+	}
+	if s.games != nil {
+		if _, listed := s.games.games[appID]; listed {
+			return true
+		}
+	}
+	return s.allowedApps[appID]
+}
+
+func writeAppNotAllowed(w http.ResponseWriter, appID AppID) {
+	writeCode(w, apperr.CodeAppNotAllowed, appID)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// exampleSteamID is a public profile used in the example URLs of the index.
+const exampleSteamID = "76561197960287930"
+
+var routeParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+var routeParamExamples = map[string]string{
+	"steamid": exampleSteamID,
+	"appid":   defaultGlobalAppID.String(),
+}
+
+type apiIndexEntry struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Summary string `json:"summary"`
+	Example string `json:"example"`
+	Admin   bool   `json:"admin,omitempty"`
+}
+
+type apiIndex struct {
+	Version   string          `json:"version"`
+	OpenAPI   string          `json:"openapi"`
+	Endpoints []apiIndexEntry `json:"endpoints"`
+}
+
+// buildAPIIndex lists the routes of version from the same table the router
+// mounts, so the index cannot drift from what is served. Admin routes are
+// only listed for admin callers.
+func buildAPIIndex(routes []apiRoute, version string, withAdmin bool) apiIndex {
+	idx := apiIndex{Version: version, OpenAPI: "/api/" + version + "/openapi.json", Endpoints: make([]apiIndexEntry, 0, len(routes))}
+	for _, rt := range routes {
+		if rt.Admin && !withAdmin {
+			continue
+		}
+		for _, v := range rt.Versions {
+			if v != version {
+				continue
+			}
+			path := "/api/" + v + rt.Path
+			example := routeParamPattern.ReplaceAllStringFunc(path, func(m string) string {
+				if ex, ok := routeParamExamples[strings.ToLower(m[1:len(m)-1])]; ok {
+					return ex
+				}
+				return "1"
+			})
+			method := rt.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+			idx.Endpoints = append(idx.Endpoints, apiIndexEntry{Method: method, Path: path, Summary: rt.Summary, Example: example + rt.Example, Admin: rt.Admin})
+		}
+	}
+	sort.SliceStable(idx.Endpoints, func(i, j int) bool {
+		if idx.Endpoints[i].Admin != idx.Endpoints[j].Admin {
+			return !idx.Endpoints[i].Admin
+		}
+		return idx.Endpoints[i].Path < idx.Endpoints[j].Path
+	})
+	return idx
+}
+
+var apiIndexTemplate = newPageTemplate("api_index.html", nil, `<!doctype html>
+<html lang="fr">
+<head>
+<meta charset="utf-8">
+<title>API {{.Version}} - Steam Completion Tracker</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #1b2838; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4rem .6rem; border-bottom: 1px solid #ddd; vertical-align: top; }
+code { font-size: .9em; }
+.admin { color: #a33; }
+</style>
+</head>
+<body>
+<h1>API {{.Version}}</h1>
+<p>Description OpenAPI : <a href="{{.OpenAPI}}">{{.OpenAPI}}</a></p>
+<table>
+<tr><th>Méthode</th><th>Chemin</th><th>Description</th><th>Exemple</th></tr>
+{{range .Endpoints}}<tr{{if .Admin}} class="admin"{{end}}>
+<td>{{.Method}}</td><td><code>{{.Path}}</code></td><td>{{.Summary}}</td>
+<td>{{if eq .Method "GET"}}<a href="{{.Example}}"><code>{{.Example}}</code></a>{{else}}<code>{{.Example}}</code>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`)
+
+// registerAPIIndex serves the index at /api and /api/ for the default
+// version and at /api/<version>/ for each version: JSON by default, HTML
+// when the client asks for text/html.
+func (s *Server) registerAPIIndex(mux *http.ServeMux, routes []apiRoute, defaultVersion string) {
+	serve := func(version string) http.Handler {
+		return withAPIVersion(version, func(w http.ResponseWriter, r *http.Request) {
+			idx := buildAPIIndex(routes, version, s.isAdminRequest(r))
+			w.Header().Set("Vary", "Accept, Authorization, X-Admin-Token")
+			if strings.Contains(r.Header.Get("Accept"), "text/html") {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				if err := apiIndexTemplate.Execute(w, idx); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			writeJSON(w, idx)
+		})
+	}
+	mux.Handle("GET /api", serve(defaultVersion))
+	mux.Handle("GET /api/{$}", serve(defaultVersion))
+	for _, v := range knownAPIVersions {
+		mux.Handle("GET /api/"+v, serve(v))
+		mux.Handle("GET /api/"+v+"/{$}", serve(v))
+	}
+}
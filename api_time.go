@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// apiTime is a unix timestamp in seconds as Steam sends it, where 0 means
+// unknown. It is int64 so values past 2038 survive, and it is written as
+// RFC3339 UTC like every other time in the API, or null when unknown.
+type apiTime int64
+
+func (t apiTime) Known() bool { return t > 0 }
+
+func (t apiTime) Time() time.Time { return time.Unix(int64(t), 0).UTC() }
+
+func (t apiTime) MarshalJSON() ([]byte, error) {
+	if !t.Known() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Time().Format(time.RFC3339))
+}
+
+// steamUnlockTime validates an unlocktime from GetPlayerAchievements.
+// Negative values are not timestamps; they are dropped to unknown.
+func steamUnlockTime(appID AppID, apiName string, v int64) apiTime {
+	if v < 0 {
+		log.Printf("user stats app %d: negative unlocktime %d for %s ignored", appID, v, apiName)
+		return 0
+	}
+	return apiTime(v)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPITimeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{"unknown", 0, `null`},
+		{"negative", -1, `null`},
+		{"pre-2009", 1230000000, `"2008-12-23T02:40:00Z"`},
+		{"current", 1700000000, `"2023-11-14T22:13:20Z"`},
+		{"post-2038", 4102444800, `"2100-01-01T00:00:00Z"`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(steamUnlockTime(105600, "A", tt.unix))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: %s, %v; want %s", tt.name, got, err, tt.want)
+		}
+		// The field is always there, null when the time is unknown.
+		b, _ := json.Marshal(Achievement{APIName: "A", UnlockTime: steamUnlockTime(105600, "A", tt.unix)})
+		if !strings.Contains(string(b), `"unlockTime":`+tt.want) {
+			t.Errorf("%s: achievement %s without unlockTime %s", tt.name, b, tt.want)
+		}
+		lite, _ := json.Marshal(LiteAchievement{APIName: "A", UnlockTime: steamUnlockTime(105600, "A", tt.unix)})
+		if !strings.Contains(string(lite), `"unlockTime":`+tt.want) {
+			t.Errorf("%s: lite achievement %s without unlockTime %s", tt.name, lite, tt.want)
+		}
+	}
+}
+
+func TestPlayerUnlockTimesFromSteam(t *testing.T) {
+	f := useFakeSteam(t)
+	f.handle("GetPlayerAchievements", http.StatusOK, `{"playerstats":{"success":true,"achievements":[
+		{"apiname":"ZERO","achieved":1,"unlocktime":0},
+		{"apiname":"OLD","achieved":1,"unlocktime":1230000000},
+		{"apiname":"NOW","achieved":1,"unlocktime":1700000000},
+		{"apiname":"FAR","achieved":1,"unlocktime":4102444800},
+		{"apiname":"NEG","achieved":1,"unlocktime":-7}]}}`)
+	got, err := fetchPlayerAchievements(context.Background(), "key", 76561197960287930, 105600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"ZERO": 0, "OLD": 1230000000, "NOW": 1700000000, "FAR": 4102444800, "NEG": 0}
+	for name, unix := range want {
+		st := got[name]
+		if int64(st.UnlockTime) != unix || st.UnlockTime.Known() != (unix > 0) || !st.Achieved {
+			t.Errorf("%s: %+v, want unlock time %d", name, st, unix)
+		}
+	}
+	if far := got["FAR"].UnlockTime.Time(); far.Year() != 2100 {
+		t.Errorf("post-2038 time read as %v", far)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AppID is a Steam application ID. It is a JSON number, like the plain int
+// it replaces; a decimal string is read too, as some clients quote IDs.
+type AppID uint32
+
+func (a AppID) String() string { return strconv.FormatUint(uint64(a), 10) }
+
+func (a *AppID) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	raw := string(b)
+	if strings.HasPrefix(raw, `"`) {
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return err
+		}
+	}
+	v, err := parseAppID(raw)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+func (a AppID) Value() (driver.Value, error) { return int64(a), nil }
+
+func (a *AppID) Scan(src any) error {
+	v, ok := src.(int64)
+	if !ok || v <= 0 || v > 1<<32-1 {
+		return fmt.Errorf("cannot scan %v into AppID", src)
+	}
+	*a = AppID(v)
+	return nil
+}
+
+// newAppID validates n as an app ID.
+func newAppID(n int) (AppID, error) {
+	if n <= 0 || n > 1<<32-1 {
+		return 0, fmt.Errorf("app id must be a positive 32-bit integer")
+	}
+	return AppID(n), nil
+}
+
+func parseAppID(raw string) (AppID, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("app id must be a positive 32-bit integer")
+	}
+	return newAppID(n)
+}
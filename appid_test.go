@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Clients rely on appId being a number and steamId a string: a SteamID64 does
+// not fit in a JavaScript number.
+func TestIdentifierJSONWire(t *testing.T) {
+	type ids struct {
+		AppID   AppID     `json:"appId"`
+		SteamID SteamID   `json:"steamId"`
+		Players []SteamID `json:"players"`
+	}
+	got, err := json.Marshal(ids{440, 76561197960287930, []SteamID{76561197960287931}})
+	if want := `{"appId":440,"steamId":"76561197960287930","players":["76561197960287931"]}`; err != nil || string(got) != want {
+		t.Errorf("Marshal = %s, %v; want %s", got, err, want)
+	}
+
+	tests := []struct {
+		name    string
+		in      string
+		app     AppID
+		steamID SteamID
+		ok      bool
+	}{
+		{"numbers", `{"appId":440,"steamId":76561197960287930}`, 440, 76561197960287930, true},
+		{"strings", `{"appId":"440","steamId":"76561197960287930"}`, 440, 76561197960287930, true},
+		{"max app id", `{"appId":4294967295}`, 4294967295, 0, true},
+		{"20-digit steam id", `{"steamId":18446744073709551615}`, 0, 0, false},
+		{"null", `{"appId":null,"steamId":null}`, 0, 0, true},
+		{"zero app id", `{"appId":0}`, 0, 0, false},
+		{"negative app id", `{"appId":-1}`, 0, 0, false},
+		{"app id overflow", `{"appId":4294967296}`, 0, 0, false},
+		{"fractional app id", `{"appId":440.5}`, 0, 0, false},
+		{"word app id", `{"appId":"tf2"}`, 0, 0, false},
+		{"short steam id", `{"steamId":"7656119796028793"}`, 0, 0, false},
+		{"legacy steam id", `{"steamId":"STEAM_0:0:11101"}`, 0, 0, false},
+		{"negative steam id", `{"steamId":-76561197960287930}`, 0, 0, false},
+		{"bool steam id", `{"steamId":true}`, 0, 0, false},
+	}
+	for _, tt := range tests {
+		var v ids
+		err := json.Unmarshal([]byte(tt.in), &v)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Unmarshal(%s) error %v, want ok %v", tt.name, tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && (v.AppID != tt.app || v.SteamID != tt.steamID) {
+			t.Errorf("%s: Unmarshal(%s) = %d, %d; want %d, %d", tt.name, tt.in, v.AppID, v.SteamID, tt.app, tt.steamID)
+		}
+	}
+
+	// A decoded value marshals back to the canonical form.
+	var v ids
+	if err := json.Unmarshal([]byte(`{"appId":"440","steamId":76561197960287930,"players":[76561197960287931,"76561197960287932"]}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = json.Marshal(v)
+	if want := `{"appId":440,"steamId":"76561197960287930","players":["76561197960287931","76561197960287932"]}`; string(got) != want {
+		t.Errorf("round trip = %s, want %s", got, want)
+	}
+}
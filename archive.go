@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const archiveDateLayout = "2006-01-02"
+
+// ArchiveResponse is the payload of /api/achievements?asOf=YYYY-MM-DD: the
+// schema as it stood at the end of that day (UTC) with, for each
+// achievement, the latest percentage recorded up to then.
+type ArchiveResponse struct {
+	Historical     bool      `json:"historical"`
+	AsOf           string    `json:"asOf"`
+	SnapshotsFrom  time.Time `json:"snapshotsFrom"`
+	SnapshotsUntil time.Time `json:"snapshotsUntil"`
+	Profile        string    `json:"profile"`
+	PolicyApplied  bool      `json:"policyApplied,omitempty"`
+	*PageInfo
+	Items any `json:"items"`
+}
+
+func (s *Server) earliestSnapshot(appID AppID) (time.Time, bool, error) {
+	var sec sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MIN(recorded_at) FROM global_percent_history WHERE app_id=?`, appID).Scan(&sec); err != nil {
+		return time.Time{}, false, err
+	}
+	if !sec.Valid {
+		return time.Time{}, false, nil
+	}
+	return time.Unix(sec.Int64, 0).UTC(), true, nil
+}
+
+// readArchive rebuilds the list as of t. Achievements without any
+// percentage snapshot up to t are left out.
+func (s *Server) readArchive(appID AppID, t time.Time) (*ArchiveResponse, []Achievement, error) {
+	rows, err := s.db.Query(`
+		SELECT h.api_name, h.name, h.description, h.icon, h.icon_gray, h.hidden, p.percent, p.recorded_at
+		FROM achievement_schema_history h
+		JOIN global_percent_history p ON p.app_id = h.app_id AND p.api_name = h.api_name
+		WHERE h.app_id = ?
+			AND h.first_seen <= ?
+			AND (h.removed_at IS NULL OR h.removed_at > ?)
+			AND p.recorded_at = (
+				SELECT MAX(recorded_at) FROM global_percent_history
+				WHERE app_id = h.app_id AND api_name = h.api_name AND recorded_at <= ?
+			)
+	`, appID, t.Unix(), t.Unix(), t.Unix())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	resp := &ArchiveResponse{Historical: true}
+	items := make([]Achievement, 0)
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var a Achievement
+		var hidden int
+		var recorded int64
+		if err := rows.Scan(&a.APIName, &a.Name, &a.Description, &a.Icon, &a.IconGray, &hidden, &a.GlobalPct, &recorded); err != nil {
+			return nil, nil, err
+		}
+		// Several snapshots may share the same timestamp; keep the first.
+		if seen[a.APIName] {
+			continue
+		}
+		seen[a.APIName] = true
+		a.Hidden = hidden == 1
+
+		at := time.Unix(recorded, 0).UTC()
+		if resp.SnapshotsFrom.IsZero() || at.Before(resp.SnapshotsFrom) {
+			resp.SnapshotsFrom = at
+		}
+		if at.After(resp.SnapshotsUntil) {
+			resp.SnapshotsUntil = at
+		}
+		items = append(items, a)
+	}
+	return resp, items, rows.Err()
+}
+
+func (s *Server) handleAchievementsArchive(w http.ResponseWriter, r *http.Request, query achievementQuery) {
+	raw := strings.TrimSpace(r.URL.Query().Get("asOf"))
+	day, err := time.Parse(archiveDateLayout, raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_as_of", "asOf must be a date formatted YYYY-MM-DD")
+		return
+	}
+	end := day.Add(24*time.Hour - time.Second)
+
+	earliest, ok, err := s.earliestSnapshot(defaultGlobalAppID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	if !ok || end.Before(earliest) {
+		body := map[string]any{"error": "no_snapshot", "details": "Aucun instantane enregistre a cette date"}
+		if ok {
+			body["earliest"] = earliest.Format(archiveDateLayout)
+		}
+		writeJSONStatus(w, http.StatusNotFound, body)
+		return
+	}
+
+	resp, items, err := s.readArchive(defaultGlobalAppID, end)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	resp.AsOf = day.Format(archiveDateLayout)
+
+	policy := s.policyFor(w, defaultGlobalAppID)
+	resp.PolicyApplied = policy != nil
+	items, unknownTags := query.apply(s.overlay, policy, defaultGlobalAppID, query.lang, items)
+	query.order.sort(items)
+	// A past day is immutable; today's snapshot moves with each sync.
+	var version int64
+	if !end.Before(time.Now()) {
+		if synced, err := s.lastSyncAt(r.Context()); err == nil {
+			version = synced.Unix()
+		}
+	}
+	items, resp.PageInfo, ok = paginate(w, r, query.order, items, version)
+	if !ok {
+		return
+	}
+	if query.export(w, defaultGlobalAppID, items) {
+		return
+	}
+	s.proxyIcons(defaultGlobalAppID, items)
+	resp.Items = query.project(items)
+	resp.Profile = query.profile
+
+	// A day that is over can no longer change, unless a visibility policy
+	// is edited.
+	if end.Before(time.Now()) && policy == nil {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	query.writeDebug(w, unknownTags)
+	query.write(w, r, resp)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// version is overridden at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+const defaultBootstrapDeadline = 3 * time.Second
+
+var bootstrapComponents = []string{"config", "achievements", "stats", "suggestions", "games"}
+
+type PublicConfig struct {
+	Version      string `json:"version"`
+	DefaultAppID AppID  `json:"defaultAppId"`
+	CacheTTL     string `json:"cacheTtl"`
+	ReadOnly     bool   `json:"readOnly"`
+}
+
+type bootstrapDebug struct {
+	AssemblyMs   int64            `json:"assemblyMs"`
+	ComponentsMs map[string]int64 `json:"componentsMs"`
+	// SurrogateKeys mirrors the Surrogate-Key header to check CDN tagging.
+	SurrogateKeys []string `json:"surrogateKeys"`
+	// Generation is the cache generation the components were read from;
+	// Retried is set when a refresh forced a second assembly.
+	Generation uint64 `json:"generation"`
+	Retried    bool   `json:"retried,omitempty"`
+	Consistent bool   `json:"consistent"`
+}
+
+type BootstrapResponse struct {
+	Version string             `json:"version"`
+	Banner  *maintenanceBanner `json:"banner,omitempty"`
+	// PolicyApplied is set when a visibility policy shaped the components.
+	PolicyApplied bool              `json:"policyApplied,omitempty"`
+	Components    map[string]any    `json:"components"`
+	Partial       []string          `json:"partial,omitempty"`
+	Errors        map[string]string `json:"errors,omitempty"`
+	Debug         bootstrapDebug    `json:"debug"`
+}
+
+func (s *Server) publicConfig() PublicConfig {
+	return PublicConfig{
+		Version:      version,
+		DefaultAppID: defaultGlobalAppID,
+		CacheTTL:     s.cfg().CacheTTL.String(),
+		ReadOnly:     s.readOnly(),
+	}
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.publicConfig())
+}
+
+// handleBootstrap assembles everything the landing page needs in a single
+// response. Components are built concurrently; the ones that fail or miss
+// the deadline are listed in "partial" instead of failing the whole call.
+func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	wanted, err := selectBootstrapComponents(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_component", err.Error())
+		return
+	}
+
+	var steamID SteamID
+	if identifier := strings.TrimSpace(r.URL.Query().Get("steamId")); identifier != "" {
+		steamID, err = s.resolveSteamIDInput(identifier)
+		if err != nil {
+			writeIdentifierError(w, err)
+			return
+		}
+	} else {
+		delete(wanted, "games")
+	}
+
+	// The landing page without a player is the same for everyone: keep it.
+	// With one, the games follow the policies of every owned app.
+	if steamID == 0 {
+		var expires time.Time
+		if wanted["achievements"] || wanted["stats"] {
+			if expires = s.globalListExpiry(r.Context()); expires.IsZero() {
+				s.writeBootstrap(w, r, wanted, 0, start)
+				return
+			}
+		}
+		scopes := []string{appGenerationScope(defaultGlobalAppID), generationPlayers, generationConfig}
+		s.serveDerived(w, r, cacheMetricBootstrap, strings.Join(slices.Sorted(maps.Keys(wanted)), ","), scopes, s.policies.forApp(defaultGlobalAppID), expires, func(w http.ResponseWriter) bool {
+			return s.writeBootstrap(w, r, wanted, 0, start)
+		})
+		return
+	}
+	s.writeBootstrap(w, r, wanted, steamID, start)
+}
+
+// writeBootstrap assembles and writes the components of wanted, and reports
+// whether the response is complete: nothing partial, one generation.
+func (s *Server) writeBootstrap(w http.ResponseWriter, r *http.Request, wanted map[string]bool, steamID SteamID, start time.Time) bool {
+	deadline := time.Duration(getenvInt("BOOTSTRAP_DEADLINE_MS", int(defaultBootstrapDeadline/time.Millisecond))) * time.Millisecond
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+
+	resp := BootstrapResponse{Version: version, Banner: s.banner()}
+	// A refresh landing mid-assembly could pair the list with stats or
+	// games from another generation: assemble again once in that case.
+	resp.Debug.Generation, resp.Debug.Retried, resp.Debug.Consistent = s.readConsistent(func() {
+		resp.Components, resp.Partial, resp.Errors, resp.Debug.ComponentsMs, resp.PolicyApplied = s.assembleBootstrap(ctx, wanted, steamID)
+	})
+	if !resp.Debug.Consistent {
+		w.Header().Set("X-Data-Inconsistent", "1")
+	}
+	flagPolicyApplied(w, resp.PolicyApplied)
+
+	sort.Strings(resp.Partial)
+	if len(resp.Errors) == 0 {
+		resp.Errors = nil
+	}
+	keys := []string{appSurrogateKey(defaultGlobalAppID), langSurrogateKey(s.lang)}
+	if steamID != 0 {
+		keys = append(keys, playerSurrogateKey(steamID))
+	}
+	resp.Debug.SurrogateKeys = setSurrogateKeys(w, keys...)
+	resp.Debug.AssemblyMs = time.Since(start).Milliseconds()
+	writeJSON(w, resp)
+	return len(resp.Partial) == 0 && resp.Debug.Consistent
+}
+
+// assembleBootstrap builds the wanted components concurrently until ctx
+// expires. wanted is not modified.
+func (s *Server) assembleBootstrap(ctx context.Context, wanted map[string]bool, steamID SteamID) (components map[string]any, partial []string, errs map[string]string, timings map[string]int64, policyApplied bool) {
+	wanted = maps.Clone(wanted)
+	var gamesPolicy atomic.Bool
+	policy := s.policies.forApp(defaultGlobalAppID)
+
+	// Achievements and stats share one read of the global list.
+	var globalOnce sync.Once
+	var globalItems []Achievement
+	var globalErr error
+	loadGlobal := func() ([]Achievement, error) {
+		globalOnce.Do(func() {
+			globalItems, globalErr = s.loadLocalizedGlobal(ctx, s.lang)
+			applyAccessibility(s.lang, globalItems)
+			applyRarity(globalItems)
+			globalItems = policy.filter(globalItems)
+		})
+		return globalItems, globalErr
+	}
+
+	builders := map[string]func() (any, error){
+		"config": func() (any, error) {
+			return s.publicConfig(), nil
+		},
+		"achievements": func() (any, error) {
+			return loadGlobal()
+		},
+		"stats": func() (any, error) {
+			items, err := loadGlobal()
+			if err != nil {
+				return nil, err
+			}
+			return computeAchievementStats(items), nil
+		},
+		"suggestions": func() (any, error) {
+			return s.publicSuggestions("", 12)
+		},
+		"games": func() (any, error) {
+			games, err := s.readUserGamesFromDB(ctx, steamID)
+			if err != nil {
+				return nil, err
+			}
+			applied, err := s.applyPolicyToGames(ctx, steamID, games)
+			gamesPolicy.Store(applied)
+			return games, err
+		},
+	}
+
+	type result struct {
+		name    string
+		value   any
+		err     error
+		elapsed time.Duration
+	}
+	results := make(chan result, len(wanted))
+	for name := range wanted {
+		build := builders[name]
+		go func() {
+			t0 := time.Now()
+			v, err := build()
+			results <- result{name: name, value: v, err: err, elapsed: time.Since(t0)}
+		}()
+	}
+
+	components = make(map[string]any, len(wanted))
+	errs = make(map[string]string)
+	timings = make(map[string]int64, len(wanted))
+	pending := len(wanted)
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			delete(wanted, res.name)
+			timings[res.name] = res.elapsed.Milliseconds()
+			if res.err != nil {
+				errs[res.name] = res.err.Error()
+				partial = append(partial, res.name)
+				continue
+			}
+			components[res.name] = res.value
+		case <-ctx.Done():
+			for name := range wanted {
+				errs[name] = "deadline exceeded"
+				partial = append(partial, name)
+			}
+			pending = 0
+		}
+	}
+
+	policyApplied = (policy != nil && (components["achievements"] != nil || components["stats"] != nil)) || gamesPolicy.Load()
+	return components, partial, errs, timings, policyApplied
+}
+
+// selectBootstrapComponents applies ?include= and ?exclude= (comma lists).
+func selectBootstrapComponents(r *http.Request) (map[string]bool, error) {
+	known := make(map[string]bool, len(bootstrapComponents))
+	for _, c := range bootstrapComponents {
+		known[c] = true
+	}
+
+	parse := func(param string) ([]string, error) {
+		out := make([]string, 0)
+		for _, part := range strings.Split(r.URL.Query().Get(param), ",") {
+			part = strings.TrimSpace(strings.ToLower(part))
+			if part == "" {
+				continue
+			}
+			if !known[part] {
+				return nil, fmt.Errorf("unknown component %q (known: %s)", part, strings.Join(bootstrapComponents, ", "))
+			}
+			out = append(out, part)
+		}
+		return out, nil
+	}
+
+	include, err := parse("include")
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parse("exclude")
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(bootstrapComponents))
+	if len(include) == 0 {
+		for _, c := range bootstrapComponents {
+			wanted[c] = true
+		}
+	}
+	for _, c := range include {
+		wanted[c] = true
+	}
+	for _, c := range exclude {
+		delete(wanted, c)
+	}
+	return wanted, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import "sync/atomic"
+
+// Every refresh of shared data (global sync, app schema or percentages,
+// player sync, runtime config) bumps one generation counter, and cache
+// entries remember the generation that wrote them. Composite responses
+// read the counter before and after assembling: a change means a refresh
+// landed in between and parts may come from different generations.
+//
+// A refresh also bumps the scopes it touched: one app's data, the players
+// or the runtime config. What depends on a few scopes (the derived
+// responses) is then kept across the refreshes of the others.
+
+const (
+	generationPlayers = "players"
+	generationConfig  = "config"
+)
+
+func appGenerationScope(appID AppID) string {
+	return "app:" + appID.String()
+}
+
+// bumpGeneration records a refresh of shared data touching scopes and
+// returns the new generation. Refreshes of isolated test namespaces do not
+// count.
+func (s *Server) bumpGeneration(scopes ...string) uint64 {
+	for _, scope := range scopes {
+		s.scopeCounter(scope).Add(1)
+	}
+	return s.generation.Add(1)
+}
+
+func (s *Server) scopeCounter(scope string) *atomic.Uint64 {
+	c, ok := s.scopeGens.Load(scope)
+	if !ok {
+		c, _ = s.scopeGens.LoadOrStore(scope, new(atomic.Uint64))
+	}
+	return c.(*atomic.Uint64)
+}
+
+// scopesGeneration sums the generations of scopes. Each only grows, so the
+// sum stays the same exactly as long as none of them moves.
+func (s *Server) scopesGeneration(scopes []string) uint64 {
+	var sum uint64
+	for _, scope := range scopes {
+		sum += s.scopeCounter(scope).Load()
+	}
+	return sum
+}
+
+// readConsistent runs build, and runs it once more if the generation moved
+// meanwhile. consistent is false when the retry was interrupted too; the
+// second result is kept then, as the newest one.
+func (s *Server) readConsistent(build func()) (gen uint64, retried bool, consistent bool) {
+	for attempt := 0; attempt < 2; attempt++ {
+		before := s.generation.Load()
+		build()
+		if after := s.generation.Load(); after == before {
+			return before, attempt > 0, true
+		}
+	}
+	return s.generation.Load(), true, false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// A refresh landing between the two reads of a composite makes it read
+// again instead of pairing old percentages with a newer list.
+func TestReadConsistentRetriesAfterRefresh(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	schemaKey, pctKey := schemaCacheKey(ctx, 440, defaultLang), globalPctCacheKey(ctx, 440)
+	s.storeSchema(schemaKey, []Achievement{{APIName: "A"}}, time.Now())
+	s.storeGlobalPercentages(pctKey, map[string]float64{"A": 10}, time.Now())
+
+	read := func() (schemaGen, pctGen uint64, pct float64) {
+		s.cacheMu.RLock()
+		defer s.cacheMu.RUnlock()
+		p := s.appGlobalPctMap[pctKey]
+		return s.appSchemaCache[schemaKey].generation, p.generation, p.items["A"]
+	}
+
+	runs := 0
+	var schemaGen, pctGen uint64
+	var pct float64
+	gen, retried, consistent := s.readConsistent(func() {
+		runs++
+		schemaGen, _, _ = read()
+		if runs == 1 {
+			s.storeGlobalPercentages(pctKey, map[string]float64{"A": 20}, time.Now())
+		}
+		_, pctGen, pct = read()
+	})
+	if runs != 2 || !retried || !consistent {
+		t.Fatalf("%d runs, retried %v, consistent %v; want a single retry", runs, retried, consistent)
+	}
+	if pct != 20 || gen != s.generation.Load() || pctGen > gen || schemaGen > gen {
+		t.Errorf("composite at generation %d from schema %d and percentages %d (%v)", gen, schemaGen, pctGen, pct)
+	}
+
+	runs = 0
+	if _, retried, consistent := s.readConsistent(func() { runs++ }); runs != 1 || retried || !consistent {
+		t.Errorf("quiet read: %d runs, retried %v, consistent %v", runs, retried, consistent)
+	}
+
+	// Refreshes during both attempts: the second result is flagged.
+	runs = 0
+	_, retried, consistent = s.readConsistent(func() {
+		runs++
+		s.storeGlobalPercentages(pctKey, map[string]float64{"A": float64(runs)}, time.Now())
+	})
+	if runs != 2 || !retried || consistent {
+		t.Errorf("busy read: %d runs, retried %v, consistent %v; want 2 runs, inconsistent", runs, retried, consistent)
+	}
+}
+
+// Isolated test namespaces do not move the shared generation.
+func TestIsolatedStoreKeepsGeneration(t *testing.T) {
+	s := newTestServer(t)
+	s.testMode = true
+	isoCtx, _ := isolationCtx(s, "run-42")
+	before := s.generation.Load()
+	s.storeSchema(schemaCacheKey(isoCtx, 440, defaultLang), []Achievement{{APIName: "A"}}, time.Now())
+	s.storeGlobalPercentages(globalPctCacheKey(isoCtx, 440), map[string]float64{"A": 1}, time.Now())
+	if after := s.generation.Load(); after != before {
+		t.Errorf("generation %d -> %d after isolated stores", before, after)
+	}
+}
+
+// The first /bootstrap of a cold server syncs while it assembles, and
+// answers from the second, consistent assembly.
+func TestBootstrapRetriesAcrossRefresh(t *testing.T) {
+	fakeSteamGame(t)
+	get := playerMux(t, newTestServer(t))
+
+	var resp struct {
+		Debug bootstrapDebug `json:"debug"`
+	}
+	w := get("/api/bootstrap")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != 200 {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	if !resp.Debug.Retried || !resp.Debug.Consistent || w.Header().Get("X-Data-Inconsistent") != "" {
+		t.Errorf("cold bootstrap: debug %+v, X-Data-Inconsistent %q", resp.Debug, w.Header().Get("X-Data-Inconsistent"))
+	}
+	first := resp.Debug.Generation
+
+	w = get("/api/bootstrap")
+	resp.Debug = bootstrapDebug{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Debug.Retried || !resp.Debug.Consistent || resp.Debug.Generation != first {
+		t.Errorf("warm bootstrap: debug %+v, %v; want generation %d without retry", resp.Debug, err, first)
+	}
+}
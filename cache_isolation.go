@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// X-Cache-Isolation lets end-to-end test runs against a shared server get
+// their own cache entries, and their syncs their own stored data (see
+// isolatedStore). The header is only honored when TEST_MODE=1; in
+// production it is ignored entirely and never reaches the cache keys.
+const cacheIsolationHeader = "X-Cache-Isolation"
+const isolatedCacheTTL = 60 * time.Second
+const isolatedCacheMaxEntries = 256
+
+type cacheNamespaceCtxKey struct{}
+
+func (s *Server) withCacheIsolation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.testMode {
+			if ns := sanitizeCacheNamespace(r.Header.Get(cacheIsolationHeader)); ns != "" {
+				r = r.WithContext(context.WithValue(r.Context(), cacheNamespaceCtxKey{}, ns))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func cacheNamespace(ctx context.Context) string {
+	ns, _ := ctx.Value(cacheNamespaceCtxKey{}).(string)
+	return ns
+}
+
+func sanitizeCacheNamespace(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) > 64 {
+		v = v[:64]
+	}
+	for _, c := range v {
+		ok := c == '-' || c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !ok {
+			return ""
+		}
+	}
+	return v
+}
+
+// isolatedStore holds what the syncs of isolated requests write. It stays
+// in memory: the achievements, global_percent, user_* and meta tables are
+// what production answers and localPct read, so an isolated sync never
+// touches them. Entries older than isolatedStoreMaxAge are dropped, and at
+// most isolatedCacheMaxEntries are kept, oldest out first.
+type isolatedStore struct {
+	mu     sync.Mutex
+	global map[string]*isolatedGlobal // by namespace
+	users  map[isolatedUserKey]*isolatedUser
+}
+
+const isolatedStoreMaxAge = 10 * isolatedCacheTTL
+
+type isolatedGlobal struct {
+	syncedAt time.Time
+	items    []Achievement // with their global percentages
+}
+
+type isolatedUserKey struct {
+	ns      string
+	steamID SteamID
+}
+
+type isolatedUser struct {
+	syncedAt     time.Time
+	profile      UserProfile
+	games        []GameCompletion
+	achievements map[AppID][]Achievement
+}
+
+func newIsolatedStore() *isolatedStore {
+	return &isolatedStore{global: make(map[string]*isolatedGlobal), users: make(map[isolatedUserKey]*isolatedUser)}
+}
+
+func (st *isolatedStore) globalOf(ns string) (isolatedGlobal, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	g, ok := st.global[ns]
+	if !ok {
+		return isolatedGlobal{}, false
+	}
+	return *g, true
+}
+
+func (st *isolatedStore) setGlobal(ns string, items []Achievement, now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.global[ns] = &isolatedGlobal{syncedAt: now, items: items}
+	st.pruneLocked(now)
+}
+
+func (st *isolatedStore) setGlobalSyncedAt(ns string, t time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if g, ok := st.global[ns]; ok {
+		g.syncedAt = t
+		return
+	}
+	st.global[ns] = &isolatedGlobal{syncedAt: t}
+	st.pruneLocked(t)
+}
+
+func (st *isolatedStore) user(ns string, steamID SteamID) (*isolatedUser, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	u, ok := st.users[isolatedUserKey{ns, steamID}]
+	return u, ok
+}
+
+// setUser replaces the data of steamID in ns; u is not modified afterwards.
+func (st *isolatedStore) setUser(ns string, steamID SteamID, u *isolatedUser) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.users[isolatedUserKey{ns, steamID}] = u
+	st.pruneLocked(u.syncedAt)
+}
+
+func (st *isolatedStore) pruneLocked(now time.Time) {
+	for ns, g := range st.global {
+		if now.Sub(g.syncedAt) > isolatedStoreMaxAge {
+			delete(st.global, ns)
+		}
+	}
+	for k, u := range st.users {
+		if now.Sub(u.syncedAt) > isolatedStoreMaxAge {
+			delete(st.users, k)
+		}
+	}
+	for len(st.global)+len(st.users) > isolatedCacheMaxEntries {
+		var oldest time.Time
+		oldestNS, oldestUser := "", isolatedUserKey{}
+		for ns, g := range st.global {
+			if oldest.IsZero() || g.syncedAt.Before(oldest) {
+				oldest, oldestNS, oldestUser = g.syncedAt, ns, isolatedUserKey{}
+			}
+		}
+		for k, u := range st.users {
+			if oldest.IsZero() || u.syncedAt.Before(oldest) {
+				oldest, oldestNS, oldestUser = u.syncedAt, "", k
+			}
+		}
+		if oldestUser.ns != "" {
+			delete(st.users, oldestUser)
+		} else {
+			delete(st.global, oldestNS)
+		}
+	}
+}
+
+func (s *Server) cacheTTLFor(ctx context.Context, ttl time.Duration) time.Duration {
+	if cacheNamespace(ctx) != "" && ttl > isolatedCacheTTL {
+		return isolatedCacheTTL
+	}
+	return ttl
+}
+
+// evictIsolatedEntriesLocked keeps isolated entries under the cap by
+// dropping the oldest ones. Callers must hold cacheMu for writing.
+func (s *Server) evictIsolatedEntriesLocked() {
+	for {
+		count := 0
+		var oldestKey CacheKey
+		var oldestAt time.Time
+		var oldestInSchema bool
+		for k, e := range s.appSchemaCache {
+			if k.NS == "" {
+				continue
+			}
+			count++
+			if oldestAt.IsZero() || e.fetchedAt.Before(oldestAt) {
+				oldestKey, oldestAt, oldestInSchema = k, e.fetchedAt, true
+			}
+		}
+		for k, e := range s.appGlobalPctMap {
+			if k.NS == "" {
+				continue
+			}
+			count++
+			if oldestAt.IsZero() || e.fetchedAt.Before(oldestAt) {
+				oldestKey, oldestAt, oldestInSchema = k, e.fetchedAt, false
+			}
+		}
+		if count < isolatedCacheMaxEntries {
+			return
+		}
+		if oldestInSchema {
+			delete(s.appSchemaCache, oldestKey)
+		} else {
+			delete(s.appGlobalPctMap, oldestKey)
+		}
+	}
+}
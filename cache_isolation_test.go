@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// isolationCtx is the context a request with X-Cache-Isolation: ns gets
+// through the middleware of s ("" sends no header).
+func isolationCtx(s *Server, ns string) (context.Context, *http.Request) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements?sort=pct&limit=10", nil)
+	if ns != "" {
+		r.Header.Set(cacheIsolationHeader, ns)
+	}
+	var got *http.Request
+	s.withCacheIsolation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r })).ServeHTTP(httptest.NewRecorder(), r)
+	return got.Context(), got
+}
+
+func cacheKeysOf(ctx context.Context, r *http.Request) []string {
+	return []string{
+		schemaCacheKey(ctx, 105600, "french").String(),
+		globalPctCacheKey(ctx, 105600).String(),
+		syncFetchKey(ctx, "french"),
+		encodedResponseKey(ctx, r, achievementQuery{profile: profileFull, format: "json"}),
+	}
+}
+
+func TestCacheKeysIgnoreHeaderOutsideTestMode(t *testing.T) {
+	s := &Server{testMode: false}
+	plainCtx, plain := isolationCtx(s, "")
+	headerCtx, withHeader := isolationCtx(s, "run-42")
+	if ns := cacheNamespace(headerCtx); ns != "" {
+		t.Fatalf("namespace %q outside TEST_MODE", ns)
+	}
+	want, got := cacheKeysOf(plainCtx, plain), cacheKeysOf(headerCtx, withHeader)
+	for i := range want {
+		if string([]byte(got[i])) != want[i] || len(got[i]) != len(want[i]) {
+			t.Errorf("key %d with the header = %q, without = %q", i, got[i], want[i])
+		}
+	}
+
+	s.testMode = true
+	isoCtx, iso := isolationCtx(s, "run-42")
+	if cacheNamespace(isoCtx) != "run-42" {
+		t.Fatalf("TEST_MODE namespace = %q", cacheNamespace(isoCtx))
+	}
+	for i, k := range cacheKeysOf(isoCtx, iso) {
+		if k == want[i] {
+			t.Errorf("key %d is shared in TEST_MODE: %q", i, k)
+		}
+	}
+}
+
+func TestSanitizeCacheNamespace(t *testing.T) {
+	for in, want := range map[string]string{
+		"run-42":                  "run-42",
+		" ci_1.a ":                "ci_1.a",
+		"a/b":                     "",
+		"a:b":                     "",
+		"":                        "",
+		"é":                       "",
+		string(make([]byte, 100)): "",
+	} {
+		if got := sanitizeCacheNamespace(in); got != want {
+			t.Errorf("sanitizeCacheNamespace(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+const (
+	fakeSchemaJSON = `{"game":{"availableGameStats":{"achievements":[
+		{"name":"A","displayName":"Facile","description":"d","icon":"","icongray":"","hidden":0},
+		{"name":"B","displayName":"Dur","description":"d","icon":"","icongray":"","hidden":1}]}}}`
+	fakePctJSON     = `{"achievementpercentages":{"achievements":[{"name":"A","percent":80},{"name":"B","percent":2.5}]}}`
+	fakeSummaryJSON = `{"response":{"players":[{"personaname":"Testeur","avatarfull":"https://example.org/a.jpg"}]}}`
+	fakeOwnedJSON   = `{"response":{"games":[{"appid":105600,"name":"Terraria","playtime_forever":60}]}}`
+	fakeStatsJSON   = `{"playerstats":{"success":true,"achievements":[{"name":"A","achieved":1,"unlocktime":1700000000},{"name":"B","achieved":0,"unlocktime":0}]}}`
+)
+
+func fakeSteamGame(t *testing.T) *fakeSteam {
+	f := useFakeSteam(t)
+	f.handle("GetSchemaForGame", http.StatusOK, fakeSchemaJSON)
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, fakePctJSON)
+	f.handle("GetPlayerSummaries", http.StatusOK, fakeSummaryJSON)
+	f.handle("GetOwnedGames", http.StatusOK, fakeOwnedJSON)
+	f.handle("GetUserStatsForGame", http.StatusOK, fakeStatsJSON)
+	return f
+}
+
+func countRows(t *testing.T, s *Server, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// An isolated global sync is served back to its namespace only and writes
+// none of the tables production answers read.
+func TestIsolatedGlobalSyncStaysInMemory(t *testing.T) {
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	s.testMode = true
+	iso, _ := isolationCtx(s, "run-1")
+
+	if err := s.runSteamSync(iso, defaultLang); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{`SELECT COUNT(*) FROM achievements`, `SELECT COUNT(*) FROM global_percent`, `SELECT COUNT(*) FROM meta WHERE key GLOB 'last_sync*'`} {
+		if n := countRows(t, s, q); n != 0 {
+			t.Errorf("%s = %d after an isolated sync", q, n)
+		}
+	}
+	if last, _ := s.lastSyncAt(context.Background()); !last.IsZero() {
+		t.Errorf("production last sync = %v after an isolated sync", last)
+	}
+	if last, _ := s.lastSyncAt(iso); last.IsZero() {
+		t.Errorf("isolated last sync not recorded")
+	}
+	items, err := s.readGlobalAchievements(iso)
+	if err != nil || len(items) != 2 || items[0].GlobalPct != 80 || items[1].GlobalPct != 2.5 {
+		t.Fatalf("isolated list = %+v, %v", items, err)
+	}
+	other, _ := isolationCtx(s, "run-2")
+	if items, _ := s.readGlobalAchievements(other); len(items) != 0 {
+		t.Errorf("another namespace sees %d achievements", len(items))
+	}
+
+	if err := s.runSteamSync(context.Background(), defaultLang); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM achievements`); n != 2 {
+		t.Errorf("production sync stored %d achievements", n)
+	}
+}
+
+// An isolated player sync keeps the player's rows out of user_* and
+// user_meta, so localPct and the public answers never count it.
+func TestIsolatedUserSyncStaysInMemory(t *testing.T) {
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	s.testMode = true
+	iso, _ := isolationCtx(s, "run-1")
+	const player SteamID = 76561197960287930
+
+	if err := s.syncUserData(iso, player, "french"); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{`SELECT COUNT(*) FROM user_achievements`, `SELECT COUNT(*) FROM user_games`, `SELECT COUNT(*) FROM user_meta`} {
+		if n := countRows(t, s, q); n != 0 {
+			t.Errorf("%s = %d after an isolated sync", q, n)
+		}
+	}
+	if st, err := s.localStatsFor(105600); err != nil || st.sample != 0 {
+		t.Errorf("localPct sample = %+v, %v after an isolated sync", st, err)
+	}
+
+	games, err := s.readUserGamesFromDB(iso, player)
+	if err != nil || len(games) != 1 || games[0].UnlockedAchievements != 1 || games[0].CompletionPct != 50 {
+		t.Fatalf("isolated games = %+v, %v", games, err)
+	}
+	items, err := s.readUserAchievementsFromDB(iso, player, 105600)
+	if err != nil || len(items) != 2 || !items[0].Achieved || items[0].APIName != "A" || items[0].UnlockTime != 1700000000 {
+		t.Fatalf("isolated achievements = %+v, %v", items, err)
+	}
+	if p, _ := s.readUserProfileFromDB(iso, player); p.DisplayName != "Testeur" {
+		t.Errorf("isolated profile = %+v", p)
+	}
+	if games, _ := s.readUserGamesFromDB(context.Background(), player); len(games) != 0 {
+		t.Errorf("production reads %d games of an isolated sync", len(games))
+	}
+
+	if err := s.syncUserData(context.Background(), player, "french"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM user_achievements WHERE steam_id=?`, player); n != 2 {
+		t.Errorf("production sync stored %d achievements", n)
+	}
+	prod, err := s.readUserAchievementsFromDB(context.Background(), player, 105600)
+	if err != nil || len(prod) != 2 || prod[0].APIName != items[0].APIName || prod[0].GlobalPct != items[0].GlobalPct {
+		t.Errorf("production achievements = %+v, %v; want the isolated order %+v", prod, err, items)
+	}
+}
+
+func TestIsolatedStoreBounds(t *testing.T) {
+	st := newIsolatedStore()
+	base := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < isolatedCacheMaxEntries+5; i++ {
+		st.setUser("ns", SteamID(i+1), &isolatedUser{syncedAt: base.Add(time.Duration(i) * time.Second)})
+	}
+	if n := len(st.users); n != isolatedCacheMaxEntries {
+		t.Fatalf("%d isolated users kept, want %d", n, isolatedCacheMaxEntries)
+	}
+	if _, ok := st.user("ns", 1); ok {
+		t.Errorf("oldest isolated user kept past the cap")
+	}
+	st.setGlobal("late", nil, base.Add(isolatedStoreMaxAge+(isolatedCacheMaxEntries+10)*time.Second))
+	if len(st.users) != 0 || len(st.global) != 1 {
+		t.Errorf("entries older than %v kept: %d users, %d lists", isolatedStoreMaxAge, len(st.users), len(st.global))
+	}
+}
+
+func TestMigrationDropsIsolatedLastSync(t *testing.T) {
+	s := newTestServer(t)
+	for _, q := range []string{
+		`INSERT INTO meta(key, value) VALUES('last_sync', '1'), ('last_sync:run-1', '2'), ('last_syncx', '3')`,
+		`INSERT INTO user_meta(steam_id, key, value) VALUES('76561197960287930', 'last_sync', '1'), ('76561197960287930', 'last_sync:run-1', '2')`,
+	} {
+		if _, err := s.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var step func(*sql.Tx) error
+	for _, m := range dbMigrations {
+		if m.name == "drop isolated last_sync rows" {
+			step = m.up
+		}
+	}
+	if step == nil {
+		t.Fatal("migration not found")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := step(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM meta WHERE key LIKE 'last_sync%'`); n != 2 {
+		t.Errorf("%d meta rows left, want last_sync and last_syncx", n)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM user_meta`); n != 1 {
+		t.Errorf("%d user_meta rows left, want last_sync", n)
+	}
+}
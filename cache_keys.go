@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cacheKeyVersion is part of every cache key. Bump it when the shape of a
+// cached value changes: entries persisted under the old version are then
+// ignored instead of being decoded into the new shape.
+const cacheKeyVersion = 1
+
+const (
+	cacheKindSchema    = "ach"
+	cacheKindGlobalPct = "pct"
+	cacheKindStatus    = "status"
+)
+
+// CacheKey identifies one cache entry. Its canonical form,
+// "ach:v1:105600:french" (plus ":ns=<namespace>" for isolated test
+// entries), is what the snapshot file and the admin endpoints use; values
+// without a language are written "-". It is comparable and used as the map
+// key of the in-memory caches.
+type CacheKey struct {
+	Kind  string
+	AppID AppID
+	Lang  string
+	NS    string
+}
+
+func schemaCacheKey(ctx context.Context, appID AppID, lang string) CacheKey {
+	return CacheKey{Kind: cacheKindSchema, AppID: appID, Lang: lang, NS: cacheNamespace(ctx)}
+}
+
+func globalPctCacheKey(ctx context.Context, appID AppID) CacheKey {
+	return CacheKey{Kind: cacheKindGlobalPct, AppID: appID, NS: cacheNamespace(ctx)}
+}
+
+func statusCacheKey(appID AppID) CacheKey {
+	return CacheKey{Kind: cacheKindStatus, AppID: appID}
+}
+
+func (k CacheKey) String() string { return k.format(cacheKeyVersion) }
+
+// format is the canonical form of k under the given key version.
+func (k CacheKey) format(version int) string {
+	lang := k.Lang
+	if lang == "" {
+		lang = "-"
+	}
+	s := k.Kind + ":v" + strconv.Itoa(version) + ":" + k.AppID.String() + ":" + lang
+	if k.NS != "" {
+		s += ":ns=" + k.NS
+	}
+	return s
+}
+
+func (k CacheKey) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+func (k *CacheKey) UnmarshalText(b []byte) error {
+	v, err := parseCacheKey(string(b))
+	if err != nil {
+		return err
+	}
+	*k = v
+	return nil
+}
+
+// parseCacheKey reverses String. Keys of another cacheKeyVersion are
+// rejected.
+func parseCacheKey(raw string) (CacheKey, error) { return parseCacheKeyVersion(raw, cacheKeyVersion) }
+
+func parseCacheKeyVersion(raw string, version int) (CacheKey, error) {
+	parts := strings.Split(raw, ":")
+	if len(parts) != 4 && len(parts) != 5 {
+		return CacheKey{}, fmt.Errorf("cache key %q: want kind:vN:appid:lang[:ns=...]", raw)
+	}
+	var k CacheKey
+	switch parts[0] {
+	case cacheKindSchema, cacheKindGlobalPct, cacheKindStatus:
+		k.Kind = parts[0]
+	default:
+		return CacheKey{}, fmt.Errorf("cache key %q: unknown kind %q", raw, parts[0])
+	}
+	if parts[1] != "v"+strconv.Itoa(version) {
+		return CacheKey{}, fmt.Errorf("cache key %q: version %s, current is v%d", raw, parts[1], version)
+	}
+	appID, err := parseAppID(parts[2])
+	if err != nil {
+		return CacheKey{}, fmt.Errorf("cache key %q: %w", raw, err)
+	}
+	k.AppID = appID
+	if parts[3] != "-" {
+		k.Lang = parts[3]
+	}
+	if len(parts) == 5 {
+		ns, ok := strings.CutPrefix(parts[4], "ns=")
+		if !ok || ns == "" || sanitizeCacheNamespace(ns) != ns {
+			return CacheKey{}, fmt.Errorf("cache key %q: bad namespace", raw)
+		}
+		k.NS = ns
+	}
+	return k, nil
+}
+
+// cacheKeys lists every in-memory key, sorted.
+func (s *Server) cacheKeys() []CacheKey {
+	s.cacheMu.RLock()
+	keys := make([]CacheKey, 0, len(s.appSchemaCache)+len(s.appGlobalPctMap)+len(s.appStatusCache))
+	for k := range s.appSchemaCache {
+		keys = append(keys, k)
+	}
+	for k := range s.appGlobalPctMap {
+		keys = append(keys, k)
+	}
+	for k := range s.appStatusCache {
+		keys = append(keys, k)
+	}
+	s.cacheMu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+// handleAdminCacheKeys lists the canonical cache keys, optionally limited
+// to those starting with ?prefix=.
+func (s *Server) handleAdminCacheKeys(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	out := make([]string, 0)
+	for _, k := range s.cacheKeys() {
+		if str := k.String(); strings.HasPrefix(str, prefix) {
+			out = append(out, str)
+		}
+	}
+	writeJSON(w, map[string]any{"version": cacheKeyVersion, "keys": out})
+}
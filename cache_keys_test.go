@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// featureCacheKeys are the keys every cache feature builds, plain and in an
+// isolated namespace.
+func featureCacheKeys(t *testing.T) []CacheKey {
+	t.Helper()
+	isoCtx, _ := isolationCtx(&Server{testMode: true}, "run-42")
+	if cacheNamespace(isoCtx) == "" {
+		t.Fatal("no namespace in TEST_MODE")
+	}
+	var keys []CacheKey
+	for _, ctx := range []context.Context{context.Background(), isoCtx} {
+		keys = append(keys,
+			schemaCacheKey(ctx, 105600, "french"),
+			schemaCacheKey(ctx, 440, "schinese"),
+			globalPctCacheKey(ctx, 105600),
+		)
+	}
+	return append(keys, statusCacheKey(105600), statusCacheKey(4294967295))
+}
+
+func TestCacheKeyRoundTrip(t *testing.T) {
+	for _, k := range featureCacheKeys(t) {
+		got, err := parseCacheKey(k.String())
+		if err != nil || got != k {
+			t.Errorf("parseCacheKey(%q) = %+v, %v; want %+v", k, got, err, k)
+		}
+		// The snapshot file stores keys as text.
+		b, err := json.Marshal(map[string]CacheKey{"key": k})
+		var back map[string]CacheKey
+		if err == nil {
+			err = json.Unmarshal(b, &back)
+		}
+		if err != nil || back["key"] != k {
+			t.Errorf("JSON round trip of %q: %s, %v", k, b, err)
+		}
+	}
+	if got := schemaCacheKey(context.Background(), 105600, "french").String(); got != "ach:v"+strconv.Itoa(cacheKeyVersion)+":105600:french" {
+		t.Errorf("schema key %q", got)
+	}
+	if got := globalPctCacheKey(context.Background(), 105600).String(); !strings.HasSuffix(got, ":105600:-") {
+		t.Errorf("percentages key %q, want the language written -", got)
+	}
+}
+
+// Bumping cacheKeyVersion renames every key, and keys of the old version
+// no longer parse.
+func TestCacheKeyVersionBump(t *testing.T) {
+	next := cacheKeyVersion + 1
+	for _, k := range featureCacheKeys(t) {
+		bumped := k.format(next)
+		if bumped == k.String() {
+			t.Errorf("%q unchanged by a version bump", k)
+		}
+		if got, err := parseCacheKeyVersion(bumped, next); err != nil || got != k {
+			t.Errorf("parse %q under v%d = %+v, %v", bumped, next, got, err)
+		}
+		if _, err := parseCacheKeyVersion(k.String(), next); err == nil {
+			t.Errorf("%q accepted after the bump", k)
+		}
+	}
+}
+
+func TestParseCacheKeyInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"", "ach", "ach:v1:105600", "ach:v1:105600:french:ns=a:b",
+		"foo:v1:105600:french", "ach:1:105600:french", "ach:v0:105600:french",
+		"ach:v1:0:french", "ach:v1:abc:french", "ach:v1:105600:french:run-42",
+		"ach:v1:105600:french:ns=", "ach:v1:105600:french:ns=a b",
+	} {
+		if k, err := parseCacheKey(raw); err == nil {
+			t.Errorf("parseCacheKey(%q) = %+v, want an error", raw, k)
+		}
+	}
+}
+
+func TestAdminCacheKeys(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	s.storeSchema(schemaCacheKey(context.Background(), 105600, "french"), []Achievement{{APIName: "A"}}, now)
+	s.storeSchema(schemaCacheKey(context.Background(), 440, "english"), []Achievement{{APIName: "A"}}, now)
+	s.storeGlobalPercentages(globalPctCacheKey(context.Background(), 440), map[string]float64{"A": 1}, now)
+
+	for _, tt := range []struct{ prefix, want string }{
+		{"", "[ach:v1:105600:french ach:v1:440:english pct:v1:440:-]"},
+		{"ach:", "[ach:v1:105600:french ach:v1:440:english]"},
+		{"pct:v1:440", "[pct:v1:440:-]"},
+		{"status:", "[]"},
+	} {
+		w := httptest.NewRecorder()
+		s.handleAdminCacheKeys(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/cache/keys?prefix="+tt.prefix, nil))
+		var body struct {
+			Version int      `json:"version"`
+			Keys    []string `json:"keys"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Version != cacheKeyVersion || body.Keys == nil || fmt.Sprint(body.Keys) != tt.want {
+			t.Errorf("?prefix=%s: %s, %v; want keys %s", tt.prefix, w.Body, err, tt.want)
+		}
+	}
+}
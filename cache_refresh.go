@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stale-while-revalidate: when Steam fails, the cached functions keep
+// serving an expired entry instead of erroring, and the handler flags the
+// response (X-Data-Stale, X-Cache: stale and a Warning header); only a
+// request with nothing cached still fails. To keep visitors out of the
+// slow path, the refresher renews the global list and the apps recently
+// served by /achievements?appId= once they reach 11/12 of their TTL, 5h30
+// of the 6h default, and writes the cache snapshot (CACHE_FILE) when it
+// changed, so a restart while Steam is down still has data.
+//
+// An entry past its TTL but younger than TTL + STALE_WHILE_REVALIDATE (24h)
+// is served at once, flagged stale, while one background fetch renews it;
+// only older entries make the request wait for Steam. 0 turns this off.
+const (
+	defaultCacheFile            = "cache.json"
+	defaultCacheRefreshInterval = time.Minute
+	cacheRefreshAhead           = 11.0 / 12
+	defaultStaleWindow          = 24 * time.Hour
+)
+
+type staleCtxKey struct{}
+
+// withStaleTracking returns a context in which the cached functions report
+// serving stale data.
+func withStaleTracking(ctx context.Context) (context.Context, *atomic.Bool) {
+	stale := new(atomic.Bool)
+	return context.WithValue(ctx, staleCtxKey{}, stale), stale
+}
+
+func markStale(ctx context.Context) {
+	if stale, ok := ctx.Value(staleCtxKey{}).(*atomic.Bool); ok {
+		stale.Store(true)
+	}
+}
+
+func writeStaleHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Data-Stale", "1")
+	w.Header().Set("X-Cache", "stale")
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+}
+
+func staleWindowFromEnv() time.Duration {
+	v := strings.TrimSpace(getenv("STALE_WHILE_REVALIDATE", ""))
+	if v == "" {
+		return defaultStaleWindow
+	}
+	if v == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("STALE_WHILE_REVALIDATE=%q ignored, using %s", v, defaultStaleWindow)
+		return defaultStaleWindow
+	}
+	return d
+}
+
+// revalidates reports whether an entry fetched at fetchedAt, past ttl, is
+// served while it is renewed in the background.
+func (s *Server) revalidates(fetchedAt time.Time, ttl time.Duration) bool {
+	window := s.cfg().StaleWindow
+	return window > 0 && !fetchedAt.IsZero() && time.Since(fetchedAt) <= ttl+window
+}
+
+// revalidate runs fetch for key in the background, shared with any caller
+// fetching key at the same time; what names it in the logs.
+func (s *Server) revalidate(ctx context.Context, key, what string, fetch func(ctx context.Context) (any, error)) {
+	s.debugf("%s: stale, refreshing in the background", what)
+	go func() {
+		if _, err := s.fetches.do(context.WithoutCancel(ctx), key, fetch); err != nil {
+			log.Printf("%s: background refresh: %v", what, err)
+		}
+	}()
+}
+
+type cacheRefresher struct {
+	s        *Server
+	interval time.Duration
+
+	mu     sync.Mutex
+	served map[CacheKey]time.Time // schema keys, last served
+}
+
+func newCacheRefresher(s *Server, interval time.Duration) *cacheRefresher {
+	return &cacheRefresher{s: s, interval: interval, served: make(map[CacheKey]time.Time)}
+}
+
+// watch keeps appID in lang fresh while it is being asked for.
+func (cr *cacheRefresher) watch(ctx context.Context, appID AppID, lang string) {
+	key := schemaCacheKey(ctx, appID, lang)
+	if key.NS != "" {
+		return
+	}
+	cr.mu.Lock()
+	cr.served[key] = time.Now()
+	cr.mu.Unlock()
+}
+
+func (cr *cacheRefresher) run(ctx context.Context) {
+	t := time.NewTicker(cr.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			cr.runOnce(ctx)
+		}
+	}
+}
+
+func (cr *cacheRefresher) runOnce(ctx context.Context) {
+	s := cr.s
+	if s.readOnly() {
+		return
+	}
+	cfg := s.cfg()
+	if last, err := s.lastSyncAt(ctx); err == nil && !last.IsZero() && time.Since(last) > refreshAge(cfg.CacheTTL) {
+		if err := s.syncFromSteam(ctx, defaultLang); err != nil {
+			log.Printf("cache refresh: global list: %v", err)
+		}
+	}
+
+	for _, key := range cr.due(cfg.AppMetaCacheTTL) {
+		cr.refreshApp(ctx, key, cfg.AppMetaCacheTTL)
+	}
+
+	if s.cacheDirty.Swap(false) {
+		if err := s.saveCacheSnapshot(); err != nil {
+			log.Printf("cache snapshot save: %v", err)
+		}
+	}
+}
+
+func refreshAge(ttl time.Duration) time.Duration {
+	return time.Duration(float64(ttl) * cacheRefreshAhead)
+}
+
+// due lists the watched keys, forgetting the ones not served for a TTL.
+func (cr *cacheRefresher) due(ttl time.Duration) []CacheKey {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	out := make([]CacheKey, 0, len(cr.served))
+	for key, at := range cr.served {
+		if time.Since(at) > ttl {
+			delete(cr.served, key)
+			continue
+		}
+		out = append(out, key)
+	}
+	return out
+}
+
+func (cr *cacheRefresher) refreshApp(ctx context.Context, key CacheKey, ttl time.Duration) {
+	s := cr.s
+	src := s.sourceFor(key.AppID)
+	now := time.Now()
+
+	s.cacheMu.RLock()
+	schema, hasSchema := s.appSchemaCache[key]
+	pcts, hasPcts := s.appGlobalPctMap[globalPctCacheKey(ctx, key.AppID)]
+	s.cacheMu.RUnlock()
+
+	if hasSchema && now.Sub(schema.fetchedAt) > refreshAge(ttl) {
+		if items, err := src.FetchSchema(ctx, key.AppID, key.Lang); err != nil {
+			log.Printf("cache refresh: schema app %d (%s): %v", key.AppID, key.Lang, err)
+		} else {
+			s.storeSchema(key, items, now)
+		}
+	}
+	if hasPcts && now.Sub(pcts.fetchedAt) > refreshAge(ttl) {
+		if items, err := src.FetchPercentages(ctx, key.AppID); err != nil {
+			log.Printf("cache refresh: global pct app %d: %v", key.AppID, err)
+		} else {
+			s.storeGlobalPercentages(globalPctCacheKey(ctx, key.AppID), items, now)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Binary snapshot layout (CACHE_CODEC=binary):
+//
+//	magic "YBCS" | version u8 | payload length u32 BE | crc32 (IEEE) u32 BE | gob payload
+//
+// Files that do not start with the magic bytes are read as JSON, so
+// snapshots written by the JSON codec keep loading after switching codecs.
+const cacheSnapshotMagic = "YBCS"
+const cacheSnapshotVersion = 1
+const cacheSnapshotHeaderLen = len(cacheSnapshotMagic) + 1 + 4 + 4
+
+const (
+	cacheCodecJSON   = "json"
+	cacheCodecBinary = "binary"
+)
+
+var errCacheSnapshotChecksum = errors.New("cache snapshot checksum mismatch")
+
+type cacheSnapshot struct {
+	SavedAt    time.Time             `json:"savedAt"`
+	Schemas    []schemaSnapshotEntry `json:"schemas"`
+	GlobalPcts []pctSnapshotEntry    `json:"globalPcts"`
+}
+
+// Key is the canonical CacheKey; snapshots written before it existed only
+// carry AppID and load as French schemas.
+type schemaSnapshotEntry struct {
+	Key       string        `json:"key,omitempty"`
+	AppID     AppID         `json:"appId"`
+	Items     []Achievement `json:"items"`
+	FetchedAt time.Time     `json:"fetchedAt"`
+}
+
+type pctSnapshotEntry struct {
+	Key       string             `json:"key,omitempty"`
+	AppID     AppID              `json:"appId"`
+	Items     map[string]float64 `json:"items"`
+	FetchedAt time.Time          `json:"fetchedAt"`
+}
+
+func encodeCacheSnapshot(snap *cacheSnapshot, codec string) ([]byte, error) {
+	switch codec {
+	case cacheCodecJSON:
+		return json.Marshal(snap)
+	case cacheCodecBinary:
+		var payload bytes.Buffer
+		if err := gob.NewEncoder(&payload).Encode(snap); err != nil {
+			return nil, err
+		}
+		out := make([]byte, cacheSnapshotHeaderLen, cacheSnapshotHeaderLen+payload.Len())
+		copy(out, cacheSnapshotMagic)
+		out[4] = cacheSnapshotVersion
+		binary.BigEndian.PutUint32(out[5:9], uint32(payload.Len()))
+		binary.BigEndian.PutUint32(out[9:13], crc32.ChecksumIEEE(payload.Bytes()))
+		return append(out, payload.Bytes()...), nil
+	}
+	return nil, fmt.Errorf("unknown cache codec %q", codec)
+}
+
+func decodeCacheSnapshot(data []byte) (*cacheSnapshot, error) {
+	var snap cacheSnapshot
+	if !bytes.HasPrefix(data, []byte(cacheSnapshotMagic)) {
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("json cache snapshot: %w", err)
+		}
+		return &snap, nil
+	}
+
+	if len(data) < cacheSnapshotHeaderLen {
+		return nil, errors.New("binary cache snapshot: truncated header")
+	}
+	if v := data[4]; v != cacheSnapshotVersion {
+		return nil, fmt.Errorf("binary cache snapshot: unsupported version %d", v)
+	}
+	size := binary.BigEndian.Uint32(data[5:9])
+	payload := data[cacheSnapshotHeaderLen:]
+	if uint32(len(payload)) != size {
+		return nil, errors.New("binary cache snapshot: truncated payload")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[9:13]) {
+		return nil, errCacheSnapshotChecksum
+	}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("binary cache snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// snapshotCaches copies the shared (non-isolated) app caches.
+func (s *Server) snapshotCaches() *cacheSnapshot {
+	snap := &cacheSnapshot{SavedAt: time.Now()}
+
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	for k, e := range s.appSchemaCache {
+		if k.NS == "" {
+			snap.Schemas = append(snap.Schemas, schemaSnapshotEntry{Key: k.String(), AppID: k.AppID, Items: e.items, FetchedAt: e.fetchedAt})
+		}
+	}
+	for k, e := range s.appGlobalPctMap {
+		if k.NS == "" {
+			snap.GlobalPcts = append(snap.GlobalPcts, pctSnapshotEntry{Key: k.String(), AppID: k.AppID, Items: e.items, FetchedAt: e.fetchedAt})
+		}
+	}
+	return snap
+}
+
+// cacheFileFromEnv is CACHE_FILE, cache.json by default; "off" disables
+// the snapshot.
+func cacheFileFromEnv() string {
+	v := strings.TrimSpace(getenv("CACHE_FILE", defaultCacheFile))
+	if strings.EqualFold(v, "off") {
+		return ""
+	}
+	return v
+}
+
+// defaultCacheSnapshotDelay is CACHE_SNAPSHOT_DELAY: the snapshot write
+// after a player sync waits that long, so a burst of syncs writes it once.
+const defaultCacheSnapshotDelay = 10 * time.Second
+
+// scheduleCacheSnapshot saves the snapshot in the background,
+// snapshotDelay after the first call of a burst, if the caches changed.
+// The refresher and the shutdown save it too; a timer finding it saved
+// meanwhile does nothing.
+func (s *Server) scheduleCacheSnapshot() {
+	if s.cacheFile == "" || !s.cacheDirty.Load() || !s.snapshotPending.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(s.snapshotDelay, func() {
+		s.snapshotPending.Store(false)
+		if !s.cacheDirty.Load() {
+			return
+		}
+		if err := s.saveCacheSnapshot(); err != nil {
+			log.Printf("cache snapshot save: %v", err)
+		}
+	})
+}
+
+func (s *Server) saveCacheSnapshot() error {
+	if s.cacheFile == "" {
+		return nil
+	}
+	// Two writers could otherwise rename an older snapshot over a newer one.
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	s.cacheDirty.Store(false)
+	data, err := encodeCacheSnapshot(s.snapshotCaches(), s.cacheCodec)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.cacheFile), ".cache-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.cacheFile)
+}
+
+func (s *Server) loadCacheSnapshot() error {
+	if s.cacheFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.cacheFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snap, err := decodeCacheSnapshot(data)
+	if err != nil {
+		return err
+	}
+
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	skipped := 0
+	for _, e := range snap.Schemas {
+		key, ok := snapshotKey(e.Key, CacheKey{Kind: cacheKindSchema, AppID: e.AppID, Lang: "french"}, cacheKindSchema)
+		if !ok {
+			skipped++
+			continue
+		}
+		s.appSchemaCache[key] = appSchemaCacheEntry{items: e.Items, fetchedAt: e.FetchedAt}
+	}
+	for _, e := range snap.GlobalPcts {
+		key, ok := snapshotKey(e.Key, CacheKey{Kind: cacheKindGlobalPct, AppID: e.AppID}, cacheKindGlobalPct)
+		if !ok {
+			skipped++
+			continue
+		}
+		s.appGlobalPctMap[key] = appGlobalPctCacheEntry{items: e.Items, fetchedAt: e.FetchedAt}
+	}
+	log.Printf("cache snapshot loaded from %s (%d schemas, %d percentage sets, %d stale keys skipped)", s.cacheFile, len(snap.Schemas), len(snap.GlobalPcts), skipped)
+	return nil
+}
+
+// snapshotKey resolves a persisted entry's key. Entries from another key
+// version, another kind or an isolated namespace are dropped.
+func snapshotKey(raw string, legacy CacheKey, kind string) (CacheKey, bool) {
+	if raw == "" {
+		return legacy, true
+	}
+	key, err := parseCacheKey(raw)
+	if err != nil {
+		log.Printf("cache snapshot: %v", err)
+		return CacheKey{}, false
+	}
+	return key, key.Kind == kind && key.NS == ""
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// snapshotFixture is a multi-game cache: games apps of the synthetic
+// 5,000 achievement game, each in two languages with its percentages.
+func snapshotFixture(games int) *cacheSnapshot {
+	_, items := syntheticGame()
+	pcts := make(map[string]float64, len(items))
+	for _, a := range items {
+		pcts[a.APIName] = a.GlobalPct
+	}
+	at := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	snap := &cacheSnapshot{SavedAt: at}
+	for i := 0; i < games; i++ {
+		appID := AppID(1000 + i)
+		for _, lang := range []string{defaultLang, fallbackLang} {
+			key := CacheKey{Kind: cacheKindSchema, AppID: appID, Lang: lang}
+			snap.Schemas = append(snap.Schemas, schemaSnapshotEntry{Key: key.String(), AppID: appID, Items: items, FetchedAt: at})
+		}
+		key := CacheKey{Kind: cacheKindGlobalPct, AppID: appID}
+		snap.GlobalPcts = append(snap.GlobalPcts, pctSnapshotEntry{Key: key.String(), AppID: appID, Items: pcts, FetchedAt: at})
+	}
+	return snap
+}
+
+func TestCacheSnapshotCodecs(t *testing.T) {
+	snap := snapshotFixture(2)
+	for _, codec := range []string{cacheCodecJSON, cacheCodecBinary} {
+		data, err := encodeCacheSnapshot(snap, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeCacheSnapshot(data)
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if !reflect.DeepEqual(got, snap) {
+			t.Errorf("%s: round trip changed the snapshot", codec)
+		}
+	}
+	if _, err := encodeCacheSnapshot(snap, "xml"); err == nil {
+		t.Errorf("codec xml accepted")
+	}
+}
+
+func TestCacheSnapshotRejectsDamage(t *testing.T) {
+	data, err := encodeCacheSnapshot(snapshotFixture(1), cacheCodecBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)-10] ^= 0xff
+	if _, err := decodeCacheSnapshot(flipped); !errors.Is(err, errCacheSnapshotChecksum) {
+		t.Errorf("flipped byte: %v, want the checksum error", err)
+	}
+	future := append([]byte(nil), data...)
+	future[4] = cacheSnapshotVersion + 1
+	for name, b := range map[string][]byte{"future version": future, "truncated": data[:len(data)-1], "header only": data[:6]} {
+		if _, err := decodeCacheSnapshot(b); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+
+	// The server starts with an empty cache rather than half a snapshot.
+	s := newTestServer(t)
+	if err := os.WriteFile(s.cacheFile, flipped, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.loadCacheSnapshot(); !errors.Is(err, errCacheSnapshotChecksum) {
+		t.Fatalf("load: %v, want the checksum error", err)
+	}
+	if len(s.appSchemaCache) != 0 || len(s.appGlobalPctMap) != 0 {
+		t.Errorf("damaged snapshot loaded %d schemas", len(s.appSchemaCache))
+	}
+}
+
+// A burst of player syncs writes the snapshot once, after the delay.
+func TestScheduleCacheSnapshot(t *testing.T) {
+	s := newTestServer(t)
+	s.snapshotDelay = 50 * time.Millisecond
+	s.scheduleCacheSnapshot()
+	if s.snapshotPending.Load() {
+		t.Fatalf("write scheduled for unchanged caches")
+	}
+
+	s.storeSchema(CacheKey{Kind: cacheKindSchema, AppID: 440, Lang: defaultLang}, []Achievement{{APIName: "A"}}, time.Now())
+	for i := 0; i < 5; i++ {
+		s.scheduleCacheSnapshot()
+	}
+	if _, err := os.Stat(s.cacheFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("snapshot written before the delay: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.cacheDirty.Load() || s.snapshotPending.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot after the delay")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	snap, err := os.ReadFile(s.cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decodeCacheSnapshot(snap); err != nil || len(got.Schemas) != 1 {
+		t.Errorf("snapshot = %+v, %v", got, err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(s.cacheFile), ".cache-*.tmp")); len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
+
+func BenchmarkCacheSnapshot(b *testing.B) {
+	snap := snapshotFixture(20)
+	for _, codec := range []string{cacheCodecJSON, cacheCodecBinary} {
+		data, err := encodeCacheSnapshot(snap, codec)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("encode/%s", codec), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := encodeCacheSnapshot(snap, codec); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("decode/%s", codec), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeCacheSnapshot(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
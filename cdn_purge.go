@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Edge caches (Fastly, Cloudflare) index responses by the keys below so a
+// refresh of one app purges only what depends on it:
+//
+//	app-<appId>      anything built from the app schema or percentages
+//	lang-<language>  the Steam language of the texts
+//	player-<steamId> anything built from one player's data
+func appSurrogateKey(appID AppID) string  { return "app-" + appID.String() }
+func langSurrogateKey(lang string) string { return "lang-" + lang }
+func playerSurrogateKey(steamID SteamID) string {
+	return "player-" + pseudonyms.steamID(steamID).String()
+}
+
+// setSurrogateKeys sets Surrogate-Key (Fastly, space separated) and
+// Cache-Tag (Cloudflare, comma separated) and returns the keys.
+func setSurrogateKeys(w http.ResponseWriter, keys ...string) []string {
+	w.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+	w.Header().Set("Cache-Tag", strings.Join(keys, ","))
+	return keys
+}
+
+const (
+	cdnProviderFastly     = "fastly"
+	cdnProviderCloudflare = "cloudflare"
+)
+
+var cdnPurgeRetryDelays = []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+// cdnPurger asks the CDN to drop the responses tagged with a key. Purges run
+// in the background so a slow CDN never delays a refresh.
+type cdnPurger struct {
+	provider string
+	endpoint string
+	header   http.Header
+	client   *http.Client
+}
+
+// cdnPurgerFromEnv returns nil (purging disabled) unless CDN_PURGE_PROVIDER
+// and the credentials of that provider are set.
+func cdnPurgerFromEnv() *cdnPurger {
+	provider := strings.ToLower(cleanEnvValue(os.Getenv("CDN_PURGE_PROVIDER")))
+	p := &cdnPurger{provider: provider, header: make(http.Header), client: &http.Client{Timeout: 15 * time.Second}}
+	switch provider {
+	case "":
+		return nil
+	case cdnProviderFastly:
+		service, token := cleanEnvValue(os.Getenv("FASTLY_SERVICE_ID")), cleanEnvValue(os.Getenv("FASTLY_API_TOKEN"))
+		if service == "" || token == "" {
+			log.Printf("CDN_PURGE_PROVIDER=fastly needs FASTLY_SERVICE_ID and FASTLY_API_TOKEN, purging disabled")
+			return nil
+		}
+		p.endpoint = "https://api.fastly.com/service/" + service + "/purge"
+		p.header.Set("Fastly-Key", token)
+	case cdnProviderCloudflare:
+		zone, token := cleanEnvValue(os.Getenv("CLOUDFLARE_ZONE_ID")), cleanEnvValue(os.Getenv("CLOUDFLARE_API_TOKEN"))
+		if zone == "" || token == "" {
+			log.Printf("CDN_PURGE_PROVIDER=cloudflare needs CLOUDFLARE_ZONE_ID and CLOUDFLARE_API_TOKEN, purging disabled")
+			return nil
+		}
+		p.endpoint = "https://api.cloudflare.com/client/v4/zones/" + zone + "/purge_cache"
+		p.header.Set("Authorization", "Bearer "+token)
+	default:
+		log.Printf("unknown CDN_PURGE_PROVIDER=%q, purging disabled", provider)
+		return nil
+	}
+	return p
+}
+
+func (p *cdnPurger) purge(keys ...string) {
+	if p == nil || len(keys) == 0 {
+		return
+	}
+	go func() {
+		for attempt := 0; ; attempt++ {
+			err := p.send(keys)
+			if err == nil {
+				return
+			}
+			log.Printf("cdn purge %s %v (attempt %d): %v", p.provider, keys, attempt+1, err)
+			if attempt >= len(cdnPurgeRetryDelays) {
+				return
+			}
+			time.Sleep(cdnPurgeRetryDelays[attempt])
+		}
+	}()
+}
+
+func (p *cdnPurger) send(keys []string) error {
+	var req *http.Request
+	var err error
+	switch p.provider {
+	case cdnProviderFastly:
+		req, err = http.NewRequest(http.MethodPost, p.endpoint, nil)
+		if err == nil {
+			req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+		}
+	case cdnProviderCloudflare:
+		var body []byte
+		body, err = json.Marshal(map[string][]string{"tags": keys})
+		if err == nil {
+			req, err = http.NewRequest(http.MethodPost, p.endpoint, bytes.NewReader(body))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for k, v := range p.header {
+		req.Header[k] = v
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The text exports (CSV, HTML) honour Accept-Charset: UTF-8 by default,
+// ISO-8859-1 for the legacy consumers that ask for it. Typographic runes
+// latin-1 lacks are folded to their closest form (narrow spaces, curly
+// quotes, dashes, œ...), anything else becomes "?" and is counted in
+// X-Charset-Substitutions. A body that would lose more than
+// latin1MaxSubstituted of its runes is sent in UTF-8 when the client also
+// accepts it, refused with 406 otherwise, rather than sent as a wall of
+// question marks. JSON is always UTF-8.
+const (
+	charsetUTF8   = "utf-8"
+	charsetLatin1 = "iso-8859-1"
+)
+
+const latin1MaxSubstituted = 0.05
+
+const latin1Substitute = '?'
+
+var charsetNames = map[string]string{
+	"utf-8":      charsetUTF8,
+	"utf8":       charsetUTF8,
+	"iso-8859-1": charsetLatin1,
+	"iso8859-1":  charsetLatin1,
+	"iso_8859-1": charsetLatin1,
+	"latin1":     charsetLatin1,
+	"latin-1":    charsetLatin1,
+	"l1":         charsetLatin1,
+}
+
+// latin1Folds values only hold runes below U+0100.
+var latin1Folds = map[rune]string{
+	'\u2002': " ", '\u2003': " ", '\u2009': " ", '\u200a': " ",
+	'\u2007': "\u00a0", '\u202f': "\u00a0",
+	'\u2018': "'", '\u2019': "'", '\u201a': "'",
+	'\u201c': `"`, '\u201d': `"`, '\u201e': `"`,
+	'\u2013': "-", '\u2014': "-", '\u2212': "-",
+	'\u2026': "...", '\u2022': "\u00b7",
+	'\u0152': "OE", '\u0153': "oe", '\u0178': "Y",
+	'\u20ac': "EUR",
+}
+
+func supportedCharsets() []string {
+	return []string{charsetLatin1, charsetUTF8}
+}
+
+// acceptedCharsets lists the charsets of an Accept-Charset header the
+// server can write, most wanted first; without a header, or with "*", it
+// is UTF-8. Empty when the header only lists charsets it cannot write.
+func acceptedCharsets(header string) []string {
+	if strings.TrimSpace(header) == "" {
+		return []string{charsetUTF8}
+	}
+	type candidate struct {
+		charset string
+		q       float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if _, v, found := strings.Cut(strings.ReplaceAll(params, " ", ""), "q="); found {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if name == "*" {
+			candidates = append(candidates, candidate{charsetUTF8, q})
+		} else if cs, ok := charsetNames[name]; ok {
+			candidates = append(candidates, candidate{cs, q})
+		}
+	}
+	// Stable, so equal weights keep the client's order.
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	var out []string
+	for _, c := range candidates {
+		if !slices.Contains(out, c.charset) {
+			out = append(out, c.charset)
+		}
+	}
+	return out
+}
+
+// negotiateCharsets reads Accept-Charset for the text formats; JSON and
+// XLSX get UTF-8 whatever was asked. An unacceptable header is answered 406 and ok
+// is false.
+func negotiateCharsets(w http.ResponseWriter, r *http.Request, format string) ([]string, bool) {
+	if format == "" || format == formatJSON || format == formatXLSX {
+		return []string{charsetUTF8}, true
+	}
+	addVary(w.Header(), "Accept-Charset")
+	charsets := acceptedCharsets(r.Header.Get("Accept-Charset"))
+	if len(charsets) == 0 {
+		writeJSONStatus(w, http.StatusNotAcceptable, map[string]any{
+			"error":     "unsupported_charset",
+			"details":   "Aucun jeu de caracteres accepte n'est disponible. Jeux acceptes: " + strings.Join(supportedCharsets(), ", "),
+			"supported": supportedCharsets(),
+		})
+		return nil, false
+	}
+	return charsets, true
+}
+
+// toLatin1 transcodes UTF-8 b, folding or substituting what latin-1 lacks.
+// substituted counts the runes replaced by latin1Substitute, runes every
+// rune of b.
+func toLatin1(b []byte) (out []byte, substituted, runes int) {
+	out = make([]byte, 0, len(b))
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		b = b[size:]
+		runes++
+		switch fold, ok := latin1Folds[r]; {
+		case ok:
+			for _, f := range fold {
+				out = append(out, byte(f))
+			}
+		case r < 0x100:
+			out = append(out, byte(r))
+		default:
+			out = append(out, latin1Substitute)
+			substituted++
+		}
+	}
+	return out, substituted, runes
+}
+
+// foldLatin1 applies latin1Folds to s. The exports fold their text before
+// encoding it, so a curly quote turned into '"' is escaped like any other.
+func foldLatin1(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if fold, ok := latin1Folds[r]; ok {
+			sb.WriteString(fold)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// withCharset sets the charset parameter of contentType.
+func withCharset(contentType, charset string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	params["charset"] = charset
+	return mime.FormatMediaType(mediaType, params)
+}
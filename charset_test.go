@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const charsetSchemaJSON = `{"game":{"availableGameStats":{"achievements":[
+	{"name":"A","displayName":"Échec élégant","description":"Ça “œuvre” – déjà","icon":"","icongray":"","hidden":0},
+	{"name":"B","displayName":"Fête 🎉","description":"à côté","icon":"","icongray":"","hidden":0}]}}}`
+
+// fromLatin1 reads b as ISO-8859-1, one rune per byte.
+func fromLatin1(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		sb.WriteRune(rune(c))
+	}
+	return sb.String()
+}
+
+func getWithCharset(s *Server, query, acceptCharset string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements"+query, nil)
+	if acceptCharset != "" {
+		r.Header.Set("Accept-Charset", acceptCharset)
+	}
+	w := httptest.NewRecorder()
+	s.handleAchievements(w, r)
+	return w
+}
+
+func TestLatin1Exports(t *testing.T) {
+	f := fakeSteamGame(t)
+	f.handle("GetSchemaForGame", http.StatusOK, charsetSchemaJSON)
+	s := newTestServer(t)
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Twice: the second answer comes from the encoded-response cache.
+	for _, pass := range []string{"miss", "hit"} {
+		w := getWithCharset(s, "?format=csv", "iso-8859-1")
+		body := fromLatin1(w.Body.Bytes())
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=iso-8859-1" {
+			t.Fatalf("%s: %d %v", pass, w.Code, w.Header())
+		}
+		if strings.HasPrefix(body, utf8BOM) || w.Body.Bytes()[0] == 0xef {
+			t.Errorf("%s: latin-1 CSV with a BOM", pass)
+		}
+		// The accents survive, the typography is folded, the emoji is the only loss.
+		for _, want := range []string{"Échec élégant", `Ça ""oeuvre"" - déjà`, "Fête ?", "à côté"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: CSV without %q: %q", pass, want, body)
+			}
+		}
+		if got := w.Header().Get("X-Charset-Substitutions"); got != "1" {
+			t.Errorf("%s: X-Charset-Substitutions %q, want 1", pass, got)
+		}
+		if !slices.Contains(strings.Split(strings.ReplaceAll(w.Header().Get("Vary"), " ", ""), ","), "Accept-Charset") {
+			t.Errorf("%s: Vary %q", pass, w.Header().Get("Vary"))
+		}
+	}
+
+	// UTF-8, asked or by default, is not served the cached latin-1 body.
+	for _, accept := range []string{"", "utf-8", "latin1;q=0.4, utf-8"} {
+		w := getWithCharset(s, "?format=csv", accept)
+		if w.Header().Get("Content-Type") != "text/csv; charset=utf-8" || !strings.HasPrefix(w.Body.String(), utf8BOM) || !strings.Contains(w.Body.String(), "Fête 🎉") {
+			t.Errorf("Accept-Charset %q: %v %q", accept, w.Header(), w.Body)
+		}
+		if w.Header().Get("X-Charset-Substitutions") != "" {
+			t.Errorf("Accept-Charset %q: substitutions counted in UTF-8", accept)
+		}
+	}
+
+	w := getWithCharset(s, "?format=html", "ISO-8859-1, utf-8;q=0.1")
+	if body := fromLatin1(w.Body.Bytes()); w.Header().Get("Content-Type") != "text/html; charset=iso-8859-1" || !strings.Contains(body, `<meta charset="iso-8859-1">`) || !strings.Contains(body, "Échec élégant") {
+		t.Errorf("latin-1 HTML: %v %q", w.Header(), body)
+	}
+
+	// JSON is UTF-8 whatever is asked, even a charset there is no writer for.
+	for _, accept := range []string{"iso-8859-1", "koi8-r"} {
+		w := getWithCharset(s, "", accept)
+		if w.Code != http.StatusOK || !json.Valid(w.Body.Bytes()) || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") || !strings.Contains(w.Body.String(), "Fête 🎉") {
+			t.Errorf("JSON with Accept-Charset %q: %d %.200s", accept, w.Code, w.Body)
+		}
+	}
+
+	w = getWithCharset(s, "?format=csv", "koi8-r, utf-8;q=0")
+	if w.Code != http.StatusNotAcceptable || !strings.Contains(w.Body.String(), "unsupported_charset") {
+		t.Errorf("no writable charset: %d %s", w.Code, w.Body)
+	}
+}
+
+// A body latin-1 mostly cannot hold falls back to UTF-8 when the client
+// takes it, and is refused otherwise.
+func TestLatin1Unrepresentable(t *testing.T) {
+	items := []Achievement{{APIName: "A", Name: "テラリア実績", Description: "完全に日本語の説明"}}
+	for _, tt := range []struct {
+		accept      string
+		code        int
+		contentType string
+	}{
+		{"iso-8859-1", http.StatusNotAcceptable, ""},
+		{"iso-8859-1, utf-8;q=0.5", http.StatusOK, "text/csv; charset=utf-8"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements?format=csv", nil)
+		r.Header.Set("Accept-Charset", tt.accept)
+		q, err := parseAchievementQuery(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.format = formatCSV
+		var ok bool
+		if q.charsets, ok = negotiateCharsets(httptest.NewRecorder(), r, q.format); !ok {
+			t.Fatalf("%q refused", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		q.export(w, 105600, items)
+		if w.Code != tt.code {
+			t.Errorf("%q: %d %s, want %d", tt.accept, w.Code, w.Body, tt.code)
+		}
+		if tt.code == http.StatusNotAcceptable && !strings.Contains(w.Body.String(), "unrepresentable_charset") {
+			t.Errorf("%q: %s", tt.accept, w.Body)
+		}
+		if tt.contentType != "" && (w.Header().Get("Content-Type") != tt.contentType || !strings.Contains(w.Body.String(), "テラリア実績")) {
+			t.Errorf("%q: %v %q", tt.accept, w.Header(), w.Body)
+		}
+	}
+}
+
+func TestAcceptedCharsets(t *testing.T) {
+	for header, want := range map[string][]string{
+		"":                               {charsetUTF8},
+		"*":                              {charsetUTF8},
+		"latin1":                         {charsetLatin1},
+		"utf-8;q=0.5, ISO-8859-1":        {charsetLatin1, charsetUTF8},
+		"iso-8859-1;q=0.8, utf8;q=0.8":   {charsetLatin1, charsetUTF8},
+		"utf-8, latin-1, l1, iso_8859-1": {charsetUTF8, charsetLatin1},
+		"koi8-r, windows-1252":           nil,
+		"utf-8;q=0, latin1;q=bad":        nil,
+		"iso-8859-1; q=0.2, *;q=0.1":     {charsetLatin1, charsetUTF8},
+	} {
+		if got := acceptedCharsets(header); !slices.Equal(got, want) {
+			t.Errorf("acceptedCharsets(%q) = %v, want %v", header, got, want)
+		}
+	}
+	if got := withCharset("text/csv; charset=utf-8", charsetLatin1); got != "text/csv; charset=iso-8859-1" {
+		t.Errorf("withCharset = %q", got)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// runCheck validates a deployment before it serves traffic: environment,
+// data dir, database, data files, static assets and, unless -offline,
+// the Steam key. It goes through the same startup helpers as main.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	offline := fs.Bool("offline", false, "skip the checks that need the network (Steam key)")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of the network checks")
+	registerConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	apiKey := cleanEnvValue(os.Getenv("STEAM_API_KEY"))
+	s := newServer(nil, apiKey)
+	defer func() {
+		if s.db != nil {
+			s.db.Close()
+		}
+	}()
+
+	checks := []smokeCheck{
+		{"config", func(ctx context.Context) error {
+			if apiKey == "" {
+				return errors.New("STEAM_API_KEY is not set")
+			}
+			if err := validateSettings(); err != nil {
+				return err
+			}
+			if err := validateCacheCodec(s.cacheCodec); err != nil {
+				return err
+			}
+			if _, err := steamLoginFromEnv(); err != nil {
+				return err
+			}
+			return registerAPIRoutes(http.NewServeMux(), s.apiRoutes(), getenv("API_DEFAULT_VERSION", apiV1))
+		}},
+		{"data dir writable", func(ctx context.Context) error {
+			return checkDirWritable(getenv("DATA_DIR", "data"))
+		}},
+		{"sqlite open and migrate", func(ctx context.Context) error {
+			db, err := openDB(getenv("DB_PATH", "steam_achievements.db"))
+			if err != nil {
+				return err
+			}
+			s.db = db
+			return s.initDB()
+		}},
+		{"data files", func(ctx context.Context) error {
+			return s.loadDataFiles()
+		}},
+		{"error catalog", func(ctx context.Context) error {
+			return apperr.Check()
+		}},
+		{"static assets", func(ctx context.Context) error {
+			h, err := staticHandlerFromEnv()
+			if err != nil {
+				return err
+			}
+			if _, ok := h.assets["index.html"]; !ok {
+				return errors.New("index.html is missing from the static assets")
+			}
+			return nil
+		}},
+		{"redis", func(ctx context.Context) error {
+			raw := cleanEnvValue(os.Getenv("REDIS_URL"))
+			if raw == "" {
+				return fmt.Errorf("%w: REDIS_URL not set", errSmokeSkipped)
+			}
+			return pingRedis(ctx, raw)
+		}},
+		{"steam api key", func(ctx context.Context) error {
+			if *offline {
+				return fmt.Errorf("%w: -offline", errSmokeSkipped)
+			}
+			if apiKey == "" {
+				return fmt.Errorf("%w: no key", errSmokeSkipped)
+			}
+			return checkSteamKey(ctx, apiKey)
+		}},
+	}
+
+	return runCheckTable(checks, *timeout)
+}
+
+func checkDirWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// checkSteamKey makes one keyed call; Steam answers 403 to a bad key.
+func checkSteamKey(ctx context.Context, apiKey string) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := fetchSchemaForGame(withUpstreamFeature(ctx, featureProbe), apiKey, defaultGlobalAppID, "english")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pingRedis sends an inline PING. An auth error still proves the server is
+// reachable, which is all this check is about.
+func pingRedis(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid REDIS_URL")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+PONG") && !strings.HasPrefix(line, "-NOAUTH") {
+		return fmt.Errorf("unexpected reply %q", strings.TrimSpace(line))
+	}
+	return nil
+}
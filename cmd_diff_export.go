@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+// runDiffExport writes the snapshot diff of an app between two dates, the
+// payload of /achievements/diff, to a file or stdout.
+func runDiffExport(args []string) int {
+	fs := flag.NewFlagSet("diff-export", flag.ContinueOnError)
+	appIDFlag := fs.Int("appid", int(defaultGlobalAppID), "Steam app ID to diff")
+	from := fs.String("from", "", "start date, YYYY-MM-DD")
+	to := fs.String("to", "", "end date, YYYY-MM-DD")
+	out := fs.String("o", "", "output file (default stdout)")
+	dbPath := fs.String("db", getenv("DB_PATH", "steam_achievements.db"), "SQLite database path")
+	tolerance := fs.Duration("tolerance", defaultDiffTolerance, "how far from each date a snapshot may be")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	appID, err := newAppID(*appIDFlag)
+	if err != nil || *from == "" || *to == "" || *tolerance < 0 {
+		fs.Usage()
+		return 2
+	}
+	start, end, err := parseDiffDates(*from, *to)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	db, err := openDB(*dbPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer db.Close()
+
+	s := newServer(db, "")
+	if err := s.initDB(); err != nil {
+		log.Print(err)
+		return 1
+	}
+	// Exports follow the deployment's visibility policies, like the API.
+	if s.policies, err = loadPolicyStore(getenv("POLICIES_DIR", defaultPoliciesDir)); err != nil {
+		log.Printf("visibility policies: %v", err)
+		return 1
+	}
+
+	d, err := s.snapshotDiff(appID, start, end, *tolerance, s.policies.forApp(appID))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	b = append(b, '\n')
+	if *out == "" {
+		os.Stdout.Write(b)
+		return 0
+	}
+	if err := writeFileAtomic(*out, func(f *os.File) error { _, err := f.Write(b); return err }); err != nil {
+		log.Print(err)
+		return 1
+	}
+	log.Printf("diff %s..%s (snapshots %s..%s): %d added, %d removed, %d changed, written to %s",
+		d.From, d.To, d.FromUsed.Format(time.RFC3339), d.ToUsed.Format(time.RFC3339), len(d.Added), len(d.Removed), len(d.Changed), *out)
+	return 0
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+// runFetch writes the achievement list of an app, without the server nor
+// its database, for scripts and cron jobs:
+//
+//	yboost fetch -appid 105600 -out achievements.json
+//
+// The list is merged as /achievements?appId= merges it: the schema of the
+// game's source (Steam, or a manual source of GAMES_FILE) completed from
+// fallbackLang, its global percentages, the overlay tags, the visibility
+// policy, rarity tiers, rarest first.
+type fetchOutput struct {
+	AppID        AppID         `json:"appId"`
+	Lang         string        `json:"lang"`
+	Source       string        `json:"source"`
+	FetchedAt    time.Time     `json:"fetchedAt"`
+	Achievements []Achievement `json:"achievements"`
+}
+
+func runFetch(args []string) int {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	appIDFlag := fs.Int("appid", int(defaultGlobalAppID), "Steam app ID to fetch")
+	out := fs.String("out", "", "output file (default stdout)")
+	langFlag := fs.String("lang", "", "language of the achievement texts, spelled as ?lang= (default $ACHIEVEMENTS_LANG, french)")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the source calls")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	appID, err := newAppID(*appIDFlag)
+	if err != nil {
+		fs.Usage()
+		return 2
+	}
+	lang, err := langFromEnv()
+	if *langFlag != "" {
+		lang, err = langParam.normalize(*langFlag)
+	}
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	if err := validateSettings(); err != nil {
+		log.Printf("config:\n%v", err)
+		return 1
+	}
+
+	s := newServer(nil, cleanEnvValue(os.Getenv("STEAM_API_KEY")))
+	if err := s.loadDataFiles(); err != nil {
+		log.Print(err)
+		return 1
+	}
+	src := s.sourceFor(appID)
+	if src.Name() == sourceSteam {
+		if s.apiKey == "" {
+			log.Print("STEAM_API_KEY is not set")
+			return 1
+		}
+		if steamHosts, steamHTTPClient.Transport, err = steamHostSelectorFromEnv(steamHTTPClient.Transport); err != nil {
+			log.Print(err)
+			return 1
+		}
+		upstreamRetry = upstreamRetryFromEnv()
+	}
+
+	ctx, cancel := context.WithTimeout(withUpstreamFeature(context.Background(), featureListRefresh), *timeout)
+	defer cancel()
+	items, err := src.FetchSchema(ctx, appID, lang)
+	if err != nil {
+		log.Printf("%s schema app %d: %v", src.Name(), appID, err)
+		return 1
+	}
+	if len(items) == 0 {
+		log.Printf("app %d: %s returned no achievements", appID, src.Name())
+		return 1
+	}
+	if lang != fallbackLang && hasUntranslated(items) {
+		ref, err := src.FetchSchema(ctx, appID, fallbackLang)
+		if err != nil {
+			log.Printf("%s fallback schema app %d: %v", fallbackLang, appID, err)
+		} else {
+			fillUntranslated(items, ref)
+		}
+	}
+	pcts, err := src.FetchPercentages(ctx, appID)
+	if err != nil {
+		log.Printf("%s global pct app %d: %v", src.Name(), appID, err)
+		return 1
+	}
+	for i := range items {
+		items[i].GlobalPct = pcts[items[i].APIName]
+	}
+
+	s.overlay.applyTags(appID, items)
+	applyAccessibility(lang, items)
+	items = s.policies.forApp(appID).filter(items)
+	applyRarity(items)
+	newAchievementOrder(sortRarity, "").sort(items)
+
+	b, err := json.MarshalIndent(fetchOutput{AppID: appID, Lang: lang, Source: src.Name(), FetchedAt: time.Now().UTC().Truncate(time.Second), Achievements: items}, "", "  ")
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	b = append(b, '\n')
+	if *out == "" {
+		os.Stdout.Write(b)
+		return 0
+	}
+	if err := writeFileAtomic(*out, func(f *os.File) error { _, err := f.Write(b); return err }); err != nil {
+		log.Print(err)
+		return 1
+	}
+	log.Printf("app %d: %d achievements from %s, written to %s", appID, len(items), src.Name(), *out)
+	return 0
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runImportHistory backfills global_percent_history from a CSV file with
+// rows "timestamp,apiName,percent" (an optional header row is skipped).
+func runImportHistory(args []string) int {
+	fs := flag.NewFlagSet("import-history", flag.ContinueOnError)
+	file := fs.String("file", "", "CSV file to import (timestamp,apiName,percent)")
+	appIDFlag := fs.Int("appid", int(defaultGlobalAppID), "Steam app ID the percentages belong to")
+	dbPath := fs.String("db", getenv("DB_PATH", "steam_achievements.db"), "SQLite database path")
+	tolerance := fs.Duration("tolerance", 30*time.Minute, "skip rows within this window of an existing snapshot")
+	batchSize := fs.Int("batch", 500, "rows per insert transaction")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	appID, err := newAppID(*appIDFlag)
+	if *file == "" || err != nil || *batchSize <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	db, err := openDB(*dbPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer db.Close()
+
+	s := newServer(db, "")
+	// Runs before db.Close: the batched writes still queued reach the file.
+	defer s.writes.close()
+	if err := s.initDB(); err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer f.Close()
+
+	rows, invalid, err := readHistoryCSV(f, appID)
+	if err != nil {
+		log.Printf("read %s: %v", *file, err)
+		return 1
+	}
+
+	known, err := s.knownAPINames(appID)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if len(known) == 0 {
+		log.Printf("no cached schema for app %d: sync it once before importing", appID)
+		return 1
+	}
+
+	unmatched := make(map[string]int)
+	accepted := make([]percentSnapshot, 0, len(rows))
+	duplicates := 0
+	lastByName := make(map[string]time.Time)
+	for _, row := range rows {
+		if !known[row.APIName] {
+			unmatched[row.APIName]++
+			continue
+		}
+		if last, ok := lastByName[row.APIName]; ok && row.RecordedAt.Sub(last) < *tolerance {
+			duplicates++
+			continue
+		}
+		exists, err := s.hasHistorySnapshotNear(appID, row.APIName, row.RecordedAt, *tolerance)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		if exists {
+			duplicates++
+			continue
+		}
+		lastByName[row.APIName] = row.RecordedAt
+		accepted = append(accepted, row)
+	}
+
+	log.Printf("%d rows read, %d invalid, %d duplicates, %d unmatched apiNames, %d to import",
+		len(rows)+invalid, invalid, duplicates, len(unmatched), len(accepted))
+	if len(unmatched) > 0 {
+		names := make([]string, 0, len(unmatched))
+		for name := range unmatched {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			log.Printf("unmatched apiName %q (%d rows)", name, unmatched[name])
+		}
+	}
+
+	if *dryRun {
+		log.Printf("dry run: nothing written")
+		return 0
+	}
+
+	for start := 0; start < len(accepted); start += *batchSize {
+		end := min(start+*batchSize, len(accepted))
+		if err := s.insertHistorySnapshots(accepted[start:end]); err != nil {
+			log.Printf("insert batch %d-%d: %v", start, end, err)
+			return 1
+		}
+		log.Printf("imported %d/%d", end, len(accepted))
+	}
+	return 0
+}
+
+// readHistoryCSV parses and validates the rows. It returns them sorted by
+// (apiName, timestamp) along with the number of rejected rows.
+func readHistoryCSV(r io.Reader, appID AppID) ([]percentSnapshot, int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	out := make([]percentSnapshot, 0)
+	invalid := 0
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if line == 1 && len(rec) > 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "timestamp") {
+			continue
+		}
+
+		row, err := parseHistoryRecord(rec, appID)
+		if err != nil {
+			invalid++
+			log.Printf("line %d: %v", line, err)
+			continue
+		}
+		out = append(out, row)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].APIName == out[j].APIName {
+			return out[i].RecordedAt.Before(out[j].RecordedAt)
+		}
+		return out[i].APIName < out[j].APIName
+	})
+	return out, invalid, nil
+}
+
+func parseHistoryRecord(rec []string, appID AppID) (percentSnapshot, error) {
+	if len(rec) != 3 {
+		return percentSnapshot{}, fmt.Errorf("expected 3 columns, got %d", len(rec))
+	}
+
+	ts, err := parseHistoryTimestamp(strings.TrimSpace(rec[0]))
+	if err != nil {
+		return percentSnapshot{}, err
+	}
+	apiName := strings.TrimSpace(rec[1])
+	if apiName == "" {
+		return percentSnapshot{}, errors.New("empty apiName")
+	}
+	pct, err := strconv.ParseFloat(strings.TrimSpace(rec[2]), 64)
+	if err != nil || math.IsNaN(pct) || math.IsInf(pct, 0) || pct < 0 || pct > 100 {
+		return percentSnapshot{}, fmt.Errorf("invalid percent %q", rec[2])
+	}
+
+	return percentSnapshot{AppID: appID, APIName: apiName, Percent: pct, RecordedAt: ts, Source: historySourceImport}, nil
+}
+
+func parseHistoryTimestamp(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil && sec > 0 {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", v)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Rows with a percentage outside [0, 100], NaN and infinities included,
+// are rejected instead of reaching the history.
+func TestReadHistoryCSVRejectsInvalidPercent(t *testing.T) {
+	csv := strings.Join([]string{
+		"timestamp,apiName,percent",
+		"1700000000,A,12.5",
+		"1700000000,B,NaN",
+		"1700000000,C,nan",
+		"1700000000,D,Inf",
+		"1700000000,E,-Inf",
+		"1700000000,F,+Infinity",
+		"1700000000,G,100.1",
+		"1700000000,H,-1",
+		"1700000000,I,0",
+	}, "\n")
+	rows, invalid, err := readHistoryCSV(strings.NewReader(csv), defaultGlobalAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid != 7 || len(rows) != 2 || rows[0].APIName != "A" || rows[0].Percent != 12.5 || rows[1].APIName != "I" {
+		t.Fatalf("rows %+v, %d invalid; want A and I kept, 7 invalid", rows, invalid)
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const smokeIconSample = 3
+
+type smokeCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+type smokeClient struct {
+	base   *url.URL
+	client *http.Client
+	// adminToken is sent to /api/admin/ routes only.
+	adminToken string
+}
+
+// runSmoke exercises the public API of a running server and exits non-zero
+// when any check fails. Only public endpoints are used, unless -admin-token
+// is given: the consistency check then runs /api/admin/verify on -appid.
+func runSmoke(args []string) int {
+	fs := flag.NewFlagSet("smoke", flag.ContinueOnError)
+	base := fs.String("base", "http://localhost:8080", "base URL of the running server")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each check")
+	steamID := fs.String("steamid", "", "player used for the user endpoints (default: first suggestion)")
+	appID := fs.Int("appid", int(defaultGlobalAppID), "app ID used for the user achievements and consistency checks")
+	adminToken := fs.String("admin-token", "", "ADMIN_TOKEN of the server, enables the consistency check")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	u, err := url.Parse(strings.TrimRight(*base, "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		fmt.Fprintf(os.Stderr, "invalid -base %q\n", *base)
+		return 2
+	}
+
+	c := &smokeClient{base: u, client: &http.Client{}, adminToken: *adminToken}
+	player := *steamID
+	checks := []smokeCheck{
+		{"static index", c.checkIndex},
+		{"config", c.checkConfig},
+		{"achievements", c.checkAchievements},
+		{"achievement icons", c.checkIcons},
+		{"suggestions", func(ctx context.Context) error {
+			first, err := c.checkSuggestions(ctx)
+			if player == "" {
+				player = first
+			}
+			return err
+		}},
+		{"bootstrap", c.checkBootstrap},
+		{"terraria progression", c.checkProgression},
+		{"events stream", c.checkEvents},
+		{"malformed steamid", c.checkMalformedSteamID},
+		{"user profile", func(ctx context.Context) error { return c.checkProfile(ctx, player) }},
+		{"user games", func(ctx context.Context) error { return c.checkGames(ctx, player) }},
+		{"user achievements", func(ctx context.Context) error { return c.checkUserAchievements(ctx, player, *appID) }},
+	}
+	if c.adminToken != "" {
+		checks = append(checks, smokeCheck{"consistency", func(ctx context.Context) error { return c.checkConsistency(ctx, *appID) }})
+	}
+
+	return runCheckTable(checks, *timeout)
+}
+
+// runCheckTable runs checks in order, prints a PASS/FAIL/SKIP table and
+// returns the process exit code.
+func runCheckTable(checks []smokeCheck, timeout time.Duration) int {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tTIME\tDETAIL")
+	failed := 0
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		err := check.run(ctx)
+		cancel()
+
+		result, detail := "PASS", ""
+		if errors.Is(err, errSmokeSkipped) {
+			result = "SKIP"
+			detail = err.Error()
+		} else if err != nil {
+			result = "FAIL"
+			detail = err.Error()
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", check.name, result, time.Since(start).Round(time.Millisecond), detail)
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Printf("all %d checks passed\n", len(checks))
+	return 0
+}
+
+var errSmokeSkipped = errors.New("skipped")
+
+func (c *smokeClient) get(ctx context.Context, path string, wantStatus int, wantType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base.String()+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.adminToken != "" && strings.HasPrefix(path, "/api/admin/") {
+		req.Header.Set("X-Admin-Token", c.adminToken)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests && wantStatus != http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: GET %s rate limited (Retry-After: %s)", errTooManyRequests, path, resp.Header.Get("Retry-After"))
+	}
+	if resp.StatusCode != wantStatus {
+		return nil, fmt.Errorf("GET %s: status %d, want %d", path, resp.StatusCode, wantStatus)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, wantType) {
+		return nil, fmt.Errorf("GET %s: content type %q, want %s", path, ct, wantType)
+	}
+	return body, nil
+}
+
+func (c *smokeClient) getJSON(ctx context.Context, path string, wantStatus int, v any) error {
+	body, err := c.get(ctx, path, wantStatus, "application/json")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("GET %s: invalid JSON: %w", path, err)
+	}
+	return nil
+}
+
+// requireFields checks that obj has every field with the given JSON kind
+// (string, number, bool, array, object).
+func requireFields(obj map[string]any, fields map[string]string) error {
+	for name, kind := range fields {
+		v, ok := obj[name]
+		if !ok {
+			return fmt.Errorf("missing field %q", name)
+		}
+		okKind := false
+		switch kind {
+		case "string":
+			_, okKind = v.(string)
+		case "number":
+			_, okKind = v.(float64)
+		case "bool":
+			_, okKind = v.(bool)
+		case "array":
+			_, okKind = v.([]any)
+		case "object":
+			_, okKind = v.(map[string]any)
+		}
+		if !okKind {
+			return fmt.Errorf("field %q is not a %s", name, kind)
+		}
+	}
+	return nil
+}
+
+func requirePercent(name string, v any) error {
+	f, _ := v.(float64)
+	if f < 0 || f > 100 {
+		return fmt.Errorf("%s = %v is outside 0-100", name, f)
+	}
+	return nil
+}
+
+var achievementFields = map[string]string{
+	"apiName": "string", "name": "string", "description": "string",
+	"icon": "string", "iconGray": "string", "hidden": "bool", "globalPct": "number",
+}
+
+func checkAchievementList(items []map[string]any) error {
+	for i, a := range items {
+		if err := requireFields(a, achievementFields); err != nil {
+			return fmt.Errorf("achievement %d: %w", i, err)
+		}
+		if a["apiName"] == "" {
+			return fmt.Errorf("achievement %d: empty apiName", i)
+		}
+		if err := requirePercent(fmt.Sprintf("achievement %v globalPct", a["apiName"]), a["globalPct"]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *smokeClient) checkIndex(ctx context.Context) error {
+	_, err := c.get(ctx, "/", http.StatusOK, "text/html")
+	return err
+}
+
+func (c *smokeClient) checkConfig(ctx context.Context) error {
+	var cfg map[string]any
+	if err := c.getJSON(ctx, "/api/config", http.StatusOK, &cfg); err != nil {
+		return err
+	}
+	return requireFields(cfg, map[string]string{"version": "string", "defaultAppId": "number", "cacheTtl": "string"})
+}
+
+func (c *smokeClient) checkAchievements(ctx context.Context) error {
+	var items []map[string]any
+	if err := c.getJSON(ctx, "/api/achievements", http.StatusOK, &items); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return errors.New("no achievements returned")
+	}
+	if err := checkAchievementList(items); err != nil {
+		return err
+	}
+	for i := 1; i < len(items); i++ {
+		if items[i]["globalPct"].(float64) > items[i-1]["globalPct"].(float64) {
+			return fmt.Errorf("achievements not sorted by globalPct at index %d", i)
+		}
+	}
+	return nil
+}
+
+func (c *smokeClient) checkIcons(ctx context.Context) error {
+	var items []Achievement
+	if err := c.getJSON(ctx, "/api/achievements", http.StatusOK, &items); err != nil {
+		return err
+	}
+	checked := 0
+	for _, a := range items {
+		if checked == smokeIconSample {
+			break
+		}
+		if a.Icon == "" {
+			continue
+		}
+		ref, err := url.Parse(a.Icon)
+		if err != nil {
+			return fmt.Errorf("icon of %s: %w", a.APIName, err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.base.ResolveReference(ref).String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("icon of %s: %w", a.APIName, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("icon of %s: status %d", a.APIName, resp.StatusCode)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("%w: no icon to resolve", errSmokeSkipped)
+	}
+	return nil
+}
+
+func (c *smokeClient) checkSuggestions(ctx context.Context) (string, error) {
+	var items []map[string]any
+	if err := c.getJSON(ctx, "/api/users/suggestions", http.StatusOK, &items); err != nil {
+		return "", err
+	}
+	fields := map[string]string{"steamId": "string", "displayName": "string", "gamesCount": "number", "avgCompletion": "number"}
+	for i, sug := range items {
+		if err := requireFields(sug, fields); err != nil {
+			return "", fmt.Errorf("suggestion %d: %w", i, err)
+		}
+		if err := requirePercent("avgCompletion", sug["avgCompletion"]); err != nil {
+			return "", fmt.Errorf("suggestion %d: %w", i, err)
+		}
+	}
+	if len(items) == 0 {
+		return "", nil
+	}
+	return items[0]["steamId"].(string), nil
+}
+
+func (c *smokeClient) checkBootstrap(ctx context.Context) error {
+	var resp struct {
+		Components struct {
+			Achievements []map[string]any  `json:"achievements"`
+			Stats        *AchievementStats `json:"stats"`
+		} `json:"components"`
+		Partial []string `json:"partial"`
+	}
+	if err := c.getJSON(ctx, "/api/bootstrap", http.StatusOK, &resp); err != nil {
+		return err
+	}
+	if len(resp.Partial) > 0 {
+		return fmt.Errorf("partial response: %s", strings.Join(resp.Partial, ", "))
+	}
+	if err := checkAchievementList(resp.Components.Achievements); err != nil {
+		return err
+	}
+	if resp.Components.Stats == nil {
+		return errors.New("missing stats component")
+	}
+	if resp.Components.Stats.Total != len(resp.Components.Achievements) {
+		return fmt.Errorf("stats.total = %d but %d achievements", resp.Components.Stats.Total, len(resp.Components.Achievements))
+	}
+	return nil
+}
+
+func (c *smokeClient) checkProgression(ctx context.Context) error {
+	var resp ProgressionResponse
+	if err := c.getJSON(ctx, "/api/terraria/progression", http.StatusOK, &resp); err != nil {
+		return err
+	}
+	if len(resp.Stages) == 0 {
+		return errors.New("no stages returned")
+	}
+	for i, st := range resp.Stages {
+		if err := requirePercent("stage "+st.ID+" reachedPct", st.ReachedPct); err != nil {
+			return err
+		}
+		if i > 0 && st.Order < resp.Stages[i-1].Order {
+			return fmt.Errorf("stage %s out of order", st.ID)
+		}
+	}
+	return nil
+}
+
+func (c *smokeClient) checkEvents(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base.String()+"/api/events?topics=refresh", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		return fmt.Errorf("content type %q, want text/event-stream", ct)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading first event: %w", err)
+	}
+	if strings.TrimSpace(line) != "event: subscribed" {
+		return fmt.Errorf("first line %q, want \"event: subscribed\"", strings.TrimSpace(line))
+	}
+	return nil
+}
+
+func (c *smokeClient) checkMalformedSteamID(ctx context.Context) error {
+	var resp map[string]any
+	if err := c.getJSON(ctx, "/api/users/games?steamId=STEAM_9:9", http.StatusBadRequest, &resp); err != nil {
+		return err
+	}
+	if resp["error"] != "invalid_steam_id" {
+		return fmt.Errorf("error = %v, want invalid_steam_id", resp["error"])
+	}
+	return nil
+}
+
+func (c *smokeClient) checkProfile(ctx context.Context, steamID string) error {
+	if steamID == "" {
+		return fmt.Errorf("%w: no player available", errSmokeSkipped)
+	}
+	var p map[string]any
+	if err := c.getJSON(ctx, "/api/users/profile?steamId="+url.QueryEscape(steamID), http.StatusOK, &p); err != nil {
+		return err
+	}
+	if err := requireFields(p, map[string]string{"steamId": "string", "displayName": "string", "avatarUrl": "string"}); err != nil {
+		return err
+	}
+	if p["steamId"] != steamID {
+		return fmt.Errorf("steamId = %v, want %s", p["steamId"], steamID)
+	}
+	return nil
+}
+
+func (c *smokeClient) checkGames(ctx context.Context, steamID string) error {
+	if steamID == "" {
+		return fmt.Errorf("%w: no player available", errSmokeSkipped)
+	}
+	var games []GameCompletion
+	if err := c.getJSON(ctx, "/api/users/games?steamId="+url.QueryEscape(steamID), http.StatusOK, &games); err != nil {
+		return err
+	}
+	for _, g := range games {
+		if g.UnlockedAchievements > g.TotalAchievements {
+			return fmt.Errorf("app %d: %d unlocked of %d", g.AppID, g.UnlockedAchievements, g.TotalAchievements)
+		}
+		if err := requirePercent(fmt.Sprintf("app %d completionPct", g.AppID), g.CompletionPct); err != nil {
+			return err
+		}
+		if g.Status == "" {
+			return fmt.Errorf("app %d: empty status", g.AppID)
+		}
+	}
+	return nil
+}
+
+func (c *smokeClient) checkUserAchievements(ctx context.Context, steamID string, appID int) error {
+	if steamID == "" {
+		return fmt.Errorf("%w: no player available", errSmokeSkipped)
+	}
+	path := fmt.Sprintf("/api/users/achievements?steamId=%s&appId=%d", url.QueryEscape(steamID), appID)
+	var items []map[string]any
+	if err := c.getJSON(ctx, path, http.StatusOK, &items); err != nil {
+		return err
+	}
+	return checkAchievementList(items)
+}
+
+var errTooManyRequests = errors.New("rate limited")
+
+// checkConsistency fails when the server finds its cache or its latest
+// snapshot out of line with a fresh upstream fetch. A run that is rate
+// limited by the server is skipped.
+func (c *smokeClient) checkConsistency(ctx context.Context, appID int) error {
+	var report verifyReport
+	err := c.getJSON(ctx, fmt.Sprintf("/api/admin/verify?appId=%d", appID), http.StatusOK, &report)
+	if errors.Is(err, errTooManyRequests) {
+		return fmt.Errorf("%w: %v", errSmokeSkipped, err)
+	}
+	if err != nil {
+		return err
+	}
+	if report.OK {
+		return nil
+	}
+	var drift []string
+	for _, part := range []struct {
+		name string
+		c    *verifyComparison
+	}{{"cache", report.Cache}, {"snapshot", report.Snapshot}} {
+		if part.c == nil || part.c.OK {
+			continue
+		}
+		drift = append(drift, fmt.Sprintf("%s: %d missing, %d extra, %d pct, %d renamed",
+			part.name, len(part.c.Missing), len(part.c.Extra), len(part.c.PctMismatch), len(part.c.Renamed)))
+	}
+	return fmt.Errorf("drift found (%s)", strings.Join(drift, "; "))
+}
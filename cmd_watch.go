@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+// runWatch compares the live Steam list of an app with the one saved in a
+// state file by the previous run, prints what changed and saves the new
+// list, for a nightly CI job:
+//
+//	yboost watch -appid 105600 -state state.json
+//
+// It exits 0 when nothing changed (or on the first run, which only records
+// the state), watchExitChanged when achievements were added, removed or
+// renamed or a percentage moved by at least -min-move points, 1 on any
+// failure and 2 on bad arguments. The diff is the one of the snapshot
+// diffs, diffAchievementLists.
+const (
+	watchExitChanged  = 3
+	watchStateVersion = 1
+	defaultWatchMove  = 1.0
+)
+
+// watchState is the state file. Version changes whenever a field changes
+// meaning; a file of another version is refused rather than misread.
+type watchState struct {
+	Version      int                `json:"version"`
+	AppID        AppID              `json:"appId"`
+	Lang         string             `json:"lang"`
+	FetchedAt    time.Time          `json:"fetchedAt"`
+	Achievements []watchAchievement `json:"achievements"`
+}
+
+type watchAchievement struct {
+	APIName   string  `json:"apiName"`
+	Name      string  `json:"name"`
+	Hidden    bool    `json:"hidden,omitempty"`
+	GlobalPct float64 `json:"globalPct"`
+}
+
+func runWatch(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	appIDFlag := fs.Int("appid", int(defaultGlobalAppID), "Steam app ID to watch")
+	statePath := fs.String("state", "", "state file of the previous run, rewritten")
+	lang := fs.String("lang", "english", "language of the achievement names")
+	minMove := fs.Float64("min-move", defaultWatchMove, "percentage points a move must reach to count as a change")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the Steam calls")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	appID, err := newAppID(*appIDFlag)
+	if err != nil || *statePath == "" || *minMove < 0 || math.IsNaN(*minMove) {
+		fs.Usage()
+		return 2
+	}
+	apiKey := cleanEnvValue(os.Getenv("STEAM_API_KEY"))
+	if apiKey == "" {
+		log.Print("STEAM_API_KEY is not set")
+		return 1
+	}
+
+	// Like the server, so CI can go through the same mirrors.
+	if steamHosts, steamHTTPClient.Transport, err = steamHostSelectorFromEnv(steamHTTPClient.Transport); err != nil {
+		log.Print(err)
+		return 1
+	}
+	upstreamRetry = upstreamRetryFromEnv()
+
+	prev, err := readWatchState(*statePath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if prev != nil && (prev.AppID != appID || prev.Lang != *lang) {
+		log.Printf("%s holds app %d in %s, not app %d in %s", *statePath, prev.AppID, prev.Lang, appID, *lang)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(withUpstreamFeature(context.Background(), featureProbe), *timeout)
+	defer cancel()
+	src := steamSource{apiKey: apiKey}
+	items, err := src.FetchSchema(ctx, appID, *lang)
+	if err != nil {
+		log.Printf("schema app %d: %v", appID, err)
+		return 1
+	}
+	pcts, err := src.FetchPercentages(ctx, appID)
+	if err != nil {
+		log.Printf("percentages app %d: %v", appID, err)
+		return 1
+	}
+	if len(items) == 0 {
+		// An empty answer is more likely a Steam hiccup than a game that
+		// lost every achievement: keep the previous state.
+		log.Printf("app %d: Steam returned no achievements, state left as is", appID)
+		return 1
+	}
+	for i := range items {
+		items[i].GlobalPct = pcts[items[i].APIName]
+	}
+	next := newWatchState(appID, *lang, items, time.Now())
+
+	code := 0
+	if prev == nil {
+		fmt.Printf("app %d: no previous state, recorded %d achievements\n", appID, len(next.Achievements))
+	} else {
+		d := watchDiff(diffAchievementLists(prev.achievements(), next.achievements()), *minMove)
+		fmt.Printf("app %d: %d achievements, compared with the state of %s\n", appID, len(next.Achievements), prev.FetchedAt.UTC().Format(time.RFC3339))
+		if printWatchDiff(os.Stdout, d, *minMove) {
+			code = watchExitChanged
+		}
+	}
+	if err := writeWatchState(*statePath, next); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return code
+}
+
+func newWatchState(appID AppID, lang string, items []Achievement, now time.Time) *watchState {
+	st := &watchState{Version: watchStateVersion, AppID: appID, Lang: lang, FetchedAt: now.UTC().Truncate(time.Second), Achievements: make([]watchAchievement, len(items))}
+	for i, a := range items {
+		st.Achievements[i] = watchAchievement{APIName: a.APIName, Name: a.Name, Hidden: a.Hidden, GlobalPct: a.GlobalPct}
+	}
+	return st
+}
+
+func (st *watchState) achievements() []Achievement {
+	out := make([]Achievement, len(st.Achievements))
+	for i, a := range st.Achievements {
+		out[i] = Achievement{APIName: a.APIName, Name: a.Name, Hidden: a.Hidden, GlobalPct: a.GlobalPct}
+	}
+	return out
+}
+
+// readWatchState reads path; nil, without error, when it does not exist.
+func readWatchState(path string) (*watchState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st watchState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if st.Version != watchStateVersion {
+		return nil, fmt.Errorf("%s: state version %d, this build reads version %d", path, st.Version, watchStateVersion)
+	}
+	return &st, nil
+}
+
+func writeWatchState(path string, st *watchState) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	return writeFileAtomic(path, func(f *os.File) error { _, err := f.Write(b); return err })
+}
+
+// watchDiff keeps the moves of at least minMove points; with 0, every
+// move that is not nil.
+func watchDiff(d achievementDiff, minMove float64) achievementDiff {
+	moved := make([]achievementMove, 0, len(d.Changed))
+	for _, m := range d.Changed {
+		if m.AbsDelta != 0 && math.Abs(m.AbsDelta) >= minMove {
+			moved = append(moved, m)
+		}
+	}
+	d.Changed = moved
+	return d
+}
+
+// printWatchDiff writes d for a human reader and reports whether it holds
+// any change.
+func printWatchDiff(w io.Writer, d achievementDiff, minMove float64) bool {
+	if len(d.Added)+len(d.Removed)+len(d.Renamed)+len(d.Changed) == 0 {
+		fmt.Fprintln(w, "no change")
+		return false
+	}
+	for _, a := range d.Added {
+		fmt.Fprintf(w, "+ %s  %s\n", a.APIName, a.Name)
+	}
+	for _, a := range d.Removed {
+		fmt.Fprintf(w, "- %s  %s\n", a.APIName, a.Name)
+	}
+	for _, r := range d.Renamed {
+		fmt.Fprintf(w, "~ %s  %q -> %q\n", r.APIName, r.Before, r.After)
+	}
+	if len(d.Changed) > 0 {
+		fmt.Fprintf(w, "percentages moved by %g points or more:\n", minMove)
+		for _, m := range d.Changed {
+			fmt.Fprintf(w, "  %s  %.2f%% -> %.2f%% (%+.2f)\n", m.APIName, m.StartPct, m.EndPct, m.AbsDelta)
+		}
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d renamed, %d moved\n", len(d.Added), len(d.Removed), len(d.Renamed), len(d.Changed))
+	return true
+}
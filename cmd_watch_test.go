@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// watchFixture is a state file in t's temp dir holding a copy of
+// testdata/watch/<fixture>; with no fixture, one that does not exist yet.
+func watchFixture(t *testing.T, fixture string) string {
+	t.Helper()
+	state := filepath.Join(t.TempDir(), "state.json")
+	if fixture == "" {
+		return state
+	}
+	b, err := os.ReadFile(filepath.Join("testdata", "watch", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(state, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// watchRun runs the watch subcommand of app 105600 over state and returns
+// its exit code and what it printed.
+func watchRun(t *testing.T, state string, args ...string) (code int, out string) {
+	t.Helper()
+	t.Setenv("STEAM_API_KEY", "test-key")
+	t.Setenv("STEAM_API_HOSTS", "")
+	savedRetry := upstreamRetry
+	t.Cleanup(func() { upstreamRetry = savedRetry })
+
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	saved := os.Stdout
+	os.Stdout = stdout
+	code = runWatch(append([]string{"-appid", "105600", "-state", state}, args...))
+	os.Stdout = saved
+	stdout.Seek(0, io.SeekStart)
+	b, _ := io.ReadAll(stdout)
+	return code, string(b)
+}
+
+func readState(t *testing.T, path string) *watchState {
+	t.Helper()
+	st, err := readWatchState(path)
+	if err != nil || st == nil {
+		t.Fatalf("state %s: %v, %v", path, st, err)
+	}
+	return st
+}
+
+func TestWatchFirstRun(t *testing.T) {
+	fakeSteamGame(t)
+	state := watchFixture(t, "")
+	code, out := watchRun(t, state)
+	if code != 0 || !strings.Contains(out, "no previous state, recorded 2 achievements") {
+		t.Fatalf("exit %d: %q", code, out)
+	}
+	st := readState(t, state)
+	if st.Version != watchStateVersion || st.AppID != 105600 || st.Lang != "english" || st.FetchedAt.IsZero() || len(st.Achievements) != 2 {
+		t.Fatalf("state %+v", st)
+	}
+	if b := st.Achievements[1]; b != (watchAchievement{APIName: "B", Name: "Dur", Hidden: true, GlobalPct: 2.5}) {
+		t.Errorf("B saved as %+v", b)
+	}
+
+	// The state it wrote is the one of the next run.
+	if code, out := watchRun(t, state); code != 0 || !strings.Contains(out, "no change") {
+		t.Errorf("second run: exit %d, %q", code, out)
+	}
+}
+
+func TestWatchDiffs(t *testing.T) {
+	for _, tt := range []struct {
+		name, fixture string
+		args          []string
+		code          int
+		want          []string
+		notWant       []string
+	}{
+		{"unchanged", "unchanged.json", nil, 0, []string{"compared with the state of 2025-06-01T00:00:00Z", "no change"}, []string{"added"}},
+		{"added", "added.json", nil, watchExitChanged, []string{"+ B  Dur", "1 added, 0 removed, 0 renamed, 0 moved"}, nil},
+		{"removed", "removed.json", nil, watchExitChanged, []string{"- C  Ancien", "0 added, 1 removed, 0 renamed, 0 moved"}, nil},
+		{"renamed", "renamed.json", nil, watchExitChanged, []string{`~ A  "Tres facile" -> "Facile"`, "0 added, 0 removed, 1 renamed, 0 moved"}, nil},
+		// A moved by 1.5 points, B by 0.1.
+		{"moved", "moved.json", nil, watchExitChanged, []string{"moved by 1 points or more:", "A  78.50% -> 80.00% (+1.50)", "0 added, 0 removed, 0 renamed, 1 moved"}, []string{"B  "}},
+		{"every move", "moved.json", []string{"-min-move", "0"}, watchExitChanged, []string{"B  2.40% -> 2.50% (+0.10)", "2 moved"}, nil},
+		{"moves under the threshold", "moved.json", []string{"-min-move", "2"}, 0, []string{"no change"}, []string{"A  "}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSteamGame(t)
+			state := watchFixture(t, tt.fixture)
+			code, out := watchRun(t, state, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit %d, want %d: %q", code, tt.code, out)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("no %q in %q", want, out)
+				}
+			}
+			for _, bad := range tt.notWant {
+				if strings.Contains(out, bad) {
+					t.Errorf("%q in %q", bad, out)
+				}
+			}
+			// Whatever changed, the state now holds the live list.
+			st := readState(t, state)
+			if len(st.Achievements) != 2 || st.Achievements[0] != (watchAchievement{APIName: "A", Name: "Facile", GlobalPct: 80}) || !st.FetchedAt.After(mustRFC3339("2025-06-01T00:00:00Z")) {
+				t.Errorf("state after the run %+v", st)
+			}
+		})
+	}
+}
+
+// A run that fails leaves the state as it was.
+func TestWatchFailures(t *testing.T) {
+	for _, tt := range []struct {
+		name, fixture string
+		args          []string
+		empty         bool
+	}{
+		{"state of another version", "version2.json", nil, false},
+		{"state of another app", "other_app.json", nil, false},
+		{"state in another language", "unchanged.json", []string{"-lang", "french"}, false},
+		{"empty Steam answer", "unchanged.json", nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fakeSteamGame(t)
+			if tt.empty {
+				f.handle("GetSchemaForGame", http.StatusOK, `{"game":{"availableGameStats":{"achievements":[]}}}`)
+			}
+			state := watchFixture(t, tt.fixture)
+			before, _ := os.ReadFile(state)
+			code, out := watchRun(t, state, tt.args...)
+			if code != 1 || out != "" {
+				t.Errorf("exit %d, %q; want 1 and nothing printed", code, out)
+			}
+			if after, _ := os.ReadFile(state); string(after) != string(before) {
+				t.Errorf("state rewritten:\n%s", after)
+			}
+		})
+	}
+
+	t.Run("Steam down", func(t *testing.T) {
+		f := fakeSteamGame(t)
+		f.handle("GetGlobalAchievementPercentagesForApp", http.StatusForbidden, `{}`)
+		state := watchFixture(t, "")
+		if code, _ := watchRun(t, state); code != 1 {
+			t.Errorf("exit %d", code)
+		} else if _, err := os.Stat(state); err == nil {
+			t.Errorf("a state was recorded from a failed run")
+		}
+	})
+	t.Run("no API key", func(t *testing.T) {
+		fakeSteamGame(t)
+		t.Setenv("STEAM_API_KEY", "")
+		if code := runWatch([]string{"-state", filepath.Join(t.TempDir(), "state.json")}); code != 1 {
+			t.Errorf("exit %d", code)
+		}
+	})
+}
+
+func TestWatchBadArguments(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state.json")
+	for _, args := range [][]string{
+		{},
+		{"-state", state, "-appid", "0"},
+		{"-state", state, "-min-move", "-1"},
+		{"-state", state, "-min-move", "NaN"},
+		{"-state", state, "-unknown"},
+	} {
+		if code := runWatch(args); code != 2 {
+			t.Errorf("%q: exit %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every setting is an environment variable, listed in configSettings. They
+// can also be written in a file, CONFIG_FILE or -config, in TOML (KEY =
+// value) or YAML (KEY: value), and given as flags: -port, -db, -static-dir,
+// -lang, -cache-ttl, -app-ids, or -set KEY=value for any of them. A flag
+// wins over the environment, .env included, which wins over the file.
+//
+// File keys are the variable names, in any case, - or . standing for _; a
+// TOML [section] or a YAML block prefixes its keys, so [upstream] rate = 4
+// is UPSTREAM_RATE. Only this flat subset is read: scalars and one-line
+// lists ([105600, 413150], joined with commas), true and false being 1 and
+// 0. An unknown key, and a number or duration that does not parse, stop
+// the startup; all of them are reported at once.
+type settingKind int
+
+const (
+	settingString settingKind = iota
+	settingInt
+	settingDuration // 0 allowed: some settings read it as off
+)
+
+var configSettings = map[string]settingKind{
+	"ACCESS_LOG": settingString, "ACHIEVEMENTS_LANG": settingString, "ACHIEVEMENT_OVERLAY_FILE": settingString,
+	"ADMIN_TOKEN": settingString, "ALERT_FAILURE_THRESHOLD": settingDuration, "ALERT_MIN_INTERVAL": settingDuration,
+	"ALERT_WEBHOOK_URL": settingString, "ALLOWED_APPIDS": settingString, "API_DEFAULT_VERSION": settingString,
+	"BOOTSTRAP_DEADLINE_MS": settingInt, "CACHE_CODEC": settingString, "CACHE_FILE": settingString,
+	"CACHE_REFRESH_INTERVAL": settingDuration, "CACHE_SNAPSHOT_DELAY": settingDuration, "CACHE_TTL": settingDuration, "CDN_PURGE_PROVIDER": settingString,
+	"CLOUDFLARE_API_TOKEN": settingString, "CLOUDFLARE_ZONE_ID": settingString, "COMPARE_MAX_PLAYERS": settingInt,
+	"CORS_ALLOW_ORIGIN": settingString, "DATA_DIR": settingString, "DB_PATH": settingString,
+	"DERIVED_CACHE_SIZE": settingInt, "DEV_HTTP_CACHE": settingString, "DEV_HTTP_CACHE_MAX_BYTES": settingInt,
+	"DEV_HTTP_CACHE_TTL": settingDuration, "DEV_MODE": settingString, "DEV_TEMPLATES_DIR": settingString,
+	"DISABLE_USAGE_STATS": settingString, "ENABLE_JSONP": settingString, "FASTLY_API_TOKEN": settingString,
+	"FASTLY_SERVICE_ID": settingString, "GAMES_FILE": settingString, "HISTORY_BUFFER_SIZE": settingInt,
+	"HISTORY_COMPACT_INTERVAL": settingString, "HISTORY_DAILY_UNTIL": settingDuration, "HISTORY_FULL_RESOLUTION": settingDuration,
+	"HOTLINK_ALLOWED_ORIGINS": settingString, "ICON_CACHE_DIR": settingString, "ICON_FETCH_CONCURRENCY": settingInt, "ICON_MEMORY_CACHE_BYTES": settingInt,
+	"IDLE_TIMEOUT": settingDuration, "LOCAL_PCT_MIN_PLAYERS": settingInt, "LOG_LEVEL": settingString,
+	"LOG_UPSTREAM_BODIES": settingString, "MANUAL_SOURCES_DIR": settingString, "MAX_HEADER_BYTES": settingInt,
+	"MAX_PARAM_REPEAT": settingInt, "MAX_QUERY_PARAMS": settingInt, "MAX_URL_LENGTH": settingInt,
+	"OWNER_ESTIMATES_FILE": settingString, "PLAYER_ACHIEVEMENTS_TTL": settingDuration, "PLAYER_REFRESH_PER_HOUR": settingString, "PLAYER_REFRESH_QUEUE_MAX": settingInt,
+	"POLICIES_DIR": settingString, "PORT": settingString, "PROXY_ICONS": settingString,
+	"PSEUDONYMIZE": settingString, "PSEUDONYMIZE_SALT": settingString, "PUBLIC_BASE_URL": settingString,
+	"RATE_LIMIT_BURST": settingInt, "RATE_LIMIT_RPS": settingInt, "READY_CRITICAL": settingString,
+	"READ_HEADER_TIMEOUT": settingDuration, "READ_ONLY": settingString, "READ_TIMEOUT": settingDuration,
+	"REDIS_URL": settingString, "RESPONSE_BUFFER_LIMIT": settingInt, "SCHEMA_WATCH_INTERVAL": settingString,
+	"SESSION_SECRET": settingString, "SESSION_TTL": settingDuration, "SHUTDOWN_TIMEOUT": settingDuration,
+	"STALE_WHILE_REVALIDATE": settingString, "STATE_FILE": settingString, "STATE_MAX_AGE": settingDuration,
+	"STATIC_DIR": settingString, "STATIC_HASHED_PATTERN": settingString, "STATIC_MANIFEST": settingString,
+	"STEAM_API_HOSTS": settingString, "STEAM_API_HOST_DECAY": settingDuration, "STEAM_API_KEY": settingString,
+	"STEAM_OPENID_URL": settingString, "TERRARIA_PROGRESSION_FILE": settingString, "TEST_MODE": settingString,
+	"TRANSLATION_GRACE": settingDuration, "TRANSLATION_REFERENCE_LANG": settingString, "TRUST_PROXY": settingString,
+	"UPSTREAM_BREAKER_COOLDOWN": settingDuration, "UPSTREAM_BREAKER_FAILURES": settingInt, "UPSTREAM_BURST": settingInt,
+	"UPSTREAM_DAILY_BUDGET": settingInt, "UPSTREAM_DUMP_BODIES": settingString, "UPSTREAM_FEATURE_QUOTAS": settingString,
+	"UPSTREAM_HEDGE_DELAY": settingDuration, "UPSTREAM_LOG_BODY_BYTES": settingInt, "UPSTREAM_MAX_CONCURRENCY": settingInt,
+	"UPSTREAM_RATE": settingInt, "UPSTREAM_RETRY_ATTEMPTS": settingInt, "UPSTREAM_RETRY_BUDGET": settingDuration,
+	"VERIFY_INTERVAL": settingDuration, "WEBHOOKS_FILE": settingString, "WEBHOOKS_TEST_MODE": settingString,
+	"WEBHOOK_MAX_ATTEMPTS": settingInt, "WEBHOOK_RARE_PCT": settingString, "WRITE_QUEUE_SIZE": settingInt,
+	"WRITE_TIMEOUT": settingDuration,
+}
+
+// configFlags are the shortcuts for the settings changed most often.
+var configFlags = []struct{ name, key, usage string }{
+	{"port", "PORT", "HTTP port"},
+	{"db", "DB_PATH", "SQLite database file"},
+	{"static-dir", "STATIC_DIR", "serve the front end from this directory instead of the embedded copy"},
+	{"lang", "ACHIEVEMENTS_LANG", "default language of the achievement texts"},
+	{"cache-ttl", "CACHE_TTL", "age after which Steam data is fetched again, e.g. 6h"},
+	{"app-ids", "ALLOWED_APPIDS", "comma-separated app IDs the public endpoints answer for"},
+}
+
+// registerConfigFlags adds -config, the shortcuts and -set to fs. They set
+// the environment as they are parsed.
+func registerConfigFlags(fs *flag.FlagSet) {
+	fs.String("config", "", "settings file, TOML or YAML (default $CONFIG_FILE)")
+	for _, f := range configFlags {
+		fs.Func(f.name, f.usage+" ("+f.key+")", func(v string) error { return os.Setenv(f.key, v) })
+	}
+	fs.Func("set", "KEY=value for any setting, repeatable", func(v string) error {
+		k, val, ok := strings.Cut(v, "=")
+		k = settingKey(k)
+		if !ok {
+			return errors.New("want KEY=value")
+		}
+		if _, known := configSettings[k]; !known {
+			return fmt.Errorf("unknown setting %s", k)
+		}
+		return os.Setenv(k, val)
+	})
+}
+
+// configFileArg is the -config of args, read before the flags are parsed
+// so that the file applies to every command; CONFIG_FILE otherwise.
+func configFileArg(args []string) string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		name, v, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return v
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return cleanEnvValue(os.Getenv("CONFIG_FILE"))
+}
+
+// loadConfigFile sets the settings of path that the environment leaves
+// unset. An empty path is no file.
+func loadConfigFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	var settings map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		settings, err = parseConfigFile(path, string(raw), parseTOMLLine)
+	case ".yaml", ".yml":
+		settings, err = parseConfigFile(path, string(raw), parseYAMLLine)
+	default:
+		return fmt.Errorf("config: %s: want a .toml, .yaml or .yml file", path)
+	}
+	if err != nil {
+		return err
+	}
+	for k, v := range settings {
+		if _, set := os.LookupEnv(k); !set {
+			os.Setenv(k, v)
+		}
+	}
+	return nil
+}
+
+// configLine is one line of a settings file: a key and its value, or a
+// section header (section set, key empty), or nothing.
+type configLine struct {
+	section *string
+	key     string
+	value   string
+}
+
+func parseConfigFile(path, raw string, parseLine func(line string) (configLine, error)) (map[string]string, error) {
+	settings := make(map[string]string)
+	var errs []error
+	section := ""
+	for n, line := range strings.Split(raw, "\n") {
+		cl, err := parseLine(strings.TrimRight(line, "\r"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", path, n+1, err))
+			continue
+		}
+		if cl.section != nil {
+			section = *cl.section
+		}
+		if cl.key == "" {
+			continue
+		}
+		key := settingKey(cl.key)
+		if section != "" {
+			key = settingKey(section) + "_" + key
+		}
+		if _, known := configSettings[key]; !known {
+			errs = append(errs, fmt.Errorf("%s:%d: unknown setting %s", path, n+1, key))
+			continue
+		}
+		settings[key] = cl.value
+	}
+	return settings, errors.Join(errs...)
+}
+
+func parseTOMLLine(line string) (configLine, error) {
+	t := strings.TrimSpace(line)
+	if t == "" || strings.HasPrefix(t, "#") {
+		return configLine{}, nil
+	}
+	if strings.HasPrefix(t, "[") {
+		name, rest, ok := strings.Cut(t[1:], "]")
+		if !ok || strings.Contains(name, "[") || strings.TrimSpace(stripComment(rest)) != "" {
+			return configLine{}, fmt.Errorf("bad section header %q", t)
+		}
+		name = strings.TrimSpace(name)
+		return configLine{section: &name}, nil
+	}
+	k, v, ok := strings.Cut(t, "=")
+	if !ok {
+		return configLine{}, fmt.Errorf("want key = value, got %q", t)
+	}
+	val, err := configValue(v)
+	return configLine{key: strings.TrimSpace(k), value: val}, err
+}
+
+// parseYAMLLine reads "key: value" at the top level, "name:" opening a
+// block and indented "key: value" inside it.
+func parseYAMLLine(line string) (configLine, error) {
+	t := strings.TrimSpace(line)
+	if t == "" || strings.HasPrefix(t, "#") || t == "---" {
+		return configLine{}, nil
+	}
+	if strings.HasPrefix(t, "- ") {
+		return configLine{}, errors.New("write lists on one line: [a, b]")
+	}
+	k, v, ok := strings.Cut(t, ":")
+	if !ok {
+		return configLine{}, fmt.Errorf("want key: value, got %q", t)
+	}
+	k = strings.TrimSpace(k)
+	indented := line[0] == ' ' || line[0] == '\t'
+	if strings.TrimSpace(stripComment(v)) == "" {
+		if indented {
+			return configLine{}, fmt.Errorf("%s: only one level of blocks is read", k)
+		}
+		return configLine{section: &k}, nil
+	}
+	val, err := configValue(v)
+	cl := configLine{key: k, value: val}
+	if !indented {
+		top := ""
+		cl.section = &top
+	}
+	return cl, err
+}
+
+// configValue decodes a scalar or a one-line list, common to both formats.
+func configValue(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := closingQuote(v)
+		if end < 0 || strings.TrimSpace(stripComment(v[end+1:])) != "" {
+			return "", fmt.Errorf("bad string %s", v)
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, "'"):
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 || strings.TrimSpace(stripComment(v[end+2:])) != "" {
+			return "", fmt.Errorf("bad string %s", v)
+		}
+		return v[1 : end+1], nil
+	case strings.HasPrefix(v, "["):
+		list, rest, ok := strings.Cut(v[1:], "]")
+		if !ok || strings.TrimSpace(stripComment(rest)) != "" {
+			return "", fmt.Errorf("bad list %s", v)
+		}
+		var items []string
+		for _, item := range strings.Split(list, ",") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	v = strings.TrimSpace(stripComment(v))
+	switch v {
+	case "true":
+		return "1", nil
+	case "false":
+		return "0", nil
+	}
+	return v, nil
+}
+
+// closingQuote is the index of the quote ending the string v starts.
+func closingQuote(v string) int {
+	for i := 1; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func stripComment(v string) string {
+	if i := strings.Index(v, " #"); i >= 0 {
+		return v[:i]
+	}
+	if strings.HasPrefix(strings.TrimSpace(v), "#") {
+		return ""
+	}
+	return v
+}
+
+func settingKey(k string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToUpper(strings.TrimSpace(k)))
+}
+
+// validateSettings checks that the numbers and durations set parse, which
+// the getenv helpers would otherwise replace by their default with a log
+// line.
+func validateSettings() error {
+	keys := make([]string, 0, len(configSettings))
+	for k := range configSettings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, k := range keys {
+		v := strings.TrimSpace(os.Getenv(k))
+		if v == "" {
+			continue
+		}
+		switch configSettings[k] {
+		case settingInt:
+			if _, err := strconv.Atoi(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q is not a whole number", k, v))
+			}
+		case settingDuration:
+			if d, err := time.ParseDuration(v); v != "0" && (err != nil || d < 0) {
+				errs = append(errs, fmt.Errorf("%s=%q is not a duration such as 30s, 5m or 6h", k, v))
+			}
+		}
+	}
+	if d := getenvDuration("CACHE_TTL", cacheTTL); d < time.Minute || d > 7*24*time.Hour {
+		errs = append(errs, fmt.Errorf("CACHE_TTL=%s must be between 1m and 168h", d))
+	}
+	if dir := cleanEnvValue(os.Getenv("STATIC_DIR")); dir != "" {
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			errs = append(errs, fmt.Errorf("STATIC_DIR=%q is not a directory", dir))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getAchievements(s *Server, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.handleAchievements(w, httptest.NewRequest(http.MethodGet, "/api/v1/achievements"+query, nil))
+	return w
+}
+
+func checkDataAge(t *testing.T, name string, w *httptest.ResponseRecorder, fetchedAt time.Time, stale bool) {
+	t.Helper()
+	h := w.Header()
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: %d %s", name, w.Code, w.Body)
+	}
+	if got := h.Get("X-Data-Fetched-At"); got != fetchedAt.UTC().Format(time.RFC3339) {
+		t.Errorf("%s: X-Data-Fetched-At %q, want %s", name, got, fetchedAt.UTC().Format(time.RFC3339))
+	}
+	age, err := strconv.ParseInt(h.Get("X-Data-Age-Seconds"), 10, 64)
+	if want := int64(time.Since(fetchedAt) / time.Second); err != nil || age < want-1 || age > want+1 {
+		t.Errorf("%s: X-Data-Age-Seconds %q, want about %d", name, h.Get("X-Data-Age-Seconds"), want)
+	}
+	if got := h.Get("X-Data-Stale") == "1"; got != stale {
+		t.Errorf("%s: X-Data-Stale %q, want stale %v", name, h.Get("X-Data-Stale"), stale)
+	}
+}
+
+func TestDataAgeGlobalList(t *testing.T) {
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	ctx := context.Background()
+	if err := s.syncFromSteam(ctx, "french"); err != nil {
+		t.Fatal(err)
+	}
+	synced, err := s.lastSyncAt(ctx)
+	if err != nil || synced.IsZero() {
+		t.Fatalf("last sync %v, %v", synced, err)
+	}
+	checkDataAge(t, "miss", getAchievements(s, ""), synced, false)
+	checkDataAge(t, "hit", getAchievements(s, ""), synced, false)
+
+	// The HTML footer shows the time of the header.
+	html := getAchievements(s, "?format=html")
+	checkDataAge(t, "html", html, synced, false)
+	if want := "Données Steam du " + formatDateTime(synced, numLocaleFrench, time.UTC); !strings.Contains(html.Body.String(), want) {
+		t.Errorf("HTML footer without %q", want)
+	}
+
+	old := time.Now().Add(-cacheTTL - time.Hour).Truncate(time.Second)
+	if _, err := s.db.Exec(`UPDATE meta SET value=? WHERE key='last_sync'`, strconv.FormatInt(old.Unix(), 10)); err != nil {
+		t.Fatal(err)
+	}
+	checkDataAge(t, "stale", getAchievements(s, ""), old, true)
+	checkDataAge(t, "stale html", getAchievements(s, "?format=html"), old, true)
+}
+
+func TestDataAgeAppList(t *testing.T) {
+	f := fakeSteamGame(t)
+	s := newTestServer(t)
+	miss := getAchievements(s, "?appId=440")
+	s.cacheMu.RLock()
+	key := globalPctCacheKey(context.Background(), 440)
+	fetched := s.appGlobalPctMap[key].fetchedAt
+	s.cacheMu.RUnlock()
+	if fetched.IsZero() {
+		t.Fatal("percentages of app 440 not cached")
+	}
+	checkDataAge(t, "miss", miss, fetched, false)
+	checkDataAge(t, "hit", getAchievements(s, "?appId=440"), fetched, false)
+
+	// Past the TTL, within the stale window, with Steam failing: the old
+	// percentages are served and said to be stale.
+	const pct = "GetGlobalAchievementPercentagesForApp"
+	f.handle(pct, http.StatusNotFound, ``)
+	fetches := f.count(pct)
+	old := time.Now().Add(-appMetaCacheTTL - time.Hour)
+	s.cacheMu.Lock()
+	entry := s.appGlobalPctMap[key]
+	entry.fetchedAt = old
+	s.appGlobalPctMap[key] = entry
+	s.cacheMu.Unlock()
+	checkDataAge(t, "stale", getAchievements(s, "?appId=440"), old, true)
+
+	// Let the background refresh end before the fake Steam goes away.
+	deadline := time.Now().Add(2 * time.Second)
+	for f.count(pct) == fetches && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.fetches.do(context.Background(), key.String(), func(context.Context) (any, error) { return nil, nil })
+}
+
+// If-Modified-Since is answered 304 while nothing changed, and 200 once a
+// runtime config PATCH or a policy edit may have changed the response
+// without a new fetch.
+func TestIfModifiedSinceFollowsShapeChanges(t *testing.T) {
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	dir := t.TempDir()
+	var err error
+	if s.policies, err = loadPolicyStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	h := withHTTPCaching(http.HandlerFunc(s.handleAchievements))
+	get := func(since string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements", nil)
+		if since != "" {
+			r.Header.Set("If-Modified-Since", since)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	revalidate := func(name, since string, want int) string {
+		t.Helper()
+		w := get(since)
+		if w.Code != want {
+			t.Fatalf("%s: %d with If-Modified-Since %s, want %d", name, w.Code, since, want)
+		}
+		if want == http.StatusOK && w.Header().Get("Last-Modified") == since {
+			t.Fatalf("%s: Last-Modified still %s", name, since)
+		}
+		return w.Header().Get("Last-Modified")
+	}
+
+	lm := revalidate("first", "", http.StatusOK)
+	revalidate("unchanged", lm, http.StatusNotModified)
+
+	patchConfig(t, s, `{"staleWhileRevalidate":"10m"}`)
+	lm = revalidate("after a config PATCH", lm, http.StatusOK)
+	revalidate("unchanged after the PATCH", lm, http.StatusNotModified)
+
+	if err := os.WriteFile(filepath.Join(dir, "105600.json"), []byte(`{"hide":["B"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lm = revalidate("after a policy edit", lm, http.StatusOK)
+	revalidate("unchanged after the policy edit", lm, http.StatusNotModified)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // ?tz= must work on images without a zoneinfo database
+)
+
+// Human-facing outputs (HTML, Markdown) spell dates out in the reader's
+// locale, the same one numberLocaleFor picks. JSON keeps RFC3339 UTC.
+var monthNames = map[string][12]string{
+	numLocaleFrench:  {"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+	numLocaleEnglish: {"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+}
+
+// timeZoneFor reads the optional ?tz= IANA zone name; UTC by default.
+func timeZoneFor(r *http.Request) (*time.Location, error) {
+	name := strings.TrimSpace(r.URL.Query().Get("tz"))
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, errors.New("tz doit etre un fuseau IANA (ex: Europe/Paris)")
+	}
+	return loc, nil
+}
+
+// formatDate renders "15 janvier 2025" in French and "January 15, 2025" in
+// English, in loc.
+func formatDate(t time.Time, locale string, loc *time.Location) string {
+	t = t.In(loc)
+	months, ok := monthNames[locale]
+	if !ok {
+		months = monthNames[numLocaleEnglish]
+		locale = numLocaleEnglish
+	}
+	month := months[t.Month()-1]
+	day, year := strconv.Itoa(t.Day()), strconv.Itoa(t.Year())
+	if locale == numLocaleFrench {
+		if t.Day() == 1 {
+			day = "1er"
+		}
+		return day + " " + month + " " + year
+	}
+	return month + " " + day + ", " + year
+}
+
+// formatDateTime adds the local time: "15 janvier 2025 à 14:05" or
+// "January 15, 2025 at 2:05 PM".
+func formatDateTime(t time.Time, locale string, loc *time.Location) string {
+	t = t.In(loc)
+	if locale == numLocaleFrench {
+		return formatDate(t, locale, loc) + " à " + t.Format("15:04")
+	}
+	return formatDate(t, locale, loc) + " at " + t.Format("3:04 PM")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestFormatDate(t *testing.T) {
+	at := time.Date(2025, 1, 15, 13, 5, 0, 0, time.UTC)
+	tests := []struct {
+		t      time.Time
+		locale string
+		want   string
+	}{
+		{at, numLocaleFrench, "15 janvier 2025"},
+		{at, numLocaleEnglish, "January 15, 2025"},
+		{time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC), numLocaleFrench, "1er août 2025"},
+		{time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC), numLocaleEnglish, "August 1, 2025"},
+		{time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), "de", "December 31, 2025"},
+	}
+	for _, tt := range tests {
+		if got := formatDate(tt.t, tt.locale, time.UTC); got != tt.want {
+			t.Errorf("formatDate(%v, %s) = %q, want %q", tt.t, tt.locale, got, tt.want)
+		}
+	}
+	if got := formatDateTime(at, numLocaleFrench, time.UTC); got != "15 janvier 2025 à 13:05" {
+		t.Errorf("French date and time = %q", got)
+	}
+	if got := formatDateTime(at, numLocaleEnglish, time.UTC); got != "January 15, 2025 at 1:05 PM" {
+		t.Errorf("English date and time = %q", got)
+	}
+}
+
+// Wall clock times on both sides of the spring and autumn changes, and a
+// date that is not the UTC one.
+func TestFormatDateTimeAcrossDST(t *testing.T) {
+	paris, newYork := mustZone(t, "Europe/Paris"), mustZone(t, "America/New_York")
+	tests := []struct {
+		utc    time.Time
+		locale string
+		loc    *time.Location
+		want   string
+	}{
+		// Paris springs forward on 30 March 2025 at 01:00 UTC.
+		{time.Date(2025, 3, 30, 0, 30, 0, 0, time.UTC), numLocaleFrench, paris, "30 mars 2025 à 01:30"},
+		{time.Date(2025, 3, 30, 1, 30, 0, 0, time.UTC), numLocaleFrench, paris, "30 mars 2025 à 03:30"},
+		// and falls back on 26 October 2025 at 01:00 UTC: 02:30 twice.
+		{time.Date(2025, 10, 26, 0, 30, 0, 0, time.UTC), numLocaleFrench, paris, "26 octobre 2025 à 02:30"},
+		{time.Date(2025, 10, 26, 1, 30, 0, 0, time.UTC), numLocaleFrench, paris, "26 octobre 2025 à 02:30"},
+		{time.Date(2025, 10, 26, 2, 30, 0, 0, time.UTC), numLocaleFrench, paris, "26 octobre 2025 à 03:30"},
+		// New York springs forward on 9 March 2025 at 07:00 UTC.
+		{time.Date(2025, 3, 9, 6, 30, 0, 0, time.UTC), numLocaleEnglish, newYork, "March 9, 2025 at 1:30 AM"},
+		{time.Date(2025, 3, 9, 7, 30, 0, 0, time.UTC), numLocaleEnglish, newYork, "March 9, 2025 at 3:30 AM"},
+		{time.Date(2025, 11, 2, 5, 30, 0, 0, time.UTC), numLocaleEnglish, newYork, "November 2, 2025 at 1:30 AM"},
+		{time.Date(2025, 11, 2, 6, 30, 0, 0, time.UTC), numLocaleEnglish, newYork, "November 2, 2025 at 1:30 AM"},
+		// The day changes with the zone.
+		{time.Date(2024, 12, 31, 23, 30, 0, 0, time.UTC), numLocaleFrench, paris, "1er janvier 2025 à 00:30"},
+		{time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC), numLocaleEnglish, newYork, "December 31, 2024 at 10:00 PM"},
+	}
+	for _, tt := range tests {
+		if got := formatDateTime(tt.utc, tt.locale, tt.loc); got != tt.want {
+			t.Errorf("formatDateTime(%v, %s, %s) = %q, want %q", tt.utc, tt.locale, tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestTimeZoneFor(t *testing.T) {
+	for raw, want := range map[string]string{"": "UTC", "Europe/Paris": "Europe/Paris", " America/New_York ": "America/New_York"} {
+		loc, err := timeZoneFor(httptest.NewRequest(http.MethodGet, "/?tz="+strings.ReplaceAll(raw, " ", "%20"), nil))
+		if err != nil || loc.String() != want {
+			t.Errorf("tz=%q = %v, %v; want %s", raw, loc, err, want)
+		}
+	}
+	for _, raw := range []string{"Local", "Mars/Olympus", "../etc/passwd"} {
+		if _, err := timeZoneFor(httptest.NewRequest(http.MethodGet, "/?tz="+raw, nil)); err == nil {
+			t.Errorf("tz=%q accepted", raw)
+		}
+	}
+	if _, err := parseAchievementQuery(httptest.NewRequest(http.MethodGet, "/api/v1/achievements?tz=Nowhere", nil)); err == nil {
+		t.Errorf("parseAchievementQuery accepted tz=Nowhere")
+	}
+}
+
+func unlockFixture() []Achievement {
+	return []Achievement{
+		{APIName: "A", Name: "Premier_pas", Description: "Tuer *un* slime", GlobalPct: 80, Achieved: true, UnlockTime: apiTime(time.Date(2025, 3, 30, 1, 30, 0, 0, time.UTC).Unix())},
+		{APIName: "B", Name: "Boss", GlobalPct: 2.5, Achieved: true},
+		{APIName: "C", Name: "Secret", GlobalPct: 0.5},
+	}
+}
+
+func playerExport(t *testing.T, query, lang string) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/players/76561197960287930/achievements?"+query, nil)
+	q, err := parseAchievementQuery(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.format, _ = negotiateFormat(httptest.NewRecorder(), r)
+	q.lang, q.unlocks = lang, true
+	w := httptest.NewRecorder()
+	if !q.export(w, 105600, unlockFixture()) {
+		t.Fatalf("%q not exported", query)
+	}
+	return w.Body.String()
+}
+
+func TestExportDates(t *testing.T) {
+	tests := []struct {
+		query, lang string
+		want        []string
+	}{
+		{"format=html", "french", []string{"<td>30 mars 2025 à 01:30</td>", "<td>oui</td>"}},
+		{"format=html&tz=Europe/Paris", "french", []string{"<td>30 mars 2025 à 03:30</td>"}},
+		{"format=html&tz=America/New_York&numlocale=en", "french", []string{"<td>March 29, 2025 at 9:30 PM</td>", "<td>yes</td>"}},
+		{"format=md&tz=Europe/Paris", "french", []string{
+			"# Succès de l'app 105600\n\n",
+			"- [x] **Premier\\_pas** - Tuer \\*un\\* slime (30 mars 2025 à 03:30)\n",
+			"- [x] **Boss**\n",
+			"- [ ] **Secret**\n",
+		}},
+		{"format=md", "english", []string{"# Achievements of app 105600\n", "(March 30, 2025 at 1:30 AM)\n"}},
+	}
+	for _, tt := range tests {
+		body := playerExport(t, tt.query, tt.lang)
+		for _, s := range tt.want {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s (lang %s): %q missing from\n%s", tt.query, tt.lang, s, body)
+			}
+		}
+	}
+
+	// JSON keeps RFC3339 UTC whatever ?tz= says.
+	b, _ := unlockFixture()[0].UnlockTime.MarshalJSON()
+	if string(b) != `"2025-03-30T01:30:00Z"` {
+		t.Errorf("JSON unlock time = %s", b)
+	}
+}
+
+// A list without unlocks shows the percentage instead of a date.
+func TestExportMarkdownGlobalList(t *testing.T) {
+	var b strings.Builder
+	e := achievementExport{AppID: 105600, Items: exportFixture(), NumLocale: numLocaleFrench}
+	if err := encodeAchievementsMarkdown(&b, e); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Succès de l'app 105600\n\n- [ ] **Facile** (2,3\u202f%)\n- [ ] **Dur** (12,25\u202f%)\n"
+	if b.String() != want {
+		t.Errorf("markdown = %q, want %q", b.String(), want)
+	}
+	if acceptedFormat("text/markdown") != formatMD {
+		t.Errorf("Accept: text/markdown not mapped to md")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"strconv"
@@ -9,8 +10,20 @@ import (
 )
 
 func (s *Server) initDB() error {
+	if _, err := s.db.Exec(`PRAGMA journal_mode=WAL;`); err != nil {
+		return err
+	}
+	if err := s.migrateDB(); err != nil {
+		return err
+	}
+	return s.seedArchive()
+}
+
+// createBaseSchema is migration 1: the tables as they were before the
+// database carried a version. Every statement is idempotent so unversioned
+// databases from older builds go through it unchanged.
+func createBaseSchema(tx *sql.Tx) error {
 	stmts := []string{
-		`PRAGMA journal_mode=WAL;`,
 		`CREATE TABLE IF NOT EXISTS achievements (
 			api_name   TEXT PRIMARY KEY,
 			name       TEXT NOT NULL,
@@ -60,36 +73,113 @@ func (s *Server) initDB() error {
 			value TEXT NOT NULL,
 			PRIMARY KEY(steam_id, key)
 		);`,
+		`CREATE TABLE IF NOT EXISTS global_percent_history (
+			app_id INTEGER NOT NULL,
+			api_name TEXT NOT NULL,
+			percent REAL NOT NULL,
+			recorded_at INTEGER NOT NULL,
+			source TEXT NOT NULL DEFAULT 'live'
+		);`,
+		`CREATE TABLE IF NOT EXISTS achievement_schema_history (
+			app_id INTEGER NOT NULL,
+			api_name TEXT NOT NULL,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL,
+			icon TEXT NOT NULL,
+			icon_gray TEXT NOT NULL,
+			hidden INTEGER NOT NULL,
+			first_seen INTEGER NOT NULL,
+			removed_at INTEGER,
+			PRIMARY KEY(app_id, api_name)
+		);`,
 		`CREATE INDEX IF NOT EXISTS idx_user_games_steam_id ON user_games(steam_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_user_achievements_steam_app ON user_achievements(steam_id, app_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_global_percent_history_app_name ON global_percent_history(app_id, api_name, recorded_at);`,
 	}
 	for _, q := range stmts {
-		if _, err := s.db.Exec(q); err != nil {
+		if _, err := tx.Exec(q); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *Server) isCacheExpired() (bool, error) {
+// ensureColumn adds a column to an existing table created by an older build.
+func ensureColumn(tx *sql.Tx, table string, column string, def string) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + def)
+	return err
+}
+
+func (s *Server) isCacheExpired(ctx context.Context) (bool, error) {
+	last, err := s.lastSyncAt(ctx)
+	if err != nil {
+		return true, err
+	}
+	return last.IsZero() || time.Since(last) > s.cacheTTLFor(ctx, s.cfg().CacheTTL), nil
+}
+
+// lastSyncAt is when the global list was last synced, zero if never.
+func (s *Server) lastSyncAt(ctx context.Context) (time.Time, error) {
+	if ns := cacheNamespace(ctx); ns != "" {
+		g, _ := s.isolated.globalOf(ns)
+		return g.syncedAt, nil
+	}
 	var v string
 	err := s.db.QueryRow(`SELECT value FROM meta WHERE key='last_sync'`).Scan(&v)
+	return parseSyncTime(v, err)
+}
+
+// userLastSyncAt is when steamID was last synced, zero if never.
+func (s *Server) userLastSyncAt(ctx context.Context, steamID SteamID) (time.Time, error) {
+	if ns := cacheNamespace(ctx); ns != "" {
+		if u, ok := s.isolated.user(ns, steamID); ok {
+			return u.syncedAt, nil
+		}
+		return time.Time{}, nil
+	}
+	var v string
+	err := s.db.QueryRow(`SELECT value FROM user_meta WHERE steam_id=? AND key='last_sync'`, steamID).Scan(&v)
+	return parseSyncTime(v, err)
+}
+
+func parseSyncTime(v string, err error) (time.Time, error) {
 	if errors.Is(err, sql.ErrNoRows) {
-		return true, nil
+		return time.Time{}, nil
 	}
 	if err != nil {
-		return true, err
+		return time.Time{}, err
 	}
-
 	sec, err := strconv.ParseInt(v, 10, 64)
 	if err != nil {
-		return true, nil
+		return time.Time{}, nil
 	}
-	last := time.Unix(sec, 0)
-	return time.Since(last) > cacheTTL, nil
+	return time.Unix(sec, 0).UTC(), nil
 }
 
-func (s *Server) setLastSync(t time.Time) error {
+func (s *Server) setLastSync(ctx context.Context, t time.Time) error {
+	if ns := cacheNamespace(ctx); ns != "" {
+		s.isolated.setGlobalSyncedAt(ns, t)
+		return nil
+	}
 	_, err := s.db.Exec(`
 		INSERT INTO meta(key,value) VALUES('last_sync', ?)
 		ON CONFLICT(key) DO UPDATE SET value=excluded.value
@@ -97,22 +187,22 @@ func (s *Server) setLastSync(t time.Time) error {
 	return err
 }
 
-func (s *Server) isUserCacheExpired(steamID string) (bool, error) {
-	var v string
-	err := s.db.QueryRow(`SELECT value FROM user_meta WHERE steam_id=? AND key='last_sync'`, steamID).Scan(&v)
-	if errors.Is(err, sql.ErrNoRows) {
-		return true, nil
-	}
+func (s *Server) isUserCacheExpired(ctx context.Context, steamID SteamID) (bool, error) {
+	last, err := s.userLastSyncAt(ctx, steamID)
 	if err != nil {
 		return true, err
 	}
+	return last.IsZero() || time.Since(last) > s.cacheTTLFor(ctx, s.cfg().CacheTTL), nil
+}
 
-	sec, err := strconv.ParseInt(v, 10, 64)
-	if err != nil {
-		return true, nil
+// readGlobalAchievements is the stored list of the default app: the
+// database, or the isolated sync of ctx's namespace.
+func (s *Server) readGlobalAchievements(ctx context.Context) ([]Achievement, error) {
+	if ns := cacheNamespace(ctx); ns != "" {
+		g, _ := s.isolated.globalOf(ns)
+		return append(make([]Achievement, 0, len(g.items)), g.items...), nil
 	}
-
-	return time.Since(time.Unix(sec, 0)) > cacheTTL, nil
+	return s.readAchievementsFromDB()
 }
 
 func (s *Server) readAchievementsFromDB() ([]Achievement, error) {
@@ -127,7 +217,7 @@ func (s *Server) readAchievementsFromDB() ([]Achievement, error) {
 	}
 	defer rows.Close()
 
-	var out []Achievement
+	out := make([]Achievement, 0)
 	for rows.Next() {
 		var a Achievement
 		var hiddenInt int
@@ -140,9 +230,16 @@ func (s *Server) readAchievementsFromDB() ([]Achievement, error) {
 	return out, rows.Err()
 }
 
-func (s *Server) readUserGamesFromDB(steamID string) ([]GameCompletion, error) {
+func (s *Server) readUserGamesFromDB(ctx context.Context, steamID SteamID) ([]GameCompletion, error) {
+	if ns := cacheNamespace(ctx); ns != "" {
+		u, _ := s.isolated.user(ns, steamID)
+		if u == nil {
+			return []GameCompletion{}, nil
+		}
+		return append([]GameCompletion{}, u.games...), nil
+	}
 	rows, err := s.db.Query(`
-		SELECT app_id, name, playtime_forever, total_achievements, unlocked_achievements, completion_pct
+		SELECT app_id, name, playtime_forever, total_achievements, unlocked_achievements, completion_pct, status
 		FROM user_games
 		WHERE steam_id=?
 		ORDER BY completion_pct DESC, name ASC
@@ -155,7 +252,7 @@ func (s *Server) readUserGamesFromDB(steamID string) ([]GameCompletion, error) {
 	out := make([]GameCompletion, 0)
 	for rows.Next() {
 		var g GameCompletion
-		if err := rows.Scan(&g.AppID, &g.Name, &g.PlaytimeForever, &g.TotalAchievements, &g.UnlockedAchievements, &g.CompletionPct); err != nil {
+		if err := rows.Scan(&g.AppID, &g.Name, &g.PlaytimeForever, &g.TotalAchievements, &g.UnlockedAchievements, &g.CompletionPct, &g.Status); err != nil {
 			return nil, err
 		}
 		out = append(out, g)
@@ -164,7 +261,14 @@ func (s *Server) readUserGamesFromDB(steamID string) ([]GameCompletion, error) {
 	return out, rows.Err()
 }
 
-func (s *Server) readUserAchievementsFromDB(steamID string, appID int) ([]Achievement, error) {
+func (s *Server) readUserAchievementsFromDB(ctx context.Context, steamID SteamID, appID AppID) ([]Achievement, error) {
+	if ns := cacheNamespace(ctx); ns != "" {
+		u, _ := s.isolated.user(ns, steamID)
+		if u == nil {
+			return []Achievement{}, nil
+		}
+		return append([]Achievement{}, u.achievements[appID]...), nil
+	}
 	rows, err := s.db.Query(`
 		SELECT api_name, name, description, icon, icon_gray, hidden, global_pct, achieved, unlock_time
 		FROM user_achievements
@@ -191,8 +295,10 @@ func (s *Server) readUserAchievementsFromDB(steamID string, appID int) ([]Achiev
 	return out, rows.Err()
 }
 
+// readUserSuggestionsFromDB returns up to limit players (12 when 0, all
+// when negative) matching query on their real name or id.
 func (s *Server) readUserSuggestionsFromDB(query string, limit int) ([]UserSuggestion, error) {
-	if limit <= 0 {
+	if limit == 0 {
 		limit = 12
 	}
 
@@ -205,8 +311,8 @@ func (s *Server) readUserSuggestionsFromDB(query string, limit int) ([]UserSugge
 		SELECT
 			u.steam_id,
 			COALESCE(pname.value, u.steam_id) AS display_name,
-			COUNT(*) AS games_count,
-			COALESCE(AVG(u.completion_pct), 0.0) AS avg_completion
+			COUNT(CASE WHEN u.status = 'ok' THEN 1 END) AS games_count,
+			COALESCE(AVG(CASE WHEN u.status = 'ok' THEN u.completion_pct END), 0.0) AS avg_completion
 		FROM user_games u
 		LEFT JOIN user_meta pname
 			ON pname.steam_id = u.steam_id AND pname.key = 'profile_name'
@@ -220,7 +326,7 @@ func (s *Server) readUserSuggestionsFromDB(query string, limit int) ([]UserSugge
 	}
 	defer rows.Close()
 
-	out := make([]UserSuggestion, 0, limit)
+	out := make([]UserSuggestion, 0, max(limit, 0))
 	for rows.Next() {
 		var s UserSuggestion
 		if err := rows.Scan(&s.SteamID, &s.DisplayName, &s.GamesCount, &s.AvgCompletion); err != nil {
@@ -232,8 +338,17 @@ func (s *Server) readUserSuggestionsFromDB(query string, limit int) ([]UserSugge
 	return out, rows.Err()
 }
 
-func (s *Server) readUserProfileFromDB(steamID string) (UserProfile, error) {
-	profile := UserProfile{SteamID: steamID, DisplayName: steamID}
+func (s *Server) readUserProfileFromDB(ctx context.Context, steamID SteamID) (UserProfile, error) {
+	profile := UserProfile{SteamID: steamID, DisplayName: steamID.String()}
+	if ns := cacheNamespace(ctx); ns != "" {
+		if u, ok := s.isolated.user(ns, steamID); ok {
+			if u.profile.DisplayName != "" {
+				profile.DisplayName = u.profile.DisplayName
+			}
+			profile.AvatarURL = u.profile.AvatarURL
+		}
+		return profile, nil
+	}
 
 	rows, err := s.db.Query(`
 		SELECT key, value
@@ -263,21 +378,31 @@ func (s *Server) readUserProfileFromDB(steamID string) (UserProfile, error) {
 	return profile, rows.Err()
 }
 
-func (s *Server) upsertUserMetaValue(steamID string, key string, value string) error {
-	_, err := s.db.Exec(`
-		INSERT INTO user_meta(steam_id,key,value) VALUES(?,?,?)
-		ON CONFLICT(steam_id,key) DO UPDATE SET value=excluded.value
-	`, steamID, key, value)
-	return err
+const upsertUserMetaSQL = `
+	INSERT INTO user_meta(steam_id,key,value) VALUES(?,?,?)
+	ON CONFLICT(steam_id,key) DO UPDATE SET value=excluded.value
+`
+
+// upsertUserMetaValue returns once the value is committed. It goes through
+// the write queue so it stays ordered with queueUserMetaValue writes.
+func (s *Server) upsertUserMetaValue(ctx context.Context, steamID SteamID, key string, value string) error {
+	return s.writes.exec(ctx, upsertUserMetaSQL, steamID, key, value)
+}
+
+func (s *Server) queueUserMetaValue(steamID SteamID, key string, value string) {
+	s.writes.enqueue(upsertUserMetaSQL, steamID, key, value)
 }
 
-func (s *Server) resolveSteamIDInput(raw string) (string, error) {
+func (s *Server) resolveSteamIDInput(raw string) (SteamID, error) {
 	v := strings.TrimSpace(raw)
 	if v == "" {
-		return "", errors.New("empty user identifier")
+		return 0, errors.New("empty user identifier")
+	}
+	if pseudonyms.enabled() {
+		return s.resolvePseudonymInput(v)
 	}
-	if isValidSteamID64(v) {
-		return v, nil
+	if id, ok, err := parseSteamID(v); ok {
+		return id, err
 	}
 
 	rows, err := s.db.Query(`
@@ -287,28 +412,28 @@ func (s *Server) resolveSteamIDInput(raw string) (string, error) {
 		LIMIT 2
 	`, v)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
 	defer rows.Close()
 
-	matched := make([]string, 0, 2)
+	matched := make([]SteamID, 0, 2)
 	for rows.Next() {
-		var id string
+		var id SteamID
 		if scanErr := rows.Scan(&id); scanErr != nil {
-			return "", scanErr
+			return 0, scanErr
 		}
 		matched = append(matched, id)
 	}
 	if err := rows.Err(); err != nil {
-		return "", err
+		return 0, err
 	}
 
 	if len(matched) == 1 {
 		return matched[0], nil
 	}
 	if len(matched) > 1 {
-		return "", errors.New("ambiguous profile name")
+		return 0, errors.New("ambiguous profile name")
 	}
 
-	return "", errors.New("unknown profile name")
+	return 0, errors.New("unknown profile name")
 }
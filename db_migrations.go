@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// dbMigrations upgrades the SQLite schema one version at a time; entry i
+// takes a database from version i to i+1 and the version is kept in PRAGMA
+// user_version. Append new steps, never edit shipped ones: a database that
+// already ran a step will not run it again.
+var dbMigrations = []struct {
+	name string
+	up   func(tx *sql.Tx) error
+}{
+	{"base schema", createBaseSchema},
+	{"user_games.status", func(tx *sql.Tx) error {
+		return ensureColumn(tx, "user_games", "status", "TEXT NOT NULL DEFAULT 'ok'")
+	}},
+	{"usage_daily", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS usage_daily (
+			day TEXT NOT NULL,
+			route TEXT NOT NULL,
+			dimension TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			PRIMARY KEY(day, route, dimension)
+		)`)
+		return err
+	}},
+	{"translation_state", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS translation_state (
+			app_id INTEGER NOT NULL,
+			api_name TEXT NOT NULL,
+			lang TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			changed_at INTEGER NOT NULL,
+			PRIMARY KEY(app_id, api_name, lang)
+		)`)
+		return err
+	}},
+	{"admin_notes", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS admin_notes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			scope TEXT NOT NULL,
+			object_id TEXT NOT NULL,
+			author TEXT NOT NULL,
+			body TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS admin_notes_object ON admin_notes(scope, object_id)`)
+		return err
+	}},
+	{"global_percent_history by time", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_global_percent_history_app_time ON global_percent_history(app_id, recorded_at)`)
+		return err
+	}},
+	{"drop isolated last_sync rows", func(tx *sql.Tx) error {
+		// TEST_MODE isolated syncs were tracked as last_sync:<namespace>;
+		// they now live in memory only.
+		if _, err := tx.Exec(`DELETE FROM meta WHERE key GLOB 'last_sync:*'`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM user_meta WHERE key GLOB 'last_sync:*'`)
+		return err
+	}},
+}
+
+func dbSchemaVersion() int { return len(dbMigrations) }
+
+// migrateDB brings the database to dbSchemaVersion. A copy of the file is
+// written next to it before touching an existing database, and a database
+// written by a newer build is refused rather than half understood.
+func (s *Server) migrateDB() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	target := dbSchemaVersion()
+	if version > target {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d): upgrade the server or restore a backup", version, target)
+	}
+	if version == target {
+		return nil
+	}
+
+	var tables int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables > 0 {
+		backup, err := s.backupDB(version)
+		if err != nil {
+			return fmt.Errorf("backup before migrating from version %d: %w", version, err)
+		}
+		if backup != "" {
+			log.Printf("database backup written to %s before migrating %d -> %d", backup, version, target)
+		}
+	}
+
+	for v := version; v < target; v++ {
+		m := dbMigrations[v]
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.up(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", v+1, m.name, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, v+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", v+1, m.name, err)
+		}
+		log.Printf("database migrated to version %d (%s)", v+1, m.name)
+	}
+	return nil
+}
+
+// backupDB copies the main database file with VACUUM INTO. In-memory
+// databases have no file and are not backed up.
+func (s *Server) backupDB(version int) (string, error) {
+	var seq int
+	var name, file string
+	if err := s.db.QueryRow(`SELECT seq, name, file FROM pragma_database_list WHERE name = 'main'`).Scan(&seq, &name, &file); err != nil {
+		return "", err
+	}
+	if file == "" {
+		return "", nil
+	}
+	path := fmt.Sprintf("%s.v%d-%s.bak", file, version, time.Now().UTC().Format("20060102T150405"))
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	if _, err := s.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dbFixture is a database as a build at schema version v left it, with a
+// player, a global percentage and the meta rows of the time. Version 0 is
+// an unversioned database from before the migrations.
+func dbFixture(t *testing.T, v int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), fmt.Sprintf("v%d.db", v))
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := dbMigrations[:v]
+	if v == 0 {
+		steps = dbMigrations[:1]
+	}
+	for _, m := range steps {
+		if err := m.up(tx); err != nil {
+			t.Fatalf("fixture v%d: %s: %v", v, m.name, err)
+		}
+	}
+	for _, q := range []string{
+		`INSERT INTO achievements(api_name, name, description, icon, icon_gray, hidden) VALUES('A', 'Facile', 'd', '', '', 0)`,
+		`INSERT INTO global_percent(api_name, percent, updated_at) VALUES('A', 80, 1700000000)`,
+		`INSERT INTO user_games(steam_id, app_id, name, playtime_forever, updated_at) VALUES('76561197960287930', 105600, 'Terraria', 60, 1700000000)`,
+		`INSERT INTO user_achievements(steam_id, app_id, api_name, name, description, icon, icon_gray, hidden, achieved, unlock_time, updated_at)
+			VALUES('76561197960287930', 105600, 'A', 'Facile', 'd', '', '', 0, 1, 1700000000, 1700000000)`,
+		`INSERT INTO meta(key, value) VALUES('last_sync', '1700000000'), ('last_sync:run-42', '1700000000')`,
+		`INSERT INTO user_meta(steam_id, key, value) VALUES('76561197960287930', 'last_sync', '1700000000'), ('76561197960287930', 'last_sync:run-42', '1')`,
+		fmt.Sprintf(`PRAGMA user_version = %d`, v),
+	} {
+		if _, err := tx.Exec(q); err != nil {
+			t.Fatalf("fixture v%d: %v", v, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func userVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var v int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func migratedServer(t *testing.T, path string) (*Server, error) {
+	t.Helper()
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := newServer(db, "test-key")
+	return s, s.initDB()
+}
+
+func TestMigrateFromEveryVersion(t *testing.T) {
+	for v := 0; v < dbSchemaVersion(); v++ {
+		t.Run(fmt.Sprintf("v%d", v), func(t *testing.T) {
+			path := dbFixture(t, v)
+			s, err := migratedServer(t, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := userVersion(t, s.db); got != dbSchemaVersion() {
+				t.Fatalf("version %d after migrating, want %d", got, dbSchemaVersion())
+			}
+
+			var name, status string
+			var playtime int
+			if err := s.db.QueryRow(`SELECT name, playtime_forever, status FROM user_games WHERE steam_id='76561197960287930'`).Scan(&name, &playtime, &status); err != nil {
+				t.Fatal(err)
+			}
+			if name != "Terraria" || playtime != 60 || status != "ok" {
+				t.Errorf("player game = %s, %d, %q", name, playtime, status)
+			}
+			var achieved, unlock int64
+			if err := s.db.QueryRow(`SELECT achieved, unlock_time FROM user_achievements WHERE api_name='A'`).Scan(&achieved, &unlock); err != nil || achieved != 1 || unlock != 1700000000 {
+				t.Errorf("player achievement = %d, %d, %v", achieved, unlock, err)
+			}
+			var pct float64
+			if err := s.db.QueryRow(`SELECT percent FROM global_percent WHERE api_name='A'`).Scan(&pct); err != nil || pct != 80 {
+				t.Errorf("global percent = %v, %v", pct, err)
+			}
+			var isolated, synced int
+			s.db.QueryRow(`SELECT COUNT(*) FROM meta WHERE key GLOB 'last_sync:*'`).Scan(&isolated)
+			s.db.QueryRow(`SELECT COUNT(*) FROM meta WHERE key = 'last_sync'`).Scan(&synced)
+			if isolated != 0 || synced != 1 {
+				t.Errorf("meta: %d isolated last_sync rows, %d last_sync; want 0 and 1", isolated, synced)
+			}
+			for _, table := range []string{"usage_daily", "translation_state", "admin_notes"} {
+				if _, err := s.db.Exec(`SELECT COUNT(*) FROM ` + table); err != nil {
+					t.Errorf("table %s: %v", table, err)
+				}
+			}
+
+			// The backup holds the database as it was before migrating.
+			backups, _ := filepath.Glob(fmt.Sprintf("%s.v%d-*.bak", path, v))
+			if len(backups) != 1 {
+				t.Fatalf("backups %v, want one of version %d", backups, v)
+			}
+			old, err := openDB(backups[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer old.Close()
+			var rows int
+			if got := userVersion(t, old); got != v || old.QueryRow(`SELECT COUNT(*) FROM user_games`).Scan(&rows) != nil || rows != 1 {
+				t.Errorf("backup: version %d, %d player games", got, rows)
+			}
+
+			// Up to date: nothing runs, no new backup.
+			if err := s.initDB(); err != nil {
+				t.Fatal(err)
+			}
+			if again, _ := filepath.Glob(path + ".*.bak"); len(again) != 1 {
+				t.Errorf("backups after a second start: %v", again)
+			}
+		})
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fresh.db")
+	s, err := migratedServer(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := userVersion(t, s.db); got != dbSchemaVersion() {
+		t.Errorf("version %d, want %d", got, dbSchemaVersion())
+	}
+	if backups, _ := filepath.Glob(path + ".*.bak"); len(backups) != 0 {
+		t.Errorf("backup of an empty database: %v", backups)
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	path := dbFixture(t, dbSchemaVersion())
+	db, _ := openDB(path)
+	db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, dbSchemaVersion()+1))
+	db.Close()
+
+	s, err := migratedServer(t, path)
+	if err == nil || !strings.Contains(err.Error(), "newer than this build supports") {
+		t.Fatalf("initDB of a newer database = %v", err)
+	}
+	if got := userVersion(t, s.db); got != dbSchemaVersion()+1 {
+		t.Errorf("version changed to %d", got)
+	}
+}
+
+// A failing step keeps the database at the last version that succeeded.
+func TestMigrateFailureRollsBack(t *testing.T) {
+	path := dbFixture(t, dbSchemaVersion())
+	saved := dbMigrations
+	t.Cleanup(func() { dbMigrations = saved })
+	dbMigrations = append(saved[:len(saved):len(saved)], struct {
+		name string
+		up   func(tx *sql.Tx) error
+	}{"broken", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM user_games`); err != nil {
+			return err
+		}
+		return errors.New("boom")
+	}})
+
+	s, err := migratedServer(t, path)
+	if want := fmt.Sprintf("migration %d (broken): boom", len(saved)+1); err == nil || err.Error() != want {
+		t.Fatalf("initDB = %v, want %s", err, want)
+	}
+	var rows int
+	if got := userVersion(t, s.db); got != len(saved) || s.db.QueryRow(`SELECT COUNT(*) FROM user_games`).Scan(&rows) != nil || rows != 1 {
+		t.Errorf("after a failed step: version %d, %d player games", got, rows)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Aggregate endpoints (/bootstrap, /tags) are kept as whole responses:
+// their inputs only change on a refresh, and a refresh bumps the
+// generation of the scopes it touched, so an entry is valid as long as the
+// generation of its scopes, the visibility policy and, for what reads the
+// global list, the cache TTL it was built under are. A refresh of one app
+// leaves the entries of the others alone. There is nothing to tune but the size,
+// DERIVED_CACHE_SIZE entries, evicted least recently used first. A
+// response that was partial, stale or built across a refresh is sent but
+// not kept. X-Derived-Cache says whether the answer was replayed.
+const defaultDerivedCacheSize = 256
+
+type derivedResponse struct {
+	key        string
+	header     http.Header
+	body       []byte
+	generation uint64 // of the scopes, see scopesGeneration
+	policy     *appPolicy
+	expires    time.Time // zero: only the generation ends it
+}
+
+type derivedResponseCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List // front: most recently used
+	entries map[string]*list.Element
+	evicted int64
+}
+
+func newDerivedResponseCache(max int) *derivedResponseCache {
+	if max <= 0 {
+		max = defaultDerivedCacheSize
+	}
+	return &derivedResponseCache{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *derivedResponseCache) get(key string, generation uint64, policy *appPolicy, now time.Time) *derivedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*derivedResponse)
+	if e.generation != generation || e.policy != policy || (!e.expires.IsZero() && !now.Before(e.expires)) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return e
+}
+
+func (c *derivedResponseCache) put(e *derivedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.order.PushFront(e)
+	for c.order.Len() > c.max {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*derivedResponse).key)
+		c.evicted++
+	}
+}
+
+func (c *derivedResponseCache) stats() (entries int, evicted int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len(), c.evicted
+}
+
+// serveDerived answers from the kept response of key when it is still
+// valid, otherwise runs build into a recorder and keeps the result when
+// build reports it complete. kind is the cacheMetric* of the endpoint and
+// scopes the generation scopes build reads. expires bounds responses that
+// read the global list, zero otherwise.
+func (s *Server) serveDerived(w http.ResponseWriter, r *http.Request, kind int, key string, scopes []string, policy *appPolicy, expires time.Time, build func(w http.ResponseWriter) bool) {
+	key = cacheMetricNames[kind] + "|" + key + "|" + cacheNamespace(r.Context())
+	generation := s.scopesGeneration(scopes)
+	if e := s.derived.get(key, generation, policy, time.Now()); e != nil {
+		metrics.cacheLookup(kind, true)
+		replayDerived(w, e, "hit")
+		return
+	}
+	metrics.cacheLookup(kind, false)
+
+	rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+	complete := build(rec)
+	if !complete || rec.status != http.StatusOK || rec.header.Get("X-Data-Stale") != "" || s.scopesGeneration(scopes) != generation ||
+		(!expires.IsZero() && !time.Now().Before(expires)) {
+		rec.header.Set("X-Derived-Cache", "bypass")
+		rec.replay(w)
+		return
+	}
+	e := &derivedResponse{key: key, header: rec.header, body: rec.body.Bytes(), generation: generation, policy: policy, expires: expires}
+	s.derived.put(e)
+	replayDerived(w, e, "miss")
+}
+
+func replayDerived(w http.ResponseWriter, e *derivedResponse, result string) {
+	h := w.Header()
+	copyRecordedHeader(h, e.header)
+	h.Set("X-Derived-Cache", result)
+	h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(e.body)
+}
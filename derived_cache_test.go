@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// derivedGet answers path and reports whether it was replayed, built
+// (zero or one aggregation) or bypassed.
+func derivedGet(t *testing.T, s *Server, path string) (result string, builds int64, body string) {
+	t.Helper()
+	kind := cacheMetricTags
+	handler := s.handleTags
+	if strings.HasPrefix(path, "/api/v1/bootstrap") {
+		kind, handler = cacheMetricBootstrap, s.handleBootstrap
+	}
+	before := metrics.cacheMisses[kind].Load()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+	}
+	return w.Header().Get("X-Derived-Cache"), metrics.cacheMisses[kind].Load() - before, w.Body.String()
+}
+
+func derivedServer(t *testing.T) *Server {
+	t.Helper()
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDerivedResponseReplayed(t *testing.T) {
+	s := derivedServer(t)
+	const path = "/api/v1/bootstrap?exclude=suggestions"
+	result, builds, first := derivedGet(t, s, path)
+	if result != "miss" || builds != 1 {
+		t.Fatalf("first request: %s, %d builds", result, builds)
+	}
+	hitsBefore := metrics.cacheHits[cacheMetricBootstrap].Load()
+	result, builds, second := derivedGet(t, s, path)
+	if result != "hit" || builds != 0 || second != first {
+		t.Errorf("second request: %s, %d builds, same body %v", result, builds, second == first)
+	}
+	if metrics.cacheHits[cacheMetricBootstrap].Load() != hitsBefore+1 {
+		t.Errorf("the hit was not counted")
+	}
+	// The components are sorted into the key: another order is the same entry.
+	if result, builds, _ := derivedGet(t, s, "/api/v1/bootstrap?include=stats,achievements,config"); result != "hit" || builds != 0 {
+		t.Errorf("same components in another order: %s, %d builds", result, builds)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{`yboost_cache_hits_total{cache="bootstrap"}`, `yboost_cache_misses_total{cache="tags"}`, "yboost_derived_cache_entries 1\n"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("/metrics without %q", want)
+		}
+	}
+}
+
+// A refresh rebuilds the entries built from what it refreshed and nothing
+// else.
+func TestDerivedRefreshInvalidatesAffected(t *testing.T) {
+	s := derivedServer(t)
+	paths := []string{"/api/v1/bootstrap", "/api/v1/tags?appId=105600", "/api/v1/tags?appId=440"}
+	for _, path := range paths {
+		derivedGet(t, s, path)
+	}
+	check := func(step string, rebuilt ...string) {
+		t.Helper()
+		for _, path := range paths {
+			want := "hit"
+			for _, p := range rebuilt {
+				if p == path {
+					want = "miss"
+				}
+			}
+			if result, _, _ := derivedGet(t, s, path); result != want {
+				t.Errorf("%s: %s answered %s, want %s", step, path, result, want)
+			}
+		}
+	}
+	check("no refresh")
+
+	s.storeGlobalPercentages(globalPctCacheKey(context.Background(), 440), map[string]float64{"A": 10}, time.Now())
+	check("app 440 refreshed", "/api/v1/tags?appId=440")
+
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	check("default app synced", "/api/v1/bootstrap", "/api/v1/tags?appId=105600")
+
+	if err := s.syncUserData(context.Background(), 76561197960287930, "french"); err != nil {
+		t.Fatal(err)
+	}
+	// The player sync fetched the schema of the one game they own again.
+	check("player synced", "/api/v1/bootstrap", "/api/v1/tags?appId=105600")
+
+	if w, _ := patchConfig(t, s, `{"rateLimitRps":3}`); w.Code != http.StatusOK {
+		t.Fatalf("PATCH: %d %s", w.Code, w.Body)
+	}
+	check("config changed", "/api/v1/bootstrap")
+
+	// An isolated refresh is not a shared one.
+	s.testMode = true
+	iso, _ := isolationCtx(s, "run-1")
+	s.storeGlobalPercentages(globalPctCacheKey(iso, 440), map[string]float64{"A": 20}, time.Now())
+	check("isolated refresh")
+}
+
+func TestDerivedCacheLRU(t *testing.T) {
+	c := newDerivedResponseCache(2)
+	now := time.Now()
+	policy := &appPolicy{}
+	for _, key := range []string{"a", "b"} {
+		c.put(&derivedResponse{key: key, generation: 1})
+	}
+	c.get("a", 1, nil, now) // b is now the least recently used
+	c.put(&derivedResponse{key: "c", generation: 1})
+	if entries, evicted := c.stats(); entries != 2 || evicted != 1 || c.get("b", 1, nil, now) != nil || c.get("a", 1, nil, now) == nil {
+		t.Errorf("after the third entry: %d entries, %d evicted", entries, evicted)
+	}
+
+	if c.get("a", 2, nil, now) != nil || c.get("a", 1, nil, now) != nil {
+		t.Errorf("an entry of another generation was served or kept")
+	}
+	c.put(&derivedResponse{key: "p", generation: 1})
+	if c.get("p", 1, policy, now) != nil {
+		t.Errorf("an entry built under another policy was served")
+	}
+	c.put(&derivedResponse{key: "e", generation: 1, expires: now})
+	if c.get("e", 1, nil, now) != nil {
+		t.Errorf("an expired entry was served")
+	}
+}
+
+// A partial answer is sent, not kept.
+func TestDerivedPartialNotKept(t *testing.T) {
+	s := derivedServer(t)
+	for range 2 {
+		w := httptest.NewRecorder()
+		s.serveDerived(w, httptest.NewRequest(http.MethodGet, "/api/v1/tags", nil), cacheMetricTags, "x", nil, nil, time.Time{}, func(w http.ResponseWriter) bool {
+			writeJSON(w, []TagCount{})
+			return false
+		})
+		if got := w.Header().Get("X-Derived-Cache"); got != "bypass" || w.Body.String() == "" {
+			t.Errorf("partial answer: %s %q", got, w.Body)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEV_HTTP_CACHE=dir caches successful upstream GET responses on disk so a
+// developer restarting the server all day does not refetch the same
+// schemas. It sits in front of the Steam client, below every application
+// cache and independent of them, and is off by default; it must not be
+// used in production since it ignores upstream cache headers.
+//
+// Files are named after the SHA-256 of the full URL; the URL stored inside
+// is redacted, so the API key never lands on disk. Entries expire after
+// DEV_HTTP_CACHE_TTL (10 minutes). Past DEV_HTTP_CACHE_MAX_BYTES (64MB)
+// the least recently used files are removed; a hit refreshes the file's
+// modification time for that purpose.
+const (
+	defaultDevHTTPCacheTTL      = 10 * time.Minute
+	defaultDevHTTPCacheMaxBytes = 64 << 20
+	devHTTPCacheExt             = ".json"
+)
+
+type devHTTPCache struct {
+	base     http.RoundTripper
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+	mu       sync.Mutex // serializes writes and eviction
+}
+
+type devHTTPCacheEntry struct {
+	URL      string      `json:"url"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	StoredAt time.Time   `json:"storedAt"`
+	Body     []byte      `json:"body"`
+}
+
+func devHTTPCacheFromEnv(base http.RoundTripper) (http.RoundTripper, error) {
+	dir := strings.TrimSpace(getenv("DEV_HTTP_CACHE", ""))
+	if dir == "" {
+		return base, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	log.Printf("dev http cache enabled in %s (do not use in production)", dir)
+	return &devHTTPCache{
+		base:     base,
+		dir:      dir,
+		ttl:      getenvDuration("DEV_HTTP_CACHE_TTL", defaultDevHTTPCacheTTL),
+		maxBytes: int64(getenvInt("DEV_HTTP_CACHE_MAX_BYTES", defaultDevHTTPCacheMaxBytes)),
+	}, nil
+}
+
+func (c *devHTTPCache) path(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String()))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+devHTTPCacheExt)
+}
+
+func (c *devHTTPCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.base.RoundTrip(req)
+	}
+	path := c.path(req)
+	if res, ok := c.load(path, req); ok {
+		return res, nil
+	}
+
+	res, err := c.base.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	c.store(path, devHTTPCacheEntry{URL: redactURL(req.URL), Status: res.StatusCode, Header: res.Header.Clone(), StoredAt: time.Now(), Body: body})
+	return res, nil
+}
+
+func (c *devHTTPCache) load(path string, req *http.Request) (*http.Response, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var e devHTTPCacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || time.Since(e.StoredAt) > c.ttl {
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	log.Printf("dev http cache hit: %s (stored %s ago)", e.URL, time.Since(e.StoredAt).Round(time.Second))
+	return &http.Response{
+		Status:        http.StatusText(e.Status),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}, true
+}
+
+func (c *devHTTPCache) store(path string, e devHTTPCacheEntry) {
+	e.Header.Del("Content-Encoding")
+	e.Header.Del("Content-Length")
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		log.Printf("dev http cache: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("dev http cache: %v", err)
+		return
+	}
+	c.evictLocked()
+}
+
+// evictLocked removes the least recently used files until the directory
+// fits in maxBytes.
+func (c *devHTTPCache) evictLocked() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type file struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []file
+	var total int64
+	for _, de := range entries {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), devHTTPCacheExt) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{path: filepath.Join(c.dir, de.Name()), size: info.Size(), used: info.ModTime()})
+		total += info.Size()
+	}
+	if total <= c.maxBytes {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	for _, f := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useDevHTTPCache(t *testing.T) (*fakeSteam, *devHTTPCache) {
+	t.Helper()
+	f := fakeSteamGame(t)
+	t.Setenv("DEV_HTTP_CACHE", t.TempDir())
+	rt, err := devHTTPCacheFromEnv(steamHTTPClient.Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := steamHTTPClient.Transport
+	steamHTTPClient.Transport = rt
+	t.Cleanup(func() { steamHTTPClient.Transport = saved })
+	return f, rt.(*devHTTPCache)
+}
+
+func TestDevHTTPCacheSecondRequestOffline(t *testing.T) {
+	f, c := useDevHTTPCache(t)
+	ctx := context.Background()
+	first, err := fetchSchemaForGame(ctx, "secret-key", 440, "french")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := fetchSchemaForGame(ctx, "secret-key", 440, "french")
+	if err != nil || len(second) != len(first) || second[0].Name != first[0].Name {
+		t.Fatalf("cached schema %+v, %v", second, err)
+	}
+	if n := f.count("GetSchemaForGame"); n != 1 {
+		t.Errorf("%d network calls for two identical requests, want 1", n)
+	}
+	// Another URL is another entry.
+	if _, err := fetchSchemaForGame(ctx, "secret-key", 440, "english"); err != nil || f.count("GetSchemaForGame") != 2 {
+		t.Errorf("english schema: %d calls, %v", f.count("GetSchemaForGame"), err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(c.dir, "*"+devHTTPCacheExt))
+	if len(files) != 2 {
+		t.Fatalf("cache files %v, want 2", files)
+	}
+	for _, path := range files {
+		b, _ := os.ReadFile(path)
+		if strings.Contains(string(b), "secret-key") || strings.Contains(filepath.Base(path), "secret-key") {
+			t.Errorf("%s holds the API key", path)
+		}
+	}
+}
+
+func TestDevHTTPCacheExpiryAndErrors(t *testing.T) {
+	f, c := useDevHTTPCache(t)
+	ctx := context.Background()
+	c.ttl = 0
+	fetchSchemaForGame(ctx, "k", 440, "french")
+	time.Sleep(time.Millisecond)
+	fetchSchemaForGame(ctx, "k", 440, "french")
+	if n := f.count("GetSchemaForGame"); n != 2 {
+		t.Errorf("%d calls past the TTL, want 2", n)
+	}
+
+	c.ttl = time.Hour
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusNotFound, `missing`)
+	fetchGlobalPercentages(ctx, 441)
+	before := f.count("GetGlobalAchievementPercentagesForApp")
+	fetchGlobalPercentages(ctx, 441)
+	if f.count("GetGlobalAchievementPercentagesForApp") == before {
+		t.Error("a failed response was served from the cache")
+	}
+}
+
+func TestDevHTTPCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	calls := 0
+	c := &devHTTPCache{dir: dir, ttl: time.Hour, maxBytes: 1 << 20, base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: r}, nil
+	})}
+	get := func(name string) {
+		t.Helper()
+		res, err := c.RoundTrip(httptestGet(t, "https://api.steampowered.com/"+name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	get("a")
+	info, _ := os.Stat(c.path(httptestGet(t, "https://api.steampowered.com/a")))
+	c.maxBytes = 2*info.Size() + 10 // room for two entries
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(c.path(httptestGet(t, "https://api.steampowered.com/a")), old, old)
+	get("b")
+	get("a") // a hit makes a the most recently used
+	get("c") // evicts b
+	calls = 0
+	get("a")
+	get("c")
+	if calls != 0 {
+		t.Errorf("%d network calls for the kept entries", calls)
+	}
+	get("b")
+	if calls != 1 {
+		t.Errorf("b still cached after eviction")
+	}
+}
+
+func TestDevHTTPCacheOffByDefault(t *testing.T) {
+	t.Setenv("DEV_HTTP_CACHE", "")
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) { return nil, nil })
+	rt, err := devHTTPCacheFromEnv(base)
+	if _, on := rt.(*devHTTPCache); on || err != nil {
+		t.Errorf("DEV_HTTP_CACHE unset: %T, %v", rt, err)
+	}
+}
+
+func httptestGet(t *testing.T, url string) *http.Request {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// -dev (or DEV_MODE=1) is for working on the SPA and the HTML pages without
+// restarting the server:
+//   - static files are served as they are on disk, revalidated on every
+//     load (no startup hashing, no immutable caching);
+//   - the HTML templates (achievements.html for /achievements?format=html,
+//     api_index.html for the API index) are read from DEV_TEMPLATES_DIR
+//     (templates) and parsed again on every request when the file exists,
+//     the built-in one is used otherwise or when it does not parse;
+//   - every Cache-Control with a lifetime becomes no-cache;
+//   - logLevel is debug and CORS accepts any origin and the admin headers;
+//   - the effective configuration is logged at startup.
+//
+// Without it none of this is reachable.
+const defaultDevTemplatesDir = "templates"
+
+var devMode bool
+
+var devTemplatesDir = defaultDevTemplatesDir
+
+// pageTemplate is an HTML template built into the binary that -dev can
+// replace with a file.
+type pageTemplate struct {
+	file    string
+	funcs   template.FuncMap
+	builtin *template.Template
+}
+
+func newPageTemplate(file string, funcs template.FuncMap, src string) *pageTemplate {
+	return &pageTemplate{file: file, funcs: funcs, builtin: template.Must(template.New(file).Funcs(funcs).Parse(src))}
+}
+
+func (p *pageTemplate) Execute(w io.Writer, data any) error {
+	t := p.builtin
+	if devMode {
+		if dt, err := p.fromDisk(); err != nil {
+			log.Printf("dev template %s: %v (using the built-in one)", p.file, err)
+		} else if dt != nil {
+			t = dt
+		}
+	}
+	return t.Execute(w, data)
+}
+
+// fromDisk parses the file of p, nil when there is none.
+func (p *pageTemplate) fromDisk() (*template.Template, error) {
+	b, err := os.ReadFile(filepath.Join(devTemplatesDir, p.file))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return template.New(p.file).Funcs(p.funcs).Parse(string(b))
+}
+
+// staticDir is where dev mode reads the front end, which it never takes
+// from the binary.
+func staticDir() string {
+	return getenv("STATIC_DIR", "./static")
+}
+
+// devStaticHandler serves fsys as it is now, every file revalidated.
+func devStaticHandler(fsys fs.FS) http.Handler {
+	files := http.FileServer(http.FS(fsys))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// withDevCaching turns every cache lifetime set below it into no-cache.
+func withDevCaching(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&devCachingWriter{w: w}, r)
+	})
+}
+
+type devCachingWriter struct {
+	w           http.ResponseWriter
+	wroteHeader bool
+}
+
+func (dw *devCachingWriter) Header() http.Header { return dw.w.Header() }
+
+func (dw *devCachingWriter) WriteHeader(status int) {
+	if !dw.wroteHeader {
+		dw.wroteHeader = true
+		h := dw.w.Header()
+		if cc := h.Get("Cache-Control"); strings.Contains(cc, "max-age") || strings.Contains(cc, "immutable") {
+			h.Set("Cache-Control", "no-cache")
+		}
+	}
+	dw.w.WriteHeader(status)
+}
+
+func (dw *devCachingWriter) Write(p []byte) (int, error) {
+	if !dw.wroteHeader {
+		dw.WriteHeader(http.StatusOK)
+	}
+	return dw.w.Write(p)
+}
+
+func (dw *devCachingWriter) Flush() {
+	if f, ok := dw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (dw *devCachingWriter) Unwrap() http.ResponseWriter { return dw.w }
+
+// logDevConfig prints what the server runs with, secrets left out.
+func (s *Server) logDevConfig(port, dbPath string) {
+	cfg, _ := json.Marshal(s.cfg().view())
+	log.Printf("dev mode: runtime config %s", cfg)
+	log.Printf("dev mode: port=%s db=%s static=%s templates=%s cacheFile=%q stateFile=%q proxyIcons=%t webhooks=%d testMode=%t admin=%t pseudonymize=%t",
+		port, dbPath, staticDir(), devTemplatesDir, s.cacheFile, s.stateFile, s.icons != nil, len(s.webhooks.endpoints), s.testMode, s.adminToken != "", pseudonyms.enabled())
+}
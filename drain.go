@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// Every request is registered in inflight while it runs, so graceful
+// shutdown can tell what it is waiting for: the count per route class and
+// the oldest request are logged every second while draining. At
+// SHUTDOWN_TIMEOUT (10s) the remaining requests are cancelled through their
+// context, and those that had not started answering get a 503; the server
+// is closed shortly after, whatever is left.
+const (
+	defaultShutdownTimeout = 10 * time.Second
+	drainLogInterval       = time.Second
+	forcedCancelGrace      = time.Second
+)
+
+// Route classes of the gauge.
+const (
+	classAPI    = "api"
+	classAdmin  = "admin"
+	classEvents = "events"
+	classProbe  = "probe"
+	classStatic = "static"
+)
+
+var inflight = newInflightTracker()
+
+type inflightTracker struct {
+	mu   sync.Mutex
+	next uint64
+	reqs map[uint64]*inflightRequest
+}
+
+type inflightRequest struct {
+	class  string
+	path   string
+	start  time.Time
+	cancel context.CancelFunc
+	w      *drainWriter
+}
+
+type inflightStats struct {
+	Total   int            `json:"total"`
+	ByClass map[string]int `json:"byClass"`
+	Oldest  *oldestRequest `json:"oldest,omitempty"`
+}
+
+type oldestRequest struct {
+	Path  string `json:"path"`
+	Class string `json:"class"`
+	AgeMs int64  `json:"ageMs"`
+}
+
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{reqs: make(map[uint64]*inflightRequest)}
+}
+
+func routeClass(path string) string {
+	switch path {
+	case "/readyz", "/healthz", "/metrics":
+		return classProbe
+	}
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return classStatic
+	}
+	for _, v := range knownAPIVersions {
+		rest = strings.TrimPrefix(rest, v+"/")
+	}
+	switch {
+	case rest == "events":
+		return classEvents
+	case strings.HasPrefix(rest, "admin/"):
+		return classAdmin
+	}
+	return classAPI
+}
+
+// wrap registers each request for as long as next runs.
+func (t *inflightTracker) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		dw := newDrainWriter(w)
+		req := &inflightRequest{class: routeClass(r.URL.Path), path: r.URL.Path, start: time.Now(), cancel: cancel, w: dw}
+
+		t.mu.Lock()
+		t.next++
+		id := t.next
+		t.reqs[id] = req
+		t.mu.Unlock()
+		defer func() {
+			t.mu.Lock()
+			delete(t.reqs, id)
+			t.mu.Unlock()
+			cancel()
+		}()
+
+		next.ServeHTTP(dw, r.WithContext(ctx))
+	})
+}
+
+func (t *inflightTracker) stats() inflightStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := inflightStats{Total: len(t.reqs), ByClass: make(map[string]int)}
+	var oldest *inflightRequest
+	for _, req := range t.reqs {
+		out.ByClass[req.class]++
+		if oldest == nil || req.start.Before(oldest.start) {
+			oldest = req
+		}
+	}
+	if oldest != nil {
+		out.Oldest = &oldestRequest{Path: oldest.path, Class: oldest.class, AgeMs: time.Since(oldest.start).Milliseconds()}
+	}
+	return out
+}
+
+func (st inflightStats) String() string {
+	classes := make([]string, 0, len(st.ByClass))
+	for c := range st.ByClass {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	var b strings.Builder
+	for i, c := range classes {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(c + "=" + strconv.Itoa(st.ByClass[c]))
+	}
+	return b.String()
+}
+
+// cancelAll cancels every registered request, answering 503 to those that
+// sent nothing yet, and returns how many were cancelled.
+func (t *inflightTracker) cancelAll() int {
+	t.mu.Lock()
+	reqs := make([]*inflightRequest, 0, len(t.reqs))
+	for _, req := range t.reqs {
+		reqs = append(reqs, req)
+	}
+	t.mu.Unlock()
+	for _, req := range reqs {
+		if req.w.abort() {
+			log.Printf("shutdown: %s %s cancelled with 503 after %s", req.class, req.path, time.Since(req.start).Round(time.Millisecond))
+		} else {
+			log.Printf("shutdown: %s %s cancelled mid-response after %s", req.class, req.path, time.Since(req.start).Round(time.Millisecond))
+		}
+		req.cancel()
+	}
+	return len(reqs)
+}
+
+// drainServer shuts srv down, logging what is still running every second,
+// and force-cancels the requests left at timeout.
+func drainServer(srv *http.Server, t *inflightTracker, timeout time.Duration) {
+	stopLog := make(chan struct{})
+	logDone := make(chan struct{})
+	go func() {
+		defer close(logDone)
+		tick := time.NewTicker(drainLogInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stopLog:
+				return
+			case <-tick.C:
+				st := t.stats()
+				if st.Total == 0 {
+					continue
+				}
+				log.Printf("shutdown: draining %d request(s) (%s), oldest %s for %dms", st.Total, st, st.Oldest.Path, st.Oldest.AgeMs)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		n := t.cancelAll()
+		log.Printf("shutdown: deadline of %s reached, %d request(s) cancelled", timeout, n)
+		deadline := time.Now().Add(forcedCancelGrace)
+		for t.stats().Total > 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if left := t.stats().Total; left > 0 {
+			log.Printf("shutdown: closing with %d request(s) still running", left)
+		}
+		err = srv.Close()
+	}
+	close(stopLog)
+	<-logDone
+	if err != nil {
+		log.Printf("shutdown: %v", err)
+		return
+	}
+	log.Printf("shutdown: done")
+}
+
+// drainWriter lets the shutdown write a 503 in place of a handler that is
+// still running. The handler gets its own header map, copied out when it
+// answers, so both never touch the connection at the same time.
+type drainWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu      sync.Mutex
+	started bool
+	aborted bool
+}
+
+func newDrainWriter(w http.ResponseWriter) *drainWriter {
+	return &drainWriter{w: w, header: make(http.Header)}
+}
+
+func (dw *drainWriter) Header() http.Header { return dw.header }
+
+func (dw *drainWriter) writeHeaderLocked(status int) {
+	if dw.started {
+		return
+	}
+	dw.started = true
+	h := dw.w.Header()
+	for k, v := range dw.header {
+		h[k] = v
+	}
+	dw.w.WriteHeader(status)
+}
+
+func (dw *drainWriter) WriteHeader(status int) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if !dw.aborted {
+		dw.writeHeaderLocked(status)
+	}
+}
+
+func (dw *drainWriter) Write(p []byte) (int, error) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if dw.aborted {
+		return 0, apperr.ErrRequestAborted
+	}
+	dw.writeHeaderLocked(http.StatusOK)
+	return dw.w.Write(p)
+}
+
+func (dw *drainWriter) Flush() {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if dw.aborted {
+		return
+	}
+	dw.writeHeaderLocked(http.StatusOK)
+	if f, ok := dw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (dw *drainWriter) Unwrap() http.ResponseWriter { return dw.w }
+
+// abort answers 503 if the handler sent nothing yet and drops whatever it
+// writes afterwards. It reports whether the 503 was sent.
+func (dw *drainWriter) abort() bool {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if dw.started {
+		return false
+	}
+	dw.started, dw.aborted = true, true
+	h := dw.w.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("Connection", "close")
+	h.Set("Retry-After", "5")
+	dw.w.WriteHeader(apperr.Status(apperr.CodeShuttingDown))
+	body, _ := json.Marshal(map[string]string{"error": string(apperr.CodeShuttingDown), "details": apperr.Message(apperr.CodeShuttingDown, apperr.DefaultLang)})
+	dw.w.Write(body)
+	// Sent now: the handler may never return to let the server flush it.
+	if f, ok := dw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return true
+}
+
+func (s *Server) handleAdminInflight(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, inflight.stats())
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer collects the log of a test; the server goroutines write to it.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.b.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.b.String()
+}
+
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	saved, flags := log.Writer(), log.Flags()
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	t.Cleanup(func() { log.SetOutput(saved); log.SetFlags(flags) })
+	return buf
+}
+
+func TestDrainForcesCancelAtDeadline(t *testing.T) {
+	logs := captureLog(t)
+	tracker := newInflightTracker()
+	started := make(chan struct{}, 2)
+	cancelled := make(chan string, 2)
+	mux := http.NewServeMux()
+	// Never answers until cancelled.
+	mux.HandleFunc("/api/v1/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+		cancelled <- "slow"
+		w.Write([]byte("too late"))
+	})
+	// Sends its headers, then hangs like an event stream.
+	mux.HandleFunc("/api/v1/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-r.Context().Done()
+		cancelled <- "events"
+	})
+	srv := httptest.NewServer(tracker.wrap(mux))
+	defer srv.Close()
+
+	type answer struct {
+		status int
+		body   string
+	}
+	slow := make(chan answer, 1)
+	go func() {
+		res, err := http.Get(srv.URL + "/api/v1/slow")
+		if err != nil {
+			slow <- answer{0, err.Error()}
+			return
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		slow <- answer{res.StatusCode, string(b)}
+	}()
+	go func() {
+		if res, err := http.Get(srv.URL + "/api/v1/events"); err == nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+	}()
+	<-started
+	<-started
+
+	const timeout = 1500 * time.Millisecond
+	start := time.Now()
+	drainServer(srv.Config, tracker, timeout)
+	if took := time.Since(start); took < timeout || took > timeout+forcedCancelGrace {
+		t.Errorf("drain took %s, want the %s deadline", took, timeout)
+	}
+
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case name := <-cancelled:
+			got[name] = true
+		case <-time.After(time.Second):
+			t.Fatalf("handlers cancelled: %v", got)
+		}
+	}
+	a := <-slow
+	if a.status != http.StatusServiceUnavailable || !strings.Contains(a.body, `"shutting_down"`) || strings.Contains(a.body, "too late") {
+		t.Errorf("slow request answered %d %s", a.status, a.body)
+	}
+	out := logs.String()
+	for _, want := range []string{
+		"shutdown: draining 2 request(s) (api=1 events=1), oldest /api/v1/",
+		"shutdown: api /api/v1/slow cancelled with 503 after",
+		"shutdown: events /api/v1/events cancelled mid-response after",
+		"shutdown: deadline of 1.5s reached, 2 request(s) cancelled",
+		"shutdown: done",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log without %q:\n%s", want, out)
+		}
+	}
+	if n := tracker.stats().Total; n != 0 {
+		t.Errorf("%d request(s) still tracked", n)
+	}
+}
+
+func TestDrainWithoutStragglers(t *testing.T) {
+	logs := captureLog(t)
+	tracker := newInflightTracker()
+	srv := httptest.NewServer(tracker.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})))
+	defer srv.Close()
+	done := make(chan int, 1)
+	go func() {
+		res, err := http.Get(srv.URL + "/api/v1/achievements")
+		if err != nil {
+			done <- 0
+			return
+		}
+		res.Body.Close()
+		done <- res.StatusCode
+	}()
+	for tracker.stats().Total == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	drainServer(srv.Config, tracker, 5*time.Second)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("request cut by a graceful drain: %d", code)
+	}
+	if out := logs.String(); strings.Contains(out, "cancelled") || !strings.Contains(out, "shutdown: done") {
+		t.Errorf("log:\n%s", out)
+	}
+}
+
+func TestInflightStats(t *testing.T) {
+	tracker := newInflightTracker()
+	release := make(chan struct{})
+	h := tracker.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	var wg sync.WaitGroup
+	for _, path := range []string{"/api/v1/achievements", "/api/achievements/stats", "/api/v1/admin/cache", "/api/v1/events", "/healthz", "/"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}()
+		time.Sleep(2 * time.Millisecond)
+	}
+	for tracker.stats().Total < 6 {
+		time.Sleep(time.Millisecond)
+	}
+	st := tracker.stats()
+	if st.String() != "admin=1 api=2 events=1 probe=1 static=1" || st.Oldest == nil || st.Oldest.Path != "/api/v1/achievements" {
+		t.Errorf("stats %s, oldest %+v", st, st.Oldest)
+	}
+
+	saved := inflight
+	inflight = tracker
+	t.Cleanup(func() { inflight = saved })
+	w := httptest.NewRecorder()
+	(&Server{}).handleAdminInflight(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/inflight", nil))
+	var got inflightStats
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Total != 6 || got.ByClass[classAPI] != 2 {
+		t.Errorf("admin inflight %s, %v", w.Body, err)
+	}
+
+	close(release)
+	wg.Wait()
+	if st := tracker.stats(); st.Total != 0 || st.Oldest != nil {
+		t.Errorf("after the requests: %+v", st)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// The binary carries what it needs to run from any directory: the front
+// end, and the data files of the repository as the defaults of
+// TERRARIA_PROGRESSION_FILE and ACHIEVEMENT_OVERLAY_FILE. A file on disk
+// at the default path still wins, so a checkout keeps editing them in
+// place. STATIC_DIR (-static-dir) serves the front end from a directory
+// instead, as dev mode always does (./static).
+
+//go:embed static
+var embeddedStatic embed.FS
+
+//go:embed data/terraria_progression.json data/achievement_overlay.json
+var embeddedData embed.FS
+
+func staticFS() fs.FS {
+	if dir := cleanEnvValue(os.Getenv("STATIC_DIR")); dir != "" {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		panic(err) // the directive above guarantees the directory
+	}
+	return sub
+}
+
+// readDataFile reads path, or the embedded copy when path is a default
+// data file and nothing is there.
+func readDataFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if embedded, embErr := embeddedData.ReadFile(path); embErr == nil {
+			return embedded, nil
+		}
+	}
+	return b, err
+}
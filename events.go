@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sseHeartbeatInterval = 25 * time.Second
+const sseClientBuffer = 16
+
+// Known event types. Clients subscribe with ?topics=type or type:subject,
+// e.g. refresh:105600 or unlock:76561198000000000.
+const (
+	eventRefresh       = "refresh"
+	eventUnlock        = "unlock"
+	eventSchemaChanged = "schema_changed"
+)
+
+var knownEventTypes = map[string]bool{eventRefresh: true, eventUnlock: true, eventSchemaChanged: true}
+
+const allTopics = "*"
+
+type serverEvent struct {
+	Type    string
+	Subject string
+	Data    []byte
+}
+
+type sseClient struct {
+	ch     chan serverEvent
+	topics []string
+}
+
+// eventHub indexes clients by topic so a publish only visits the
+// subscribers of "type:subject", "type:*" and the catch-all "*".
+type eventHub struct {
+	mu      sync.RWMutex
+	byTopic map[string]map[*sseClient]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{byTopic: make(map[string]map[*sseClient]bool)}
+}
+
+func (h *eventHub) subscribe(topics []string) *sseClient {
+	c := &sseClient{ch: make(chan serverEvent, sseClientBuffer), topics: topics}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range topics {
+		if h.byTopic[t] == nil {
+			h.byTopic[t] = make(map[*sseClient]bool)
+		}
+		h.byTopic[t][c] = true
+	}
+	return c
+}
+
+func (h *eventHub) unsubscribe(c *sseClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range c.topics {
+		delete(h.byTopic[t], c)
+		if len(h.byTopic[t]) == 0 {
+			delete(h.byTopic, t)
+		}
+	}
+}
+
+func (h *eventHub) publish(eventType string, subject string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	ev := serverEvent{Type: eventType, Subject: subject, Data: data}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	sent := make(map[*sseClient]bool)
+	for _, t := range []string{eventType + ":" + subject, eventType + ":" + allTopics, allTopics} {
+		for c := range h.byTopic[t] {
+			if sent[c] {
+				continue
+			}
+			sent[c] = true
+			select {
+			case c.ch <- ev:
+			default:
+				// Slow client: drop rather than block the publisher.
+			}
+		}
+	}
+}
+
+// pctChange is a global percentage that moved between two fetches, as the
+// refresh events carry it.
+type pctChange struct {
+	APIName string  `json:"apiName"`
+	Before  float64 `json:"before"`
+	After   float64 `json:"after"`
+	Delta   float64 `json:"delta"`
+}
+
+// pctChanges lists the achievements of both before and after whose
+// percentage moved, biggest move first, leaving out those the policy
+// hides. The first fetch of an app has nothing to compare and moves none.
+func pctChanges(before, after map[string]float64, policy *appPolicy) []pctChange {
+	out := make([]pctChange, 0)
+	for apiName, pct := range after {
+		prev, ok := before[apiName]
+		if !ok || policy.hidden(apiName) {
+			continue
+		}
+		if d := roundPct(pct - prev); d != 0 {
+			out = append(out, pctChange{APIName: apiName, Before: prev, After: pct, Delta: d})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if a, b := math.Abs(out[i].Delta), math.Abs(out[j].Delta); a != b {
+			return a > b
+		}
+		return out[i].APIName < out[j].APIName
+	})
+	return out
+}
+
+// parseEventTopics splits ?topics= into accepted and ignored topics. An
+// empty value subscribes to everything.
+func parseEventTopics(raw string) (accepted []string, ignored []string) {
+	accepted, ignored = make([]string, 0), make([]string, 0)
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eventType, subject, hasSubject := strings.Cut(part, ":")
+		eventType = strings.ToLower(eventType)
+		if !knownEventTypes[eventType] || (hasSubject && strings.TrimSpace(subject) == "") {
+			ignored = append(ignored, part)
+			continue
+		}
+		topic := eventType + ":" + allTopics
+		if hasSubject {
+			topic = eventType + ":" + strings.TrimSpace(subject)
+		}
+		if !seen[topic] {
+			seen[topic] = true
+			accepted = append(accepted, topic)
+		}
+	}
+	if len(accepted) == 0 && len(ignored) == 0 {
+		accepted = []string{allTopics}
+	}
+	sort.Strings(accepted)
+	return accepted, ignored
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming_unsupported", "Streaming non supporte")
+		return
+	}
+
+	accepted, ignored := parseEventTopics(r.URL.Query().Get("topics"))
+	client := s.events.subscribe(accepted)
+	defer s.events.unsubscribe(client)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	keepStreamOpen(w)
+	w.WriteHeader(http.StatusOK)
+
+	hello, _ := json.Marshal(map[string]any{"topics": accepted, "ignored": ignored})
+	fmt.Fprintf(w, "event: subscribed\ndata: %s\n\n", hello)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case ev := <-client.ch:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, ev.Data)
+			flusher.Flush()
+		}
+	}
+}
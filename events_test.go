@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEventTopics(t *testing.T) {
+	tests := []struct {
+		raw      string
+		accepted string
+		ignored  string
+	}{
+		{"", "[*]", "[]"},
+		{"refresh", "[refresh:*]", "[]"},
+		{"refresh:105600,unlock:76561198000000000", "[refresh:105600 unlock:76561198000000000]", "[]"},
+		{" Refresh:105600 , refresh:105600,REFRESH", "[refresh:* refresh:105600]", "[]"},
+		{"refresh:105600,bogus,unlock:", "[refresh:105600]", "[bogus unlock:]"},
+		{"bogus", "[]", "[bogus]"},
+		{",,", "[*]", "[]"},
+	}
+	for _, tt := range tests {
+		accepted, ignored := parseEventTopics(tt.raw)
+		if fmt.Sprint(accepted) != tt.accepted || fmt.Sprint(ignored) != tt.ignored {
+			t.Errorf("%q: accepted %v ignored %v, want %s and %s", tt.raw, accepted, ignored, tt.accepted, tt.ignored)
+		}
+	}
+}
+
+func received(c *sseClient) []string {
+	var out []string
+	for {
+		select {
+		case ev := <-c.ch:
+			out = append(out, ev.Type+":"+ev.Subject)
+		default:
+			return out
+		}
+	}
+}
+
+func TestEventHubMatching(t *testing.T) {
+	h := newEventHub()
+	all := h.subscribe([]string{allTopics})
+	game := h.subscribe([]string{"refresh:105600"})
+	refreshes := h.subscribe([]string{"refresh:*"})
+	overlapping := h.subscribe([]string{"refresh:*", "refresh:105600", allTopics})
+	player := h.subscribe([]string{"unlock:76561198000000000"})
+
+	h.publish(eventRefresh, "105600", nil)
+	h.publish(eventRefresh, "440", nil)
+	h.publish(eventUnlock, "76561198000000000", nil)
+	h.publish(eventUnlock, "76561198000000001", nil)
+
+	for _, tt := range []struct {
+		name string
+		c    *sseClient
+		want string
+	}{
+		{"*", all, "[refresh:105600 refresh:440 unlock:76561198000000000 unlock:76561198000000001]"},
+		{"refresh:105600", game, "[refresh:105600]"},
+		{"refresh:*", refreshes, "[refresh:105600 refresh:440]"},
+		{"overlapping", overlapping, "[refresh:105600 refresh:440 unlock:76561198000000000 unlock:76561198000000001]"},
+		{"unlock of one player", player, "[unlock:76561198000000000]"},
+	} {
+		if got := fmt.Sprint(received(tt.c)); got != tt.want {
+			t.Errorf("%s received %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	for _, c := range []*sseClient{all, game, refreshes, overlapping, player} {
+		h.unsubscribe(c)
+	}
+	if len(h.byTopic) != 0 {
+		t.Errorf("topics left after unsubscribing everyone: %v", h.byTopic)
+	}
+}
+
+// A slow client loses events instead of blocking the publisher.
+func TestEventHubSlowClient(t *testing.T) {
+	h := newEventHub()
+	c := h.subscribe([]string{allTopics})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < sseClientBuffer*2; i++ {
+			h.publish(eventRefresh, "105600", i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish blocked on a full client")
+	}
+	if n := len(received(c)); n != sseClientBuffer {
+		t.Errorf("%d events buffered, want %d", n, sseClientBuffer)
+	}
+}
+
+func TestEventStreamSubscribed(t *testing.T) {
+	s := newTestServer(t)
+	srv := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?topics=refresh:105600,bogus", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	next := func() (event, data string) {
+		t.Helper()
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "" && event != "":
+				return event, data
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return "", ""
+	}
+
+	event, data := next()
+	var hello struct{ Topics, Ignored []string }
+	if err := json.Unmarshal([]byte(data), &hello); event != "subscribed" || err != nil ||
+		fmt.Sprint(hello.Topics) != "[refresh:105600]" || fmt.Sprint(hello.Ignored) != "[bogus]" {
+		t.Fatalf("first event %s %s, %v", event, data, err)
+	}
+
+	// Only the subscribed subject reaches the stream.
+	s.events.publish(eventRefresh, "440", map[string]any{"appId": 440})
+	s.events.publish(eventRefresh, "105600", map[string]any{"appId": 105600})
+	if event, data := next(); event != eventRefresh || data != `{"appId":105600}` {
+		t.Errorf("next event %s %s, want the refresh of 105600", event, data)
+	}
+}
+
+func TestPctChanges(t *testing.T) {
+	policy := &appPolicy{hide: map[string]bool{"SECRET": true}}
+	tests := []struct {
+		name          string
+		before, after map[string]float64
+		want          string
+	}{
+		{"first fetch", nil, map[string]float64{"A": 10}, "[]"},
+		{"unchanged after rounding", map[string]float64{"A": 10}, map[string]float64{"A": 10.00001}, "[]"},
+		{"new achievement", map[string]float64{"A": 10}, map[string]float64{"A": 10, "B": 3}, "[]"},
+		{"hidden by the policy", map[string]float64{"SECRET": 1, "A": 10}, map[string]float64{"SECRET": 50, "A": 11}, "[{A 10 11 1}]"},
+		{
+			"biggest move first, then by name",
+			map[string]float64{"A": 10, "B": 20, "C": 30, "D": 40},
+			map[string]float64{"A": 12, "B": 18, "C": 35, "D": 40},
+			"[{C 30 35 5} {A 10 12 2} {B 20 18 -2}]",
+		},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(pctChanges(tt.before, tt.after, policy)); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// The refresh event of a global sync carries the percentages that moved
+// since the previous one.
+func TestRefreshEventCarriesChanges(t *testing.T) {
+	f := fakeSteamGame(t)
+	s := newTestServer(t)
+	ctx := context.Background()
+	if err := s.syncFromSteam(ctx, "french"); err != nil {
+		t.Fatal(err)
+	}
+	c := s.events.subscribe([]string{"refresh:*"})
+	defer s.events.unsubscribe(c)
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, `{"achievementpercentages":{"achievements":[{"name":"A","percent":81.5},{"name":"B","percent":2.5}]}}`)
+	if err := s.syncFromSteam(ctx, "french"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-c.ch:
+		var data struct {
+			Changed []pctChange `json:"changed"`
+		}
+		if err := json.Unmarshal(ev.Data, &data); err != nil || fmt.Sprint(data.Changed) != "[{A 80 81.5 1.5}]" {
+			t.Errorf("refresh event %s: changed %v, %v; want A 80 -> 81.5", ev.Data, data.Changed, err)
+		}
+	default:
+		t.Fatal("no refresh event")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// fetchGroup coalesces concurrent cold-cache fetches of the same key: the
+// first caller starts the fetch, the others wait for its result. The fetch
+// runs on its own context, so one visitor leaving does not fail the rest,
+// and is cancelled once every caller waiting for it has left.
+type fetchGroup struct {
+	mu        sync.Mutex
+	calls     map[string]*fetchCall
+	coalesced atomic.Int64 // callers that joined a fetch already running
+}
+
+type fetchCall struct {
+	done    chan struct{}
+	val     any
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newFetchGroup() *fetchGroup {
+	return &fetchGroup{calls: make(map[string]*fetchCall)}
+}
+
+// do runs fn once for all the concurrent callers of key. fn gets a context
+// carrying the values of the first caller's.
+func (g *fetchGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if ok {
+		g.coalesced.Add(1)
+	} else {
+		fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &fetchCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			c.val, c.err = fn(fctx)
+			g.mu.Lock()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// Nobody wants it any more; the next caller starts afresh.
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
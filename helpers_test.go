@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestServer is a Server on a fresh database in t's temp dir.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := openDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := newServer(db, "test-key")
+	// Never the cache.json of the working directory.
+	s.cacheFile = filepath.Join(dir, "cache.json")
+	if err := s.initDB(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// fakeSteam answers the Steam calls of the tests without the network: the
+// first route whose fragment is in the URL wins.
+type fakeSteam struct {
+	mu     sync.Mutex
+	routes []fakeSteamRoute
+	calls  map[string]int
+}
+
+type fakeSteamRoute struct {
+	fragment string
+	status   int
+	body     string
+}
+
+// useFakeSteam sends steamHTTPClient to a fakeSteam until t ends.
+func useFakeSteam(t *testing.T) *fakeSteam {
+	t.Helper()
+	f := &fakeSteam{calls: make(map[string]int)}
+	saved, savedHosts := steamHTTPClient.Transport, steamHosts
+	steamHTTPClient.Transport, steamHosts = f, nil
+	t.Cleanup(func() { steamHTTPClient.Transport, steamHosts = saved, savedHosts })
+	return f
+}
+
+func (f *fakeSteam) handle(fragment string, status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.routes {
+		if f.routes[i].fragment == fragment {
+			f.routes[i] = fakeSteamRoute{fragment, status, body}
+			return
+		}
+	}
+	f.routes = append(f.routes, fakeSteamRoute{fragment, status, body})
+}
+
+func (f *fakeSteam) count(fragment string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[fragment]
+}
+
+func (f *fakeSteam) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rt := range f.routes {
+		if strings.Contains(r.URL.String(), rt.fragment) {
+			f.calls[rt.fragment]++
+			return &http.Response{StatusCode: rt.status, Header: http.Header{"Content-Type": {"application/json"}},
+				Body: io.NopCloser(strings.NewReader(rt.body)), Request: r}, nil
+		}
+	}
+	return &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+const historySourceLive = "live"
+const historySourceImport = "import"
+
+type percentSnapshot struct {
+	AppID      AppID
+	APIName    string
+	Percent    float64
+	RecordedAt time.Time
+	Source     string
+}
+
+// knownAPINames lists the apiNames of the cached schema for appID.
+func (s *Server) knownAPINames(appID AppID) (map[string]bool, error) {
+	q := `SELECT DISTINCT api_name FROM user_achievements WHERE app_id=?`
+	if appID == defaultGlobalAppID {
+		q += ` UNION SELECT api_name FROM achievements`
+	}
+
+	rows, err := s.db.Query(q, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out[name] = true
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) hasHistorySnapshotNear(appID AppID, apiName string, t time.Time, tolerance time.Duration) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM global_percent_history
+		WHERE app_id=? AND api_name=? AND recorded_at BETWEEN ? AND ?
+	`, appID, apiName, t.Add(-tolerance).Unix(), t.Add(tolerance).Unix()).Scan(&n)
+	return n > 0, err
+}
+
+func (s *Server) insertHistorySnapshots(items []percentSnapshot) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO global_percent_history(app_id, api_name, percent, recorded_at, source)
+		VALUES(?,?,?,?,?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, it := range items {
+		if _, err := stmt.Exec(it.AppID, it.APIName, it.Percent, it.RecordedAt.Unix(), it.Source); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// seedArchive records the global list already stored by older builds, so
+// archive queries have a starting point. Schema rows seeded this way use
+// first_seen=0: they predate tracking.
+func (s *Server) seedArchive() error {
+	if _, err := s.db.Exec(`
+		INSERT OR IGNORE INTO achievement_schema_history(app_id, api_name, name, description, icon, icon_gray, hidden, first_seen)
+		SELECT ?, api_name, name, description, icon, icon_gray, hidden, 0 FROM achievements
+	`, defaultGlobalAppID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO global_percent_history(app_id, api_name, percent, recorded_at, source)
+		SELECT ?, api_name, percent, updated_at, ? FROM global_percent
+		WHERE NOT EXISTS (SELECT 1 FROM global_percent_history WHERE app_id=?)
+	`, defaultGlobalAppID, historySourceLive, defaultGlobalAppID)
+	return err
+}
+
+// recordSchemaHistoryTx keeps one row per achievement ever seen; rows that
+// vanish from the schema get removed_at instead of being deleted.
+func recordSchemaHistoryTx(tx *sql.Tx, appID AppID, schema []Achievement, now time.Time) error {
+	stmt, err := tx.Prepare(`
+		INSERT INTO achievement_schema_history(app_id, api_name, name, description, icon, icon_gray, hidden, first_seen)
+		VALUES(?,?,?,?,?,?,?,?)
+		ON CONFLICT(app_id, api_name) DO UPDATE SET
+			name=excluded.name,
+			description=excluded.description,
+			icon=excluded.icon,
+			icon_gray=excluded.icon_gray,
+			hidden=excluded.hidden,
+			removed_at=NULL
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	present := make(map[string]bool, len(schema))
+	for _, a := range schema {
+		present[a.APIName] = true
+		hidden := 0
+		if a.Hidden {
+			hidden = 1
+		}
+		if _, err := stmt.Exec(appID, a.APIName, a.Name, a.Description, a.Icon, a.IconGray, hidden, now.Unix()); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query(`SELECT api_name FROM achievement_schema_history WHERE app_id=? AND removed_at IS NULL`, appID)
+	if err != nil {
+		return err
+	}
+	var gone []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if !present[name] {
+			gone = append(gone, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, name := range gone {
+		if _, err := tx.Exec(`UPDATE achievement_schema_history SET removed_at=? WHERE app_id=? AND api_name=?`, now.Unix(), appID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordLiveSnapshotsTx(tx *sql.Tx, appID AppID, pcts map[string]float64, now time.Time) error {
+	stmt, err := tx.Prepare(`
+		INSERT INTO global_percent_history(app_id, api_name, percent, recorded_at, source)
+		VALUES(?,?,?,?,?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for apiName, pct := range pcts {
+		if _, err := stmt.Exec(appID, apiName, pct, now.Unix(), historySourceLive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
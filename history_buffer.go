@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// When the store fails a sync (database locked, disk full), the percentage
+// snapshot it carried is kept in memory and written later, so the history
+// has no hole for it. Up to HISTORY_BUFFER_SIZE snapshots wait, retried
+// oldest first with a backoff from historyRetryMin to historyRetryMax, or
+// right away after the next sync that goes through. A full buffer drops
+// the newest snapshot: the history resumes where it stopped and the gap is
+// logged once everything pending is written. Pending snapshots do not
+// survive a restart.
+const (
+	defaultHistoryBufferSize = 48
+	historyRetryMin          = 5 * time.Second
+	historyRetryMax          = 5 * time.Minute
+)
+
+type pendingSnapshot struct {
+	appID AppID
+	pcts  map[string]float64
+	at    time.Time
+}
+
+type historyBuffer struct {
+	db      *sql.DB
+	size    int
+	added   chan struct{}
+	wakeups chan struct{}
+
+	// flushMu serializes flush, the only one removing from pending.
+	flushMu sync.Mutex
+	mu      sync.Mutex
+	pending []pendingSnapshot
+	// dropFrom and dropTo bound the snapshots dropped since the buffer was
+	// last empty.
+	dropped          int
+	dropFrom, dropTo time.Time
+	droppedTotal     int64
+	written          int64
+	lastError        string
+}
+
+type historyBufferStats struct {
+	Pending       int     `json:"pending"`
+	Capacity      int     `json:"capacity"`
+	OldestPending apiTime `json:"oldestPending"`
+	Written       int64   `json:"written"`
+	Dropped       int64   `json:"dropped"`
+	LastError     string  `json:"lastError,omitempty"`
+}
+
+func newHistoryBuffer(db *sql.DB, size int) *historyBuffer {
+	if size <= 0 {
+		size = defaultHistoryBufferSize
+	}
+	return &historyBuffer{db: db, size: size, added: make(chan struct{}, 1), wakeups: make(chan struct{}, 1)}
+}
+
+// add keeps the snapshot a failed write (err) could not store.
+func (b *historyBuffer) add(appID AppID, pcts map[string]float64, at time.Time, err error) {
+	if len(pcts) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastError = err.Error()
+	if len(b.pending) >= b.size {
+		if b.dropped == 0 {
+			b.dropFrom = at
+		}
+		b.dropped++
+		b.dropTo = at
+		b.droppedTotal++
+		log.Printf("history snapshot app %d at %s dropped, %d already pending: %v", appID, at.UTC().Format(time.RFC3339), len(b.pending), err)
+		return
+	}
+	b.pending = append(b.pending, pendingSnapshot{appID: appID, pcts: pcts, at: at})
+	log.Printf("history snapshot app %d at %s kept for retry (%d pending): %v", appID, at.UTC().Format(time.RFC3339), len(b.pending), err)
+	notify(b.added)
+}
+
+// wake tells run the store works again: what is pending is retried now.
+func (b *historyBuffer) wake() {
+	notify(b.wakeups)
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// flush writes the pending snapshots, oldest first, each in its own
+// transaction; it stops at the first failure, leaving it pending.
+func (b *historyBuffer) flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	for {
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			if b.dropped > 0 {
+				log.Printf("history gap: %d snapshot(s) between %s and %s were dropped while the store was failing",
+					b.dropped, b.dropFrom.UTC().Format(time.RFC3339), b.dropTo.UTC().Format(time.RFC3339))
+				b.dropped = 0
+			}
+			b.mu.Unlock()
+			return nil
+		}
+		next := b.pending[0]
+		b.mu.Unlock()
+
+		if err := b.write(next); err != nil {
+			b.mu.Lock()
+			b.lastError = err.Error()
+			b.mu.Unlock()
+			return err
+		}
+		// add only appends: pending[0] is still next.
+		b.mu.Lock()
+		b.pending = b.pending[1:]
+		b.written++
+		b.lastError = ""
+		b.mu.Unlock()
+		log.Printf("history snapshot app %d at %s written after retry", next.appID, next.at.UTC().Format(time.RFC3339))
+	}
+}
+
+func (b *historyBuffer) write(p pendingSnapshot) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := recordLiveSnapshotsTx(tx, p.appID, p.pcts, p.at); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// run retries the pending snapshots until ctx is done. Nothing is written
+// while readOnly reports true.
+func (b *historyBuffer) run(ctx context.Context, readOnly func() bool) {
+	delay := historyRetryMin
+	var retry <-chan time.Time // nil when no retry is scheduled
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.added:
+			if retry == nil {
+				retry = time.After(delay)
+			}
+			continue
+		case <-b.wakeups:
+		case <-retry:
+		}
+		retry = nil
+		if b.stats().Pending == 0 {
+			delay = historyRetryMin
+			continue
+		}
+		if readOnly() {
+			retry = time.After(historyRetryMax)
+			continue
+		}
+		if err := b.flush(); err != nil {
+			log.Printf("history retry failed, next in %s: %v", delay, err)
+			retry = time.After(delay)
+			delay = min(delay*2, historyRetryMax)
+			continue
+		}
+		delay = historyRetryMin
+	}
+}
+
+func (b *historyBuffer) stats() historyBufferStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := historyBufferStats{
+		Pending:   len(b.pending),
+		Capacity:  b.size,
+		Written:   b.written,
+		Dropped:   b.droppedTotal,
+		LastError: b.lastError,
+	}
+	if len(b.pending) > 0 {
+		st.OldestPending = apiTime(b.pending[0].at.Unix())
+	}
+	return st
+}
+
+// adminWritesResponse is GET /admin/writes: the write queue counters and
+// the history snapshots waiting for the store.
+type adminWritesResponse struct {
+	writeQueueStats
+	HistorySnapshots historyBufferStats `json:"historySnapshots"`
+}
+
+func (s *Server) handleAdminWrites(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, adminWritesResponse{writeQueueStats: s.writes.stats(), HistorySnapshots: s.history.stats()})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failHistoryWrites makes every history insert fail, as a full disk would,
+// until the returned func is called.
+func failHistoryWrites(t *testing.T, s *Server) (restore func()) {
+	t.Helper()
+	if _, err := s.db.Exec(`CREATE TRIGGER fail_history BEFORE INSERT ON global_percent_history
+		BEGIN SELECT RAISE(ABORT, 'database or disk is full'); END`); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if _, err := s.db.Exec(`DROP TRIGGER fail_history`); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHistoryStoreAndForward(t *testing.T) {
+	f := fakeSteamGame(t)
+	s := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.history.run(ctx, s.readOnly)
+
+	restore := failHistoryWrites(t, s)
+	before := time.Now().Truncate(time.Second)
+	if err := s.syncFromSteam(context.Background(), "french"); err == nil {
+		t.Fatal("the sync went through a failing store")
+	}
+	// Two more refreshes fail while the store is down.
+	first := s.history.stats().OldestPending.Time()
+	pcts := map[string]float64{"A": 80, "B": 2.5}
+	s.history.add(defaultGlobalAppID, pcts, first.Add(time.Minute), errors.New("database is locked"))
+	s.history.add(defaultGlobalAppID, pcts, first.Add(2*time.Minute), errors.New("database is locked"))
+	if err := s.history.flush(); err == nil {
+		t.Fatal("flush went through a failing store")
+	}
+
+	st := s.history.stats()
+	if st.Pending != 3 || first.Before(before) || st.Written != 0 || !strings.Contains(st.LastError, "disk is full") {
+		t.Fatalf("while the store fails: %+v", st)
+	}
+	call := notesMux(t, s)
+	var admin struct {
+		HistorySnapshots struct {
+			Pending, Capacity int
+			OldestPending     string
+		}
+	}
+	json.Unmarshal(call("GET", "/api/v1/admin/writes", "").Body.Bytes(), &admin)
+	if got := admin.HistorySnapshots; got.Pending != 3 || got.OldestPending != first.Format(time.RFC3339) || got.Capacity != defaultHistoryBufferSize {
+		t.Errorf("/admin/writes %+v", got)
+	}
+
+	// The next sync that goes through brings the buffered snapshots in.
+	restore()
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, `{"achievementpercentages":{"achievements":[{"name":"A","percent":81},{"name":"B","percent":3}]}}`)
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.history.stats().Pending > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := s.history.stats(); st.Pending != 0 || st.Written != 3 || st.LastError != "" || st.OldestPending.Known() {
+		t.Fatalf("after the recovery: %+v", st)
+	}
+
+	rows, err := s.db.Query(`SELECT recorded_at FROM global_percent_history
+		WHERE app_id=? AND api_name='A' AND percent=80 ORDER BY rowid`, defaultGlobalAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []int64
+	for rows.Next() {
+		var at int64
+		rows.Scan(&at)
+		got = append(got, at)
+	}
+	want := []int64{first.Unix(), first.Add(time.Minute).Unix(), first.Add(2 * time.Minute).Unix()}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("buffered snapshots written at %v, want %v once each in order", got, want)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM global_percent_history WHERE app_id=? AND percent=81`, defaultGlobalAppID); n != 1 {
+		t.Errorf("the recovering sync wrote its own snapshot %d times", n)
+	}
+}
+
+func TestHistoryBufferFull(t *testing.T) {
+	s := newTestServer(t)
+	s.history = newHistoryBuffer(s.db, 2)
+	restore := failHistoryWrites(t, s)
+	start := time.Unix(1_700_000_000, 0)
+	for i := range 5 {
+		s.history.add(defaultGlobalAppID, map[string]float64{"A": float64(i)}, start.Add(time.Duration(i)*time.Hour), errors.New("disk full"))
+	}
+	// Nothing to keep for a sync without percentages.
+	s.history.add(defaultGlobalAppID, nil, start, errors.New("disk full"))
+	if st := s.history.stats(); st.Pending != 2 || st.Dropped != 3 || st.OldestPending != apiTime(start.Unix()) {
+		t.Fatalf("full buffer %+v", st)
+	}
+
+	restore()
+	logs := captureLog(t)
+	if err := s.history.flush(); err != nil {
+		t.Fatal(err)
+	}
+	// The oldest two are kept, the gap after them is logged once.
+	if n := countRows(t, s, `SELECT COUNT(*) FROM global_percent_history WHERE percent IN (0, 1)`); n != 2 {
+		t.Errorf("%d of the kept snapshots written", n)
+	}
+	wantGap := "history gap: 3 snapshot(s) between " + start.Add(2*time.Hour).UTC().Format(time.RFC3339) + " and " + start.Add(4*time.Hour).UTC().Format(time.RFC3339)
+	if !strings.Contains(logs.String(), wantGap) {
+		t.Errorf("log %q, want %q", logs.String(), wantGap)
+	}
+	s.history.flush()
+	if strings.Count(logs.String(), "history gap") != 1 {
+		t.Errorf("the gap was logged more than once: %q", logs.String())
+	}
+}
+
+// A read-only instance keeps its snapshots pending.
+func TestHistoryRetryWaitsWhileReadOnly(t *testing.T) {
+	s := newTestServer(t)
+	s.history.add(defaultGlobalAppID, map[string]float64{"A": 1}, time.Now(), errors.New("disk full"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.history.run(ctx, func() bool { return true })
+		close(done)
+	}()
+	s.history.wake()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+	if st := s.history.stats(); st.Pending != 1 || st.Written != 0 {
+		t.Errorf("read-only: %+v", st)
+	}
+	w := httptest.NewRecorder()
+	s.handleAdminWrites(w, httptest.NewRequest("GET", "/api/v1/admin/writes", nil))
+	if !strings.Contains(w.Body.String(), `"pending": 1,`) {
+		t.Errorf("/admin/writes %s", w.Body)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The percentage history is compacted once a day: snapshots younger than
+// HISTORY_FULL_RESOLUTION (30 days) are kept as recorded, older ones are
+// replaced by one daily average per achievement and, past
+// HISTORY_DAILY_UNTIL (365 days), by one monthly average. Averages are
+// dated at the start of their UTC day or month and carry source "daily" or
+// "monthly". Each bucket (one app, one day or month) is rewritten in its
+// own short transaction, so reads wait at most for one bucket and an
+// interrupted run simply finds the remaining rows next time: a bucket is
+// done when all its rows carry its tier. The file is vacuumed when rows
+// were deleted. HISTORY_COMPACT_INTERVAL=0 disables the job.
+const (
+	historySourceDaily   = "daily"
+	historySourceMonthly = "monthly"
+
+	defaultHistoryFullResolution  = 30 * 24 * time.Hour
+	defaultHistoryDailyUntil      = 365 * 24 * time.Hour
+	defaultHistoryCompactInterval = 24 * time.Hour
+	// historyCompactFirstRun leaves the startup alone.
+	historyCompactFirstRun = 5 * time.Minute
+	// historyCompactPause lets queued queries through between buckets.
+	historyCompactPause    = 20 * time.Millisecond
+	historyCompactLogEvery = 30 * time.Second
+)
+
+type historyTier struct {
+	source string
+	// bucket returns the bucket of t: its start and the next one's.
+	bucket func(t time.Time) (time.Time, time.Time)
+	// lower lists the sources a bucket of this tier absorbs.
+	lower []string
+}
+
+var (
+	dailyTier = historyTier{
+		source: historySourceDaily,
+		bucket: func(t time.Time) (time.Time, time.Time) {
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			return d, d.AddDate(0, 0, 1)
+		},
+		lower: []string{historySourceLive, historySourceImport},
+	}
+	monthlyTier = historyTier{
+		source: historySourceMonthly,
+		bucket: func(t time.Time) (time.Time, time.Time) {
+			m := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+			return m, m.AddDate(0, 1, 0)
+		},
+		lower: []string{historySourceLive, historySourceImport, historySourceDaily},
+	}
+)
+
+type historyCompactor struct {
+	db             *sql.DB
+	fullResolution time.Duration
+	dailyUntil     time.Duration
+	interval       time.Duration
+
+	mu   sync.Mutex
+	last *historyCompactionStats
+}
+
+type historyCompactionStats struct {
+	Running        bool    `json:"running"`
+	StartedAt      apiTime `json:"startedAt"`
+	FinishedAt     apiTime `json:"finishedAt"`
+	DurationMs     int64   `json:"durationMs"`
+	DailyBuckets   int     `json:"dailyBuckets"`
+	MonthlyBuckets int     `json:"monthlyBuckets"`
+	RowsRead       int64   `json:"rowsRead"`
+	RowsWritten    int64   `json:"rowsWritten"`
+	VacuumMs       int64   `json:"vacuumMs"`
+	Interrupted    bool    `json:"interrupted,omitempty"`
+	Error          string  `json:"error,omitempty"`
+}
+
+func historyCompactorFromEnv(db *sql.DB) *historyCompactor {
+	c := &historyCompactor{
+		db:             db,
+		fullResolution: getenvDuration("HISTORY_FULL_RESOLUTION", defaultHistoryFullResolution),
+		dailyUntil:     getenvDuration("HISTORY_DAILY_UNTIL", defaultHistoryDailyUntil),
+		interval:       defaultHistoryCompactInterval,
+	}
+	if v := strings.TrimSpace(getenv("HISTORY_COMPACT_INTERVAL", "")); v == "0" {
+		c.interval = 0
+	} else if v != "" {
+		c.interval = getenvDuration("HISTORY_COMPACT_INTERVAL", defaultHistoryCompactInterval)
+	}
+	if c.dailyUntil < c.fullResolution {
+		log.Printf("HISTORY_DAILY_UNTIL=%s is shorter than HISTORY_FULL_RESOLUTION=%s, using the latter", c.dailyUntil, c.fullResolution)
+		c.dailyUntil = c.fullResolution
+	}
+	return c
+}
+
+// run compacts every interval until ctx is done; readOnly pauses it.
+func (c *historyCompactor) run(ctx context.Context, readOnly func() bool) {
+	if c.interval <= 0 {
+		return
+	}
+	timer := time.NewTimer(historyCompactFirstRun)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if !readOnly() {
+			c.compact(ctx, time.Now())
+		}
+		timer.Reset(c.interval)
+	}
+}
+
+func (c *historyCompactor) stats() *historyCompactionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return nil
+	}
+	st := *c.last
+	return &st
+}
+
+func (c *historyCompactor) update(f func(st *historyCompactionStats)) {
+	c.mu.Lock()
+	f(c.last)
+	c.mu.Unlock()
+}
+
+// compact runs one pass as of now; the result is also kept for stats.
+func (c *historyCompactor) compact(ctx context.Context, now time.Time) historyCompactionStats {
+	start := time.Now()
+	c.mu.Lock()
+	c.last = &historyCompactionStats{Running: true, StartedAt: apiTime(start.Unix())}
+	c.mu.Unlock()
+
+	err := c.compactTiers(ctx, now.UTC())
+	var deleted bool
+	c.update(func(st *historyCompactionStats) {
+		deleted = st.RowsRead > st.RowsWritten
+		if err != nil {
+			st.Error = err.Error()
+			st.Interrupted = ctx.Err() != nil
+		}
+	})
+	if deleted && ctx.Err() == nil {
+		t0 := time.Now()
+		// In WAL mode the vacuumed pages land in the WAL first: checkpoint
+		// so the space is given back now.
+		if _, verr := c.db.Exec(`VACUUM`); verr != nil {
+			log.Printf("history compaction: vacuum: %v", verr)
+		} else if _, verr := c.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); verr != nil {
+			log.Printf("history compaction: checkpoint: %v", verr)
+		}
+		c.update(func(st *historyCompactionStats) { st.VacuumMs = time.Since(t0).Milliseconds() })
+	}
+	c.update(func(st *historyCompactionStats) {
+		st.Running = false
+		st.FinishedAt = apiTime(time.Now().Unix())
+		st.DurationMs = time.Since(start).Milliseconds()
+	})
+	st := *c.stats()
+	switch {
+	case err != nil:
+		log.Printf("history compaction stopped after %d daily and %d monthly buckets: %v", st.DailyBuckets, st.MonthlyBuckets, err)
+	case st.RowsRead > 0:
+		log.Printf("history compaction: %d daily and %d monthly buckets, %d rows into %d, vacuum %dms, %dms in all",
+			st.DailyBuckets, st.MonthlyBuckets, st.RowsRead, st.RowsWritten, st.VacuumMs, st.DurationMs)
+	}
+	return st
+}
+
+func (c *historyCompactor) compactTiers(ctx context.Context, now time.Time) error {
+	apps, err := c.historyApps()
+	if err != nil {
+		return err
+	}
+	// Oldest first: the monthly tier also takes raw rows nothing compacted
+	// yet.
+	monthlyBefore, _ := monthlyTier.bucket(now.Add(-c.dailyUntil))
+	dailyBefore, _ := dailyTier.bucket(now.Add(-c.fullResolution))
+	for _, appID := range apps {
+		if err := c.compactTier(ctx, appID, monthlyTier, time.Time{}, monthlyBefore); err != nil {
+			return err
+		}
+		if err := c.compactTier(ctx, appID, dailyTier, monthlyBefore, dailyBefore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *historyCompactor) historyApps() ([]AppID, error) {
+	rows, err := c.db.Query(`SELECT DISTINCT app_id FROM global_percent_history ORDER BY app_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AppID
+	for rows.Next() {
+		var appID AppID
+		if err := rows.Scan(&appID); err != nil {
+			return nil, err
+		}
+		out = append(out, appID)
+	}
+	return out, rows.Err()
+}
+
+// compactTier rewrites the buckets of tier holding rows in [from, before)
+// that are not of the tier yet, oldest first.
+func (c *historyCompactor) compactTier(ctx context.Context, appID AppID, tier historyTier, from, before time.Time) error {
+	cursor := from
+	lastLog := time.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var sec sql.NullInt64
+		err := c.db.QueryRow(`
+			SELECT MIN(recorded_at) FROM global_percent_history
+			WHERE app_id=? AND recorded_at>=? AND recorded_at<? AND source<>?
+		`, appID, cursor.Unix(), before.Unix(), tier.source).Scan(&sec)
+		if err != nil {
+			return err
+		}
+		if !sec.Valid {
+			return nil
+		}
+		bucketStart, bucketEnd := tier.bucket(time.Unix(sec.Int64, 0).UTC())
+		read, written, err := c.compactBucket(appID, tier, bucketStart, bucketEnd)
+		if err != nil {
+			return err
+		}
+		c.update(func(st *historyCompactionStats) {
+			if tier.source == historySourceDaily {
+				st.DailyBuckets++
+			} else {
+				st.MonthlyBuckets++
+			}
+			st.RowsRead += read
+			st.RowsWritten += written
+		})
+		if time.Since(lastLog) >= historyCompactLogEvery {
+			lastLog = time.Now()
+			log.Printf("history compaction app %d: %s buckets done up to %s", appID, tier.source, bucketStart.Format(archiveDateLayout))
+		}
+		cursor = bucketEnd
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(historyCompactPause):
+		}
+	}
+}
+
+// compactBucket replaces the rows of one bucket by their average per
+// achievement. read counts the rows it replaced, written the averages.
+func (c *historyCompactor) compactBucket(appID AppID, tier historyTier, start, end time.Time) (read, written int64, err error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// A bucket compacted before may have gained rows since (an import):
+	// its average is folded in like any other row.
+	sources := append([]string{tier.source}, tier.lower...)
+	args := []any{appID, start.Unix(), end.Unix()}
+	for _, src := range sources {
+		args = append(args, src)
+	}
+	in := "?" + strings.Repeat(",?", len(sources)-1)
+	rows, err := tx.Query(`
+		SELECT api_name, AVG(percent), COUNT(*) FROM global_percent_history
+		WHERE app_id=? AND recorded_at>=? AND recorded_at<? AND source IN (`+in+`)
+		GROUP BY api_name
+	`, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	avgs := make(map[string]float64)
+	for rows.Next() {
+		var apiName string
+		var avg float64
+		var n int64
+		if err := rows.Scan(&apiName, &avg, &n); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		avgs[apiName] = avg
+		read += n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM global_percent_history WHERE app_id=? AND recorded_at>=? AND recorded_at<? AND source IN (`+in+`)`, args...); err != nil {
+		return 0, 0, err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO global_percent_history(app_id, api_name, percent, recorded_at, source) VALUES(?,?,?,?,?)`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+	for apiName, avg := range avgs {
+		if _, err := stmt.Exec(appID, apiName, avg, start.Unix(), tier.source); err != nil {
+			return 0, 0, err
+		}
+	}
+	return read, int64(len(avgs)), tx.Commit()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var compactNow = time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+type historyRow struct {
+	app     AppID
+	apiName string
+	pct     float64
+	at      time.Time
+	source  string
+}
+
+func (r historyRow) String() string {
+	return fmt.Sprintf("%d %s %.6g %s %s", r.app, r.apiName, r.pct, r.at.UTC().Format(time.RFC3339), r.source)
+}
+
+func mustRFC3339(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func insertHistory(t *testing.T, s *Server, rows ...historyRow) {
+	t.Helper()
+	for _, r := range rows {
+		if _, err := s.db.Exec(`INSERT INTO global_percent_history(app_id, api_name, percent, recorded_at, source) VALUES(?,?,?,?,?)`,
+			r.app, r.apiName, r.pct, r.at.Unix(), r.source); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dumpHistory(t *testing.T, s *Server) []string {
+	t.Helper()
+	rows, err := s.db.Query(`SELECT app_id, api_name, percent, recorded_at, source FROM global_percent_history ORDER BY app_id, recorded_at, api_name, source`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var r historyRow
+		var sec int64
+		rows.Scan(&r.app, &r.apiName, &r.pct, &sec, &r.source)
+		r.at = time.Unix(sec, 0)
+		out = append(out, r.String())
+	}
+	return out
+}
+
+func testCompactor(s *Server) *historyCompactor {
+	return &historyCompactor{db: s.db, fullResolution: defaultHistoryFullResolution, dailyUntil: defaultHistoryDailyUntil, interval: defaultHistoryCompactInterval}
+}
+
+// As of compactNow, daily averages replace what is older than 2025-05-16,
+// monthly ones what is older than 2024-06-01.
+func seedCompactionHistory(t *testing.T, s *Server) {
+	t.Helper()
+	live := func(app AppID, apiName string, pct float64, when string) historyRow {
+		return historyRow{app, apiName, pct, mustRFC3339(when), historySourceLive}
+	}
+	insertHistory(t, s,
+		// Recent: kept as recorded.
+		live(105600, "A", 10, "2025-06-10T00:00:00Z"),
+		live(105600, "A", 20, "2025-06-10T06:00:00Z"),
+		live(105600, "A", 30, "2025-05-16T00:00:00Z"),
+		// One daily average per achievement: (1+2+3+6)/4 = 3, (50+60)/2 = 55.
+		live(105600, "A", 1, "2025-03-03T00:00:00Z"),
+		live(105600, "A", 2, "2025-03-03T06:00:00Z"),
+		live(105600, "A", 3, "2025-03-03T12:00:00Z"),
+		historyRow{105600, "A", 6, mustRFC3339("2025-03-03T23:59:59Z"), historySourceImport},
+		live(105600, "B", 50, "2025-03-03T00:00:00Z"),
+		live(105600, "B", 60, "2025-03-03T12:00:00Z"),
+		// The next day is its own bucket: (4+8)/2 = 6.
+		live(105600, "A", 4, "2025-03-04T00:00:00Z"),
+		live(105600, "A", 8, "2025-03-04T12:00:00Z"),
+		// The last day before the full resolution window: (7+9)/2 = 8.
+		live(105600, "A", 7, "2025-05-15T00:00:00Z"),
+		live(105600, "A", 9, "2025-05-15T23:00:00Z"),
+		// Monthly from raw rows: (10+20+60)/3 = 30.
+		live(105600, "A", 10, "2024-01-05T00:00:00Z"),
+		live(105600, "A", 20, "2024-01-05T12:00:00Z"),
+		live(105600, "A", 60, "2024-01-20T00:00:00Z"),
+		// Monthly from daily averages of an earlier pass: (40+20)/2 = 30.
+		historyRow{105600, "A", 40, mustRFC3339("2023-12-01T00:00:00Z"), historySourceDaily},
+		historyRow{105600, "A", 20, mustRFC3339("2023-12-02T00:00:00Z"), historySourceDaily},
+		// The last month before the daily window: (12+14)/2 = 13.
+		live(105600, "A", 12, "2024-05-01T00:00:00Z"),
+		live(105600, "A", 14, "2024-05-31T23:00:00Z"),
+		// June 2024 is within the daily window: (5+15)/2 = 10.
+		live(105600, "A", 5, "2024-06-01T00:00:00Z"),
+		live(105600, "A", 15, "2024-06-01T18:00:00Z"),
+		// Another app is compacted on its own: (1+3)/2 = 2.
+		live(440, "A", 1, "2025-04-01T01:00:00Z"),
+		live(440, "A", 3, "2025-04-01T02:00:00Z"),
+	)
+}
+
+var compactedHistory = []string{
+	"440 A 2 2025-04-01T00:00:00Z daily",
+	"105600 A 30 2023-12-01T00:00:00Z monthly",
+	"105600 A 30 2024-01-01T00:00:00Z monthly",
+	"105600 A 13 2024-05-01T00:00:00Z monthly",
+	"105600 A 10 2024-06-01T00:00:00Z daily",
+	"105600 A 3 2025-03-03T00:00:00Z daily",
+	"105600 B 55 2025-03-03T00:00:00Z daily",
+	"105600 A 6 2025-03-04T00:00:00Z daily",
+	"105600 A 8 2025-05-15T00:00:00Z daily",
+	"105600 A 30 2025-05-16T00:00:00Z live",
+	"105600 A 10 2025-06-10T00:00:00Z live",
+	"105600 A 20 2025-06-10T06:00:00Z live",
+}
+
+func TestHistoryCompactionAverages(t *testing.T) {
+	s := newTestServer(t)
+	seedCompactionHistory(t, s)
+	c := testCompactor(s)
+	st := c.compact(context.Background(), compactNow)
+	if got := dumpHistory(t, s); strings.Join(got, "\n") != strings.Join(compactedHistory, "\n") {
+		t.Errorf("compacted history:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(compactedHistory, "\n"))
+	}
+	if st.Error != "" || st.Running || st.DailyBuckets != 5 || st.MonthlyBuckets != 3 || st.RowsRead != 21 || st.RowsWritten != 9 {
+		t.Errorf("stats %+v", st)
+	}
+
+	// A second pass has nothing to do.
+	if st := c.compact(context.Background(), compactNow); st.RowsRead != 0 || st.DailyBuckets+st.MonthlyBuckets != 0 {
+		t.Errorf("second pass %+v", st)
+	}
+	// A year later, the daily averages of 2025-03 become a monthly one:
+	// each day weighs the same, (3+6)/2 = 4.5 for A.
+	c.compact(context.Background(), compactNow.AddDate(1, 1, 0))
+	got := strings.Join(dumpHistory(t, s), "\n")
+	for _, want := range []string{"105600 A 4.5 2025-03-01T00:00:00Z monthly", "105600 B 55 2025-03-01T00:00:00Z monthly"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("a year later, no %q in:\n%s", want, got)
+		}
+	}
+}
+
+// A pass cancelled between buckets leaves every bucket either done or
+// untouched, and the next pass ends where an uninterrupted one would.
+func TestHistoryCompactionResumes(t *testing.T) {
+	s := newTestServer(t)
+	seedCompactionHistory(t, s)
+	c := testCompactor(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			if st := c.stats(); st != nil && st.DailyBuckets+st.MonthlyBuckets >= 2 {
+				cancel()
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	st := c.compact(ctx, compactNow)
+	if !st.Interrupted || st.VacuumMs != 0 || st.DailyBuckets+st.MonthlyBuckets >= 9 {
+		t.Fatalf("interrupted pass %+v", st)
+	}
+	if got := len(dumpHistory(t, s)); got <= len(compactedHistory) {
+		t.Fatalf("%d rows left after the interrupted pass: nothing was left to resume", got)
+	}
+
+	if st := c.compact(context.Background(), compactNow); st.Interrupted || st.Error != "" {
+		t.Fatalf("resumed pass %+v", st)
+	}
+	if got := dumpHistory(t, s); strings.Join(got, "\n") != strings.Join(compactedHistory, "\n") {
+		t.Errorf("after the resumed pass:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(compactedHistory, "\n"))
+	}
+}
+
+func TestAdminStorage(t *testing.T) {
+	s := newTestServer(t)
+	seedCompactionHistory(t, s)
+	s.compactor = testCompactor(s)
+	get := func() map[string]any {
+		w := httptest.NewRecorder()
+		s.handleAdminStorage(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/storage", nil))
+		var got map[string]any
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &got) != nil {
+			t.Fatalf("%d %s", w.Code, w.Body)
+		}
+		return got
+	}
+	got := get()
+	if rows := got["tables"].(map[string]any)["global_percent_history"]; rows != float64(24) {
+		t.Errorf("history rows %v, want 24", rows)
+	}
+	compaction := got["historyCompaction"].(map[string]any)
+	if compaction["last"] != nil || compaction["fullResolution"] != "720h0m0s" || compaction["enabled"] != true {
+		t.Errorf("before any pass: %v", compaction)
+	}
+	files := got["files"].([]any)
+	if len(files) == 0 || !strings.HasSuffix(files[0].(map[string]any)["path"].(string), "test.db") || files[0].(map[string]any)["bytes"].(float64) <= 0 {
+		t.Errorf("files %v", files)
+	}
+
+	s.compactor.compact(context.Background(), compactNow)
+	got = get()
+	last := got["historyCompaction"].(map[string]any)["last"].(map[string]any)
+	if rows := got["tables"].(map[string]any)["global_percent_history"]; rows != float64(len(compactedHistory)) || last["dailyBuckets"] != float64(5) || last["rowsRead"] != float64(21) {
+		t.Errorf("after a pass: %v rows, last %v", rows, last)
+	}
+	if _, ok := got["historySnapshots"].(map[string]any)["pending"]; !ok {
+		t.Errorf("no pending history snapshots in %v", got)
+	}
+}
+
+func TestHistoryCompactorFromEnv(t *testing.T) {
+	t.Setenv("HISTORY_FULL_RESOLUTION", "72h")
+	t.Setenv("HISTORY_DAILY_UNTIL", "24h")
+	t.Setenv("HISTORY_COMPACT_INTERVAL", "0")
+	c := historyCompactorFromEnv(nil)
+	if c.fullResolution != 72*time.Hour || c.dailyUntil != 72*time.Hour || c.interval != 0 {
+		t.Errorf("%+v", c)
+	}
+	t.Setenv("HISTORY_COMPACT_INTERVAL", "6h")
+	if c := historyCompactorFromEnv(nil); c.interval != 6*time.Hour {
+		t.Errorf("interval %s", c.interval)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// HOTLINK_ALLOWED_ORIGINS=https://yboost.example,https://partner.example
+// keeps other sites from embedding the icon proxy and the exports: a
+// request whose Origin (or, without one, Referer) is present and neither
+// listed nor this server gets 403. No Referer at all, or Origin "null",
+// stays allowed: privacy-minded browsers and curl send none. The JSON API
+// is never checked. Unset, nothing is. Checked responses carry Vary:
+// Referer, Origin, so a shared cache never hands one site's answer to
+// another.
+const hotlinkMiddlewareName = "hotlink"
+
+type hotlinkGuard struct {
+	allowed map[string]bool // origins, "https://host[:port]"
+}
+
+// parseHotlinkOrigins reads HOTLINK_ALLOWED_ORIGINS; nil when it is empty.
+func parseHotlinkOrigins(raw string) (*hotlinkGuard, error) {
+	g := &hotlinkGuard{allowed: make(map[string]bool)}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		u, ok := parseOrigin(strings.TrimSuffix(part, "/"))
+		if !ok || !strings.EqualFold(originString(u), strings.TrimSuffix(part, "/")) {
+			return nil, fmt.Errorf("HOTLINK_ALLOWED_ORIGINS: %q is not an origin such as https://example.com", part)
+		}
+		g.allowed[originString(u)] = true
+	}
+	if len(g.allowed) == 0 {
+		return nil, nil
+	}
+	return g, nil
+}
+
+// parseOrigin parses an http(s) URL with a host.
+func parseOrigin(raw string) (*neturl.URL, bool) {
+	u, err := neturl.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, false
+	}
+	return u, true
+}
+
+// originString is the lowercased scheme://host of u.
+func originString(u *neturl.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// allows reports whether r may be served, and the origin it came from.
+func (g *hotlinkGuard) allows(r *http.Request) (bool, string) {
+	from := r.Header.Get("Origin")
+	if from == "" || from == "null" {
+		from = r.Header.Get("Referer")
+	}
+	if from == "" {
+		return true, ""
+	}
+	u, ok := parseOrigin(from)
+	if !ok {
+		return false, from
+	}
+	origin := originString(u)
+	return g.allowed[origin] || strings.EqualFold(u.Host, r.Host), origin
+}
+
+// hotlinkProtected applies the hotlink check to the requests of rt that
+// applies reports as downloads.
+func (rt apiRoute) hotlinkProtected(s *Server, applies func(*http.Request) bool) apiRoute {
+	rt.Middleware = append(rt.Middleware, routeMiddleware{Name: hotlinkMiddlewareName, Wrap: func(next http.HandlerFunc) http.HandlerFunc {
+		return s.withHotlinkProtection(applies, next)
+	}})
+	return rt
+}
+
+func (s *Server) withHotlinkProtection(applies func(*http.Request) bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.hotlinks == nil || !applies(r) {
+			next(w, r)
+			return
+		}
+		addVary(w.Header(), "Referer")
+		addVary(w.Header(), "Origin")
+		if ok, origin := s.hotlinks.allows(r); !ok {
+			metrics.hotlinkBlocked.Add(1)
+			s.debugf("hotlink blocked: %s from %q", r.URL.Path, origin)
+			w.Header().Set("Cache-Control", "no-store")
+			writeCode(w, apperr.CodeHotlinkBlocked)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func anyRequest(*http.Request) bool { return true }
+
+// isExportRequest reports whether r asks /achievements for a CSV or HTML
+// download rather than JSON.
+func isExportRequest(r *http.Request) bool {
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = acceptedFormat(r.Header.Get("Accept"))
+	}
+	return format != formatJSON
+}
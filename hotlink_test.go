@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func varyOf(w *httptest.ResponseRecorder) []string {
+	var out []string
+	for _, v := range w.Header().Values("Vary") {
+		for _, part := range strings.Split(v, ",") {
+			out = append(out, strings.TrimSpace(part))
+		}
+	}
+	return out
+}
+
+// hotlinkMux serves the routes of s behind the hotlink guard of origins.
+func hotlinkMux(t *testing.T, s *Server, origins string) func(path string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var err error
+	if s.hotlinks, err = parseHotlinkOrigins(origins); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	if err := registerAPIRoutes(mux, s.apiRoutes(), apiV1); err != nil {
+		t.Fatal(err)
+	}
+	return func(path string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+}
+
+func TestHotlinkGuard(t *testing.T) {
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	get := hotlinkMux(t, s, "https://yboost.example, http://partner.example:8080/")
+	blockedBefore := metrics.hotlinkBlocked.Load()
+
+	guarded := []string{"/api/v1/achievements?format=csv", "/api/v1/achievements/export?format=csv", "/api/v1/icons/A?appId=105600", "/api/v1/icon-sprite.png?appId=105600"}
+	for _, tt := range []struct {
+		name    string
+		header  []string
+		blocked bool
+	}{
+		{"allowed referer", []string{"Referer", "https://yboost.example/jeu?id=1"}, false},
+		{"allowed origin, any case", []string{"Origin", "HTTP://Partner.example:8080"}, false},
+		{"the server itself", []string{"Referer", "http://example.com/"}, false},
+		{"no referer", nil, false},
+		{"origin null", []string{"Origin", "null"}, false},
+		{"other site", []string{"Referer", "https://forum.example/topic/42"}, true},
+		{"allowed host on another port", []string{"Referer", "http://partner.example/"}, true},
+		{"origin wins over referer", []string{"Origin", "https://forum.example", "Referer", "https://yboost.example/"}, true},
+		{"origin null, other referer", []string{"Origin", "null", "Referer", "https://forum.example/"}, true},
+		{"not a URL", []string{"Referer", "android-app://com.example"}, true},
+	} {
+		for _, path := range guarded {
+			w := get(path, tt.header...)
+			if blocked := w.Code == http.StatusForbidden; blocked != tt.blocked {
+				t.Errorf("%s, %s: %d %.100s", tt.name, path, w.Code, w.Body)
+			}
+			// Allowed and blocked answers alike depend on where the request came from.
+			if vary := varyOf(w); !slices.Contains(vary, "Referer") || !slices.Contains(vary, "Origin") {
+				t.Errorf("%s, %s: Vary %v", tt.name, path, vary)
+			}
+			if tt.blocked && (!strings.Contains(w.Body.String(), "hotlink_blocked") || w.Header().Get("Cache-Control") != "no-store") {
+				t.Errorf("%s, %s: %v %s", tt.name, path, w.Header(), w.Body)
+			}
+		}
+	}
+	if n := metrics.hotlinkBlocked.Load() - blockedBefore; n != 5*int64(len(guarded)) {
+		t.Errorf("%d blocked requests counted, want %d", n, 5*len(guarded))
+	}
+
+	// The JSON API is never checked.
+	for _, w := range []*httptest.ResponseRecorder{
+		get("/api/v1/achievements", "Referer", "https://forum.example/"),
+		get("/api/v1/achievements?format=json", "Referer", "https://forum.example/"),
+		get("/api/v1/bootstrap", "Origin", "https://forum.example"),
+	} {
+		if w.Code != http.StatusOK || slices.Contains(varyOf(w), "Referer") {
+			t.Errorf("JSON: %d Vary %v", w.Code, varyOf(w))
+		}
+	}
+	// The list cache replays its recorded Vary on top of the one set before
+	// the handler ran.
+	for range 2 {
+		if vary := varyOf(get("/api/v1/achievements")); !slices.Contains(vary, "Accept") || !slices.Contains(vary, "Save-Data") {
+			t.Errorf("JSON list: Vary %v", vary)
+		}
+	}
+	// A CSV negotiated by Accept is a download too.
+	for range 2 {
+		w := get("/api/v1/achievements", "Accept", "text/csv", "Referer", "https://yboost.example/")
+		if vary := varyOf(w); w.Code != http.StatusOK || !slices.Contains(vary, "Accept") || !slices.Contains(vary, "Referer") {
+			t.Errorf("Accept: text/csv: %d Vary %v", w.Code, vary)
+		}
+		if w := get("/api/v1/achievements", "Accept", "text/csv", "Referer", "https://forum.example/"); w.Code != http.StatusForbidden {
+			t.Errorf("Accept: text/csv from another site: %d", w.Code)
+		}
+	}
+}
+
+func TestHotlinkGuardUnset(t *testing.T) {
+	get := hotlinkMux(t, newTestServer(t), "")
+	w := get("/api/v1/achievements?format=html", "Referer", "https://forum.example/")
+	if w.Code == http.StatusForbidden || slices.Contains(varyOf(w), "Referer") {
+		t.Errorf("without HOTLINK_ALLOWED_ORIGINS: %d Vary %v", w.Code, varyOf(w))
+	}
+}
+
+func TestParseHotlinkOrigins(t *testing.T) {
+	g, err := parseHotlinkOrigins(" https://Yboost.example/ ,, http://localhost:3000")
+	if err != nil || !g.allowed["https://yboost.example"] || !g.allowed["http://localhost:3000"] || len(g.allowed) != 2 {
+		t.Errorf("%+v, %v", g, err)
+	}
+	if g, err := parseHotlinkOrigins(" , "); g != nil || err != nil {
+		t.Errorf("empty list: %+v, %v", g, err)
+	}
+	for _, bad := range []string{"yboost.example", "https://yboost.example/page", "ftp://yboost.example", "https://"} {
+		if _, err := parseHotlinkOrigins(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
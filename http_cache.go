@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTP caching of the API. Buffered JSON responses already carry a strong
+// ETag (response_writer.go); withHTTPCaching answers 304 when it matches
+// If-None-Match and gzips text payloads for clients that accept it, with
+// "-gzip" appended to the ETag since the bytes differ. The global
+// achievement list, polled on every page load, is kept encoded (plain and
+// gzipped, hashed once) until the data changes, and is sent with a
+// Cache-Control max-age of what is left of the cache TTL.
+//
+// Responses that report their data age (X-Data-Fetched-At) also carry a
+// Last-Modified, and If-Modified-Since is answered 304 when nothing changed
+// since. A response can change without a new fetch, so Last-Modified is the
+// later of the fetch and of the last change of what else shapes responses:
+// the startup (which loads the overlay), the runtime config and the
+// visibility policies. The ETag stays authoritative: If-Modified-Since is
+// ignored when the request has If-None-Match.
+const (
+	minGzipBytes             = 1024
+	maxEncodedResponses      = 64
+	encodedResponseKeyMaxLen = 1024
+)
+
+// gzipTypes are the compressible Content-Types; event streams are left
+// alone so every event is flushed as is.
+var gzipTypes = []string{"application/json", "application/javascript", "text/html", "text/css", "text/plain", "text/csv", "image/svg+xml"}
+
+func compressible(contentType string) bool {
+	for _, t := range gzipTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipETag(etag string) string {
+	if etag == "" {
+		return ""
+	}
+	return strings.TrimSuffix(etag, `"`) + `-gzip"`
+}
+
+// etagMatches is the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether the validators of r match the response
+// headers h.
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, h.Get("ETag"))
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
+
+// changeClock dates the changes of what shapes responses besides the data.
+// HTTP dates have a one second precision, so each tick is rounded up to the
+// next second and lands at least one second after the previous one: a
+// response sent before a change never validates after it.
+type changeClock struct {
+	last atomic.Int64 // Unix seconds
+}
+
+var shapeChanges changeClock
+
+func (c *changeClock) tick() {
+	for {
+		cur := c.last.Load()
+		if c.last.CompareAndSwap(cur, max(cur+1, time.Now().Unix()+1)) {
+			return
+		}
+	}
+}
+
+// lastModified is the Last-Modified of data fetched at fetchedAt, see
+// above.
+func lastModified(fetchedAt time.Time) time.Time {
+	if shaped := time.Unix(shapeChanges.last.Load(), 0); shaped.After(fetchedAt) {
+		return shaped
+	}
+	return fetchedAt
+}
+
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
+
+// cacheControlMaxAge lets shared caches keep a response for d, rounded
+// down to the second; nothing left means revalidate every time.
+func cacheControlMaxAge(d time.Duration) string {
+	if secs := int64(d / time.Second); secs > 0 {
+		return "public, max-age=" + strconv.FormatInt(secs, 10)
+	}
+	return "no-cache"
+}
+
+func withHTTPCaching(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &cachingWriter{w: w, r: r}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+type cachingWriter struct {
+	w           http.ResponseWriter
+	r           *http.Request
+	wroteHeader bool
+	notModified bool
+	gz          *gzip.Writer
+}
+
+func (cw *cachingWriter) Header() http.Header { return cw.w.Header() }
+
+func (cw *cachingWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	h := cw.w.Header()
+	if status != http.StatusOK {
+		cw.w.WriteHeader(status)
+		return
+	}
+
+	if compressible(h.Get("Content-Type")) {
+		addVary(h, "Accept-Encoding")
+		length, err := strconv.Atoi(h.Get("Content-Length"))
+		if h.Get("Content-Encoding") == "" && acceptsGzip(cw.r) && (err != nil || length >= minGzipBytes) {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			if etag := h.Get("ETag"); etag != "" {
+				h.Set("ETag", gzipETag(etag))
+			}
+			cw.gz = gzip.NewWriter(cw.w)
+		}
+	}
+
+	if (cw.r.Method == http.MethodGet || cw.r.Method == http.MethodHead) && notModified(cw.r, h) {
+		cw.notModified, cw.gz = true, nil
+		for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			h.Del(k)
+		}
+		cw.w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	cw.w.WriteHeader(status)
+}
+
+func (cw *cachingWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case cw.notModified:
+		return len(p), nil
+	case cw.gz != nil:
+		return cw.gz.Write(p)
+	}
+	return cw.w.Write(p)
+}
+
+func (cw *cachingWriter) Flush() {
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *cachingWriter) Unwrap() http.ResponseWriter { return cw.w }
+
+func (cw *cachingWriter) close() {
+	if cw.gz != nil {
+		cw.gz.Close()
+	}
+}
+
+// encodedResponse is one recorded answer of the global achievement list.
+type encodedResponse struct {
+	header     http.Header
+	body       []byte
+	gz         []byte // nil when the body is already encoded or too small
+	generation uint64
+	policy     *appPolicy
+	expires    time.Time
+}
+
+type encodedResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]*encodedResponse
+}
+
+func newEncodedResponseCache() *encodedResponseCache {
+	return &encodedResponseCache{entries: make(map[string]*encodedResponse)}
+}
+
+func (c *encodedResponseCache) get(key string, generation uint64, policy *appPolicy, now time.Time) *encodedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.generation != generation || e.policy != policy || !now.Before(e.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (c *encodedResponseCache) put(key string, e *encodedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxEncodedResponses {
+		// Old generations are never asked for again; start over.
+		c.entries = make(map[string]*encodedResponse)
+	}
+	c.entries[key] = e
+}
+
+// encodedResponseKey identifies what the list handler writes for r: the
+// query, the profile, format and charsets it resolved to and, for the lite profile that gzips
+// by itself, whether the client accepts gzip.
+func encodedResponseKey(ctx context.Context, r *http.Request, q achievementQuery) string {
+	query := r.URL.Query()
+	query.Del("callback") // applied around the handler
+	key := query.Encode() + "|" + q.profile + "|" + q.format + "|" + strings.Join(q.charsets, ",") + "|" + cacheNamespace(ctx)
+	if q.profile == profileLite {
+		key += "|" + strconv.FormatBool(acceptsGzip(r))
+	}
+	if len(key) > encodedResponseKeyMaxLen {
+		return ""
+	}
+	return key
+}
+
+// globalListExpiry is when the global list goes past its cache TTL, zero
+// if it was never synced.
+func (s *Server) globalListExpiry(ctx context.Context) time.Time {
+	last, err := s.lastSyncAt(ctx)
+	if err != nil || last.IsZero() {
+		return time.Time{}
+	}
+	return last.Add(s.cacheTTLFor(ctx, s.cfg().CacheTTL))
+}
+
+// copyRecordedHeader copies a recorded header onto h. Vary is merged, not
+// replaced: what the middleware and the negotiation set on h before the
+// handler ran still applies.
+func copyRecordedHeader(h, recorded http.Header) {
+	for k, v := range recorded {
+		if k != "Vary" {
+			h[k] = append([]string(nil), v...)
+			continue
+		}
+		for _, value := range v {
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					addVary(h, part)
+				}
+			}
+		}
+	}
+}
+
+// serveEncodedList answers from the encoded copy of the list when it is
+// still current, otherwise runs write into a recorder and keeps the
+// result for the next requests.
+func (s *Server) serveEncodedList(w http.ResponseWriter, r *http.Request, key string, write func(w http.ResponseWriter)) {
+	ctx := r.Context()
+	generation := s.generation.Load()
+	policy := s.policies.forApp(defaultGlobalAppID)
+	if e := s.encodedResponses.get(key, generation, policy, time.Now()); e != nil {
+		s.replayEncoded(w, r, e)
+		return
+	}
+
+	rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+	write(rec)
+	expires := s.globalListExpiry(ctx)
+	if rec.status != http.StatusOK || rec.header.Get("X-Data-Stale") != "" || !time.Now().Before(expires) || s.generation.Load() != generation {
+		rec.replay(w)
+		return
+	}
+	e := &encodedResponse{header: rec.header, body: rec.body.Bytes(), generation: generation, policy: policy, expires: expires}
+	if e.header.Get("Content-Encoding") == "" && len(e.body) >= minGzipBytes {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(e.body)
+		gz.Close()
+		e.gz = buf.Bytes()
+	}
+	s.encodedResponses.put(key, e)
+	s.replayEncoded(w, r, e)
+}
+
+func (s *Server) replayEncoded(w http.ResponseWriter, r *http.Request, e *encodedResponse) {
+	h := w.Header()
+	copyRecordedHeader(h, e.header)
+	s.setGlobalDataAge(r.Context(), w)
+	h.Set("Cache-Control", cacheControlMaxAge(time.Until(e.expires)))
+
+	body := e.body
+	if e.gz != nil {
+		addVary(h, "Accept-Encoding")
+		if acceptsGzip(r) {
+			body = e.gz
+			h.Set("Content-Encoding", "gzip")
+			h.Set("ETag", gzipETag(h.Get("ETag")))
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// responseRecorder keeps a whole response in memory.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header { return rec.header }
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.body.Write(p)
+}
+
+func (rec *responseRecorder) replay(w http.ResponseWriter) {
+	copyRecordedHeader(w.Header(), rec.header)
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
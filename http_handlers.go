@@ -1,20 +1,28 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
 )
 
-func withCORS(next http.Handler) http.Handler {
+func (s *Server) withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Origin", s.cfg().CORSAllowOrigin)
+		if devMode {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Admin-Token, "+cacheIsolationHeader)
+		} else {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		}
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -27,42 +35,50 @@ func (s *Server) handleUserGames(w http.ResponseWriter, r *http.Request) {
 	identifier := strings.TrimSpace(r.URL.Query().Get("steamId"))
 	steamID, err := s.resolveSteamIDInput(identifier)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_user_identifier", "Entre un SteamID64 valide ou un pseudo deja present en base")
+		writeIdentifierError(w, err)
 		return
 	}
+	w.Header().Set("X-Steam-ID", pseudonyms.steamID(steamID).String())
+	setSurrogateKeys(w, playerSurrogateKey(steamID))
 
-	forceRefresh := shouldForceRefresh(r)
-	expired, err := s.isUserCacheExpired(steamID)
+	forceRefresh, err := shouldForceRefresh(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	expired, err := s.isUserCacheExpired(r.Context(), steamID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
 	}
 
 	if forceRefresh || expired {
-		if err := s.syncUserData(steamID, "french"); err != nil {
-			cachedGames, readErr := s.readUserGamesFromDB(steamID)
+		if err := s.syncUserData(r.Context(), steamID, s.lang); err != nil {
+			cachedGames, readErr := s.readUserGamesFromDB(r.Context(), steamID)
 			if readErr == nil && len(cachedGames) > 0 {
 				log.Printf("steam sync warning (games, steamID=%s): %v (serving cached data)", steamID, err)
 				w.Header().Set("X-Data-Stale", "1")
+				applied, err := s.applyPolicyToGames(r.Context(), steamID, cachedGames)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+					return
+				}
+				flagPolicyApplied(w, applied)
 				writeJSON(w, cachedGames)
 				return
 			}
 
-			if errors.Is(err, errProfilePrivate) {
-				writeError(w, http.StatusForbidden, "private_profile", "Profil prive ou statistiques inaccessibles pour ce SteamID")
-				return
-			}
-			if errors.Is(err, errInvalidSteamAPIKey) {
-				writeError(w, http.StatusBadGateway, "invalid_api_key", "Cle Steam API invalide ou mal configuree cote serveur")
-				return
-			}
-			log.Printf("steam sync error (games, steamID=%s): %v", steamID, err)
-			writeError(w, http.StatusBadGateway, "steam_sync_error", "Echec de synchronisation avec Steam")
+			writeSyncError(w, err, fmt.Sprintf("games, steamID=%s", steamID))
 			return
 		}
 	}
 
-	games, err := s.readUserGamesFromDB(steamID)
+	games, err := s.readUserGamesFromDB(r.Context(), steamID)
+	if err == nil {
+		var applied bool
+		applied, err = s.applyPolicyToGames(r.Context(), steamID, games)
+		flagPolicyApplied(w, applied)
+	}
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
@@ -73,7 +89,7 @@ func (s *Server) handleUserGames(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleUserSuggestions(w http.ResponseWriter, r *http.Request) {
 	q := strings.TrimSpace(r.URL.Query().Get("q"))
-	suggestions, err := s.readUserSuggestionsFromDB(q, 12)
+	suggestions, err := s.publicSuggestions(q, 12)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
@@ -86,140 +102,445 @@ func (s *Server) handleUserProfile(w http.ResponseWriter, r *http.Request) {
 	identifier := strings.TrimSpace(r.URL.Query().Get("steamId"))
 	steamID, err := s.resolveSteamIDInput(identifier)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_user_identifier", "Entre un SteamID64 valide ou un pseudo deja present en base")
+		writeIdentifierError(w, err)
 		return
 	}
 
-	profile, err := s.readUserProfileFromDB(steamID)
+	setSurrogateKeys(w, playerSurrogateKey(steamID))
+
+	profile, err := s.readUserProfileFromDB(r.Context(), steamID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
 	}
 
-	if profile.DisplayName == "" || profile.AvatarURL == "" {
-		summary, summaryErr := fetchPlayerSummary(s.apiKey, steamID)
+	if (profile.DisplayName == "" || profile.AvatarURL == "") && !s.readOnly() {
+		summary, summaryErr := fetchPlayerSummary(r.Context(), s.apiKey, steamID)
 		if summaryErr == nil {
-			_ = s.upsertUserMetaValue(steamID, "profile_name", summary.DisplayName)
-			_ = s.upsertUserMetaValue(steamID, "profile_avatar", summary.AvatarURL)
+			if cacheNamespace(r.Context()) == "" {
+				s.queueUserMetaValue(steamID, "profile_name", summary.DisplayName)
+				s.queueUserMetaValue(steamID, "profile_avatar", summary.AvatarURL)
+			}
 			profile = summary
 		}
 	}
 
 	profile.SteamID = steamID
 	if profile.DisplayName == "" {
-		profile.DisplayName = steamID
+		profile.DisplayName = steamID.String()
 	}
 
-	writeJSON(w, profile)
+	writeJSON(w, pseudonyms.profile(profile))
 }
 
 func (s *Server) handleUserAchievements(w http.ResponseWriter, r *http.Request) {
 	identifier := strings.TrimSpace(r.URL.Query().Get("steamId"))
 	steamID, err := s.resolveSteamIDInput(identifier)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_user_identifier", "Entre un SteamID64 valide ou un pseudo deja present en base")
+		writeIdentifierError(w, err)
 		return
 	}
+	w.Header().Set("X-Steam-ID", pseudonyms.steamID(steamID).String())
 
-	appID, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get("appId")))
-	if err != nil || appID <= 0 {
+	appID, err := parseAppID(appIDQuery(r))
+	if err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
 		return
 	}
+	if source := s.sourceFor(appID).Name(); source != sourceSteam {
+		writeSourceUnsupported(w, appID, source)
+		return
+	}
+	w.Header().Set("X-Achievement-Source", sourceSteam)
+	setSurrogateKeys(w, appSurrogateKey(appID), playerSurrogateKey(steamID), langSurrogateKey(s.lang))
+	query, err := parseAchievementQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	factor, ok := s.percentageBaseline(w, appID, &query)
+	if !ok {
+		return
+	}
 
-	forceRefresh := shouldForceRefresh(r)
-	expired, err := s.isUserCacheExpired(steamID)
+	forceRefresh, err := shouldForceRefresh(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	expired, err := s.isUserCacheExpired(r.Context(), steamID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
 	}
 
 	if forceRefresh || expired {
-		if err := s.syncUserData(steamID, "french"); err != nil {
-			cachedItems, readErr := s.readUserAchievementsFromDB(steamID, appID)
+		if err := s.syncUserData(r.Context(), steamID, s.lang); err != nil {
+			cachedItems, readErr := s.readUserAchievementsFromDB(r.Context(), steamID, appID)
 			if readErr == nil && len(cachedItems) > 0 {
 				log.Printf("steam sync warning (achievements, steamID=%s, appID=%d): %v (serving cached data)", steamID, appID, err)
 				w.Header().Set("X-Data-Stale", "1")
-				writeJSON(w, cachedItems)
+				cachedItems, unknownTags := query.apply(s.overlay, s.policyFor(w, appID), appID, s.lang, cachedItems)
+				rescaleGlobalPct(cachedItems, factor)
+				if err := s.applyLocalPct(w, appID, cachedItems); err != nil {
+					writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+					return
+				}
+				query.writeDebug(w, unknownTags)
+				s.setUserDataAge(r.Context(), w, steamID)
+				s.proxyIcons(appID, cachedItems)
+				query.write(w, r, query.project(cachedItems))
 				return
 			}
 
-			if errors.Is(err, errProfilePrivate) {
-				writeError(w, http.StatusForbidden, "private_profile", "Profil prive ou statistiques inaccessibles pour ce SteamID")
-				return
-			}
-			if errors.Is(err, errInvalidSteamAPIKey) {
-				writeError(w, http.StatusBadGateway, "invalid_api_key", "Cle Steam API invalide ou mal configuree cote serveur")
-				return
-			}
-			log.Printf("steam sync error (achievements, steamID=%s, appID=%d): %v", steamID, appID, err)
-			writeError(w, http.StatusBadGateway, "steam_sync_error", "Echec de synchronisation avec Steam")
+			writeSyncError(w, err, fmt.Sprintf("achievements, steamID=%s, appID=%d", steamID, appID))
 			return
 		}
 	}
 
-	items, err := s.readUserAchievementsFromDB(steamID, appID)
+	items, err := s.readUserAchievementsFromDB(r.Context(), steamID, appID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
 	}
 
-	writeJSON(w, items)
+	items, unknownTags := query.apply(s.overlay, s.policyFor(w, appID), appID, s.lang, items)
+	rescaleGlobalPct(items, factor)
+	if err := s.applyLocalPct(w, appID, items); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	query.writeDebug(w, unknownTags)
+	s.setUserDataAge(r.Context(), w, steamID)
+	s.proxyIcons(appID, items)
+	query.write(w, r, query.project(items))
 }
 
 func (s *Server) handleAchievements(w http.ResponseWriter, r *http.Request) {
-	expired, err := s.isCacheExpired()
+	query, err := parseAchievementQuery(r)
+	if err == nil {
+		def := s.lang
+		if r.URL.Query().Has("asOf") {
+			def = defaultLang
+		}
+		query.lang, err = langParam.fromQuery(r.URL.Query(), def, &query.normalized)
+	}
 	if err != nil {
-		http.Error(w, "DB error: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
 		return
 	}
-	if expired {
-		if err := s.syncFromSteam("french"); err != nil {
-			log.Printf("sync error: %v", err)
+	format, ok := negotiateFormat(w, r)
+	if !ok {
+		return
+	}
+	query.format = format
+	if query.charsets, ok = negotiateCharsets(w, r, format); !ok {
+		return
+	}
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		appID, err := parseAppID(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
+		}
+		if !s.appAllowed(appID) {
+			writeAppNotAllowed(w, appID)
+			return
+		}
+		if appID != defaultGlobalAppID {
+			s.handleSourceAchievements(w, r, appID, query)
+			return
 		}
 	}
+	setSurrogateKeys(w, appSurrogateKey(defaultGlobalAppID), langSurrogateKey(query.lang))
+	w.Header().Set("X-Achievement-Source", sourceSteam)
+	if r.URL.Query().Has("asOf") {
+		if query.lang != defaultLang {
+			writeError(w, http.StatusBadRequest, "lang_unavailable", "Les archives ne sont disponibles qu'en "+defaultLang)
+			return
+		}
+		if query.baseline != baselinePlayers {
+			// Owner estimates are dated; applying today's to an old snapshot
+			// would mislabel it.
+			writeError(w, http.StatusBadRequest, "baseline_unavailable", "baseline=estimatedOwners n'est pas disponible avec asOf")
+			return
+		}
+		s.handleAchievementsArchive(w, r, query)
+		return
+	}
+	if key := encodedResponseKey(r.Context(), r, query); key != "" {
+		s.serveEncodedList(w, r, key, func(w http.ResponseWriter) { s.writeGlobalAchievements(w, r, query) })
+		return
+	}
+	s.writeGlobalAchievements(w, r, query)
+}
 
-	items, err := s.readAchievementsFromDB()
+// writeGlobalAchievements answers /achievements for the default app from
+// the live data.
+func (s *Server) writeGlobalAchievements(w http.ResponseWriter, r *http.Request, query achievementQuery) {
+	factor, ok := s.percentageBaseline(w, defaultGlobalAppID, &query)
+	if !ok {
+		return
+	}
+
+	ctx, stale := withStaleTracking(r.Context())
+	idx, err := s.globalAchievementIndex(ctx, query.lang)
+	if errors.Is(err, apperr.ErrReadOnly) {
+		writeReadOnly(w)
+		return
+	}
+	if err != nil && query.lang != defaultLang {
+		writeSyncError(w, err, fmt.Sprintf("achievements, lang=%s", query.lang))
+		return
+	}
 	if err != nil {
 		http.Error(w, "DB error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sort.Slice(items, func(i, j int) bool {
-		if items[i].GlobalPct == items[j].GlobalPct {
-			return items[i].Name < items[j].Name
+	items, unknownTags := query.applyIndex(s.overlay, s.policyFor(w, defaultGlobalAppID), idx)
+	rescaleGlobalPct(items, factor)
+	if err := s.applyLocalPct(w, defaultGlobalAppID, items); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	query.writeDebug(w, unknownTags)
+	if stale.Load() {
+		writeStaleHeaders(w)
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", cacheControlMaxAge(time.Until(s.globalListExpiry(r.Context()))))
+	}
+
+	query.order.sort(items)
+	var version int64
+	if synced, err := s.lastSyncAt(r.Context()); err == nil {
+		version = synced.Unix()
+	}
+	items, page, ok := paginate(w, r, query.order, items, version)
+	if !ok {
+		return
+	}
+
+	s.setGlobalDataAge(r.Context(), w)
+	if query.export(w, defaultGlobalAppID, items) {
+		return
+	}
+	s.proxyIcons(defaultGlobalAppID, items)
+	query.write(w, r, paged(page, query.project(items)))
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	appID := defaultGlobalAppID
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		v, err := parseAppID(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
 		}
-		return items[i].GlobalPct > items[j].GlobalPct
+		appID = v
+	}
+
+	setSurrogateKeys(w, appSurrogateKey(appID))
+	s.serveDerived(w, r, cacheMetricTags, appID.String(), []string{appGenerationScope(appID)}, s.policies.forApp(appID), time.Time{}, func(w http.ResponseWriter) bool {
+		writeJSON(w, s.overlay.tagCounts(appID, s.policyFor(w, appID)))
+		return true
 	})
+}
+
+// loadGlobalAchievements returns the legacy global list, syncing it from
+// Steam first when the cache expired, or in the background while it is
+// within the stale window. Sync errors are logged, not returned.
+func (s *Server) loadGlobalAchievements(ctx context.Context, lang string) ([]Achievement, error) {
+	expired, err := s.isCacheExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if expired && !s.readOnly() {
+		if last, err := s.lastSyncAt(ctx); err == nil && s.revalidates(last, s.cacheTTLFor(ctx, s.cfg().CacheTTL)) {
+			s.revalidate(ctx, syncFetchKey(ctx, lang), "global list", func(ctx context.Context) (any, error) {
+				return nil, s.runSteamSync(ctx, lang)
+			})
+			markStale(ctx)
+			return s.readGlobalAchievements(ctx)
+		}
+	}
+	if expired {
+		err := s.syncFromSteam(ctx, lang)
+		if errors.Is(err, apperr.ErrReadOnly) {
+			items, readErr := s.readGlobalAchievements(ctx)
+			if readErr == nil && len(items) == 0 {
+				return nil, apperr.ErrReadOnly
+			}
+			return items, readErr
+		}
+		if err != nil {
+			log.Printf("sync error: %v", err)
+		}
+	}
 
-	writeJSON(w, items)
+	return s.readGlobalAchievements(ctx)
+}
+
+// loadLocalizedGlobal is loadGlobalAchievements in lang. The database only
+// holds the default language; the others take their names and descriptions
+// from the schema cache.
+func (s *Server) loadLocalizedGlobal(ctx context.Context, lang string) ([]Achievement, error) {
+	items, err := s.loadGlobalAchievements(ctx, defaultLang)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.localizeAchievements(ctx, defaultGlobalAppID, lang, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// globalAchievementIndex is loadGlobalAchievements indexed for the query
+// pipeline, in lang. The index of the default language is kept until the
+// next global sync; other languages are rebuilt from the schema cache.
+func (s *Server) globalAchievementIndex(ctx context.Context, lang string) (*achievementIndex, error) {
+	expired, err := s.isCacheExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics.cacheLookup(cacheMetricGlobal, !expired)
+	shared := cacheNamespace(ctx) == ""
+	if !expired && lang == defaultLang && shared {
+		if idx := s.globalIndex.Load(); idx != nil {
+			return idx, nil
+		}
+	}
+
+	items, err := s.loadLocalizedGlobal(ctx, lang)
+	if err != nil {
+		return nil, err
+	}
+	s.flagOutdatedTranslations(defaultGlobalAppID, lang, items)
+	idx := newAchievementIndex(s.overlay, defaultGlobalAppID, lang, items)
+	if lang == defaultLang && shared {
+		s.globalIndex.Store(idx)
+	}
+	return idx, nil
+}
+
+func writeIdentifierError(w http.ResponseWriter, err error) {
+	if errors.Is(err, apperr.ErrMalformedSteamID) {
+		writeCode(w, apperr.CodeInvalidSteamID, acceptedSteamIDForms)
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid_user_identifier", "Entre un SteamID ("+acceptedSteamIDForms+") ou un pseudo deja present en base")
+}
+
+func writeSyncError(w http.ResponseWriter, err error, logContext string) {
+	if writeAppError(w, err) {
+		return
+	}
+	log.Printf("steam sync error (%s): %v", logContext, err)
+	writeCode(w, apperr.CodeSteamSync)
+}
+
+// writeAppError answers err with the status and message of its apperr
+// code. It reports false, writing nothing, when err has no code.
+func writeAppError(w http.ResponseWriter, err error) bool {
+	code, ok := apperr.CodeOf(err)
+	if !ok {
+		return false
+	}
+	switch code {
+	case apperr.CodeReadOnly:
+		writeReadOnly(w)
+	case apperr.CodeInvalidSteamID:
+		writeCode(w, code, acceptedSteamIDForms)
+	default:
+		writeCode(w, code)
+	}
+	return true
+}
+
+// writeCode writes the error of code with its default status and message,
+// formatted with args.
+func writeCode(w http.ResponseWriter, code apperr.Code, args ...any) {
+	writeError(w, apperr.Status(code), string(code), apperr.Message(code, apperr.DefaultLang, args...))
 }
 
 func writeJSON(w http.ResponseWriter, v any) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(v)
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	writeEncoded(w, status, jsonEncoder, v)
 }
 
 func writeError(w http.ResponseWriter, status int, code string, message string) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(status)
-	writeJSON(w, map[string]any{
+	writeJSONStatus(w, status, map[string]any{
 		"error":   code,
 		"details": message,
 	})
 }
 
-func isValidSteamID64(v string) bool {
-	if len(v) != 17 {
-		return false
+// setDataAgeHeaders tells clients how old the data is. Past the cache TTL
+// (served stale because the refresh failed) it also sets the stale headers.
+func (s *Server) setDataAgeHeaders(ctx context.Context, w http.ResponseWriter, fetchedAt time.Time) {
+	if fetchedAt.IsZero() {
+		return
+	}
+	writeDataAge(w, fetchedAt)
+	if time.Since(fetchedAt) > s.cacheTTLFor(ctx, s.cfg().CacheTTL) {
+		writeStaleHeaders(w)
+	}
+}
+
+func writeDataAge(w http.ResponseWriter, fetchedAt time.Time) {
+	age := max(time.Since(fetchedAt), 0)
+	h := w.Header()
+	h.Set("X-Data-Fetched-At", fetchedAt.UTC().Format(time.RFC3339))
+	h.Set("X-Data-Age-Seconds", strconv.FormatInt(int64(age/time.Second), 10))
+	h.Set("Last-Modified", lastModified(fetchedAt).UTC().Format(http.TimeFormat))
+}
+
+// dataFetchedAt is the time the age headers of w report, zero without them.
+func dataFetchedAt(w http.ResponseWriter) time.Time {
+	t, _ := time.Parse(time.RFC3339, w.Header().Get("X-Data-Fetched-At"))
+	return t
+}
+
+func (s *Server) setGlobalDataAge(ctx context.Context, w http.ResponseWriter) {
+	if last, err := s.lastSyncAt(ctx); err == nil {
+		s.setDataAgeHeaders(ctx, w, last)
+	}
+}
+
+// setAppDataAge reports the age of the cached percentages of appID, the
+// part of its list that moves, or of its schema when it has none. Whether
+// it is stale is left to the stale tracking of the request.
+func (s *Server) setAppDataAge(ctx context.Context, w http.ResponseWriter, appID AppID, lang string) {
+	if fetchedAt := s.appDataFetchedAt(ctx, appID, lang); !fetchedAt.IsZero() {
+		writeDataAge(w, fetchedAt)
+	}
+}
+
+// appDataFetchedAt is the fetch time setAppDataAge reports, zero when
+// nothing is cached for appID.
+func (s *Server) appDataFetchedAt(ctx context.Context, appID AppID, lang string) time.Time {
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	fetchedAt := s.appGlobalPctMap[globalPctCacheKey(ctx, appID)].fetchedAt
+	if fetchedAt.IsZero() {
+		fetchedAt = s.appSchemaCache[schemaCacheKey(ctx, appID, lang)].fetchedAt
+	}
+	return fetchedAt
+}
+
+func (s *Server) setUserDataAge(ctx context.Context, w http.ResponseWriter, steamID SteamID) {
+	if last, err := s.userLastSyncAt(ctx, steamID); err == nil {
+		s.setDataAgeHeaders(ctx, w, last)
 	}
-	_, err := strconv.ParseUint(v, 10, 64)
-	return err == nil
 }
 
-func shouldForceRefresh(r *http.Request) bool {
-	v := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("refresh")))
-	return v == "1" || v == "true" || v == "yes"
+func shouldForceRefresh(w http.ResponseWriter, r *http.Request) (bool, error) {
+	var notes []string
+	v, err := refreshParam.fromQuery(r.URL.Query(), "false", &notes)
+	writeNormalizedParams(w, notes)
+	return v == "true", err
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// iconColorGrid is the side of the grid icons are sampled on; Steam icons
+// are 64x64, so this looks at one pixel out of four in each direction.
+const iconColorGrid = 16
+
+// dominantIconColor returns the average color of an icon as "#rrggbb".
+// Mostly transparent pixels are skipped so the background does not wash out
+// the result.
+func dominantIconColor(r io.Reader) (string, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return "", err
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return "", fmt.Errorf("empty image")
+	}
+
+	var sumR, sumG, sumB, n uint64
+	for gy := 0; gy < iconColorGrid; gy++ {
+		y := b.Min.Y + (gy*b.Dy()+b.Dy()/2)/iconColorGrid
+		for gx := 0; gx < iconColorGrid; gx++ {
+			x := b.Min.X + (gx*b.Dx()+b.Dx()/2)/iconColorGrid
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			if ca < 0x8000 {
+				continue
+			}
+			// RGBA() is alpha-premultiplied on 16 bits; undo both.
+			sumR += uint64(cr) * 0xffff / uint64(ca) >> 8
+			sumG += uint64(cg) * 0xffff / uint64(ca) >> 8
+			sumB += uint64(cb) * 0xffff / uint64(ca) >> 8
+			n++
+		}
+	}
+	if n == 0 {
+		return "", fmt.Errorf("fully transparent image")
+	}
+	return fmt.Sprintf("#%02x%02x%02x", sumR/n, sumG/n, sumB/n), nil
+}
+
+// iconColorSuffix names the file next to a cached icon that holds its
+// color, so colors survive restarts without decoding every icon again.
+const iconColorSuffix = ".color"
+
+var iconColorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// storeColor computes the color of the icon cached at path and keeps it.
+// Icons that do not decode (SVG, say) keep no color.
+func (ip *iconProxy) storeColor(path string, body []byte) {
+	color, err := dominantIconColor(bytes.NewReader(body))
+	if err != nil {
+		log.Printf("icon color %s: %v", filepath.Base(path), err)
+		return
+	}
+	if err := writeFileAtomic(path+iconColorSuffix, func(f *os.File) error {
+		_, err := f.WriteString(color)
+		return err
+	}); err != nil {
+		log.Printf("icon color %s: %v", filepath.Base(path), err)
+	}
+	ip.mu.Lock()
+	ip.colors[filepath.Base(path)] = color
+	ip.mu.Unlock()
+}
+
+// loadColors reads the colors kept in dir, and computes those of icons
+// cached before colors were.
+func (ip *iconProxy) loadColors() {
+	entries, err := os.ReadDir(ip.dir)
+	if err != nil {
+		return
+	}
+	names := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			names[e.Name()] = true
+		}
+	}
+	for name := range names {
+		if strings.Contains(name, ".") {
+			continue
+		}
+		path := filepath.Join(ip.dir, name)
+		if names[name+iconColorSuffix] {
+			if b, err := os.ReadFile(path + iconColorSuffix); err == nil && iconColorPattern.Match(b) {
+				ip.mu.Lock()
+				ip.colors[name] = string(b)
+				ip.mu.Unlock()
+				continue
+			}
+		}
+		if body, err := os.ReadFile(path); err == nil {
+			ip.storeColor(path, body)
+		}
+	}
+}
+
+// colorJSON is the "color" of an achievement whose icon is upstream.
+func (ip *iconProxy) colorJSON(upstream string) json.RawMessage {
+	if upstream == "" {
+		return json.RawMessage("null")
+	}
+	ip.mu.Lock()
+	color, ok := ip.colors[filepath.Base(ip.path(upstream))]
+	ip.mu.Unlock()
+	if !ok {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(`"` + color + `"`)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func solidIcon(c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDominantIconColor(t *testing.T) {
+	// A blue square on a transparent background: the background is skipped.
+	framed := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 16; y < 48; y++ {
+		for x := 16; x < 48; x++ {
+			framed.Set(x, y, color.NRGBA{0x1b, 0x28, 0x38, 0xff})
+		}
+	}
+	// Half transparent pixels count with their own color, not premultiplied.
+	halfAlpha := solidIcon(color.NRGBA{0xc0, 0x40, 0x20, 0x99})
+
+	var gifBody bytes.Buffer
+	if err := gif.Encode(&gifBody, solidIcon(color.NRGBA{0, 0, 0xff, 0xff}), nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		body []byte
+		want string
+	}{
+		"red png":         {encodePNG(t, solidIcon(color.NRGBA{0xff, 0, 0, 0xff})), "#ff0000"},
+		"steam blue png":  {encodePNG(t, solidIcon(color.NRGBA{0x1b, 0x28, 0x38, 0xff})), "#1b2838"},
+		"framed png":      {encodePNG(t, framed), "#1b2838"},
+		"half alpha png":  {encodePNG(t, halfAlpha), "#c04020"},
+		"blue gif":        {gifBody.Bytes(), "#0000ff"},
+		"not 64x64 white": {encodePNG(t, solidIcon(color.White).SubImage(image.Rect(3, 5, 40, 23))), "#ffffff"},
+	}
+	for name, tt := range tests {
+		got, err := dominantIconColor(bytes.NewReader(tt.body))
+		if err != nil || got != tt.want {
+			t.Errorf("%s: dominantIconColor() = %q, %v; want %q", name, got, err, tt.want)
+		}
+	}
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, solidIcon(color.NRGBA{0x80, 0x80, 0x80, 0xff}), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dominantIconColor(&jpg); err != nil || !iconColorPattern.MatchString(got) || got[1:3] < "7e" || got[1:3] > "82" {
+		t.Errorf("gray jpeg = %q, %v; want about #808080", got, err)
+	}
+
+	if _, err := dominantIconColor(bytes.NewReader(encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 64, 64))))); err == nil {
+		t.Errorf("fully transparent icon got a color")
+	}
+	if _, err := dominantIconColor(strings.NewReader(iconPlaceholder)); err == nil {
+		t.Errorf("SVG decoded")
+	}
+}
+
+// testIconProxy waits for its background work before dir is removed.
+func testIconProxy(t *testing.T, dir string) *iconProxy {
+	ip := newIconProxy(dir, 2)
+	t.Cleanup(ip.colorJobs.Wait)
+	return ip
+}
+
+func waitForColor(t *testing.T, ip *iconProxy, upstream, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for string(ip.colorJSON(upstream)) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("color of %s = %s, want %s", upstream, ip.colorJSON(upstream), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestIconProxyColors(t *testing.T) {
+	red := encodePNG(t, solidIcon(color.NRGBA{0xff, 0, 0, 0xff}))
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/icon.bmp" {
+			// An image, but in a format with no decoder here.
+			w.Write(append([]byte("BM"), make([]byte, 64)...))
+			return
+		}
+		w.Write(red)
+	}))
+	defer cdn.Close()
+	dir := t.TempDir()
+	ip := testIconProxy(t, dir)
+	upstream := cdn.URL + "/red.png"
+
+	if got := string(ip.colorJSON(upstream)); got != "null" {
+		t.Fatalf("color before the first fetch = %s, want null", got)
+	}
+	path, err := ip.fetch(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForColor(t, ip, upstream, `"#ff0000"`)
+	if b, err := os.ReadFile(path + iconColorSuffix); err != nil || string(b) != "#ff0000" {
+		t.Fatalf("kept color = %q, %v", b, err)
+	}
+
+	// A restart reads the kept color, and computes the missing ones.
+	if _, err := ip.fetch(cdn.URL + "/icon.bmp"); err != nil {
+		t.Fatal(err)
+	}
+	restarted := testIconProxy(t, dir)
+	restarted.loadColors()
+	if got := string(restarted.colorJSON(upstream)); got != `"#ff0000"` {
+		t.Errorf("color after a restart = %s", got)
+	}
+	if got := string(restarted.colorJSON(cdn.URL + "/icon.bmp")); got != "null" {
+		t.Errorf("BMP icon color = %s, want null", got)
+	}
+	os.WriteFile(path+iconColorSuffix, []byte("garbage"), 0o644)
+	again := testIconProxy(t, dir)
+	again.loadColors()
+	if got := string(again.colorJSON(upstream)); got != `"#ff0000"` {
+		t.Errorf("color with a broken file = %s", got)
+	}
+	if b, _ := os.ReadFile(path + iconColorSuffix); string(b) != "#ff0000" {
+		t.Errorf("broken color file not rewritten: %q", b)
+	}
+
+	items := []Achievement{{APIName: "A", Icon: upstream}, {APIName: "B", Icon: cdn.URL + "/other.png"}, {APIName: "C"}}
+	(&Server{icons: ip}).proxyIcons(105600, items)
+	body, _ := json.Marshal(items)
+	for _, want := range []string{`"apiName":"A","name":"","description":"","icon":"/api/icon/A?v=`, `"color":"#ff0000"`, `"apiName":"B"`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("%s missing from %s", want, body)
+		}
+	}
+	if n := strings.Count(string(body), `"color":null`); n != 2 {
+		t.Errorf("%d null colors in %s, want 2", n, body)
+	}
+	lite, _ := json.Marshal(achievementQuery{profile: profileLite}.project(items))
+	if !strings.Contains(string(lite), `"color":"#ff0000"`) {
+		t.Errorf("lite list has no color: %s", lite)
+	}
+
+	plain := []Achievement{{APIName: "A", Icon: upstream}}
+	(&Server{}).proxyIcons(105600, plain)
+	if body, _ := json.Marshal(plain); strings.Contains(string(body), `"color"`) {
+		t.Errorf("color without PROXY_ICONS: %s", body)
+	}
+}
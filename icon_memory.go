@@ -0,0 +1,74 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// The icons served are also kept in memory, up to ICON_MEMORY_CACHE_BYTES
+// in all (4 MiB, a few thousand Steam icons; 0 turns it off), least
+// recently used out first, so the icons of a page being browsed skip the
+// disk. A cached file never changes, it is named after its upstream URL,
+// so an entry is valid until it is evicted.
+const defaultIconMemoryBytes = 4 << 20
+
+type memoryIcon struct {
+	path        string
+	body        []byte
+	contentType string
+	modTime     time.Time
+}
+
+type iconMemoryCache struct {
+	mu      sync.Mutex
+	max     int
+	size    int
+	order   *list.List // front: most recently used
+	entries map[string]*list.Element
+}
+
+// newIconMemoryCache returns nil for max <= 0; a nil cache keeps nothing.
+func newIconMemoryCache(max int) *iconMemoryCache {
+	if max <= 0 {
+		return nil
+	}
+	return &iconMemoryCache{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *iconMemoryCache) get(path string) *memoryIcon {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[path]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*memoryIcon)
+}
+
+// put keeps e, evicting from the back until it fits. An icon larger than
+// the whole budget is not kept.
+func (c *iconMemoryCache) put(e *memoryIcon) {
+	if c == nil || len(e.body) > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.path]; ok {
+		c.size -= len(el.Value.(*memoryIcon).body)
+		c.order.Remove(el)
+	}
+	c.entries[e.path] = c.order.PushFront(e)
+	c.size += len(e.body)
+	for c.size > c.max {
+		el := c.order.Back()
+		old := el.Value.(*memoryIcon)
+		c.order.Remove(el)
+		delete(c.entries, old.path)
+		c.size -= len(old.body)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIconMemoryCacheEviction(t *testing.T) {
+	c := newIconMemoryCache(10)
+	icon := func(path string, size int) *memoryIcon {
+		return &memoryIcon{path: path, body: make([]byte, size)}
+	}
+	c.put(icon("a", 4))
+	c.put(icon("b", 4))
+	c.get("a") // b is now the least recently used
+	c.put(icon("c", 4))
+	if c.get("b") != nil || c.get("a") == nil || c.get("c") == nil {
+		t.Fatalf("b not evicted first")
+	}
+	c.put(icon("c", 4))
+	c.put(icon("big", 11))
+	if c.size != 8 || c.get("big") != nil {
+		t.Errorf("size %d after putting c again and an icon over the budget", c.size)
+	}
+
+	var off *iconMemoryCache = newIconMemoryCache(0)
+	off.put(icon("a", 1))
+	if off.get("a") != nil {
+		t.Errorf("ICON_MEMORY_CACHE_BYTES=0 kept an icon")
+	}
+}
+
+// /api/icons is an alias of /api/icon, next to the sprite routes, and a
+// served icon keeps being served from memory without its file.
+func TestIconRoutesServeFromMemory(t *testing.T) {
+	red := encodePNG(t, solidIcon(color.NRGBA{0xff, 0, 0, 0xff}))
+	var hits atomic.Int64
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/a.png" {
+			hits.Add(1)
+		}
+		w.Write(red)
+	}))
+	t.Cleanup(cdn.Close)
+	f := useFakeSteam(t)
+	f.handle("GetSchemaForGame", http.StatusOK, `{"game":{"availableGameStats":{"achievements":[
+		{"name":"A","displayName":"Facile","description":"d","icon":"`+cdn.URL+`/a.png","icongray":"`+cdn.URL+`/a_gray.png","hidden":0}]}}}`)
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, `{"achievementpercentages":{"achievements":[{"name":"A","percent":80}]}}`)
+	s := newTestServer(t)
+	dir := t.TempDir()
+	s.icons = testIconProxy(t, dir)
+	mux := http.NewServeMux()
+	if err := registerAPIRoutes(mux, s.apiRoutes(), apiV1); err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	for _, path := range []string{"/api/icons/A?appId=105600", "/api/v1/icons/A?appId=105600", "/api/icon/A?appId=105600"} {
+		w := get(path)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), red) {
+			t.Fatalf("%s: %d %s", path, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("%d downloads of the icon, want 1", hits.Load())
+	}
+
+	os.RemoveAll(dir)
+	if w := get("/api/icons/A?appId=105600"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), red) || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("after the file is gone: %d, Last-Modified %q", w.Code, w.Header().Get("Last-Modified"))
+	}
+	if hits.Load() != 1 {
+		t.Errorf("icon downloaded again: %d downloads", hits.Load())
+	}
+	if w := get("/api/icons/A?appId=105600&gray=1"); w.Code != http.StatusOK {
+		t.Errorf("gray icon: %d", w.Code)
+	}
+	if w := get("/api/icon-sprite?appId=105600"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "sprite_not_ready") {
+		t.Errorf("/icon-sprite: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// PROXY_ICONS=1 serves achievement icons from /api/icon/{apiName}, or its
+// alias /api/icons/{apiName} (?gray=1, ?appId=), instead of linking the
+// Steam CDN, so browsers never reach it and icons keep working where it is
+// blocked. An icon is downloaded once, on first request, into
+// ICON_CACHE_DIR (default: DATA_DIR/icons), named after its upstream URL,
+// and the ones served last are kept in memory (see icon_memory.go). Only the icons of known achievements are served:
+// the handler looks the URL up in the list, it never fetches a URL from
+// the request. The rewritten links carry ?v=, a hash of the upstream URL,
+// and are cached for a year; a link without the current ?v= for a day.
+//
+// On a cold cache a page load asks for every icon at once. Concurrent
+// requests for one icon share its download, and at most
+// ICON_FETCH_CONCURRENCY downloads (8) run at a time; a request that gets
+// no download slot within iconSlotWait is answered a placeholder cached
+// for iconPlaceholderAge, so the page renders and the icons come on the
+// next load.
+//
+// Each cached icon also gets its dominant color, computed in the background
+// once it is on disk and kept next to it (see icon_color.go); the lists
+// carry it as "color", null until it is known.
+const (
+	maxIconBytes              = 1 << 20
+	iconDownloadTimeout       = 10 * time.Second
+	iconUnversionedAge        = 24 * time.Hour
+	defaultIconFetchLimit     = 8
+	iconSlotWait              = 250 * time.Millisecond
+	iconPlaceholderAge        = 10 * time.Second
+	iconPlaceholderMarkHeader = "X-Icon-Placeholder"
+)
+
+// iconPlaceholder is a neutral 64x64 tile, the size of Steam icons.
+const iconPlaceholder = `<svg xmlns="http://www.w3.org/2000/svg" width="64" height="64" viewBox="0 0 64 64"><rect width="64" height="64" rx="6" fill="#2a3f5a"/></svg>`
+
+var grayParam = enumParam{name: "gray", accepted: []string{"true", "false"}, synonyms: boolSynonyms}
+
+type iconProxy struct {
+	dir string
+	// client is not steamHTTPClient: CDN failures must not count against
+	// the Web API health.
+	client *http.Client
+
+	// slots caps the downloads running at once.
+	slots chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]*iconDownload
+	// colors maps an icon file name to its "#rrggbb"; colorJobs counts
+	// the computations still running.
+	colors    map[string]string
+	colorJobs sync.WaitGroup
+
+	// memory keeps the icons last served, see icon_memory.go.
+	memory *iconMemoryCache
+
+	// spriteMu serializes the sprite updates, see sprite.go.
+	spriteMu sync.Mutex
+}
+
+type iconDownload struct {
+	done chan struct{}
+	err  error
+}
+
+// iconProxyFromEnv returns nil unless PROXY_ICONS=1.
+func iconProxyFromEnv() *iconProxy {
+	if getenv("PROXY_ICONS", "") != "1" {
+		return nil
+	}
+	dir := getenv("ICON_CACHE_DIR", filepath.Join(getenv("DATA_DIR", "data"), "icons"))
+	ip := newIconProxy(dir, max(1, getenvInt("ICON_FETCH_CONCURRENCY", defaultIconFetchLimit)))
+	ip.memory = newIconMemoryCache(getenvInt("ICON_MEMORY_CACHE_BYTES", defaultIconMemoryBytes))
+	go ip.loadColors()
+	return ip
+}
+
+func newIconProxy(dir string, fetchLimit int) *iconProxy {
+	return &iconProxy{
+		dir:      dir,
+		client:   &http.Client{Timeout: iconDownloadTimeout},
+		slots:    make(chan struct{}, fetchLimit),
+		inflight: make(map[string]*iconDownload),
+		colors:   make(map[string]string),
+		memory:   newIconMemoryCache(defaultIconMemoryBytes),
+	}
+}
+
+func iconVersion(upstream string) string {
+	sum := sha256.Sum256([]byte(upstream))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (ip *iconProxy) path(upstream string) string {
+	sum := sha256.Sum256([]byte(upstream))
+	return filepath.Join(ip.dir, hex.EncodeToString(sum[:16]))
+}
+
+// localIconURL is the proxied link of one icon of apiName, "" for no icon.
+func localIconURL(appID AppID, apiName, upstream string, gray bool) string {
+	if upstream == "" {
+		return ""
+	}
+	q := neturl.Values{}
+	if appID != defaultGlobalAppID {
+		q.Set("appId", strconv.FormatUint(uint64(appID), 10))
+	}
+	if gray {
+		q.Set("gray", "1")
+	}
+	q.Set("v", iconVersion(upstream))
+	return "/api/icon/" + neturl.PathEscape(apiName) + "?" + q.Encode()
+}
+
+// proxyIcons points the icons of items at /api/icon when PROXY_ICONS is on.
+func (s *Server) proxyIcons(appID AppID, items []Achievement) {
+	if s.icons == nil {
+		return
+	}
+	for i := range items {
+		a := &items[i]
+		a.Color = s.icons.colorJSON(a.Icon)
+		a.Icon = localIconURL(appID, a.APIName, a.Icon, false)
+		a.IconGray = localIconURL(appID, a.APIName, a.IconGray, true)
+	}
+}
+
+// fetch makes sure upstream is on disk and returns its path. Concurrent
+// callers for the same icon wait for a single download, which is not tied
+// to any of their requests so one visitor leaving does not fail the rest.
+// It returns apperr.ErrIconBusy when no download slot frees up in time.
+func (ip *iconProxy) fetch(upstream string) (string, error) {
+	path := ip.path(upstream)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	ip.mu.Lock()
+	d, ok := ip.inflight[path]
+	ip.mu.Unlock()
+	if !ok {
+		if !ip.acquireSlot() {
+			return "", apperr.ErrIconBusy
+		}
+		ip.mu.Lock()
+		if d, ok = ip.inflight[path]; ok {
+			// Started by someone else while we waited for the slot.
+			<-ip.slots
+		} else if _, err := os.Stat(path); err == nil {
+			// Or even finished.
+			<-ip.slots
+			ip.mu.Unlock()
+			return path, nil
+		} else {
+			d = &iconDownload{done: make(chan struct{})}
+			ip.inflight[path] = d
+			go func() {
+				d.err = ip.download(upstream, path)
+				<-ip.slots
+				ip.mu.Lock()
+				delete(ip.inflight, path)
+				ip.mu.Unlock()
+				close(d.done)
+			}()
+		}
+		ip.mu.Unlock()
+	}
+
+	<-d.done
+	if d.err != nil {
+		return "", d.err
+	}
+	return path, nil
+}
+
+func (ip *iconProxy) acquireSlot() bool {
+	select {
+	case ip.slots <- struct{}{}:
+		return true
+	default:
+	}
+	t := time.NewTimer(iconSlotWait)
+	defer t.Stop()
+	select {
+	case ip.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func (ip *iconProxy) download(upstream, path string) error {
+	u, err := neturl.Parse(upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("icon URL is not http(s)")
+	}
+	metrics.iconFetches.Add(1)
+	res, err := ip.client.Get(upstream)
+	if err != nil {
+		return redactError(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s -> %d", redactURL(u), res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxIconBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxIconBytes {
+		return fmt.Errorf("icon larger than %d bytes", maxIconBytes)
+	}
+	if ct := http.DetectContentType(body); !strings.HasPrefix(ct, "image/") {
+		return fmt.Errorf("icon is %s, not an image", ct)
+	}
+
+	if err := os.MkdirAll(ip.dir, 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, func(f *os.File) error {
+		_, err := f.Write(body)
+		return err
+	}); err != nil {
+		return err
+	}
+	log.Printf("icon cached: %s (%d bytes)", redactURL(u), len(body))
+	ip.colorJobs.Add(1)
+	go func() {
+		defer ip.colorJobs.Done()
+		ip.storeColor(path, body)
+	}()
+	return nil
+}
+
+// iconUpstream is the CDN URL of an icon of apiName in appID, as listed
+// (with the visibility policy applied) by /achievements.
+func (s *Server) iconUpstream(r *http.Request, appID AppID, apiName string, gray bool) (string, error) {
+	var items []Achievement
+	if appID == defaultGlobalAppID {
+		idx, err := s.globalAchievementIndex(r.Context(), defaultLang)
+		if err != nil {
+			return "", err
+		}
+		items = idx.items
+	} else {
+		list, err := s.appAchievementList(r.Context(), appID, defaultLang)
+		if err != nil {
+			return "", err
+		}
+		items = list
+	}
+	p := s.policies.forApp(appID)
+	for _, a := range items {
+		if a.APIName != apiName {
+			continue
+		}
+		if p.hidden(a.APIName) || p.redacted(a.APIName) {
+			break
+		}
+		upstream := a.Icon
+		if gray {
+			upstream = a.IconGray
+		}
+		if upstream == "" {
+			break
+		}
+		return upstream, nil
+	}
+	return "", apperr.ErrUnknownIcon
+}
+
+func (s *Server) handleIcon(w http.ResponseWriter, r *http.Request) {
+	if s.icons == nil {
+		writeError(w, http.StatusNotFound, "icons_not_proxied", "Le proxy d'icones est desactive (PROXY_ICONS)")
+		return
+	}
+	q := r.URL.Query()
+	appID := defaultGlobalAppID
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		v, err := parseAppID(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
+		}
+		appID = v
+	}
+	if !s.appAllowed(appID) {
+		writeAppNotAllowed(w, appID)
+		return
+	}
+	var notes []string
+	gray, err := grayParam.fromQuery(q, "false", &notes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	apiName := r.PathValue("apiName")
+	upstream, err := s.iconUpstream(r, appID, apiName, gray == "true")
+	if errors.Is(err, apperr.ErrUnknownIcon) {
+		writeAppError(w, err)
+		return
+	}
+	if err != nil {
+		writeSyncError(w, err, fmt.Sprintf("icon, appID=%d, apiName=%s", appID, apiName))
+		return
+	}
+
+	icon := s.icons.memory.get(s.icons.path(upstream))
+	if icon == nil {
+		path, err := s.icons.fetch(upstream)
+		if errors.Is(err, apperr.ErrIconBusy) {
+			serveIconPlaceholder(w)
+			return
+		}
+		if err != nil {
+			log.Printf("icon app %d %s: %v", appID, apiName, err)
+			writeError(w, http.StatusBadGateway, "icon_unavailable", "Icone indisponible pour le moment")
+			return
+		}
+		if icon, err = s.icons.load(path); err != nil {
+			writeError(w, http.StatusInternalServerError, "icon_unavailable", err.Error())
+			return
+		}
+	}
+	w.Header().Set("Content-Type", icon.contentType)
+	if q.Get("v") == iconVersion(upstream) {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", cacheControlMaxAge(iconUnversionedAge))
+	}
+	setSurrogateKeys(w, appSurrogateKey(appID))
+	http.ServeContent(w, r, "", icon.modTime, bytes.NewReader(icon.body))
+}
+
+// load reads the icon fetched to path and keeps it in memory.
+func (ip *iconProxy) load(path string) (*memoryIcon, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &memoryIcon{path: path, body: body, contentType: http.DetectContentType(body), modTime: st.ModTime()}
+	ip.memory.put(e)
+	return e, nil
+}
+
+func serveIconPlaceholder(w http.ResponseWriter) {
+	metrics.iconPlaceholders.Add(1)
+	h := w.Header()
+	h.Set("Content-Type", "image/svg+xml")
+	h.Set("Cache-Control", cacheControlMaxAge(iconPlaceholderAge))
+	h.Set(iconPlaceholderMarkHeader, "1")
+	h.Set("Content-Length", strconv.Itoa(len(iconPlaceholder)))
+	io.WriteString(w, iconPlaceholder)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// slowCDN takes delay per icon and records the downloads per path and the
+// most running at once.
+type slowCDN struct {
+	srv     *httptest.Server
+	running atomic.Int64
+	peak    atomic.Int64
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newSlowCDN(t *testing.T, delay time.Duration) *slowCDN {
+	t.Helper()
+	body := encodePNG(t, solidIcon(color.NRGBA{0x20, 0x40, 0x80, 0xff}))
+	c := &slowCDN{calls: make(map[string]int)}
+	c.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := c.running.Add(1)
+		defer c.running.Add(-1)
+		for peak := c.peak.Load(); n > peak && !c.peak.CompareAndSwap(peak, n); peak = c.peak.Load() {
+		}
+		c.mu.Lock()
+		c.calls[r.URL.Path]++
+		c.mu.Unlock()
+		time.Sleep(delay)
+		w.Write(body)
+	}))
+	t.Cleanup(c.srv.Close)
+	return c
+}
+
+func (c *slowCDN) downloads() (total, most int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, n := range c.calls {
+		total += n
+		most = max(most, n)
+	}
+	return total, most
+}
+
+// iconGame lists n achievements whose icons are on cdn.
+func iconGame(t *testing.T, cdn *slowCDN, n int) {
+	t.Helper()
+	var achs []string
+	for i := range n {
+		achs = append(achs, fmt.Sprintf(`{"name":"ACH_%02d","displayName":"Succes %d","description":"d","icon":"%s/%d.png","icongray":"%s/%d_gray.png","hidden":0}`, i, i, cdn.srv.URL, i, cdn.srv.URL, i))
+	}
+	f := useFakeSteam(t)
+	f.handle("GetSchemaForGame", http.StatusOK, `{"game":{"availableGameStats":{"achievements":[`+strings.Join(achs, ",")+`]}}}`)
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, `{"achievementpercentages":{"achievements":[]}}`)
+}
+
+func TestIconColdStartStampede(t *testing.T) {
+	cdn := newSlowCDN(t, 400*time.Millisecond)
+	iconGame(t, cdn, 10)
+	s := newTestServer(t)
+	const limit = 4
+	s.icons = newIconProxy(t.TempDir(), limit)
+	t.Cleanup(s.icons.colorJobs.Wait)
+	get := playerMux(t, s)
+	// The schema is cached before the icons are asked for, as after the
+	// page's first /achievements.
+	get("/api/achievements")
+	placeholdersBefore := metrics.iconPlaceholders.Load()
+
+	var wg sync.WaitGroup
+	var icons, placeholders atomic.Int64
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := get(fmt.Sprintf("/api/icons/ACH_%02d?appId=105600", i%10))
+			switch {
+			case w.Code == http.StatusOK && w.Header().Get("Content-Type") == "image/png":
+				icons.Add(1)
+			case w.Code == http.StatusOK && w.Header().Get(iconPlaceholderMarkHeader) == "1":
+				placeholders.Add(1)
+				if w.Header().Get("Content-Type") != "image/svg+xml" || w.Header().Get("Cache-Control") != cacheControlMaxAge(iconPlaceholderAge) {
+					t.Errorf("placeholder headers %v", w.Header())
+				}
+			default:
+				t.Errorf("icon %d: %d %s", i%10, w.Code, w.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	total, most := cdn.downloads()
+	if total > 10 || most > 1 {
+		t.Errorf("%d downloads, %d for one icon; want at most 10, one each", total, most)
+	}
+	if peak := cdn.peak.Load(); peak > limit {
+		t.Errorf("%d downloads at once, cap %d", peak, limit)
+	}
+	// 400ms downloads against a 250ms slot wait: some requests had to give up.
+	if placeholders.Load() == 0 || icons.Load()+placeholders.Load() != 50 {
+		t.Errorf("%d icons, %d placeholders", icons.Load(), placeholders.Load())
+	}
+	if n := metrics.iconPlaceholders.Load() - placeholdersBefore; n != placeholders.Load() {
+		t.Errorf("metrics counted %d placeholders, %d served", n, placeholders.Load())
+	}
+
+	// Once the icons trickled in, each is served without another download.
+	for i := range 10 {
+		if w := get(fmt.Sprintf("/api/icons/ACH_%02d?appId=105600", i)); w.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("icon %d on the next load: %d %v", i, w.Code, w.Header())
+		}
+	}
+	if total, most := cdn.downloads(); total != 10 || most != 1 {
+		t.Errorf("after the next load: %d downloads, %d for one icon; want 10, one each", total, most)
+	}
+}
+
+// Concurrent requests for one icon share its download, and with slots to
+// spare nobody gets a placeholder.
+func TestIconFetchSingleflight(t *testing.T) {
+	cdn := newSlowCDN(t, 100*time.Millisecond)
+	ip := newIconProxy(t.TempDir(), 10)
+	t.Cleanup(ip.colorJobs.Wait)
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ip.fetch(fmt.Sprintf("%s/%d.png", cdn.srv.URL, i%10)); err != nil {
+				t.Errorf("fetch %d: %v", i%10, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if total, most := cdn.downloads(); total != 10 || most != 1 {
+		t.Errorf("%d downloads, %d for one icon; want 10, one each", total, most)
+	}
+}
+
+func TestIconFetchBusy(t *testing.T) {
+	cdn := newSlowCDN(t, time.Second)
+	ip := newIconProxy(t.TempDir(), 1)
+	t.Cleanup(ip.colorJobs.Wait)
+	go ip.fetch(cdn.srv.URL + "/1.png")
+	for cdn.running.Load() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	start := time.Now()
+	if _, err := ip.fetch(cdn.srv.URL + "/2.png"); !errors.Is(err, apperr.ErrIconBusy) {
+		t.Errorf("with the only slot taken: %v, want ErrIconBusy", err)
+	}
+	if waited := time.Since(start); waited < iconSlotWait || waited > iconSlotWait+200*time.Millisecond {
+		t.Errorf("gave up after %s, want %s", waited, iconSlotWait)
+	}
+	// The icon already downloading is shared, not refused.
+	if _, err := ip.fetch(cdn.srv.URL + "/1.png"); err != nil {
+		t.Errorf("joining the running download: %v", err)
+	}
+}
+
+// With the proxy on, the cached player list served during a Steam outage
+// points at the proxy too, never at the Steam CDN.
+func TestStalePlayerAchievementsProxyIcons(t *testing.T) {
+	f := useFakeSteam(t)
+	f.handle("GetSchemaForGame", http.StatusOK, strings.ReplaceAll(fakeSchemaJSON, `"icon":""`, `"icon":"https://cdn.steam.example/a.jpg"`))
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, fakePctJSON)
+	f.handle("GetPlayerSummaries", http.StatusOK, fakeSummaryJSON)
+	f.handle("GetOwnedGames", http.StatusOK, fakeOwnedJSON)
+	f.handle("GetUserStatsForGame", http.StatusOK, fakeStatsJSON)
+	s := newTestServer(t)
+	s.icons = newIconProxy(t.TempDir(), 2)
+	get := playerMux(t, s)
+	const path = "/api/users/achievements?steamId=76561197960287930&appId=105600"
+
+	if w := get(path); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/api/icon/A?") {
+		t.Fatalf("fresh: %d %s", w.Code, w.Body)
+	}
+	f.handle("GetOwnedGames", http.StatusNotFound, ``)
+	w := get(path + "&refresh=true")
+	if w.Code != http.StatusOK || w.Header().Get("X-Data-Stale") != "1" {
+		t.Fatalf("Steam down: %d, X-Data-Stale %q: %s", w.Code, w.Header().Get("X-Data-Stale"), w.Body)
+	}
+	if body := w.Body.String(); strings.Contains(body, "cdn.steam.example") || !strings.Contains(body, "/api/icon/A?") {
+		t.Errorf("stale list icons not proxied: %s", body)
+	}
+}
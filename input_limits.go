@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Input limits reject oversized requests before routing, so a 50KB query
+// string or a parameter repeated a thousand times never reaches the query
+// parsers, the cache or Steam. Defaults are far above what the front end
+// sends.
+const (
+	defaultMaxURLLength    = 8 << 10
+	defaultMaxQueryParams  = 64
+	defaultMaxParamRepeat  = 8
+	defaultMaxHeaderBytes  = 32 << 10
+	inputLimitReasonURL    = "uri_too_long"
+	inputLimitReasonParams = "too_many_params"
+	inputLimitReasonRepeat = "param_repeated"
+	inputLimitReasonHeader = "headers_too_large"
+)
+
+type inputLimits struct {
+	MaxURLLength   int `json:"maxUrlLength"`
+	MaxQueryParams int `json:"maxQueryParams"`
+	MaxParamRepeat int `json:"maxParamRepeat"`
+	MaxHeaderBytes int `json:"maxHeaderBytes"`
+
+	rejectedURL    atomic.Int64
+	rejectedParams atomic.Int64
+	rejectedRepeat atomic.Int64
+	rejectedHeader atomic.Int64
+}
+
+// requestLimits is set by main; the check command leaves it nil.
+var requestLimits *inputLimits
+
+func inputLimitsFromEnv() *inputLimits {
+	l := &inputLimits{
+		MaxURLLength:   getenvInt("MAX_URL_LENGTH", defaultMaxURLLength),
+		MaxQueryParams: getenvInt("MAX_QUERY_PARAMS", defaultMaxQueryParams),
+		MaxParamRepeat: getenvInt("MAX_PARAM_REPEAT", defaultMaxParamRepeat),
+		MaxHeaderBytes: getenvInt("MAX_HEADER_BYTES", defaultMaxHeaderBytes),
+	}
+	if l.MaxURLLength <= 0 {
+		l.MaxURLLength = defaultMaxURLLength
+	}
+	if l.MaxQueryParams <= 0 {
+		l.MaxQueryParams = defaultMaxQueryParams
+	}
+	if l.MaxParamRepeat <= 0 {
+		l.MaxParamRepeat = defaultMaxParamRepeat
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = defaultMaxHeaderBytes
+	}
+	return l
+}
+
+// wrap runs just inside CORS, before routing. http.Server.MaxHeaderBytes is set to
+// the same header limit, but net/http allows some slack past it and answers
+// with a plain-text 431, so headers are also measured here to get the
+// structured error.
+func (l *inputLimits) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason, status, detail := l.check(r); reason != "" {
+			writeError(w, status, reason, detail)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check scans the raw URL without decoding it, so rejected requests cost
+// one pass over their bytes.
+func (l *inputLimits) check(r *http.Request) (reason string, status int, detail string) {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	if len(uri) > l.MaxURLLength {
+		l.rejectedURL.Add(1)
+		return inputLimitReasonURL, http.StatusRequestURITooLong, fmt.Sprintf("URL trop longue (max %d octets)", l.MaxURLLength)
+	}
+
+	if raw := r.URL.RawQuery; raw != "" {
+		if n := strings.Count(raw, "&") + 1; n > l.MaxQueryParams {
+			l.rejectedParams.Add(1)
+			return inputLimitReasonParams, http.StatusBadRequest, fmt.Sprintf("Trop de parametres (max %d)", l.MaxQueryParams)
+		}
+		if name := l.repeatedParam(raw); name != "" {
+			l.rejectedRepeat.Add(1)
+			return inputLimitReasonRepeat, http.StatusBadRequest, fmt.Sprintf("Parametre %q repete plus de %d fois", name, l.MaxParamRepeat)
+		}
+	}
+
+	size := 0
+	for k, vs := range r.Header {
+		for _, v := range vs {
+			size += len(k) + len(v) + 4 // ": " and CRLF
+		}
+	}
+	if size > l.MaxHeaderBytes {
+		l.rejectedHeader.Add(1)
+		return inputLimitReasonHeader, http.StatusRequestHeaderFieldsTooLarge, fmt.Sprintf("En-tetes trop volumineux (max %d octets)", l.MaxHeaderBytes)
+	}
+	return "", 0, ""
+}
+
+// repeatedParam returns the first raw parameter name present more than
+// MaxParamRepeat times. The parameter count is already capped, so the
+// quadratic scan stays small.
+func (l *inputLimits) repeatedParam(raw string) string {
+	names := make([]string, 0, l.MaxQueryParams)
+	for raw != "" {
+		var pair string
+		pair, raw, _ = strings.Cut(raw, "&")
+		name, _, _ := strings.Cut(pair, "=")
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	for i, name := range names {
+		seen := 1
+		for _, other := range names[i+1:] {
+			if other == name {
+				seen++
+			}
+		}
+		if seen > l.MaxParamRepeat {
+			return name
+		}
+	}
+	return ""
+}
+
+func (s *Server) handleAdminLimits(w http.ResponseWriter, r *http.Request) {
+	l := requestLimits
+	if l == nil {
+		writeJSON(w, map[string]any{"enabled": false})
+		return
+	}
+	writeJSON(w, map[string]any{
+		"enabled": true,
+		"limits":  l,
+		"rejected": map[string]int64{
+			inputLimitReasonURL:    l.rejectedURL.Load(),
+			inputLimitReasonParams: l.rejectedParams.Load(),
+			inputLimitReasonRepeat: l.rejectedRepeat.Load(),
+			inputLimitReasonHeader: l.rejectedHeader.Load(),
+		},
+		"rateLimit": rateLimits.snapshot(),
+	})
+}
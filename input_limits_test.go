@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInputLimitsRejectEarly(t *testing.T) {
+	f := fakeSteamGame(t)
+	s := newTestServer(t)
+	mux := http.NewServeMux()
+	if err := registerAPIRoutes(mux, s.apiRoutes(), apiV1); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MAX_URL_LENGTH", "")
+	l := inputLimitsFromEnv()
+	h := l.wrap(mux)
+
+	repeated := strings.Repeat("tag=a&", defaultMaxParamRepeat+1)
+	tests := []struct {
+		name   string
+		target string
+		header int
+		status int
+		reason string
+	}{
+		{"50KB query", "/api/achievements?q=" + strings.Repeat("a", 50<<10), 0, http.StatusRequestURITooLong, inputLimitReasonURL},
+		{"50KB path", "/api/icon/" + strings.Repeat("a", 50<<10), 0, http.StatusRequestURITooLong, inputLimitReasonURL},
+		{"too many parameters", "/api/achievements?" + strings.Repeat("x=1&", defaultMaxQueryParams) + "y=1", 0, http.StatusBadRequest, inputLimitReasonParams},
+		{"repeated parameter", "/api/achievements?" + repeated, 0, http.StatusBadRequest, inputLimitReasonRepeat},
+		{"huge headers", "/api/achievements", defaultMaxHeaderBytes, http.StatusRequestHeaderFieldsTooLarge, inputLimitReasonHeader},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.header > 0 {
+			r.Header.Set("X-Padding", strings.Repeat("h", tt.header))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), `"error": "`+tt.reason+`"`) {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, w.Code, w.Body, tt.status, tt.reason)
+		}
+	}
+
+	// Nothing reached the handlers: no Steam call, nothing cached.
+	for _, fragment := range []string{"GetSchemaForGame", "GetGlobalAchievementPercentagesForApp"} {
+		if n := f.count(fragment); n != 0 {
+			t.Errorf("%d %s calls for rejected requests", n, fragment)
+		}
+	}
+	if keys := s.cacheKeys(); len(keys) != 0 {
+		t.Errorf("cache filled by rejected requests: %v", keys)
+	}
+	if l.rejectedURL.Load() != 2 || l.rejectedParams.Load() != 1 || l.rejectedRepeat.Load() != 1 || l.rejectedHeader.Load() != 1 {
+		t.Errorf("rejections counted: url %d, params %d, repeat %d, header %d",
+			l.rejectedURL.Load(), l.rejectedParams.Load(), l.rejectedRepeat.Load(), l.rejectedHeader.Load())
+	}
+}
+
+func TestInputLimitsAtTheLimit(t *testing.T) {
+	l := &inputLimits{MaxURLLength: 64, MaxQueryParams: 4, MaxParamRepeat: 2, MaxHeaderBytes: 1 << 10}
+	reached := 0
+	h := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached++ }))
+	for _, target := range []string{
+		"/api/achievements?" + strings.Repeat("q", 64-len("/api/achievements?")),
+		"/api/achievements?a=1&b=2&c=3&d=4",
+		"/api/achievements?tag=a&tag=b&x=1",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s (%d bytes): %d %s", target, len(target), w.Code, w.Body)
+		}
+	}
+	if reached != 3 {
+		t.Errorf("%d requests reached the handler, want 3", reached)
+	}
+}
+
+func TestInputLimitsFromEnv(t *testing.T) {
+	t.Setenv("MAX_URL_LENGTH", "1024")
+	t.Setenv("MAX_QUERY_PARAMS", "0")
+	t.Setenv("MAX_PARAM_REPEAT", "-3")
+	t.Setenv("MAX_HEADER_BYTES", "")
+	l := inputLimitsFromEnv()
+	if l.MaxURLLength != 1024 || l.MaxQueryParams != defaultMaxQueryParams || l.MaxParamRepeat != defaultMaxParamRepeat || l.MaxHeaderBytes != defaultMaxHeaderBytes {
+		t.Errorf("limits %+v", l)
+	}
+}
@@ -0,0 +1,167 @@
+// Package apperr is the home of the domain errors of the server: each one
+// has a stable code (the "error" field of the JSON answers), a default HTTP
+// status and a user message in every language of Languages.
+//
+// Errors are compared by code, so a wrapped error still matches its
+// sentinel:
+//
+//	err := apperr.Wrap(apperr.ErrProfilePrivate, "owned games of 7656...")
+//	errors.Is(err, apperr.ErrProfilePrivate) // true
+//	apperr.Is(err, apperr.CodePrivateProfile) // true
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type Code string
+
+const (
+	CodeReadOnly         Code = "read_only"
+	CodePrivateProfile   Code = "private_profile"
+	CodeInvalidAPIKey    Code = "invalid_api_key"
+	CodeInvalidSteamID   Code = "invalid_steam_id"
+	CodeInvalidCursor    Code = "invalid_cursor"
+	CodeAppNotAllowed    Code = "app_not_allowed"
+	CodeUnknownIcon      Code = "unknown_icon"
+	CodeIconBusy         Code = "icon_busy"
+	CodeShuttingDown     Code = "shutting_down"
+	CodeWriteQueueClosed Code = "write_queue_closed"
+	CodeSteamSync        Code = "steam_sync_error"
+	CodeHotlinkBlocked   Code = "hotlink_blocked"
+	CodeUpstreamQuota    Code = "upstream_quota"
+	CodeUpstreamOpen     Code = "upstream_unavailable"
+	CodeRateLimited      Code = "rate_limited"
+	CodeRefreshQueueFull Code = "refresh_queue_full"
+)
+
+// Error is a domain error. Msg is for logs, in English; users get the
+// message of Code.
+type Error struct {
+	Code Code
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Msg
+	case e.Msg == "":
+		return e.Err.Error()
+	}
+	return e.Msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is matches any *Error of the same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+func New(code Code, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
+
+// Wrap adds context to err, keeping its code; nil stays nil.
+func Wrap(err error, context string) error {
+	if err == nil {
+		return nil
+	}
+	code, _ := CodeOf(err)
+	return &Error{Code: code, Msg: context, Err: err}
+}
+
+// Wrapf is Wrap with a format.
+func Wrapf(err error, format string, args ...any) error {
+	return Wrap(err, fmt.Sprintf(format, args...))
+}
+
+// CodeOf is the code of the outermost *Error in the chain of err.
+func CodeOf(err error) (Code, bool) {
+	var e *Error
+	for errors.As(err, &e) {
+		if e.Code != "" {
+			return e.Code, true
+		}
+		err = e.Err
+	}
+	return "", false
+}
+
+func Is(err error, code Code) bool {
+	c, ok := CodeOf(err)
+	return ok && c == code
+}
+
+// Sentinels returned by the server.
+var (
+	ErrReadOnly         = New(CodeReadOnly, "server is in read-only mode")
+	ErrProfilePrivate   = New(CodePrivateProfile, "steam profile is private or stats unavailable")
+	ErrInvalidKey       = New(CodeInvalidAPIKey, "invalid steam api key")
+	ErrMalformedSteamID = New(CodeInvalidSteamID, "malformed steam id")
+	ErrBadCursor        = New(CodeInvalidCursor, "bad cursor")
+	ErrUnknownIcon      = New(CodeUnknownIcon, "unknown icon")
+	ErrIconBusy         = New(CodeIconBusy, "icon downloads saturated")
+	ErrRequestAborted   = New(CodeShuttingDown, "request aborted by shutdown")
+	ErrWriteQueueClosed = New(CodeWriteQueueClosed, "write queue closed")
+	ErrUpstreamQuota    = New(CodeUpstreamQuota, "steam request budget spent")
+	ErrUpstreamOpen     = New(CodeUpstreamOpen, "steam calls suspended by the circuit breaker")
+)
+
+var statuses = map[Code]int{
+	CodeReadOnly:         http.StatusServiceUnavailable,
+	CodePrivateProfile:   http.StatusForbidden,
+	CodeInvalidAPIKey:    http.StatusBadGateway,
+	CodeInvalidSteamID:   http.StatusBadRequest,
+	CodeInvalidCursor:    http.StatusBadRequest,
+	CodeAppNotAllowed:    http.StatusForbidden,
+	CodeUnknownIcon:      http.StatusNotFound,
+	CodeIconBusy:         http.StatusServiceUnavailable,
+	CodeShuttingDown:     http.StatusServiceUnavailable,
+	CodeWriteQueueClosed: http.StatusServiceUnavailable,
+	CodeSteamSync:        http.StatusBadGateway,
+	CodeHotlinkBlocked:   http.StatusForbidden,
+	CodeUpstreamQuota:    http.StatusServiceUnavailable,
+	CodeUpstreamOpen:     http.StatusServiceUnavailable,
+	CodeRateLimited:      http.StatusTooManyRequests,
+	CodeRefreshQueueFull: http.StatusTooManyRequests,
+}
+
+// Status is the HTTP status code answers with; 500 for a code without one.
+func Status(code Code) int {
+	if s, ok := statuses[code]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// Check reports the codes missing a status or a message in one of the
+// languages. The check command runs it.
+func Check() error {
+	var errs []error
+	seen := make(map[Code]bool)
+	for _, lang := range Languages {
+		for c := range messages[lang] {
+			seen[c] = true
+		}
+	}
+	for c := range statuses {
+		seen[c] = true
+	}
+	for c := range seen {
+		if _, ok := statuses[c]; !ok {
+			errs = append(errs, fmt.Errorf("code %s: no HTTP status", c))
+		}
+		for _, lang := range Languages {
+			if messages[lang][c] == "" {
+				errs = append(errs, fmt.Errorf("code %s: no %s message", c, lang))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,121 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// declaredCodes reads the Code constants from the source, so a code added
+// without a status or a message fails here and not in production.
+func declaredCodes(t *testing.T) map[Code]string {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "apperr.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[Code]string)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if id, ok := vs.Type.(*ast.Ident); !ok || id.Name != "Code" {
+				continue
+			}
+			for i, name := range vs.Names {
+				v, _ := strconv.Unquote(vs.Values[i].(*ast.BasicLit).Value)
+				if prev, dup := out[Code(v)]; dup {
+					t.Errorf("%s and %s share the code %q", prev, name.Name, v)
+				}
+				out[Code(v)] = name.Name
+			}
+		}
+	}
+	if len(out) == 0 {
+		t.Fatal("no Code constant found")
+	}
+	return out
+}
+
+var verbs = regexp.MustCompile(`%[a-z]`)
+
+func TestEveryCodeHasStatusAndMessages(t *testing.T) {
+	codes := declaredCodes(t)
+	for code, name := range codes {
+		if _, ok := statuses[code]; !ok {
+			t.Errorf("%s: no HTTP status", name)
+		}
+		for _, lang := range Languages {
+			if messages[lang][code] == "" {
+				t.Errorf("%s: no %s message", name, lang)
+			}
+		}
+		// Callers pass the same args whatever the language.
+		want := fmt.Sprint(verbs.FindAllString(messages[DefaultLang][code], -1))
+		for _, lang := range Languages {
+			if got := fmt.Sprint(verbs.FindAllString(messages[lang][code], -1)); got != want {
+				t.Errorf("%s: %s message takes %s, %s takes %s", name, lang, got, DefaultLang, want)
+			}
+		}
+	}
+	for code := range statuses {
+		if _, ok := codes[code]; !ok {
+			t.Errorf("status for the undeclared code %q", code)
+		}
+	}
+	for _, lang := range Languages {
+		for code := range messages[lang] {
+			if _, ok := codes[code]; !ok {
+				t.Errorf("%s message for the undeclared code %q", lang, code)
+			}
+		}
+	}
+	if err := Check(); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+}
+
+func TestWrapKeepsTheCode(t *testing.T) {
+	err := Wrapf(ErrProfilePrivate, "owned games of %d", 76561197960287930)
+	if !errors.Is(err, ErrProfilePrivate) || errors.Is(err, ErrInvalidKey) || !Is(err, CodePrivateProfile) {
+		t.Errorf("%v does not match its sentinel", err)
+	}
+	if err.Error() != "owned games of 76561197960287930: steam profile is private or stats unavailable" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	outer := fmt.Errorf("sync: %w", Wrap(err, "player"))
+	if c, ok := CodeOf(outer); !ok || c != CodePrivateProfile || Status(c) != http.StatusForbidden {
+		t.Errorf("CodeOf through fmt.Errorf = %q, %v", c, ok)
+	}
+	if Wrap(nil, "x") != nil {
+		t.Errorf("Wrap(nil) is not nil")
+	}
+	plain := errors.New("disk full")
+	if _, ok := CodeOf(Wrap(plain, "x")); ok || Is(plain, CodeReadOnly) {
+		t.Errorf("a plain error got a code")
+	}
+	if !errors.Is(Wrap(plain, "x"), plain) {
+		t.Errorf("Wrap hid the wrapped error")
+	}
+}
+
+func TestStatusAndMessage(t *testing.T) {
+	if Status("made_up") != http.StatusInternalServerError {
+		t.Errorf("unknown code status %d", Status("made_up"))
+	}
+	if got := Message(CodeAppNotAllowed, "en", 440); got != "App 440 is not served by this instance" {
+		t.Errorf("en message %q", got)
+	}
+	if got := Message(CodeReadOnly, "de"); got != messages[DefaultLang][CodeReadOnly] {
+		t.Errorf("unknown language message %q", got)
+	}
+}
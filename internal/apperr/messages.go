@@ -0,0 +1,61 @@
+package apperr
+
+import "fmt"
+
+// Languages of the user messages; DefaultLang is the one the API answers
+// in. Messages stay without accents, like every answer of the API.
+var Languages = []string{"fr", "en"}
+
+const DefaultLang = "fr"
+
+var messages = map[string]map[Code]string{
+	"fr": {
+		CodeReadOnly:         "Maintenance en cours: l'API est en lecture seule, reessaie plus tard",
+		CodePrivateProfile:   "Profil prive ou statistiques inaccessibles pour ce SteamID",
+		CodeInvalidAPIKey:    "Cle Steam API invalide ou mal configuree cote serveur",
+		CodeInvalidSteamID:   "Format de SteamID invalide. Formats acceptes: %s",
+		CodeInvalidCursor:    "Curseur invalide ou altere",
+		CodeAppNotAllowed:    "L'app %d n'est pas servie par cette instance",
+		CodeUnknownIcon:      "Aucune icone pour ce succes",
+		CodeIconBusy:         "Trop d'icones en cours de telechargement, reessaie dans un instant",
+		CodeShuttingDown:     "Le serveur redemarre, reessaie dans quelques secondes",
+		CodeWriteQueueClosed: "Le serveur s'arrete, modification refusee",
+		CodeSteamSync:        "Echec de synchronisation avec Steam",
+		CodeHotlinkBlocked:   "Cette ressource ne peut etre integree que par les sites autorises",
+		CodeUpstreamQuota:    "Quota de requetes Steam du jour atteint, reessaie plus tard",
+		CodeUpstreamOpen:     "Steam ne repond plus, reessaie dans un instant",
+		CodeRateLimited:      "Trop de requetes depuis cette adresse, reessaie dans %d s",
+		CodeRefreshQueueFull: "File de rafraichissement pleine, reessaie dans %d s",
+	},
+	"en": {
+		CodeReadOnly:         "Maintenance in progress: the API is read-only, try again later",
+		CodePrivateProfile:   "Private profile or stats unavailable for this SteamID",
+		CodeInvalidAPIKey:    "Invalid or misconfigured Steam API key on the server",
+		CodeInvalidSteamID:   "Malformed SteamID. Accepted forms: %s",
+		CodeInvalidCursor:    "Invalid or tampered cursor",
+		CodeAppNotAllowed:    "App %d is not served by this instance",
+		CodeUnknownIcon:      "No icon for this achievement",
+		CodeIconBusy:         "Too many icons being downloaded, try again in a moment",
+		CodeShuttingDown:     "The server is restarting, try again in a few seconds",
+		CodeWriteQueueClosed: "The server is shutting down, change refused",
+		CodeSteamSync:        "Failed to sync with Steam",
+		CodeHotlinkBlocked:   "This resource may only be embedded by allowed sites",
+		CodeUpstreamQuota:    "Today's Steam request quota is spent, try again later",
+		CodeUpstreamOpen:     "Steam is not answering, try again in a moment",
+		CodeRateLimited:      "Too many requests from this address, try again in %d s",
+		CodeRefreshQueueFull: "The refresh queue is full, try again in %d s",
+	},
+}
+
+// Message is the user message of code in lang (DefaultLang when lang has
+// none), formatted with args.
+func Message(code Code, lang string, args ...any) string {
+	m, ok := messages[lang][code]
+	if !ok {
+		m = messages[DefaultLang][code]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(m, args...)
+	}
+	return m
+}
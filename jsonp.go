@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const jsonpMiddlewareName = "jsonp"
+
+// jsonpCallbackPattern accepts plain or dotted JavaScript identifiers
+// ("cb", "Partner.widgets.render") and nothing else: no brackets, quotes,
+// parentheses or spaces can reach the response.
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*){0,3}$`)
+
+const jsonpCallbackMaxLen = 64
+
+// jsonpReservedWords are the JavaScript reserved words, and the globals
+// that would turn the callback into something else than a call, refused as
+// any part of a callback.
+var jsonpReservedWords = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true, "else": true, "enum": true,
+	"export": true, "extends": true, "false": true, "finally": true, "for": true, "function": true,
+	"if": true, "implements": true, "import": true, "in": true, "instanceof": true, "interface": true,
+	"let": true, "new": true, "null": true, "package": true, "private": true, "protected": true,
+	"public": true, "return": true, "static": true, "super": true, "switch": true, "this": true,
+	"throw": true, "true": true, "try": true, "typeof": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true, "await": true, "async": true,
+	"eval": true, "arguments": true, "constructor": true, "__proto__": true, "prototype": true,
+}
+
+func validJSONPCallback(callback string) bool {
+	if len(callback) > jsonpCallbackMaxLen || !jsonpCallbackPattern.MatchString(callback) {
+		return false
+	}
+	for _, part := range strings.Split(callback, ".") {
+		if jsonpReservedWords[part] {
+			return false
+		}
+	}
+	return true
+}
+
+// withJSONP lets script-tag embeds read a public route: with ENABLE_JSONP=1
+// and ?callback=fn the JSON body is compacted and sent as "fn(...);".
+// Requests about a player (?steamId=) are refused so player data never
+// leaves through JSONP; validateRoutes keeps it off admin routes.
+func (rt apiRoute) jsonp(s *Server) apiRoute {
+	rt.Middleware = append(rt.Middleware, routeMiddleware{Name: jsonpMiddlewareName, Wrap: s.withJSONP})
+	return rt
+}
+
+func (s *Server) withJSONP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		callback := q.Get("callback")
+		if !s.jsonpEnabled || !q.Has("callback") {
+			next(w, r)
+			return
+		}
+		if !validJSONPCallback(callback) {
+			writeError(w, http.StatusBadRequest, "invalid_callback", "callback doit etre un identifiant JavaScript (lettres, chiffres, _ $ et points), hors mots reserves")
+			return
+		}
+		if q.Has("steamId") || q.Has("steamid") {
+			writeError(w, http.StatusBadRequest, "jsonp_not_allowed", "JSONP n'est pas disponible pour les donnees d'un joueur")
+			return
+		}
+
+		// The wrapped body must be plain JSON, not gzip.
+		r = r.Clone(r.Context())
+		r.Header.Del("Accept-Encoding")
+		rec := &jsonpRecorder{header: make(http.Header), status: http.StatusOK}
+		next(rec, r)
+
+		h := w.Header()
+		for k, v := range rec.header {
+			h[k] = v
+		}
+		for _, k := range []string{"Content-Length", "ETag", "Last-Modified", "Content-Encoding", "Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers"} {
+			h.Del(k)
+		}
+
+		var body bytes.Buffer
+		if !strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") || json.Compact(&body, rec.buf.Bytes()) != nil {
+			// Not JSON: pass it through untouched rather than guess.
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(rec.buf.Bytes())
+			return
+		}
+		h.Set("Content-Type", "application/javascript; charset=utf-8")
+		h.Set("X-Content-Type-Options", "nosniff")
+		sw := newSizingWriter(w, rec.status)
+		// The leading comment defuses content-sniffing attacks on the callback.
+		_, _ = sw.Write([]byte("/**/" + callback + "("))
+		_, _ = sw.Write(body.Bytes())
+		_, _ = sw.Write([]byte(");\n"))
+		_ = sw.Close()
+	}
+}
+
+type jsonpRecorder struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (r *jsonpRecorder) Header() http.Header         { return r.header }
+func (r *jsonpRecorder) Write(p []byte) (int, error) { return r.buf.Write(p) }
+func (r *jsonpRecorder) WriteHeader(status int)      { r.status = status }
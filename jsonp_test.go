@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func jsonpCall(s *Server, query string) *httptest.ResponseRecorder {
+	h := s.withJSONP(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		writeJSON(w, map[string]any{"items": []string{"A", "B"}})
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/api/v1/achievements?"+query, nil))
+	return w
+}
+
+func TestJSONPValidCallback(t *testing.T) {
+	s := &Server{jsonpEnabled: true}
+	for _, cb := range []string{"cb", "Partner.widgets.render", "$_jq123"} {
+		w := jsonpCall(s, "callback="+url.QueryEscape(cb))
+		body := w.Body.String()
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/javascript; charset=utf-8" {
+			t.Fatalf("%s: %d %s", cb, w.Code, w.Header().Get("Content-Type"))
+		}
+		if want := "/**/" + cb + `({"items":["A","B"]});` + "\n"; body != want {
+			t.Errorf("%s: body %q, want %q", cb, body, want)
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: headers %v", cb, w.Header())
+		}
+	}
+}
+
+func TestJSONPHostileCallback(t *testing.T) {
+	s := &Server{jsonpEnabled: true}
+	for _, cb := range []string{
+		"alert(1)//", "cb;alert(1)", "<script>", "a b", "", "1cb", "a..b", "a.b.c.d.e",
+		"function", "eval", "a.constructor", "window.__proto__", "this",
+		strings.Repeat("a", jsonpCallbackMaxLen+1),
+	} {
+		w := jsonpCall(s, "callback="+url.QueryEscape(cb))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_callback") {
+			t.Errorf("%q: %d %s", cb, w.Code, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") || strings.HasPrefix(w.Body.String(), "/**/") {
+			t.Errorf("%q answered as script: %s %s", cb, ct, w.Body)
+		}
+	}
+	if w := jsonpCall(s, "callback=cb&steamId=76561197960287930"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "jsonp_not_allowed") {
+		t.Errorf("player data through JSONP: %d %s", w.Code, w.Body)
+	}
+	if !validJSONPCallback(strings.Repeat("a", jsonpCallbackMaxLen)) {
+		t.Errorf("a callback of the maximum length refused")
+	}
+}
+
+func TestJSONPPlainJSON(t *testing.T) {
+	for _, tt := range []struct {
+		enabled bool
+		query   string
+	}{
+		{true, ""},
+		{false, "callback=cb"},
+	} {
+		w := jsonpCall(&Server{jsonpEnabled: tt.enabled}, tt.query)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") || strings.HasPrefix(w.Body.String(), "/**/") {
+			t.Errorf("enabled %v, ?%s: %d %s %q", tt.enabled, tt.query, w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+	}
+}
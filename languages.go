@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// ?lang= picks the language of achievement names and descriptions on
+// /achievements. French stays the default and the only language stored in
+// the database; other languages come from the per-app schema cache, which
+// is keyed by language, so one language never answers for another.
+// ACHIEVEMENTS_LANG changes the language of requests without ?lang=;
+// archives (?asOf=) stay french.
+const (
+	defaultLang  = "french"
+	fallbackLang = "english"
+)
+
+// langParam accepts the Steam language codes we expect visitors to use,
+// plus the usual ISO spellings.
+var langParam = enumParam{
+	name: "lang",
+	accepted: []string{
+		"french", "english", "german", "spanish", "latam", "italian", "portuguese", "brazilian",
+		"russian", "polish", "ukrainian", "dutch", "turkish", "japanese", "koreana", "schinese", "tchinese",
+	},
+	synonyms: map[string]string{
+		"fr": "french", "en": "english", "de": "german", "es": "spanish", "es-419": "latam", "it": "italian",
+		"pt": "portuguese", "pt-br": "brazilian", "ru": "russian", "pl": "polish", "uk": "ukrainian", "nl": "dutch",
+		"tr": "turkish", "ja": "japanese", "ko": "koreana", "zh-cn": "schinese", "zh-tw": "tchinese",
+	},
+}
+
+// langFromEnv reads ACHIEVEMENTS_LANG, spelled as ?lang= would be.
+func langFromEnv() (string, error) {
+	lang, err := langParam.normalize(getenv("ACHIEVEMENTS_LANG", ""))
+	if err != nil {
+		return "", fmt.Errorf("ACHIEVEMENTS_LANG: %w", err)
+	}
+	if lang == "" {
+		return defaultLang, nil
+	}
+	return lang, nil
+}
+
+// localizedSchema is the schema of appID in lang. Steam silently leaves
+// some names or descriptions empty in a translation; those are taken from
+// the english schema. The result is a copy the caller may modify.
+func (s *Server) localizedSchema(ctx context.Context, appID AppID, lang string) ([]Achievement, error) {
+	schema, err := s.fetchSchemaForGameCached(ctx, appID, lang)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Achievement, len(schema))
+	copy(items, schema)
+	if lang == fallbackLang || !hasUntranslated(items) {
+		return items, nil
+	}
+
+	ref, err := s.fetchSchemaForGameCached(ctx, appID, fallbackLang)
+	if err != nil {
+		log.Printf("%s fallback schema app %d: %v", fallbackLang, appID, err)
+		return items, nil
+	}
+	fillUntranslated(items, ref)
+	return items, nil
+}
+
+// fillUntranslated takes the names and descriptions missing from items
+// from ref, the same schema in fallbackLang.
+func fillUntranslated(items, ref []Achievement) {
+	byName := make(map[string]Achievement, len(ref))
+	for _, a := range ref {
+		byName[a.APIName] = a
+	}
+	for i := range items {
+		en, ok := byName[items[i].APIName]
+		if !ok {
+			continue
+		}
+		if items[i].Name == "" {
+			items[i].Name = en.Name
+		}
+		if items[i].Description == "" {
+			items[i].Description = en.Description
+		}
+	}
+}
+
+func hasUntranslated(items []Achievement) bool {
+	for _, a := range items {
+		if a.Name == "" || a.Description == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// localizeAchievements replaces the names and descriptions of items (the
+// stored french list of appID) with their lang version.
+func (s *Server) localizeAchievements(ctx context.Context, appID AppID, lang string, items []Achievement) error {
+	if lang == defaultLang {
+		return nil
+	}
+	schema, err := s.localizedSchema(ctx, appID, lang)
+	if err != nil {
+		return fmt.Errorf("%s schema: %w", lang, err)
+	}
+	byName := make(map[string]Achievement, len(schema))
+	for _, a := range schema {
+		byName[a.APIName] = a
+	}
+	for i := range items {
+		if a, ok := byName[items[i].APIName]; ok {
+			items[i].Name, items[i].Description = a.Name, a.Description
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const defaultLocalPctMinPlayers = 5
+
+// localStats is the unlock rate of each achievement among the players this
+// server knows, recomputed lazily after any player sync.
+type localStats struct {
+	pcts   map[string]float64
+	sample int
+}
+
+type localStatsCache struct {
+	mu         sync.Mutex
+	minPlayers int
+	byApp      map[AppID]localStats
+	// generation counts the invalidations: stats computed from rows read
+	// before one are not kept.
+	generation uint64
+}
+
+func newLocalStatsCache(minPlayers int) *localStatsCache {
+	return &localStatsCache{minPlayers: minPlayers, byApp: make(map[AppID]localStats)}
+}
+
+func (c *localStatsCache) invalidate() {
+	c.mu.Lock()
+	c.byApp = make(map[AppID]localStats)
+	c.generation++
+	c.mu.Unlock()
+}
+
+// lookup returns the kept stats of appID, and the generation to store new
+// ones with.
+func (c *localStatsCache) lookup(appID AppID) (localStats, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.byApp[appID]
+	return st, c.generation, ok
+}
+
+// store keeps st, computed at generation gen, unless an invalidation
+// happened since.
+func (c *localStatsCache) store(appID AppID, gen uint64, st localStats) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != gen {
+		return false
+	}
+	c.byApp[appID] = st
+	return true
+}
+
+func (s *Server) localStatsFor(appID AppID) (localStats, error) {
+	c := s.localStats
+	st, gen, ok := c.lookup(appID)
+	if ok {
+		return st, nil
+	}
+
+	st = localStats{pcts: make(map[string]float64)}
+	if err := s.db.QueryRow(`SELECT COUNT(DISTINCT steam_id) FROM user_achievements WHERE app_id=?`, appID).Scan(&st.sample); err != nil {
+		return st, err
+	}
+	if st.sample > 0 {
+		rows, err := s.db.Query(`
+			SELECT api_name, SUM(achieved)
+			FROM user_achievements
+			WHERE app_id=?
+			GROUP BY api_name
+		`, appID)
+		if err != nil {
+			return st, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			var unlocked int
+			if err := rows.Scan(&name, &unlocked); err != nil {
+				return st, err
+			}
+			st.pcts[name] = float64(unlocked) * 100 / float64(st.sample)
+		}
+		if err := rows.Err(); err != nil {
+			return st, err
+		}
+	}
+
+	c.store(appID, gen, st)
+	return st, nil
+}
+
+// applyLocalPct fills LocalPct when enough players are known and reports
+// the sample size in X-Local-Sample-Size.
+func (s *Server) applyLocalPct(w http.ResponseWriter, appID AppID, items []Achievement) error {
+	st, err := s.localStatsFor(appID)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Local-Sample-Size", strconv.Itoa(st.sample))
+	if st.sample < s.localStats.minPlayers {
+		return nil
+	}
+	for i := range items {
+		pct := st.pcts[items[i].APIName]
+		items[i].LocalPct = &pct
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// registerUnlocks records the player steamID with the achievements of app
+// 105600 they have, out of A, B, C and D.
+func registerUnlocks(t *testing.T, s *Server, steamID string, unlocked ...string) {
+	t.Helper()
+	has := make(map[string]bool)
+	for _, n := range unlocked {
+		has[n] = true
+	}
+	for _, name := range []string{"A", "B", "C", "D"} {
+		achieved := 0
+		if has[name] {
+			achieved = 1
+		}
+		if _, err := s.db.Exec(`INSERT INTO user_achievements(steam_id, app_id, api_name, name, description, icon, icon_gray, hidden, achieved, unlock_time, global_pct, updated_at)
+			VALUES (?, 105600, ?, ?, '', '', '', 0, ?, 0, 0, ?)`, steamID, name, name, achieved, time.Now().Unix()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func localPcts(t *testing.T, s *Server) (map[string]*float64, string) {
+	t.Helper()
+	items := []Achievement{{APIName: "A"}, {APIName: "B"}, {APIName: "C"}, {APIName: "D"}}
+	w := httptest.NewRecorder()
+	if err := s.applyLocalPct(w, 105600, items); err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]*float64)
+	for _, a := range items {
+		out[a.APIName] = a.LocalPct
+	}
+	return out, w.Header().Get("X-Local-Sample-Size")
+}
+
+func TestLocalPctOverlappingPlayers(t *testing.T) {
+	s := newTestServer(t)
+	// A: everyone, B: half, C: the first one, D: nobody.
+	registry := [][]string{{"A", "B", "C"}, {"A", "B"}, {"A", "B"}, {"A"}}
+	for i, unlocked := range registry {
+		registerUnlocks(t, s, fmt.Sprintf("7656119796028793%d", i), unlocked...)
+	}
+	got, sample := localPcts(t, s)
+	if sample != "4" || got["A"] != nil {
+		t.Fatalf("below the minimum: sample %s, A %v; want 4 and no localPct", sample, got["A"])
+	}
+
+	registerUnlocks(t, s, "76561197960287934", "A", "C")
+	registerUnlocks(t, s, "76561197960287935")
+	// Cached until a sync invalidates.
+	if _, sample := localPcts(t, s); sample != "4" {
+		t.Fatalf("sample %s before the invalidation, want the cached 4", sample)
+	}
+	s.localStats.invalidate()
+	got, sample = localPcts(t, s)
+	if sample != "6" {
+		t.Fatalf("sample %s, want 6", sample)
+	}
+	want := map[string]float64{"A": 500.0 / 6, "B": 50, "C": 100.0 / 3, "D": 0}
+	for name, pct := range want {
+		if got[name] == nil || *got[name] != pct {
+			t.Errorf("localPct %s = %v, want %v", name, got[name], pct)
+		}
+	}
+}
+
+// Stats read before an invalidation are served once but not kept.
+func TestLocalStatsNotKeptAcrossInvalidation(t *testing.T) {
+	c := newLocalStatsCache(defaultLocalPctMinPlayers)
+	_, gen, ok := c.lookup(105600)
+	if ok {
+		t.Fatal("empty cache has stats")
+	}
+	c.invalidate() // a player sync lands while the rows are read
+	if c.store(105600, gen, localStats{sample: 3}) {
+		t.Fatal("stats from before the invalidation kept")
+	}
+	if _, _, ok := c.lookup(105600); ok {
+		t.Fatal("stale stats served from the cache")
+	}
+
+	_, gen, _ = c.lookup(105600)
+	if !c.store(105600, gen, localStats{sample: 4}) {
+		t.Fatal("current stats not kept")
+	}
+	if st, _, ok := c.lookup(105600); !ok || st.sample != 4 {
+		t.Errorf("kept stats = %+v, %t", st, ok)
+	}
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "modernc.org/sqlite"
@@ -13,46 +20,298 @@ import (
 
 func main() {
 	_ = godotenv.Load() // charge .env si present
+	if err := loadConfigFile(configFileArg(os.Args[1:])); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import-history":
+			os.Exit(runImportHistory(os.Args[2:]))
+		case "smoke":
+			os.Exit(runSmoke(os.Args[2:]))
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		case "diff-export":
+			os.Exit(runDiffExport(os.Args[2:]))
+		case "watch":
+			os.Exit(runWatch(os.Args[2:]))
+		case "fetch":
+			os.Exit(runFetch(os.Args[2:]))
+		}
+	}
+
+	flag.BoolFunc("dev", "development mode: assets and templates from disk, no caching, debug logs (DEV_MODE)", func(v string) error {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		if on {
+			return os.Setenv("DEV_MODE", "1")
+		}
+		return os.Setenv("DEV_MODE", "0")
+	})
+	registerConfigFlags(flag.CommandLine)
+	flag.Parse()
+	if err := validateSettings(); err != nil {
+		log.Fatalf("config:\n%v", err)
+	}
+	devMode = getenv("DEV_MODE", "") == "1"
+	devTemplatesDir = getenv("DEV_TEMPLATES_DIR", defaultDevTemplatesDir)
 
 	port := getenv("PORT", "8080")
 	dbPath := getenv("DB_PATH", "steam_achievements.db")
+	responseBufferLimit = getenvInt("RESPONSE_BUFFER_LIMIT", defaultResponseBufferLimit)
+	upstreamTrace = upstreamTraceFromEnv(getenv("DATA_DIR", "data"))
+	usage = usageStatsFromEnv()
+	upstreamHedge.delay = getenvDuration("UPSTREAM_HEDGE_DELAY", 0)
+	upstreamRetry = upstreamRetryFromEnv()
+	steamLimiter = newTokenBucket(float64(getenvInt("UPSTREAM_RATE", defaultUpstreamRate)), getenvInt("UPSTREAM_BURST", defaultUpstreamBurst))
+	requestLimits = inputLimitsFromEnv()
+	upstreamHealth = newUpstreamMonitor(
+		getenvDuration("ALERT_FAILURE_THRESHOLD", defaultAlertFailureThreshold),
+		newAlertNotifier(cleanEnvValue(os.Getenv("ALERT_WEBHOOK_URL")), getenvDuration("ALERT_MIN_INTERVAL", defaultAlertMinInterval)),
+	)
+	var err error
+	if pseudonyms, err = pseudonymizerFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if steamHosts, steamHTTPClient.Transport, err = steamHostSelectorFromEnv(steamHTTPClient.Transport); err != nil {
+		log.Fatal(err)
+	}
+	if upstreamUsage, err = upstreamLedgerFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	breaker = upstreamBreakerFromEnv(upstreamHealth.alerts)
+	if steamHTTPClient.Transport, err = devHTTPCacheFromEnv(steamHTTPClient.Transport); err != nil {
+		log.Fatal(err)
+	}
 	apiKey := cleanEnvValue(os.Getenv("STEAM_API_KEY"))
 	if apiKey == "" {
 		log.Fatal("STEAM_API_KEY manquant (mets-le dans .env)")
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := openDB(dbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	// SQLite is file-based; one shared connection avoids writer lock contention.
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
 	defer db.Close()
 
-	s := &Server{
-		db:              db,
-		apiKey:          apiKey,
-		appSchemaCache:  make(map[int]appSchemaCacheEntry),
-		appGlobalPctMap: make(map[int]appGlobalPctCacheEntry),
+	s := newServer(db, apiKey)
+	rateLimits = newClientRateLimiter(s.cfg, getenv("TRUST_PROXY", "") == "1", s.isAdminRequest)
+	if s.lang, err = langFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if s.login, err = steamLoginFromEnv(); err != nil {
+		log.Fatal(err)
 	}
-
 	if err := s.initDB(); err != nil {
 		log.Fatal(err)
 	}
+	if err := validateCacheCodec(s.cacheCodec); err != nil {
+		log.Fatal(err)
+	}
+	if err := s.loadCacheSnapshot(); err != nil {
+		log.Printf("cache snapshot ignored (%s): %v", s.cacheFile, err)
+	}
+	s.loadProtectiveState(time.Now())
+
+	if err := s.loadDataFiles(); err != nil {
+		log.Fatal(err)
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/achievements", s.handleAchievements)
-	mux.HandleFunc("/api/users/suggestions", s.handleUserSuggestions)
-	mux.HandleFunc("/api/users/profile", s.handleUserProfile)
-	mux.HandleFunc("/api/users/games", s.handleUserGames)
-	mux.HandleFunc("/api/users/achievements", s.handleUserAchievements)
+	apiVersion := getenv("API_DEFAULT_VERSION", apiV1)
+	routes := s.apiRoutes()
+	if err := registerAPIRoutes(mux, routes, apiVersion); err != nil {
+		log.Fatalf("routes: %v", err)
+	}
+	s.registerAPIIndex(mux, routes, apiVersion)
+
+	static, err := frontEndHandler()
+	if err != nil {
+		log.Fatalf("static assets: %v", err)
+	}
+	var handler http.Handler = withHTTPCaching(s.withCORS(requestLimits.wrap(s.withCacheIsolation(withDefaultAPIVersion(apiVersion, mux)))))
+	if devMode {
+		handler = withDevCaching(handler)
+		s.logDevConfig(port, dbPath)
+	}
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /auth/steam/login", s.handleSteamLogin)
+	mux.HandleFunc("GET "+callbackPath, s.handleSteamCallback)
+	mux.HandleFunc("POST /auth/logout", s.handleLogout)
+	mux.Handle("/", static)
 
-	mux.Handle("/", http.FileServer(http.Dir("./static")))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go s.scheduler.run(withUpstreamFeature(ctx, featurePlayerFetch))
+	go newSchemaWatcher(s, schemaWatchIntervalFromEnv()).run(withUpstreamFeature(ctx, featureProbe))
+	go usage.run(ctx, s.writes, s.readOnly)
+	go s.refresher.run(withUpstreamFeature(ctx, featurePrefetch))
+	go s.webhooks.run(ctx)
+	go s.history.run(ctx, s.readOnly)
+	go s.compactor.run(ctx, s.readOnly)
 
-	addr := ":" + port
-	log.Printf("Listening on %s (db=%s)", addr, dbPath)
-	log.Fatal(http.ListenAndServe(addr, withCORS(mux)))
+	srv := &http.Server{
+		Addr:           ":" + port,
+		Handler:        inflight.wrap(withAccessLog(getenv("ACCESS_LOG", "1") != "0", handler)),
+		MaxHeaderBytes: requestLimits.MaxHeaderBytes,
+	}
+	setServerTimeouts(srv)
+	drained := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		drainServer(srv, inflight, getenvDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout))
+		close(drained)
+	}()
+
+	log.Printf("Listening on %s (db=%s)", srv.Addr, dbPath)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// ListenAndServe returns as soon as shutdown starts; wait for the
+	// requests still running before closing what they use.
+	<-drained
+	if s.readOnly() {
+		log.Printf("read-only mode: usage counters not flushed")
+	} else {
+		usage.flush(s.writes, usageDay(time.Now()))
+		if s.cacheDirty.Load() {
+			if err := s.saveCacheSnapshot(); err != nil {
+				log.Printf("cache snapshot save: %v", err)
+			}
+		}
+		if err := s.saveProtectiveState(); err != nil {
+			log.Printf("protective state save: %v", err)
+		}
+		if err := s.history.flush(); err != nil {
+			log.Printf("history snapshots lost at shutdown (%d pending): %v", s.history.stats().Pending, err)
+		}
+	}
+	s.writes.close()
+	log.Printf("write queue flushed, bye")
+}
+
+// The helpers below are the startup steps shared with the check command.
+
+func validateCacheCodec(codec string) error {
+	if codec != cacheCodecJSON && codec != cacheCodecBinary {
+		return fmt.Errorf("CACHE_CODEC must be %q or %q", cacheCodecJSON, cacheCodecBinary)
+	}
+	return nil
+}
+
+func (s *Server) loadDataFiles() error {
+	progression, err := loadProgressionMap(getenv("TERRARIA_PROGRESSION_FILE", defaultProgressionFile))
+	if err != nil {
+		return fmt.Errorf("terraria progression: %w", err)
+	}
+	overlay, err := loadAchievementOverlay(getenv("ACHIEVEMENT_OVERLAY_FILE", defaultOverlayFile))
+	if err != nil {
+		return fmt.Errorf("achievement overlay: %w", err)
+	}
+	estimates, err := loadOwnerEstimates(getenv("OWNER_ESTIMATES_FILE", defaultOwnerEstimatesFile))
+	if err != nil {
+		return fmt.Errorf("owner estimates: %w", err)
+	}
+	games, err := loadGameRegistry(getenv("GAMES_FILE", defaultGamesFile), getenv("MANUAL_SOURCES_DIR", defaultManualSources), s.apiKey)
+	if err != nil {
+		return fmt.Errorf("games: %w", err)
+	}
+	allowed, err := parseAllowedAppIDs(os.Getenv("ALLOWED_APPIDS"))
+	if err != nil {
+		return err
+	}
+	hotlinks, err := parseHotlinkOrigins(os.Getenv("HOTLINK_ALLOWED_ORIGINS"))
+	if err != nil {
+		return err
+	}
+	policies, err := loadPolicyStore(getenv("POLICIES_DIR", defaultPoliciesDir))
+	if err != nil {
+		return fmt.Errorf("visibility policies: %w", err)
+	}
+	webhooks, err := loadWebhookEndpoints(getenv("WEBHOOKS_FILE", defaultWebhooksFile))
+	if err != nil {
+		return fmt.Errorf("webhooks: %w", err)
+	}
+	s.progression = progression
+	s.overlay = overlay
+	s.ownerEstimates = estimates
+	s.games = games
+	s.allowedApps = allowed
+	s.hotlinks = hotlinks
+	s.policies = policies
+	s.webhooks = newWebhookDispatcher(webhooks)
+	return nil
+}
+
+// frontEndHandler serves the front end: from disk in dev mode, otherwise
+// as staticHandlerFromEnv says.
+func frontEndHandler() (http.Handler, error) {
+	if devMode {
+		return devStaticHandler(os.DirFS(staticDir())), nil
+	}
+	return staticHandlerFromEnv()
+}
+
+func staticHandlerFromEnv() (*staticHandler, error) {
+	return newStaticHandler(staticFS(), getenv("STATIC_HASHED_PATTERN", defaultHashedAssetPattern), getenv("STATIC_MANIFEST", ""))
+}
+
+func newServer(db *sql.DB, apiKey string) *Server {
+	s := &Server{
+		db:               db,
+		apiKey:           apiKey,
+		appSchemaCache:   make(map[CacheKey]appSchemaCacheEntry),
+		appGlobalPctMap:  make(map[CacheKey]appGlobalPctCacheEntry),
+		appStatusCache:   make(map[CacheKey]appStatusCacheEntry),
+		adminToken:       cleanEnvValue(os.Getenv("ADMIN_TOKEN")),
+		startupConfig:    runtimeConfigFromEnv(),
+		testMode:         getenv("TEST_MODE", "") == "1",
+		isolated:         newIsolatedStore(),
+		cacheFile:        cacheFileFromEnv(),
+		stateFile:        stateFileFromEnv(),
+		stateMaxAge:      getenvDuration("STATE_MAX_AGE", defaultStateMaxAge),
+		cacheCodec:       strings.ToLower(getenv("CACHE_CODEC", cacheCodecJSON)),
+		snapshotDelay:    getenvDuration("CACHE_SNAPSHOT_DELAY", defaultCacheSnapshotDelay),
+		events:           newEventHub(),
+		localStats:       newLocalStatsCache(getenvInt("LOCAL_PCT_MIN_PLAYERS", defaultLocalPctMinPlayers)),
+		cdn:              cdnPurgerFromEnv(),
+		writes:           newWriteQueue(db, getenvInt("WRITE_QUEUE_SIZE", defaultWriteQueueSize)),
+		history:          newHistoryBuffer(db, getenvInt("HISTORY_BUFFER_SIZE", defaultHistoryBufferSize)),
+		compactor:        historyCompactorFromEnv(db),
+		jsonpEnabled:     getenv("ENABLE_JSONP", "") == "1",
+		icons:            iconProxyFromEnv(),
+		encodedResponses: newEncodedResponseCache(),
+		derived:          newDerivedResponseCache(getenvInt("DERIVED_CACHE_SIZE", defaultDerivedCacheSize)),
+		fetches:          newFetchGroup(),
+		verifies:         newVerifyLimiter(getenvDuration("VERIFY_INTERVAL", defaultVerifyInterval)),
+	}
+	s.runtime.Store(s.startupConfig)
+	shapeChanges.tick()
+	s.scheduler = newPlayerScheduler(s, playerRefreshPerHourFromEnv(), refreshQueueMaxFromEnv())
+	s.ready = newReadiness(s)
+	s.translationReference = translationReferenceFromEnv()
+	s.translationGrace = getenvDuration("TRANSLATION_GRACE", defaultTranslationGrace)
+	s.playerAchievements = newPlayerAchievementsCache(getenvDuration("PLAYER_ACHIEVEMENTS_TTL", defaultPlayerAchievementsTTL))
+	s.refresher = newCacheRefresher(s, getenvDuration("CACHE_REFRESH_INTERVAL", defaultCacheRefreshInterval))
+	s.lang = defaultLang
+	s.compareMaxPlayers = getenvInt("COMPARE_MAX_PLAYERS", defaultCompareMaxPlayers)
+	return s
+}
+
+func openDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite is file-based; one shared connection avoids writer lock contention.
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	return db, nil
 }
 
 func getenv(k, def string) string {
@@ -62,6 +321,32 @@ func getenv(k, def string) string {
 	return def
 }
 
+func getenvInt(k string, def int) int {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %d", k, v, def)
+		return def
+	}
+	return n
+}
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s=%q, using default %s", k, v, def)
+		return def
+	}
+	return d
+}
+
 func cleanEnvValue(v string) string {
 	v = strings.TrimSpace(v)
 	v = strings.Trim(v, "\"'")
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// GET /metrics exposes counters in the Prometheus text format for the
+// reverse proxy and its monitoring: API requests and their latency per
+// route, responses per status class, cache hits and misses, Steam calls and
+// failures, and the last successful refresh. Counters start at zero with
+// the process. The latency of /events is how long the stream stayed open.
+//
+// Every request is also logged (method, path, status, duration) unless
+// ACCESS_LOG=0; probes and /metrics are left out of the log.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Cache kinds of the hit/miss counters.
+const (
+	cacheMetricGlobal = iota // default app list, database refreshed every CACHE_TTL
+	cacheMetricSchema
+	cacheMetricPct
+	cacheMetricBootstrap // derived responses, see derived_cache.go
+	cacheMetricTags
+)
+
+var cacheMetricNames = [...]string{"global", "schema", "pct", "bootstrap", "tags"}
+
+// latencyBuckets are the upper bounds of the request latency histogram, in
+// seconds.
+var latencyBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type latencyHistogram struct {
+	buckets [len(latencyBuckets)]atomic.Int64 // not cumulative
+	count   atomic.Int64
+	sumUs   atomic.Int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	secs := d.Seconds()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.buckets[i].Add(1)
+			break
+		}
+	}
+	h.count.Add(1)
+	h.sumUs.Add(d.Microseconds())
+}
+
+// write loads the count first: observe bumps a bucket before the count, so
+// a concurrent observation may show in the buckets only, and clamping them
+// to the count keeps the series cumulative up to +Inf.
+func (h *latencyHistogram) write(w io.Writer, name, labels string) {
+	count := h.count.Load()
+	var cum int64
+	for i, le := range latencyBuckets {
+		cum = min(cum+h.buckets[i].Load(), count)
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(le, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, float64(h.sumUs.Load())/1e6)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, count)
+}
+
+type serverMetrics struct {
+	// routes is filled by wrap before the server starts, then only read.
+	routes     map[string]*atomic.Int64
+	latencies  map[string]*latencyHistogram
+	routeNames []string
+
+	statusClasses [6]atomic.Int64 // index: status / 100
+	cacheHits     [len(cacheMetricNames)]atomic.Int64
+	cacheMisses   [len(cacheMetricNames)]atomic.Int64
+
+	upstreamRequests    atomic.Int64
+	upstreamErrors      atomic.Int64
+	lastUpstreamSuccess atomic.Int64 // unix seconds
+
+	hotlinkBlocked   atomic.Int64
+	iconFetches      atomic.Int64
+	iconPlaceholders atomic.Int64
+}
+
+var metrics = newServerMetrics()
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{routes: make(map[string]*atomic.Int64), latencies: make(map[string]*latencyHistogram)}
+}
+
+// wrap counts and times the calls of next under name. Must be called
+// before the server starts.
+func (m *serverMetrics) wrap(name string, next http.Handler) http.Handler {
+	c, ok := m.routes[name]
+	if !ok {
+		c = &atomic.Int64{}
+		m.routes[name] = c
+		m.latencies[name] = &latencyHistogram{}
+		m.routeNames = append(m.routeNames, name)
+		sort.Strings(m.routeNames)
+	}
+	h := m.latencies[name]
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Add(1)
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		h.observe(time.Since(start))
+	})
+}
+
+func (m *serverMetrics) cacheLookup(kind int, hit bool) {
+	if hit {
+		m.cacheHits[kind].Add(1)
+	} else {
+		m.cacheMisses[kind].Add(1)
+	}
+}
+
+// upstreamCall records one Steam attempt made by httpGET.
+func (m *serverMetrics) upstreamCall(failed bool) {
+	m.upstreamRequests.Add(1)
+	if failed {
+		m.upstreamErrors.Add(1)
+		return
+	}
+	m.lastUpstreamSuccess.Store(time.Now().Unix())
+}
+
+func (m *serverMetrics) response(status int) {
+	if class := status / 100; class > 0 && class < len(m.statusClasses) {
+		m.statusClasses[class].Add(1)
+	}
+}
+
+func promLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func writePromHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := metrics
+	var b strings.Builder
+
+	writePromHeader(&b, "yboost_http_requests_total", "counter", "API requests by route.")
+	for _, name := range m.routeNames {
+		fmt.Fprintf(&b, "yboost_http_requests_total{route=\"%s\"} %d\n", promLabel(name), m.routes[name].Load())
+	}
+	writePromHeader(&b, "yboost_http_request_duration_seconds", "histogram", "API request latency by route, until the handler returns.")
+	for _, name := range m.routeNames {
+		m.latencies[name].write(&b, "yboost_http_request_duration_seconds", "route=\""+promLabel(name)+"\"")
+	}
+	writePromHeader(&b, "yboost_http_responses_total", "counter", "Responses by status class.")
+	for class := 1; class < len(m.statusClasses); class++ {
+		fmt.Fprintf(&b, "yboost_http_responses_total{code=\"%dxx\"} %d\n", class, m.statusClasses[class].Load())
+	}
+	writePromHeader(&b, "yboost_http_inflight_requests", "gauge", "Requests being served.")
+	fmt.Fprintf(&b, "yboost_http_inflight_requests %d\n", inflight.stats().Total)
+
+	writePromHeader(&b, "yboost_cache_hits_total", "counter", "Cache lookups answered from the cache.")
+	for i, name := range cacheMetricNames {
+		fmt.Fprintf(&b, "yboost_cache_hits_total{cache=\"%s\"} %d\n", name, m.cacheHits[i].Load())
+	}
+	writePromHeader(&b, "yboost_cache_misses_total", "counter", "Cache lookups that needed a fetch.")
+	for i, name := range cacheMetricNames {
+		fmt.Fprintf(&b, "yboost_cache_misses_total{cache=\"%s\"} %d\n", name, m.cacheMisses[i].Load())
+	}
+	entries, evicted := s.derived.stats()
+	writePromHeader(&b, "yboost_derived_cache_entries", "gauge", "Aggregate responses kept, outdated ones until looked up or evicted.")
+	fmt.Fprintf(&b, "yboost_derived_cache_entries %d\n", entries)
+	writePromHeader(&b, "yboost_derived_cache_evictions_total", "counter", "Aggregate responses evicted to stay under DERIVED_CACHE_SIZE.")
+	fmt.Fprintf(&b, "yboost_derived_cache_evictions_total %d\n", evicted)
+
+	writePromHeader(&b, "yboost_upstream_requests_total", "counter", "Steam calls, retries included.")
+	fmt.Fprintf(&b, "yboost_upstream_requests_total %d\n", m.upstreamRequests.Load())
+	writePromHeader(&b, "yboost_upstream_errors_total", "counter", "Steam calls that failed or answered non-2xx.")
+	fmt.Fprintf(&b, "yboost_upstream_errors_total %d\n", m.upstreamErrors.Load())
+	usageStats := upstreamUsage.snapshot(time.Now())
+	writePromHeader(&b, "yboost_upstream_feature_requests_total", "counter", "Steam calls by originating feature, retries and hedges included.")
+	for _, f := range upstreamFeatures {
+		fmt.Fprintf(&b, "yboost_upstream_feature_requests_total{feature=\"%s\"} %d\n", f, usageStats.Features[f].Requests)
+	}
+	writePromHeader(&b, "yboost_upstream_feature_errors_total", "counter", "Steam calls by originating feature that failed or answered non-2xx.")
+	for _, f := range upstreamFeatures {
+		fmt.Fprintf(&b, "yboost_upstream_feature_errors_total{feature=\"%s\"} %d\n", f, usageStats.Features[f].Errors)
+	}
+	writePromHeader(&b, "yboost_upstream_feature_refused_total", "counter", "Steam calls not sent because UPSTREAM_DAILY_BUDGET or the feature quota was spent.")
+	for _, f := range upstreamFeatures {
+		fmt.Fprintf(&b, "yboost_upstream_feature_refused_total{feature=\"%s\"} %d\n", f, usageStats.Features[f].Refused)
+	}
+	writePromHeader(&b, "yboost_upstream_feature_requests_today", "gauge", "Steam calls by originating feature since 00:00 UTC.")
+	for _, f := range upstreamFeatures {
+		fmt.Fprintf(&b, "yboost_upstream_feature_requests_today{feature=\"%s\"} %d\n", f, usageStats.Features[f].Today)
+	}
+	writePromHeader(&b, "yboost_upstream_retries_total", "counter", "Steam attempts after the first one of a call.")
+	fmt.Fprintf(&b, "yboost_upstream_retries_total %d\n", upstreamRetry.retried.Load())
+	br := breaker.snapshot()
+	open := 0
+	if br.Open {
+		open = 1
+	}
+	writePromHeader(&b, "yboost_upstream_breaker_open", "gauge", "1 while the circuit breaker refuses Steam calls.")
+	fmt.Fprintf(&b, "yboost_upstream_breaker_open %d\n", open)
+	writePromHeader(&b, "yboost_upstream_breaker_trips_total", "counter", "Times the circuit breaker opened.")
+	fmt.Fprintf(&b, "yboost_upstream_breaker_trips_total %d\n", br.Trips)
+	writePromHeader(&b, "yboost_upstream_breaker_refused_total", "counter", "Steam calls refused by the open circuit breaker.")
+	fmt.Fprintf(&b, "yboost_upstream_breaker_refused_total %d\n", br.Refused)
+	if hosts := steamHosts.snapshot(); len(hosts) > 0 {
+		writePromHeader(&b, "yboost_steam_host_score", "gauge", "Health score of each STEAM_API_HOSTS entry, lower is preferred.")
+		for _, h := range hosts {
+			fmt.Fprintf(&b, "yboost_steam_host_score{host=%q} %g\n", h.Host, h.Score)
+		}
+		writePromHeader(&b, "yboost_steam_host_requests_total", "counter", "Steam calls sent to each STEAM_API_HOSTS entry.")
+		for _, h := range hosts {
+			fmt.Fprintf(&b, "yboost_steam_host_requests_total{host=%q} %d\n", h.Host, h.Requests)
+		}
+		writePromHeader(&b, "yboost_steam_host_failures_total", "counter", "Steam calls to each STEAM_API_HOSTS entry that failed, answered 429 or 5xx.")
+		for _, h := range hosts {
+			fmt.Fprintf(&b, "yboost_steam_host_failures_total{host=%q} %d\n", h.Host, h.Failed)
+		}
+	}
+	writePromHeader(&b, "yboost_upstream_last_success_timestamp_seconds", "gauge", "Last successful Steam call, 0 if none since start.")
+	fmt.Fprintf(&b, "yboost_upstream_last_success_timestamp_seconds %d\n", m.lastUpstreamSuccess.Load())
+
+	writePromHeader(&b, "yboost_icon_fetches_total", "counter", "Icon downloads from the Steam CDN.")
+	fmt.Fprintf(&b, "yboost_icon_fetches_total %d\n", m.iconFetches.Load())
+	writePromHeader(&b, "yboost_icon_placeholders_total", "counter", "Placeholders served while icon downloads were saturated.")
+	fmt.Fprintf(&b, "yboost_icon_placeholders_total %d\n", m.iconPlaceholders.Load())
+	writePromHeader(&b, "yboost_hotlink_blocked_total", "counter", "Icon and export requests refused by HOTLINK_ALLOWED_ORIGINS.")
+	fmt.Fprintf(&b, "yboost_hotlink_blocked_total %d\n", m.hotlinkBlocked.Load())
+	writePromHeader(&b, "yboost_rate_limited_total", "counter", "API requests refused by the per-client rate limit.")
+	fmt.Fprintf(&b, "yboost_rate_limited_total %d\n", rateLimits.snapshot().Limited)
+
+	var synced int64
+	if last, err := s.lastSyncAt(context.Background()); err == nil && !last.IsZero() {
+		synced = last.Unix()
+	}
+	writePromHeader(&b, "yboost_last_refresh_timestamp_seconds", "gauge", "Last successful refresh of the default app, 0 if never.")
+	fmt.Fprintf(&b, "yboost_last_refresh_timestamp_seconds %d\n", synced)
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	io.WriteString(w, b.String())
+}
+
+// withAccessLog counts responses by status class and logs each request
+// when enabled.
+func withAccessLog(enabled bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{w: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		metrics.response(sw.status)
+		if enabled && routeClass(r.URL.Path) != classProbe {
+			log.Printf("%s %s %d %dB %s", r.Method, r.URL.Path, sw.status, sw.bytes, time.Since(start).Round(time.Microsecond))
+		}
+	})
+}
+
+type statusWriter struct {
+	w           http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (sw *statusWriter) Header() http.Header { return sw.w.Header() }
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status, sw.wroteHeader = status, true
+	}
+	sw.w.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	sw.wroteHeader = true
+	n, err := sw.w.Write(p)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter { return sw.w }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The buckets are cumulative up to +Inf, which equals the count, and the
+// sum is in seconds.
+func TestLatencyHistogramWrite(t *testing.T) {
+	h := &latencyHistogram{}
+	for _, d := range []time.Duration{3 * time.Millisecond, 5 * time.Millisecond, 7 * time.Millisecond, 300 * time.Millisecond, 20 * time.Second} {
+		h.observe(d)
+	}
+	var b strings.Builder
+	h.write(&b, "x", `route="r"`)
+	want := `x_bucket{route="r",le="0.005"} 2
+x_bucket{route="r",le="0.01"} 3
+x_bucket{route="r",le="0.025"} 3
+x_bucket{route="r",le="0.05"} 3
+x_bucket{route="r",le="0.1"} 3
+x_bucket{route="r",le="0.25"} 3
+x_bucket{route="r",le="0.5"} 4
+x_bucket{route="r",le="1"} 4
+x_bucket{route="r",le="2.5"} 4
+x_bucket{route="r",le="5"} 4
+x_bucket{route="r",le="10"} 4
+x_bucket{route="r",le="+Inf"} 5
+x_sum{route="r"} 20.315
+x_count{route="r"} 5
+`
+	if b.String() != want {
+		t.Errorf("histogram:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	// An observation caught between its bucket and its count: no finite
+	// bucket may go past +Inf.
+	h = &latencyHistogram{}
+	h.observe(time.Millisecond)
+	h.buckets[0].Add(1)
+	b.Reset()
+	h.write(&b, "x", `route="r"`)
+	prev := int64(0)
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if !strings.HasPrefix(line, "x_bucket") {
+			continue
+		}
+		v, err := strconv.ParseInt(line[strings.LastIndex(line, " ")+1:], 10, 64)
+		if err != nil || v < prev || v > 1 {
+			t.Errorf("%s: not cumulative up to the count of 1", line)
+		}
+		prev = v
+	}
+	if prev != 1 {
+		t.Errorf("+Inf bucket %d, want the count of 1", prev)
+	}
+}
@@ -2,77 +2,167 @@ package main
 
 import (
 	"database/sql"
-	"errors"
+	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const defaultGlobalAppID = 105600 // Steam app ID (Terraria), used by /api/achievements legacy endpoint.
+const defaultGlobalAppID AppID = 105600 // Steam app ID (Terraria), used by /api/achievements legacy endpoint.
 const cacheTTL = 6 * time.Hour
 const appMetaCacheTTL = 24 * time.Hour
 
+// Per-game status in the games list, so the frontend can grey out games
+// that would only show an empty achievements page.
+const (
+	gameStatusOK             = "ok"
+	gameStatusNoAchievements = "no_achievements"
+	gameStatusDelisted       = "delisted"
+	gameStatusError          = "error"
+)
+
 type appSchemaCacheEntry struct {
-	items     []Achievement
+	items      []Achievement
+	fetchedAt  time.Time
+	generation uint64
+	// index is the list merged with the percentages, see achievement_index.go.
+	index *achievementIndex
+}
+
+type appStatusCacheEntry struct {
+	status    string
 	fetchedAt time.Time
 }
 
 type appGlobalPctCacheEntry struct {
-	items     map[string]float64
-	fetchedAt time.Time
+	items      map[string]float64
+	fetchedAt  time.Time
+	generation uint64
 }
 
 type Achievement struct {
-	APIName     string  `json:"apiName"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Icon        string  `json:"icon"`
-	IconGray    string  `json:"iconGray"`
-	Hidden      bool    `json:"hidden"`
-	GlobalPct   float64 `json:"globalPct"`
-	Achieved    bool    `json:"achieved,omitempty"`
-	UnlockTime  int64   `json:"unlockTime,omitempty"`
+	APIName     string   `json:"apiName"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Icon        string   `json:"icon"`
+	IconGray    string   `json:"iconGray"`
+	Hidden      bool     `json:"hidden"`
+	GlobalPct   float64  `json:"globalPct"`
+	LocalPct    *float64 `json:"localPct"`
+	Achieved    bool     `json:"achieved,omitempty"`
+	UnlockTime  apiTime  `json:"unlockTime"`
+	Tags        []string `json:"tags,omitempty"`
+	// Computed on output, never stored.
+	IconAlt                     string `json:"iconAlt"`
+	Rarity                      string `json:"rarity"`
+	DescriptionHidden           bool   `json:"descriptionHidden,omitempty"`
+	PossiblyOutdatedTranslation bool   `json:"possiblyOutdatedTranslation,omitempty"`
+	Redacted                    bool   `json:"redacted,omitempty"`
+	// Color is the dominant color of the icon with PROXY_ICONS, "#rrggbb"
+	// or null until computed; absent without the proxy.
+	Color json.RawMessage `json:"color,omitempty"`
 }
 
 type OwnedGame struct {
-	AppID           int    `json:"appId"`
+	AppID           AppID  `json:"appId"`
 	Name            string `json:"name"`
 	PlaytimeForever int    `json:"playtimeForever"`
 }
 
 type GameCompletion struct {
-	AppID                int     `json:"appId"`
+	AppID                AppID   `json:"appId"`
 	Name                 string  `json:"name"`
 	PlaytimeForever      int     `json:"playtimeForever"`
 	TotalAchievements    int     `json:"totalAchievements"`
 	UnlockedAchievements int     `json:"unlockedAchievements"`
 	CompletionPct        float64 `json:"completionPct"`
+	Status               string  `json:"status"`
 }
 
 type UserSuggestion struct {
-	SteamID       string  `json:"steamId"`
+	SteamID       SteamID `json:"steamId"`
 	DisplayName   string  `json:"displayName"`
 	GamesCount    int     `json:"gamesCount"`
 	AvgCompletion float64 `json:"avgCompletion"`
 }
 
 type UserProfile struct {
-	SteamID     string `json:"steamId"`
-	DisplayName string `json:"displayName"`
-	AvatarURL   string `json:"avatarUrl"`
+	SteamID     SteamID `json:"steamId"`
+	DisplayName string  `json:"displayName"`
+	AvatarURL   string  `json:"avatarUrl"`
 }
 
 type Server struct {
 	db              *sql.DB
 	apiKey          string
 	cacheMu         sync.RWMutex
-	appSchemaCache  map[int]appSchemaCacheEntry
-	appGlobalPctMap map[int]appGlobalPctCacheEntry
+	appSchemaCache  map[CacheKey]appSchemaCacheEntry
+	appGlobalPctMap map[CacheKey]appGlobalPctCacheEntry
+	appStatusCache  map[CacheKey]appStatusCacheEntry
+	progression     *progressionMap
+	adminToken      string
+	runtime         atomic.Pointer[runtimeConfig]
+	startupConfig   *runtimeConfig
+	testMode        bool
+	isolated        *isolatedStore // writes of TEST_MODE isolated requests
+	cacheFile       string
+	cacheCodec      string
+	// stateFile keeps the protective state across restarts, see
+	// protective_state.go.
+	stateFile      string
+	stateMaxAge    time.Duration
+	events         *eventHub
+	scheduler      *playerScheduler
+	overlay        *achievementOverlay
+	localStats     *localStatsCache
+	globalIndex    atomic.Pointer[achievementIndex]
+	generation     atomic.Uint64
+	scopeGens      sync.Map // generation scope -> *atomic.Uint64, see cache_generation.go
+	cdn            *cdnPurger
+	writes         *writeQueue
+	history        *historyBuffer
+	compactor      *historyCompactor
+	jsonpEnabled   bool
+	ready          *readiness
+	ownerEstimates map[AppID]ownerEstimate
+	games          *gameRegistry
+	allowedApps    map[AppID]bool // nil: every app
+	hotlinks       *hotlinkGuard  // nil: no hotlink check
+	policies       *policyStore
+	// translationReference is the language whose changes flag the others
+	// as possibly outdated; "" disables the check.
+	translationReference string
+	translationGrace     time.Duration
+	playerAchievements   *playerAchievementsCache
+	refresher            *cacheRefresher
+	compareMaxPlayers    int
+	login                *steamLogin
+	// lang answers /achievements without ?lang=, see languages.go.
+	lang             string
+	icons            *iconProxy // nil without PROXY_ICONS
+	encodedResponses *encodedResponseCache
+	derived          *derivedResponseCache
+	webhooks         *webhookDispatcher
+	verifies         *verifyLimiter
+	fetches          *fetchGroup
+	// cacheDirty is set when the shared app caches changed since the last
+	// snapshot; snapshotMu serializes snapshot writes. snapshotPending is
+	// set while a delayed write is scheduled, see scheduleCacheSnapshot.
+	cacheDirty      atomic.Bool
+	snapshotMu      sync.Mutex
+	snapshotPending atomic.Bool
+	snapshotDelay   time.Duration
+}
+
+type UnlockEvent struct {
+	AppID   AppID  `json:"appId"`
+	APIName string `json:"apiName"`
+	Name    string `json:"name"`
+	// GlobalPct is 0 when the percentages could not be fetched.
+	GlobalPct float64 `json:"globalPct,omitempty"`
 }
 
 type userAchievementState struct {
 	Achieved   bool
-	UnlockTime int64
+	UnlockTime apiTime
 }
-
-var errProfilePrivate = errors.New("steam profile is private or stats unavailable")
-var errInvalidSteamAPIKey = errors.New("invalid steam api key")
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Human-facing outputs (HTML, CSV) format numbers for the reader's locale.
+// JSON never goes through this and always keeps machine-readable dots; the
+// XLSX keeps numeric cells, which the spreadsheet shows in its own locale.
+const (
+	numLocaleFrench  = "fr"
+	numLocaleEnglish = "en"
+)
+
+// narrowNoBreakSpace separates the number and "%" in French typography.
+const narrowNoBreakSpace = "\u202f"
+
+// numberLocaleFor picks the number locale from the Steam language, unless
+// ?numlocale= (override, already normalized) is set.
+func numberLocaleFor(override, lang string) string {
+	if override != "" {
+		return override
+	}
+	if lang == "french" {
+		return numLocaleFrench
+	}
+	return numLocaleEnglish
+}
+
+// formatDecimal renders v with at most two decimals, trailing zeros trimmed.
+func formatDecimal(v float64, locale string) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if locale == numLocaleFrench {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	return s
+}
+
+// formatPercent renders "2,3 %" (narrow no-break space) in French and
+// "2.3%" in English.
+func formatPercent(v float64, locale string) string {
+	if locale == numLocaleFrench {
+		return formatDecimal(v, locale) + narrowNoBreakSpace + "%"
+	}
+	return formatDecimal(v, locale) + "%"
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormatPercent(t *testing.T) {
+	tests := []struct {
+		v      float64
+		locale string
+		want   string
+	}{
+		{2.3, numLocaleFrench, "2,3\u202f%"},
+		{2.3, numLocaleEnglish, "2.3%"},
+		{12.25, numLocaleFrench, "12,25\u202f%"},
+		{12.254, numLocaleEnglish, "12.25%"},
+		{100, numLocaleFrench, "100\u202f%"},
+		{0, numLocaleEnglish, "0%"},
+		{0.1, numLocaleFrench, "0,1\u202f%"},
+		{99.999, numLocaleEnglish, "100%"},
+	}
+	for _, tt := range tests {
+		if got := formatPercent(tt.v, tt.locale); got != tt.want {
+			t.Errorf("formatPercent(%v, %s) = %q, want %q", tt.v, tt.locale, got, tt.want)
+		}
+	}
+	if got := formatDecimal(1234.5, numLocaleFrench); got != "1234,5" {
+		t.Errorf("formatDecimal(1234.5, fr) = %q", got)
+	}
+}
+
+func TestNumberLocaleFor(t *testing.T) {
+	tests := []struct{ query, lang, want string }{
+		{"", "french", numLocaleFrench},
+		{"", "english", numLocaleEnglish},
+		{"", "german", numLocaleEnglish},
+		{"numlocale=en", "french", numLocaleEnglish},
+		{"numlocale=FR-fr", "english", numLocaleFrench},
+	}
+	for _, tt := range tests {
+		q, err := parseAchievementQuery(httptest.NewRequest(http.MethodGet, "/api/v1/achievements?"+tt.query, nil))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if got := numberLocaleFor(q.numLocale, tt.lang); got != tt.want {
+			t.Errorf("%q with lang %s = %s, want %s", tt.query, tt.lang, got, tt.want)
+		}
+	}
+	if _, err := parseAchievementQuery(httptest.NewRequest(http.MethodGet, "/api/v1/achievements?numlocale=de", nil)); err == nil {
+		t.Errorf("numlocale=de accepted")
+	}
+}
+
+func exportFixture() []Achievement {
+	return []Achievement{{APIName: "A", Name: "Facile", GlobalPct: 2.3}, {APIName: "B", Name: "Dur", GlobalPct: 12.25}}
+}
+
+func exportBody(t *testing.T, query, lang string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements?"+query, nil)
+	q, err := parseAchievementQuery(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.lang = lang
+	if q.format, _ = negotiateFormat(httptest.NewRecorder(), r); q.format == formatJSON {
+		t.Fatalf("%q is not an export", query)
+	}
+	w := httptest.NewRecorder()
+	if !q.export(w, 105600, exportFixture()) {
+		t.Fatalf("%q not exported", query)
+	}
+	return w
+}
+
+func TestExportNumbersFollowLocale(t *testing.T) {
+	tests := []struct {
+		query, lang string
+		want        []string
+		not         []string
+	}{
+		{"format=csv", "french", []string{"A;Facile;;false;2,3\r\n", "B;Dur;;false;12,25\r\n"}, []string{"2.3", `"2,3"`}},
+		{"format=csv", "english", []string{"A,Facile,,false,2.3\r\n", "B,Dur,,false,12.25\r\n"}, []string{"2,3"}},
+		{"format=csv&numlocale=en", "french", []string{"A,Facile,,false,2.3\r\n"}, []string{";"}},
+		{"format=html", "french", []string{"<td class=\"pct\">2,3\u202f%</td>", "<td class=\"pct\">12,25\u202f%</td>"}, nil},
+		{"format=html", "english", []string{`<td class="pct">2.3%</td>`}, []string{"2,3"}},
+		{"format=html&numlocale=fr", "english", []string{"<td class=\"pct\">2,3\u202f%</td>"}, nil},
+	}
+	for _, tt := range tests {
+		body := exportBody(t, tt.query, tt.lang).Body.String()
+		for _, s := range tt.want {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s (lang %s): %q missing from\n%s", tt.query, tt.lang, s, body)
+			}
+		}
+		for _, s := range tt.not {
+			if strings.Contains(body, s) {
+				t.Errorf("%s (lang %s): %q found in\n%s", tt.query, tt.lang, s, body)
+			}
+		}
+	}
+}
+
+// Every CSV reads back with the delimiter of its locale into the columns
+// and percentages it was written from.
+func TestExportCSVParsesBack(t *testing.T) {
+	items := append(exportFixture(), Achievement{APIName: "C", Name: "Vite; tres vite", Description: "1,5 fois, sans pause", GlobalPct: 0.5})
+	for _, tt := range []struct {
+		query, lang string
+		comma       rune
+		pcts        []string
+	}{
+		{"format=csv", "french", ';', []string{"2,3", "12,25", "0,5"}},
+		{"format=csv", "english", ',', []string{"2.3", "12.25", "0.5"}},
+		{"format=csv&numlocale=fr", "english", ';', []string{"2,3", "12,25", "0,5"}},
+		{"format=csv&numlocale=en", "french", ',', []string{"2.3", "12.25", "0.5"}},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements?"+tt.query, nil)
+		q, err := parseAchievementQuery(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.lang = tt.lang
+		q.format, _ = negotiateFormat(httptest.NewRecorder(), r)
+		w := httptest.NewRecorder()
+		q.export(w, 105600, items)
+
+		cr := csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), utf8BOM)))
+		cr.Comma = tt.comma
+		rows, err := cr.ReadAll()
+		if err != nil || len(rows) != 1+len(items) {
+			t.Fatalf("%s (lang %s): %d rows, %v\n%s", tt.query, tt.lang, len(rows), err, w.Body)
+		}
+		if strings.Join(rows[0], "|") != "apiName|name|description|hidden|globalPct" {
+			t.Errorf("%s (lang %s): header %q", tt.query, tt.lang, rows[0])
+		}
+		for i, a := range items {
+			row := rows[i+1]
+			if len(row) != 5 || row[0] != a.APIName || row[1] != a.Name || row[2] != a.Description || row[4] != tt.pcts[i] {
+				t.Errorf("%s (lang %s): row %q for %+v", tt.query, tt.lang, row, a)
+			}
+		}
+	}
+}
+
+// The latin-1 HTML keeps the space before "%" as a no-break space.
+func TestExportPercentLatin1(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements?format=html", nil)
+	q, _ := parseAchievementQuery(r)
+	q.format, q.lang, q.charsets = formatHTML, "french", []string{charsetLatin1}
+	w := httptest.NewRecorder()
+	q.export(w, 105600, exportFixture())
+	if !bytes.Contains(w.Body.Bytes(), []byte("2,3\xa0%")) {
+		t.Errorf("latin-1 body has no 2,3\\xa0%%:\n%q", w.Body.String())
+	}
+}
+
+// XLSX cells stay numbers whatever the locale: the spreadsheet formats them.
+func TestExportXLSXPercentCells(t *testing.T) {
+	for _, lang := range []string{"french", "english"} {
+		body := exportBody(t, "format=xlsx", lang).Body.Bytes()
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			if f.Name != "xl/worksheets/sheet1.xml" {
+				continue
+			}
+			rc, _ := f.Open()
+			sheet, _ := io.ReadAll(rc)
+			rc.Close()
+			for _, want := range []string{`<c s="2"><v>2.3</v></c>`, `<c s="2"><v>12.25</v></c>`} {
+				if !bytes.Contains(sheet, []byte(want)) {
+					t.Errorf("lang %s: %s missing from the sheet", lang, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// Achievement lists can be paged with ?limit= and either ?offset= or the
+// opaque ?cursor= from the X-Next-Cursor header of the previous page. A
+// page is wrapped in {total, limit, offset, items} (plus nextCursor);
+// without ?limit= or ?cursor= the whole list is returned as before.
+//
+// A cursor carries the sort spec, a fingerprint of the filters, the data
+// version of the list and the sort key of the last item served, signed
+// with a per-process HMAC key. The data version is the list's own sync
+// time rather than the process-wide cache generation, which also moves on
+// unrelated player syncs. Once the list was refreshed, the pages of the
+// version before are still served from a copy kept for each paged query
+// (the last maxPagedVersions queries); older versions, or a query evicted
+// since, answer 409 with "restart": true, as resuming in a reordered list
+// would skip or repeat items. Cursors do not survive a restart either.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+	maxPagedVersions = 128
+)
+
+var cursorKey = newCursorKey()
+
+func newCursorKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+type pageCursor struct {
+	Sort        string  `json:"s"`
+	Filter      string  `json:"f"`
+	Version     int64   `json:"v"`
+	LastPct     float64 `json:"p"`
+	LastName    string  `json:"n"`
+	LastAPIName string  `json:"a"`
+}
+
+func (c pageCursor) encode() string {
+	payload, _ := json.Marshal(c)
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func decodeCursor(raw string) (pageCursor, error) {
+	var c pageCursor
+	body, sig, ok := strings.Cut(raw, ".")
+	if !ok {
+		return c, apperr.ErrBadCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return c, apperr.ErrBadCursor
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return c, apperr.ErrBadCursor
+	}
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)[:16]) {
+		return c, apperr.ErrBadCursor
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, apperr.ErrBadCursor
+	}
+	return c, nil
+}
+
+// pagedVersions keeps, for each paged query, the sorted list of its
+// current version and of the one before, so that a refresh between two
+// pages does not break the pagination.
+type pagedVersions struct {
+	mu      sync.Mutex
+	lists   map[string]*pagedGenerations
+	order   []string // keys from the least recently stored
+	maxKeys int
+}
+
+type pagedGenerations struct {
+	current, previous pagedSnapshot
+}
+
+type pagedSnapshot struct {
+	version int64
+	items   []Achievement
+}
+
+var pagedLists = newPagedVersions(maxPagedVersions)
+
+func newPagedVersions(maxKeys int) *pagedVersions {
+	return &pagedVersions{lists: map[string]*pagedGenerations{}, maxKeys: maxKeys}
+}
+
+// store records items as the list of key at version, shifting the list
+// stored for another version to previous.
+func (p *pagedVersions) store(key string, version int64, items []Achievement) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g, ok := p.lists[key]
+	if !ok {
+		for len(p.order) >= p.maxKeys {
+			delete(p.lists, p.order[0])
+			p.order = p.order[1:]
+		}
+		g = &pagedGenerations{}
+		p.lists[key] = g
+		p.order = append(p.order, key)
+	}
+	if g.current.items != nil && g.current.version == version {
+		return
+	}
+	g.previous = g.current
+	g.current = pagedSnapshot{version: version, items: append([]Achievement(nil), items...)}
+}
+
+// at returns the list of key at version, if still kept.
+func (p *pagedVersions) at(key string, version int64) ([]Achievement, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g, ok := p.lists[key]
+	if !ok {
+		return nil, false
+	}
+	for _, snap := range []pagedSnapshot{g.current, g.previous} {
+		if snap.items != nil && snap.version == version {
+			return snap.items, true
+		}
+	}
+	return nil, false
+}
+
+// PageInfo describes the page served, nil when the list was not paged.
+type PageInfo struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type pagedList struct {
+	*PageInfo
+	Items any `json:"items"`
+}
+
+// paged wraps v, a projected list, for a page; it is returned as is when
+// page is nil.
+func paged(page *PageInfo, v any) any {
+	if page == nil {
+		return v
+	}
+	return pagedList{PageInfo: page, Items: v}
+}
+
+// filterFingerprint identifies the filters of r, so a cursor cannot be
+// replayed against another result set.
+func filterFingerprint(r *http.Request) string {
+	q := r.URL.Query()
+	for _, k := range []string{"cursor", "limit", "offset", "callback"} {
+		q.Del(k)
+	}
+	sum := sha256.Sum256([]byte(q.Encode()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// paginate returns the page of items (already sorted in order) asked for
+// by r, or false after answering an error. version identifies the data
+// items come from; pass 0 for data that cannot change.
+func paginate(w http.ResponseWriter, r *http.Request, order achievementOrder, items []Achievement, version int64) ([]Achievement, *PageInfo, bool) {
+	q := r.URL.Query()
+	rawLimit, rawOffset, rawCursor := strings.TrimSpace(q.Get("limit")), strings.TrimSpace(q.Get("offset")), strings.TrimSpace(q.Get("cursor"))
+	if rawLimit == "" && rawCursor == "" {
+		if rawOffset != "" {
+			writeError(w, http.StatusBadRequest, "invalid_query", "offset demande aussi limit")
+			return nil, nil, false
+		}
+		return items, nil, true
+	}
+
+	limit := defaultPageLimit
+	if rawLimit != "" {
+		n, err := strconv.Atoi(rawLimit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			writeError(w, http.StatusBadRequest, "invalid_query", "limit doit etre un entier entre 1 et "+strconv.Itoa(maxPageLimit))
+			return nil, nil, false
+		}
+		limit = n
+	}
+
+	fingerprint := filterFingerprint(r)
+	pagedKey := cacheNamespace(r.Context()) + "|" + r.URL.Path + "|" + order.spec() + "|" + fingerprint
+	if version != 0 {
+		pagedLists.store(pagedKey, version, items)
+	}
+	start := 0
+	switch {
+	case rawCursor != "" && rawOffset != "":
+		writeError(w, http.StatusBadRequest, "invalid_query", "cursor et offset sont incompatibles")
+		return nil, nil, false
+	case rawCursor != "":
+		c, err := decodeCursor(rawCursor)
+		if err != nil {
+			writeAppError(w, err)
+			return nil, nil, false
+		}
+		if c.Sort != order.spec() || c.Filter != fingerprint {
+			writeError(w, apperr.Status(apperr.CodeInvalidCursor), string(apperr.CodeInvalidCursor), "Le curseur ne correspond pas a ces filtres")
+			return nil, nil, false
+		}
+		if c.Version != version {
+			old, ok := pagedLists.at(pagedKey, c.Version)
+			if !ok {
+				writeJSONStatus(w, http.StatusConflict, map[string]any{
+					"error":   "cursor_expired",
+					"details": "La liste a ete rafraichie depuis la premiere page: recommence sans cursor",
+					"restart": true,
+				})
+				return nil, nil, false
+			}
+			// The page is copied below: callers rewrite icon URLs in place.
+			items, version = old, c.Version
+		}
+		last := Achievement{GlobalPct: c.LastPct, Name: c.LastName, APIName: c.LastAPIName}
+		start = sort.Search(len(items), func(i int) bool { return order.less(last, items[i]) })
+	case rawOffset != "":
+		n, err := strconv.Atoi(rawOffset)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_query", "offset doit etre un entier positif")
+			return nil, nil, false
+		}
+		start = min(n, len(items))
+	}
+
+	end := min(start+limit, len(items))
+	page := &PageInfo{Total: len(items), Limit: limit, Offset: start}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(items)))
+	if end < len(items) {
+		last := items[end-1]
+		next := pageCursor{Sort: order.spec(), Filter: fingerprint, Version: version, LastPct: last.GlobalPct, LastName: last.Name, LastAPIName: last.APIName}.encode()
+		page.NextCursor = next
+		w.Header().Set("X-Next-Cursor", next)
+		nextQuery := r.URL.Query()
+		nextQuery.Del("offset")
+		nextQuery.Set("cursor", next)
+		nextQuery.Set("limit", strconv.Itoa(limit))
+		w.Header().Set("Link", "<"+(&url.URL{Path: r.URL.Path, RawQuery: nextQuery.Encode()}).String()+`>; rel="next"`)
+	}
+	return append([]Achievement(nil), items[start:end]...), page, true
+}
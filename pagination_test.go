@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// pagingFixture has ties on the percentage and on the name, so that the
+// tie breakers are exercised.
+func pagingFixture() []Achievement {
+	return []Achievement{
+		{APIName: "A1", Name: "Alpha", GlobalPct: 50},
+		{APIName: "A2", Name: "Alpha", GlobalPct: 12.5},
+		{APIName: "B1", Name: "Bravo", GlobalPct: 12.5},
+		{APIName: "C1", Name: "Charlie", GlobalPct: 0.1},
+		{APIName: "D1", Name: "Delta", GlobalPct: 99},
+		{APIName: "E1", Name: "Echo", GlobalPct: 12.5},
+		{APIName: "F1", Name: "Foxtrot", GlobalPct: 3},
+		{APIName: "G1", Name: "Golf", GlobalPct: 50},
+	}
+}
+
+func apiNames(items []Achievement) string {
+	names := make([]string, len(items))
+	for i, a := range items {
+		names[i] = a.APIName
+	}
+	return strings.Join(names, ",")
+}
+
+func pageRequest(query string) (*httptest.ResponseRecorder, *http.Request) {
+	return httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/achievements?"+query, nil)
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := pageCursor{Sort: "name:asc,apiName:asc", Filter: "f", Version: 7, LastPct: 12.5, LastName: "Bravo", LastAPIName: "B1"}
+	got, err := decodeCursor(c.encode())
+	if err != nil || got != c {
+		t.Fatalf("decodeCursor(encode()) = %+v, %v; want %+v", got, err, c)
+	}
+}
+
+func TestCursorTamper(t *testing.T) {
+	raw := pageCursor{Sort: newAchievementOrder(sortPct, "").spec(), Filter: "f", Version: 7, LastPct: 12.5, LastName: "Bravo", LastAPIName: "B1"}.encode()
+	body, sig, _ := strings.Cut(raw, ".")
+
+	payload, _ := base64.RawURLEncoding.DecodeString(body)
+	flipped := append([]byte(nil), payload...)
+	flipped[len(flipped)/2] ^= 0x01
+	forged, _ := json.Marshal(pageCursor{Sort: newAchievementOrder(sortPct, "").spec(), Filter: "f", Version: 8, LastPct: 12.5, LastName: "Bravo", LastAPIName: "B1"})
+
+	tests := map[string]string{
+		"flipped payload byte":   base64.RawURLEncoding.EncodeToString(flipped) + "." + sig,
+		"other version, old sig": base64.RawURLEncoding.EncodeToString(forged) + "." + sig,
+		"truncated signature":    body + "." + sig[:len(sig)-2],
+		"empty signature":        body + ".",
+		"no signature":           body,
+		"signature not base64":   body + ".!!",
+		"payload not base64":     "!!." + sig,
+	}
+	for name, cursor := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decodeCursor(cursor); !errors.Is(err, apperr.ErrBadCursor) {
+				t.Fatalf("decodeCursor() err = %v, want ErrBadCursor", err)
+			}
+			w, r := pageRequest("limit=2&cursor=" + url.QueryEscape(cursor))
+			if _, _, ok := paginate(w, r, newAchievementOrder(sortPct, ""), pagingFixture(), 7); ok || w.Code != http.StatusBadRequest {
+				t.Fatalf("paginate() ok = %v, status %d; want 400", ok, w.Code)
+			}
+		})
+	}
+}
+
+func TestCursorReplayedUnderOtherFilters(t *testing.T) {
+	order := newAchievementOrder(sortPct, "")
+	items := pagingFixture()
+	order.sort(items)
+	w, r := pageRequest("limit=2&hidden=false")
+	if _, _, ok := paginate(w, r, order, items, 7); !ok {
+		t.Fatalf("first page: status %d", w.Code)
+	}
+	next := w.Header().Get("X-Next-Cursor")
+
+	for _, query := range []string{"hidden=only", "hidden=false&tag=boss", "", "hidden=false&lang=english"} {
+		w, r := pageRequest(query + "&limit=2&cursor=" + url.QueryEscape(next))
+		if _, _, ok := paginate(w, r, order, items, 7); ok || w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(apperr.CodeInvalidCursor)) {
+			t.Errorf("replay under %q: ok = %v, status %d, body %s", query, ok, w.Code, w.Body)
+		}
+	}
+	// Another sort is another result set too.
+	w, r = pageRequest("limit=2&hidden=false&cursor=" + url.QueryEscape(next))
+	if _, _, ok := paginate(w, r, newAchievementOrder(sortName, ""), items, 7); ok || w.Code != http.StatusBadRequest {
+		t.Errorf("replay under another sort: ok = %v, status %d", ok, w.Code)
+	}
+	// The limit may change between pages.
+	w, r = pageRequest("limit=5&hidden=false&cursor=" + url.QueryEscape(next))
+	if _, _, ok := paginate(w, r, order, items, 7); !ok {
+		t.Errorf("replay with another limit: status %d, body %s", w.Code, w.Body)
+	}
+}
+
+// walk pages through items with cursors and returns everything served.
+func walk(t *testing.T, order achievementOrder, items []Achievement, limit int, query string, versionAt func(page int) (int64, []Achievement)) []Achievement {
+	t.Helper()
+	var got []Achievement
+	cursor := ""
+	for page := 0; page <= len(items); page++ {
+		q := fmt.Sprintf("%slimit=%d", query, limit)
+		if cursor != "" {
+			q += "&cursor=" + url.QueryEscape(cursor)
+		}
+		version, list := versionAt(page)
+		w, r := pageRequest(q)
+		served, info, ok := paginate(w, r, order, list, version)
+		if !ok {
+			t.Fatalf("page %d: status %d, body %s", page, w.Code, w.Body)
+		}
+		got = append(got, served...)
+		if info.NextCursor == "" {
+			return got
+		}
+		cursor = info.NextCursor
+	}
+	t.Fatalf("pagination did not end")
+	return nil
+}
+
+func TestCursorAcrossSorts(t *testing.T) {
+	for _, key := range []string{sortPct, sortName, sortRarity} {
+		for _, dir := range []string{orderAsc, orderDesc} {
+			for _, limit := range []int{1, 3, 8} {
+				t.Run(fmt.Sprintf("%s:%s/limit=%d", key, dir, limit), func(t *testing.T) {
+					order := newAchievementOrder(key, dir)
+					items := pagingFixture()
+					order.sort(items)
+					got := walk(t, order, items, limit, fmt.Sprintf("sort=%s&order=%s&", key, dir), func(int) (int64, []Achievement) { return 0, items })
+					if apiNames(got) != apiNames(items) {
+						t.Fatalf("pages = %s, want %s", apiNames(got), apiNames(items))
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCursorOrders(t *testing.T) {
+	want := map[string]string{
+		"pct:desc":    "D1,A1,G1,A2,B1,E1,F1,C1",
+		"pct:asc":     "C1,F1,A2,B1,E1,A1,G1,D1",
+		"rarity:desc": "C1,F1,A2,B1,E1,A1,G1,D1",
+		"rarity:asc":  "D1,A1,G1,A2,B1,E1,F1,C1",
+		"name:asc":    "A1,A2,B1,C1,D1,E1,F1,G1",
+		"name:desc":   "G1,F1,E1,D1,C1,B1,A1,A2",
+	}
+	for spec, names := range want {
+		key, dir, _ := strings.Cut(spec, ":")
+		order := newAchievementOrder(key, dir)
+		items := pagingFixture()
+		order.sort(items)
+		if got := apiNames(items); got != names {
+			t.Errorf("%s = %s, want %s", spec, got, names)
+		}
+	}
+}
+
+// A refresh between two pages keeps serving the version the first page
+// came from; two refreshes lose it.
+func TestCursorServedFromPreviousVersion(t *testing.T) {
+	order := newAchievementOrder(sortPct, "")
+	v1 := pagingFixture()
+	order.sort(v1)
+	v2 := pagingFixture()
+	for i := range v2 {
+		v2[i].GlobalPct = 100 - v2[i].GlobalPct
+	}
+	order.sort(v2)
+
+	query := "sort=pct&refresh-test=1&"
+	got := walk(t, order, v1, 3, query, func(page int) (int64, []Achievement) {
+		if page == 0 {
+			return 101, v1
+		}
+		return 102, v2
+	})
+	if apiNames(got) != apiNames(v1) {
+		t.Fatalf("pages across a refresh = %s, want the first version %s", apiNames(got), apiNames(v1))
+	}
+
+	w, r := pageRequest(query + "limit=3")
+	if _, _, ok := paginate(w, r, order, v1, 201); !ok {
+		t.Fatalf("first page: status %d", w.Code)
+	}
+	cursor := w.Header().Get("X-Next-Cursor")
+	for _, version := range []int64{202, 203} {
+		w, r = pageRequest(query + "limit=3")
+		paginate(w, r, order, v2, version)
+	}
+	w, r = pageRequest(query + "limit=3&cursor=" + url.QueryEscape(cursor))
+	if _, _, ok := paginate(w, r, order, v2, 203); ok || w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"restart": true`) {
+		t.Fatalf("two refreshes later: ok = %v, status %d, body %s", ok, w.Code, w.Body)
+	}
+}
+
+// Served pages are copies: callers rewrite icon URLs in place.
+func TestPaginateCopiesPage(t *testing.T) {
+	order := newAchievementOrder(sortPct, "")
+	items := pagingFixture()
+	order.sort(items)
+	w, r := pageRequest("copy-test=1&limit=2")
+	page, _, _ := paginate(w, r, order, items, 301)
+	page[0].Icon = "rewritten"
+	w, r = pageRequest("copy-test=1&limit=2&cursor=" + url.QueryEscape(w.Header().Get("X-Next-Cursor")))
+	paginate(w, r, order, items, 302)
+	if snap, ok := pagedLists.at(cacheNamespace(r.Context())+"|"+r.URL.Path+"|"+order.spec()+"|"+filterFingerprint(r), 301); !ok || snap[0].Icon != "" {
+		t.Fatalf("kept version = %+v, %v; want it untouched", snap, ok)
+	}
+}
+
+func TestPagedVersionsEviction(t *testing.T) {
+	p := newPagedVersions(2)
+	p.store("a", 1, pagingFixture())
+	p.store("b", 1, pagingFixture())
+	p.store("c", 1, pagingFixture())
+	if _, ok := p.at("a", 1); ok {
+		t.Errorf("oldest query kept past the bound")
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, ok := p.at(key, 1); !ok {
+			t.Errorf("query %s evicted", key)
+		}
+	}
+}
+
+func TestPaginateOffset(t *testing.T) {
+	order := newAchievementOrder(sortName, "")
+	items := pagingFixture()
+	order.sort(items)
+	tests := []struct {
+		query  string
+		status int
+		names  string
+	}{
+		{"", http.StatusOK, apiNames(items)},
+		{"limit=3", http.StatusOK, "A1,A2,B1"},
+		{"limit=3&offset=6", http.StatusOK, "F1,G1"},
+		{"limit=3&offset=20", http.StatusOK, ""},
+		{"offset=2", http.StatusBadRequest, ""},
+		{"limit=0", http.StatusBadRequest, ""},
+		{"limit=501", http.StatusBadRequest, ""},
+		{"limit=3&offset=-1", http.StatusBadRequest, ""},
+		{"limit=3&offset=1&cursor=x", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w, r := pageRequest(tt.query)
+		got, _, ok := paginate(w, r, order, items, 0)
+		if tt.status != http.StatusOK {
+			if ok || w.Code != tt.status {
+				t.Errorf("%q: ok = %v, status %d; want %d", tt.query, ok, w.Code, tt.status)
+			}
+			continue
+		}
+		if !ok || apiNames(got) != tt.names {
+			t.Errorf("%q = %s (ok %v), want %s", tt.query, apiNames(got), ok, tt.names)
+		}
+	}
+}
+
+// The cursors of an ?appId= list follow the app's own data: player syncs
+// and config changes in between do not expire them.
+func TestAppCursorSurvivesUnrelatedSyncs(t *testing.T) {
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	w := getAchievements(s, "?appId=440&limit=1&sort=pct")
+	cursor := w.Header().Get("X-Next-Cursor")
+	if w.Code != http.StatusOK || cursor == "" {
+		t.Fatalf("first page: %d %s, cursor %q", w.Code, w.Body, cursor)
+	}
+	// Other clients start their walk after each sync.
+	for range 3 {
+		s.bumpGeneration(generationPlayers)
+		s.bumpGeneration(generationConfig)
+		getAchievements(s, "?appId=440&limit=1&sort=pct")
+	}
+	w = getAchievements(s, "?appId=440&limit=1&sort=pct&cursor="+url.QueryEscape(cursor))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"apiName": "B"`) {
+		t.Fatalf("second page after unrelated syncs: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+)
+
+// Steam global percentages are relative to players who launched the game.
+// ?baseline=estimatedOwners rescales them to estimated owners with the
+// figures of the owner estimates file (data/owner_estimates.json):
+//
+//	{
+//	  "apps": {
+//	    "105600": { "owners": 60000000, "players": 44000000, "source": "SteamSpy, 2025-09" }
+//	  }
+//	}
+//
+// "players" is the number of players who launched the game on the same
+// date as "owners", so pct * players / owners is the share of owners. The
+// file is optional; without an entry the owners baseline is refused.
+const defaultOwnerEstimatesFile = "data/owner_estimates.json"
+
+const (
+	baselinePlayers         = "players"
+	baselineEstimatedOwners = "estimatedOwners"
+)
+
+var baselineParam = enumParam{name: "baseline", accepted: []string{baselinePlayers, baselineEstimatedOwners}, synonyms: map[string]string{"owners": baselineEstimatedOwners}}
+
+type ownerEstimate struct {
+	Owners  int64  `json:"owners"`
+	Players int64  `json:"players"`
+	Source  string `json:"source"`
+}
+
+func loadOwnerEstimates(path string) (map[AppID]ownerEstimate, error) {
+	out := make(map[AppID]ownerEstimate)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Apps map[string]ownerEstimate `json:"apps"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for key, e := range raw.Apps {
+		appID, err := parseAppID(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: app key %q is not a positive integer", path, key)
+		}
+		if e.Owners <= 0 || e.Players <= 0 || e.Players > e.Owners {
+			return nil, fmt.Errorf("%s: app %d: need 0 < players <= owners", path, appID)
+		}
+		if e.Source == "" {
+			return nil, fmt.Errorf("%s: app %d: source is required", path, appID)
+		}
+		out[appID] = e
+	}
+	return out, nil
+}
+
+// percentageBaseline resolves q.baseline for appID and labels the response
+// with X-Percentage-Baseline (and X-Percentage-Baseline-Source), so the two
+// scales are never served unmarked. It returns the factor to apply to
+// Steam percentages, or false after answering 400 when no estimate exists.
+// Percentage bounds are converted so ?minPct=/?maxPct= filter on the
+// displayed scale.
+func (s *Server) percentageBaseline(w http.ResponseWriter, appID AppID, q *achievementQuery) (float64, bool) {
+	w.Header().Set("X-Percentage-Baseline", q.baseline)
+	if q.baseline != baselineEstimatedOwners {
+		return 1, true
+	}
+	est, ok := s.ownerEstimates[appID]
+	if !ok {
+		w.Header().Del("X-Percentage-Baseline")
+		writeError(w, http.StatusBadRequest, "baseline_unavailable", fmt.Sprintf("Aucune estimation du nombre de proprietaires pour l'app %d: utilise baseline=players", appID))
+		return 0, false
+	}
+	w.Header().Set("X-Percentage-Baseline-Source", est.Source)
+	factor := float64(est.Players) / float64(est.Owners)
+	for _, bound := range []*float64{q.minPct, q.maxPct} {
+		if bound != nil {
+			*bound /= factor
+		}
+	}
+	return factor, true
+}
+
+// rescaleGlobalPct applies factor to the Steam percentages of items, which
+// must be a copy of any cached list. Values keep four decimals.
+func rescaleGlobalPct(items []Achievement, factor float64) {
+	if factor == 1 {
+		return
+	}
+	for i := range items {
+		items[i].GlobalPct = math.Round(items[i].GlobalPct*factor*1e4) / 1e4
+	}
+}
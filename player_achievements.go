@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// /players/{steamid}/achievements, or /player/{steamid}/achievements, is
+// the achievement list of one game (?appId=, default the global app)
+// merged with one player's unlocks. The list comes from the same data as
+// /achievements, so a request costs at most one GetPlayerAchievements
+// call, and the player's unlocks are cached for PLAYER_ACHIEVEMENTS_TTL (5
+// minutes) so refreshing the front end does not call Steam again. Unlike
+// /users/achievements it does not sync the whole library nor write to the
+// database.
+const defaultPlayerAchievementsTTL = 5 * time.Minute
+const playerAchievementsMaxEntries = 1024
+
+type playerAchievementsKey struct {
+	steamID SteamID
+	appID   AppID
+}
+
+type playerAchievementsEntry struct {
+	states    map[string]userAchievementState
+	fetchedAt time.Time
+}
+
+type playerAchievementsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[playerAchievementsKey]playerAchievementsEntry
+}
+
+func newPlayerAchievementsCache(ttl time.Duration) *playerAchievementsCache {
+	return &playerAchievementsCache{ttl: ttl, entries: make(map[playerAchievementsKey]playerAchievementsEntry)}
+}
+
+func (c *playerAchievementsCache) get(key playerAchievementsKey, now time.Time) (playerAchievementsEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || now.Sub(e.fetchedAt) > c.ttl {
+		return playerAchievementsEntry{}, false
+	}
+	return e, true
+}
+
+func (c *playerAchievementsCache) put(key playerAchievementsKey, e playerAchievementsEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= playerAchievementsMaxEntries {
+		for k, old := range c.entries {
+			if e.fetchedAt.Sub(old.fetchedAt) > c.ttl {
+				delete(c.entries, k)
+			}
+		}
+		// Still full of live entries: drop an arbitrary one.
+		for k := range c.entries {
+			if len(c.entries) < playerAchievementsMaxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = e
+}
+
+func (s *Server) playerAchievementStates(ctx context.Context, steamID SteamID, appID AppID) (playerAchievementsEntry, error) {
+	key := playerAchievementsKey{steamID: steamID, appID: appID}
+	now := time.Now()
+	if e, ok := s.playerAchievements.get(key, now); ok {
+		return e, nil
+	}
+	if s.readOnly() {
+		return playerAchievementsEntry{}, apperr.ErrReadOnly
+	}
+	states, err := fetchPlayerAchievements(ctx, s.apiKey, steamID, appID)
+	if err != nil {
+		return playerAchievementsEntry{}, err
+	}
+	e := playerAchievementsEntry{states: states, fetchedAt: now}
+	s.playerAchievements.put(key, e)
+	return e, nil
+}
+
+// achievementList is the list of appID with global percentages, in the
+// served language: the synced global list for the default app, the per-app
+// caches otherwise.
+func (s *Server) achievementList(ctx context.Context, appID AppID) ([]Achievement, error) {
+	if appID == defaultGlobalAppID {
+		return s.loadLocalizedGlobal(ctx, s.lang)
+	}
+	return s.appAchievementList(ctx, appID, s.lang)
+}
+
+func (s *Server) handlePlayerAchievements(w http.ResponseWriter, r *http.Request) {
+	steamID, err := s.resolveSteamIDInput(r.PathValue("steamid"))
+	if err != nil {
+		writeIdentifierError(w, err)
+		return
+	}
+	s.servePlayerAchievements(w, r, steamID)
+}
+
+func (s *Server) servePlayerAchievements(w http.ResponseWriter, r *http.Request, steamID SteamID) {
+	w.Header().Set("X-Steam-ID", pseudonyms.steamID(steamID).String())
+
+	appID := defaultGlobalAppID
+	var err error
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		if appID, err = parseAppID(raw); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
+		}
+	}
+	if !s.appAllowed(appID) {
+		writeAppNotAllowed(w, appID)
+		return
+	}
+	if source := s.sourceFor(appID).Name(); source != sourceSteam {
+		writeSourceUnsupported(w, appID, source)
+		return
+	}
+	w.Header().Set("X-Achievement-Source", sourceSteam)
+	setSurrogateKeys(w, appSurrogateKey(appID), playerSurrogateKey(steamID), langSurrogateKey(s.lang))
+
+	query, err := parseAchievementQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	format, ok := negotiateFormat(w, r)
+	if !ok {
+		return
+	}
+	query.format, query.unlocks = format, true
+	if query.charsets, ok = negotiateCharsets(w, r, format); !ok {
+		return
+	}
+	factor, ok := s.percentageBaseline(w, appID, &query)
+	if !ok {
+		return
+	}
+
+	items, err := s.achievementList(r.Context(), appID)
+	if errors.Is(err, apperr.ErrReadOnly) {
+		writeReadOnly(w)
+		return
+	}
+	if err != nil {
+		writeSyncError(w, err, fmt.Sprintf("player achievements schema, appID=%d", appID))
+		return
+	}
+	if len(items) == 0 && appID != defaultGlobalAppID {
+		writeNoAchievements(w, appID, s.emptySchemaStatus(r.Context(), appID))
+		return
+	}
+	player, err := s.playerAchievementStates(r.Context(), steamID, appID)
+	if err != nil {
+		writeSyncError(w, err, fmt.Sprintf("player achievements, steamID=%s, appID=%d", steamID, appID))
+		return
+	}
+	for i := range items {
+		st := player.states[items[i].APIName]
+		items[i].Achieved, items[i].UnlockTime = st.Achieved, st.UnlockTime
+	}
+
+	items, unknownTags := query.apply(s.overlay, s.policyFor(w, appID), appID, s.lang, items)
+	rescaleGlobalPct(items, factor)
+	if err := s.applyLocalPct(w, appID, items); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	query.writeDebug(w, unknownTags)
+	query.order.sort(items)
+	s.setDataAgeHeaders(r.Context(), w, player.fetchedAt)
+	if query.export(w, appID, items) {
+		return
+	}
+	s.proxyIcons(appID, items)
+	query.write(w, r, playerItems(query.project(items)))
+}
+
+// PlayerAchievement is an item of a player's list in the full profile:
+// achieved is sent even when false, and the unlock time is also given as
+// unlockedAt, RFC3339 or null, the name the player endpoint was asked with.
+type PlayerAchievement struct {
+	Achievement
+	Achieved   bool    `json:"achieved"`
+	UnlockedAt apiTime `json:"unlockedAt"`
+}
+
+// playerItems turns the projected items of a player's list into
+// PlayerAchievement; the lite profile keeps its shape.
+func playerItems(v any) any {
+	items, ok := v.([]Achievement)
+	if !ok {
+		return v
+	}
+	out := make([]PlayerAchievement, len(items))
+	for i, a := range items {
+		out[i] = PlayerAchievement{Achievement: a, Achieved: a.Achieved, UnlockedAt: a.UnlockTime}
+	}
+	return out
+}
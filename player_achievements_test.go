@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const fakePlayerJSON = `{"playerstats":{"success":true,"achievements":[{"apiname":"A","achieved":1,"unlocktime":1700000000},{"apiname":"B","achieved":0,"unlocktime":0}]}}`
+
+func playerMux(t *testing.T, s *Server) func(path string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	if err := registerAPIRoutes(mux, s.apiRoutes(), apiV1); err != nil {
+		t.Fatal(err)
+	}
+	return func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+}
+
+func TestPlayerAchievementsAlias(t *testing.T) {
+	f := fakeSteamGame(t)
+	f.handle("GetPlayerAchievements", http.StatusOK, fakePlayerJSON)
+	get := playerMux(t, newTestServer(t))
+
+	players := get("/api/players/76561197960287930/achievements?appId=440")
+	player := get("/api/player/76561197960287930/achievements?appId=440")
+	if players.Code != http.StatusOK || player.Code != http.StatusOK || !bytes.Equal(players.Body.Bytes(), player.Body.Bytes()) {
+		t.Fatalf("/players %d, /player %d: %s", players.Code, player.Code, player.Body)
+	}
+	if f.count("GetPlayerAchievements") != 1 {
+		t.Errorf("%d Steam calls for the two paths, want 1", f.count("GetPlayerAchievements"))
+	}
+	if w := get("/api/v1/player/not-a-steamid!/achievements?appId=440"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid SteamID: %d", w.Code)
+	}
+}
+
+func TestPlayerAchievementsFields(t *testing.T) {
+	f := fakeSteamGame(t)
+	f.handle("GetPlayerAchievements", http.StatusOK, fakePlayerJSON)
+	get := playerMux(t, newTestServer(t))
+
+	w := get("/api/player/76561197960287930/achievements?appId=440&sort=name")
+	var items []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil || len(items) != 2 {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	byName := map[string]map[string]any{}
+	for _, a := range items {
+		byName[a["apiName"].(string)] = a
+	}
+	if a := byName["A"]; a["achieved"] != true || a["unlockedAt"] != "2023-11-14T22:13:20Z" || a["unlockTime"] != "2023-11-14T22:13:20Z" {
+		t.Errorf("unlocked A = %v", a)
+	}
+	b, ok := byName["B"]
+	if v, has := b["unlockedAt"]; !ok || !has || v != nil || b["achieved"] != false {
+		t.Errorf("locked B = %v, want achieved false and unlockedAt null", b)
+	}
+	if bytes.Count(w.Body.Bytes(), []byte(`"achieved"`)) != 2 {
+		t.Errorf("achieved sent %d times in %s", bytes.Count(w.Body.Bytes(), []byte(`"achieved"`)), w.Body)
+	}
+
+	f.handle("GetPlayerAchievements", http.StatusOK, `{"playerstats":{"success":false,"error":"Requested profile is not public"}}`)
+	if w := get("/api/player/76561197960287931/achievements?appId=440"); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"error"`) {
+		t.Errorf("private profile: %d %s", w.Code, w.Body)
+	}
+}
+
+// ACHIEVEMENTS_LANG is the language of the player endpoints and of the
+// bootstrap list too, not only of /achievements.
+func TestServedLanguageFollowsSetting(t *testing.T) {
+	f := useFakeSteam(t)
+	f.handle("l=english", http.StatusOK, strings.NewReplacer("Facile", "Easy", "Dur", "Hard").Replace(fakeSchemaJSON))
+	f.handle("GetSchemaForGame", http.StatusOK, fakeSchemaJSON)
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, fakePctJSON)
+	f.handle("GetPlayerSummaries", http.StatusOK, fakeSummaryJSON)
+	f.handle("GetOwnedGames", http.StatusOK, fakeOwnedJSON)
+	f.handle("GetUserStatsForGame", http.StatusOK, fakeStatsJSON)
+	f.handle("GetPlayerAchievements", http.StatusOK, fakePlayerJSON)
+	s := newTestServer(t)
+	s.lang = "english"
+	get := playerMux(t, s)
+
+	for _, path := range []string{
+		"/api/player/76561197960287930/achievements?appId=440",
+		"/api/users/achievements?steamId=76561197960287930&appId=105600",
+		"/api/bootstrap?include=achievements",
+	} {
+		w := get(path)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Easy"`) || strings.Contains(w.Body.String(), `"Facile"`) {
+			t.Errorf("%s: %d %s, want the english names", path, w.Code, w.Body)
+		}
+		if keys := w.Header().Get("Surrogate-Key"); !strings.Contains(keys, "lang-english") {
+			t.Errorf("%s: Surrogate-Key %q without lang-english", path, keys)
+		}
+	}
+}
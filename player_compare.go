@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// /compare?steamids=a,b,c (&appId=) lines up the unlocks of a group of
+// players on one game: who has each achievement and who does not, how many
+// the whole group has and how many anyone has, and the achievements still
+// missing for someone, easiest (highest global percent) first. Players are
+// fetched concurrently through the /players cache, so comparing a group
+// right after opening its members' pages costs no Steam call. A player
+// whose unlocks cannot be read (private profile, Steam error) is reported
+// with its error and left out of the comparison; the request only fails
+// when nobody could be read. At most COMPARE_MAX_PLAYERS (8) per request.
+const (
+	defaultCompareMaxPlayers = 8
+	compareEasiestMissing    = 10
+)
+
+type comparedPlayer struct {
+	SteamID    SteamID `json:"steamId"`
+	Achieved   int     `json:"achieved"`
+	Completion float64 `json:"completion"`
+	Error      string  `json:"error,omitempty"`
+	Details    string  `json:"details,omitempty"`
+}
+
+type comparedAchievement struct {
+	APIName    string    `json:"apiName"`
+	Name       string    `json:"name"`
+	Icon       string    `json:"icon"`
+	GlobalPct  float64   `json:"globalPct"`
+	Redacted   bool      `json:"redacted,omitempty"`
+	AchievedBy []SteamID `json:"achievedBy"`
+	MissingBy  []SteamID `json:"missingBy"`
+}
+
+type compareSummary struct {
+	Total int `json:"total"`
+	// Everyone counts the achievements every compared player has, Anyone
+	// those at least one has.
+	Everyone int `json:"everyone"`
+	Anyone   int `json:"anyone"`
+	Nobody   int `json:"nobody"`
+}
+
+type PlayerComparison struct {
+	AppID          AppID                 `json:"appId"`
+	Players        []comparedPlayer      `json:"players"`
+	Summary        compareSummary        `json:"summary"`
+	EasiestMissing []comparedAchievement `json:"easiestMissing"`
+	Achievements   []comparedAchievement `json:"achievements"`
+}
+
+// parseCompareSteamIDs resolves ?steamids=, comma separated or repeated,
+// dropping duplicates.
+func (s *Server) parseCompareSteamIDs(values []string) ([]SteamID, error) {
+	var ids []SteamID
+	seen := make(map[SteamID]bool)
+	for _, v := range values {
+		for _, raw := range strings.Split(v, ",") {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			id, err := s.resolveSteamIDInput(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", raw, err)
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	ids, err := s.parseCompareSteamIDs(r.URL.Query()["steamids"])
+	if err != nil {
+		writeIdentifierError(w, err)
+		return
+	}
+	if len(ids) < 2 {
+		writeError(w, http.StatusBadRequest, "invalid_query", "steamids doit lister au moins deux joueurs, separes par des virgules")
+		return
+	}
+	if len(ids) > s.compareMaxPlayers {
+		writeError(w, http.StatusBadRequest, "too_many_players", fmt.Sprintf("Au plus %d joueurs par comparaison", s.compareMaxPlayers))
+		return
+	}
+
+	appID := defaultGlobalAppID
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		if appID, err = parseAppID(raw); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
+		}
+	}
+	if !s.appAllowed(appID) {
+		writeAppNotAllowed(w, appID)
+		return
+	}
+	if source := s.sourceFor(appID).Name(); source != sourceSteam {
+		writeSourceUnsupported(w, appID, source)
+		return
+	}
+
+	items, err := s.achievementList(r.Context(), appID)
+	if errors.Is(err, apperr.ErrReadOnly) {
+		writeReadOnly(w)
+		return
+	}
+	if err != nil {
+		writeSyncError(w, err, fmt.Sprintf("compare schema, appID=%d", appID))
+		return
+	}
+	if len(items) == 0 && appID != defaultGlobalAppID {
+		writeNoAchievements(w, appID, s.emptySchemaStatus(r.Context(), appID))
+		return
+	}
+	items = s.policyFor(w, appID).filter(items)
+
+	states := make([]map[string]userAchievementState, len(ids))
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, err := s.playerAchievementStates(r.Context(), id, appID)
+			states[i], errs[i] = e.states, err
+		}()
+	}
+	wg.Wait()
+
+	resp := PlayerComparison{AppID: appID, Players: make([]comparedPlayer, len(ids)), Achievements: make([]comparedAchievement, 0, len(items))}
+	var read []int
+	var firstErr error
+	for i, id := range ids {
+		resp.Players[i].SteamID = pseudonyms.steamID(id)
+		if err := errs[i]; err != nil {
+			code, ok := apperr.CodeOf(err)
+			if !ok {
+				log.Printf("compare: player achievements, steamID=%s, appID=%d: %v", id, appID, err)
+				code = apperr.CodeSteamSync
+			}
+			resp.Players[i].Error, resp.Players[i].Details = string(code), apperr.Message(code, apperr.DefaultLang)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		read = append(read, i)
+	}
+	if len(read) == 0 {
+		writeSyncError(w, firstErr, fmt.Sprintf("compare, appID=%d", appID))
+		return
+	}
+
+	for _, a := range items {
+		c := comparedAchievement{APIName: a.APIName, Name: a.Name, Icon: a.Icon, GlobalPct: a.GlobalPct, Redacted: a.Redacted,
+			AchievedBy: make([]SteamID, 0, len(read)), MissingBy: make([]SteamID, 0, len(read))}
+		for _, i := range read {
+			if states[i][a.APIName].Achieved {
+				c.AchievedBy = append(c.AchievedBy, resp.Players[i].SteamID)
+				resp.Players[i].Achieved++
+			} else {
+				c.MissingBy = append(c.MissingBy, resp.Players[i].SteamID)
+			}
+		}
+		switch len(c.AchievedBy) {
+		case len(read):
+			resp.Summary.Everyone++
+		case 0:
+			resp.Summary.Nobody++
+		}
+		resp.Achievements = append(resp.Achievements, c)
+	}
+	resp.Summary.Total = len(items)
+	resp.Summary.Anyone = resp.Summary.Total - resp.Summary.Nobody
+	for _, i := range read {
+		if resp.Summary.Total > 0 {
+			resp.Players[i].Completion = roundPct(float64(resp.Players[i].Achieved) * 100 / float64(resp.Summary.Total))
+		}
+	}
+
+	resp.EasiestMissing = make([]comparedAchievement, 0, compareEasiestMissing)
+	for _, c := range resp.Achievements {
+		if len(c.MissingBy) > 0 {
+			resp.EasiestMissing = append(resp.EasiestMissing, c)
+		}
+	}
+	sort.SliceStable(resp.EasiestMissing, func(i, j int) bool {
+		a, b := resp.EasiestMissing[i], resp.EasiestMissing[j]
+		if a.GlobalPct != b.GlobalPct {
+			return a.GlobalPct > b.GlobalPct
+		}
+		return a.APIName < b.APIName
+	})
+	if len(resp.EasiestMissing) > compareEasiestMissing {
+		resp.EasiestMissing = resp.EasiestMissing[:compareEasiestMissing]
+	}
+
+	keys := []string{appSurrogateKey(appID)}
+	for _, id := range ids {
+		keys = append(keys, playerSurrogateKey(id))
+	}
+	setSurrogateKeys(w, keys...)
+	writeJSON(w, resp)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The state that keeps Steam from being hammered lives in memory: the
+// upstream outage tracking and the alerts already sent, the circuit
+// breaker, the Steam requests already spent from the daily budget, the
+// Steam rate limiter bucket, the player scheduler's hourly cap, the
+// per-host health and the verify rate limit. It is written to STATE_FILE (default:
+// DATA_DIR/state.json, "off" disables it) on graceful shutdown and read
+// back at startup when it is younger than STATE_MAX_AGE (10m), so a
+// restart loop does not start every time with full protections reset.
+// A corrupt, stale or unknown-version file is ignored with a log line.
+const (
+	protectiveStateVersion = 1
+	defaultStateMaxAge     = 10 * time.Minute
+)
+
+type protectiveState struct {
+	Version         int                   `json:"version"`
+	SavedAt         time.Time             `json:"savedAt"`
+	Upstream        *upstreamMonitorState `json:"upstream,omitempty"`
+	AlertsSent      map[string]time.Time  `json:"alertsSent,omitempty"`
+	Breaker         *breakerState         `json:"breaker,omitempty"`
+	Budget          *upstreamBudgetState  `json:"budget,omitempty"`
+	Limiter         *tokenBucketState     `json:"limiter,omitempty"`
+	SchedulerRecent []time.Time           `json:"schedulerRecent,omitempty"`
+	Hosts           []steamHostState      `json:"hosts,omitempty"`
+	Verifies        map[string]time.Time  `json:"verifies,omitempty"`
+}
+
+type upstreamMonitorState struct {
+	FailingSince time.Time `json:"failingSince"`
+	Down         bool      `json:"down"`
+	LastError    string    `json:"lastError,omitempty"`
+	LastErrorAt  time.Time `json:"lastErrorAt"`
+}
+
+type breakerState struct {
+	Failures  int       `json:"failures"`
+	OpenUntil time.Time `json:"openUntil"` // zero: closed
+	LastTrip  time.Time `json:"lastTrip"`
+}
+
+// upstreamBudgetState is the Steam requests of one UTC day.
+type upstreamBudgetState struct {
+	Day      string                    `json:"day"`
+	Total    int64                     `json:"total"`
+	Requests map[upstreamFeature]int64 `json:"requests,omitempty"`
+}
+
+type tokenBucketState struct {
+	Tokens float64   `json:"tokens"`
+	At     time.Time `json:"at"`
+}
+
+type steamHostState struct {
+	Host      string    `json:"host"`
+	LatencyMs float64   `json:"latencyMs"`
+	Failures  float64   `json:"failures"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// stateFileFromEnv is STATE_FILE, "" when disabled.
+func stateFileFromEnv() string {
+	v := strings.TrimSpace(getenv("STATE_FILE", filepath.Join(getenv("DATA_DIR", "data"), "state.json")))
+	if strings.EqualFold(v, "off") {
+		return ""
+	}
+	return v
+}
+
+func (m *upstreamMonitor) exportState() *upstreamMonitorState {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &upstreamMonitorState{FailingSince: m.failingSince, Down: m.down, LastError: m.lastError, LastErrorAt: m.lastErrorAt}
+}
+
+func (m *upstreamMonitor) restoreState(st *upstreamMonitorState) {
+	if m == nil || st == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failingSince, m.down, m.lastError, m.lastErrorAt = st.FailingSince, st.Down, st.LastError, st.LastErrorAt
+}
+
+func (n *alertNotifier) exportState() map[string]time.Time {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make(map[string]time.Time, len(n.lastSent))
+	for k, v := range n.lastSent {
+		out[k] = v
+	}
+	return out
+}
+
+func (n *alertNotifier) restoreState(sent map[string]time.Time) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for k, v := range sent {
+		n.lastSent[k] = v
+	}
+}
+
+func (b *upstreamBreaker) exportState() *breakerState {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &breakerState{Failures: b.failures, OpenUntil: b.openUntil, LastTrip: b.lastTrip}
+}
+
+// restoreState keeps an open breaker open until the saved time; past it,
+// the next call is the probe, as it would have been without the restart.
+func (b *upstreamBreaker) restoreState(st *breakerState) {
+	if b == nil || st == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.openUntil, b.lastTrip = st.Failures, st.OpenUntil, st.LastTrip
+}
+
+func (l *upstreamLedger) exportState(now time.Time) *upstreamBudgetState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d := l.todayLocked(now)
+	return &upstreamBudgetState{Day: d.day, Total: d.total, Requests: maps.Clone(d.requests)}
+}
+
+// restoreState counts the requests the previous run sent today; those of
+// another day are forgotten with the budget they were spent on.
+func (l *upstreamLedger) restoreState(st *upstreamBudgetState, now time.Time) {
+	if st == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d := l.todayLocked(now)
+	if st.Day != d.day {
+		return
+	}
+	d.total += st.Total
+	for f, n := range st.Requests {
+		d.requests[f] += n
+	}
+}
+
+func (b *tokenBucket) exportState() *tokenBucketState {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &tokenBucketState{Tokens: b.tokens, At: b.last}
+}
+
+// restoreState takes the saved level back; the time since it was saved
+// refills it on the next wait.
+func (b *tokenBucket) restoreState(st *tokenBucketState, now time.Time) {
+	if b == nil || st == nil || st.At.After(now) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens, b.last = min(b.burst, st.Tokens), st.At
+}
+
+func (p *playerScheduler) exportState() []time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]time.Time(nil), p.recent...)
+}
+
+func (p *playerScheduler) restoreState(recent []time.Time, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range recent {
+		if now.Sub(t) < time.Hour && !t.After(now) {
+			p.recent = append(p.recent, t)
+		}
+	}
+}
+
+func (sel *steamHostSelector) exportState() []steamHostState {
+	if sel == nil {
+		return nil
+	}
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	out := make([]steamHostState, 0, len(sel.hosts))
+	for _, h := range sel.hosts {
+		out = append(out, steamHostState{Host: h.base.String(), LatencyMs: h.latencyMs, Failures: h.failures, UpdatedAt: h.updatedAt})
+	}
+	return out
+}
+
+// restoreState applies the saved health of the hosts still configured.
+func (sel *steamHostSelector) restoreState(hosts []steamHostState) {
+	if sel == nil {
+		return
+	}
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	for _, st := range hosts {
+		for _, h := range sel.hosts {
+			if h.base.String() == st.Host {
+				h.latencyMs, h.failures, h.updatedAt = st.LatencyMs, st.Failures, st.UpdatedAt
+			}
+		}
+	}
+}
+
+func (l *verifyLimiter) exportState() map[string]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]time.Time, len(l.lastRun))
+	for id, t := range l.lastRun {
+		out[id.String()] = t
+	}
+	return out
+}
+
+func (l *verifyLimiter) restoreState(runs map[string]time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for raw, t := range runs {
+		if id, err := parseAppID(raw); err == nil {
+			l.lastRun[id] = t
+		}
+	}
+}
+
+func (s *Server) captureProtectiveState(now time.Time) *protectiveState {
+	st := &protectiveState{
+		Version:         protectiveStateVersion,
+		SavedAt:         now.UTC(),
+		Upstream:        upstreamHealth.exportState(),
+		Breaker:         breaker.exportState(),
+		Budget:          upstreamUsage.exportState(now),
+		Limiter:         steamLimiter.exportState(),
+		SchedulerRecent: s.scheduler.exportState(),
+		Hosts:           steamHosts.exportState(),
+		Verifies:        s.verifies.exportState(),
+	}
+	if upstreamHealth != nil {
+		st.AlertsSent = upstreamHealth.alerts.exportState()
+	}
+	return st
+}
+
+func (s *Server) saveProtectiveState() error {
+	if s.stateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.captureProtectiveState(time.Now()))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.stateFile), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.stateFile, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// loadProtectiveState restores the state saved by the previous run. Nothing
+// it finds wrong stops the startup.
+func (s *Server) loadProtectiveState(now time.Time) {
+	if s.stateFile == "" {
+		return
+	}
+	data, err := os.ReadFile(s.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("protective state: %v (ignored)", err)
+		return
+	}
+	var st protectiveState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("protective state %s: corrupt, ignored: %v", s.stateFile, err)
+		return
+	}
+	if err := st.usable(now, s.stateMaxAge); err != nil {
+		log.Printf("protective state %s: %v, ignored", s.stateFile, err)
+		return
+	}
+
+	upstreamHealth.restoreState(st.Upstream)
+	if upstreamHealth != nil {
+		upstreamHealth.alerts.restoreState(st.AlertsSent)
+	}
+	breaker.restoreState(st.Breaker)
+	upstreamUsage.restoreState(st.Budget, now)
+	steamLimiter.restoreState(st.Limiter, now)
+	s.scheduler.restoreState(st.SchedulerRecent, now)
+	steamHosts.restoreState(st.Hosts)
+	s.verifies.restoreState(st.Verifies)
+	log.Printf("protective state restored from %s (saved %s ago)", s.stateFile, now.Sub(st.SavedAt).Round(time.Second))
+}
+
+func (st *protectiveState) usable(now time.Time, maxAge time.Duration) error {
+	if st.Version != protectiveStateVersion {
+		return fmt.Errorf("unsupported version %d", st.Version)
+	}
+	age := now.Sub(st.SavedAt)
+	if st.SavedAt.IsZero() || age < 0 {
+		return errors.New("no valid savedAt")
+	}
+	if age > maxAge {
+		return fmt.Errorf("saved %s ago, older than %s", age.Round(time.Second), maxAge)
+	}
+	return nil
+}
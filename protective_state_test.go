@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// restartableProtections gives the test its own breaker and Steam budget,
+// both replaced again by restart as a new process would.
+func restartableProtections(t *testing.T, clock *fakeClock) (restart func()) {
+	t.Helper()
+	savedBreaker, savedUsage, savedHealth, savedLimiter, savedHosts := breaker, upstreamUsage, upstreamHealth, steamLimiter, steamHosts
+	t.Cleanup(func() {
+		breaker, upstreamUsage, upstreamHealth, steamLimiter, steamHosts = savedBreaker, savedUsage, savedHealth, savedLimiter, savedHosts
+	})
+	restart = func() {
+		breaker = newUpstreamBreaker(2, 30*time.Second)
+		breaker.now = clock.now
+		upstreamUsage = newUpstreamLedger(100, nil)
+		upstreamHealth, steamHosts = nil, nil
+		steamLimiter = newTokenBucket(defaultUpstreamRate, defaultUpstreamBurst)
+	}
+	restart()
+	return restart
+}
+
+func TestBreakerOpenAcrossRestart(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	restart := restartableProtections(t, clock)
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	for range 2 {
+		probe, err := breaker.allow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		breaker.done(probe, callFailed)
+	}
+	for range 3 {
+		upstreamUsage.take(featurePlayerFetch, clock.now())
+	}
+	if !breaker.snapshot().Open {
+		t.Fatal("the breaker did not open")
+	}
+	s := newTestServer(t)
+	s.stateFile = stateFile
+	if err := s.saveProtectiveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restart()
+	clock.advance(10 * time.Second)
+	s = newTestServer(t)
+	s.stateFile = stateFile
+	s.loadProtectiveState(clock.now())
+	if _, err := breaker.allow(); !apperr.Is(err, apperr.CodeUpstreamOpen) {
+		t.Errorf("10s after the restart: %v, want the breaker still open", err)
+	}
+	if st := breaker.snapshot(); !st.Open || st.Failures != 2 {
+		t.Errorf("breaker after the restart %+v", st)
+	}
+	if today := upstreamUsage.snapshot(clock.now()).Today; today != 3 {
+		t.Errorf("%d Steam requests spent today after the restart, want 3", today)
+	}
+
+	// The cooldown started before the restart ends on time.
+	clock.advance(25 * time.Second)
+	probe, err := breaker.allow()
+	if err != nil || !probe {
+		t.Fatalf("after the cooldown: probe %v, %v", probe, err)
+	}
+	breaker.done(probe, callSucceeded)
+	if breaker.snapshot().Open {
+		t.Errorf("a successful probe left the breaker open")
+	}
+}
+
+func TestProtectiveStateIgnored(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	restart := restartableProtections(t, clock)
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	s := newTestServer(t)
+	s.stateFile, s.stateMaxAge = stateFile, defaultStateMaxAge
+	for range 2 {
+		breaker.done(false, callFailed)
+	}
+	if err := s.saveProtectiveState(); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(stateFile)
+
+	for _, tt := range []struct {
+		name string
+		data string
+		at   time.Time
+		log  string
+	}{
+		{"stale", string(saved), clock.now().Add(defaultStateMaxAge + time.Second), "older than 10m0s, ignored"},
+		{"from the future", string(saved), clock.now().Add(-time.Hour), "no valid savedAt, ignored"},
+		{"corrupt", `{"version":1,"savedAt":`, clock.now(), "corrupt, ignored"},
+		{"unknown version", strings.Replace(string(saved), `"version":1`, `"version":99`, 1), clock.now(), "unsupported version 99, ignored"},
+	} {
+		logs := captureLog(t)
+		os.WriteFile(stateFile, []byte(tt.data), 0o644)
+		restart()
+		s.loadProtectiveState(tt.at)
+		if breaker.snapshot().Open {
+			t.Errorf("%s: the state was restored", tt.name)
+		}
+		if !strings.Contains(logs.String(), tt.log) {
+			t.Errorf("%s: log %q, want %q", tt.name, logs.String(), tt.log)
+		}
+	}
+
+	// Yesterday's requests do not count against today's budget.
+	restart()
+	upstreamUsage.restoreState(&upstreamBudgetState{Day: clock.now().Add(-24 * time.Hour).UTC().Format(archiveDateLayout), Total: 50}, clock.now())
+	if today := upstreamUsage.snapshot(clock.now()).Today; today != 0 {
+		t.Errorf("yesterday's budget restored: %d", today)
+	}
+
+	os.Remove(stateFile)
+	logs := captureLog(t)
+	s.loadProtectiveState(clock.now())
+	if logs.String() != "" {
+		t.Errorf("no state file: %q", logs.String())
+	}
+	t.Setenv("STATE_FILE", "off")
+	if got := stateFileFromEnv(); got != "" {
+		t.Errorf("STATE_FILE=off: %q", got)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pseudonymizer hides real players in public responses for demos
+// (PSEUDONYMIZE=1). SteamIDs go through a keyed permutation of their
+// account id, so a pseudonym is stable for a given PSEUDONYMIZE_SALT, can
+// be typed back into the API, and never collides with another player.
+// Names and avatars are derived from the pseudonym. Admin endpoints and
+// logs keep the real values.
+type pseudonymizer struct {
+	key []byte
+}
+
+// pseudonyms is nil unless PSEUDONYMIZE=1; every method is nil-safe and
+// returns its input unchanged when off.
+var pseudonyms *pseudonymizer
+
+var errPseudonymSalt = errors.New("PSEUDONYMIZE_SALT manquant (obligatoire avec PSEUDONYMIZE=1)")
+
+func pseudonymizerFromEnv() (*pseudonymizer, error) {
+	if getenv("PSEUDONYMIZE", "") != "1" {
+		return nil, nil
+	}
+	salt := cleanEnvValue(os.Getenv("PSEUDONYMIZE_SALT"))
+	if salt == "" {
+		return nil, errPseudonymSalt
+	}
+	return &pseudonymizer{key: []byte(salt)}, nil
+}
+
+func (p *pseudonymizer) enabled() bool { return p != nil }
+
+func (p *pseudonymizer) mac(parts ...string) []byte {
+	m := hmac.New(sha256.New, p.key)
+	for _, part := range parts {
+		m.Write([]byte(part))
+		m.Write([]byte{0})
+	}
+	return m.Sum(nil)
+}
+
+// feistel runs a 4-round Feistel network over the 32-bit account id;
+// running the rounds backwards inverts it.
+func (p *pseudonymizer) feistel(v uint32, inverse bool) uint32 {
+	l, r := uint16(v>>16), uint16(v)
+	for i := 0; i < 4; i++ {
+		round := i
+		if inverse {
+			round = 3 - i
+		}
+		if !inverse {
+			l, r = r, l^p.roundFunc(round, r)
+		} else {
+			l, r = r^p.roundFunc(round, l), l
+		}
+	}
+	return uint32(l)<<16 | uint32(r)
+}
+
+func (p *pseudonymizer) roundFunc(round int, half uint16) uint16 {
+	return binary.BigEndian.Uint16(p.mac("round", strconv.Itoa(round), strconv.Itoa(int(half))))
+}
+
+// steamID maps a real id to its pseudonym. Only the account id bits
+// change, so the result is still a valid individual SteamID64.
+func (p *pseudonymizer) steamID(id SteamID) SteamID {
+	if p == nil || id == 0 {
+		return id
+	}
+	high := uint64(id) &^ 0xFFFFFFFF
+	return SteamID(high | uint64(p.feistel(uint32(id), false)))
+}
+
+// realSteamID reverses steamID for ids typed into public endpoints.
+func (p *pseudonymizer) realSteamID(id SteamID) SteamID {
+	if p == nil || id == 0 {
+		return id
+	}
+	high := uint64(id) &^ 0xFFFFFFFF
+	return SteamID(high | uint64(p.feistel(uint32(id), true)))
+}
+
+var pseudonymAdjectives = []string{
+	"Amber", "Brave", "Calm", "Clever", "Cosmic", "Crimson", "Dusty", "Eager",
+	"Fierce", "Gentle", "Golden", "Hidden", "Jolly", "Lucky", "Misty", "Nimble",
+	"Quiet", "Rapid", "Rusty", "Silent", "Silver", "Sleepy", "Swift", "Wild",
+}
+
+var pseudonymAnimals = []string{
+	"Badger", "Bison", "Crow", "Falcon", "Ferret", "Fox", "Gecko", "Heron",
+	"Ibex", "Koala", "Lynx", "Marten", "Moose", "Newt", "Otter", "Owl",
+	"Panda", "Raven", "Salmon", "Stoat", "Tapir", "Toad", "Walrus", "Wolf",
+}
+
+// name generates the public persona of a real id, e.g. "Swift Otter 4821".
+func (p *pseudonymizer) name(id SteamID, real string) string {
+	if p == nil {
+		return real
+	}
+	sum := p.mac("name", p.steamID(id).String())
+	adj := pseudonymAdjectives[int(sum[0])%len(pseudonymAdjectives)]
+	animal := pseudonymAnimals[int(sum[1])%len(pseudonymAnimals)]
+	return adj + " " + animal + " " + strconv.Itoa(int(binary.BigEndian.Uint16(sum[2:4]))%10000)
+}
+
+// avatar replaces the Steam avatar with an identicon seeded by the
+// pseudonym, so nothing about the real picture is requested either.
+func (p *pseudonymizer) avatar(id SteamID, real string) string {
+	if p == nil {
+		return real
+	}
+	sum := p.mac("avatar", p.steamID(id).String())
+	return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:16]) + "?d=identicon&f=y&s=184"
+}
+
+func (p *pseudonymizer) profile(profile UserProfile) UserProfile {
+	if p == nil {
+		return profile
+	}
+	return UserProfile{
+		SteamID:     p.steamID(profile.SteamID),
+		DisplayName: p.name(profile.SteamID, profile.DisplayName),
+		AvatarURL:   p.avatar(profile.SteamID, profile.AvatarURL),
+	}
+}
+
+// publicSuggestions is readUserSuggestionsFromDB for public endpoints. When
+// pseudonymized, ?q= matches the pseudonyms instead of the real names and
+// ids, so searching cannot confirm who is in the database.
+func (s *Server) publicSuggestions(query string, limit int) ([]UserSuggestion, error) {
+	p := pseudonyms
+	if p == nil {
+		return s.readUserSuggestionsFromDB(query, limit)
+	}
+	all, err := s.readUserSuggestionsFromDB("", -1)
+	if err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(query)
+	out := make([]UserSuggestion, 0, limit)
+	for _, sug := range all {
+		pub := UserSuggestion{
+			SteamID:       p.steamID(sug.SteamID),
+			DisplayName:   p.name(sug.SteamID, sug.DisplayName),
+			GamesCount:    sug.GamesCount,
+			AvgCompletion: sug.AvgCompletion,
+		}
+		if query != "" && !strings.Contains(strings.ToLower(pub.DisplayName), query) && !strings.Contains(pub.SteamID.String(), query) {
+			continue
+		}
+		out = append(out, pub)
+		if len(out) == limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// resolvePseudonymInput maps public input back to a real id: a typed
+// pseudonymous SteamID is reversed, and a name is looked up among the
+// generated names of known players.
+func (s *Server) resolvePseudonymInput(v string) (SteamID, error) {
+	p := pseudonyms
+	if id, ok, err := parseSteamID(v); ok {
+		if err != nil {
+			return 0, err
+		}
+		return p.realSteamID(id), nil
+	}
+	all, err := s.readUserSuggestionsFromDB("", -1)
+	if err != nil {
+		return 0, err
+	}
+	for _, sug := range all {
+		if strings.EqualFold(p.name(sug.SteamID, ""), v) {
+			return sug.SteamID, nil
+		}
+	}
+	return 0, errors.New("unknown profile name")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func usePseudonyms(t *testing.T, salt string) *pseudonymizer {
+	t.Helper()
+	saved := pseudonyms
+	pseudonyms = &pseudonymizer{key: []byte(salt)}
+	t.Cleanup(func() { pseudonyms = saved })
+	return pseudonyms
+}
+
+func TestPseudonymSteamIDs(t *testing.T) {
+	p := &pseudonymizer{key: []byte("demo-salt")}
+	again := &pseudonymizer{key: []byte("demo-salt")}
+	other := &pseudonymizer{key: []byte("other-salt")}
+	seen := make(map[SteamID]SteamID)
+	for n := uint64(0); n < 2000; n++ {
+		real := SteamID(steamID64Base + n*7919)
+		pub := p.steamID(real)
+		if back := p.realSteamID(pub); back != real {
+			t.Fatalf("%d -> %d -> %d", real, pub, back)
+		}
+		if again.steamID(real) != pub || again.name(real, "") != p.name(real, "") {
+			t.Fatalf("%d: pseudonym changed with the same salt", real)
+		}
+		if uint64(pub)>>32 != uint64(real)>>32 {
+			t.Fatalf("%d -> %d: not an individual SteamID64 of the same universe", real, pub)
+		}
+		if prev, dup := seen[pub]; dup {
+			t.Fatalf("%d and %d share the pseudonym %d", prev, real, pub)
+		}
+		seen[pub] = real
+	}
+	if real := SteamID(76561197960287930); other.steamID(real) == p.steamID(real) {
+		t.Error("two salts give the same pseudonym")
+	}
+
+	var off *pseudonymizer
+	if off.steamID(76561197960287930) != 76561197960287930 || off.name(1, "Testeur") != "Testeur" || off.avatar(1, "a.jpg") != "a.jpg" {
+		t.Error("a nil pseudonymizer changed its input")
+	}
+}
+
+// No public response names the real player, in its body or its headers.
+func TestPseudonymizedResponsesHideRealPlayer(t *testing.T) {
+	const real, friend SteamID = 76561197960287930, 76561197960287931
+	fakeSteamGame(t).handle("GetPlayerAchievements", 200, fakePlayerJSON)
+	s := newTestServer(t)
+	p := usePseudonyms(t, "demo-salt")
+	pub, pubFriend := p.steamID(real).String(), p.steamID(friend).String()
+	get := playerMux(t, s)
+
+	secrets := []string{real.String(), friend.String(), fmt.Sprint(uint64(real) - steamID64Base), "Testeur", "example.org/a.jpg"}
+	for _, path := range []string{
+		"/api/users/profile?steamId=" + pub,
+		"/api/users/games?steamId=" + pub,
+		"/api/users/achievements?appId=105600&steamId=" + pub,
+		"/api/users/suggestions",
+		"/api/users/suggestions?q=" + pub[len(pub)-5:],
+		"/api/bootstrap?steamId=" + pub,
+		"/api/players/" + pub + "/achievements?appId=105600",
+		"/api/compare?appId=105600&steamids=" + pub + "," + pubFriend,
+	} {
+		w := get(path)
+		if w.Code != 200 {
+			t.Errorf("%s: %d %s", path, w.Code, w.Body)
+			continue
+		}
+		dump := w.Body.String() + fmt.Sprint(w.Header())
+		for _, secret := range secrets {
+			if strings.Contains(dump, secret) {
+				t.Errorf("%s: response contains %q", path, secret)
+			}
+		}
+	}
+
+	w := get("/api/users/profile?steamId=" + pub)
+	if body := w.Body.String(); !strings.Contains(body, pub) || !strings.Contains(body, p.name(real, "")) || !strings.Contains(body, "identicon") {
+		t.Errorf("profile without the pseudonym: %s", body)
+	}
+	// The generated name can be typed back in.
+	if id, err := s.resolveSteamIDInput(p.name(real, "")); err != nil || id != real {
+		t.Errorf("resolve %q = %d, %v", p.name(real, ""), id, err)
+	}
+	if _, err := s.resolveSteamIDInput("Testeur"); err == nil {
+		t.Error("the real name resolves a pseudonymized player")
+	}
+}
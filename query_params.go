@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// enumParam is a query parameter restricted to a fixed set of values.
+// Values are matched case-insensitively, synonyms map to a canonical value,
+// and errors list what is accepted.
+type enumParam struct {
+	name     string
+	accepted []string
+	synonyms map[string]string
+}
+
+var boolSynonyms = map[string]string{
+	"1": "true", "yes": "true", "y": "true", "on": "true",
+	"0": "false", "no": "false", "n": "false", "off": "false",
+}
+
+// withSynonyms is base plus extra, for a parameter extending a shared set.
+func withSynonyms(base, extra map[string]string) map[string]string {
+	out := maps.Clone(base)
+	maps.Copy(out, extra)
+	return out
+}
+
+var (
+	tagModeParam   = enumParam{name: "tagMode", accepted: []string{tagModeAny, tagModeAll}, synonyms: map[string]string{"or": tagModeAny, "and": tagModeAll}}
+	profileParam   = enumParam{name: "profile", accepted: []string{profileFull, profileLite}}
+	rarityParam    = enumParam{name: "rarity", accepted: []string{rarityCommon, rarityUncommon, rarityRare, rarityUltraRare}, synonyms: map[string]string{"ultra-rare": rarityUltraRare, "ultra_rare": rarityUltraRare}}
+	refreshParam   = enumParam{name: "refresh", accepted: []string{"true", "false"}, synonyms: boolSynonyms}
+	numLocaleParam = enumParam{
+		name:     "numlocale",
+		accepted: []string{numLocaleFrench, numLocaleEnglish},
+		synonyms: map[string]string{"french": numLocaleFrench, "fr-fr": numLocaleFrench, "english": numLocaleEnglish, "en-us": numLocaleEnglish, "en-gb": numLocaleEnglish},
+	}
+)
+
+// normalize returns the canonical value of raw, or "" when raw is empty.
+func (p enumParam) normalize(raw string) (string, error) {
+	v := strings.ToLower(strings.TrimSpace(raw))
+	if v == "" {
+		return "", nil
+	}
+	for _, a := range p.accepted {
+		if v == strings.ToLower(a) {
+			return a, nil
+		}
+	}
+	if c, ok := p.synonyms[v]; ok {
+		return c, nil
+	}
+	return "", fmt.Errorf("%s must be one of: %s (got %q)", p.name, strings.Join(p.accepted, ", "), raw)
+}
+
+// fromQuery reads p from v, falling back to def when absent. Understood
+// values are appended to notes as "name=value".
+func (p enumParam) fromQuery(v url.Values, def string, notes *[]string) (string, error) {
+	c, err := p.normalize(v.Get(p.name))
+	if err != nil || c == "" {
+		return def, err
+	}
+	*notes = append(*notes, p.name+"="+c)
+	return c, nil
+}
+
+// writeNormalizedParams tells callers how their enum values were understood.
+func writeNormalizedParams(w http.ResponseWriter, notes []string) {
+	if len(notes) > 0 {
+		w.Header().Add("X-Query-Normalized", strings.Join(notes, ","))
+	}
+}
+
+// appIDQuery is the raw ?appId= of r; the all-lowercase ?appid= spelling
+// of the Steam Web API is accepted too.
+func appIDQuery(r *http.Request) string {
+	q := r.URL.Query()
+	if v := q.Get("appId"); v != "" {
+		return v
+	}
+	return q.Get("appid")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEnumParams(t *testing.T) {
+	tests := []struct {
+		param enumParam
+		in    map[string]string // raw value -> canonical, "" for a 400
+	}{
+		{tagModeParam, map[string]string{"any": tagModeAny, "ALL": tagModeAll, " Or ": tagModeAny, "and": tagModeAll, "some": ""}},
+		{profileParam, map[string]string{"Full": profileFull, "LITE": profileLite, "small": ""}},
+		{rarityParam, map[string]string{"Common": rarityCommon, "ULTRA-RARE": rarityUltraRare, "ultrarare": rarityUltraRare, "legendary": ""}},
+		{refreshParam, map[string]string{"TRUE": "true", "1": "true", "Yes": "true", "off": "false", "maybe": ""}},
+		{numLocaleParam, map[string]string{"FR": numLocaleFrench, "fr-FR": numLocaleFrench, "English": numLocaleEnglish, "de": ""}},
+		{sortParam, map[string]string{"PCT": sortPct, "globalPct": sortPct, "Name": sortName, "rare": sortRarity, "date": ""}},
+		{orderParam, map[string]string{"ASC": orderAsc, "Ascending": orderAsc, "descending": orderDesc, "up": ""}},
+		{hiddenParam, map[string]string{"Exclude": hiddenExclude, "include": hiddenInclude, "yes": hiddenInclude, "0": hiddenExclude, "ONLY": hiddenOnly, "never": ""}},
+		{noteScopeParam, map[string]string{"Game": noteScopeGame, "app": noteScopeGame, "ACHIEVEMENTS": noteScopeAchievement, "player": ""}},
+		{grayParam, map[string]string{"True": "true", "no": "false", "grey": ""}},
+		{baselineParam, map[string]string{"Owners": baselineEstimatedOwners, "PLAYERS": baselinePlayers, "all": ""}},
+		{langParam, map[string]string{"French": "french", "FR": "french", "pt-BR": "brazilian", "klingon": ""}},
+	}
+	for _, tt := range tests {
+		for raw, want := range tt.in {
+			got, err := tt.param.normalize(raw)
+			if want == "" {
+				if err == nil || !strings.Contains(err.Error(), strings.Join(tt.param.accepted, ", ")) {
+					t.Errorf("%s=%q: %q, %v; want an error listing %v", tt.param.name, raw, got, err, tt.param.accepted)
+				}
+				continue
+			}
+			if err != nil || got != want {
+				t.Errorf("%s=%q: %q, %v; want %q", tt.param.name, raw, got, err, want)
+			}
+		}
+		if got, err := tt.param.normalize("  "); got != "" || err != nil {
+			t.Errorf("%s blank: %q, %v", tt.param.name, got, err)
+		}
+	}
+}
+
+// Synonyms of a parameter do not leak into the shared set they extend.
+func TestWithSynonyms(t *testing.T) {
+	if _, ok := boolSynonyms["exclude"]; ok {
+		t.Error("the hidden synonyms changed boolSynonyms")
+	}
+	if refreshParam.synonyms["exclude"] != "" {
+		t.Error("refresh accepts exclude")
+	}
+}
+
+func TestQueryNormalizedHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/achievements?hidden=Exclude&sort=Percent&order=ASCENDING&rarity=Rare&rarity=rare", nil)
+	q, err := parseAchievementQuery(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.hidden != hiddenExclude || q.order != newAchievementOrder(sortPct, orderAsc) || len(q.rarity) != 1 {
+		t.Errorf("query = hidden %q, order %v, rarity %v", q.hidden, q.order, q.rarity)
+	}
+	w := httptest.NewRecorder()
+	writeNormalizedParams(w, q.normalized)
+	if got, want := w.Header().Get("X-Query-Normalized"), "hidden=false,rarity=rare,sort=pct,order=asc"; got != want {
+		t.Errorf("X-Query-Normalized %q, want %q", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	writeNormalizedParams(w, nil)
+	if _, ok := w.Header()["X-Query-Normalized"]; ok {
+		t.Error("X-Query-Normalized sent without enum parameters")
+	}
+}
+
+func TestUnknownEnumValueIs400(t *testing.T) {
+	s := newTestServer(t)
+	for _, query := range []string{"?hidden=sometimes", "?sort=date", "?tagMode=xor", "?numlocale=de"} {
+		w := getAchievements(s, query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "must be one of") {
+			t.Errorf("%s: %d %s", query, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// Per-client rate limiting of the API routes: each client IP has a token
+// bucket of RATE_LIMIT_BURST (120) requests refilled at RATE_LIMIT_RPS (10)
+// per second. Every answer carries X-RateLimit-Limit, X-RateLimit-Remaining
+// and X-RateLimit-Reset (seconds until the bucket is full again); an empty
+// bucket is answered 429 rate_limited with Retry-After. The icon proxy,
+// which a page calls once per achievement, is not counted, nor are admin
+// routes called with the admin token. Admin calls without it spend from a
+// stricter bucket of adminAuthBurst attempts refilled at adminAuthRPS, so
+// that ADMIN_TOKEN cannot be guessed at the API rate; that one stays on
+// with RATE_LIMIT_RPS=0, which turns the rest off. Both are runtime
+// settings (rateLimitRps, rateLimitBurst), so PATCH /admin/config changes
+// them without a restart; buckets fuller than a lowered burst are cut down
+// on their next request. Behind a reverse proxy, TRUST_PROXY=1 takes the
+// client from the last X-Forwarded-For hop, the one the proxy added;
+// otherwise the header is ignored, since anyone can send it.
+const (
+	defaultRateLimitRPS   = 10
+	defaultRateLimitBurst = 120
+	rateLimitMaxClients   = 10000
+
+	adminAuthRPS   = 0.1
+	adminAuthBurst = 10
+)
+
+type clientRateLimiter struct {
+	config     func() *runtimeConfig
+	trustProxy bool
+	isAdmin    func(*http.Request) bool
+
+	mu      sync.Mutex
+	clients map[string]*clientBucket
+	// adminClients are the buckets of unauthenticated admin calls.
+	adminClients map[string]*clientBucket
+
+	limited atomic.Int64
+}
+
+type clientBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimits is set by main; the check command leaves it nil, which lets
+// every request through.
+var rateLimits *clientRateLimiter
+
+// newClientRateLimiter reads the limits from config on every request;
+// isAdmin tells the admin calls that carry the token.
+func newClientRateLimiter(config func() *runtimeConfig, trustProxy bool, isAdmin func(*http.Request) bool) *clientRateLimiter {
+	return &clientRateLimiter{config: config, trustProxy: trustProxy, isAdmin: isAdmin,
+		clients: make(map[string]*clientBucket), adminClients: make(map[string]*clientBucket)}
+}
+
+// limits returns the tokens per second and the burst; a rate of 0 is off.
+func (l *clientRateLimiter) limits() (rate, burst float64) {
+	cfg := l.config()
+	return float64(cfg.RateLimitRPS), float64(max(cfg.RateLimitBurst, 1))
+}
+
+func (l *clientRateLimiter) clientIP(r *http.Request) string {
+	if l.trustProxy {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(xff[len(xff)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// take spends a token of client in clients. It returns what is left and,
+// when the bucket was empty, how long until the next token.
+func (l *clientRateLimiter) take(clients map[string]*clientBucket, client string, now time.Time, rate, burst float64) (remaining float64, retryAfter time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, found := clients[client]
+	if !found {
+		if len(clients) >= rateLimitMaxClients {
+			pruneBuckets(clients, now, rate, burst)
+		}
+		b = &clientBucket{tokens: burst, last: now}
+		clients[client] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return b.tokens, time.Duration((1 - b.tokens) / rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return b.tokens, 0, true
+}
+
+// pruneBuckets forgets the clients whose bucket has refilled, which is the
+// state a new bucket starts in anyway. The caller holds l.mu.
+func pruneBuckets(clients map[string]*clientBucket, now time.Time, rate, burst float64) {
+	for k, b := range clients {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(clients, k)
+		}
+	}
+	// Still full of active clients: drop an arbitrary one.
+	for k := range clients {
+		if len(clients) < rateLimitMaxClients {
+			break
+		}
+		delete(clients, k)
+	}
+}
+
+// wrap meters the requests of rt; nil and exempt routes pass through, as do
+// admin routes called with the token.
+func (l *clientRateLimiter) wrap(rt apiRoute, next http.Handler) http.Handler {
+	if l == nil || rt.RateLimitExempt && !rt.Admin {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clients := l.clients
+		rate, burst := l.limits()
+		if rt.Admin {
+			if l.isAdmin(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			clients, rate, burst = l.adminClients, adminAuthRPS, adminAuthBurst
+		}
+		if rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		remaining, retryAfter, ok := l.take(clients, l.clientIP(r), time.Now(), rate, burst)
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(int(burst)))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(int(max(remaining, 0))))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil((burst-remaining)/rate))))
+		if !ok {
+			l.limited.Add(1)
+			secs := int(math.Ceil(retryAfter.Seconds()))
+			h.Set("Retry-After", strconv.Itoa(secs))
+			writeCode(w, apperr.CodeRateLimited, secs)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// noRateLimit leaves rt out of the per-client rate limit.
+func (rt apiRoute) noRateLimit() apiRoute {
+	rt.RateLimitExempt = true
+	return rt
+}
+
+type rateLimitStats struct {
+	Enabled    bool    `json:"enabled"`
+	RPS        float64 `json:"rps,omitempty"`
+	Burst      float64 `json:"burst,omitempty"`
+	TrustProxy bool    `json:"trustProxy"`
+	Clients    int     `json:"clients"`
+	Limited    int64   `json:"limited"`
+}
+
+func (l *clientRateLimiter) snapshot() rateLimitStats {
+	if l == nil {
+		return rateLimitStats{}
+	}
+	rate, burst := l.limits()
+	if rate <= 0 {
+		return rateLimitStats{TrustProxy: l.trustProxy, Limited: l.limited.Load()}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return rateLimitStats{Enabled: true, RPS: rate, Burst: burst, TrustProxy: l.trustProxy, Clients: len(l.clients), Limited: l.limited.Load()}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Admin calls with the token are never limited; without it they spend
+// from the admin bucket, even with the API rate limit off.
+func TestRateLimitMetersFailedAdminAuth(t *testing.T) {
+	s := &Server{adminToken: "secret", startupConfig: &runtimeConfig{CacheTTL: time.Hour, AppMetaCacheTTL: time.Hour, RateLimitBurst: 1}}
+	s.runtime.Store(s.startupConfig)
+	limiter := newClientRateLimiter(s.cfg, false, s.isAdminRequest)
+	rt := route("GET", "/admin/ping", []string{apiV1}, "test", func(w http.ResponseWriter, r *http.Request) {}).adminOnly(s)
+	h := limiter.wrap(rt, rt.handler())
+	call := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/ping", nil)
+		r.Header.Set("X-Admin-Token", token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := range adminAuthBurst {
+		if w := call("guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong token %d: %d, want 401", i, w.Code)
+		}
+	}
+	if w := call("guess"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("wrong token past the admin burst: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	for i := range 2 * adminAuthBurst {
+		if w := call("secret"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("admin call %d: %d, limit %q", i, w.Code, w.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// Read-only mode (READ_ONLY=1, or readOnly in PATCH /admin/config) keeps
+// the API up during maintenance while nothing is written and Steam is not
+// called: mutating routes answer 503, syncs are refused so handlers serve
+// what is already stored (flagged X-Data-Stale) or 503 when there is
+// nothing, and the background refreshers pause. Admin routes keep working
+// so the mode can be turned off again.
+const readOnlyRetryAfter = 10 * 60 // seconds
+
+const readOnlyMiddlewareName = "readOnly"
+
+type maintenanceBanner struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func (s *Server) readOnly() bool {
+	return s.cfg().ReadOnly
+}
+
+// banner is the notice the front end shows, nil outside maintenance.
+func (s *Server) banner() *maintenanceBanner {
+	if !s.readOnly() {
+		return nil
+	}
+	return &maintenanceBanner{Kind: "maintenance", Message: "Maintenance en cours: les donnees affichees ne sont pas mises a jour"}
+}
+
+func writeReadOnly(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(readOnlyRetryAfter))
+	writeCode(w, apperr.CodeReadOnly)
+}
+
+func (s *Server) refuseWhenReadOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.readOnly() {
+			writeReadOnly(w)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writes flags rt as mutating: it is refused in read-only mode. Public
+// routes other than GET must carry it, which validateRoutes enforces.
+func (rt apiRoute) writes(s *Server) apiRoute {
+	rt.Middleware = append(rt.Middleware, routeMiddleware{Name: readOnlyMiddlewareName, Wrap: s.refuseWhenReadOnly})
+	return rt
+}
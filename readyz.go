@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	componentOK       = "ok"
+	componentDegraded = "degraded"
+	componentDown     = "down"
+	componentDisabled = "disabled"
+)
+
+const readyProbeTTL = 5 * time.Second
+const readyProbeTimeout = time.Second
+const defaultReadyCritical = "storage"
+
+type readyComponent struct {
+	Status      string     `json:"status"`
+	Critical    bool       `json:"critical"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	CheckedAt   *time.Time `json:"checkedAt,omitempty"`
+}
+
+type readyResponse struct {
+	Status     string                    `json:"status"`
+	Components map[string]readyComponent `json:"components"`
+	Cache      string                    `json:"cache"`
+	// Reasons explains, in French, why a cold server is not ready.
+	Reasons []string `json:"reasons,omitempty"`
+	// ReadOnly is informational: maintenance does not make the server
+	// unready, reads are still served.
+	ReadOnly bool `json:"readOnly"`
+}
+
+// readyProbe caches one active check for readyProbeTTL. Concurrent callers
+// share the in-flight result, so a load balancer polling every second
+// costs at most one probe per TTL and a slow dependency is never hit
+// harder because it is slow.
+type readyProbe struct {
+	check func(ctx context.Context) error
+
+	mu          sync.Mutex
+	checkedAt   time.Time
+	err         error
+	lastError   string
+	lastErrorAt time.Time
+}
+
+func (p *readyProbe) result() readyComponent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.checkedAt) > readyProbeTTL {
+		ctx, cancel := context.WithTimeout(context.Background(), readyProbeTimeout)
+		p.err = p.check(ctx)
+		cancel()
+		p.checkedAt = time.Now()
+		if p.err != nil {
+			p.lastError, p.lastErrorAt = p.err.Error(), p.checkedAt
+		}
+	}
+	c := readyComponent{Status: componentOK, LastError: p.lastError}
+	if p.err != nil {
+		c.Status = componentDown
+	}
+	checkedAt := p.checkedAt
+	c.CheckedAt = &checkedAt
+	if !p.lastErrorAt.IsZero() {
+		at := p.lastErrorAt
+		c.LastErrorAt = &at
+	}
+	return c
+}
+
+type readiness struct {
+	critical map[string]bool
+	storage  *readyProbe
+	redis    *readyProbe // nil without REDIS_URL
+	alerts   *alertNotifier
+	now      func() time.Time
+
+	mu        sync.Mutex
+	lastReady string // "" until the first check
+	downSince time.Time
+}
+
+// newReadiness reads READY_CRITICAL, the comma-separated components whose
+// failure makes the server unready (default: storage). Any other failing
+// component only degrades it.
+func newReadiness(s *Server) *readiness {
+	rd := &readiness{critical: make(map[string]bool), now: time.Now}
+	if upstreamHealth != nil {
+		rd.alerts = upstreamHealth.alerts
+	}
+	for _, name := range strings.Split(getenv("READY_CRITICAL", defaultReadyCritical), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			rd.critical[name] = true
+		}
+	}
+	rd.storage = &readyProbe{check: s.db.PingContext}
+	if raw := cleanEnvValue(os.Getenv("REDIS_URL")); raw != "" {
+		rd.redis = &readyProbe{check: func(ctx context.Context) error { return pingRedis(ctx, raw) }}
+	}
+	return rd
+}
+
+func (s *Server) readyState() readyResponse {
+	rd := s.ready
+	out := readyResponse{Components: make(map[string]readyComponent)}
+
+	// Steam is judged from the traffic we already send, never probed.
+	steamStatus, steamErr, steamErrAt := upstreamHealth.state()
+	steam := readyComponent{Status: steamStatus, LastError: steamErr}
+	if !steamErrAt.IsZero() {
+		steam.LastErrorAt = &steamErrAt
+	}
+	out.Components["steam"] = steam
+	out.Components["storage"] = rd.storage.result()
+	if rd.redis != nil {
+		out.Components["redis"] = rd.redis.result()
+	} else {
+		out.Components["redis"] = readyComponent{Status: componentDisabled}
+	}
+
+	out.Status = componentOK
+	for name, c := range out.Components {
+		c.Critical = rd.critical[name]
+		out.Components[name] = c
+		switch {
+		case c.Status == componentDown && c.Critical:
+			out.Status = componentDown
+		case c.Status != componentOK && c.Status != componentDisabled && out.Status == componentOK:
+			out.Status = componentDegraded
+		}
+	}
+
+	out.Cache = "cold"
+	s.cacheMu.RLock()
+	warm := len(s.appSchemaCache) > 0 || len(s.appGlobalPctMap) > 0
+	s.cacheMu.RUnlock()
+	if synced, err := s.lastSyncAt(context.Background()); warm || (err == nil && !synced.IsZero()) {
+		out.Cache = "warm"
+	}
+	// Nothing to serve yet: ready only once a Steam call has worked.
+	if out.Cache == "cold" && metrics.lastUpstreamSuccess.Load() == 0 {
+		out.Status = componentDown
+		out.Reasons = append(out.Reasons, "Cache vide et aucun appel Steam reussi depuis le demarrage")
+	}
+	out.ReadOnly = s.readOnly()
+	rd.observe(out)
+	return out
+}
+
+// observe raises not_ready when a ready server turns unready, and ready
+// when it comes back. A server that starts unready (cold cache) raises
+// nothing until it has been ready once.
+func (rd *readiness) observe(st readyResponse) {
+	now := rd.now()
+	rd.mu.Lock()
+	prev := rd.lastReady
+	rd.lastReady = st.Status
+	var alert *alertPayload
+	switch {
+	case st.Status == componentDown && prev != "" && prev != componentDown:
+		rd.downSince = now
+		alert = &alertPayload{Type: alertNotReady, Error: readyFailureSummary(st), Duration: "0s"}
+	case st.Status != componentDown && prev == componentDown && !rd.downSince.IsZero():
+		alert = &alertPayload{Type: alertReady, Duration: now.Sub(rd.downSince).Round(time.Second).String()}
+		rd.downSince = time.Time{}
+	}
+	rd.mu.Unlock()
+	if alert != nil {
+		rd.alerts.notify(*alert)
+	}
+}
+
+// readyFailureSummary names the critical components that are down, then
+// the reasons of a cold server.
+func readyFailureSummary(st readyResponse) string {
+	var parts []string
+	for _, name := range []string{"steam", "storage", "redis"} {
+		if c, ok := st.Components[name]; ok && c.Critical && c.Status == componentDown {
+			part := name
+			if c.LastError != "" {
+				part += ": " + c.LastError
+			}
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(append(parts, st.Reasons...), "; ")
+}
+
+// handleReadyz answers load balancers with the status code alone (503 when
+// a critical component is down, or when the cache is cold and Steam has
+// not answered yet); ?verbose=1 adds the last error of each component for
+// humans.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	state := s.readyState()
+	if r.URL.Query().Get("verbose") != "1" {
+		for name, c := range state.Components {
+			state.Components[name] = readyComponent{Status: c.Status, Critical: c.Critical}
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if state.Status == componentDown {
+		writeJSONStatus(w, http.StatusServiceUnavailable, state)
+		return
+	}
+	writeJSON(w, state)
+}
+
+// handleHealthz only tells the process is up and serving: 200, always.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]string{"status": componentOK})
+}
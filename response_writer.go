@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultResponseBufferLimit = 1 << 20 // 1 MiB
+
+// responseBufferLimit is the size under which a response is fully buffered
+// (Content-Length + ETag). Larger responses stream with chunked encoding.
+var responseBufferLimit = defaultResponseBufferLimit
+
+type sizingWriter struct {
+	w         http.ResponseWriter
+	status    int
+	limit     int
+	buf       bytes.Buffer
+	streaming bool
+}
+
+func newSizingWriter(w http.ResponseWriter, status int) *sizingWriter {
+	return &sizingWriter{w: w, status: status, limit: responseBufferLimit}
+}
+
+func (sw *sizingWriter) Write(p []byte) (int, error) {
+	if sw.streaming {
+		return sw.w.Write(p)
+	}
+	if sw.buf.Len()+len(p) <= sw.limit {
+		return sw.buf.Write(p)
+	}
+
+	// Threshold crossed: flush what we have and switch to streaming.
+	sw.streaming = true
+	h := sw.w.Header()
+	h.Del("Content-Length")
+	h.Del("ETag")
+	sw.w.WriteHeader(sw.status)
+	if sw.buf.Len() > 0 {
+		if _, err := sw.w.Write(sw.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		sw.buf.Reset()
+	}
+	return sw.w.Write(p)
+}
+
+// Close sends the buffered response. It is a no-op once streaming started.
+func (sw *sizingWriter) Close() error {
+	if sw.streaming {
+		return nil
+	}
+
+	body := sw.buf.Bytes()
+	h := sw.w.Header()
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if sw.status >= 200 && sw.status <= 299 {
+		h.Set("ETag", computeETag(body))
+	}
+	sw.w.WriteHeader(sw.status)
+	_, err := sw.w.Write(body)
+	return err
+}
+
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// responseEncoder writes a response body in one format.
+type responseEncoder struct {
+	contentType string
+	encode      func(w io.Writer, v any) error
+}
+
+var jsonEncoder = responseEncoder{contentType: "application/json; charset=utf-8", encode: func(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}}
+
+// writeEncoded writes v with enc through a sizingWriter, so small bodies
+// get a Content-Length and an ETag.
+func writeEncoded(w http.ResponseWriter, status int, enc responseEncoder, v any) {
+	w.Header().Set("Content-Type", enc.contentType)
+	sw := newSizingWriter(w, status)
+	_ = enc.encode(sw, v)
+	_ = sw.Close()
+}
+
+// writeCompactJSON writes v without indentation, gzipped when the client
+// accepts it. Used by the lite payload profile.
+func writeCompactJSON(w http.ResponseWriter, r *http.Request, v any) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Add("Vary", "Accept-Encoding")
+	sw := newSizingWriter(w, http.StatusOK)
+	if acceptsGzip(r) {
+		h.Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(sw)
+		_ = json.NewEncoder(gz).Encode(v)
+		_ = gz.Close()
+	} else {
+		_ = json.NewEncoder(sw).Encode(v)
+	}
+	_ = sw.Close()
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
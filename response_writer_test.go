@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSizingWriterBoundary(t *testing.T) {
+	if defaultResponseBufferLimit != 1<<20 {
+		t.Fatalf("default limit %d, want 1 MiB", defaultResponseBufferLimit)
+	}
+	limit := defaultResponseBufferLimit
+	tests := []struct {
+		name     string
+		size     int
+		buffered bool
+	}{
+		{"limit-1", limit - 1, true},
+		{"limit", limit, true},
+		{"limit+1", limit + 1, false},
+	}
+	for _, tt := range tests {
+		body := bytes.Repeat([]byte("a"), tt.size)
+		// In one write, and in two that cross the limit on the second one.
+		for _, chunks := range [][][]byte{{body}, {body[:limit/2], body[limit/2:]}} {
+			w := httptest.NewRecorder()
+			sw := &sizingWriter{w: w, status: http.StatusOK, limit: limit}
+			for _, c := range chunks {
+				if n, err := sw.Write(c); err != nil || n != len(c) {
+					t.Fatalf("%s: Write = %d, %v", tt.name, n, err)
+				}
+			}
+			if err := sw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			cl, etag := w.Header().Get("Content-Length"), w.Header().Get("ETag")
+			if tt.buffered && (cl != strconv.Itoa(tt.size) || etag != computeETag(body)) {
+				t.Errorf("%s in %d writes: Content-Length %q, ETag %q; want %d and the body hash", tt.name, len(chunks), cl, etag, tt.size)
+			}
+			if !tt.buffered && (cl != "" || etag != "") {
+				t.Errorf("%s in %d writes streamed with Content-Length %q, ETag %q", tt.name, len(chunks), cl, etag)
+			}
+			if w.Code != http.StatusOK || w.Body.Len() != tt.size {
+				t.Errorf("%s in %d writes: %d, %d bytes", tt.name, len(chunks), w.Code, w.Body.Len())
+			}
+		}
+	}
+}
+
+// A streamed response drops the headers set for the buffered one.
+func TestSizingWriterDropsSizeHeadersWhenStreaming(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Length", "3")
+	w.Header().Set("ETag", `"old"`)
+	sw := &sizingWriter{w: w, status: http.StatusOK, limit: 4}
+	sw.Write([]byte("abc"))
+	sw.Write([]byte("de"))
+	sw.Close()
+	if w.Header().Get("Content-Length") != "" || w.Header().Get("ETag") != "" || w.Body.String() != "abcde" {
+		t.Errorf("headers %v, body %q", w.Header(), w.Body)
+	}
+}
+
+func TestSizingWriterErrorHasNoETag(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusBadGateway} {
+		w := httptest.NewRecorder()
+		writeEncoded(w, status, jsonEncoder, map[string]string{"error": "not_found"})
+		if w.Code != status || w.Header().Get("ETag") != "" {
+			t.Errorf("%d: code %d, ETag %q", status, w.Code, w.Header().Get("ETag"))
+		}
+		if w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+			t.Errorf("%d: Content-Length %q for %d bytes", status, w.Header().Get("Content-Length"), w.Body.Len())
+		}
+	}
+	w := httptest.NewRecorder()
+	writeEncoded(w, http.StatusCreated, jsonEncoder, map[string]string{"ok": "1"})
+	if w.Header().Get("ETag") == "" {
+		t.Errorf("201 has no ETag")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	apiV1 = "v1"
+)
+
+// knownAPIVersions lists every version the router can serve, oldest first.
+var knownAPIVersions = []string{apiV1}
+
+// apiRoute is one endpoint of the registry. Path is relative to the version
+// prefix: "/achievements" is served at /api/v1/achievements and, when v1 is
+// the default version, at /api/achievements. Versions must be listed
+// explicitly; there is no "all versions" shortcut. Middleware is applied by
+// the registry, first entry outermost; Admin routes must include the admin
+// middleware, which validateRoutes enforces.
+type apiRoute struct {
+	Method   string
+	Path     string
+	Versions []string
+	Summary  string
+	Example  string // query string for the /api index, e.g. "?steamId=..."
+	Admin    bool
+	// RateLimitExempt leaves the route out of the per-client rate limit.
+	RateLimitExempt bool
+	Middleware      []routeMiddleware
+	Handler         http.HandlerFunc
+}
+
+type routeMiddleware struct {
+	Name string
+	Wrap func(http.HandlerFunc) http.HandlerFunc
+}
+
+const adminMiddlewareName = "admin"
+
+func route(method, path string, versions []string, summary string, h http.HandlerFunc) apiRoute {
+	return apiRoute{Method: method, Path: path, Versions: versions, Summary: summary, Handler: h}
+}
+
+// adminOnly flags rt as an admin route and gates it behind the admin token.
+func (rt apiRoute) adminOnly(s *Server) apiRoute {
+	rt.Admin = true
+	rt.Middleware = append(rt.Middleware, routeMiddleware{Name: adminMiddlewareName, Wrap: s.requireAdmin})
+	return rt
+}
+
+func (rt apiRoute) example(query string) apiRoute {
+	rt.Example = query
+	return rt
+}
+
+func (rt apiRoute) handler() http.HandlerFunc {
+	h := rt.Handler
+	for i := len(rt.Middleware) - 1; i >= 0; i-- {
+		h = rt.Middleware[i].Wrap(h)
+	}
+	return h
+}
+
+func (s *Server) apiRoutes() []apiRoute {
+	v1 := []string{apiV1}
+	return []apiRoute{
+		route("GET", "/achievements", v1, "Global achievement list (bare array, {total, limit, offset, items} when paged), filterable and sortable, ?asOf= for archives, ?lang= for the language, ?format=json|csv|xlsx|html", s.handleAchievements).jsonp(s).hotlinkProtected(s, isExportRequest),
+		route("GET", "/achievements/export", v1, "Achievement list of ?appId= as a download, ?format=csv|xlsx, with the unlocks of ?steamId= when given", s.handleAchievementsExport).example("?format=xlsx").hotlinkProtected(s, anyRequest),
+		route("GET", "/achievements/stats", v1, "Achievement count per rarity tier, median and mean global percent, rarest and most common of ?appId=, with the list filters", s.handleAchievementStats).jsonp(s),
+		route("GET", "/achievements/diff", v1, "Snapshot diff between ?from= and ?to= (YYYY-MM-DD)", s.handleAchievementsDiff).example("?from=2024-11-01&to=2025-01-01"),
+		route("GET", "/achievements/{apiName}/history", v1, "Recorded global percentages of one achievement of ?appId=, oldest first, ?from=&to= (YYYY-MM-DD)", s.handleAchievementHistory),
+		route("GET", "/icon/{apiName}", v1, "Achievement icon of ?appId= through the local cache, ?gray=1 for the locked one (PROXY_ICONS)", s.handleIcon).hotlinkProtected(s, anyRequest).noRateLimit(),
+		route("GET", "/icons/{apiName}", v1, "Same as /icon/{apiName}", s.handleIcon).hotlinkProtected(s, anyRequest).noRateLimit(),
+		route("GET", "/icon-sprite", v1, "Manifest of the icon sprite sheet of ?appId=: the cell of each apiName and the sheet URL (PROXY_ICONS)", s.handleSpriteManifest),
+		route("GET", "/icon-sprite.png", v1, "Icon sprite sheet of ?appId=, ?v= from the manifest (PROXY_ICONS)", s.handleSpriteSheet).hotlinkProtected(s, anyRequest).noRateLimit(),
+		route("GET", "/users/suggestions", v1, "Known players matching ?q=", s.handleUserSuggestions).example("?q=a"),
+		route("GET", "/users/profile", v1, "Player profile for ?steamId=", s.handleUserProfile).example("?steamId=" + exampleSteamID).upstream(featurePlayerFetch),
+		route("GET", "/users/games", v1, "Owned games with completion for ?steamId=", s.handleUserGames).example("?steamId=" + exampleSteamID).upstream(featurePlayerFetch),
+		route("GET", "/users/achievements", v1, "Player achievements for ?steamId=&appId=", s.handleUserAchievements).example("?steamId=" + exampleSteamID + "&appId=105600").upstream(featurePlayerFetch),
+		route("GET", "/tags", v1, "Known achievement tags with counts", s.handleTags).jsonp(s),
+		route("GET", "/terraria/progression", v1, "Terraria boss progression stages", s.handleTerrariaProgression).jsonp(s),
+		route("GET", "/config", v1, "Public configuration", s.handleConfig),
+		route("GET", "/webhooks/schema", v1, "JSON Schema of the webhook payloads", s.handleWebhookSchema),
+		route("GET", "/bootstrap", v1, "Landing page data in one request", s.handleBootstrap),
+		route("GET", "/events", v1, "Server-sent events, ?topics=", s.handleEvents).example("?topics=refresh"),
+		route("GET", "/players/{steamid}/achievements", v1, "One player's unlocks merged into the achievement list of ?appId=", s.handlePlayerAchievements).example("?appId=105600").upstream(featurePlayerFetch),
+		route("GET", "/player/{steamid}/achievements", v1, "Same as /players/{steamid}/achievements", s.handlePlayerAchievements).upstream(featurePlayerFetch),
+		route("GET", "/me/achievements", v1, "Unlocks of the player logged in through /auth/steam/login merged into the achievement list of ?appId=", s.handleMyAchievements).upstream(featurePlayerFetch),
+		route("GET", "/compare", v1, "Unlocks of ?steamids= (comma separated) side by side on ?appId=, with what the group still misses, easiest first", s.handleCompare).upstream(featurePlayerFetch),
+		route("POST", "/players/{steamid}/refresh", v1, "Queue a refresh of one player", s.handlePlayerRefresh).writes(s),
+		route("GET", "/admin/config", v1, "Runtime configuration (admin)", s.handleAdminConfig).adminOnly(s),
+		route("PATCH", "/admin/config", v1, "Override runtime settings (admin)", s.handleAdminConfig).adminOnly(s),
+		route("GET", "/admin/cache", v1, "In-memory cache entries (admin)", s.handleAdminCache).adminOnly(s),
+		route("GET", "/admin/cache/keys", v1, "Canonical cache keys, filtered by ?prefix= (admin)", s.handleAdminCacheKeys).example("?prefix=ach:").adminOnly(s),
+		route("GET", "/admin/scheduler", v1, "Background refresh queue (admin)", s.handleAdminScheduler).adminOnly(s),
+		route("GET", "/admin/writes", v1, "Write queue backlog and counters, history snapshots waiting for the store (admin)", s.handleAdminWrites).adminOnly(s),
+		route("GET", "/admin/storage", v1, "Database file sizes, rows per table and the last history compaction (admin)", s.handleAdminStorage).adminOnly(s),
+		route("GET", "/admin/usage", v1, "Anonymous usage counters over ?days= (admin)", s.handleAdminUsage).example("?days=7").adminOnly(s),
+		route("GET", "/admin/limits", v1, "Input limits and early rejection counts (admin)", s.handleAdminLimits).adminOnly(s),
+		route("GET", "/admin/notes", v1, "Internal notes, ?scope=game|achievement (admin)", s.handleAdminNotes).example("?scope=game").adminOnly(s),
+		route("GET", "/admin/notes/{scope}/{id}", v1, "Notes of one game (appId) or achievement (appId:apiName) (admin)", s.handleAdminObjectNotes).adminOnly(s),
+		route("PUT", "/admin/notes/{scope}/{id}", v1, "Add a note, or edit noteId, on a game or achievement (admin)", s.handleAdminObjectNotes).adminOnly(s).writes(s),
+		route("DELETE", "/admin/notes/{scope}/{id}", v1, "Delete ?noteId= or every note of a game or achievement (admin)", s.handleAdminObjectNotes).adminOnly(s).writes(s),
+		route("GET", "/admin/verify", v1, "Fresh upstream fetch compared with the cache and the latest snapshot, ?tolerance= (admin)", s.handleAdminVerify).example("?appId=105600").adminOnly(s).upstream(featureAdminVerify),
+		route("GET", "/admin/inflight", v1, "Requests in flight by route class, with the oldest (admin)", s.handleAdminInflight).adminOnly(s),
+		route("GET", "/admin/webhooks", v1, "Webhook endpoints, delivery counters and dead letters (admin)", s.handleAdminWebhooks).adminOnly(s),
+		route("GET", "/admin/webhooks/captured", v1, "Webhook payloads captured by WEBHOOKS_TEST_MODE (admin)", s.handleAdminWebhooksCaptured).adminOnly(s),
+		route("DELETE", "/admin/webhooks/captured", v1, "Forget the captured webhook payloads (admin)", s.handleAdminWebhooksCaptured).adminOnly(s),
+		route("GET", "/admin/upstream", v1, "Upstream response sizes, compressed and decoded (admin)", s.handleAdminUpstream).adminOnly(s),
+	}
+}
+
+// openAPIRegistry lists the operations each version documents, as
+// "METHOD /path" relative to the version prefix. It is kept apart from the
+// route table so that a route added without being documented, or a
+// documented one that was removed, fails validation.
+var openAPIRegistry = map[string][]string{
+	apiV1: {
+		"GET /achievements",
+		"GET /achievements/export",
+		"GET /achievements/stats",
+		"GET /achievements/diff",
+		"GET /achievements/{apiName}/history",
+		"GET /icon/{apiName}",
+		"GET /icons/{apiName}",
+		"GET /icon-sprite",
+		"GET /icon-sprite.png",
+		"GET /users/suggestions",
+		"GET /users/profile",
+		"GET /users/games",
+		"GET /users/achievements",
+		"GET /tags",
+		"GET /terraria/progression",
+		"GET /config",
+		"GET /webhooks/schema",
+		"GET /bootstrap",
+		"GET /events",
+		"GET /players/{steamid}/achievements",
+		"GET /player/{steamid}/achievements",
+		"GET /me/achievements",
+		"GET /compare",
+		"POST /players/{steamid}/refresh",
+		"GET /admin/config",
+		"PATCH /admin/config",
+		"GET /admin/cache",
+		"GET /admin/cache/keys",
+		"GET /admin/scheduler",
+		"GET /admin/writes",
+		"GET /admin/storage",
+		"GET /admin/usage",
+		"GET /admin/limits",
+		"GET /admin/notes",
+		"GET /admin/notes/{scope}/{id}",
+		"PUT /admin/notes/{scope}/{id}",
+		"DELETE /admin/notes/{scope}/{id}",
+		"GET /admin/verify",
+		"GET /admin/inflight",
+		"GET /admin/webhooks",
+		"GET /admin/webhooks/captured",
+		"DELETE /admin/webhooks/captured",
+		"GET /admin/upstream",
+	},
+}
+
+// validateRoutes checks the whole table before anything is mounted, so a
+// bad table fails at startup with every problem listed instead of a mux
+// panic or an unprotected admin route in production. registry is checked
+// against the table both ways. The table is the /api/ surface only: the
+// probes (/readyz, /healthz), /metrics, the /auth/ login flow and the
+// static files are mounted by main outside any version and not validated.
+func validateRoutes(routes []apiRoute, registry map[string][]string, defaultVersion string) error {
+	known := make(map[string]bool, len(knownAPIVersions))
+	for _, v := range knownAPIVersions {
+		known[v] = true
+	}
+	var errs []error
+	if !known[defaultVersion] {
+		errs = append(errs, fmt.Errorf("unknown default API version %q (known: %s)", defaultVersion, strings.Join(knownAPIVersions, ", ")))
+	}
+
+	documented := make(map[string]map[string]bool, len(registry))
+	for v, ops := range registry {
+		documented[v] = make(map[string]bool, len(ops))
+		for _, op := range ops {
+			documented[v][op] = true
+		}
+	}
+	served := make(map[string]bool)
+
+	seen := make(map[string]int)
+	for i, rt := range routes {
+		name := strings.TrimSpace(routePattern(rt.Method, rt.Path))
+		if rt.Handler == nil {
+			errs = append(errs, fmt.Errorf("route %s: no handler", name))
+		}
+		if !strings.HasPrefix(rt.Path, "/") {
+			errs = append(errs, fmt.Errorf("route %s: path must start with /", name))
+		}
+		if len(rt.Versions) == 0 {
+			errs = append(errs, fmt.Errorf("route %s: no API version", name))
+		}
+
+		hasAdmin, hasJSONP, hasReadOnly := false, false, false
+		for _, m := range rt.Middleware {
+			if m.Wrap == nil {
+				errs = append(errs, fmt.Errorf("route %s: middleware %q has no function", name, m.Name))
+			}
+			hasAdmin = hasAdmin || m.Name == adminMiddlewareName
+			hasJSONP = hasJSONP || m.Name == jsonpMiddlewareName
+			hasReadOnly = hasReadOnly || m.Name == readOnlyMiddlewareName
+		}
+		if rt.Admin && !hasAdmin {
+			errs = append(errs, fmt.Errorf("route %s: admin route without the %s middleware", name, adminMiddlewareName))
+		}
+		if !rt.Admin && rt.Method != "GET" && !hasReadOnly {
+			errs = append(errs, fmt.Errorf("route %s: public %s route without the %s middleware", name, rt.Method, readOnlyMiddlewareName))
+		}
+		if hasJSONP && (rt.Admin || rt.Method != "GET") {
+			errs = append(errs, fmt.Errorf("route %s: JSONP is only allowed on public GET routes", name))
+		}
+		if strings.HasPrefix(rt.Path, "/admin/") && !rt.Admin {
+			errs = append(errs, fmt.Errorf("route %s: path under /admin/ but not flagged admin", name))
+		}
+		if strings.TrimSpace(rt.Summary) == "" {
+			errs = append(errs, fmt.Errorf("route %s: no summary for the OpenAPI registry", name))
+		}
+
+		for _, v := range rt.Versions {
+			if !known[v] {
+				errs = append(errs, fmt.Errorf("route %s: unknown API version %q", name, v))
+				continue
+			}
+			patterns := []string{routePattern(rt.Method, "/api/"+v+rt.Path)}
+			if v == defaultVersion {
+				patterns = append(patterns, routePattern(rt.Method, "/api"+rt.Path))
+			}
+			for _, p := range patterns {
+				if j, dup := seen[p]; dup {
+					errs = append(errs, fmt.Errorf("route %s: pattern %q already registered by route #%d", name, p, j+1))
+					continue
+				}
+				seen[p] = i
+			}
+			served[v+" "+name] = true
+			if !documented[v][name] {
+				errs = append(errs, fmt.Errorf("route %s: missing from the %s OpenAPI registry", name, v))
+			}
+		}
+	}
+	for _, v := range slices.Sorted(maps.Keys(registry)) {
+		for _, op := range registry[v] {
+			if !served[v+" "+op] {
+				errs = append(errs, fmt.Errorf("%s OpenAPI registry: %s has no route", v, op))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// registerAPIRoutes validates the table, then mounts every route under
+// /api/<version>/ and, for the default version, under the unprefixed /api/
+// paths. Each version also gets its own /api/<version>/openapi.json. Nothing
+// is mounted when validation fails.
+func registerAPIRoutes(mux *http.ServeMux, routes []apiRoute, defaultVersion string) error {
+	if err := validateRoutes(routes, openAPIRegistry, defaultVersion); err != nil {
+		return err
+	}
+
+	for _, rt := range routes {
+		name := routePattern(rt.Method, rt.Path)
+		h := metrics.wrap(name, usage.wrap(name, rateLimits.wrap(rt, withUpstreamFeatureHandler(featureListRefresh, rt.handler()))))
+		for _, v := range rt.Versions {
+			mux.Handle(routePattern(rt.Method, "/api/"+v+rt.Path), withAPIVersion(v, h.ServeHTTP))
+			if v == defaultVersion {
+				mux.Handle(routePattern(rt.Method, "/api"+rt.Path), withAPIVersion(v, h.ServeHTTP))
+			}
+		}
+	}
+
+	for _, v := range knownAPIVersions {
+		doc := openAPIDocument(routes, v)
+		mux.Handle("GET /api/"+v+"/openapi.json", withAPIVersion(v, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, doc)
+		}))
+	}
+	return nil
+}
+
+func routePattern(method, path string) string {
+	if method == "" {
+		return path
+	}
+	return method + " " + path
+}
+
+// withDefaultAPIVersion tags responses that no versioned route handled
+// (static files, 404s) with the default version.
+func withDefaultAPIVersion(version string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-API-Version", version)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withAPIVersion(version string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-API-Version", version)
+		next(w, r)
+	})
+}
+
+func openAPIMethod(method string) string {
+	if method == "" {
+		return "get"
+	}
+	return strings.ToLower(method)
+}
+
+// openAPIDocument describes the routes of one version. It only lists paths,
+// methods and summaries; payload schemas are not described.
+func openAPIDocument(routes []apiRoute, version string) map[string]any {
+	paths := make(map[string]any)
+	for _, rt := range routes {
+		for _, v := range rt.Versions {
+			if v != version {
+				continue
+			}
+			method := openAPIMethod(rt.Method)
+			p := "/api/" + v + rt.Path
+			ops, _ := paths[p].(map[string]any)
+			if ops == nil {
+				ops = make(map[string]any)
+				paths[p] = ops
+			}
+			ops[method] = map[string]any{
+				"summary":   rt.Summary,
+				"responses": map[string]any{"200": map[string]any{"description": "OK"}},
+			}
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": "Yboost achievements API", "version": version},
+		"paths":   paths,
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func noopHandler(w http.ResponseWriter, r *http.Request) {}
+
+// documentedV1 is a registry documenting every v1 route of routes.
+func documentedV1(routes []apiRoute) map[string][]string {
+	var ops []string
+	for _, rt := range routes {
+		if slices.Contains(rt.Versions, apiV1) {
+			ops = append(ops, routePattern(rt.Method, rt.Path))
+		}
+	}
+	return map[string][]string{apiV1: ops}
+}
+
+func TestRouteTableValid(t *testing.T) {
+	s := newTestServer(t)
+	if err := validateRoutes(s.apiRoutes(), openAPIRegistry, apiV1); err != nil {
+		t.Fatalf("the route table is invalid:\n%v", err)
+	}
+}
+
+func TestValidateRoutesErrors(t *testing.T) {
+	s := newTestServer(t)
+	v1 := []string{apiV1}
+	tests := []struct {
+		name   string
+		routes []apiRoute
+		want   string
+	}{
+		{
+			"duplicate pattern",
+			[]apiRoute{route("GET", "/games", v1, "Games", noopHandler), route("GET", "/games", v1, "Games again", noopHandler)},
+			`route GET /games: pattern "GET /api/v1/games" already registered by route #1` + "\n" +
+				`route GET /games: pattern "GET /api/games" already registered by route #1`,
+		},
+		{
+			"admin without the admin middleware",
+			[]apiRoute{{Method: "GET", Path: "/admin/secret", Versions: v1, Summary: "Secret", Admin: true, Handler: noopHandler}},
+			"route GET /admin/secret: admin route without the admin middleware",
+		},
+		{
+			"admin path not flagged",
+			[]apiRoute{route("GET", "/admin/secret", v1, "Secret", noopHandler)},
+			"route GET /admin/secret: path under /admin/ but not flagged admin",
+		},
+		{
+			"public write without read-only gate",
+			[]apiRoute{route("POST", "/games", v1, "Add a game", noopHandler)},
+			"route POST /games: public POST route without the readOnly middleware",
+		},
+		{
+			"JSONP on an admin route",
+			[]apiRoute{route("GET", "/admin/secret", v1, "Secret", noopHandler).adminOnly(s).jsonp(s)},
+			"route GET /admin/secret: JSONP is only allowed on public GET routes",
+		},
+		{
+			"unknown version",
+			[]apiRoute{route("GET", "/games", []string{"v9"}, "Games", noopHandler)},
+			`route GET /games: unknown API version "v9"`,
+		},
+		{
+			"no version",
+			[]apiRoute{route("GET", "/games", nil, "Games", noopHandler)},
+			"route GET /games: no API version",
+		},
+		{
+			"no summary",
+			[]apiRoute{route("GET", "/games", v1, " ", noopHandler)},
+			"route GET /games: no summary for the OpenAPI registry",
+		},
+		{
+			"no handler and bad path",
+			[]apiRoute{route("GET", "games", v1, "Games", nil)},
+			"route GET games: no handler\nroute GET games: path must start with /",
+		},
+		{
+			"middleware without function",
+			[]apiRoute{{Method: "GET", Path: "/games", Versions: v1, Summary: "Games", Middleware: []routeMiddleware{{Name: "broken"}}, Handler: noopHandler}},
+			`route GET /games: middleware "broken" has no function`,
+		},
+	}
+	for _, tt := range tests {
+		err := validateRoutes(tt.routes, documentedV1(tt.routes), apiV1)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s:\n got %v\nwant %s", tt.name, err, tt.want)
+		}
+	}
+
+	games := []apiRoute{route("GET", "/games", v1, "Games", noopHandler), route("GET", "/users", v1, "Users", noopHandler)}
+	if err := validateRoutes(games, documentedV1(games[:1]), apiV1); err == nil || err.Error() != "route GET /users: missing from the v1 OpenAPI registry" {
+		t.Errorf("undocumented route: %v", err)
+	}
+	if err := validateRoutes(games[:1], documentedV1(games), apiV1); err == nil || err.Error() != "v1 OpenAPI registry: GET /users has no route" {
+		t.Errorf("documented route not served: %v", err)
+	}
+	if err := validateRoutes(nil, nil, "v9"); err == nil || err.Error() != `unknown default API version "v9" (known: v1)` {
+		t.Errorf("unknown default version: %v", err)
+	}
+}
+
+// Nothing is mounted from an invalid table.
+func TestRegisterAPIRoutesRefusesInvalidTable(t *testing.T) {
+	mux := http.NewServeMux()
+	routes := []apiRoute{
+		route("GET", "/games", []string{apiV1}, "Games", noopHandler),
+		route("GET", "/admin/secret", []string{apiV1}, "Secret", noopHandler),
+	}
+	err := registerAPIRoutes(mux, routes, apiV1)
+	if err == nil || !strings.Contains(err.Error(), "not flagged admin") {
+		t.Fatalf("registerAPIRoutes = %v", err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/games", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /api/v1/games = %d after a failed registration, want 404", w.Code)
+	}
+}
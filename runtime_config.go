@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// runtimeConfig holds the settings that can be tuned while the server runs.
+// It is never mutated in place: updates build a copy and swap the pointer.
+type runtimeConfig struct {
+	CacheTTL        time.Duration
+	AppMetaCacheTTL time.Duration
+	CORSAllowOrigin string
+	LogLevel        string
+	ReadOnly        bool
+	// RateLimitRPS (0 for off) and RateLimitBurst are the per-client rate
+	// limit, see rate_limit.go.
+	RateLimitRPS   int
+	RateLimitBurst int
+	// StaleWindow is how long past its TTL an entry is still served while
+	// it is refreshed, see cache_refresh.go.
+	StaleWindow time.Duration
+}
+
+type runtimeConfigView struct {
+	CacheTTL             string `json:"cacheTtl"`
+	AppMetaCacheTTL      string `json:"appMetaCacheTtl"`
+	CORSAllowOrigin      string `json:"corsAllowOrigin"`
+	LogLevel             string `json:"logLevel"`
+	ReadOnly             bool   `json:"readOnly"`
+	RateLimitRPS         int    `json:"rateLimitRps"`
+	RateLimitBurst       int    `json:"rateLimitBurst"`
+	StaleWhileRevalidate string `json:"staleWhileRevalidate"`
+}
+
+// nonTunableSettings are rejected explicitly so callers get a clear error
+// instead of "unknown setting".
+var nonTunableSettings = map[string]bool{
+	"steamApiKey": true,
+	"adminToken":  true,
+	"port":        true,
+	"dbPath":      true,
+	"trustProxy":  true,
+}
+
+func runtimeConfigFromEnv() *runtimeConfig {
+	cfg := &runtimeConfig{
+		CacheTTL:        getenvDuration("CACHE_TTL", cacheTTL),
+		AppMetaCacheTTL: appMetaCacheTTL,
+		CORSAllowOrigin: "*",
+		LogLevel:        "info",
+		ReadOnly:        getenv("READ_ONLY", "") == "1",
+		RateLimitRPS:    max(getenvInt("RATE_LIMIT_RPS", defaultRateLimitRPS), 0),
+		RateLimitBurst:  max(getenvInt("RATE_LIMIT_BURST", defaultRateLimitBurst), 1),
+		StaleWindow:     staleWindowFromEnv(),
+	}
+	if v := strings.TrimSpace(getenv("CORS_ALLOW_ORIGIN", "")); v != "" {
+		cfg.CORSAllowOrigin = v
+	}
+	if v := strings.TrimSpace(getenv("LOG_LEVEL", "")); v != "" {
+		cfg.LogLevel = strings.ToLower(v)
+	}
+	if devMode {
+		cfg.CORSAllowOrigin = "*"
+		cfg.LogLevel = "debug"
+	}
+	return cfg
+}
+
+func (c *runtimeConfig) view() runtimeConfigView {
+	return runtimeConfigView{
+		CacheTTL:             c.CacheTTL.String(),
+		AppMetaCacheTTL:      c.AppMetaCacheTTL.String(),
+		CORSAllowOrigin:      c.CORSAllowOrigin,
+		LogLevel:             c.LogLevel,
+		ReadOnly:             c.ReadOnly,
+		RateLimitRPS:         c.RateLimitRPS,
+		RateLimitBurst:       c.RateLimitBurst,
+		StaleWhileRevalidate: c.StaleWindow.String(),
+	}
+}
+
+// withPatch returns a copy of c with the patch applied. Either every key is
+// valid and applied, or an error is returned and c is left untouched.
+func (c *runtimeConfig) withPatch(patch map[string]any) (*runtimeConfig, error) {
+	next := *c
+	for key, raw := range patch {
+		if nonTunableSettings[key] {
+			return nil, fmt.Errorf("%s is not tunable at runtime", key)
+		}
+
+		switch key {
+		case "cacheTtl":
+			d, err := parseTunableDuration(key, raw, time.Minute, 7*24*time.Hour)
+			if err != nil {
+				return nil, err
+			}
+			next.CacheTTL = d
+		case "appMetaCacheTtl":
+			d, err := parseTunableDuration(key, raw, time.Minute, 7*24*time.Hour)
+			if err != nil {
+				return nil, err
+			}
+			next.AppMetaCacheTTL = d
+		case "corsAllowOrigin":
+			v, ok := raw.(string)
+			if !ok || strings.TrimSpace(v) == "" || strings.ContainsAny(v, "\r\n") {
+				return nil, fmt.Errorf("corsAllowOrigin must be a non-empty origin or \"*\"")
+			}
+			next.CORSAllowOrigin = strings.TrimSpace(v)
+		case "logLevel":
+			v, ok := raw.(string)
+			v = strings.ToLower(strings.TrimSpace(v))
+			if !ok || (v != "debug" && v != "info") {
+				return nil, fmt.Errorf("logLevel must be \"debug\" or \"info\"")
+			}
+			next.LogLevel = v
+		case "readOnly":
+			v, ok := raw.(bool)
+			if !ok {
+				return nil, fmt.Errorf("readOnly must be true or false")
+			}
+			next.ReadOnly = v
+		case "rateLimitRps":
+			n, err := parseTunableInt(key, raw, 0, 10000)
+			if err != nil {
+				return nil, err
+			}
+			next.RateLimitRPS = n
+		case "rateLimitBurst":
+			n, err := parseTunableInt(key, raw, 1, 100000)
+			if err != nil {
+				return nil, err
+			}
+			next.RateLimitBurst = n
+		case "staleWhileRevalidate":
+			d, err := parseTunableDuration(key, raw, 0, 7*24*time.Hour)
+			if err != nil {
+				return nil, err
+			}
+			next.StaleWindow = d
+		default:
+			return nil, fmt.Errorf("unknown setting %s", key)
+		}
+	}
+	return &next, nil
+}
+
+func parseTunableDuration(key string, raw any, lo, hi time.Duration) (time.Duration, error) {
+	v, ok := raw.(string)
+	if !ok {
+		return 0, fmt.Errorf("%s must be a duration string such as \"6h\"", key)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", key, err)
+	}
+	if d < lo || d > hi {
+		return 0, fmt.Errorf("%s must be between %s and %s", key, lo, hi)
+	}
+	return d, nil
+}
+
+// parseTunableInt reads a whole JSON number between lo and hi.
+func parseTunableInt(key string, raw any, lo, hi int) (int, error) {
+	v, ok := raw.(float64)
+	if !ok || v != math.Trunc(v) || v < float64(lo) || v > float64(hi) {
+		return 0, fmt.Errorf("%s must be a whole number between %d and %d", key, lo, hi)
+	}
+	return int(v), nil
+}
+
+func (s *Server) cfg() *runtimeConfig {
+	return s.runtime.Load()
+}
+
+func (s *Server) debugf(format string, args ...any) {
+	if s.cfg().LogLevel == "debug" {
+		log.Printf("debug: "+format, args...)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func patchConfig(t *testing.T, s *Server, body string) (*httptest.ResponseRecorder, adminConfigResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.handleAdminConfig(w, httptest.NewRequest(http.MethodPatch, "/api/v1/admin/config", strings.NewReader(body)))
+	var got adminConfigResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, got
+}
+
+func TestRuntimeConfigPatchValidation(t *testing.T) {
+	base := &runtimeConfig{RateLimitRPS: 10, RateLimitBurst: 120, StaleWindow: time.Hour}
+	valid := map[string]func(*runtimeConfig) bool{
+		`{"rateLimitRps":0}`:                    func(c *runtimeConfig) bool { return c.RateLimitRPS == 0 },
+		`{"rateLimitRps":2,"rateLimitBurst":5}`: func(c *runtimeConfig) bool { return c.RateLimitRPS == 2 && c.RateLimitBurst == 5 },
+		`{"staleWhileRevalidate":"0"}`:          func(c *runtimeConfig) bool { return c.StaleWindow == 0 },
+		`{"staleWhileRevalidate":"30m"}`:        func(c *runtimeConfig) bool { return c.StaleWindow == 30*time.Minute },
+	}
+	for body, check := range valid {
+		var patch map[string]any
+		json.Unmarshal([]byte(body), &patch)
+		next, err := base.withPatch(patch)
+		if err != nil || !check(next) {
+			t.Errorf("%s: %+v, %v", body, next, err)
+		}
+	}
+	for _, body := range []string{
+		`{"rateLimitRps":-1}`, `{"rateLimitRps":2.5}`, `{"rateLimitRps":"10"}`, `{"rateLimitBurst":0}`,
+		`{"staleWhileRevalidate":"-1m"}`, `{"staleWhileRevalidate":"30d"}`, `{"trustProxy":true}`,
+		`{"rateLimitRps":5,"steamApiKey":"x"}`,
+	} {
+		var patch map[string]any
+		json.Unmarshal([]byte(body), &patch)
+		if _, err := base.withPatch(patch); err == nil {
+			t.Errorf("%s accepted", body)
+		}
+	}
+	if base.RateLimitRPS != 10 || base.StaleWindow != time.Hour {
+		t.Errorf("withPatch changed the current config: %+v", base)
+	}
+}
+
+// The rate limit and the stale window follow a PATCH without a restart.
+func TestRuntimeConfigAppliesLive(t *testing.T) {
+	s := &Server{startupConfig: &runtimeConfig{CacheTTL: time.Hour, AppMetaCacheTTL: time.Hour, RateLimitRPS: 1, RateLimitBurst: 2, StaleWindow: time.Hour}}
+	s.runtime.Store(s.startupConfig)
+	limiter := newClientRateLimiter(s.cfg, false, s.isAdminRequest)
+	h := limiter.wrap(route("GET", "/games", []string{apiV1}, "test", func(w http.ResponseWriter, r *http.Request) {}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/games", nil))
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := call(); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Fatalf("request %d: %d, limit %q", i, w.Code, w.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+	if w := call(); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request within the burst of 2: %d", w.Code)
+	}
+
+	w, got := patchConfig(t, s, `{"rateLimitRps":0}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH: %d %s", w.Code, w.Body)
+	}
+	if w := call(); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("rate limit still applied after rateLimitRps=0: %d", w.Code)
+	}
+	if limiter.snapshot().Enabled {
+		t.Errorf("stats say enabled after rateLimitRps=0")
+	}
+	patchConfig(t, s, `{"rateLimitRps":1000,"rateLimitBurst":50}`)
+	time.Sleep(10 * time.Millisecond) // the empty bucket refills at the new rate
+	if w := call(); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "50" {
+		t.Errorf("raised limit: %d, limit %q", w.Code, w.Header().Get("X-RateLimit-Limit"))
+	}
+
+	past := time.Now().Add(-90 * time.Minute)
+	if !s.revalidates(past, time.Hour) {
+		t.Fatal("entry 30m past its TTL not revalidated with a 1h window")
+	}
+	_, got = patchConfig(t, s, `{"staleWhileRevalidate":"10m"}`)
+	if s.revalidates(past, time.Hour) {
+		t.Errorf("still revalidated with a 10m window")
+	}
+	slices.Sort(got.Overridden)
+	if strings.Join(got.Overridden, ",") != "rateLimitBurst,rateLimitRps,staleWhileRevalidate" || got.Persisted || !strings.Contains(got.Note, "reset on restart") {
+		t.Errorf("config response = %+v", got)
+	}
+	if got.Effective.StaleWhileRevalidate != "10m0s" || got.Startup.RateLimitBurst != 2 {
+		t.Errorf("effective %+v, startup %+v", got.Effective, got.Startup)
+	}
+}
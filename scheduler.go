@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+const defaultPlayerRefreshPerHour = 6
+const schedulerErrorHistory = 10
+
+// defaultRefreshQueueMax bounds the players POST /players/{steamid}/refresh
+// may queue ahead of the scheduler (PLAYER_REFRESH_QUEUE_MAX); past it the
+// endpoint answers 429.
+const defaultRefreshQueueMax = 100
+
+type schedulerError struct {
+	SteamID SteamID   `json:"steamId"`
+	Error   string    `json:"error"`
+	At      time.Time `json:"at"`
+}
+
+type scheduledPlayer struct {
+	SteamID  SteamID   `json:"steamId"`
+	LastSync time.Time `json:"lastSync"`
+	Priority bool      `json:"priority,omitempty"`
+}
+
+// playerScheduler refreshes registered players in the background, most
+// stale first, capped per hour. Fairness survives restarts because the
+// order comes from the persisted last_sync values.
+type playerScheduler struct {
+	s        *Server
+	perHour  int
+	maxQueue int
+	wake     chan struct{}
+
+	mu         sync.Mutex
+	priority   []SteamID
+	queued     map[SteamID]bool
+	recent     []time.Time
+	lastErrors []schedulerError
+}
+
+func newPlayerScheduler(s *Server, perHour, maxQueue int) *playerScheduler {
+	return &playerScheduler{s: s, perHour: perHour, maxQueue: maxQueue, wake: make(chan struct{}, 1), queued: make(map[SteamID]bool)}
+}
+
+func (p *playerScheduler) run(ctx context.Context) {
+	if p.perHour <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+		p.runOnce(ctx)
+	}
+}
+
+// enqueue puts a player in the priority queue and returns its position;
+// a player already queued keeps its place. ok is false when the queue is
+// full.
+func (p *playerScheduler) enqueue(steamID SteamID) (pos int, ok bool) {
+	p.mu.Lock()
+	if p.queued[steamID] {
+		for i, id := range p.priority {
+			if id == steamID {
+				p.mu.Unlock()
+				return i + 1, true
+			}
+		}
+	}
+	if len(p.priority) >= p.maxQueue {
+		p.mu.Unlock()
+		return 0, false
+	}
+	p.priority = append(p.priority, steamID)
+	p.queued[steamID] = true
+	pos = len(p.priority)
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return pos, true
+}
+
+// interval is the time between two scheduled refreshes.
+func (p *playerScheduler) interval() time.Duration {
+	if p.perHour <= 0 {
+		return time.Hour
+	}
+	return time.Hour / time.Duration(p.perHour)
+}
+
+func (p *playerScheduler) underCapLocked(now time.Time) bool {
+	kept := p.recent[:0]
+	for _, t := range p.recent {
+		if now.Sub(t) < time.Hour {
+			kept = append(kept, t)
+		}
+	}
+	p.recent = kept
+	return len(p.recent) < p.perHour
+}
+
+func (p *playerScheduler) runOnce(ctx context.Context) {
+	if p.s.readOnly() {
+		return
+	}
+	now := time.Now()
+
+	p.mu.Lock()
+	if !p.underCapLocked(now) {
+		p.mu.Unlock()
+		return
+	}
+	var steamID SteamID
+	if len(p.priority) > 0 {
+		steamID = p.priority[0]
+		p.priority = p.priority[1:]
+		delete(p.queued, steamID)
+	}
+	p.mu.Unlock()
+
+	if steamID == 0 {
+		stale, err := p.s.readStalestPlayers(1)
+		if err != nil || len(stale) == 0 {
+			return
+		}
+		if now.Sub(stale[0].LastSync) <= p.s.cfg().CacheTTL {
+			return
+		}
+		steamID = stale[0].SteamID
+	}
+
+	p.mu.Lock()
+	p.recent = append(p.recent, now)
+	p.mu.Unlock()
+
+	// Failed players must not stay at the head of the queue forever.
+	if err := p.s.upsertUserMetaValue(ctx, steamID, "last_refresh_attempt", strconv.FormatInt(now.Unix(), 10)); err != nil {
+		log.Printf("scheduler: persist attempt for %s: %v", steamID, err)
+	}
+	if err := p.s.syncUserData(ctx, steamID, p.s.lang); err != nil {
+		p.mu.Lock()
+		p.lastErrors = append(p.lastErrors, schedulerError{SteamID: steamID, Error: err.Error(), At: now})
+		if len(p.lastErrors) > schedulerErrorHistory {
+			p.lastErrors = p.lastErrors[len(p.lastErrors)-schedulerErrorHistory:]
+		}
+		p.mu.Unlock()
+	}
+}
+
+// readStalestPlayers lists registered players, least recently refreshed
+// (or attempted) first.
+func (s *Server) readStalestPlayers(limit int) ([]scheduledPlayer, error) {
+	rows, err := s.db.Query(`
+		SELECT p.steam_id,
+			COALESCE(CAST(m.value AS INTEGER), 0) AS last_sync,
+			MAX(COALESCE(CAST(m.value AS INTEGER), 0), COALESCE(CAST(a.value AS INTEGER), 0)) AS last_seen
+		FROM (SELECT DISTINCT steam_id FROM user_meta) p
+		LEFT JOIN user_meta m ON m.steam_id = p.steam_id AND m.key = 'last_sync'
+		LEFT JOIN user_meta a ON a.steam_id = p.steam_id AND a.key = 'last_refresh_attempt'
+		ORDER BY last_seen ASC, p.steam_id ASC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]scheduledPlayer, 0, limit)
+	for rows.Next() {
+		var sp scheduledPlayer
+		var sec, seen int64
+		if err := rows.Scan(&sp.SteamID, &sec, &seen); err != nil {
+			return nil, err
+		}
+		if sec > 0 {
+			sp.LastSync = time.Unix(sec, 0).UTC()
+		}
+		out = append(out, sp)
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) handlePlayerRefresh(w http.ResponseWriter, r *http.Request) {
+	steamID, err := s.resolveSteamIDInput(r.PathValue("steamid"))
+	if err != nil {
+		writeIdentifierError(w, err)
+		return
+	}
+
+	w.Header().Set("X-Steam-ID", pseudonyms.steamID(steamID).String())
+	now := time.Now()
+	if upstreamUsage.spent(featurePlayerFetch, now) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(nextUTCMidnight(now).Sub(now)/time.Second)+1))
+		writeCode(w, apperr.CodeUpstreamQuota)
+		return
+	}
+	pos, ok := s.scheduler.enqueue(steamID)
+	if !ok {
+		retry := int(s.scheduler.interval() / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeCode(w, apperr.CodeRefreshQueueFull, retry)
+		return
+	}
+	writeJSONStatus(w, http.StatusAccepted, map[string]any{
+		"steamId":  pseudonyms.steamID(steamID),
+		"queued":   true,
+		"position": pos,
+	})
+}
+
+func (s *Server) handleAdminScheduler(w http.ResponseWriter, r *http.Request) {
+	p := s.scheduler
+	now := time.Now()
+
+	p.mu.Lock()
+	p.underCapLocked(now)
+	priority := append([]SteamID(nil), p.priority...)
+	usedThisHour := len(p.recent)
+	lastErrors := append([]schedulerError(nil), p.lastErrors...)
+	p.mu.Unlock()
+
+	next := make([]scheduledPlayer, 0, 5)
+	for _, id := range priority {
+		if len(next) == 5 {
+			break
+		}
+		next = append(next, scheduledPlayer{SteamID: id, Priority: true})
+	}
+	if len(next) < 5 {
+		stale, err := s.readStalestPlayers(5 + len(priority))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		queued := make(map[SteamID]bool, len(priority))
+		for _, id := range priority {
+			queued[id] = true
+		}
+		for _, sp := range stale {
+			if len(next) == 5 {
+				break
+			}
+			if queued[sp.SteamID] || now.Sub(sp.LastSync) <= s.cfg().CacheTTL {
+				continue
+			}
+			next = append(next, sp)
+		}
+	}
+	if lastErrors == nil {
+		lastErrors = make([]schedulerError, 0)
+	}
+
+	writeJSON(w, map[string]any{
+		"enabled":      p.perHour > 0,
+		"perHourCap":   p.perHour,
+		"usedThisHour": usedThisHour,
+		"queueLength":  len(priority),
+		"queueMax":     p.maxQueue,
+		"next":         next,
+		"lastErrors":   lastErrors,
+	})
+}
+
+func playerRefreshPerHourFromEnv() int {
+	v := strings.TrimSpace(getenv("PLAYER_REFRESH_PER_HOUR", strconv.Itoa(defaultPlayerRefreshPerHour)))
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return defaultPlayerRefreshPerHour
+	}
+	return n
+}
+
+func refreshQueueMaxFromEnv() int {
+	n := getenvInt("PLAYER_REFRESH_QUEUE_MAX", defaultRefreshQueueMax)
+	if n < 1 {
+		log.Printf("PLAYER_REFRESH_QUEUE_MAX=%d is not positive, using %d", n, defaultRefreshQueueMax)
+		return defaultRefreshQueueMax
+	}
+	return n
+}
+
+// nextUTCMidnight is when the daily Steam budget starts over.
+func nextUTCMidnight(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
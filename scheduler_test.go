@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+func TestSchedulerEnqueue(t *testing.T) {
+	p := newPlayerScheduler(&Server{}, 6, 3)
+	steps := []struct {
+		id  SteamID
+		pos int
+		ok  bool
+	}{
+		{76561197960287930, 1, true},
+		{76561197960287931, 2, true},
+		{76561197960287930, 1, true}, // already queued: same place
+		{76561197960287932, 3, true},
+		{76561197960287933, 0, false}, // full
+		{76561197960287931, 2, true},  // a queued player still gets its place
+	}
+	for i, st := range steps {
+		if pos, ok := p.enqueue(st.id); pos != st.pos || ok != st.ok {
+			t.Errorf("step %d: enqueue(%d) = %d, %v; want %d, %v", i, st.id, pos, ok, st.pos, st.ok)
+		}
+	}
+	if len(p.priority) != 3 || len(p.queued) != 3 {
+		t.Fatalf("queue = %v (set %v), want 3 distinct players", p.priority, p.queued)
+	}
+
+	// Serving the head frees a place, and the served player may be queued
+	// again.
+	p.mu.Lock()
+	head := p.priority[0]
+	p.priority = p.priority[1:]
+	delete(p.queued, head)
+	p.mu.Unlock()
+	if pos, ok := p.enqueue(head); pos != 3 || !ok {
+		t.Errorf("enqueue(served player) = %d, %v; want 3, true", pos, ok)
+	}
+}
+
+func refreshRequest(s *Server, steamID string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/players/"+steamID+"/refresh", nil)
+	r.SetPathValue("steamid", steamID)
+	w := httptest.NewRecorder()
+	s.handlePlayerRefresh(w, r)
+	return w
+}
+
+func TestHandlePlayerRefreshQueueFull(t *testing.T) {
+	s := &Server{}
+	s.scheduler = newPlayerScheduler(s, 6, 1)
+
+	if w := refreshRequest(s, "76561197960287930"); w.Code != http.StatusAccepted {
+		t.Fatalf("first refresh: status %d, body %s", w.Code, w.Body)
+	}
+	if w := refreshRequest(s, "76561197960287930"); w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"position": 1`) {
+		t.Fatalf("same player again: status %d, body %s", w.Code, w.Body)
+	}
+	w := refreshRequest(s, "76561197960287931")
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), string(apperr.CodeRefreshQueueFull)) {
+		t.Fatalf("queue full: status %d, body %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Retry-After"); got != "600" {
+		t.Errorf("Retry-After = %q, want one scheduler interval (600)", got)
+	}
+}
+
+func TestHandlePlayerRefreshBudgetSpent(t *testing.T) {
+	saved := upstreamUsage
+	defer func() { upstreamUsage = saved }()
+	upstreamUsage = newUpstreamLedger(0, map[upstreamFeature]int64{featurePlayerFetch: 1})
+
+	s := &Server{}
+	s.scheduler = newPlayerScheduler(s, 6, 10)
+	if w := refreshRequest(s, "76561197960287930"); w.Code != http.StatusAccepted {
+		t.Fatalf("budget left: status %d", w.Code)
+	}
+	if err := upstreamUsage.take(featurePlayerFetch, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	w := refreshRequest(s, "76561197960287931")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), string(apperr.CodeUpstreamQuota)) || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("budget spent: status %d, Retry-After %q, body %s", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	if len(s.scheduler.priority) != 1 {
+		t.Errorf("a refusal queued the player: %v", s.scheduler.priority)
+	}
+}
+
+func TestUpstreamLedgerSpent(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	l := newUpstreamLedger(2, map[upstreamFeature]int64{featurePrefetch: 1})
+	if l.spent(featurePlayerFetch, now) || l.spent(featurePrefetch, now) {
+		t.Fatalf("spent before any request")
+	}
+	l.take(featurePrefetch, now)
+	if !l.spent(featurePrefetch, now) || l.spent(featurePlayerFetch, now) {
+		t.Errorf("after the prefetch quota: prefetch %v, player_fetch %v", l.spent(featurePrefetch, now), l.spent(featurePlayerFetch, now))
+	}
+	l.take(featurePlayerFetch, now)
+	if !l.spent(featurePlayerFetch, now) {
+		t.Errorf("daily budget spent, player_fetch still allowed")
+	}
+	if l.spent(featurePlayerFetch, nextUTCMidnight(now)) {
+		t.Errorf("budget not renewed at 00:00 UTC")
+	}
+}
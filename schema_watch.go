@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSchemaWatchInterval = time.Hour
+
+// Valve publishes percentages for new achievements before the schema
+// catches up, so a mismatch that survives a refresh is retried with backoff
+// instead of refreshing on every check.
+const schemaRetryMinBackoff = 2 * time.Minute
+const schemaRetryMaxBackoff = time.Hour
+
+type schemaRetry struct {
+	backoff time.Duration
+	nextAt  time.Time
+}
+
+// schemaWatcher compares the apiNames of the keyless percentages call with
+// the cached schema and refreshes the schema when they diverge.
+type schemaWatcher struct {
+	s        *Server
+	interval time.Duration
+
+	mu      sync.Mutex
+	retries map[AppID]*schemaRetry
+}
+
+func newSchemaWatcher(s *Server, interval time.Duration) *schemaWatcher {
+	return &schemaWatcher{s: s, interval: interval, retries: make(map[AppID]*schemaRetry)}
+}
+
+func (sw *schemaWatcher) run(ctx context.Context) {
+	if sw.interval <= 0 {
+		return
+	}
+	check := time.NewTicker(sw.interval)
+	defer check.Stop()
+	retry := time.NewTicker(schemaRetryMinBackoff)
+	defer retry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-check.C:
+			for _, appID := range sw.s.watchedApps() {
+				sw.checkApp(ctx, appID, false)
+			}
+		case <-retry.C:
+			for _, appID := range sw.dueRetries(time.Now()) {
+				sw.checkApp(ctx, appID, true)
+			}
+		}
+	}
+}
+
+func (sw *schemaWatcher) dueRetries(now time.Time) []AppID {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	var due []AppID
+	for appID, r := range sw.retries {
+		if !now.Before(r.nextAt) {
+			due = append(due, appID)
+		}
+	}
+	return due
+}
+
+func (sw *schemaWatcher) checkApp(ctx context.Context, appID AppID, isRetry bool) {
+	sw.mu.Lock()
+	_, pending := sw.retries[appID]
+	sw.mu.Unlock()
+	if pending && !isRetry || sw.s.readOnly() {
+		return
+	}
+
+	pcts, err := sw.s.sourceFor(appID).FetchPercentages(ctx, appID)
+	if err != nil {
+		log.Printf("schema watch: percentages app %d: %v", appID, err)
+		return
+	}
+
+	// Each cached language is its own schema entry: an app served only in
+	// english is compared and refreshed in english.
+	added, removed := make(map[string]bool), make(map[string]bool)
+	settled := true
+	for _, lang := range sw.s.cachedSchemaLangs(appID) {
+		before, err := sw.s.cachedSchemaNames(appID, lang)
+		if err != nil {
+			log.Printf("schema watch: cached schema app %d (%s): %v", appID, lang, err)
+			return
+		}
+		if sameNames(before, pcts) {
+			continue
+		}
+
+		log.Printf("schema watch: app %d percentages and schema (%s) differ, refreshing schema", appID, lang)
+		if err := sw.s.refreshSchema(ctx, appID, lang); err != nil {
+			log.Printf("schema watch: refresh app %d (%s): %v", appID, lang, err)
+			settled = false
+			continue
+		}
+		after, err := sw.s.cachedSchemaNames(appID, lang)
+		if err != nil {
+			log.Printf("schema watch: cached schema app %d (%s): %v", appID, lang, err)
+			settled = false
+			continue
+		}
+		a, r := diffNames(before, after)
+		for _, name := range a {
+			added[name] = true
+		}
+		for _, name := range r {
+			removed[name] = true
+		}
+		settled = settled && sameNames(after, pcts)
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		policy := sw.s.policies.forApp(appID)
+		added, removed := policy.visibleNames(slices.Sorted(maps.Keys(added))), policy.visibleNames(slices.Sorted(maps.Keys(removed)))
+		if len(added) > 0 || len(removed) > 0 {
+			sw.s.events.publish(eventSchemaChanged, appID.String(), map[string]any{
+				"appId":   appID,
+				"added":   added,
+				"removed": removed,
+			})
+			sw.s.webhooks.emit(eventSchemaChanged, appID, webhookSchemaChangedData{Added: append([]string{}, added...), Removed: append([]string{}, removed...)})
+			sw.s.cdn.purge(appSurrogateKey(appID))
+		}
+	}
+	if settled {
+		sw.clearRetry(appID)
+	} else {
+		sw.scheduleRetry(appID)
+	}
+}
+
+func (sw *schemaWatcher) scheduleRetry(appID AppID) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	r, ok := sw.retries[appID]
+	if !ok {
+		r = &schemaRetry{backoff: schemaRetryMinBackoff}
+		sw.retries[appID] = r
+	} else {
+		r.backoff = min(r.backoff*2, schemaRetryMaxBackoff)
+	}
+	r.nextAt = time.Now().Add(r.backoff)
+}
+
+func (sw *schemaWatcher) clearRetry(appID AppID) {
+	sw.mu.Lock()
+	delete(sw.retries, appID)
+	sw.mu.Unlock()
+}
+
+// watchedApps is the legacy global app plus every app with a shared schema
+// in memory.
+func (s *Server) watchedApps() []AppID {
+	apps := map[AppID]bool{defaultGlobalAppID: true}
+	s.cacheMu.RLock()
+	for k := range s.appSchemaCache {
+		if k.NS == "" {
+			apps[k.AppID] = true
+		}
+	}
+	s.cacheMu.RUnlock()
+
+	out := make([]AppID, 0, len(apps))
+	for id := range apps {
+		out = append(out, id)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// cachedSchemaLangs lists the languages appID has a shared schema cached
+// in; the legacy global app keeps its schema in the database instead.
+func (s *Server) cachedSchemaLangs(appID AppID) []string {
+	if appID == defaultGlobalAppID {
+		return []string{defaultLang}
+	}
+	var langs []string
+	s.cacheMu.RLock()
+	for k := range s.appSchemaCache {
+		if k.NS == "" && k.AppID == appID {
+			langs = append(langs, k.Lang)
+		}
+	}
+	s.cacheMu.RUnlock()
+	sort.Strings(langs)
+	return langs
+}
+
+func (s *Server) cachedSchemaNames(appID AppID, lang string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	if appID == defaultGlobalAppID {
+		items, err := s.readAchievementsFromDB()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range items {
+			names[a.APIName] = true
+		}
+		return names, nil
+	}
+
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	for _, a := range s.appSchemaCache[CacheKey{Kind: cacheKindSchema, AppID: appID, Lang: lang}].items {
+		names[a.APIName] = true
+	}
+	return names, nil
+}
+
+// refreshSchema bypasses the schema TTL for appID in lang.
+func (s *Server) refreshSchema(ctx context.Context, appID AppID, lang string) error {
+	if appID == defaultGlobalAppID {
+		return s.syncFromSteam(ctx, lang)
+	}
+	s.cacheMu.Lock()
+	delete(s.appSchemaCache, CacheKey{Kind: cacheKindSchema, AppID: appID, Lang: lang})
+	s.cacheMu.Unlock()
+	_, err := s.fetchSchemaForGameCached(ctx, appID, lang)
+	return err
+}
+
+func sameNames(schema map[string]bool, pcts map[string]float64) bool {
+	if len(schema) != len(pcts) {
+		return false
+	}
+	for name := range pcts {
+		if !schema[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func diffNames(before, after map[string]bool) (added []string, removed []string) {
+	added, removed = make([]string, 0), make([]string, 0)
+	for name := range after {
+		if !before[name] {
+			added = append(added, name)
+		}
+	}
+	for name := range before {
+		if !after[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func schemaWatchIntervalFromEnv() time.Duration {
+	v := strings.TrimSpace(getenv("SCHEMA_WATCH_INTERVAL", ""))
+	if v == "" {
+		return defaultSchemaWatchInterval
+	}
+	if v == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Minute {
+		log.Printf("SCHEMA_WATCH_INTERVAL=%q ignored, using %s", v, defaultSchemaWatchInterval)
+		return defaultSchemaWatchInterval
+	}
+	return d
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A schema cached only in english is compared and refreshed in english:
+// in sync it is left alone, behind it is refetched and announced once.
+func TestSchemaWatchChecksCachedLanguages(t *testing.T) {
+	f := fakeSteamGame(t)
+	s := newTestServer(t)
+	ctx := context.Background()
+	key := CacheKey{Kind: cacheKindSchema, AppID: 440, Lang: "english"}
+	s.storeSchema(key, []Achievement{{APIName: "A"}, {APIName: "B"}}, time.Now())
+	events := s.events.subscribe([]string{allTopics})
+	defer s.events.unsubscribe(events)
+	sw := newSchemaWatcher(s, time.Hour)
+
+	sw.checkApp(ctx, 440, false)
+	if n := f.count("GetSchemaForGame"); n != 0 {
+		t.Fatalf("schema in sync: %d schema fetches, want none", n)
+	}
+	select {
+	case ev := <-events.ch:
+		t.Fatalf("schema in sync: event %s %s", ev.Type, ev.Data)
+	default:
+	}
+
+	s.storeSchema(key, []Achievement{{APIName: "A"}}, time.Now())
+	sw.checkApp(ctx, 440, false)
+	if n := f.count("GetSchemaForGame"); n != 1 {
+		t.Fatalf("schema behind: %d schema fetches, want 1", n)
+	}
+	if names, _ := s.cachedSchemaNames(440, "english"); len(names) != 2 {
+		t.Errorf("english schema after refresh = %v, want A and B", names)
+	}
+	if _, ok := s.appSchemaCache[schemaCacheKey(ctx, 440, "french")]; ok {
+		t.Error("refresh cached a french schema nobody served")
+	}
+	select {
+	case ev := <-events.ch:
+		if ev.Type != eventSchemaChanged || !strings.Contains(string(ev.Data), `"added":["B"]`) {
+			t.Errorf("event %s %s, want B added", ev.Type, ev.Data)
+		}
+	default:
+		t.Fatal("no schema_changed event")
+	}
+	select {
+	case ev := <-events.ch:
+		t.Errorf("second event %s %s", ev.Type, ev.Data)
+	default:
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Timeouts of the HTTP server: READ_HEADER_TIMEOUT (5s) and READ_TIMEOUT
+// (15s) bound a client sending its request, WRITE_TIMEOUT (2m) the whole
+// exchange once the headers are read, long enough for a library sync, and
+// IDLE_TIMEOUT (2m) a keep-alive connection waiting for the next request.
+// 0 disables one. Event streams lift their write deadline, they are meant
+// to stay open.
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 15 * time.Second
+	defaultWriteTimeout      = 2 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+func setServerTimeouts(srv *http.Server) {
+	srv.ReadHeaderTimeout = serverTimeoutFromEnv("READ_HEADER_TIMEOUT", defaultReadHeaderTimeout)
+	srv.ReadTimeout = serverTimeoutFromEnv("READ_TIMEOUT", defaultReadTimeout)
+	srv.WriteTimeout = serverTimeoutFromEnv("WRITE_TIMEOUT", defaultWriteTimeout)
+	srv.IdleTimeout = serverTimeoutFromEnv("IDLE_TIMEOUT", defaultIdleTimeout)
+}
+
+// serverTimeoutFromEnv is getenvDuration that also takes 0, no timeout.
+func serverTimeoutFromEnv(k string, def time.Duration) time.Duration {
+	if strings.TrimSpace(os.Getenv(k)) == "0" {
+		return 0
+	}
+	return getenvDuration(k, def)
+}
+
+// keepStreamOpen clears the write deadline of a long-lived response.
+func keepStreamOpen(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("event stream keeps the write timeout: %v", err)
+	}
+}
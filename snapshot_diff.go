@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A snapshot diff compares the archived state of an app at two dates:
+// achievements added and removed in between, and the percentage move of
+// every one present at both ends, biggest absolute movers first. Each date
+// uses the snapshot nearest to it (from: start of day, to: end of day,
+// UTC) within a tolerance, and the dates actually used are reported. The
+// payload is deterministic so exports of the same window can be diffed.
+const defaultDiffTolerance = 3 * 24 * time.Hour
+
+type diffAchievement struct {
+	APIName string `json:"apiName"`
+	Name    string `json:"name"`
+}
+
+type achievementMove struct {
+	APIName  string  `json:"apiName"`
+	Name     string  `json:"name"`
+	StartPct float64 `json:"startPct"`
+	EndPct   float64 `json:"endPct"`
+	AbsDelta float64 `json:"absDelta"`
+	// RelDelta is AbsDelta over StartPct, in percent; null from 0.
+	RelDelta *float64 `json:"relDelta"`
+}
+
+type achievementRename struct {
+	APIName string `json:"apiName"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+}
+
+// achievementDiff compares two lists of one app by apiName. It is shared
+// by the snapshot diffs and the consistency checks (verify.go).
+type achievementDiff struct {
+	Added   []diffAchievement   `json:"added"`
+	Removed []diffAchievement   `json:"removed"`
+	Changed []achievementMove   `json:"changed"`
+	Renamed []achievementRename `json:"renamed"`
+}
+
+type SnapshotDiff struct {
+	AppID     AppID     `json:"appId"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	FromUsed  time.Time `json:"fromUsed"`
+	ToUsed    time.Time `json:"toUsed"`
+	Tolerance string    `json:"tolerance"`
+	achievementDiff
+}
+
+// errNoSnapshotNear is returned when no snapshot is within the tolerance of
+// a requested date.
+type errNoSnapshotNear struct {
+	date    string
+	nearest time.Time // zero: no snapshot at all
+}
+
+func (e errNoSnapshotNear) Error() string {
+	if e.nearest.IsZero() {
+		return fmt.Sprintf("no snapshot recorded, cannot diff %s", e.date)
+	}
+	return fmt.Sprintf("no snapshot within tolerance of %s (nearest: %s)", e.date, e.nearest.Format(time.RFC3339))
+}
+
+// nearestSnapshot is the recorded_at of appID closest to t.
+func (s *Server) nearestSnapshot(appID AppID, t time.Time) (time.Time, bool, error) {
+	var sec int64
+	err := s.db.QueryRow(`
+		SELECT recorded_at FROM global_percent_history
+		WHERE app_id=?
+		ORDER BY ABS(recorded_at - ?), recorded_at
+		LIMIT 1
+	`, appID, t.Unix()).Scan(&sec)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.Unix(sec, 0).UTC(), true, nil
+}
+
+func (s *Server) snapshotNear(appID AppID, date string, t time.Time, tolerance time.Duration) (time.Time, error) {
+	used, ok, err := s.nearestSnapshot(appID, t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok || used.Sub(t).Abs() > tolerance {
+		return time.Time{}, errNoSnapshotNear{date: date, nearest: used}
+	}
+	return used, nil
+}
+
+func parseDiffDates(from, to string) (time.Time, time.Time, error) {
+	start, err := time.Parse(archiveDateLayout, strings.TrimSpace(from))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be a date formatted YYYY-MM-DD")
+	}
+	end, err := time.Parse(archiveDateLayout, strings.TrimSpace(to))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be a date formatted YYYY-MM-DD")
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must not be before from")
+	}
+	return start, end.Add(24*time.Hour - time.Second), nil
+}
+
+// snapshotDiff computes the diff of appID between start and end (from
+// parseDiffDates), with achievements hidden by p left out and redacted ones
+// unnamed.
+func (s *Server) snapshotDiff(appID AppID, start, end time.Time, tolerance time.Duration, p *appPolicy) (*SnapshotDiff, error) {
+	from, to := start.Format(archiveDateLayout), end.Format(archiveDateLayout)
+	startUsed, err := s.snapshotNear(appID, from, start, tolerance)
+	if err != nil {
+		return nil, err
+	}
+	endUsed, err := s.snapshotNear(appID, to, end, tolerance)
+	if err != nil {
+		return nil, err
+	}
+	_, before, err := s.readArchive(appID, startUsed)
+	if err != nil {
+		return nil, err
+	}
+	_, after, err := s.readArchive(appID, endUsed)
+	if err != nil {
+		return nil, err
+	}
+	before, after = p.filter(before), p.filter(after)
+
+	return &SnapshotDiff{
+		AppID: appID, From: from, To: to,
+		FromUsed: startUsed, ToUsed: endUsed, Tolerance: tolerance.String(),
+		achievementDiff: diffAchievementLists(before, after),
+	}, nil
+}
+
+// diffAchievementLists lists what was added and removed from before to
+// after, and the percentage move and renames of the achievements in both,
+// biggest absolute movers first. The result is deterministic.
+func diffAchievementLists(before, after []Achievement) achievementDiff {
+	d := achievementDiff{
+		Added: make([]diffAchievement, 0), Removed: make([]diffAchievement, 0),
+		Changed: make([]achievementMove, 0), Renamed: make([]achievementRename, 0),
+	}
+	old := make(map[string]Achievement, len(before))
+	for _, a := range before {
+		old[a.APIName] = a
+	}
+	for _, a := range after {
+		b, ok := old[a.APIName]
+		if !ok {
+			d.Added = append(d.Added, diffAchievement{APIName: a.APIName, Name: a.Name})
+			continue
+		}
+		delete(old, a.APIName)
+		m := achievementMove{APIName: a.APIName, Name: a.Name, StartPct: b.GlobalPct, EndPct: a.GlobalPct, AbsDelta: roundPct(a.GlobalPct - b.GlobalPct)}
+		if b.GlobalPct != 0 {
+			rel := roundPct((a.GlobalPct - b.GlobalPct) * 100 / b.GlobalPct)
+			m.RelDelta = &rel
+		}
+		d.Changed = append(d.Changed, m)
+		if a.Name != b.Name {
+			d.Renamed = append(d.Renamed, achievementRename{APIName: a.APIName, Before: b.Name, After: a.Name})
+		}
+	}
+	for _, b := range old {
+		d.Removed = append(d.Removed, diffAchievement{APIName: b.APIName, Name: b.Name})
+	}
+
+	byAPIName := func(list []diffAchievement) {
+		sort.Slice(list, func(i, j int) bool { return list[i].APIName < list[j].APIName })
+	}
+	byAPIName(d.Added)
+	byAPIName(d.Removed)
+	sort.Slice(d.Renamed, func(i, j int) bool { return d.Renamed[i].APIName < d.Renamed[j].APIName })
+	sort.Slice(d.Changed, func(i, j int) bool {
+		ai, aj := math.Abs(d.Changed[i].AbsDelta), math.Abs(d.Changed[j].AbsDelta)
+		if ai != aj {
+			return ai > aj
+		}
+		return d.Changed[i].APIName < d.Changed[j].APIName
+	})
+	return d
+}
+
+func roundPct(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+func (s *Server) handleAchievementsDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	appID := defaultGlobalAppID
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		v, err := parseAppID(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
+		}
+		appID = v
+	}
+	if !s.appAllowed(appID) {
+		writeAppNotAllowed(w, appID)
+		return
+	}
+	tolerance := defaultDiffTolerance
+	if raw := strings.TrimSpace(q.Get("tolerance")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 || d > 31*24*time.Hour {
+			writeError(w, http.StatusBadRequest, "invalid_query", "tolerance doit etre une duree entre 0 et 744h")
+			return
+		}
+		tolerance = d
+	}
+
+	start, end, err := parseDiffDates(q.Get("from"), q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	d, err := s.snapshotDiff(appID, start, end, tolerance, s.policyFor(w, appID))
+	var near errNoSnapshotNear
+	if errors.As(err, &near) {
+		body := map[string]any{"error": "no_snapshot", "details": "Aucun instantane assez proche de " + near.date + " (tolerance " + tolerance.String() + ")"}
+		if !near.nearest.IsZero() {
+			body["nearest"] = near.nearest
+		}
+		writeJSONStatus(w, http.StatusNotFound, body)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	setSurrogateKeys(w, appSurrogateKey(appID))
+	writeJSON(w, d)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// An achievementSource provides the schema and global percentages of the
+// games it knows. Steam is the default; games listed in the games file
+// (data/games.json) can use another source:
+//
+//	{
+//	  "games": [
+//	    { "appId": 2000000001, "name": "Club Game Jam 2025", "source": "manual", "slug": "jam-2025" }
+//	  ]
+//	}
+//
+// Manual games read curated files from data/sources/{slug}/ (see
+// manualSource) and go through the same caches, filters and handlers as
+// Steam games. Their appId must be at least manualAppIDMin, far above
+// Steam's range, so the two can never collide.
+type achievementSource interface {
+	Name() string
+	FetchSchema(ctx context.Context, appID AppID, lang string) ([]Achievement, error)
+	FetchPercentages(ctx context.Context, appID AppID) (map[string]float64, error)
+}
+
+const (
+	defaultGamesFile           = "data/games.json"
+	defaultManualSources       = "data/sources"
+	manualAppIDMin       AppID = 2_000_000_000
+	sourceSteam                = "steam"
+	sourceManual               = "manual"
+)
+
+var manualSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+type gameEntry struct {
+	AppID  AppID  `json:"appId"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Slug   string `json:"slug,omitempty"`
+}
+
+type gameRegistry struct {
+	games  map[AppID]gameEntry
+	steam  steamSource
+	manual manualSource
+}
+
+func loadGameRegistry(path, manualDir, apiKey string) (*gameRegistry, error) {
+	games := make(map[AppID]gameEntry)
+	reg := &gameRegistry{
+		games:  games,
+		steam:  steamSource{apiKey: apiKey},
+		manual: manualSource{dir: manualDir, games: games},
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return reg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Games []gameEntry `json:"games"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, g := range raw.Games {
+		if g.AppID == 0 {
+			return nil, fmt.Errorf("%s: game %q has no appId", path, g.Name)
+		}
+		if _, dup := games[g.AppID]; dup {
+			return nil, fmt.Errorf("%s: app %d listed twice", path, g.AppID)
+		}
+		switch g.Source {
+		case "", sourceSteam:
+			if g.AppID >= manualAppIDMin {
+				return nil, fmt.Errorf("%s: app %d: ids from %d are reserved for manual games", path, g.AppID, manualAppIDMin)
+			}
+			g.Source = sourceSteam
+		case sourceManual:
+			if g.AppID < manualAppIDMin {
+				return nil, fmt.Errorf("%s: app %d: manual games need an appId of at least %d", path, g.AppID, manualAppIDMin)
+			}
+			if !manualSlugPattern.MatchString(g.Slug) {
+				return nil, fmt.Errorf("%s: app %d: slug must match %s", path, g.AppID, manualSlugPattern)
+			}
+			if _, err := os.Stat(reg.manual.file(g.Slug, "achievements.json")); err != nil {
+				return nil, fmt.Errorf("%s: app %d: %w", path, g.AppID, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s: app %d: unknown source %q", path, g.AppID, g.Source)
+		}
+		games[g.AppID] = g
+	}
+	return reg, nil
+}
+
+// sourceFor returns the source of appID; unlisted games come from Steam.
+// The check command runs without a registry.
+func (s *Server) sourceFor(appID AppID) achievementSource {
+	if s.games == nil {
+		return steamSource{apiKey: s.apiKey}
+	}
+	if g, ok := s.games.games[appID]; ok && g.Source == sourceManual {
+		return s.games.manual
+	}
+	return s.games.steam
+}
+
+type steamSource struct {
+	apiKey string
+}
+
+func (steamSource) Name() string { return sourceSteam }
+
+func (src steamSource) FetchSchema(ctx context.Context, appID AppID, lang string) ([]Achievement, error) {
+	return fetchSchemaForGame(ctx, src.apiKey, appID, lang)
+}
+
+func (steamSource) FetchPercentages(ctx context.Context, appID AppID) (map[string]float64, error) {
+	return fetchGlobalPercentages(ctx, appID)
+}
+
+// manualSource reads one directory per game, read on every cache miss so
+// edits show up after the schema TTL:
+//
+//	data/sources/jam-2025/achievements.json         schema, required
+//	data/sources/jam-2025/achievements.<lang>.json  translated schema, optional
+//	data/sources/jam-2025/unlocks.json              unlocks of registered players, optional
+//
+// achievements.json is {"achievements": [{"apiName", "name", "description",
+// "icon", "iconGray", "hidden", "globalPct"}]}. unlocks.json is
+// {"players": {"<steamId>": ["API_NAME", ...]}}; when it lists at least one
+// player, percentages are recomputed from it and the curated globalPct
+// values are ignored.
+type manualSource struct {
+	dir   string
+	games map[AppID]gameEntry
+}
+
+type manualAchievement struct {
+	APIName     string  `json:"apiName"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Icon        string  `json:"icon"`
+	IconGray    string  `json:"iconGray"`
+	Hidden      bool    `json:"hidden"`
+	GlobalPct   float64 `json:"globalPct"`
+}
+
+func (manualSource) Name() string { return sourceManual }
+
+func (src manualSource) file(slug, name string) string {
+	return filepath.Join(src.dir, slug, name)
+}
+
+func (src manualSource) slug(appID AppID) (string, error) {
+	g, ok := src.games[appID]
+	if !ok || g.Source != sourceManual {
+		return "", fmt.Errorf("app %d is not a manual game", appID)
+	}
+	return g.Slug, nil
+}
+
+func (src manualSource) readAchievements(appID AppID, lang string) ([]manualAchievement, error) {
+	slug, err := src.slug(appID)
+	if err != nil {
+		return nil, err
+	}
+	path := src.file(slug, "achievements.json")
+	if lang != "" {
+		translated := src.file(slug, "achievements."+lang+".json")
+		if _, err := os.Stat(translated); err == nil {
+			path = translated
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Achievements []manualAchievement `json:"achievements"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := make(map[string]bool, len(raw.Achievements))
+	for _, a := range raw.Achievements {
+		if a.APIName == "" || seen[a.APIName] {
+			return nil, fmt.Errorf("%s: missing or duplicate apiName %q", path, a.APIName)
+		}
+		seen[a.APIName] = true
+	}
+	return raw.Achievements, nil
+}
+
+func (src manualSource) FetchSchema(ctx context.Context, appID AppID, lang string) ([]Achievement, error) {
+	raw, err := src.readAchievements(appID, lang)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Achievement, 0, len(raw))
+	for _, a := range raw {
+		out = append(out, Achievement{
+			APIName:     a.APIName,
+			Name:        a.Name,
+			Description: a.Description,
+			Icon:        a.Icon,
+			IconGray:    a.IconGray,
+			Hidden:      a.Hidden,
+		})
+	}
+	return out, nil
+}
+
+func (src manualSource) FetchPercentages(ctx context.Context, appID AppID) (map[string]float64, error) {
+	raw, err := src.readAchievements(appID, "")
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]float64, len(raw))
+	for _, a := range raw {
+		out[a.APIName] = a.GlobalPct
+	}
+
+	slug, _ := src.slug(appID)
+	path := src.file(slug, "unlocks.json")
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var unlocks struct {
+		Players map[string][]string `json:"players"`
+	}
+	if err := json.Unmarshal(b, &unlocks); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(unlocks.Players) == 0 {
+		return out, nil
+	}
+	counts := make(map[string]int, len(out))
+	for _, names := range unlocks.Players {
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if _, known := out[name]; known && !seen[name] {
+				seen[name] = true
+				counts[name]++
+			}
+		}
+	}
+	for name := range out {
+		out[name] = math.Round(float64(counts[name])*100/float64(len(unlocks.Players))*1e4) / 1e4
+	}
+	return out, nil
+}
+
+// appAchievementList is the schema of appID in lang with its global
+// percentages, from the per-app caches. The result is a copy the caller may
+// modify. A percentages failure is logged and leaves them at 0.
+func (s *Server) appAchievementList(ctx context.Context, appID AppID, lang string) ([]Achievement, error) {
+	items, err := s.localizedSchema(ctx, appID, lang)
+	if err != nil {
+		return nil, err
+	}
+	pcts, err := s.fetchGlobalPercentagesCached(ctx, appID)
+	if err != nil {
+		log.Printf("%s global pct app %d: %v", s.sourceFor(appID).Name(), appID, err)
+		pcts = map[string]float64{}
+	}
+
+	for i := range items {
+		items[i].GlobalPct = pcts[items[i].APIName]
+	}
+	return items, nil
+}
+
+// handleSourceAchievements serves /achievements?appId= for any game other
+// than the default one, from the per-app caches filled by its source.
+func (s *Server) handleSourceAchievements(w http.ResponseWriter, r *http.Request, appID AppID, query achievementQuery) {
+	source := s.sourceFor(appID)
+	w.Header().Set("X-Achievement-Source", source.Name())
+	setSurrogateKeys(w, appSurrogateKey(appID), langSurrogateKey(query.lang))
+	if r.URL.Query().Has("asOf") {
+		writeError(w, http.StatusBadRequest, "invalid_query", "asOf n'est disponible que pour l'app par defaut")
+		return
+	}
+	factor, ok := s.percentageBaseline(w, appID, &query)
+	if !ok {
+		return
+	}
+
+	s.refresher.watch(r.Context(), appID, query.lang)
+	ctx, stale := withStaleTracking(r.Context())
+	idx, err := s.appAchievementIndex(ctx, appID, query.lang)
+	if errors.Is(err, apperr.ErrReadOnly) {
+		writeReadOnly(w)
+		return
+	}
+	if errors.Is(err, apperr.ErrUpstreamQuota) || errors.Is(err, apperr.ErrUpstreamOpen) {
+		writeAppError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("%s schema app %d: %v", source.Name(), appID, err)
+		writeError(w, http.StatusBadGateway, "source_error", "Echec de lecture des succes du jeu depuis la source "+source.Name())
+		return
+	}
+	if len(idx.items) == 0 {
+		writeNoAchievements(w, appID, s.emptySchemaStatus(r.Context(), appID))
+		return
+	}
+	items, unknownTags := query.applyIndex(s.overlay, s.policyFor(w, appID), idx)
+	rescaleGlobalPct(items, factor)
+	if err := s.applyLocalPct(w, appID, items); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	query.writeDebug(w, unknownTags)
+	s.setAppDataAge(r.Context(), w, appID, query.lang)
+	if stale.Load() {
+		writeStaleHeaders(w)
+	}
+
+	query.order.sort(items)
+	var version int64
+	if fetchedAt := s.appDataFetchedAt(r.Context(), appID, query.lang); !fetchedAt.IsZero() {
+		version = fetchedAt.Unix()
+	}
+	items, page, ok := paginate(w, r, query.order, items, version)
+	if !ok {
+		return
+	}
+	if query.export(w, appID, items) {
+		return
+	}
+	s.proxyIcons(appID, items)
+	query.write(w, r, paged(page, query.project(items)))
+}
+
+// writeNoAchievements answers 404 for a game whose schema is empty, with
+// the reason (delisted or no achievements) in "status".
+func writeNoAchievements(w http.ResponseWriter, appID AppID, status string) {
+	writeJSONStatus(w, http.StatusNotFound, map[string]any{
+		"error":   "no_achievements",
+		"details": fmt.Sprintf("Aucun succes pour l'app %d", appID),
+		"status":  status,
+	})
+}
+
+// writeSourceUnsupported answers player endpoints for games whose source
+// has no player data.
+func writeSourceUnsupported(w http.ResponseWriter, appID AppID, source string) {
+	w.Header().Set("X-Achievement-Source", source)
+	writeError(w, http.StatusNotFound, "source_unsupported", fmt.Sprintf("Les donnees joueur ne sont pas disponibles pour l'app %d (source %s)", appID, source))
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// Icon sprite sheet: every icon of an app on one PNG grid, described by a
+// manifest. Updates are incremental: cells keep their position across
+// refreshes and only cells whose icon hash changed are fetched and redrawn
+// over the previous sheet.
+//
+// With PROXY_ICONS, each schema the server stores (default language)
+// updates the sheet of its app in the background, from the icons of the
+// proxy cache, into ICON_CACHE_DIR/sprites/{appId}. An unchanged schema
+// leaves the files untouched. Hidden and redacted achievements get no
+// cell. /icon-sprite serves the manifest and /icon-sprite.png the sheet.
+const (
+	spriteCellSize    = 64
+	spriteColumns     = 16
+	spriteManifestAge = 5 * time.Minute
+	// spriteFetchTries bounds the waits for a download slot of one icon.
+	spriteFetchTries = 40
+)
+
+type spriteManifest struct {
+	// Version is bumped whenever the sheet bytes change so clients can
+	// cache-bust with ?v=.
+	Version  int          `json:"version"`
+	CellSize int          `json:"cellSize"`
+	Columns  int          `json:"columns"`
+	Rows     int          `json:"rows"`
+	Cells    []spriteCell `json:"cells"`
+}
+
+type spriteCell struct {
+	APIName string `json:"apiName"`
+	Index   int    `json:"index"`
+	Hash    string `json:"hash"`
+}
+
+// spriteIcon is one wanted cell. Steam icon URLs embed a content hash, so
+// hashing the URL tells a changed icon without downloading it.
+type spriteIcon struct {
+	APIName string
+	URL     string
+}
+
+func spriteIconHash(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+// updateSprite returns the manifest and sheet for icons, reusing prev and
+// prevSheet when they have the same geometry. fetch is only called for new
+// or changed cells; changed lists the redrawn cell indexes. When nothing
+// changed, the previous sheet is returned as is.
+func updateSprite(prev *spriteManifest, prevSheet image.Image, icons []spriteIcon, fetch func(url string) (image.Image, error)) (*spriteManifest, *image.NRGBA, []int, error) {
+	reflow := prev == nil || prevSheet == nil || prev.CellSize != spriteCellSize || prev.Columns != spriteColumns
+	next := &spriteManifest{CellSize: spriteCellSize, Columns: spriteColumns}
+	if prev != nil {
+		next.Version = prev.Version
+	}
+	old := make(map[string]spriteCell)
+	if !reflow {
+		for _, c := range prev.Cells {
+			old[c.APIName] = c
+		}
+	}
+
+	// Surviving cells keep their index; new ones take freed slots first.
+	wanted := make(map[string]bool, len(icons))
+	for _, ic := range icons {
+		wanted[ic.APIName] = true
+	}
+	used := make(map[int]bool)
+	var freed []int
+	for _, c := range old {
+		if wanted[c.APIName] {
+			used[c.Index] = true
+		}
+	}
+	if !reflow {
+		for _, c := range prev.Cells {
+			if !wanted[c.APIName] {
+				freed = append(freed, c.Index)
+			}
+		}
+	}
+	nextFree := 0
+	allocate := func() int {
+		if len(freed) > 0 {
+			i := freed[0]
+			freed = freed[1:]
+			used[i] = true
+			return i
+		}
+		for used[nextFree] {
+			nextFree++
+		}
+		used[nextFree] = true
+		return nextFree
+	}
+
+	var changed []int
+	maxIndex := -1
+	for _, ic := range icons {
+		hash := spriteIconHash(ic.URL)
+		c, ok := old[ic.APIName]
+		if !ok {
+			c = spriteCell{APIName: ic.APIName, Index: allocate()}
+		}
+		if c.Hash != hash {
+			c.Hash = hash
+			changed = append(changed, c.Index)
+		}
+		next.Cells = append(next.Cells, c)
+		maxIndex = max(maxIndex, c.Index)
+	}
+	if !reflow {
+		// Freed cells no longer in use must be cleared.
+		for _, i := range freed {
+			changed = append(changed, i)
+			maxIndex = max(maxIndex, i)
+		}
+	}
+	next.Rows = max(1, (maxIndex+spriteColumns)/spriteColumns)
+	if !reflow {
+		next.Rows = max(next.Rows, prev.Rows)
+	}
+
+	grew := !reflow && next.Rows != prev.Rows
+	if !reflow && len(changed) == 0 && !grew {
+		if sheet, ok := prevSheet.(*image.NRGBA); ok {
+			return next, sheet, nil, nil
+		}
+	}
+
+	sheet := image.NewNRGBA(image.Rect(0, 0, spriteColumns*spriteCellSize, next.Rows*spriteCellSize))
+	if !reflow {
+		draw.Draw(sheet, prevSheet.Bounds(), prevSheet, prevSheet.Bounds().Min, draw.Src)
+	}
+	byIndex := make(map[int]spriteIcon, len(icons))
+	for i, c := range next.Cells {
+		byIndex[c.Index] = icons[i]
+	}
+	for _, idx := range changed {
+		r := spriteCellRect(idx)
+		draw.Draw(sheet, r, image.Transparent, image.Point{}, draw.Src)
+		ic, ok := byIndex[idx]
+		if !ok {
+			continue
+		}
+		img, err := fetch(ic.URL)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("icon %s: %w", ic.APIName, err)
+		}
+		drawScaled(sheet, r, img)
+	}
+	next.Version++
+	return next, sheet, changed, nil
+}
+
+func spriteCellRect(index int) image.Rectangle {
+	x, y := (index%spriteColumns)*spriteCellSize, (index/spriteColumns)*spriteCellSize
+	return image.Rect(x, y, x+spriteCellSize, y+spriteCellSize)
+}
+
+// drawScaled draws src into r with nearest-neighbour scaling; Steam icons
+// are already 64x64, so this is a plain copy in practice.
+func drawScaled(dst draw.Image, r image.Rectangle, src image.Image) {
+	b := src.Bounds()
+	if b.Dx() == r.Dx() && b.Dy() == r.Dy() {
+		draw.Draw(dst, r, src, b.Min, draw.Over)
+		return
+	}
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			dst.Set(r.Min.X+x, r.Min.Y+y, src.At(b.Min.X+x*b.Dx()/r.Dx(), b.Min.Y+y*b.Dy()/r.Dy()))
+		}
+	}
+}
+
+// writeSpriteFiles replaces sheet.png and manifest.json in dir, each via a
+// temporary file and a rename so readers never see a half-written sheet.
+func writeSpriteFiles(dir string, m *spriteManifest, sheet image.Image) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "sheet.png"), func(f *os.File) error { return png.Encode(f, sheet) }); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, "manifest.json"), func(f *os.File) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	})
+}
+
+func writeFileAtomic(path string, write func(f *os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (ip *iconProxy) spriteDir(appID AppID) string {
+	return filepath.Join(ip.dir, "sprites", appID.String())
+}
+
+// readSpriteFiles loads what writeSpriteFiles wrote, nil when there is
+// nothing usable. The sheet is returned as NRGBA, the type updateSprite
+// hands back as is when nothing changed.
+func readSpriteFiles(dir string) (*spriteManifest, *image.NRGBA) {
+	m, err := readSpriteManifest(dir)
+	if err != nil {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(dir, "sheet.png"))
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, nil
+	}
+	sheet, ok := img.(*image.NRGBA)
+	if !ok {
+		sheet = image.NewNRGBA(img.Bounds())
+		draw.Draw(sheet, sheet.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	return m, sheet
+}
+
+func readSpriteManifest(dir string) (*spriteManifest, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var m spriteManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// spriteImage is the decoded icon at url, through the proxy cache. It
+// waits for a download slot instead of giving up like a page load does.
+func (ip *iconProxy) spriteImage(url string) (image.Image, error) {
+	var path string
+	var err error
+	for try := 0; try < spriteFetchTries; try++ {
+		if path, err = ip.fetch(url); !errors.Is(err, apperr.ErrIconBusy) {
+			break
+		}
+		time.Sleep(iconSlotWait)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// updateSpriteFiles brings the sheet of appID up to date with icons and
+// returns the redrawn cells; the files are only written when a cell
+// changed.
+func (ip *iconProxy) updateSpriteFiles(appID AppID, icons []spriteIcon) ([]int, error) {
+	ip.spriteMu.Lock()
+	defer ip.spriteMu.Unlock()
+	dir := ip.spriteDir(appID)
+	prev, prevSheet := readSpriteFiles(dir)
+	var prevImage image.Image
+	if prevSheet != nil {
+		prevImage = prevSheet
+	}
+	m, sheet, changed, err := updateSprite(prev, prevImage, icons, ip.spriteImage)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil && m.Version == prev.Version {
+		return nil, nil
+	}
+	return changed, writeSpriteFiles(dir, m, sheet)
+}
+
+// refreshSprite updates the sheet of appID from items in the background.
+func (s *Server) refreshSprite(appID AppID, items []Achievement) {
+	if s.icons == nil {
+		return
+	}
+	p := s.policies.forApp(appID)
+	icons := make([]spriteIcon, 0, len(items))
+	for _, a := range items {
+		if a.Icon != "" && !p.hidden(a.APIName) && !p.redacted(a.APIName) {
+			icons = append(icons, spriteIcon{APIName: a.APIName, URL: a.Icon})
+		}
+	}
+	go func() {
+		changed, err := s.icons.updateSpriteFiles(appID, icons)
+		if err != nil {
+			log.Printf("icon sprite app %d: %v", appID, err)
+		} else if len(changed) > 0 {
+			log.Printf("icon sprite app %d: %d cells redrawn", appID, len(changed))
+		}
+	}()
+}
+
+// spriteApp reads ?appId= of the sprite routes, answering the errors.
+func (s *Server) spriteApp(w http.ResponseWriter, r *http.Request) (AppID, bool) {
+	if s.icons == nil {
+		writeError(w, http.StatusNotFound, "icons_not_proxied", "Le proxy d'icones est desactive (PROXY_ICONS)")
+		return 0, false
+	}
+	appID := defaultGlobalAppID
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		v, err := parseAppID(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return 0, false
+		}
+		appID = v
+	}
+	if !s.appAllowed(appID) {
+		writeAppNotAllowed(w, appID)
+		return 0, false
+	}
+	return appID, true
+}
+
+type spriteManifestResponse struct {
+	*spriteManifest
+	Sheet string `json:"sheet"`
+}
+
+func (s *Server) handleSpriteManifest(w http.ResponseWriter, r *http.Request) {
+	appID, ok := s.spriteApp(w, r)
+	if !ok {
+		return
+	}
+	m, err := readSpriteManifest(s.icons.spriteDir(appID))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "sprite_not_ready", "Planche d'icones pas encore generee pour cette app")
+		return
+	}
+	q := url.Values{}
+	if appID != defaultGlobalAppID {
+		q.Set("appId", appID.String())
+	}
+	q.Set("v", strconv.Itoa(m.Version))
+	w.Header().Set("Cache-Control", cacheControlMaxAge(spriteManifestAge))
+	setSurrogateKeys(w, appSurrogateKey(appID))
+	writeJSON(w, spriteManifestResponse{spriteManifest: m, Sheet: "/api/icon-sprite.png?" + q.Encode()})
+}
+
+// handleSpriteSheet serves the sheet, cached for a year when ?v= is the
+// version of the manifest next to it. Both files are replaced by renames,
+// so no lock is taken: a sheet read during an update only misses the
+// long cache.
+func (s *Server) handleSpriteSheet(w http.ResponseWriter, r *http.Request) {
+	appID, ok := s.spriteApp(w, r)
+	if !ok {
+		return
+	}
+	dir := s.icons.spriteDir(appID)
+	f, err := os.Open(filepath.Join(dir, "sheet.png"))
+	m, _ := readSpriteManifest(dir)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "sprite_not_ready", "Planche d'icones pas encore generee pour cette app")
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "sprite_unavailable", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	if m != nil && r.URL.Query().Get("v") == strconv.Itoa(m.Version) {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", cacheControlMaxAge(spriteManifestAge))
+	}
+	setSurrogateKeys(w, appSurrogateKey(appID))
+	http.ServeContent(w, r, "", st.ModTime(), f)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// spriteCDN serves a solid icon per path, its color taken from the path,
+// and counts the downloads.
+type spriteCDN struct {
+	srv   *httptest.Server
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newSpriteCDN(t *testing.T) *spriteCDN {
+	c := &spriteCDN{calls: make(map[string]int)}
+	c.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.calls[r.URL.Path]++
+		c.mu.Unlock()
+		var n int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/"), "%d", &n)
+		var b bytes.Buffer
+		png.Encode(&b, solidIcon(color.NRGBA{uint8(n * 7), uint8(n * 13), uint8(n * 29), 0xff}))
+		w.Write(b.Bytes())
+	}))
+	t.Cleanup(c.srv.Close)
+	return c
+}
+
+func (c *spriteCDN) downloads() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, v := range c.calls {
+		n += v
+	}
+	return n
+}
+
+func (c *spriteCDN) icons(n int) []spriteIcon {
+	out := make([]spriteIcon, n)
+	for i := range out {
+		out[i] = spriteIcon{APIName: fmt.Sprintf("ACH_%02d", i), URL: fmt.Sprintf("%s/%d.png", c.srv.URL, i+1)}
+	}
+	return out
+}
+
+func spriteFile(t *testing.T, ip *iconProxy, appID AppID, name string) ([]byte, time.Time) {
+	t.Helper()
+	path := filepath.Join(ip.spriteDir(appID), name)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := os.Stat(path)
+	return b, st.ModTime()
+}
+
+func TestSpriteUnchangedSchemaIsByteIdentical(t *testing.T) {
+	cdn := newSpriteCDN(t)
+	ip := testIconProxy(t, t.TempDir())
+	icons := cdn.icons(5)
+
+	changed, err := ip.updateSpriteFiles(105600, icons)
+	if err != nil || len(changed) != 5 {
+		t.Fatalf("first build: changed %v, %v", changed, err)
+	}
+	sheet, sheetAt := spriteFile(t, ip, 105600, "sheet.png")
+	manifest, _ := spriteFile(t, ip, 105600, "manifest.json")
+	downloads := cdn.downloads()
+
+	time.Sleep(10 * time.Millisecond)
+	changed, err = ip.updateSpriteFiles(105600, icons)
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("rebuild: changed %v, %v", changed, err)
+	}
+	again, againAt := spriteFile(t, ip, 105600, "sheet.png")
+	manifestAgain, _ := spriteFile(t, ip, 105600, "manifest.json")
+	if !bytes.Equal(sheet, again) || !bytes.Equal(manifest, manifestAgain) || !againAt.Equal(sheetAt) {
+		t.Errorf("unchanged schema rewrote the sprite")
+	}
+	if cdn.downloads() != downloads {
+		t.Errorf("unchanged schema downloaded %d icons", cdn.downloads()-downloads)
+	}
+
+	// Redrawing every cell from the same icons gives the same bytes too.
+	m, img, _, err := updateSprite(nil, nil, icons, ip.spriteImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	png.Encode(&b, img)
+	if !bytes.Equal(b.Bytes(), sheet) || m.Version != 1 {
+		t.Errorf("full rebuild differs from the incremental sheet (version %d)", m.Version)
+	}
+}
+
+func TestSpriteSingleIconChangeTouchesOneCell(t *testing.T) {
+	cdn := newSpriteCDN(t)
+	ip := testIconProxy(t, t.TempDir())
+	icons := cdn.icons(20) // two rows
+	if _, err := ip.updateSpriteFiles(105600, icons); err != nil {
+		t.Fatal(err)
+	}
+	before, beforeSheet := readSpriteFiles(ip.spriteDir(105600))
+	downloads := cdn.downloads()
+
+	icons[17].URL = cdn.srv.URL + "/99.png"
+	changed, err := ip.updateSpriteFiles(105600, icons)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, afterSheet := readSpriteFiles(ip.spriteDir(105600))
+	if len(changed) != 1 || changed[0] != 17 {
+		t.Fatalf("changed cells = %v, want [17]", changed)
+	}
+	if after.Version != before.Version+1 || after.Rows != 2 {
+		t.Errorf("version %d -> %d, rows %d", before.Version, after.Version, after.Rows)
+	}
+	if cdn.downloads() != downloads+1 {
+		t.Errorf("%d downloads for one changed icon", cdn.downloads()-downloads)
+	}
+	for i, c := range after.Cells {
+		if c.Index != before.Cells[i].Index {
+			t.Errorf("cell %s moved from %d to %d", c.APIName, before.Cells[i].Index, c.Index)
+		}
+	}
+
+	cell := spriteCellRect(17)
+	var inside int
+	b := afterSheet.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if afterSheet.NRGBAAt(x, y) == beforeSheet.NRGBAAt(x, y) {
+				continue
+			}
+			if !(image.Point{x, y}).In(cell) {
+				t.Fatalf("pixel %d,%d outside cell 17 changed", x, y)
+			}
+			inside++
+		}
+	}
+	if inside != spriteCellSize*spriteCellSize {
+		t.Errorf("%d pixels of cell 17 changed, want all of them", inside)
+	}
+	if got := afterSheet.NRGBAAt(cell.Min.X, cell.Min.Y); got != (color.NRGBA{99 * 7 % 256, 99 * 13 % 256, 99 * 29 % 256, 0xff}) {
+		t.Errorf("cell 17 = %v, not the new icon", got)
+	}
+}
+
+func TestSpriteGrowsAndReusesCells(t *testing.T) {
+	cdn := newSpriteCDN(t)
+	ip := testIconProxy(t, t.TempDir())
+	icons := cdn.icons(spriteColumns)
+	if _, err := ip.updateSpriteFiles(105600, icons); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := readSpriteFiles(ip.spriteDir(105600))
+	if m.Rows != 1 {
+		t.Fatalf("%d rows for %d icons", m.Rows, spriteColumns)
+	}
+
+	// One removed, two added: the first takes the freed cell, the second
+	// a new row.
+	grown := append(slices.Clone(icons[:3]), icons[4:]...)
+	more := cdn.icons(spriteColumns + 2)
+	grown = append(grown, more[spriteColumns], more[spriteColumns+1])
+	changed, err := ip.updateSpriteFiles(105600, grown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, sheet := readSpriteFiles(ip.spriteDir(105600))
+	slices.Sort(changed)
+	if !slices.Equal(changed, []int{3, spriteColumns}) || m2.Rows != 2 || m2.Version != m.Version+1 {
+		t.Fatalf("changed %v, rows %d, version %d", changed, m2.Rows, m2.Version)
+	}
+	if sheet.Bounds().Dy() != 2*spriteCellSize {
+		t.Errorf("sheet height %d", sheet.Bounds().Dy())
+	}
+}
+
+func TestRefreshSpriteSkipsHiddenAndRedacted(t *testing.T) {
+	cdn := newSpriteCDN(t)
+	policies := t.TempDir()
+	os.WriteFile(filepath.Join(policies, "105600.json"), []byte(`{"hide":["ACH_01"],"redact":["ACH_02"]}`), 0o644)
+	ps, err := loadPolicyStore(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{icons: testIconProxy(t, t.TempDir()), policies: ps}
+	var items []Achievement
+	for _, ic := range cdn.icons(4) {
+		items = append(items, Achievement{APIName: ic.APIName, Icon: ic.URL})
+	}
+	items = append(items, Achievement{APIName: "NO_ICON"})
+	s.refreshSprite(105600, items)
+
+	deadline := time.Now().Add(2 * time.Second)
+	var m *spriteManifest
+	for m == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		m, _ = readSpriteManifest(s.icons.spriteDir(105600))
+	}
+	if m == nil {
+		t.Fatal("no sprite written")
+	}
+	var names []string
+	for _, c := range m.Cells {
+		names = append(names, c.APIName)
+	}
+	if strings.Join(names, ",") != "ACH_00,ACH_03" {
+		t.Errorf("cells = %v, want ACH_00,ACH_03", names)
+	}
+}
+
+func TestSpriteHandlers(t *testing.T) {
+	plain := &Server{}
+	w := httptest.NewRecorder()
+	plain.handleSpriteManifest(w, httptest.NewRequest(http.MethodGet, "/api/v1/icon-sprite", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "icons_not_proxied") {
+		t.Fatalf("without PROXY_ICONS: %d %s", w.Code, w.Body)
+	}
+
+	cdn := newSpriteCDN(t)
+	s := &Server{icons: testIconProxy(t, t.TempDir())}
+	w = httptest.NewRecorder()
+	s.handleSpriteManifest(w, httptest.NewRequest(http.MethodGet, "/api/v1/icon-sprite", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "sprite_not_ready") {
+		t.Fatalf("before the first build: %d %s", w.Code, w.Body)
+	}
+	if _, err := s.icons.updateSpriteFiles(105600, cdn.icons(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleSpriteManifest(w, httptest.NewRequest(http.MethodGet, "/api/v1/icon-sprite", nil))
+	var got struct {
+		Version int          `json:"version"`
+		Cells   []spriteCell `json:"cells"`
+		Sheet   string       `json:"sheet"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Version != 1 || len(got.Cells) != 3 || got.Sheet != "/api/icon-sprite.png?v=1" {
+		t.Fatalf("manifest = %+v, %v (%s)", got, err, w.Body)
+	}
+
+	for v, cache := range map[string]string{"1": immutableCacheControl, "0": cacheControlMaxAge(spriteManifestAge)} {
+		w = httptest.NewRecorder()
+		s.handleSpriteSheet(w, httptest.NewRequest(http.MethodGet, "/api/v1/icon-sprite.png?v="+v, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || w.Header().Get("Cache-Control") != cache {
+			t.Errorf("?v=%s: %d %s, Cache-Control %q", v, w.Code, w.Header().Get("Content-Type"), w.Header().Get("Cache-Control"))
+		}
+		if _, err := png.Decode(w.Body); err != nil {
+			t.Errorf("?v=%s: sheet does not decode: %v", v, err)
+		}
+	}
+}
@@ -6,6 +6,11 @@ let suggestionByValue = new Map();
 let suggestionsByName = new Map();
 let suggestionsTimer = null;
 const API_FALLBACKS = ["http://127.0.0.1:8099", "http://localhost:8099"];
+const GAME_STATUS_LABELS = {
+  no_achievements: "Aucun achievement",
+  delisted: "Retire du store",
+  error: "Indisponible",
+};
 
 const els = {
   steamForm: document.getElementById("steamForm"),
@@ -65,6 +70,22 @@ function formatSuggestionLabel(item) {
   return `${name} (${item.steamId})`;
 }
 
+// Data age of the last API response, from X-Data-Fetched-At / X-Data-Stale.
+let lastDataAge = null;
+
+function readDataAge(res) {
+  const fetchedAt = res.headers.get("X-Data-Fetched-At");
+  lastDataAge = fetchedAt ? { fetchedAt: new Date(fetchedAt), stale: res.headers.get("X-Data-Stale") === "1" } : null;
+}
+
+function formatDataAge(age) {
+  if (!age) {
+    return "";
+  }
+  const when = age.fetchedAt.toLocaleString("fr-FR", { dateStyle: "short", timeStyle: "short" });
+  return age.stale ? ` · données du ${when} (périmées)` : ` · données du ${when}`;
+}
+
 async function getJSON(url) {
   const isApiPath = typeof url === "string" && url.startsWith("/api/");
   if (!isApiPath) {
@@ -95,6 +116,7 @@ async function getJSON(url) {
       const res = await fetch(fullURL, { cache: "no-store" });
       const body = await res.json().catch(() => null);
       if (res.ok) {
+        readDataAge(res);
         return body;
       }
       // On a static server (Five Server), /api/* usually returns 404 HTML.
@@ -269,7 +291,7 @@ async function loadAchievements(appId, gameName) {
     currentGameName = gameName;
     showAchievementsView();
     renderAchievements();
-    els.status.textContent = `Achievements: ${gameName}`;
+    els.status.textContent = `Achievements: ${gameName}${formatDataAge(lastDataAge)}`;
   } catch (err) {
     els.status.textContent = "Erreur";
     setError(err.message || "Erreur inconnue");
@@ -311,9 +333,13 @@ function renderGames() {
     const pct = (g.completionPct ?? 0).toFixed(2);
     const unlocked = g.unlockedAchievements ?? 0;
     const total = g.totalAchievements ?? 0;
+    const status = g.status || "ok";
+    const action = status === "ok"
+      ? `<button class="smallBtn" data-appid="${esc(g.appId)}" data-name="${esc(g.name || "")}">Voir les achievements</button>`
+      : `<span class="muted">${esc(GAME_STATUS_LABELS[status] || status)}</span>`;
 
     return `
-      <article class="card gameCard">
+      <article class="card gameCard${status === "ok" ? "" : " broken"}">
         <div class="body">
           <div class="topline">
             <h3 class="title">${esc(g.name || `App ${g.appId}`)}</h3>
@@ -323,7 +349,7 @@ function renderGames() {
           <div class="progress"><span style="width:${Math.max(0, Math.min(100, g.completionPct ?? 0))}%"></span></div>
           <div class="foot">
             <code class="api">AppID ${esc(g.appId)}</code>
-            <button class="smallBtn" data-appid="${esc(g.appId)}" data-name="${esc(g.name || "")}">Voir les achievements</button>
+            ${action}
           </div>
         </div>
       </article>
@@ -376,12 +402,16 @@ function renderAchievements() {
     const pct = (a.globalPct ?? 0).toFixed(2) + "%";
     const status = a.achieved ? "debloque" : "verrouille";
     const statusClass = a.achieved ? "unlocked" : "locked";
-    const desc = a.description?.trim() ? esc(a.description) : "<em class='muted'>Pas de description</em>";
+    const desc = a.description?.trim()
+      ? esc(a.description)
+      : a.descriptionHidden
+        ? "<em class='muted'>Succes secret, description masquee</em>"
+        : "<em class='muted'>Pas de description</em>";
 
     return `
       <article class="card">
         <div class="iconWrap ${statusClass}">
-          <img class="icon" src="${esc(a.icon || a.iconGray || "")}" alt="" loading="lazy" />
+          <img class="icon" src="${esc(a.icon || a.iconGray || "")}" alt="${esc(a.iconAlt || "")}" loading="lazy" />
         </div>
         <div class="body">
           <div class="topline">
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Files whose name carries a content hash (app.3f2a1c.js) never change and
+// are cached forever; everything else is revalidated through its ETag.
+const defaultHashedAssetPattern = `\.[0-9a-f]{6,}\.[A-Za-z0-9]+$`
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+type staticAsset struct {
+	etag    string
+	hashed  bool
+	corrupt bool
+}
+
+type staticHandler struct {
+	files  http.Handler
+	assets map[string]staticAsset
+}
+
+// newStaticHandler hashes every file once at startup. When manifestPath is
+// set it must be a JSON object {"app.3f2a1c.js": "<sha256 hex>"}; hashed
+// files missing from it or not matching it are refused.
+func newStaticHandler(fsys fs.FS, hashedPattern string, manifestPath string) (*staticHandler, error) {
+	re, err := regexp.Compile(hashedPattern)
+	if err != nil {
+		return nil, fmt.Errorf("hashed asset pattern: %w", err)
+	}
+
+	var manifest map[string]string
+	if manifestPath != "" {
+		b, err := os.ReadFile(manifestPath)
+		if err != nil {
+			return nil, fmt.Errorf("static manifest: %w", err)
+		}
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return nil, fmt.Errorf("static manifest: %w", err)
+		}
+	}
+
+	h := &staticHandler{files: http.FileServer(http.FS(fsys)), assets: make(map[string]staticAsset)}
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		digest := hex.EncodeToString(sum[:])
+
+		asset := staticAsset{etag: `"` + digest[:32] + `"`, hashed: re.MatchString(path.Base(name))}
+		if asset.hashed && manifest != nil && !strings.EqualFold(manifest[name], digest) {
+			asset.corrupt = true
+			log.Printf("static: %s does not match the manifest, it will not be served", name)
+		}
+		h.assets[name] = asset
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	if asset, ok := h.assets[name]; ok {
+		if asset.corrupt {
+			http.Error(w, "asset integrity check failed", http.StatusInternalServerError)
+			return
+		}
+		if asset.hashed {
+			w.Header().Set("Cache-Control", immutableCacheControl)
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", asset.etag)
+		}
+	}
+	h.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useDiskFrontEnd runs the test in a directory whose ./static and
+// ./templates differ from the embedded ones.
+func useDiskFrontEnd(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"static/index.html":           "<p>disque</p>",
+		"templates/achievements.html": "disque {{len .Items}}",
+	} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	t.Setenv("STATIC_DIR", "")
+	saved := devMode
+	t.Cleanup(func() { devMode = saved })
+}
+
+func frontEndIndex(t *testing.T) string {
+	t.Helper()
+	h, err := frontEndHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /: %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestEmbeddedFrontEndWithoutDev(t *testing.T) {
+	useDiskFrontEnd(t)
+	embedded, err := embeddedStatic.ReadFile("static/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devMode = false
+	if got := frontEndIndex(t); got != string(embedded) {
+		t.Errorf("without -dev, / = %.60q, want the embedded index.html", got)
+	}
+	var b strings.Builder
+	if err := achievementsHTMLTemplate.Execute(&b, achievementExport{AppID: 105600}); err != nil || strings.HasPrefix(b.String(), "disque") {
+		t.Errorf("without -dev, the HTML export used templates/achievements.html: %.60q, %v", b.String(), err)
+	}
+
+	devMode = true
+	if got := frontEndIndex(t); got != "<p>disque</p>" {
+		t.Errorf("with -dev, / = %.60q, want ./static/index.html", got)
+	}
+	b.Reset()
+	if err := achievementsHTMLTemplate.Execute(&b, achievementExport{AppID: 105600}); err != nil || b.String() != "disque 0" {
+		t.Errorf("with -dev, the HTML export = %.60q, %v; want templates/achievements.html", b.String(), err)
+	}
+}
+
+// STATIC_DIR is the explicit way to serve the front end from disk.
+func TestStaticDirOverride(t *testing.T) {
+	useDiskFrontEnd(t)
+	devMode = false
+	t.Setenv("STATIC_DIR", "static")
+	if got := frontEndIndex(t); got != "<p>disque</p>" {
+		t.Errorf("STATIC_DIR=static, / = %.60q", got)
+	}
+}
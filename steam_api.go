@@ -1,21 +1,28 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	neturl "net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"yboost-projet-25-26/internal/apperr"
 )
 
-func fetchSchemaForGame(apiKey string, appid int, lang string) ([]Achievement, error) {
+// fetchSchemaForGame and fetchGlobalPercentages share steamLimiter.
+func fetchSchemaForGame(ctx context.Context, apiKey string, appid AppID, lang string) ([]Achievement, error) {
 	url := fmt.Sprintf("https://api.steampowered.com/ISteamUserStats/GetSchemaForGame/v2/?key=%s&appid=%d&l=%s&format=json",
 		apiKey, appid, lang)
 
-	body, err := httpGET(url)
+	if err := steamLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	body, _, err := httpGETContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -35,8 +42,8 @@ func fetchSchemaForGame(apiKey string, appid int, lang string) ([]Achievement, e
 		} `json:"game"`
 	}
 
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, fmt.Errorf("schema json parse: %w", err)
+	if err := decodeUpstreamJSON(url, body, &resp, "schema"); err != nil {
+		return nil, err
 	}
 
 	out := make([]Achievement, 0, len(resp.Game.AvailableGameStats.Achievements))
@@ -53,10 +60,13 @@ func fetchSchemaForGame(apiKey string, appid int, lang string) ([]Achievement, e
 	return out, nil
 }
 
-func fetchGlobalPercentages(appid int) (map[string]float64, error) {
+func fetchGlobalPercentages(ctx context.Context, appid AppID) (map[string]float64, error) {
 	url := fmt.Sprintf("https://api.steampowered.com/ISteamUserStats/GetGlobalAchievementPercentagesForApp/v0002/?gameid=%d&format=json", appid)
 
-	body, err := httpGET(url)
+	if err := steamLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	body, err := hedgedGET(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -70,8 +80,8 @@ func fetchGlobalPercentages(appid int) (map[string]float64, error) {
 		} `json:"achievementpercentages"`
 	}
 
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, fmt.Errorf("global pct json parse: %w", err)
+	if err := decodeUpstreamJSON(url, body, &resp, "global pct"); err != nil {
+		return nil, err
 	}
 
 	out := make(map[string]float64, len(resp.AchievementPercentages.Achievements))
@@ -81,13 +91,37 @@ func fetchGlobalPercentages(appid int) (map[string]float64, error) {
 	return out, nil
 }
 
-func fetchOwnedGames(apiKey string, steamID string) ([]OwnedGame, error) {
+// fetchStoreListed asks the store API whether an app still has a store page.
+// Delisted apps answer {"<appid>": {"success": false}}.
+func fetchStoreListed(ctx context.Context, appid AppID) (bool, error) {
+	url := fmt.Sprintf("https://store.steampowered.com/api/appdetails?appids=%d&filters=basic", appid)
+
+	body, err := httpGET(ctx, url)
+	if err != nil {
+		return false, err
+	}
+
+	var resp map[string]struct {
+		Success bool `json:"success"`
+	}
+	if err := decodeUpstreamJSON(url, body, &resp, "store appdetails"); err != nil {
+		return false, err
+	}
+
+	entry, ok := resp[appid.String()]
+	if !ok {
+		return false, fmt.Errorf("store appdetails: no entry for app %d", appid)
+	}
+	return entry.Success, nil
+}
+
+func fetchOwnedGames(ctx context.Context, apiKey string, steamID SteamID) ([]OwnedGame, error) {
 	url := fmt.Sprintf("https://api.steampowered.com/IPlayerService/GetOwnedGames/v0001/?key=%s&steamid=%s&include_appinfo=1&include_played_free_games=1&format=json", apiKey, steamID)
 
-	body, status, err := httpGETWithStatus(url)
+	body, status, err := httpGETWithStatus(ctx, url)
 	if err != nil {
 		if status == http.StatusUnauthorized || status == http.StatusForbidden {
-			return nil, errInvalidSteamAPIKey
+			return nil, apperr.Wrapf(apperr.ErrInvalidKey, "owned games -> %d", status)
 		}
 		return nil, err
 	}
@@ -95,15 +129,15 @@ func fetchOwnedGames(apiKey string, steamID string) ([]OwnedGame, error) {
 	var resp struct {
 		Response struct {
 			Games []struct {
-				AppID           int    `json:"appid"`
+				AppID           AppID  `json:"appid"`
 				Name            string `json:"name"`
 				PlaytimeForever int    `json:"playtime_forever"`
 			} `json:"games"`
 		} `json:"response"`
 	}
 
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, fmt.Errorf("owned games json parse: %w", err)
+	if err := decodeUpstreamJSON(url, body, &resp, "owned games"); err != nil {
+		return nil, err
 	}
 
 	out := make([]OwnedGame, 0, len(resp.Response.Games))
@@ -114,10 +148,10 @@ func fetchOwnedGames(apiKey string, steamID string) ([]OwnedGame, error) {
 	return out, nil
 }
 
-func fetchPlayerSummary(apiKey string, steamID string) (UserProfile, error) {
+func fetchPlayerSummary(ctx context.Context, apiKey string, steamID SteamID) (UserProfile, error) {
 	url := fmt.Sprintf("https://api.steampowered.com/ISteamUser/GetPlayerSummaries/v0002/?key=%s&steamids=%s&format=json", apiKey, steamID)
 
-	body, err := httpGET(url)
+	body, err := httpGET(ctx, url)
 	if err != nil {
 		return UserProfile{}, err
 	}
@@ -131,8 +165,8 @@ func fetchPlayerSummary(apiKey string, steamID string) (UserProfile, error) {
 		} `json:"response"`
 	}
 
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return UserProfile{}, fmt.Errorf("player summary json parse: %w", err)
+	if err := decodeUpstreamJSON(url, body, &resp, "player summary"); err != nil {
+		return UserProfile{}, err
 	}
 	if len(resp.Response.Players) == 0 {
 		return UserProfile{}, nil
@@ -146,13 +180,13 @@ func fetchPlayerSummary(apiKey string, steamID string) (UserProfile, error) {
 	}, nil
 }
 
-func fetchUserAchievementStats(apiKey string, steamID string, appID int) (map[string]userAchievementState, error) {
+func fetchUserAchievementStats(ctx context.Context, apiKey string, steamID SteamID, appID AppID) (map[string]userAchievementState, error) {
 	url := fmt.Sprintf("https://api.steampowered.com/ISteamUserStats/GetUserStatsForGame/v0002/?key=%s&steamid=%s&appid=%d&format=json", apiKey, steamID, appID)
 
-	body, status, err := httpGETWithStatus(url)
+	body, status, err := httpGETWithStatus(ctx, url)
 	if err != nil {
 		if status == http.StatusForbidden {
-			return nil, errProfilePrivate
+			return nil, apperr.Wrapf(apperr.ErrProfilePrivate, "user stats app %d -> 403", appID)
 		}
 		return nil, err
 	}
@@ -168,48 +202,172 @@ func fetchUserAchievementStats(apiKey string, steamID string, appID int) (map[st
 		} `json:"playerstats"`
 	}
 
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, fmt.Errorf("user stats json parse: %w", err)
+	if err := decodeUpstreamJSON(url, body, &resp, "user stats"); err != nil {
+		return nil, err
 	}
 
 	if resp.PlayerStats.Error != "" {
 		msg := strings.ToLower(resp.PlayerStats.Error)
 		if strings.Contains(msg, "private") || strings.Contains(msg, "forbidden") {
-			return nil, errProfilePrivate
+			return nil, apperr.Wrapf(apperr.ErrProfilePrivate, "user stats app %d", appID)
 		}
 		return nil, fmt.Errorf("user stats steam error: %s", resp.PlayerStats.Error)
 	}
 
 	out := make(map[string]userAchievementState, len(resp.PlayerStats.Achievements))
 	for _, a := range resp.PlayerStats.Achievements {
-		out[a.Name] = userAchievementState{Achieved: a.Achieved == 1, UnlockTime: a.UnlockTime}
+		out[a.Name] = userAchievementState{Achieved: a.Achieved == 1, UnlockTime: steamUnlockTime(appID, a.Name, a.UnlockTime)}
+	}
+
+	return out, nil
+}
+
+// fetchPlayerAchievements is the single-game call behind
+// /players/{steamid}/achievements. Steam answers success=false (or 403)
+// when the profile or its game details are private.
+func fetchPlayerAchievements(ctx context.Context, apiKey string, steamID SteamID, appID AppID) (map[string]userAchievementState, error) {
+	url := fmt.Sprintf("https://api.steampowered.com/ISteamUserStats/GetPlayerAchievements/v0001/?key=%s&steamid=%s&appid=%d&format=json", apiKey, steamID, appID)
+
+	body, status, err := httpGETWithStatus(ctx, url)
+	if err != nil {
+		if status == http.StatusForbidden {
+			return nil, apperr.Wrapf(apperr.ErrProfilePrivate, "player achievements app %d -> 403", appID)
+		}
+		return nil, err
+	}
+
+	var resp struct {
+		PlayerStats struct {
+			Success      bool   `json:"success"`
+			Error        string `json:"error"`
+			Achievements []struct {
+				APIName    string `json:"apiname"`
+				Achieved   int    `json:"achieved"`
+				UnlockTime int64  `json:"unlocktime"`
+			} `json:"achievements"`
+		} `json:"playerstats"`
+	}
+
+	if err := decodeUpstreamJSON(url, body, &resp, "player achievements"); err != nil {
+		return nil, err
+	}
+
+	if !resp.PlayerStats.Success {
+		msg := strings.ToLower(resp.PlayerStats.Error)
+		if strings.Contains(msg, "private") || strings.Contains(msg, "not public") {
+			return nil, apperr.Wrapf(apperr.ErrProfilePrivate, "player achievements app %d", appID)
+		}
+		return nil, fmt.Errorf("player achievements steam error: %s", resp.PlayerStats.Error)
 	}
 
+	out := make(map[string]userAchievementState, len(resp.PlayerStats.Achievements))
+	for _, a := range resp.PlayerStats.Achievements {
+		out[a.APIName] = userAchievementState{Achieved: a.Achieved == 1, UnlockTime: steamUnlockTime(appID, a.APIName, a.UnlockTime)}
+	}
 	return out, nil
 }
 
-func httpGET(url string) ([]byte, error) {
-	body, _, err := httpGETWithStatus(url)
+func httpGET(ctx context.Context, url string) ([]byte, error) {
+	body, _, err := httpGETWithStatus(ctx, url)
 	return body, err
 }
 
-func httpGETWithStatus(url string) ([]byte, int, error) {
-	client := &http.Client{Timeout: 12 * time.Second}
-	res, err := client.Get(url)
+func httpGETWithStatus(ctx context.Context, url string) ([]byte, int, error) {
+	return httpGETContext(ctx, url)
+}
+
+// httpGETContext retries transient failures as upstream_retry.go describes,
+// unless the breaker (upstream_breaker.go) is open.
+func httpGETContext(ctx context.Context, url string) (body []byte, status int, err error) {
+	probe, err := breaker.allow()
 	if err != nil {
 		return nil, 0, err
 	}
+	defer func() {
+		if ctx.Err() != nil {
+			breaker.done(probe, callAbandoned)
+		} else {
+			breaker.done(probe, outcomeOf(status, err))
+		}
+	}()
+	deadline := time.Now().Add(upstreamRetry.budget)
+	for attempt := 1; ; attempt++ {
+		body, status, retryAfter, err := httpGETOnce(ctx, url, deadline)
+		if err == nil {
+			return body, status, nil
+		}
+		if attempt >= upstreamRetry.attempts || !retryable(ctx, status, err) {
+			if attempt > 1 {
+				upstreamRetry.gaveUp.Add(1)
+			}
+			return nil, status, redactError(err)
+		}
+		wait := retryDelay(attempt)
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if time.Until(deadline) < wait {
+			upstreamRetry.gaveUp.Add(1)
+			return nil, status, redactError(err)
+		}
+		log.Printf("upstream [%s]: %v, attempt %d of %d in %s", upstreamFeatureOf(ctx), redactError(err), attempt+1, upstreamRetry.attempts, wait.Round(time.Millisecond))
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, status, redactError(err)
+		}
+		upstreamRetry.retried.Add(1)
+	}
+}
+
+// httpGETOnce is one attempt, cut at deadline. Its error is not redacted.
+// The attempt is counted against the feature of ctx before it is sent.
+func httpGETOnce(ctx context.Context, url string, deadline time.Time) ([]byte, int, time.Duration, error) {
+	feature := upstreamFeatureOf(ctx)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	release, err := upstreamRetry.acquire(ctx)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer release()
+	if err := upstreamUsage.take(feature, time.Now()); err != nil {
+		return nil, 0, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		upstreamUsage.failed(feature)
+		return nil, 0, 0, err
+	}
+	res, err := steamHTTPClient.Do(req)
+	if err != nil {
+		metrics.upstreamCall(true)
+		upstreamUsage.failed(feature)
+		return nil, 0, 0, err
+	}
 	defer res.Body.Close()
 
 	if res.StatusCode < 200 || res.StatusCode > 299 {
+		metrics.upstreamCall(true)
+		upstreamUsage.failed(feature)
 		b, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
-		safeURL := url
-		if u, parseErr := neturl.Parse(url); parseErr == nil {
-			safeURL = u.Scheme + "://" + u.Host + u.Path
-		}
-		return nil, res.StatusCode, fmt.Errorf("GET %s -> %d: %s", safeURL, res.StatusCode, strconv.Quote(string(b)))
+		return nil, res.StatusCode, parseRetryAfter(res.Header, time.Now()),
+			fmt.Errorf("GET %s -> %d: %s", safeUpstreamURL(url), res.StatusCode, strconv.Quote(string(b)))
 	}
 
 	b, err := io.ReadAll(res.Body)
-	return b, res.StatusCode, err
+	metrics.upstreamCall(err != nil)
+	if err != nil {
+		upstreamUsage.failed(feature)
+	}
+	return b, res.StatusCode, 0, err
+}
+
+func safeUpstreamURL(url string) string {
+	if u, err := neturl.Parse(url); err == nil {
+		return u.Scheme + "://" + u.Host + u.Path
+	}
+	return url
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// STEAM_API_HOSTS (comma-separated base URLs, e.g.
+// "https://api.steampowered.com,https://partner.steam-api.com") spreads Web
+// API calls over several hosts. Each call is sent to the healthiest host
+// and, when it fails (network error, 429 or 5xx), to the next ones in the
+// same call until the client timeout. Health is passive: the latency and
+// failures of real calls, both decaying with STEAM_API_HOST_DECAY (5m
+// half-life), so a host that was avoided looks better over time and gets
+// tried again. The store host is never rewritten.
+const (
+	defaultSteamAPIHost       = "api.steampowered.com"
+	defaultSteamHostDecay     = 5 * time.Minute
+	steamHostFailurePenaltyMs = 5000.0
+	steamHostLatencyWeight    = 0.3 // of the newest sample in the average
+)
+
+type steamHost struct {
+	base      *neturl.URL
+	latencyMs float64 // decaying average
+	failures  float64 // decaying count
+	updatedAt time.Time
+	requests  int64
+	failed    int64
+}
+
+type steamHostSelector struct {
+	base  http.RoundTripper
+	decay time.Duration
+	now   func() time.Time
+
+	mu    sync.Mutex
+	hosts []*steamHost
+}
+
+type steamHostStats struct {
+	Host      string  `json:"host"`
+	Score     float64 `json:"score"`
+	LatencyMs float64 `json:"latencyMs"`
+	Failures  float64 `json:"failures"`
+	Requests  int64   `json:"requests"`
+	Failed    int64   `json:"failed"`
+}
+
+var steamHosts *steamHostSelector
+
+func parseSteamAPIHosts(raw string) ([]*neturl.URL, error) {
+	var out []*neturl.URL
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		u, err := neturl.Parse(part)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("STEAM_API_HOSTS: %q is not an http(s) base URL", part)
+		}
+		out = append(out, &neturl.URL{Scheme: u.Scheme, Host: u.Host})
+	}
+	return out, nil
+}
+
+// steamHostSelectorFromEnv returns nil (and base unchanged) without
+// STEAM_API_HOSTS.
+func steamHostSelectorFromEnv(base http.RoundTripper) (*steamHostSelector, http.RoundTripper, error) {
+	bases, err := parseSteamAPIHosts(getenv("STEAM_API_HOSTS", ""))
+	if err != nil || len(bases) == 0 {
+		return nil, base, err
+	}
+	sel := newSteamHostSelector(base, bases, getenvDuration("STEAM_API_HOST_DECAY", defaultSteamHostDecay))
+	return sel, sel, nil
+}
+
+func newSteamHostSelector(base http.RoundTripper, bases []*neturl.URL, decay time.Duration) *steamHostSelector {
+	sel := &steamHostSelector{base: base, decay: decay, now: time.Now}
+	for _, b := range bases {
+		sel.hosts = append(sel.hosts, &steamHost{base: b})
+	}
+	return sel
+}
+
+// decayLocked brings h to now: both the latency and the failures fade
+// toward 0 with the half-life.
+func (sel *steamHostSelector) decayLocked(h *steamHost, now time.Time) {
+	if !h.updatedAt.IsZero() && sel.decay > 0 {
+		f := math.Pow(0.5, float64(now.Sub(h.updatedAt))/float64(sel.decay))
+		h.latencyMs *= f
+		h.failures *= f
+	}
+	h.updatedAt = now
+}
+
+func (h *steamHost) score() float64 {
+	return h.latencyMs + h.failures*steamHostFailurePenaltyMs
+}
+
+// ordered is the hosts from the healthiest; ties keep the configured order.
+func (sel *steamHostSelector) ordered() []*steamHost {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	now := sel.now()
+	for _, h := range sel.hosts {
+		sel.decayLocked(h, now)
+	}
+	out := append([]*steamHost(nil), sel.hosts...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].score() < out[j].score() })
+	return out
+}
+
+func (sel *steamHostSelector) observe(h *steamHost, latency time.Duration, failed bool) {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	sel.decayLocked(h, sel.now())
+	h.requests++
+	ms := float64(latency) / float64(time.Millisecond)
+	if h.latencyMs == 0 {
+		h.latencyMs = ms
+	} else {
+		h.latencyMs += steamHostLatencyWeight * (ms - h.latencyMs)
+	}
+	if failed {
+		h.failed++
+		h.failures++
+	}
+}
+
+func (sel *steamHostSelector) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != defaultSteamAPIHost || req.Method != http.MethodGet {
+		return sel.base.RoundTrip(req)
+	}
+
+	var res *http.Response
+	var err error
+	for i, h := range sel.ordered() {
+		if i > 0 {
+			if ctxErr := req.Context().Err(); ctxErr != nil {
+				break
+			}
+			if res != nil {
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+		}
+		attempt := req.Clone(req.Context())
+		u := *req.URL
+		u.Scheme, u.Host = h.base.Scheme, h.base.Host
+		attempt.URL, attempt.Host = &u, ""
+
+		start := time.Now()
+		res, err = sel.base.RoundTrip(attempt)
+		if errors.Is(err, context.Canceled) {
+			return res, err // the caller gave up, not the host
+		}
+		failed := isUpstreamFailure(res, err)
+		sel.observe(h, time.Since(start), failed)
+		if !failed {
+			return res, nil
+		}
+	}
+	return res, err
+}
+
+func (sel *steamHostSelector) snapshot() []steamHostStats {
+	if sel == nil {
+		return nil
+	}
+	out := make([]steamHostStats, 0, len(sel.hosts))
+	for _, h := range sel.ordered() {
+		sel.mu.Lock()
+		out = append(out, steamHostStats{
+			Host:      h.base.String(),
+			Score:     math.Round(h.score()*10) / 10,
+			LatencyMs: math.Round(h.latencyMs*10) / 10,
+			Failures:  math.Round(h.failures*100) / 100,
+			Requests:  h.requests,
+			Failed:    h.failed,
+		})
+		sel.mu.Unlock()
+	}
+	return out
+}
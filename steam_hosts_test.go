@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSteamHost answers "ok <name>" until failing is set, then 503.
+type fakeSteamHost struct {
+	url     *url.URL
+	failing atomic.Bool
+	hits    atomic.Int64
+}
+
+func newFakeSteamHost(t *testing.T, name string) *fakeSteamHost {
+	t.Helper()
+	h := &fakeSteamHost{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.hits.Add(1)
+		if h.failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok "+name)
+	}))
+	t.Cleanup(srv.Close)
+	h.url, _ = url.Parse(srv.URL)
+	return h
+}
+
+func steamHostCall(t *testing.T, sel *steamHostSelector) (int, string) {
+	t.Helper()
+	res, err := (&http.Client{Transport: sel}).Get("https://" + defaultSteamAPIHost + "/ISteamUserStats/GetSchemaForGame/v2/?appid=440")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(b)
+}
+
+func hostStats(sel *steamHostSelector, h *fakeSteamHost) steamHostStats {
+	for _, st := range sel.snapshot() {
+		if st.Host == h.url.String() {
+			return st
+		}
+	}
+	return steamHostStats{}
+}
+
+func TestSteamHostFailover(t *testing.T) {
+	a, b := newFakeSteamHost(t, "a"), newFakeSteamHost(t, "b")
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sel := newSteamHostSelector(http.DefaultTransport, []*url.URL{a.url, b.url}, time.Minute)
+	sel.now = clock.now
+	// Both hosts already answered once, a faster.
+	sel.observe(sel.hosts[0], 5*time.Millisecond, false)
+	sel.observe(sel.hosts[1], 50*time.Millisecond, false)
+
+	if code, body := steamHostCall(t, sel); code != http.StatusOK || body != "ok a" {
+		t.Fatalf("first call: %d %q, want the fastest host", code, body)
+	}
+
+	// a starts failing: the same call ends on b, the next ones start there.
+	a.failing.Store(true)
+	if code, body := steamHostCall(t, sel); code != http.StatusOK || body != "ok b" {
+		t.Fatalf("after a failed: %d %q", code, body)
+	}
+	hitsA := a.hits.Load()
+	for range 3 {
+		if _, body := steamHostCall(t, sel); body != "ok b" {
+			t.Fatalf("while a is down: %q", body)
+		}
+	}
+	if a.hits.Load() != hitsA {
+		t.Errorf("the failing host got %d more calls while b was healthy", a.hits.Load()-hitsA)
+	}
+	if st := hostStats(sel, a); st.Requests != 3 || st.Failed != 1 || st.Failures != 1 {
+		t.Errorf("a stats %+v", st)
+	}
+	if st := hostStats(sel, b); st.Requests != 5 || st.Failed != 0 {
+		t.Errorf("b stats %+v", st)
+	}
+	if got := sel.snapshot(); got[0].Host != b.url.String() {
+		t.Errorf("snapshot order %+v, want b first", got)
+	}
+
+	// The failure fades with the half-life: once a recovered and b fails,
+	// a answers again.
+	a.failing.Store(false)
+	clock.advance(20 * time.Minute)
+	if st := hostStats(sel, a); st.Failures > 0.001 {
+		t.Errorf("a failures after 20 half-lives: %v", st.Failures)
+	}
+	b.failing.Store(true)
+	if code, body := steamHostCall(t, sel); code != http.StatusOK || body != "ok a" {
+		t.Errorf("after b failed: %d %q", code, body)
+	}
+
+	// Every host down: the last answer goes back to the caller.
+	a.failing.Store(true)
+	if code, _ := steamHostCall(t, sel); code != http.StatusServiceUnavailable {
+		t.Errorf("every host down: %d", code)
+	}
+}
+
+func TestSteamHostPassThrough(t *testing.T) {
+	a := newFakeSteamHost(t, "a")
+	var hosts []string
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		hosts = append(hosts, r.URL.Host)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+	})
+	sel := newSteamHostSelector(base, []*url.URL{a.url}, time.Minute)
+	client := &http.Client{Transport: sel}
+	for _, req := range []func() (*http.Response, error){
+		func() (*http.Response, error) {
+			return client.Get("https://store.steampowered.com/api/appdetails?appids=440")
+		},
+		func() (*http.Response, error) {
+			return client.Post("https://"+defaultSteamAPIHost+"/ISteamUser/x/v1/", "text/plain", nil)
+		},
+		func() (*http.Response, error) {
+			return client.Get("https://" + defaultSteamAPIHost + "/ISteamUser/x/v1/")
+		},
+	} {
+		res, err := req()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	want := []string{"store.steampowered.com", defaultSteamAPIHost, a.url.Host}
+	if fmt.Sprint(hosts) != fmt.Sprint(want) {
+		t.Errorf("hosts %v, want %v", hosts, want)
+	}
+}
+
+func TestSteamHostStatsExposed(t *testing.T) {
+	a, b := newFakeSteamHost(t, "a"), newFakeSteamHost(t, "b")
+	a.failing.Store(true)
+	sel := newSteamHostSelector(http.DefaultTransport, []*url.URL{a.url, b.url}, time.Minute)
+	saved := steamHosts
+	steamHosts = sel
+	t.Cleanup(func() { steamHosts = saved })
+	steamHostCall(t, sel)
+	s := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		fmt.Sprintf(`yboost_steam_host_requests_total{host="%s"} 1`, a.url),
+		fmt.Sprintf(`yboost_steam_host_failures_total{host="%s"} 1`, a.url),
+		fmt.Sprintf(`yboost_steam_host_failures_total{host="%s"} 0`, b.url),
+		fmt.Sprintf(`yboost_steam_host_score{host="%s"}`, b.url),
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("/metrics without %s", line)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	s.handleAdminUpstream(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/upstream", nil))
+	var got struct {
+		Hosts []steamHostStats `json:"hosts"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || len(got.Hosts) != 2 || got.Hosts[0].Host != b.url.String() || got.Hosts[1].Failed != 1 {
+		t.Errorf("admin upstream hosts %+v, %v", got.Hosts, err)
+	}
+
+	// Without STEAM_API_HOSTS nothing is exposed.
+	steamHosts = nil
+	w = httptest.NewRecorder()
+	s.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(w.Body.String(), "yboost_steam_host_") {
+		t.Errorf("host metrics without STEAM_API_HOSTS")
+	}
+}
+
+func TestParseSteamAPIHosts(t *testing.T) {
+	got, err := parseSteamAPIHosts(" https://api.steampowered.com/ISteamUser , ,http://127.0.0.1:8080")
+	if err != nil || fmt.Sprint(got) != "[https://api.steampowered.com http://127.0.0.1:8080]" {
+		t.Errorf("parse = %v, %v", got, err)
+	}
+	for _, raw := range []string{"api.steampowered.com", "ftp://api.steampowered.com", "https://"} {
+		if _, err := parseSteamAPIHosts(raw); err == nil {
+			t.Errorf("%q accepted", raw)
+		}
+	}
+	if got, err := parseSteamAPIHosts(""); err != nil || got != nil {
+		t.Errorf("empty = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// "Sign in through Steam": /auth/steam/login sends the browser to Steam's
+// OpenID 2.0 provider, which comes back to /auth/steam/callback with the
+// player's SteamID in openid.claimed_id. The assertion is checked with
+// Steam itself (check_authentication) before a session cookie is set: the
+// SteamID and an expiry signed with SESSION_SECRET, valid SESSION_TTL (30
+// days). Nothing is stored server side, so logging out only drops the
+// cookie. Without SESSION_SECRET a random key is drawn at startup and
+// sessions end with the process.
+//
+// PUBLIC_BASE_URL (https://example.org) is the realm shown by Steam and the
+// base of the return URL. The login needs it: unset, the login and callback
+// answer 503 rather than take both from a Host header the client chose. The
+// cookie is Secure when that base is https.
+const (
+	defaultSessionTTL   = 30 * 24 * time.Hour
+	defaultOpenIDURL    = "https://steamcommunity.com/openid/login"
+	openIDNamespace     = "http://specs.openid.net/auth/2.0"
+	openIDIdentifierAny = "http://specs.openid.net/auth/2.0/identifier_select"
+	steamOpenIDIDPrefix = "https://steamcommunity.com/openid/id/"
+	sessionCookieName   = "yboost_session"
+	loginStateCookie    = "yboost_login"
+	loginStateTTL       = 10 * time.Minute
+	callbackPath        = "/auth/steam/callback"
+	openIDMaxAnswer     = 4 << 10
+)
+
+// openIDSignedFields must all be covered by the provider's signature, or
+// check_authentication would vouch for an assertion whose claimed_id or
+// return_to the browser changed.
+var openIDSignedFields = []string{"op_endpoint", "claimed_id", "identity", "return_to", "response_nonce", "assoc_handle"}
+
+type steamLogin struct {
+	key       []byte
+	ttl       time.Duration
+	openIDURL string
+	baseURL   string // no trailing slash; "" disables the login
+	client    *http.Client
+}
+
+func steamLoginFromEnv() (*steamLogin, error) {
+	l := &steamLogin{
+		ttl:       getenvDuration("SESSION_TTL", defaultSessionTTL),
+		openIDURL: getenv("STEAM_OPENID_URL", defaultOpenIDURL),
+		baseURL:   strings.TrimRight(cleanEnvValue(os.Getenv("PUBLIC_BASE_URL")), "/"),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if l.baseURL == "" {
+		log.Printf("PUBLIC_BASE_URL not set: Steam login disabled")
+	} else {
+		if u, err := url.Parse(l.baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("PUBLIC_BASE_URL must be an absolute http(s) URL, got %q", l.baseURL)
+		}
+	}
+	if secret := cleanEnvValue(os.Getenv("SESSION_SECRET")); secret != "" {
+		l.key = []byte(secret)
+	} else {
+		l.key = make([]byte, 32)
+		if _, err := rand.Read(l.key); err != nil {
+			return nil, err
+		}
+		log.Printf("SESSION_SECRET not set: Steam logins last until the next restart")
+	}
+	return l, nil
+}
+
+// enabled answers 503 on w when the login has no PUBLIC_BASE_URL.
+func (l *steamLogin) enabled(w http.ResponseWriter) bool {
+	if l.baseURL != "" {
+		return true
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeError(w, http.StatusServiceUnavailable, "login_unavailable", "La connexion Steam n'est pas configuree sur ce serveur")
+	return false
+}
+
+func (l *steamLogin) secure(r *http.Request) bool {
+	if l.baseURL != "" {
+		return strings.HasPrefix(l.baseURL, "https://")
+	}
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func (l *steamLogin) sign(payload string) string {
+	m := hmac.New(sha256.New, l.key)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// sessionValue is "<steamid>.<expires unix>.<signature>".
+func (l *steamLogin) sessionValue(id SteamID, expires time.Time) string {
+	payload := id.String() + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + l.sign(payload)
+}
+
+// session is the SteamID of the logged-in player of r, if any.
+func (l *steamLogin) session(r *http.Request, now time.Time) (SteamID, bool) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return 0, false
+	}
+	payload, sig, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return 0, false
+	}
+	exp, sig, ok := strings.Cut(sig, ".")
+	if !ok {
+		return 0, false
+	}
+	payload += "." + exp
+	if !hmac.Equal([]byte(sig), []byte(l.sign(payload))) {
+		return 0, false
+	}
+	until, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() >= until {
+		return 0, false
+	}
+	id, err := parseSteamID64(payload[:strings.IndexByte(payload, '.')])
+	return id, err == nil
+}
+
+// localRedirect keeps ?next= on this site: a path, not //host or a URL.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.ContainsAny(next, "\\\r\n") {
+		return "/"
+	}
+	return next
+}
+
+func (s *Server) handleSteamLogin(w http.ResponseWriter, r *http.Request) {
+	l := s.login
+	if !l.enabled(w) {
+		return
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		writeError(w, http.StatusInternalServerError, "login_failed", err.Error())
+		return
+	}
+	state := hex.EncodeToString(nonce)
+	http.SetCookie(w, &http.Cookie{Name: loginStateCookie, Value: state, Path: callbackPath, MaxAge: int(loginStateTTL / time.Second),
+		HttpOnly: true, Secure: l.secure(r), SameSite: http.SameSiteLaxMode})
+
+	returnTo := l.baseURL + callbackPath + "?" + url.Values{"state": {state}, "next": {localRedirect(r.URL.Query().Get("next"))}}.Encode()
+	q := url.Values{
+		"openid.ns":         {openIDNamespace},
+		"openid.mode":       {"checkid_setup"},
+		"openid.return_to":  {returnTo},
+		"openid.realm":      {l.baseURL + "/"},
+		"openid.identity":   {openIDIdentifierAny},
+		"openid.claimed_id": {openIDIdentifierAny},
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, l.openIDURL+"?"+q.Encode(), http.StatusFound)
+}
+
+func (s *Server) handleSteamCallback(w http.ResponseWriter, r *http.Request) {
+	l := s.login
+	if !l.enabled(w) {
+		return
+	}
+	q := r.URL.Query()
+	w.Header().Set("Cache-Control", "no-store")
+	c, err := r.Cookie(loginStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(q.Get("state"))) != 1 {
+		writeError(w, http.StatusBadRequest, "login_failed", "Connexion expiree ou lancee depuis un autre onglet, recommence")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginStateCookie, Path: callbackPath, MaxAge: -1})
+	if q.Get("openid.mode") == "cancel" {
+		http.Redirect(w, r, localRedirect(q.Get("next")), http.StatusSeeOther)
+		return
+	}
+
+	id, err := l.verify(r.Context(), q, l.baseURL+callbackPath)
+	if err != nil {
+		log.Printf("steam login: %v", err)
+		writeError(w, http.StatusBadGateway, "login_failed", "Steam n'a pas confirme la connexion, recommence")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: l.sessionValue(id, time.Now().Add(l.ttl)), Path: "/",
+		MaxAge: int(l.ttl / time.Second), HttpOnly: true, Secure: l.secure(r), SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, localRedirect(q.Get("next")), http.StatusSeeOther)
+}
+
+// verify checks a positive assertion and asks Steam to confirm it, as
+// OpenID 2.0 direct verification goes, returning the player's SteamID.
+func (l *steamLogin) verify(ctx context.Context, q url.Values, returnURL string) (SteamID, error) {
+	if q.Get("openid.mode") != "id_res" {
+		return 0, fmt.Errorf("openid.mode %q", q.Get("openid.mode"))
+	}
+	signed := map[string]bool{}
+	for _, f := range strings.Split(q.Get("openid.signed"), ",") {
+		signed[f] = true
+	}
+	for _, f := range openIDSignedFields {
+		if !signed[f] {
+			return 0, fmt.Errorf("openid.signed %q lacks %s", q.Get("openid.signed"), f)
+		}
+	}
+	if q.Get("openid.op_endpoint") != l.openIDURL {
+		return 0, fmt.Errorf("op_endpoint %q", q.Get("openid.op_endpoint"))
+	}
+	// The signed return_to must be the URL Steam sent the browser back to.
+	if rt, query, _ := strings.Cut(q.Get("openid.return_to"), "?"); rt != returnURL || !strings.Contains("&"+query+"&", "&state="+q.Get("state")+"&") {
+		return 0, fmt.Errorf("return_to %q", q.Get("openid.return_to"))
+	}
+	claimed := q.Get("openid.claimed_id")
+	if !strings.HasPrefix(claimed, steamOpenIDIDPrefix) || q.Get("openid.identity") != claimed {
+		return 0, fmt.Errorf("claimed_id %q", claimed)
+	}
+	id, err := parseSteamID64(strings.TrimPrefix(claimed, steamOpenIDIDPrefix))
+	if err != nil {
+		return 0, fmt.Errorf("claimed_id %q: %w", claimed, err)
+	}
+
+	form := url.Values{}
+	for k, v := range q {
+		if strings.HasPrefix(k, "openid.") {
+			form[k] = v
+		}
+	}
+	form.Set("openid.mode", "check_authentication")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.openIDURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("check_authentication: HTTP %d", resp.StatusCode)
+	}
+	sc := bufio.NewScanner(io.LimitReader(resp.Body, openIDMaxAnswer))
+	for sc.Scan() {
+		if strings.TrimSpace(sc.Text()) == "is_valid:true" {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("check_authentication: assertion for %s not valid", id)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Path: "/", MaxAge: -1, HttpOnly: true, Secure: s.login.secure(r), SameSite: http.SameSiteLaxMode})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMyAchievements is /players/{steamid}/achievements for the player of
+// the session cookie. The answer is private to them.
+func (s *Server) handleMyAchievements(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	addVary(w.Header(), "Cookie")
+	id, ok := s.login.session(r, time.Now())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "not_logged_in", "Connecte-toi avec Steam pour voir ta progression")
+		return
+	}
+	s.servePlayerAchievements(w, r, id)
+}
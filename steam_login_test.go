@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testReturnURL = "https://example.org" + callbackPath
+
+// fakeOpenIDProvider answers check_authentication with is_valid:true for
+// anything, as a compromised or careless provider would: the checks under
+// test must hold on our side.
+func fakeOpenIDProvider(t *testing.T) (*steamLogin, *int) {
+	t.Helper()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(b))
+		if form.Get("openid.mode") != "check_authentication" {
+			t.Errorf("provider got openid.mode %q", form.Get("openid.mode"))
+		}
+		io.WriteString(w, "ns:"+openIDNamespace+"\nis_valid:true\n")
+	}))
+	t.Cleanup(srv.Close)
+	return &steamLogin{key: []byte("k"), openIDURL: srv.URL, client: srv.Client()}, &calls
+}
+
+func validAssertion(l *steamLogin) url.Values {
+	claimed := steamOpenIDIDPrefix + "76561197960287930"
+	return url.Values{
+		"state":                 {"abc"},
+		"openid.ns":             {openIDNamespace},
+		"openid.mode":           {"id_res"},
+		"openid.op_endpoint":    {l.openIDURL},
+		"openid.claimed_id":     {claimed},
+		"openid.identity":       {claimed},
+		"openid.return_to":      {testReturnURL + "?next=%2F&state=abc"},
+		"openid.response_nonce": {"2026-10-14T12:00:00Zx"},
+		"openid.assoc_handle":   {"1234567890"},
+		"openid.signed":         {"signed,op_endpoint,claimed_id,identity,return_to,response_nonce,assoc_handle"},
+		"openid.sig":            {"c2ln"},
+	}
+}
+
+func TestSteamLoginVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(q url.Values)
+		ok     bool
+		asks   bool // whether Steam is asked to confirm
+	}{
+		{"valid", func(q url.Values) {}, true, true},
+		{"forged claimed_id, other prefix", func(q url.Values) {
+			q.Set("openid.claimed_id", "https://evil.example/openid/id/76561197960287930")
+			q.Set("openid.identity", q.Get("openid.claimed_id"))
+		}, false, false},
+		{"forged claimed_id, differs from identity", func(q url.Values) {
+			q.Set("openid.claimed_id", steamOpenIDIDPrefix+"76561197960287931")
+		}, false, false},
+		{"forged claimed_id, not a SteamID", func(q url.Values) {
+			q.Set("openid.claimed_id", steamOpenIDIDPrefix+"12")
+			q.Set("openid.identity", q.Get("openid.claimed_id"))
+		}, false, false},
+		{"tampered return_to, other host", func(q url.Values) {
+			q.Set("openid.return_to", "https://evil.example"+callbackPath+"?next=%2F&state=abc")
+		}, false, false},
+		{"tampered return_to, other state", func(q url.Values) {
+			q.Set("openid.return_to", testReturnURL+"?next=%2F&state=abd")
+		}, false, false},
+		{"tampered op_endpoint", func(q url.Values) {
+			q.Set("openid.op_endpoint", "https://evil.example/openid/login")
+		}, false, false},
+		{"narrowed signed list, no claimed_id", func(q url.Values) {
+			q.Set("openid.signed", "signed,op_endpoint,identity,return_to,response_nonce,assoc_handle")
+		}, false, false},
+		{"narrowed signed list, no return_to", func(q url.Values) {
+			q.Set("openid.signed", "signed,op_endpoint,claimed_id,identity,response_nonce,assoc_handle")
+		}, false, false},
+		{"narrowed signed list, only the nonce", func(q url.Values) {
+			q.Set("openid.signed", "response_nonce")
+		}, false, false},
+		{"no signed list", func(q url.Values) { q.Del("openid.signed") }, false, false},
+		{"not a positive assertion", func(q url.Values) { q.Set("openid.mode", "setup_needed") }, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, calls := fakeOpenIDProvider(t)
+			q := validAssertion(l)
+			tt.change(q)
+			id, err := l.verify(context.Background(), q, testReturnURL)
+			if (err == nil) != tt.ok {
+				t.Fatalf("verify() err = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && id != 76561197960287930 {
+				t.Errorf("verify() = %d", id)
+			}
+			if asked := *calls > 0; asked != tt.asks {
+				t.Errorf("check_authentication called = %v, want %v", asked, tt.asks)
+			}
+		})
+	}
+}
+
+func TestSteamLoginVerifyRejectedByProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ns:"+openIDNamespace+"\nis_valid:false\n")
+	}))
+	defer srv.Close()
+	l := &steamLogin{key: []byte("k"), openIDURL: srv.URL, client: srv.Client()}
+	if _, err := l.verify(context.Background(), validAssertion(l), testReturnURL); err == nil || !strings.Contains(err.Error(), "not valid") {
+		t.Fatalf("verify() err = %v, want not valid", err)
+	}
+}
+
+// The realm and return URL never come from the Host the client sent.
+func TestSteamLoginForgedHost(t *testing.T) {
+	s := newTestServer(t)
+	for _, path := range []string{"/auth/steam/login?next=%2Fjeu", callbackPath + "?state=abc&openid.mode=id_res"} {
+		s.login = &steamLogin{key: []byte("k"), openIDURL: defaultOpenIDURL}
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Host = "evil.example"
+		r.AddCookie(&http.Cookie{Name: loginStateCookie, Value: "abc"})
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(path, callbackPath) {
+			s.handleSteamCallback(w, r)
+		} else {
+			s.handleSteamLogin(w, r)
+		}
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "login_unavailable") || w.Header().Get("Location") != "" || len(w.Result().Cookies()) != 0 {
+			t.Errorf("%s without PUBLIC_BASE_URL: %d %v %s", path, w.Code, w.Header(), w.Body)
+		}
+	}
+
+	s.login = &steamLogin{key: []byte("k"), openIDURL: defaultOpenIDURL, baseURL: "https://example.org"}
+	r := httptest.NewRequest(http.MethodGet, "/auth/steam/login?next=%2Fjeu", nil)
+	r.Host = "evil.example"
+	w := httptest.NewRecorder()
+	s.handleSteamLogin(w, r)
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil {
+		t.Fatalf("login: %d %v", w.Code, w.Header())
+	}
+	q := loc.Query()
+	if q.Get("openid.realm") != "https://example.org/" || !strings.HasPrefix(q.Get("openid.return_to"), testReturnURL+"?") {
+		t.Errorf("realm %q, return_to %q", q.Get("openid.realm"), q.Get("openid.return_to"))
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].Name != loginStateCookie || !c[0].Secure {
+		t.Errorf("state cookie %v", c)
+	}
+}
+
+func TestSteamLoginFromEnv(t *testing.T) {
+	t.Setenv("PUBLIC_BASE_URL", "")
+	if l, err := steamLoginFromEnv(); err != nil || l.baseURL != "" {
+		t.Errorf("unset: %+v, %v", l, err)
+	}
+	t.Setenv("PUBLIC_BASE_URL", "https://example.org/")
+	if l, err := steamLoginFromEnv(); err != nil || l.baseURL != "https://example.org" {
+		t.Errorf("set: %+v, %v", l, err)
+	}
+	for _, bad := range []string{"example.org", "ftp://example.org", "https://"} {
+		t.Setenv("PUBLIC_BASE_URL", bad)
+		if _, err := steamLoginFromEnv(); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
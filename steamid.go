@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// SteamID is a SteamID64. It travels as a decimal string in JSON and in the
+// database, exactly like the plain strings it replaces; a JSON number is
+// read too, for clients that do not quote it.
+type SteamID uint64
+
+func (id SteamID) String() string { return strconv.FormatUint(uint64(id), 10) }
+
+func (id SteamID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+func (id *SteamID) UnmarshalText(b []byte) error {
+	v, err := parseSteamID64(string(b))
+	if err != nil {
+		return err
+	}
+	*id = v
+	return nil
+}
+
+func (id *SteamID) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	if strings.HasPrefix(string(b), `"`) {
+		var raw string
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return err
+		}
+		b = []byte(raw)
+	}
+	return id.UnmarshalText(b)
+}
+
+func (id SteamID) Value() (driver.Value, error) { return id.String(), nil }
+
+func (id *SteamID) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return id.UnmarshalText([]byte(v))
+	case []byte:
+		return id.UnmarshalText(v)
+	case int64:
+		*id = SteamID(v)
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into SteamID", src)
+}
+
+// parseSteamID64 accepts only the canonical 17-digit form.
+func parseSteamID64(v string) (SteamID, error) {
+	if len(v) != 17 {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	return SteamID(n), nil
+}
+
+// steamID64Base is the SteamID64 of individual account 0 in the public universe.
+const steamID64Base uint64 = 76561197960265728
+
+const acceptedSteamIDForms = "SteamID64 (17 chiffres), STEAM_0:Y:Z, [U:1:Z]"
+
+// parseSteamID converts SteamID64, legacy STEAM_X:Y:Z and SteamID3 [U:1:Z]
+// inputs to a canonical SteamID64. ok is false when v is not shaped like a
+// SteamID at all (e.g. a profile name); err is set when it looks like one
+// but is malformed.
+func parseSteamID(v string) (id SteamID, ok bool, err error) {
+	v = strings.TrimSpace(v)
+	if id, err := parseSteamID64(v); err == nil {
+		return id, true, nil
+	}
+	switch {
+	case strings.HasPrefix(strings.ToUpper(v), "STEAM_"):
+		account, err := parseLegacySteamID(v[len("STEAM_"):])
+		if err != nil {
+			return 0, true, err
+		}
+		return steamID64FromAccountID(account), true, nil
+	case strings.HasPrefix(v, "[") || strings.HasPrefix(strings.ToUpper(v), "U:1:"):
+		account, err := parseSteamID3(v)
+		if err != nil {
+			return 0, true, err
+		}
+		return steamID64FromAccountID(account), true, nil
+	}
+	return 0, false, nil
+}
+
+// parseLegacySteamID parses "X:Y:Z" where account id = Z*2 + Y.
+func parseLegacySteamID(v string) (uint32, error) {
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	universe, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil || universe > 1 {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	y, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil || y > 1 {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	z, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil || z > (1<<31)-1 {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	return uint32(z*2 + y), nil
+}
+
+// parseSteamID3 parses "[U:1:Z]" (brackets optional) where Z is the account id.
+func parseSteamID3(v string) (uint32, error) {
+	if strings.HasPrefix(v, "[") != strings.HasSuffix(v, "]") {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 || !strings.EqualFold(parts[0], "U") || parts[1] != "1" {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	z, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return 0, apperr.ErrMalformedSteamID
+	}
+	return uint32(z), nil
+}
+
+func steamID64FromAccountID(account uint32) SteamID {
+	return SteamID(steamID64Base + uint64(account))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+func TestParseSteamID(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SteamID
+		ok      bool // shaped like a SteamID
+		wantErr bool
+	}{
+		// SteamID64 and its 17-digit bounds.
+		{"76561197960287930", 76561197960287930, true, false},
+		{" 76561197960287930 ", 76561197960287930, true, false},
+		{"10000000000000000", 10000000000000000, true, false},
+		{"99999999999999999", 99999999999999999, true, false},
+		{"7656119796028793", 0, false, false},
+		{"765611979602879300", 0, false, false},
+		{"7656119796028793x", 0, false, false},
+
+		// Legacy STEAM_X:Y:Z, account id = Z*2 + Y.
+		{"STEAM_0:0:0", 76561197960265728, true, false},
+		{"STEAM_0:1:0", 76561197960265729, true, false},
+		{"STEAM_0:0:11101", 76561197960287930, true, false},
+		{"STEAM_1:0:11101", 76561197960287930, true, false},
+		{"steam_0:1:11101", 76561197960287931, true, false},
+		{"STEAM_0:1:2147483647", 76561197960265728 + 4294967295, true, false},
+		{"STEAM_0:0:2147483648", 0, true, true},
+		{"STEAM_0:2:11101", 0, true, true},
+		{"STEAM_2:0:11101", 0, true, true},
+		{"STEAM_0:0:-1", 0, true, true},
+		{"STEAM_0:0", 0, true, true},
+		{"STEAM_0:0:1:2", 0, true, true},
+		{"STEAM_", 0, true, true},
+
+		// SteamID3 [U:1:Z].
+		{"[U:1:0]", 76561197960265728, true, false},
+		{"[U:1:22202]", 76561197960287930, true, false},
+		{"[u:1:22203]", 76561197960287931, true, false},
+		{"U:1:22202", 76561197960287930, true, false},
+		{"[U:1:4294967295]", 76561197960265728 + 4294967295, true, false},
+		{"[U:1:4294967296]", 0, true, true},
+		{"[U:1:22202", 0, true, true},
+		{"U:1:22202]", 0, true, true},
+		{"[U:0:22202]", 0, true, true},
+		{"[G:1:22202]", 0, true, true},
+		{"[U:1:]", 0, true, true},
+		{"[]", 0, true, true},
+
+		// Not a SteamID at all: a profile name.
+		{"gabelogannewell", 0, false, false},
+		{"", 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			id, ok, err := parseSteamID(tt.in)
+			if ok != tt.ok || (err != nil) != tt.wantErr {
+				t.Fatalf("parseSteamID(%q) ok = %v, err = %v; want ok %v, err %v", tt.in, ok, err, tt.ok, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, apperr.ErrMalformedSteamID) {
+				t.Errorf("parseSteamID(%q) err = %v, want ErrMalformedSteamID", tt.in, err)
+			}
+			if id != tt.want {
+				t.Errorf("parseSteamID(%q) = %d, want %d", tt.in, id, tt.want)
+			}
+		})
+	}
+}
+
+// The three spellings of one account give one SteamID64, for both parities
+// of Y.
+func TestParseSteamIDFormsAgree(t *testing.T) {
+	for _, account := range []uint32{0, 1, 22202, 22203, 1<<31 - 1, 1 << 31, 1<<32 - 1} {
+		want := steamID64FromAccountID(account)
+		for _, in := range []string{
+			want.String(),
+			"STEAM_0:" + itoa(uint64(account%2)) + ":" + itoa(uint64(account/2)),
+			"[U:1:" + itoa(uint64(account)) + "]",
+		} {
+			if id, ok, err := parseSteamID(in); !ok || err != nil || id != want {
+				t.Errorf("parseSteamID(%q) = %d, %v, %v; want %d", in, id, ok, err, want)
+			}
+		}
+	}
+}
+
+func TestSteamIDText(t *testing.T) {
+	var id SteamID
+	if err := id.UnmarshalText([]byte("76561197960287930")); err != nil || id.String() != "76561197960287930" {
+		t.Fatalf("UnmarshalText = %d, %v", id, err)
+	}
+	if err := id.UnmarshalText([]byte("STEAM_0:0:11101")); err == nil {
+		t.Errorf("UnmarshalText accepted a legacy SteamID, stored values are canonical")
+	}
+	if err := id.Scan(int64(76561197960287931)); err != nil || id != 76561197960287931 {
+		t.Errorf("Scan(int64) = %d, %v", id, err)
+	}
+	if err := id.Scan(1.5); err == nil {
+		t.Errorf("Scan(float64) accepted")
+	}
+}
+
+func itoa(n uint64) string { return SteamID(n).String() }
+
+func TestWriteIdentifierErrorListsForms(t *testing.T) {
+	_, _, err := parseSteamID("[U:1:22202")
+	rec := httptest.NewRecorder()
+	writeIdentifierError(rec, err)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	for _, form := range []string{"SteamID64", "STEAM_0:Y:Z", "[U:1:Z]"} {
+		if !strings.Contains(rec.Body.String(), form) {
+			t.Errorf("body %s does not list %s", rec.Body, form)
+		}
+	}
+}
@@ -1,183 +1,387 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"yboost-projet-25-26/internal/apperr"
 )
 
-func (s *Server) syncUserData(steamID string, lang string) error {
-	summary, profileErr := fetchPlayerSummary(s.apiKey, steamID)
+func (s *Server) syncUserData(ctx context.Context, steamID SteamID, lang string) error {
+	if s.readOnly() {
+		return apperr.ErrReadOnly
+	}
+	summary, profileErr := fetchPlayerSummary(ctx, s.apiKey, steamID)
 	if profileErr != nil {
 		log.Printf("profile summary warning (steamID=%s): %v", steamID, profileErr)
 		summary = UserProfile{}
 	}
 
-	games, err := fetchOwnedGames(s.apiKey, steamID)
+	games, err := fetchOwnedGames(ctx, s.apiKey, steamID)
 	if err != nil {
-		if errors.Is(err, errProfilePrivate) {
+		if errors.Is(err, apperr.ErrProfilePrivate) {
 			return err
 		}
 		return fmt.Errorf("owned games fetch: %w", err)
 	}
 
-	tx, err := s.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.Exec(`DELETE FROM user_achievements WHERE steam_id=?`, steamID); err != nil {
-		return err
-	}
-	if _, err := tx.Exec(`DELETE FROM user_games WHERE steam_id=?`, steamID); err != nil {
-		return err
-	}
-
-	gameStmt, err := tx.Prepare(`
-		INSERT INTO user_games(steam_id, app_id, name, playtime_forever, total_achievements, unlocked_achievements, completion_pct, updated_at)
-		VALUES(?,?,?,?,?,?,?,?)
-	`)
-	if err != nil {
-		return err
-	}
-	defer gameStmt.Close()
-
-	achStmt, err := tx.Prepare(`
-		INSERT INTO user_achievements(steam_id, app_id, api_name, name, description, icon, icon_gray, hidden, achieved, unlock_time, global_pct, updated_at)
-		VALUES(?,?,?,?,?,?,?,?,?,?,?,?)
-	`)
-	if err != nil {
-		return err
+	now := time.Now().Unix()
+	synced := &isolatedUser{syncedAt: time.Unix(now, 0).UTC(), profile: summary,
+		games: make([]GameCompletion, 0, len(games)), achievements: make(map[AppID][]Achievement)}
+	emptyGame := func(game OwnedGame, status string) GameCompletion {
+		return GameCompletion{AppID: game.AppID, Name: game.Name, PlaytimeForever: game.PlaytimeForever, Status: status}
 	}
-	defer achStmt.Close()
 
-	now := time.Now().Unix()
 	for _, game := range games {
-		schema, err := s.fetchSchemaForGameCached(game.AppID, lang)
+		schema, err := s.fetchSchemaForGameCached(ctx, game.AppID, lang)
 		if err != nil {
 			log.Printf("skip schema app %d (%s): %v", game.AppID, game.Name, err)
+			synced.games = append(synced.games, emptyGame(game, gameStatusError))
 			continue
 		}
 		if len(schema) == 0 {
+			synced.games = append(synced.games, emptyGame(game, s.emptySchemaStatus(ctx, game.AppID)))
 			continue
 		}
 
-		pcts, err := s.fetchGlobalPercentagesCached(game.AppID)
+		pcts, err := s.fetchGlobalPercentagesCached(ctx, game.AppID)
 		if err != nil {
 			log.Printf("skip global pct app %d (%s): %v", game.AppID, game.Name, err)
 			pcts = map[string]float64{}
 		}
 
-		userStats, err := fetchUserAchievementStats(s.apiKey, steamID, game.AppID)
+		userStats, err := fetchUserAchievementStats(ctx, s.apiKey, steamID, game.AppID)
 		if err != nil {
-			if errors.Is(err, errProfilePrivate) {
+			if errors.Is(err, apperr.ErrProfilePrivate) {
 				return err
 			}
 			log.Printf("skip user stats app %d (%s): %v", game.AppID, game.Name, err)
+			synced.games = append(synced.games, emptyGame(game, gameStatusError))
 			continue
 		}
 
 		unlockedCount := 0
+		items := make([]Achievement, 0, len(schema))
 		for _, a := range schema {
 			st, ok := userStats[a.APIName]
 			if st.Achieved {
 				unlockedCount++
 			}
-
-			hidden := 0
-			if a.Hidden {
-				hidden = 1
-			}
-			achieved := 0
-			if ok && st.Achieved {
-				achieved = 1
-			}
-
-			if _, err := achStmt.Exec(
-				steamID,
-				game.AppID,
-				a.APIName,
-				a.Name,
-				a.Description,
-				a.Icon,
-				a.IconGray,
-				hidden,
-				achieved,
-				st.UnlockTime,
-				pcts[a.APIName],
-				now,
-			); err != nil {
-				return err
-			}
+			a.Achieved = ok && st.Achieved
+			a.UnlockTime = st.UnlockTime
+			a.GlobalPct = pcts[a.APIName]
+			items = append(items, a)
 		}
+		synced.achievements[game.AppID] = items
 
 		completion := 0.0
 		if len(schema) > 0 {
 			completion = float64(unlockedCount) * 100.0 / float64(len(schema))
 		}
+		synced.games = append(synced.games, GameCompletion{AppID: game.AppID, Name: game.Name, PlaytimeForever: game.PlaytimeForever,
+			TotalAchievements: len(schema), UnlockedAchievements: unlockedCount, CompletionPct: completion, Status: gameStatusOK})
+	}
 
-		if _, err := gameStmt.Exec(
-			steamID,
-			game.AppID,
-			game.Name,
-			game.PlaytimeForever,
-			len(schema),
-			unlockedCount,
-			completion,
-			now,
-		); err != nil {
+	var unlocks []UnlockEvent
+	if ns := cacheNamespace(ctx); ns != "" {
+		prev, _ := s.isolated.user(ns, steamID)
+		unlocks = newUnlocks(prev, synced)
+		sortUserData(synced)
+		s.isolated.setUser(ns, steamID, synced)
+	} else {
+		if unlocks, err = s.saveUserSync(ctx, steamID, synced, now); err != nil {
 			return err
 		}
+		s.bumpGeneration(generationPlayers)
+		s.localStats.invalidate()
+		s.scheduleCacheSnapshot()
+		s.cdn.purge(playerSurrogateKey(steamID))
+	}
+	if len(unlocks) > 0 {
+		pub := pseudonyms.steamID(steamID)
+		s.events.publish(eventUnlock, pub.String(), map[string]any{"steamId": pub, "unlocks": unlocks})
+		s.emitRareUnlocks(pub, unlocks)
 	}
+	return nil
+}
 
-	if _, err := tx.Exec(`
-		INSERT INTO user_meta(steam_id,key,value) VALUES(?,?,?)
-		ON CONFLICT(steam_id,key) DO UPDATE SET value=excluded.value
-	`, steamID, "last_sync", strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+// saveUserSync replaces the stored data of steamID with synced, made at
+// now, and returns the achievements unlocked since the previous sync. It
+// goes through the write queue and returns once the rows are committed; a
+// canceled ctx does not abandon a sync already made.
+func (s *Server) saveUserSync(ctx context.Context, steamID SteamID, synced *isolatedUser, now int64) ([]UnlockEvent, error) {
+	var unlocks []UnlockEvent
+	err := s.writes.execTx(context.WithoutCancel(ctx), func(tx *sql.Tx) error {
+		var err error
+		unlocks, err = saveUserSyncTx(tx, steamID, synced, now)
 		return err
+	})
+	return unlocks, err
+}
+
+func saveUserSyncTx(tx *sql.Tx, steamID SteamID, synced *isolatedUser, now int64) ([]UnlockEvent, error) {
+	prevUnlocked, err := readUnlockedSet(tx, steamID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_achievements WHERE steam_id=?`, steamID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_games WHERE steam_id=?`, steamID); err != nil {
+		return nil, err
 	}
 
-	if strings.TrimSpace(summary.DisplayName) != "" {
-		if _, err := tx.Exec(`
-			INSERT INTO user_meta(steam_id,key,value) VALUES(?,?,?)
-			ON CONFLICT(steam_id,key) DO UPDATE SET value=excluded.value
-		`, steamID, "profile_name", summary.DisplayName); err != nil {
-			return err
+	gameStmt, err := tx.Prepare(`
+		INSERT INTO user_games(steam_id, app_id, name, playtime_forever, total_achievements, unlocked_achievements, completion_pct, status, updated_at)
+		VALUES(?,?,?,?,?,?,?,?,?)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer gameStmt.Close()
+
+	achStmt, err := tx.Prepare(`
+		INSERT INTO user_achievements(steam_id, app_id, api_name, name, description, icon, icon_gray, hidden, achieved, unlock_time, global_pct, updated_at)
+		VALUES(?,?,?,?,?,?,?,?,?,?,?,?)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer achStmt.Close()
+
+	unlocks := make([]UnlockEvent, 0)
+	for _, game := range synced.games {
+		for _, a := range synced.achievements[game.AppID] {
+			hidden, achieved := 0, 0
+			if a.Hidden {
+				hidden = 1
+			}
+			if a.Achieved {
+				achieved = 1
+				// No previous rows means first sync: nothing is "new".
+				if len(prevUnlocked) > 0 && !prevUnlocked[unlockKey(game.AppID, a.APIName)] {
+					unlocks = append(unlocks, UnlockEvent{AppID: game.AppID, APIName: a.APIName, Name: a.Name, GlobalPct: a.GlobalPct})
+				}
+			}
+			if _, err := achStmt.Exec(steamID, game.AppID, a.APIName, a.Name, a.Description, a.Icon, a.IconGray,
+				hidden, achieved, a.UnlockTime, a.GlobalPct, now); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := gameStmt.Exec(steamID, game.AppID, game.Name, game.PlaytimeForever, game.TotalAchievements,
+			game.UnlockedAchievements, game.CompletionPct, game.Status, now); err != nil {
+			return nil, err
 		}
 	}
 
-	if strings.TrimSpace(summary.AvatarURL) != "" {
+	meta := [][2]string{{"last_sync", strconv.FormatInt(time.Now().Unix(), 10)}}
+	if name := strings.TrimSpace(synced.profile.DisplayName); name != "" {
+		meta = append(meta, [2]string{"profile_name", synced.profile.DisplayName})
+	}
+	if avatar := strings.TrimSpace(synced.profile.AvatarURL); avatar != "" {
+		meta = append(meta, [2]string{"profile_avatar", synced.profile.AvatarURL})
+	}
+	for _, kv := range meta {
 		if _, err := tx.Exec(`
 			INSERT INTO user_meta(steam_id,key,value) VALUES(?,?,?)
 			ON CONFLICT(steam_id,key) DO UPDATE SET value=excluded.value
-		`, steamID, "profile_avatar", summary.AvatarURL); err != nil {
-			return err
+		`, steamID, kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	return unlocks, nil
+}
+
+// newUnlocks lists the achievements of synced that prev, the isolated sync
+// before, had locked; none on a first sync.
+func newUnlocks(prev, synced *isolatedUser) []UnlockEvent {
+	if prev == nil {
+		return nil
+	}
+	var unlocks []UnlockEvent
+	for _, game := range synced.games {
+		was := make(map[string]bool)
+		for _, a := range prev.achievements[game.AppID] {
+			was[a.APIName] = a.Achieved
+		}
+		for _, a := range synced.achievements[game.AppID] {
+			if a.Achieved && !was[a.APIName] {
+				unlocks = append(unlocks, UnlockEvent{AppID: game.AppID, APIName: a.APIName, Name: a.Name, GlobalPct: a.GlobalPct})
+			}
+		}
+	}
+	return unlocks
+}
+
+// sortUserData puts an isolated sync in the order readUserGamesFromDB and
+// readUserAchievementsFromDB read the tables in.
+func sortUserData(u *isolatedUser) {
+	sort.SliceStable(u.games, func(i, j int) bool {
+		if u.games[i].CompletionPct != u.games[j].CompletionPct {
+			return u.games[i].CompletionPct > u.games[j].CompletionPct
 		}
+		return u.games[i].Name < u.games[j].Name
+	})
+	for _, items := range u.achievements {
+		sort.SliceStable(items, func(i, j int) bool {
+			a, b := items[i], items[j]
+			if a.Achieved != b.Achieved {
+				return a.Achieved
+			}
+			if a.GlobalPct != b.GlobalPct {
+				return a.GlobalPct > b.GlobalPct
+			}
+			return a.Name < b.Name
+		})
 	}
+}
 
-	return tx.Commit()
+func readUnlockedSet(tx *sql.Tx, steamID SteamID) (map[string]bool, error) {
+	rows, err := tx.Query(`SELECT app_id, api_name FROM user_achievements WHERE steam_id=? AND achieved=1`, steamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]bool)
+	for rows.Next() {
+		var appID AppID
+		var apiName string
+		if err := rows.Scan(&appID, &apiName); err != nil {
+			return nil, err
+		}
+		out[unlockKey(appID, apiName)] = true
+	}
+	return out, rows.Err()
 }
 
-func (s *Server) syncFromSteam(lang string) error {
-	schema, err := fetchSchemaForGame(s.apiKey, defaultGlobalAppID, lang)
+func unlockKey(appID AppID, apiName string) string {
+	return appID.String() + "/" + apiName
+}
+
+// syncFromSteam refreshes the default app in the database. Concurrent
+// syncs of the same language share one run.
+func (s *Server) syncFromSteam(ctx context.Context, lang string) error {
+	if s.readOnly() {
+		return apperr.ErrReadOnly
+	}
+	_, err := s.fetches.do(ctx, syncFetchKey(ctx, lang), func(ctx context.Context) (any, error) {
+		return nil, s.runSteamSync(ctx, lang)
+	})
+	return err
+}
+
+func syncFetchKey(ctx context.Context, lang string) string {
+	return "sync|" + lang + "|" + cacheNamespace(ctx)
+}
+
+func (s *Server) runSteamSync(ctx context.Context, lang string) error {
+	schema, err := fetchSchemaForGame(ctx, s.apiKey, defaultGlobalAppID, lang)
 	if err != nil {
 		return err
 	}
-	pcts, err := fetchGlobalPercentages(defaultGlobalAppID)
+	pcts, err := fetchGlobalPercentages(ctx, defaultGlobalAppID)
 	if err != nil {
 		return err
 	}
+	var refSchema []Achievement
+	if ref := s.translationReference; ref != "" && ref != lang && cacheNamespace(ctx) == "" {
+		if refSchema, err = fetchSchemaForGame(ctx, s.apiKey, defaultGlobalAppID, ref); err != nil {
+			log.Printf("translation check: %s schema app %d: %v", ref, defaultGlobalAppID, err)
+			refSchema = nil
+		}
+	}
 
-	tx, err := s.db.Begin()
+	before, err := s.storedGlobalPercentages(ctx)
 	if err != nil {
+		log.Printf("refresh event: previous percentages: %v", err)
+	}
+	now := time.Now().Unix()
+	if ns := cacheNamespace(ctx); ns != "" {
+		items := make([]Achievement, len(schema))
+		for i, a := range schema {
+			a.GlobalPct = pcts[a.APIName]
+			items[i] = a
+		}
+		s.isolated.setGlobal(ns, items, time.Unix(now, 0).UTC())
+	} else {
+		if err := s.saveSteamSync(ctx, lang, schema, refSchema, pcts, now); err != nil {
+			s.history.add(defaultGlobalAppID, pcts, time.Unix(now, 0), err)
+			return err
+		}
+		s.history.wake()
+		s.globalIndex.Store(nil)
+		s.bumpGeneration(appGenerationScope(defaultGlobalAppID))
+		if lang == defaultLang {
+			s.refreshSprite(defaultGlobalAppID, schema)
+		}
+	}
+	// Outside the transaction: the pool has a single connection. last_sync
+	// has second precision; the event carries the same value as the
+	// X-Data-Fetched-At header.
+	syncedAt := time.Now().UTC().Truncate(time.Second)
+	if err := s.setLastSync(ctx, syncedAt); err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	if cacheNamespace(ctx) == "" {
+		outdated, err := s.outdatedTranslations(defaultGlobalAppID, lang, time.Now().Add(-s.translationGrace))
+		if err != nil {
+			log.Printf("translation check app %d (%s): %v", defaultGlobalAppID, lang, err)
+		}
+		s.events.publish(eventRefresh, defaultGlobalAppID.String(), map[string]any{"appId": defaultGlobalAppID, "fetchedAt": syncedAt, "outdatedTranslations": len(outdated),
+			"changed": pctChanges(before, pcts, s.policies.forApp(defaultGlobalAppID))})
+		s.webhooks.emit(eventRefresh, defaultGlobalAppID, webhookRefreshData{FetchedAt: syncedAt})
+		s.cdn.purge(appSurrogateKey(defaultGlobalAppID))
+	}
+	return nil
+}
+
+// storedGlobalPercentages are the percentages of the default app as of the
+// last sync.
+func (s *Server) storedGlobalPercentages(ctx context.Context) (map[string]float64, error) {
+	if ns := cacheNamespace(ctx); ns != "" {
+		g, _ := s.isolated.globalOf(ns)
+		out := make(map[string]float64, len(g.items))
+		for _, a := range g.items {
+			out[a.APIName] = a.GlobalPct
+		}
+		return out, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT api_name, percent FROM global_percent`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]float64)
+	for rows.Next() {
+		var apiName string
+		var pct float64
+		if err := rows.Scan(&apiName, &pct); err != nil {
+			return nil, err
+		}
+		out[apiName] = pct
+	}
+	return out, rows.Err()
+}
 
+// saveSteamSync stores a Steam sync of the default app made at now, with
+// its history rows. Isolated syncs keep theirs in s.isolated instead.
+// It goes through the write queue, like the player syncs, so it lands in
+// order with the writes queued before it.
+func (s *Server) saveSteamSync(ctx context.Context, lang string, schema, refSchema []Achievement, pcts map[string]float64, now int64) error {
+	return s.writes.execTx(context.WithoutCancel(ctx), func(tx *sql.Tx) error {
+		return s.saveSteamSyncTx(tx, lang, schema, refSchema, pcts, now)
+	})
+}
+
+func (s *Server) saveSteamSyncTx(tx *sql.Tx, lang string, schema, refSchema []Achievement, pcts map[string]float64, now int64) error {
 	achStmt, err := tx.Prepare(`
 		INSERT INTO achievements(api_name, name, description, icon, icon_gray, hidden)
 		VALUES(?,?,?,?,?,?)
@@ -203,7 +407,6 @@ func (s *Server) syncFromSteam(lang string) error {
 		}
 	}
 
-	now := time.Now().Unix()
 	pctStmt, err := tx.Prepare(`
 		INSERT INTO global_percent(api_name, percent, updated_at)
 		VALUES(?,?,?)
@@ -222,53 +425,189 @@ func (s *Server) syncFromSteam(lang string) error {
 		}
 	}
 
-	if err := s.setLastSync(time.Now()); err != nil {
+	if err := recordSchemaHistoryTx(tx, defaultGlobalAppID, schema, time.Unix(now, 0)); err != nil {
 		return err
 	}
-
-	return tx.Commit()
+	if err := recordLiveSnapshotsTx(tx, defaultGlobalAppID, pcts, time.Unix(now, 0)); err != nil {
+		return err
+	}
+	if err := recordTranslationStateTx(tx, defaultGlobalAppID, lang, schema, time.Unix(now, 0)); err != nil {
+		return err
+	}
+	if refSchema != nil {
+		if err := recordTranslationStateTx(tx, defaultGlobalAppID, s.translationReference, refSchema, time.Unix(now, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *Server) fetchSchemaForGameCached(appID int, lang string) ([]Achievement, error) {
+func (s *Server) fetchSchemaForGameCached(ctx context.Context, appID AppID, lang string) ([]Achievement, error) {
 	now := time.Now()
+	key := schemaCacheKey(ctx, appID, lang)
 
 	s.cacheMu.RLock()
-	entry, ok := s.appSchemaCache[appID]
+	entry, ok := s.appSchemaCache[key]
 	s.cacheMu.RUnlock()
-	if ok && now.Sub(entry.fetchedAt) <= appMetaCacheTTL {
+	if ok && now.Sub(entry.fetchedAt) <= s.cacheTTLFor(ctx, s.cfg().AppMetaCacheTTL) {
+		s.debugf("schema cache hit app %d", appID)
+		metrics.cacheLookup(cacheMetricSchema, true)
 		return entry.items, nil
 	}
 
-	items, err := fetchSchemaForGame(s.apiKey, appID, lang)
+	if s.readOnly() {
+		if ok {
+			return entry.items, nil
+		}
+		return nil, apperr.ErrReadOnly
+	}
+	fetch := func(ctx context.Context) (any, error) {
+		items, err := s.sourceFor(appID).FetchSchema(ctx, appID, lang)
+		if err == nil {
+			s.storeSchema(key, items, time.Now())
+		}
+		return items, err
+	}
+	if ok && s.revalidates(entry.fetchedAt, s.cacheTTLFor(ctx, s.cfg().AppMetaCacheTTL)) {
+		metrics.cacheLookup(cacheMetricSchema, true)
+		s.revalidate(ctx, key.String(), fmt.Sprintf("schema app %d (%s)", appID, lang), fetch)
+		markStale(ctx)
+		return entry.items, nil
+	}
+	s.debugf("schema cache miss app %d", appID)
+	metrics.cacheLookup(cacheMetricSchema, false)
+	v, err := s.fetches.do(ctx, key.String(), fetch)
 	if err != nil {
+		if ok {
+			log.Printf("schema app %d (%s): %v (serving stale cache)", appID, lang, err)
+			markStale(ctx)
+			return entry.items, nil
+		}
 		return nil, err
 	}
+	return v.([]Achievement), nil
+}
 
+func (s *Server) storeSchema(key CacheKey, items []Achievement, now time.Time) {
 	s.cacheMu.Lock()
-	s.appSchemaCache[appID] = appSchemaCacheEntry{items: items, fetchedAt: now}
+	gen := s.generation.Load()
+	if key.NS != "" {
+		s.evictIsolatedEntriesLocked()
+	} else {
+		gen = s.bumpGeneration(appGenerationScope(key.AppID))
+		s.cacheDirty.Store(true)
+	}
+	s.appSchemaCache[key] = appSchemaCacheEntry{items: items, fetchedAt: now, generation: gen}
 	s.cacheMu.Unlock()
-
-	return items, nil
+	if key.NS != "" {
+		return
+	}
+	s.indexAppLists(key.AppID)
+	if key.Lang == defaultLang {
+		s.refreshSprite(key.AppID, items)
+	}
 }
 
-func (s *Server) fetchGlobalPercentagesCached(appID int) (map[string]float64, error) {
+func (s *Server) fetchGlobalPercentagesCached(ctx context.Context, appID AppID) (map[string]float64, error) {
 	now := time.Now()
+	key := globalPctCacheKey(ctx, appID)
 
 	s.cacheMu.RLock()
-	entry, ok := s.appGlobalPctMap[appID]
+	entry, ok := s.appGlobalPctMap[key]
 	s.cacheMu.RUnlock()
-	if ok && now.Sub(entry.fetchedAt) <= appMetaCacheTTL {
+	if ok && now.Sub(entry.fetchedAt) <= s.cacheTTLFor(ctx, s.cfg().AppMetaCacheTTL) {
+		s.debugf("global pct cache hit app %d", appID)
+		metrics.cacheLookup(cacheMetricPct, true)
 		return entry.items, nil
 	}
 
-	items, err := fetchGlobalPercentages(appID)
+	if s.readOnly() {
+		if ok {
+			return entry.items, nil
+		}
+		return nil, apperr.ErrReadOnly
+	}
+	fetch := func(ctx context.Context) (any, error) {
+		items, err := s.sourceFor(appID).FetchPercentages(ctx, appID)
+		if err == nil {
+			s.storeGlobalPercentages(key, items, time.Now())
+		}
+		return items, err
+	}
+	if ok && s.revalidates(entry.fetchedAt, s.cacheTTLFor(ctx, s.cfg().AppMetaCacheTTL)) {
+		metrics.cacheLookup(cacheMetricPct, true)
+		s.revalidate(ctx, key.String(), fmt.Sprintf("global pct app %d", appID), fetch)
+		markStale(ctx)
+		return entry.items, nil
+	}
+	s.debugf("global pct cache miss app %d", appID)
+	metrics.cacheLookup(cacheMetricPct, false)
+	v, err := s.fetches.do(ctx, key.String(), fetch)
 	if err != nil {
+		if ok {
+			log.Printf("global pct app %d: %v (serving stale cache)", appID, err)
+			markStale(ctx)
+			return entry.items, nil
+		}
 		return nil, err
 	}
+	return v.(map[string]float64), nil
+}
+
+func (s *Server) storeGlobalPercentages(key CacheKey, items map[string]float64, now time.Time) {
+	s.cacheMu.Lock()
+	gen := s.generation.Load()
+	if key.NS != "" {
+		s.evictIsolatedEntriesLocked()
+	} else {
+		gen = s.bumpGeneration(appGenerationScope(key.AppID))
+		s.cacheDirty.Store(true)
+	}
+	before := s.appGlobalPctMap[key].items
+	s.appGlobalPctMap[key] = appGlobalPctCacheEntry{items: items, fetchedAt: now, generation: gen}
+	s.cacheMu.Unlock()
+
+	if key.NS == "" {
+		s.indexAppLists(key.AppID)
+		s.events.publish(eventRefresh, key.AppID.String(), map[string]any{"appId": key.AppID, "fetchedAt": now.UTC(), "changed": pctChanges(before, items, s.policies.forApp(key.AppID))})
+		s.webhooks.emit(eventRefresh, key.AppID, webhookRefreshData{FetchedAt: now.UTC()})
+		s.cdn.purge(appSurrogateKey(key.AppID))
+	}
+}
+
+// emptySchemaStatus tells a delisted game from one that simply has no
+// achievements. The result is cached on the schema TTL, except after a
+// failed store probe: that one reports gameStatusError and is asked again
+// on the next call.
+func (s *Server) emptySchemaStatus(ctx context.Context, appID AppID) string {
+	now := time.Now()
+
+	s.cacheMu.RLock()
+	entry, ok := s.appStatusCache[statusCacheKey(appID)]
+	s.cacheMu.RUnlock()
+	if ok && now.Sub(entry.fetchedAt) <= s.cfg().AppMetaCacheTTL {
+		return entry.status
+	}
+
+	if s.sourceFor(appID).Name() != sourceSteam || s.readOnly() {
+		// Only Steam games have a store page, and read-only mode does not
+		// call Steam.
+		return gameStatusNoAchievements
+	}
+	// A store probe whoever asked: its Steam call is attributed as one.
+	listed, err := fetchStoreListed(withUpstreamFeature(ctx, featureProbe), appID)
+	if err != nil {
+		log.Printf("store probe app %d: %v", appID, err)
+		return gameStatusError
+	}
+	status := gameStatusNoAchievements
+	if !listed {
+		status = gameStatusDelisted
+	}
 
 	s.cacheMu.Lock()
-	s.appGlobalPctMap[appID] = appGlobalPctCacheEntry{items: items, fetchedAt: now}
+	s.appStatusCache[statusCacheKey(appID)] = appStatusCacheEntry{status: status, fetchedAt: now}
 	s.cacheMu.Unlock()
 
-	return items, nil
+	return status
 }
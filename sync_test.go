@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestEmptySchemaStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+		cached bool
+	}{
+		{"listed", http.StatusOK, `{"440":{"success":true,"data":{}}}`, gameStatusNoAchievements, true},
+		{"delisted", http.StatusOK, `{"440":{"success":false}}`, gameStatusDelisted, true},
+		{"store down", http.StatusBadGateway, `upstream error`, gameStatusError, false},
+		{"other app only", http.StatusOK, `{"570":{"success":true}}`, gameStatusError, false},
+		{"null answer", http.StatusOK, `null`, gameStatusError, false},
+		{"not json", http.StatusOK, `<html>`, gameStatusError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeSteam(t)
+			f.handle("appdetails", tt.status, tt.body)
+			s := newTestServer(t)
+			if got := s.emptySchemaStatus(context.Background(), 440); got != tt.want {
+				t.Fatalf("status = %q, want %q", got, tt.want)
+			}
+			probes := f.count("appdetails")
+			if probes == 0 {
+				t.Fatal("store not probed")
+			}
+
+			// The next call: from the cache, or probing again after a failure.
+			f.handle("appdetails", http.StatusOK, `{"440":{"success":true}}`)
+			again := s.emptySchemaStatus(context.Background(), 440)
+			if tt.cached && (again != tt.want || f.count("appdetails") != probes) {
+				t.Errorf("cached status: got %q after %d more probes", again, f.count("appdetails")-probes)
+			}
+			if !tt.cached && (again != gameStatusNoAchievements || f.count("appdetails") == probes) {
+				t.Errorf("failed probe cached: second call %q after %d more probes", again, f.count("appdetails")-probes)
+			}
+		})
+	}
+}
+
+// The global sync goes through the write queue: a write queued before it
+// cannot land after it and overwrite the fresh percentages.
+func TestSteamSyncQueuedWithWrites(t *testing.T) {
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	stale := `INSERT INTO global_percent(api_name, percent, updated_at) VALUES('A', 99, 0)
+		ON CONFLICT(api_name) DO UPDATE SET percent=excluded.percent, updated_at=excluded.updated_at`
+	before := s.writes.stats().Written
+	s.writes.enqueue(stale)
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.writes.stats().Written; got < before+2 {
+		t.Errorf("%d writes through the queue, want the queued one and the sync", got-before)
+	}
+	var pct float64
+	if err := s.db.QueryRow(`SELECT percent FROM global_percent WHERE api_name='A'`).Scan(&pct); err != nil {
+		t.Fatal(err)
+	}
+	if pct != 80 {
+		t.Errorf("percent of A = %v after the sync, want 80 from Steam", pct)
+	}
+}
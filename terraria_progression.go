@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Progression mapping file (data/terraria_progression.json):
+//
+//	{
+//	  "appId": 105600,
+//	  "stages": [
+//	    { "id": "eye_of_cthulhu", "name": "Eye of Cthulhu", "achievements": ["EYE_ON_YOU"] },
+//	    { "id": "evil_boss", "name": "...", "require": "any", "achievements": ["WORM_FODDER", "MASTERMIND"] }
+//	  ]
+//	}
+//
+// Stages are listed in progression order. "require" is "all" (default) or
+// "any" and decides when a player has cleared the stage. An apiName may
+// belong to a single stage; achievements not listed end up in "misc".
+const defaultProgressionFile = "data/terraria_progression.json"
+const progressionMiscStageID = "misc"
+
+type progressionStageDef struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Require      string   `json:"require"`
+	Achievements []string `json:"achievements"`
+}
+
+type progressionMap struct {
+	AppID  AppID                 `json:"appId"`
+	Stages []progressionStageDef `json:"stages"`
+}
+
+type ProgressionStage struct {
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	Order         int           `json:"order"`
+	Require       string        `json:"require"`
+	ReachedPct    float64       `json:"reachedPct"`
+	Achievements  []Achievement `json:"achievements"`
+	UnlockedCount *int          `json:"unlockedCount,omitempty"`
+	Completed     *bool         `json:"completed,omitempty"`
+}
+
+type ProgressionResponse struct {
+	AppID        AppID              `json:"appId"`
+	SteamID      SteamID            `json:"steamId,omitempty"`
+	ReachedStage string             `json:"reachedStage,omitempty"`
+	Stages       []ProgressionStage `json:"stages"`
+}
+
+func loadProgressionMap(path string) (*progressionMap, error) {
+	b, err := readDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m progressionMap
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (m *progressionMap) validate() error {
+	if m.AppID <= 0 {
+		return fmt.Errorf("appId must be a positive integer")
+	}
+	if len(m.Stages) == 0 {
+		return fmt.Errorf("at least one stage is required")
+	}
+
+	stageIDs := make(map[string]bool, len(m.Stages))
+	owner := make(map[string]string)
+	for i, st := range m.Stages {
+		id := strings.TrimSpace(st.ID)
+		switch {
+		case id == "":
+			return fmt.Errorf("stage #%d: empty id", i+1)
+		case id == progressionMiscStageID:
+			return fmt.Errorf("stage #%d: id %q is reserved", i+1, id)
+		case stageIDs[id]:
+			return fmt.Errorf("stage %q: duplicate id", id)
+		case strings.TrimSpace(st.Name) == "":
+			return fmt.Errorf("stage %q: empty name", id)
+		case st.Require != "" && st.Require != "all" && st.Require != "any":
+			return fmt.Errorf("stage %q: require must be \"all\" or \"any\"", id)
+		case len(st.Achievements) == 0:
+			return fmt.Errorf("stage %q: no achievements", id)
+		}
+		stageIDs[id] = true
+
+		for _, apiName := range st.Achievements {
+			if strings.TrimSpace(apiName) == "" {
+				return fmt.Errorf("stage %q: empty achievement name", id)
+			}
+			if prev, ok := owner[apiName]; ok {
+				return fmt.Errorf("achievement %q listed in both %q and %q", apiName, prev, id)
+			}
+			owner[apiName] = id
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleTerrariaProgression(w http.ResponseWriter, r *http.Request) {
+	if s.progression == nil {
+		writeError(w, http.StatusNotFound, "progression_unavailable", "Aucun fichier de progression charge")
+		return
+	}
+
+	items, err := s.loadLocalizedGlobal(r.Context(), s.lang)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+
+	resp := ProgressionResponse{AppID: s.progression.AppID}
+	keys := []string{appSurrogateKey(s.progression.AppID), langSurrogateKey(s.lang)}
+
+	var userStates map[string]Achievement
+	if identifier := strings.TrimSpace(r.URL.Query().Get("steamid")); identifier != "" {
+		steamID, err := s.resolveSteamIDInput(identifier)
+		if err != nil {
+			writeIdentifierError(w, err)
+			return
+		}
+
+		expired, err := s.isUserCacheExpired(r.Context(), steamID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		var syncErr error
+		if expired {
+			syncErr = s.syncUserData(r.Context(), steamID, s.lang)
+		}
+
+		userItems, err := s.readUserAchievementsFromDB(r.Context(), steamID, s.progression.AppID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		if syncErr != nil {
+			if len(userItems) == 0 {
+				writeSyncError(w, syncErr, fmt.Sprintf("progression, steamID=%s", steamID))
+				return
+			}
+			log.Printf("steam sync warning (progression, steamID=%s): %v (serving cached data)", steamID, syncErr)
+			w.Header().Set("X-Data-Stale", "1")
+		}
+		if len(items) == 0 {
+			// The global list has not been synced yet; the player's rows
+			// carry the same schema and global percentages.
+			items = userItems
+		}
+		userStates = make(map[string]Achievement, len(userItems))
+		for _, a := range userItems {
+			userStates[a.APIName] = a
+		}
+		resp.SteamID = steamID
+		keys = append(keys, playerSurrogateKey(steamID))
+	}
+	setSurrogateKeys(w, keys...)
+
+	applyAccessibility(s.lang, items)
+	applyRarity(items)
+	resp.Stages = s.progression.build(items, userStates)
+	if userStates != nil {
+		for _, st := range resp.Stages {
+			if st.ID == progressionMiscStageID || st.Completed == nil || !*st.Completed {
+				continue
+			}
+			resp.ReachedStage = st.ID
+		}
+	}
+
+	if resp.SteamID != 0 {
+		s.setUserDataAge(r.Context(), w, resp.SteamID)
+		resp.SteamID = pseudonyms.steamID(resp.SteamID)
+	} else {
+		s.setGlobalDataAge(r.Context(), w)
+	}
+	writeJSON(w, resp)
+}
+
+// build groups achievements into the mapped stages. userStates is nil when
+// no player was requested.
+func (m *progressionMap) build(items []Achievement, userStates map[string]Achievement) []ProgressionStage {
+	byName := make(map[string]Achievement, len(items))
+	for _, a := range items {
+		byName[a.APIName] = a
+	}
+
+	mapped := make(map[string]bool)
+	stages := make([]ProgressionStage, 0, len(m.Stages)+1)
+	for i, def := range m.Stages {
+		require := def.Require
+		if require == "" {
+			require = "all"
+		}
+		st := ProgressionStage{ID: def.ID, Name: def.Name, Order: i + 1, Require: require, Achievements: make([]Achievement, 0, len(def.Achievements))}
+		for _, apiName := range def.Achievements {
+			mapped[apiName] = true
+			if a, ok := byName[apiName]; ok {
+				st.Achievements = append(st.Achievements, a)
+			}
+		}
+		stages = append(stages, st)
+	}
+
+	misc := ProgressionStage{ID: progressionMiscStageID, Name: "Misc", Order: len(m.Stages) + 1, Require: "all", Achievements: make([]Achievement, 0)}
+	for _, a := range items {
+		if !mapped[a.APIName] {
+			misc.Achievements = append(misc.Achievements, a)
+		}
+	}
+	if len(misc.Achievements) > 0 {
+		stages = append(stages, misc)
+	}
+
+	for i := range stages {
+		stages[i].finalize(userStates)
+	}
+	return stages
+}
+
+func (st *ProgressionStage) finalize(userStates map[string]Achievement) {
+	unlocked := 0
+	for i, a := range st.Achievements {
+		if i == 0 || a.GlobalPct < st.ReachedPct {
+			st.ReachedPct = a.GlobalPct
+		}
+		if userStates == nil {
+			continue
+		}
+		if u, ok := userStates[a.APIName]; ok && u.Achieved {
+			st.Achievements[i].Achieved = true
+			st.Achievements[i].UnlockTime = u.UnlockTime
+			unlocked++
+		}
+	}
+	if st.Require == "any" {
+		// Any single achievement clears the stage, so the best one counts.
+		for _, a := range st.Achievements {
+			if a.GlobalPct > st.ReachedPct {
+				st.ReachedPct = a.GlobalPct
+			}
+		}
+	}
+
+	if userStates == nil {
+		return
+	}
+	completed := len(st.Achievements) > 0 && unlocked == len(st.Achievements)
+	if st.Require == "any" {
+		completed = unlocked > 0
+	}
+	st.UnlockedCount = &unlocked
+	st.Completed = &completed
+}
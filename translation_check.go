@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// Valve often updates an English description long before the French one.
+// Each global sync also preloads the reference language (english, set by
+// TRANSLATION_REFERENCE_LANG, "off" to disable) and keeps a content hash per
+// (app, apiName, lang) in translation_state, with the time the hash last
+// changed. An entry whose reference text changed more than
+// TRANSLATION_GRACE ago while its own text did not is flagged
+// possiblyOutdatedTranslation until it changes too.
+const defaultTranslationGrace = 7 * 24 * time.Hour
+
+func translationReferenceFromEnv() string {
+	ref := getenv("TRANSLATION_REFERENCE_LANG", "english")
+	if ref == "off" {
+		return ""
+	}
+	return ref
+}
+
+func translationHash(a Achievement) string {
+	sum := sha256.Sum256([]byte(a.Name + "\x00" + a.Description))
+	return hex.EncodeToString(sum[:8])
+}
+
+// recordTranslationStateTx stores the hashes of schema. New rows start
+// with changed_at 0: a text seen for the first time is a baseline, not a
+// change, so adding a language never flags anything.
+func recordTranslationStateTx(tx *sql.Tx, appID AppID, lang string, schema []Achievement, now time.Time) error {
+	stmt, err := tx.Prepare(`
+		INSERT INTO translation_state(app_id, api_name, lang, hash, changed_at)
+		VALUES(?,?,?,?,0)
+		ON CONFLICT(app_id, api_name, lang) DO UPDATE SET
+			changed_at = CASE WHEN hash = excluded.hash THEN changed_at ELSE ? END,
+			hash = excluded.hash
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, a := range schema {
+		if _, err := stmt.Exec(appID, a.APIName, lang, translationHash(a), now.Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outdatedTranslations returns the apiNames of lang whose reference text
+// changed before cutoff and after their own last change.
+func (s *Server) outdatedTranslations(appID AppID, lang string, cutoff time.Time) (map[string]bool, error) {
+	ref := s.translationReference
+	out := make(map[string]bool)
+	if ref == "" || ref == lang {
+		return out, nil
+	}
+	rows, err := s.db.Query(`
+		SELECT t.api_name
+		FROM translation_state t
+		JOIN translation_state r ON r.app_id = t.app_id AND r.api_name = t.api_name AND r.lang = ?
+		WHERE t.app_id = ? AND t.lang = ?
+			AND r.changed_at > t.changed_at AND r.changed_at <= ?
+	`, ref, appID, lang, cutoff.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out[name] = true
+	}
+	return out, rows.Err()
+}
+
+// flagOutdatedTranslations sets PossiblyOutdatedTranslation on items. A
+// failure only loses the flags.
+func (s *Server) flagOutdatedTranslations(appID AppID, lang string, items []Achievement) {
+	outdated, err := s.outdatedTranslations(appID, lang, time.Now().Add(-s.translationGrace))
+	if err != nil {
+		log.Printf("translation check app %d (%s): %v", appID, lang, err)
+		return
+	}
+	for i := range items {
+		items[i].PossiblyOutdatedTranslation = outdated[items[i].APIName]
+	}
+}
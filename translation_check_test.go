@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func translatedSchema(names ...string) string {
+	return fmt.Sprintf(`{"game":{"availableGameStats":{"achievements":[
+		{"name":"A","displayName":%q,"description":%q,"icon":"","icongray":"","hidden":0},
+		{"name":"B","displayName":%q,"description":%q,"icon":"","icongray":"","hidden":0}]}}}`, names[0], names[1], names[2], names[3])
+}
+
+func outdatedFlags(t *testing.T, s *Server) map[string]bool {
+	t.Helper()
+	w := getAchievements(s, "")
+	var items []Achievement
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	out := make(map[string]bool)
+	for _, a := range items {
+		if a.PossiblyOutdatedTranslation {
+			out[a.APIName] = true
+		}
+	}
+	return out
+}
+
+func TestOutdatedTranslationFlag(t *testing.T) {
+	f := useFakeSteam(t)
+	f.handle("l=english", http.StatusOK, translatedSchema("Easy", "first", "Hard", "second"))
+	f.handle("l=french", http.StatusOK, translatedSchema("Facile", "premier", "Dur", "second"))
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, fakePctJSON)
+	s := newTestServer(t)
+	s.translationReference, s.translationGrace = "english", time.Hour
+	events := s.events.subscribe([]string{eventRefresh + ":*"})
+	defer s.events.unsubscribe(events)
+	ctx := context.Background()
+	sync := func() int {
+		t.Helper()
+		if err := s.syncFromSteam(ctx, "french"); err != nil {
+			t.Fatal(err)
+		}
+		var data struct{ OutdatedTranslations int }
+		select {
+		case ev := <-events.ch:
+			json.Unmarshal(ev.Data, &data)
+		default:
+			t.Fatal("no refresh event")
+		}
+		return data.OutdatedTranslations
+	}
+
+	// First sight of both languages: a baseline.
+	if n := sync(); n != 0 || len(outdatedFlags(t, s)) != 0 {
+		t.Fatalf("baseline: %d outdated, flags %v", n, outdatedFlags(t, s))
+	}
+
+	// English changes A alone; French lags.
+	f.handle("l=english", http.StatusOK, translatedSchema("Easy", "first, reworded", "Hard", "second"))
+	if n := sync(); n != 0 || len(outdatedFlags(t, s)) != 0 {
+		t.Errorf("within the grace period: %d outdated, flags %v", n, outdatedFlags(t, s))
+	}
+	s.translationGrace = 0
+	if n := sync(); n != 1 || fmt.Sprint(outdatedFlags(t, s)) != "map[A:true]" {
+		t.Errorf("past the grace period: %d outdated, flags %v; want A only", n, outdatedFlags(t, s))
+	}
+
+	// French catches up.
+	f.handle("l=french", http.StatusOK, translatedSchema("Facile", "premier, reformule", "Dur", "second"))
+	if n := sync(); n != 0 || len(outdatedFlags(t, s)) != 0 {
+		t.Errorf("after French caught up: %d outdated, flags %v", n, outdatedFlags(t, s))
+	}
+
+	var rows int
+	s.db.QueryRow(`SELECT COUNT(*) FROM translation_state WHERE app_id = ?`, defaultGlobalAppID).Scan(&rows)
+	if rows != 4 {
+		t.Errorf("%d translation_state rows, want one per (apiName, lang)", rows)
+	}
+}
+
+func TestTranslationReferenceFromEnv(t *testing.T) {
+	for env, want := range map[string]string{"": "english", "german": "german", "off": ""} {
+		t.Setenv("TRANSLATION_REFERENCE_LANG", env)
+		if got := translationReferenceFromEnv(); got != want {
+			t.Errorf("TRANSLATION_REFERENCE_LANG=%q: %q, want %q", env, got, want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// Every Steam request is attributed to the feature that caused it, carried
+// in the context from its entry point: list_refresh (a visitor finding the
+// cache expired), player_fetch (player pages and the player scheduler),
+// prefetch (the refresher), probe (schema watch, store pages, the check
+// command) and admin_verify; anything untagged is "other". Each attempt is
+// counted once, retries and hedges included, so the features add up to
+// yboost_upstream_requests_total. Counts are kept per UTC day for
+// upstreamUsageDays days.
+//
+// UPSTREAM_DAILY_BUDGET caps the requests of a UTC day, and
+// UPSTREAM_FEATURE_QUOTAS=prefetch=20%,probe=500 the share of one feature,
+// in percent of the budget or in requests. A request over either is not
+// sent: it fails with apperr.ErrUpstreamQuota, which callers handle as any
+// Steam failure (stale data when there is some).
+type upstreamFeature string
+
+const (
+	featureListRefresh upstreamFeature = "list_refresh"
+	featurePlayerFetch upstreamFeature = "player_fetch"
+	featurePrefetch    upstreamFeature = "prefetch"
+	featureProbe       upstreamFeature = "probe"
+	featureAdminVerify upstreamFeature = "admin_verify"
+	featureOther       upstreamFeature = "other"
+)
+
+var upstreamFeatures = []upstreamFeature{featureListRefresh, featurePlayerFetch, featurePrefetch, featureProbe, featureAdminVerify, featureOther}
+
+const upstreamUsageDays = 7
+
+const upstreamFeatureMiddlewareName = "upstream"
+
+type upstreamFeatureCtxKey struct{}
+
+// withUpstreamFeature attributes the Steam calls made under ctx to f.
+func withUpstreamFeature(ctx context.Context, f upstreamFeature) context.Context {
+	return context.WithValue(ctx, upstreamFeatureCtxKey{}, f)
+}
+
+func upstreamFeatureOf(ctx context.Context) upstreamFeature {
+	if f, ok := ctx.Value(upstreamFeatureCtxKey{}).(upstreamFeature); ok {
+		return f
+	}
+	return featureOther
+}
+
+// upstream attributes the Steam calls of rt to f; without it they are
+// list_refresh.
+func (rt apiRoute) upstream(f upstreamFeature) apiRoute {
+	rt.Middleware = append(rt.Middleware, routeMiddleware{Name: upstreamFeatureMiddlewareName, Wrap: func(next http.HandlerFunc) http.HandlerFunc {
+		return withUpstreamFeatureHandler(f, next)
+	}})
+	return rt
+}
+
+func withUpstreamFeatureHandler(f upstreamFeature, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(withUpstreamFeature(r.Context(), f)))
+	}
+}
+
+type featureCounters struct {
+	requests, errors, refused int64
+}
+
+type upstreamDay struct {
+	day      string // archiveDateLayout, UTC
+	total    int64
+	requests map[upstreamFeature]int64
+	refused  map[upstreamFeature]int64
+}
+
+type upstreamLedger struct {
+	budget int64                     // requests per UTC day, 0: none
+	quotas map[upstreamFeature]int64 // requests per UTC day
+
+	mu       sync.Mutex
+	counters map[upstreamFeature]*featureCounters
+	days     []*upstreamDay // oldest first
+}
+
+var upstreamUsage = newUpstreamLedger(0, nil)
+
+func newUpstreamLedger(budget int64, quotas map[upstreamFeature]int64) *upstreamLedger {
+	l := &upstreamLedger{budget: budget, quotas: quotas, counters: make(map[upstreamFeature]*featureCounters, len(upstreamFeatures))}
+	for _, f := range upstreamFeatures {
+		l.counters[f] = &featureCounters{}
+	}
+	return l
+}
+
+func upstreamLedgerFromEnv() (*upstreamLedger, error) {
+	budget := int64(getenvInt("UPSTREAM_DAILY_BUDGET", 0))
+	if budget < 0 {
+		return nil, fmt.Errorf("UPSTREAM_DAILY_BUDGET: %d is negative", budget)
+	}
+	quotas, err := parseFeatureQuotas(getenv("UPSTREAM_FEATURE_QUOTAS", ""), budget)
+	if err != nil {
+		return nil, err
+	}
+	return newUpstreamLedger(budget, quotas), nil
+}
+
+// parseFeatureQuotas reads "feature=20%,feature=500"; a percentage needs
+// the daily budget it is a share of.
+func parseFeatureQuotas(raw string, budget int64) (map[upstreamFeature]int64, error) {
+	quotas := make(map[upstreamFeature]int64)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		f := upstreamFeature(strings.TrimSpace(name))
+		if !ok || !slices.Contains(upstreamFeatures, f) {
+			return nil, fmt.Errorf("UPSTREAM_FEATURE_QUOTAS: %q is not feature=requests or feature=percent%% (features: %s)", part, joinFeatures(upstreamFeatures))
+		}
+		value = strings.TrimSpace(value)
+		if pct, isPct := strings.CutSuffix(value, "%"); isPct {
+			p, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+			if err != nil || p < 0 || p > 100 {
+				return nil, fmt.Errorf("UPSTREAM_FEATURE_QUOTAS: %q is not a percentage between 0 and 100", part)
+			}
+			if budget == 0 {
+				return nil, fmt.Errorf("UPSTREAM_FEATURE_QUOTAS: %q is a share of UPSTREAM_DAILY_BUDGET, which is not set", part)
+			}
+			quotas[f] = int64(float64(budget) * p / 100)
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("UPSTREAM_FEATURE_QUOTAS: %q is not a number of requests", part)
+		}
+		quotas[f] = n
+	}
+	if len(quotas) == 0 {
+		return nil, nil
+	}
+	return quotas, nil
+}
+
+func joinFeatures(fs []upstreamFeature) string {
+	names := make([]string, len(fs))
+	for i, f := range fs {
+		names[i] = string(f)
+	}
+	return strings.Join(names, ", ")
+}
+
+// todayLocked is the day of now, created (and the oldest forgotten) on the
+// first request of a day.
+func (l *upstreamLedger) todayLocked(now time.Time) *upstreamDay {
+	day := now.UTC().Format(archiveDateLayout)
+	if n := len(l.days); n > 0 && l.days[n-1].day == day {
+		return l.days[n-1]
+	}
+	d := &upstreamDay{day: day, requests: make(map[upstreamFeature]int64), refused: make(map[upstreamFeature]int64)}
+	l.days = append(l.days, d)
+	if len(l.days) > upstreamUsageDays {
+		l.days = l.days[len(l.days)-upstreamUsageDays:]
+	}
+	return d
+}
+
+// take counts one request of f about to be sent, or refuses it when the
+// day's budget or f's quota is spent.
+func (l *upstreamLedger) take(f upstreamFeature, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d := l.todayLocked(now)
+	var err error
+	if l.budget > 0 && d.total >= l.budget {
+		err = apperr.Wrapf(apperr.ErrUpstreamQuota, "daily budget of %d Steam requests spent (%s)", l.budget, f)
+	} else if q, ok := l.quotas[f]; ok && d.requests[f] >= q {
+		err = apperr.Wrapf(apperr.ErrUpstreamQuota, "%s spent its %d Steam requests of the day", f, q)
+	}
+	if err != nil {
+		if d.refused[f] == 0 {
+			log.Printf("upstream [%s]: %v, refusing until 00:00 UTC", f, err)
+		}
+		d.refused[f]++
+		l.counters[f].refused++
+		return err
+	}
+	d.total++
+	d.requests[f]++
+	l.counters[f].requests++
+	return nil
+}
+
+// spent reports whether a request of f would be refused now, without
+// counting one.
+func (l *upstreamLedger) spent(f upstreamFeature, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d := l.todayLocked(now)
+	if l.budget > 0 && d.total >= l.budget {
+		return true
+	}
+	q, ok := l.quotas[f]
+	return ok && d.requests[f] >= q
+}
+
+// failed records that a request of f taken earlier failed.
+func (l *upstreamLedger) failed(f upstreamFeature) {
+	l.mu.Lock()
+	l.counters[f].errors++
+	l.mu.Unlock()
+}
+
+type featureUsage struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	Refused  int64 `json:"refused"`
+	Today    int64 `json:"today"`
+	Quota    int64 `json:"dailyQuota,omitempty"`
+}
+
+type upstreamDayStats struct {
+	Day      string                    `json:"day"`
+	Total    int64                     `json:"total"`
+	Requests map[upstreamFeature]int64 `json:"requests"`
+	Refused  map[upstreamFeature]int64 `json:"refused,omitempty"`
+}
+
+type upstreamUsageStats struct {
+	DailyBudget int64                            `json:"dailyBudget,omitempty"`
+	Today       int64                            `json:"today"`
+	Features    map[upstreamFeature]featureUsage `json:"byFeature"`
+	Days        []upstreamDayStats               `json:"days"` // newest first
+}
+
+func (l *upstreamLedger) snapshot(now time.Time) upstreamUsageStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	today := now.UTC().Format(archiveDateLayout)
+	st := upstreamUsageStats{DailyBudget: l.budget, Features: make(map[upstreamFeature]featureUsage, len(upstreamFeatures)), Days: make([]upstreamDayStats, 0, len(l.days))}
+	var current *upstreamDay
+	for i := len(l.days) - 1; i >= 0; i-- {
+		d := l.days[i]
+		if d.day == today {
+			current = d
+			st.Today = d.total
+		}
+		ds := upstreamDayStats{Day: d.day, Total: d.total, Requests: make(map[upstreamFeature]int64, len(d.requests))}
+		for f, n := range d.requests {
+			ds.Requests[f] = n
+		}
+		if len(d.refused) > 0 {
+			ds.Refused = make(map[upstreamFeature]int64, len(d.refused))
+			for f, n := range d.refused {
+				ds.Refused[f] = n
+			}
+		}
+		st.Days = append(st.Days, ds)
+	}
+	for _, f := range upstreamFeatures {
+		c := l.counters[f]
+		fu := featureUsage{Requests: c.requests, Errors: c.errors, Refused: c.refused, Quota: l.quotas[f]}
+		if current != nil {
+			fu.Today = current.requests[f]
+		}
+		st.Features[f] = fu
+	}
+	return st
+}
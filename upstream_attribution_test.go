@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// featureTotals sums the requests of every feature.
+func featureTotals(st upstreamUsageStats) int64 {
+	var n int64
+	for _, fu := range st.Features {
+		n += fu.Requests
+	}
+	return n
+}
+
+// flakySteam answers the percentages, the first failures requests with a
+// 503.
+func flakySteam(t *testing.T, failures int64) *atomic.Int64 {
+	t.Helper()
+	requests := new(atomic.Int64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fakePctJSON))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	base := &http.Transport{}
+	t.Cleanup(base.CloseIdleConnections)
+	saved, savedDelay := steamHTTPClient.Transport, upstreamHedge.delay
+	steamHTTPClient.Transport, upstreamHedge.delay = toServer{u, base}, 0
+	t.Cleanup(func() { steamHTTPClient.Transport, upstreamHedge.delay = saved, savedDelay })
+	return requests
+}
+
+func TestAttributionThroughRetries(t *testing.T) {
+	restartableProtections(t, &fakeClock{t: time.Now()})
+	requests := flakySteam(t, 1)
+	logs := captureLog(t)
+	sent := metrics.upstreamRequests.Load()
+
+	ctx := withUpstreamFeature(context.Background(), featurePrefetch)
+	if _, err := fetchGlobalPercentages(ctx, 440); err != nil {
+		t.Fatal(err)
+	}
+	st := upstreamUsage.snapshot(time.Now())
+	prefetch := st.Features[featurePrefetch]
+	if requests.Load() != 2 || prefetch.Requests != 2 || prefetch.Errors != 1 || prefetch.Today != 2 || st.Today != 2 {
+		t.Errorf("%d sent, prefetch %+v, today %d; want 2 requests, 1 error", requests.Load(), prefetch, st.Today)
+	}
+	if total, global := featureTotals(st), metrics.upstreamRequests.Load()-sent; total != global {
+		t.Errorf("features add up to %d, %d Steam requests", total, global)
+	}
+	if !strings.Contains(logs.String(), "upstream [prefetch]: ") || !strings.Contains(logs.String(), "attempt 2 of") {
+		t.Errorf("retry log without the feature: %q", logs.String())
+	}
+}
+
+// Both the request and its hedge count once, under the feature of the call.
+func TestAttributionThroughHedges(t *testing.T) {
+	restartableProtections(t, &fakeClock{t: time.Now()})
+	requests, cancelled := useHangingSteam(t, 50*time.Millisecond)
+	sent := metrics.upstreamRequests.Load()
+
+	ctx := withUpstreamFeature(context.Background(), featureProbe)
+	if _, err := fetchGlobalPercentages(ctx, 440); err != nil {
+		t.Fatal(err)
+	}
+	<-cancelled
+	deadline := time.Now().Add(time.Second)
+	for metrics.upstreamRequests.Load()-sent < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	st := upstreamUsage.snapshot(time.Now())
+	if requests.Load() != 2 || st.Features[featureProbe].Requests != 2 || st.Today != 2 {
+		t.Errorf("%d sent, probe %+v; want 2 requests", requests.Load(), st.Features[featureProbe])
+	}
+	if total, global := featureTotals(st), metrics.upstreamRequests.Load()-sent; total != 2 || global != 2 {
+		t.Errorf("features add up to %d, %d Steam requests; want 2 and 2", total, global)
+	}
+}
+
+func TestUpstreamQuotas(t *testing.T) {
+	restartableProtections(t, &fakeClock{t: time.Now()})
+	requests := flakySteam(t, 0)
+	upstreamUsage = newUpstreamLedger(5, map[upstreamFeature]int64{featurePrefetch: 2})
+	logs := captureLog(t)
+	prefetch := withUpstreamFeature(context.Background(), featurePrefetch)
+	for i := range 3 {
+		_, err := fetchGlobalPercentages(prefetch, 440)
+		if refused := apperr.Is(err, apperr.CodeUpstreamQuota); refused != (i == 2) {
+			t.Errorf("prefetch %d: %v", i+1, err)
+		}
+	}
+	// Refused before it was sent, and logged once for the day.
+	fetchGlobalPercentages(prefetch, 440)
+	if requests.Load() != 2 || strings.Count(logs.String(), "refusing until 00:00 UTC") != 1 {
+		t.Errorf("%d sent; log %q", requests.Load(), logs.String())
+	}
+	if !upstreamUsage.spent(featurePrefetch, time.Now()) || upstreamUsage.spent(featureListRefresh, time.Now()) {
+		t.Errorf("spent: prefetch should be, list_refresh not")
+	}
+
+	// The rest of the budget goes to the other features.
+	list := withUpstreamFeature(context.Background(), featureListRefresh)
+	for i := range 4 {
+		_, err := fetchGlobalPercentages(list, 440)
+		if refused := apperr.Is(err, apperr.CodeUpstreamQuota); refused != (i == 3) {
+			t.Errorf("list refresh %d: %v", i+1, err)
+		}
+	}
+	st := upstreamUsage.snapshot(time.Now())
+	if st.Today != 5 || requests.Load() != 5 || st.Features[featurePrefetch].Refused != 2 || st.Features[featureListRefresh].Refused != 1 || st.Features[featurePrefetch].Quota != 2 {
+		t.Errorf("usage %+v", st)
+	}
+	if apperr.Status(apperr.CodeUpstreamQuota) != http.StatusServiceUnavailable {
+		t.Errorf("upstream_quota answers %d", apperr.Status(apperr.CodeUpstreamQuota))
+	}
+
+	// A new UTC day has its own budget.
+	tomorrow := time.Now().Add(24 * time.Hour)
+	if err := upstreamUsage.take(featurePrefetch, tomorrow); err != nil {
+		t.Errorf("next day: %v", err)
+	}
+	if st := upstreamUsage.snapshot(tomorrow); st.Today != 1 || len(st.Days) != 2 || st.Days[0].Day != tomorrow.UTC().Format(archiveDateLayout) || st.Days[1].Total != 5 {
+		t.Errorf("next day usage %+v", st)
+	}
+}
+
+// API routes are list_refresh unless tagged otherwise.
+func TestAttributionOfRoutes(t *testing.T) {
+	restartableProtections(t, &fakeClock{t: time.Now()})
+	f := fakeSteamGame(t)
+	f.handle("GetPlayerAchievements", http.StatusOK, fakePlayerJSON)
+	s := newTestServer(t)
+	call := notesMux(t, s)
+	if w := call("GET", "/api/v1/achievements?appId=440", ""); w.Code != http.StatusOK {
+		t.Fatalf("achievements: %d %s", w.Code, w.Body)
+	}
+	st := upstreamUsage.snapshot(time.Now())
+	if st.Features[featureListRefresh].Requests == 0 || st.Features[featurePlayerFetch].Requests != 0 {
+		t.Errorf("after /achievements: %+v", st.Features)
+	}
+	before := st.Features[featureListRefresh].Requests
+	if w := call("GET", "/api/v1/players/76561197960287930/achievements?appId=105600", ""); w.Code != http.StatusOK {
+		t.Fatalf("player: %d %s", w.Code, w.Body)
+	}
+	st = upstreamUsage.snapshot(time.Now())
+	if st.Features[featurePlayerFetch].Requests == 0 || st.Features[featureOther].Requests != 0 {
+		t.Errorf("after a player page: %+v (list_refresh was %d)", st.Features, before)
+	}
+
+	var admin struct{ Usage upstreamUsageStats }
+	json.Unmarshal(call("GET", "/api/v1/admin/upstream", "").Body.Bytes(), &admin)
+	if admin.Usage.Features[featurePlayerFetch].Requests != st.Features[featurePlayerFetch].Requests || len(admin.Usage.Days) != 1 {
+		t.Errorf("/admin/upstream usage %+v", admin.Usage)
+	}
+	w := httptest.NewRecorder()
+	s.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `yboost_upstream_feature_requests_total{feature="player_fetch"} `) {
+		t.Errorf("/metrics without the features")
+	}
+}
+
+func TestParseFeatureQuotas(t *testing.T) {
+	got, err := parseFeatureQuotas(" prefetch=20% , probe=500", 1000)
+	if err != nil || got[featurePrefetch] != 200 || got[featureProbe] != 500 || len(got) != 2 {
+		t.Errorf("%v, %v", got, err)
+	}
+	if got, err := parseFeatureQuotas(" , ", 0); got != nil || err != nil {
+		t.Errorf("empty: %v, %v", got, err)
+	}
+	for _, bad := range []string{"prefetch=20%", "unknown=1", "prefetch", "probe=-1", "probe=lots"} {
+		if _, err := parseFeatureQuotas(bad, 0); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+	if _, err := parseFeatureQuotas("prefetch=120%", 1000); err == nil {
+		t.Errorf("120%% accepted")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// Circuit breaker: after UPSTREAM_BREAKER_FAILURES Steam calls in a row
+// failed (5; a call fails once its retries are spent, on a network error,
+// 429 or 5xx), calls are refused without being sent for
+// UPSTREAM_BREAKER_COOLDOWN (30s) with apperr.ErrUpstreamOpen, which
+// callers handle as any Steam failure: stale data when there is some. The
+// first call after the cooldown is let through as a probe, the others
+// still refused; its success closes the breaker, its failure opens it for
+// another cooldown. Answers such as a private profile are Steam working
+// and reset the count. UPSTREAM_BREAKER_FAILURES=0 disables the breaker.
+// Opening and closing raise breaker_open and breaker_closed alerts.
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// callOutcome is what a finished call tells the breaker.
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	// callAbandoned is a call that says nothing of Steam: cancelled by
+	// its caller, or refused before it was sent.
+	callAbandoned
+)
+
+type upstreamBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	alerts    *alertNotifier
+
+	mu        sync.Mutex
+	failures  int       // in a row
+	openUntil time.Time // zero: closed
+	probing   bool
+	trips     int64
+	refused   int64
+	lastTrip  time.Time
+}
+
+var breaker = newUpstreamBreaker(defaultBreakerFailures, defaultBreakerCooldown)
+
+func newUpstreamBreaker(threshold int, cooldown time.Duration) *upstreamBreaker {
+	return &upstreamBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func upstreamBreakerFromEnv(alerts *alertNotifier) *upstreamBreaker {
+	threshold := getenvInt("UPSTREAM_BREAKER_FAILURES", defaultBreakerFailures)
+	if threshold < 0 {
+		threshold = 0
+	}
+	b := newUpstreamBreaker(threshold, getenvDuration("UPSTREAM_BREAKER_COOLDOWN", defaultBreakerCooldown))
+	b.alerts = alerts
+	return b
+}
+
+// allow reports whether a call may be sent, and whether it is the probe.
+// Every allowed call must end with done.
+func (b *upstreamBreaker) allow() (probe bool, err error) {
+	if b == nil || b.threshold <= 0 {
+		return false, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return false, nil
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		b.refused++
+		return false, apperr.Wrapf(apperr.ErrUpstreamOpen, "%d Steam calls failed in a row, retrying after %s", b.failures, b.openUntil.UTC().Format(time.RFC3339))
+	}
+	b.probing = true
+	return true, nil
+}
+
+func (b *upstreamBreaker) done(probe bool, outcome callOutcome) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	var alert *alertPayload
+	b.mu.Lock()
+	if probe {
+		b.probing = false
+	}
+	switch outcome {
+	case callSucceeded:
+		if !b.openUntil.IsZero() {
+			open := b.now().Sub(b.lastTrip).Round(time.Second)
+			log.Printf("upstream breaker: Steam answers again, closed after %s", open)
+			alert = &alertPayload{Type: alertBreakerClosed, Duration: open.String()}
+		}
+		b.failures, b.openUntil = 0, time.Time{}
+	case callFailed:
+		b.failures++
+		if probe || (b.openUntil.IsZero() && b.failures >= b.threshold) {
+			now := b.now()
+			if b.openUntil.IsZero() {
+				b.lastTrip = now
+				b.trips++
+				log.Printf("upstream breaker: %d Steam calls failed in a row, refusing calls for %s", b.failures, b.cooldown)
+				alert = &alertPayload{Type: alertBreakerOpen, Error: fmt.Sprintf("%d Steam calls failed in a row", b.failures), Duration: b.cooldown.String()}
+			}
+			b.openUntil = now.Add(b.cooldown)
+		}
+	}
+	b.mu.Unlock()
+	if alert != nil {
+		b.alerts.notify(*alert)
+	}
+}
+
+// outcomeOf classifies the end of a call the way isUpstreamFailure does.
+func outcomeOf(status int, err error) callOutcome {
+	switch {
+	case err == nil:
+		return callSucceeded
+	case apperr.Is(err, apperr.CodeUpstreamQuota), apperr.Is(err, apperr.CodeUpstreamOpen), errors.Is(err, errNoUpstreamSlot):
+		return callAbandoned
+	case status == http.StatusTooManyRequests || status >= 500:
+		return callFailed
+	case status != 0:
+		return callSucceeded
+	}
+	return callFailed
+}
+
+type breakerStats struct {
+	Enabled   bool    `json:"enabled"`
+	Open      bool    `json:"open"`
+	Failures  int     `json:"consecutiveFailures"`
+	OpenUntil apiTime `json:"openUntil"`
+	Trips     int64   `json:"trips"`
+	Refused   int64   `json:"refused"`
+	Threshold int     `json:"threshold"`
+	Cooldown  string  `json:"cooldown"`
+}
+
+func (b *upstreamBreaker) snapshot() breakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := breakerStats{Enabled: b.threshold > 0, Open: !b.openUntil.IsZero(), Failures: b.failures, Trips: b.trips, Refused: b.refused,
+		Threshold: b.threshold, Cooldown: b.cooldown.String()}
+	if st.Open {
+		st.OpenUntil = apiTime(b.openUntil.Unix())
+	}
+	return st
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// gzipTransport asks Steam for gzip explicitly and decompresses the body
+// itself. Go's transparent gzip only applies while the caller leaves
+// Accept-Encoding alone, so it would silently stop as soon as a custom
+// header is set; doing it here keeps schema responses (~200KB of JSON)
+// compressed on the wire either way.
+type gzipTransport struct {
+	base http.RoundTripper
+}
+
+// upstreamBytes counts response body bytes as received (wire) and after
+// decompression (decoded). Identity responses add the same amount to both.
+var upstreamBytes struct {
+	responses     atomic.Int64
+	gzipResponses atomic.Int64
+	wire          atomic.Int64
+	decoded       atomic.Int64
+}
+
+type upstreamBytesStats struct {
+	Responses     int64   `json:"responses"`
+	GzipResponses int64   `json:"gzipResponses"`
+	WireBytes     int64   `json:"wireBytes"`
+	DecodedBytes  int64   `json:"decodedBytes"`
+	Ratio         float64 `json:"ratio"`
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+	upstreamBytes.responses.Add(1)
+
+	wire := &countingReadCloser{ReadCloser: res.Body, n: &upstreamBytes.wire}
+	if !strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		res.Body = &countingReadCloser{ReadCloser: wire, n: &upstreamBytes.decoded}
+		return res, nil
+	}
+
+	upstreamBytes.gzipResponses.Add(1)
+	res.Body = &gzipBody{wire: wire}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return res, nil
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// gzipBody opens the gzip stream on first read, so a bad header surfaces
+// as a read error like any other truncated body.
+type gzipBody struct {
+	wire io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.wire)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.zr.Read(p)
+	upstreamBytes.decoded.Add(int64(n))
+	return n, err
+}
+
+func (b *gzipBody) Close() error {
+	return b.wire.Close()
+}
+
+func upstreamBytesSnapshot() upstreamBytesStats {
+	st := upstreamBytesStats{
+		Responses:     upstreamBytes.responses.Load(),
+		GzipResponses: upstreamBytes.gzipResponses.Load(),
+		WireBytes:     upstreamBytes.wire.Load(),
+		DecodedBytes:  upstreamBytes.decoded.Load(),
+	}
+	if st.WireBytes > 0 {
+		st.Ratio = float64(st.DecodedBytes) / float64(st.WireBytes)
+	}
+	return st
+}
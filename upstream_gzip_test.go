@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// toServer sends every request to srv, whatever its host.
+type toServer struct {
+	srv  *url.URL
+	base http.RoundTripper
+}
+
+func (t toServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.srv.Scheme, t.srv.Host
+	return t.base.RoundTrip(r)
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// useGzipSteam serves the Steam calls from a server that gzips when asked,
+// through the real client stack with Go's transparent gzip turned off.
+// It returns the Accept-Encoding headers Steam received.
+func useGzipSteam(t *testing.T, routes map[string][]byte) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var encodings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		mu.Unlock()
+		for fragment, body := range routes {
+			if strings.Contains(r.URL.Path, fragment) {
+				if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(body)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	base := &http.Transport{DisableCompression: true}
+	t.Cleanup(base.CloseIdleConnections)
+
+	saved, savedHosts := steamHTTPClient.Transport, steamHosts
+	steamHTTPClient.Transport = &tracingTransport{base: &gzipTransport{base: toServer{u, base}}}
+	steamHosts = nil
+	t.Cleanup(func() { steamHTTPClient.Transport, steamHosts = saved, savedHosts })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), encodings...)
+	}
+}
+
+func TestGzipUpstreamEndToEnd(t *testing.T) {
+	schema := gzipped(t, fakeSchemaJSON)
+	encodings := useGzipSteam(t, map[string][]byte{
+		"GetSchemaForGame":                      schema,
+		"GetGlobalAchievementPercentagesForApp": gzipped(t, fakePctJSON),
+	})
+	before := upstreamBytesSnapshot()
+
+	items, err := fetchSchemaForGame(context.Background(), "key", 440, "french")
+	if err != nil || len(items) != 2 || items[0].APIName != "A" {
+		t.Fatalf("schema from a gzipped body: %+v, %v", items, err)
+	}
+	pcts, err := fetchGlobalPercentages(context.Background(), 440)
+	if err != nil || pcts["A"] != 80 || pcts["B"] != 2.5 {
+		t.Fatalf("percentages from a gzipped body: %v, %v", pcts, err)
+	}
+	if len(encodings()) != 2 {
+		t.Errorf("%d calls reached Steam, want 2", len(encodings()))
+	}
+	for _, e := range encodings() {
+		if e != "gzip" {
+			t.Errorf("Accept-Encoding %q sent to Steam, want gzip", e)
+		}
+	}
+
+	after := upstreamBytesSnapshot()
+	if n := after.GzipResponses - before.GzipResponses; n != 2 {
+		t.Errorf("%d gzip responses counted, want 2", n)
+	}
+	wire, decoded := after.WireBytes-before.WireBytes, after.DecodedBytes-before.DecodedBytes
+	want := int64(len(fakeSchemaJSON) + len(fakePctJSON))
+	if decoded != want || wire >= decoded || wire < int64(len(schema)) {
+		t.Errorf("wire %d, decoded %d bytes; want %d decoded and fewer on the wire", wire, decoded, want)
+	}
+}
+
+func TestGzipUpstreamIdentityAndCorrupt(t *testing.T) {
+	var sent string
+	plain := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r.Header.Get("Accept-Encoding")
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: r}, nil
+	})
+	req := httptest.NewRequest(http.MethodGet, "https://api.steampowered.com/", nil)
+	req.Header.Set("Accept-Encoding", "identity")
+	res, err := (&gzipTransport{base: plain}).RoundTrip(req)
+	if err != nil || sent != "identity" || res.Uncompressed {
+		t.Errorf("explicit Accept-Encoding: sent %q, uncompressed %v, %v", sent, res.Uncompressed, err)
+	}
+
+	corrupt := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Encoding": {"gzip"}},
+			Body: http.NoBody, Request: r}, nil
+	})
+	res, err = (&gzipTransport{base: corrupt}).RoundTrip(httptest.NewRequest(http.MethodGet, "https://api.steampowered.com/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.Body.Read(make([]byte, 8)); err == nil {
+		t.Error("a gzip body without a header read as valid")
+	}
+	if res.Header.Get("Content-Encoding") != "" {
+		t.Error("Content-Encoding kept on a decoded body")
+	}
+}
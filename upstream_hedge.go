@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hedging: the keyless percentages call is sometimes stuck for seconds
+// while a fresh attempt answers at once. With UPSTREAM_HEDGE_DELAY set
+// (e.g. 800ms), a second identical request is fired when the first has not
+// answered by then, and the first response wins; the other one is
+// cancelled. Calls carrying the API key are never hedged, so the key
+// budget is unchanged.
+type upstreamHedgeConfig struct {
+	delay  time.Duration
+	hedged atomic.Int64 // second requests fired
+	wins   atomic.Int64 // of those, answered first
+}
+
+var upstreamHedge upstreamHedgeConfig
+
+type hedgeStats struct {
+	Enabled bool   `json:"enabled"`
+	Delay   string `json:"delay,omitempty"`
+	Hedged  int64  `json:"hedged"`
+	Wins    int64  `json:"wins"`
+}
+
+func upstreamHedgeSnapshot() hedgeStats {
+	st := hedgeStats{Enabled: upstreamHedge.delay > 0, Hedged: upstreamHedge.hedged.Load(), Wins: upstreamHedge.wins.Load()}
+	if st.Enabled {
+		st.Delay = upstreamHedge.delay.String()
+	}
+	return st
+}
+
+func hasAPIKey(rawURL string) bool {
+	u, err := neturl.Parse(rawURL)
+	return err != nil || u.Query().Has("key")
+}
+
+type hedgeResult struct {
+	body  []byte
+	err   error
+	hedge bool
+}
+
+// hedgedGET is httpGET for idempotent keyless calls. An error from one
+// attempt waits for the other one if it is still running; the hedge is not
+// a retry and is only fired by the delay. It returns once the cancelled
+// loser is done too, so that its breaker and attribution bookkeeping stays
+// within the call.
+func hedgedGET(ctx context.Context, url string) ([]byte, error) {
+	delay := upstreamHedge.delay
+	if delay <= 0 || hasAPIKey(url) {
+		body, _, err := httpGETContext(ctx, url)
+		return body, err
+	}
+
+	var running sync.WaitGroup
+	defer running.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels the loser, before the wait
+	results := make(chan hedgeResult, 2)
+	launch := func(hedge bool) {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			body, _, err := httpGETContext(ctx, url)
+			results <- hedgeResult{body: body, err: err, hedge: hedge}
+		}()
+	}
+
+	launch(false)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			upstreamHedge.hedged.Add(1)
+			launch(true)
+			pending++
+		case res := <-results:
+			pending--
+			if res.err != nil && pending > 0 {
+				continue
+			}
+			if res.err == nil && res.hedge {
+				upstreamHedge.wins.Add(1)
+			}
+			return res.body, res.err
+		}
+	}
+}
+
+func (s *Server) handleAdminUpstream(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		upstreamBytesStats
+		Hedge   hedgeStats         `json:"hedge"`
+		Retry   retryStats         `json:"retry"`
+		Breaker breakerStats       `json:"breaker"`
+		Hosts   []steamHostStats   `json:"hosts,omitempty"`
+		Usage   upstreamUsageStats `json:"usage"`
+	}{upstreamBytesSnapshot(), upstreamHedgeSnapshot(), upstreamRetrySnapshot(), breaker.snapshot(), steamHosts.snapshot(), upstreamUsage.snapshot(time.Now())})
+}
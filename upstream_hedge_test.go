@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useHangingSteam answers every Steam call with the percentages, except
+// the first one, which hangs until its client goes away.
+func useHangingSteam(t *testing.T, delay time.Duration) (requests *atomic.Int64, cancelled chan struct{}) {
+	t.Helper()
+	requests = new(atomic.Int64)
+	cancelled = make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			<-r.Context().Done()
+			close(cancelled)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fakePctJSON))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	base := &http.Transport{}
+	t.Cleanup(base.CloseIdleConnections)
+
+	saved, savedHosts, savedDelay := steamHTTPClient.Transport, steamHosts, upstreamHedge.delay
+	steamHTTPClient.Transport, steamHosts = toServer{u, base}, nil
+	upstreamHedge.delay = delay
+	t.Cleanup(func() {
+		steamHTTPClient.Transport, steamHosts, upstreamHedge.delay = saved, savedHosts, savedDelay
+	})
+	return requests, cancelled
+}
+
+func TestHedgeAnswersPastTheDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	requests, cancelled := useHangingSteam(t, delay)
+	hedged, wins := upstreamHedge.hedged.Load(), upstreamHedge.wins.Load()
+
+	start := time.Now()
+	pcts, err := fetchGlobalPercentages(context.Background(), 440)
+	elapsed := time.Since(start)
+	if err != nil || pcts["A"] != 80 {
+		t.Fatalf("percentages %v, %v", pcts, err)
+	}
+	if elapsed < delay || elapsed > delay+500*time.Millisecond {
+		t.Errorf("answered in %s, want just past the %s delay", elapsed, delay)
+	}
+	if requests.Load() != 2 || upstreamHedge.hedged.Load() != hedged+1 || upstreamHedge.wins.Load() != wins+1 {
+		t.Errorf("%d requests, %d hedges, %d wins; want 2, 1, 1",
+			requests.Load(), upstreamHedge.hedged.Load()-hedged, upstreamHedge.wins.Load()-wins)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("the hanging request was not cancelled")
+	}
+}
+
+// A fast first answer fires no hedge, and calls carrying the key are
+// never hedged.
+func TestHedgeSkipped(t *testing.T) {
+	requests, _ := useHangingSteam(t, 50*time.Millisecond)
+	hedged := upstreamHedge.hedged.Load()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := hedgedGET(ctx, "https://api.steampowered.com/ISteamUserStats/GetSchemaForGame/v2/?key=secret&appid=440"); err == nil {
+		t.Error("the hanging keyed call answered")
+	}
+	if requests.Load() != 1 || upstreamHedge.hedged.Load() != hedged {
+		t.Errorf("keyed call: %d requests, %d hedges; want 1 and 0", requests.Load(), upstreamHedge.hedged.Load()-hedged)
+	}
+
+	// The hanging request is spent: the next one answers at once.
+	if _, err := hedgedGET(context.Background(), "https://api.steampowered.com/ISteamUserStats/GetGlobalAchievementPercentagesForApp/v0002/?gameid=440"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if requests.Load() != 2 || upstreamHedge.hedged.Load() != hedged {
+		t.Errorf("fast call: %d requests, %d hedges; want 2 and 0", requests.Load(), upstreamHedge.hedged.Load()-hedged)
+	}
+}
+
+func TestHasAPIKey(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"https://api.steampowered.com/x/?key=k&appid=1": true,
+		"https://api.steampowered.com/x/?appid=1&key=":  true,
+		"https://api.steampowered.com/x/?gameid=1":      false,
+		"https://api.steampowered.com/x/?monkey=1":      false,
+		"://bad url": true,
+	} {
+		if got := hasAPIKey(raw); got != want {
+			t.Errorf("hasAPIKey(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
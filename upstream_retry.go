@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Retries: a Steam call answered 429 or 5xx, or that timed out, is tried
+// again up to UPSTREAM_RETRY_ATTEMPTS times in all (3), after an
+// exponential backoff with jitter (500ms, then 1s, each +-50%) or the
+// Retry-After Steam sent. Every attempt and wait of one call fits in
+// UPSTREAM_RETRY_BUDGET (20s); a wait that would not fit ends the call
+// with the last error instead. Cancelling the context stops the retries.
+//
+// Rate limiting: schema and percentage fetches take a token from a bucket
+// refilled at UPSTREAM_RATE calls per second (4), holding up to
+// UPSTREAM_BURST (8), and wait for one when it is empty. UPSTREAM_RATE=0
+// turns the limiter off.
+//
+// Concurrency: UPSTREAM_MAX_CONCURRENCY caps the Steam requests in flight
+// at once, all features together; an attempt past the cap waits for a slot
+// or for its context; one still waiting when the retry budget is spent
+// ends with errNoUpstreamSlot, which the breaker does not count against
+// Steam. 0, the default, leaves it uncapped.
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBudget   = 20 * time.Second
+	retryBaseDelay       = 500 * time.Millisecond
+	defaultUpstreamRate  = 4
+	defaultUpstreamBurst = 8
+)
+
+type upstreamRetryConfig struct {
+	attempts int
+	budget   time.Duration
+	retried  atomic.Int64 // attempts after the first
+	gaveUp   atomic.Int64 // calls that failed after retrying
+
+	slots         chan struct{} // nil: no concurrency cap
+	waitedForSlot atomic.Int64
+}
+
+var errNoUpstreamSlot = errors.New("no free Steam request slot within the retry budget")
+
+var upstreamRetry = &upstreamRetryConfig{attempts: defaultRetryAttempts, budget: defaultRetryBudget}
+
+type retryStats struct {
+	Attempts  int    `json:"attempts"`
+	Budget    string `json:"budget"`
+	Retried   int64  `json:"retried"`
+	GaveUp    int64  `json:"gaveUp"`
+	Throttled int64  `json:"throttled"`
+	// MaxConcurrency is 0 when uncapped.
+	MaxConcurrency int   `json:"maxConcurrency"`
+	InFlight       int   `json:"inFlight"`
+	WaitedForSlot  int64 `json:"waitedForSlot"`
+}
+
+func upstreamRetrySnapshot() retryStats {
+	st := retryStats{
+		Attempts: upstreamRetry.attempts, Budget: upstreamRetry.budget.String(),
+		Retried: upstreamRetry.retried.Load(), GaveUp: upstreamRetry.gaveUp.Load(),
+		MaxConcurrency: cap(upstreamRetry.slots), InFlight: len(upstreamRetry.slots), WaitedForSlot: upstreamRetry.waitedForSlot.Load(),
+	}
+	if steamLimiter != nil {
+		st.Throttled = steamLimiter.throttled.Load()
+	}
+	return st
+}
+
+// retryable tells whether a failed attempt is worth another one. err is
+// the raw transport error, status 0 when there was no response.
+func retryable(ctx context.Context, status int, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if status != 0 {
+		return status == http.StatusTooManyRequests || status >= 500
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryDelay is the wait before attempt+1.
+func retryDelay(attempt int) time.Duration {
+	d := retryBaseDelay << (attempt - 1)
+	return d/2 + rand.N(d)
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or as a date; 0
+// when absent or unreadable.
+func parseRetryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func upstreamRetryFromEnv() *upstreamRetryConfig {
+	cfg := &upstreamRetryConfig{
+		attempts: getenvInt("UPSTREAM_RETRY_ATTEMPTS", defaultRetryAttempts),
+		budget:   getenvDuration("UPSTREAM_RETRY_BUDGET", defaultRetryBudget),
+	}
+	if cfg.attempts < 1 {
+		cfg.attempts = 1
+	}
+	if n := getenvInt("UPSTREAM_MAX_CONCURRENCY", 0); n > 0 {
+		cfg.slots = make(chan struct{}, n)
+	}
+	return cfg
+}
+
+// acquire takes a slot for one attempt; the returned func gives it back.
+func (c *upstreamRetryConfig) acquire(ctx context.Context) (func(), error) {
+	if c.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		c.waitedForSlot.Add(1)
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, errNoUpstreamSlot
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-c.slots }, nil
+}
+
+// tokenBucket hands out reservations: a caller takes a token even when the
+// bucket is empty and waits until it would have been refilled, so waiters
+// go through in arrival order. A nil bucket never waits.
+type tokenBucket struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	throttled atomic.Int64 // calls that had to wait
+}
+
+var steamLimiter = newTokenBucket(defaultUpstreamRate, defaultUpstreamBurst)
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	missing := -b.tokens
+	b.mu.Unlock()
+	if missing <= 0 {
+		return nil
+	}
+
+	b.throttled.Add(1)
+	t := time.NewTimer(time.Duration(missing / b.rate * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens++ // give the reservation back
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultUpstreamLogBodyBytes = 4 << 10
+const maxUpstreamDumpBytes = 8 << 20
+
+// upstreamTraceConfig is set from LOG_UPSTREAM_BODIES=trace and friends.
+// Every upstream call is logged with status and latency; bodies are only
+// logged for failed calls, cut at UPSTREAM_LOG_BODY_BYTES. With
+// UPSTREAM_DUMP_BODIES=1 the failing body is also saved under
+// data/upstream-dumps, whole up to maxUpstreamDumpBytes (8 MiB): past that
+// it is cut there, and the caller reads the cut body too. The API key is
+// redacted from every URL logged or saved.
+type upstreamTraceConfig struct {
+	enabled      bool
+	logBodyBytes int
+	dumpDir      string
+}
+
+var upstreamTrace upstreamTraceConfig
+
+var steamHTTPClient = &http.Client{
+	Timeout:   12 * time.Second,
+	Transport: &tracingTransport{base: &gzipTransport{base: http.DefaultTransport}},
+}
+
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	// A cancelled call (the losing hedge) says nothing about Steam.
+	if !errors.Is(err, context.Canceled) {
+		upstreamHealth.record(req, res, err)
+	}
+	if !upstreamTrace.enabled {
+		return res, err
+	}
+
+	latency := time.Since(start).Round(time.Millisecond)
+	safeURL := redactURL(req.URL)
+	if err != nil {
+		log.Printf("upstream trace [%s]: %s %s failed after %s: %v", upstreamFeatureOf(req.Context()), req.Method, safeURL, latency, redactError(err))
+		return res, err
+	}
+
+	log.Printf("upstream trace [%s]: %s %s -> %d in %s", upstreamFeatureOf(req.Context()), req.Method, safeURL, res.StatusCode, latency)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxUpstreamDumpBytes))
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		traceUpstreamFailure(safeURL, fmt.Sprintf("status %d", res.StatusCode), body)
+	}
+	return res, nil
+}
+
+// decodeUpstreamJSON unmarshals a Steam response, tracing the raw body when
+// it does not parse.
+func decodeUpstreamJSON(rawURL string, body []byte, v any, what string) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		if upstreamTrace.enabled {
+			safe := rawURL
+			if u, parseErr := neturl.Parse(rawURL); parseErr == nil {
+				safe = redactURL(u)
+			}
+			traceUpstreamFailure(safe, "parse error: "+err.Error(), body)
+		}
+		return fmt.Errorf("%s json parse: %w", what, err)
+	}
+	return nil
+}
+
+func traceUpstreamFailure(safeURL string, reason string, body []byte) {
+	excerpt := body
+	if len(excerpt) > upstreamTrace.logBodyBytes {
+		excerpt = excerpt[:upstreamTrace.logBodyBytes]
+	}
+	log.Printf("upstream trace: %s %s: body (%d of %d bytes): %q", safeURL, reason, len(excerpt), len(body), excerpt)
+
+	if upstreamTrace.dumpDir == "" {
+		return
+	}
+	if err := os.MkdirAll(upstreamTrace.dumpDir, 0o755); err != nil {
+		log.Printf("upstream trace: dump dir: %v", err)
+		return
+	}
+	name := fmt.Sprintf("upstream-%s.txt", time.Now().UTC().Format("20060102T150405.000000000"))
+	content := fmt.Sprintf("url: %s\nreason: %s\n\n%s", safeURL, reason, body)
+	path := filepath.Join(upstreamTrace.dumpDir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		log.Printf("upstream trace: dump body: %v", err)
+		return
+	}
+	log.Printf("upstream trace: full body saved to %s", path)
+}
+
+// redactURL strips the Steam API key from a URL before it is logged.
+func redactURL(u *neturl.URL) string {
+	c := *u
+	q := c.Query()
+	if q.Has("key") {
+		q.Set("key", "REDACTED")
+		c.RawQuery = q.Encode()
+	}
+	return c.String()
+}
+
+// redactError rewrites *url.Error so transport errors never carry the key.
+func redactError(err error) error {
+	var uerr *neturl.Error
+	if errors.As(err, &uerr) {
+		if u, parseErr := neturl.Parse(uerr.URL); parseErr == nil {
+			return &neturl.Error{Op: uerr.Op, URL: redactURL(u), Err: uerr.Err}
+		}
+	}
+	return err
+}
+
+func upstreamTraceFromEnv(dataDir string) upstreamTraceConfig {
+	cfg := upstreamTraceConfig{
+		enabled:      strings.EqualFold(strings.TrimSpace(os.Getenv("LOG_UPSTREAM_BODIES")), "trace"),
+		logBodyBytes: getenvInt("UPSTREAM_LOG_BODY_BYTES", defaultUpstreamLogBodyBytes),
+	}
+	if cfg.logBodyBytes <= 0 {
+		cfg.logBodyBytes = defaultUpstreamLogBodyBytes
+	}
+	if cfg.enabled && getenv("UPSTREAM_DUMP_BODIES", "") == "1" {
+		cfg.dumpDir = filepath.Join(dataDir, "upstream-dumps")
+	}
+	return cfg
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const traceKey = "SECRETKEY123"
+
+func useUpstreamTrace(t *testing.T, cfg upstreamTraceConfig) {
+	t.Helper()
+	saved := upstreamTrace
+	upstreamTrace = cfg
+	t.Cleanup(func() { upstreamTrace = saved })
+}
+
+// A failing keyed call is logged and dumped without the key, the logged
+// excerpt stops at UPSTREAM_LOG_BODY_BYTES and the caller still reads the
+// whole body.
+func TestUpstreamTraceFailedCall(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	useUpstreamTrace(t, upstreamTraceConfig{enabled: true, logBodyBytes: 16, dumpDir: dir})
+	const body = "0123456789abcdef and the rest of the error page"
+	tr := &tracingTransport{base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+	req, _ := http.NewRequest(http.MethodGet, "https://api.steampowered.com/ISteamUserStats/GetSchemaForGame/v2/?key="+traceKey+"&appid=105600", nil)
+
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(res.Body); string(got) != body {
+		t.Errorf("body handed back %q, want %q", got, body)
+	}
+	out := logs.String()
+	if strings.Contains(out, traceKey) || !strings.Contains(out, "key=REDACTED") {
+		t.Errorf("log leaks the key or does not redact it:\n%s", out)
+	}
+	if want := fmt.Sprintf(`body (16 of %d bytes): "0123456789abcdef"`, len(body)); !strings.Contains(out, want) {
+		t.Errorf("log without %s:\n%s", want, out)
+	}
+
+	dumps, _ := filepath.Glob(filepath.Join(dir, "upstream-*.txt"))
+	if len(dumps) != 1 {
+		t.Fatalf("%d dump files, want 1", len(dumps))
+	}
+	dump, _ := os.ReadFile(dumps[0])
+	if strings.Contains(string(dump), traceKey) || !strings.HasSuffix(string(dump), "\n\n"+body) {
+		t.Errorf("dump leaks the key or lacks the full body:\n%s", dump)
+	}
+}
+
+// Transport errors carry the URL; the logged one is redacted.
+func TestUpstreamTraceTransportError(t *testing.T) {
+	logs := captureLog(t)
+	useUpstreamTrace(t, upstreamTraceConfig{enabled: true, logBodyBytes: defaultUpstreamLogBodyBytes})
+	keyed := "https://api.steampowered.com/IPlayerService/GetOwnedGames/v1/?key=" + traceKey
+	tr := &tracingTransport{base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, &url.Error{Op: "Get", URL: keyed, Err: errors.New("connection reset")}
+	})}
+	req, _ := http.NewRequest(http.MethodGet, keyed, nil)
+
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Fatal("error swallowed")
+	}
+	if out := logs.String(); strings.Contains(out, traceKey) || !strings.Contains(out, "connection reset") {
+		t.Errorf("transport error log:\n%s", out)
+	}
+	if got := redactError(&url.Error{Op: "Get", URL: keyed, Err: io.EOF}).Error(); strings.Contains(got, traceKey) {
+		t.Errorf("redactError = %s", got)
+	}
+}
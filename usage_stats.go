@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Usage statistics count which routes, response formats and query
+// parameters are used. Only names are counted: never parameter values,
+// never client addresses. Counters are atomics allocated at route
+// registration, so the request path only does atomic adds. They are
+// rolled up into usage_daily (UTC days) by a background loop and on
+// shutdown. DISABLE_USAGE_STATS=1 turns all of it off.
+const usageFlushInterval = 5 * time.Minute
+
+const (
+	usageFormatJSON = iota
+	usageFormatLite
+	usageFormatJSONP
+	usageFormatHTML
+	usageFormatCSV
+	usageFormatXLSX
+	usageFormatMD
+)
+
+var usageFormats = [...]string{"json", "lite", "jsonp", "html", "csv", "xlsx", "md"}
+
+// usageParams are the query parameters whose presence is counted; any
+// other name is ignored so clients cannot grow the table.
+var usageParams = [...]string{
+	"steamId", "appId", "q", "tag", "tagMode", "minPct", "maxPct", "profile", "baseline",
+	"asOf", "refresh", "callback", "include", "exclude", "topics", "numlocale", "tz", "prefix", "verbose",
+	"limit", "offset", "cursor", "format",
+}
+
+type usageCounters struct {
+	total   atomic.Int64
+	formats [len(usageFormats)]atomic.Int64
+	params  [len(usageParams)]atomic.Int64
+}
+
+type usageStats struct {
+	routes map[string]*usageCounters // written during registration only
+	names  []string
+}
+
+// usage is nil when DISABLE_USAGE_STATS=1; methods are nil-safe.
+var usage *usageStats
+
+func newUsageStats() *usageStats {
+	return &usageStats{routes: make(map[string]*usageCounters)}
+}
+
+func usageStatsFromEnv() *usageStats {
+	if getenv("DISABLE_USAGE_STATS", "") == "1" {
+		return nil
+	}
+	return newUsageStats()
+}
+
+// wrap counts calls of next under name ("GET /achievements"). Must be
+// called before the server starts.
+func (u *usageStats) wrap(name string, next http.Handler) http.Handler {
+	if u == nil {
+		return next
+	}
+	c, ok := u.routes[name]
+	if !ok {
+		c = &usageCounters{}
+		u.routes[name] = c
+		u.names = append(u.names, name)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.record(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// record scans the raw query in place; it must not allocate.
+func (c *usageCounters) record(r *http.Request) {
+	c.total.Add(1)
+	format := usageFormatJSON
+	raw := r.URL.RawQuery
+	for raw != "" {
+		var pair string
+		pair, raw, _ = strings.Cut(raw, "&")
+		name, value, _ := strings.Cut(pair, "=")
+		for i, p := range usageParams {
+			if strings.EqualFold(name, p) {
+				c.params[i].Add(1)
+				switch {
+				case p == "callback":
+					format = usageFormatJSONP
+				case p == "profile" && strings.EqualFold(value, profileLite) && format == usageFormatJSON:
+					format = usageFormatLite
+				case p == "format" && strings.EqualFold(value, formatCSV):
+					format = usageFormatCSV
+				case p == "format" && strings.EqualFold(value, formatHTML):
+					format = usageFormatHTML
+				case p == "format" && strings.EqualFold(value, formatXLSX):
+					format = usageFormatXLSX
+				case p == "format" && strings.EqualFold(value, formatMD):
+					format = usageFormatMD
+				}
+				break
+			}
+		}
+	}
+	if format == usageFormatJSON && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		format = usageFormatHTML
+	}
+	c.formats[format].Add(1)
+}
+
+// drain returns the counts since the last drain as dimension -> count,
+// with dimensions "total", "format:<f>" and "param:<p>".
+func (c *usageCounters) drain() map[string]int64 {
+	out := make(map[string]int64)
+	if n := c.total.Swap(0); n > 0 {
+		out["total"] = n
+	}
+	for i := range c.formats {
+		if n := c.formats[i].Swap(0); n > 0 {
+			out["format:"+usageFormats[i]] = n
+		}
+	}
+	for i := range c.params {
+		if n := c.params[i].Swap(0); n > 0 {
+			out["param:"+usageParams[i]] = n
+		}
+	}
+	return out
+}
+
+func (c *usageCounters) peek() map[string]int64 {
+	out := make(map[string]int64)
+	if n := c.total.Load(); n > 0 {
+		out["total"] = n
+	}
+	for i := range c.formats {
+		if n := c.formats[i].Load(); n > 0 {
+			out["format:"+usageFormats[i]] = n
+		}
+	}
+	for i := range c.params {
+		if n := c.params[i].Load(); n > 0 {
+			out["param:"+usageParams[i]] = n
+		}
+	}
+	return out
+}
+
+const upsertUsageSQL = `
+	INSERT INTO usage_daily(day, route, dimension, count) VALUES(?,?,?,?)
+	ON CONFLICT(day, route, dimension) DO UPDATE SET count = count + excluded.count
+`
+
+// flush queues the pending counts for day. On shutdown it must run before
+// the write queue is closed.
+func (u *usageStats) flush(q *writeQueue, day string) {
+	if u == nil {
+		return
+	}
+	for _, name := range u.names {
+		for dim, n := range u.routes[name].drain() {
+			q.enqueue(upsertUsageSQL, day, name, dim, n)
+		}
+	}
+}
+
+func usageDay(t time.Time) string { return t.UTC().Format(time.DateOnly) }
+
+// nextUsageFlush is the next flush after now: every usageFlushInterval,
+// and exactly at UTC midnight so a window never spans two days.
+func nextUsageFlush(now time.Time) time.Time {
+	next := now.Add(usageFlushInterval)
+	midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if midnight.Before(next) {
+		return midnight
+	}
+	return next
+}
+
+// run rolls the counters up until ctx is done. Each window is credited to
+// the day it started in; the last window is flushed by shutdown. While
+// paused (read-only mode) counts keep accumulating in memory.
+func (u *usageStats) run(ctx context.Context, q *writeQueue, paused func() bool) {
+	if u == nil {
+		return
+	}
+	windowStart := time.Now()
+	next := nextUsageFlush(windowStart)
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if paused() {
+			next = nextUsageFlush(time.Now())
+			continue
+		}
+		u.flush(q, usageDay(windowStart))
+		windowStart = time.Now()
+		next = nextUsageFlush(windowStart)
+	}
+}
+
+type usageRow struct {
+	Route    string           `json:"route"`
+	Requests int64            `json:"requests"`
+	Formats  map[string]int64 `json:"formats"`
+	Params   map[string]int64 `json:"params"`
+}
+
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if usage == nil {
+		writeJSON(w, map[string]any{"enabled": false})
+		return
+	}
+	days := 7
+	if raw := strings.TrimSpace(r.URL.Query().Get("days")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 366 {
+			writeError(w, http.StatusBadRequest, "invalid_days", "days doit etre un entier entre 1 et 366")
+			return
+		}
+		days = n
+	}
+	from := usageDay(time.Now().AddDate(0, 0, -(days - 1)))
+
+	rows := make(map[string]*usageRow)
+	add := func(route, dim string, n int64) {
+		row, ok := rows[route]
+		if !ok {
+			row = &usageRow{Route: route, Formats: make(map[string]int64), Params: make(map[string]int64)}
+			rows[route] = row
+		}
+		switch {
+		case dim == "total":
+			row.Requests += n
+		case strings.HasPrefix(dim, "format:"):
+			row.Formats[strings.TrimPrefix(dim, "format:")] += n
+		case strings.HasPrefix(dim, "param:"):
+			row.Params[strings.TrimPrefix(dim, "param:")] += n
+		}
+	}
+
+	res, err := s.db.QueryContext(r.Context(), `SELECT route, dimension, SUM(count) FROM usage_daily WHERE day >= ? GROUP BY route, dimension`, from)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	if err := scanUsageRows(res, add); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	// Counts not rolled up yet belong to today.
+	for _, name := range usage.names {
+		for dim, n := range usage.routes[name].peek() {
+			add(name, dim, n)
+		}
+	}
+
+	out := make([]usageRow, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Requests != out[j].Requests {
+			return out[i].Requests > out[j].Requests
+		}
+		return out[i].Route < out[j].Route
+	})
+	writeJSON(w, map[string]any{"enabled": true, "days": days, "from": from, "routes": out})
+}
+
+func scanUsageRows(rows *sql.Rows, add func(route, dim string, n int64)) error {
+	defer rows.Close()
+	for rows.Next() {
+		var route, dim string
+		var n int64
+		if err := rows.Scan(&route, &dim, &n); err != nil {
+			return err
+		}
+		add(route, dim, n)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNextUsageFlushMidnight(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"midday", utc("2026-10-14T12:00:00Z"), utc("2026-10-14T12:05:00Z")},
+		{"window ends at midnight", utc("2026-10-14T23:55:00Z"), utc("2026-10-15T00:00:00Z")},
+		{"window would span midnight", utc("2026-10-14T23:58:30Z"), utc("2026-10-15T00:00:00Z")},
+		{"one nanosecond before", utc("2026-10-14T23:59:59.999999999Z"), utc("2026-10-15T00:00:00Z")},
+		{"at midnight", utc("2026-10-15T00:00:00Z"), utc("2026-10-15T00:05:00Z")},
+		{"UTC midnight, not local", utc("2026-10-14T21:58:00Z").In(paris), utc("2026-10-14T22:03:00Z")},
+		{"local clock past UTC midnight", utc("2026-10-14T23:58:00Z").In(paris), utc("2026-10-15T00:00:00Z")},
+	}
+	for _, tt := range tests {
+		if got := nextUsageFlush(tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: next flush after %s = %s, want %s", tt.name, tt.now, got.UTC(), tt.want)
+		}
+	}
+
+	// A window is credited to the UTC day it started in.
+	if d := usageDay(utc("2026-10-14T23:59:59Z").In(paris)); d != "2026-10-14" {
+		t.Errorf("day of 23:59:59 UTC = %s", d)
+	}
+	if d := usageDay(utc("2026-10-15T00:00:00Z")); d != "2026-10-15" {
+		t.Errorf("day of midnight UTC = %s", d)
+	}
+}
+
+func TestUsageRecord(t *testing.T) {
+	c := &usageCounters{}
+	for _, raw := range []string{
+		"/api/achievements",
+		"/api/achievements?format=csv&appId=440",
+		"/api/achievements?profile=lite&steamId=76561197960287930",
+		"/api/achievements?callback=cb&q=secret+value",
+		"/api/achievements?password=hunter2&APPID=1",
+	} {
+		c.record(httptest.NewRequest(http.MethodGet, raw, nil))
+	}
+	got := c.peek()
+	want := map[string]int64{
+		"total": 5, "format:json": 2, "format:csv": 1, "format:lite": 1, "format:jsonp": 1,
+		"param:format": 1, "param:appId": 2, "param:profile": 1, "param:steamId": 1, "param:callback": 1, "param:q": 1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("counts %v, want %v", got, want)
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("%s = %d, want %d (all: %v)", k, got[k], n, got)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/achievements?appId=440&format=csv&tag=boss&limit=10", nil)
+	if allocs := testing.AllocsPerRun(100, func() { c.record(r) }); allocs != 0 {
+		t.Errorf("record allocates %v times per request", allocs)
+	}
+}
+
+func TestUsageRollupByDay(t *testing.T) {
+	s := newTestServer(t)
+	saved := usage
+	usage = newUsageStats()
+	t.Cleanup(func() { usage = saved })
+	h := usage.wrap("GET /achievements", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(query string) {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/achievements"+query, nil))
+	}
+
+	today := time.Now().UTC()
+	call("?format=csv")
+	call("")
+	usage.flush(s.writes, usageDay(today.AddDate(0, 0, -1)))
+	call("?appId=440")
+	usage.flush(s.writes, usageDay(today))
+	call("") // pending, counted as today
+	if err := s.writes.exec(t.Context(), `SELECT 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	var perDay = map[string]int64{}
+	rows, err := s.db.Query(`SELECT day, count FROM usage_daily WHERE dimension = 'total'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var day string
+		var n int64
+		rows.Scan(&day, &n)
+		perDay[day] = n
+	}
+	rows.Close()
+	if perDay[usageDay(today.AddDate(0, 0, -1))] != 2 || perDay[usageDay(today)] != 1 || len(perDay) != 2 {
+		t.Errorf("rolled up totals per day %v", perDay)
+	}
+
+	for _, tt := range []struct {
+		days string
+		want int64
+	}{{"1", 2}, {"2", 4}, {"", 4}} {
+		w := httptest.NewRecorder()
+		s.handleAdminUsage(w, httptest.NewRequest(http.MethodGet, "/api/admin/usage?days="+tt.days, nil))
+		var body struct {
+			Enabled bool       `json:"enabled"`
+			Routes  []usageRow `json:"routes"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || !body.Enabled || len(body.Routes) != 1 || body.Routes[0].Requests != tt.want {
+			t.Errorf("?days=%s: %s, %v; want %d requests", tt.days, w.Body, err, tt.want)
+		}
+	}
+}
+
+func TestUsageDisabled(t *testing.T) {
+	t.Setenv("DISABLE_USAGE_STATS", "1")
+	if u := usageStatsFromEnv(); u != nil {
+		t.Fatal("usage stats collected with DISABLE_USAGE_STATS=1")
+	}
+	t.Setenv("DISABLE_USAGE_STATS", "")
+	if usageStatsFromEnv() == nil {
+		t.Fatal("usage stats off by default")
+	}
+
+	s := newTestServer(t)
+	saved := usage
+	usage = nil
+	t.Cleanup(func() { usage = saved })
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var off *usageStats
+	if h := off.wrap("GET /achievements", next); h == nil {
+		t.Fatal("nil handler")
+	}
+	off.flush(s.writes, "2026-10-14")
+	w := httptest.NewRecorder()
+	s.handleAdminUsage(w, httptest.NewRequest(http.MethodGet, "/api/admin/usage", nil))
+	if w.Body.String() == "" || !json.Valid(w.Body.Bytes()) {
+		t.Fatalf("admin usage: %s", w.Body)
+	}
+	var body map[string]any
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body["enabled"] != false || body["routes"] != nil {
+		t.Errorf("admin usage while disabled: %v", body)
+	}
+	var n int
+	s.db.QueryRow(`SELECT COUNT(*) FROM usage_daily`).Scan(&n)
+	if n != 0 {
+		t.Errorf("%d usage rows stored while disabled", n)
+	}
+}
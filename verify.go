@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+// GET /admin/verify?appId= checks that the caching layers have not drifted:
+// it fetches the schema and percentages fresh from the source of the app,
+// bypassing every cache, and compares them achievement by achievement with
+// the cached copy the API serves and with the latest snapshot in the
+// history. Percentages may differ by ?tolerance= points (0.5) before they
+// count as a mismatch. A run costs two upstream calls, so each app can be
+// verified once per VERIFY_INTERVAL (5m); earlier runs get a 429.
+const (
+	defaultVerifyInterval  = 5 * time.Minute
+	defaultVerifyTolerance = 0.5
+)
+
+type verifyLimiter struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	lastRun map[AppID]time.Time
+}
+
+func newVerifyLimiter(interval time.Duration) *verifyLimiter {
+	return &verifyLimiter{interval: interval, lastRun: make(map[AppID]time.Time)}
+}
+
+// allow records a run of appID at now, or returns how long to wait for one.
+func (l *verifyLimiter) allow(appID AppID, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.lastRun[appID]; ok {
+		if wait := last.Add(l.interval).Sub(now); wait > 0 {
+			return wait, false
+		}
+	}
+	l.lastRun[appID] = now
+	return 0, true
+}
+
+// verifyComparison is the upstream list compared with one stored copy.
+type verifyComparison struct {
+	Available bool       `json:"available"`
+	Note      string     `json:"note,omitempty"`
+	At        *time.Time `json:"at,omitempty"`
+	Items     int        `json:"items"`
+	OK        bool       `json:"ok"`
+	// Missing are upstream but not in the copy, Extra the other way round.
+	Missing     []diffAchievement   `json:"missing"`
+	Extra       []diffAchievement   `json:"extra"`
+	PctMismatch []achievementMove   `json:"pctMismatch"`
+	Renamed     []achievementRename `json:"renamed"`
+}
+
+type verifyReport struct {
+	AppID         AppID             `json:"appId"`
+	Source        string            `json:"source"`
+	CheckedAt     time.Time         `json:"checkedAt"`
+	Tolerance     float64           `json:"tolerance"`
+	OK            bool              `json:"ok"`
+	UpstreamItems int               `json:"upstreamItems"`
+	Cache         *verifyComparison `json:"cache"`
+	Snapshot      *verifyComparison `json:"snapshot"`
+}
+
+func compareWithUpstream(stored, upstream []Achievement, tolerance float64) *verifyComparison {
+	d := diffAchievementLists(stored, upstream)
+	c := &verifyComparison{
+		Available: true, Items: len(stored),
+		Missing: d.Added, Extra: d.Removed, Renamed: d.Renamed,
+		PctMismatch: make([]achievementMove, 0),
+	}
+	for _, m := range d.Changed {
+		if math.Abs(m.AbsDelta) > tolerance {
+			c.PctMismatch = append(c.PctMismatch, m)
+		}
+	}
+	c.OK = len(c.Missing) == 0 && len(c.Extra) == 0 && len(c.PctMismatch) == 0 && len(c.Renamed) == 0
+	return c
+}
+
+func unavailableComparison(note string) *verifyComparison {
+	return &verifyComparison{
+		OK: true, Note: note,
+		Missing: make([]diffAchievement, 0), Extra: make([]diffAchievement, 0),
+		PctMismatch: make([]achievementMove, 0), Renamed: make([]achievementRename, 0),
+	}
+}
+
+// upstreamAchievements reads appID straight from its source, caches aside.
+func (s *Server) upstreamAchievements(r *http.Request, appID AppID) ([]Achievement, error) {
+	src := s.sourceFor(appID)
+	items, err := src.FetchSchema(r.Context(), appID, defaultLang)
+	if err != nil {
+		return nil, err
+	}
+	pcts, err := src.FetchPercentages(r.Context(), appID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].GlobalPct = pcts[items[i].APIName]
+	}
+	return items, nil
+}
+
+// cachedAchievements is the copy the API serves for appID, without
+// refreshing it: the database for the default app, the in-memory caches
+// for the others. ok is false when nothing is cached yet.
+func (s *Server) cachedAchievements(appID AppID) ([]Achievement, bool, error) {
+	if appID == defaultGlobalAppID {
+		items, err := s.readAchievementsFromDB()
+		return items, err == nil && len(items) > 0, err
+	}
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	schema, ok := s.appSchemaCache[CacheKey{Kind: cacheKindSchema, AppID: appID, Lang: defaultLang}]
+	if !ok {
+		return nil, false, nil
+	}
+	pcts := s.appGlobalPctMap[CacheKey{Kind: cacheKindGlobalPct, AppID: appID}].items
+	items := append([]Achievement(nil), schema.items...)
+	for i := range items {
+		items[i].GlobalPct = pcts[items[i].APIName]
+	}
+	return items, true, nil
+}
+
+// latestSnapshot is the list of appID as of its last percentage snapshot.
+func (s *Server) latestSnapshot(appID AppID) ([]Achievement, time.Time, bool, error) {
+	var sec sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(recorded_at) FROM global_percent_history WHERE app_id=?`, appID).Scan(&sec); err != nil {
+		return nil, time.Time{}, false, err
+	}
+	if !sec.Valid {
+		return nil, time.Time{}, false, nil
+	}
+	at := time.Unix(sec.Int64, 0).UTC()
+	_, items, err := s.readArchive(appID, at)
+	return items, at, err == nil, err
+}
+
+func (s *Server) handleAdminVerify(w http.ResponseWriter, r *http.Request) {
+	appID := defaultGlobalAppID
+	if raw := strings.TrimSpace(appIDQuery(r)); raw != "" {
+		v, err := parseAppID(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_app_id", "appId must be a positive integer")
+			return
+		}
+		appID = v
+	}
+	if !s.appAllowed(appID) {
+		writeAppNotAllowed(w, appID)
+		return
+	}
+	tolerance := defaultVerifyTolerance
+	if raw := strings.TrimSpace(r.URL.Query().Get("tolerance")); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 || v > 100 || math.IsNaN(v) {
+			writeError(w, http.StatusBadRequest, "invalid_query", "tolerance doit etre un nombre de points entre 0 et 100")
+			return
+		}
+		tolerance = v
+	}
+	if s.readOnly() {
+		writeReadOnly(w)
+		return
+	}
+	if wait, ok := s.verifies.allow(appID, time.Now()); !ok {
+		secs := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeError(w, http.StatusTooManyRequests, "verify_rate_limited", fmt.Sprintf("Verification deja lancee pour cette app, reessaie dans %ds", secs))
+		return
+	}
+
+	src := s.sourceFor(appID)
+	upstream, err := s.upstreamAchievements(r, appID)
+	if errors.Is(err, apperr.ErrUpstreamQuota) || errors.Is(err, apperr.ErrUpstreamOpen) {
+		writeAppError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("verify app %d: %s: %v", appID, src.Name(), err)
+		writeError(w, http.StatusBadGateway, "source_error", "Echec de lecture des succes du jeu depuis la source "+src.Name())
+		return
+	}
+	report := verifyReport{
+		AppID: appID, Source: src.Name(), CheckedAt: time.Now().UTC(),
+		Tolerance: tolerance, UpstreamItems: len(upstream),
+	}
+
+	cached, ok, err := s.cachedAchievements(appID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	if ok {
+		report.Cache = compareWithUpstream(cached, upstream, tolerance)
+	} else {
+		report.Cache = unavailableComparison("aucune copie en cache")
+	}
+
+	snapshot, at, ok, err := s.latestSnapshot(appID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	if ok {
+		report.Snapshot = compareWithUpstream(snapshot, upstream, tolerance)
+		report.Snapshot.At = &at
+	} else {
+		report.Snapshot = unavailableComparison("aucun instantane enregistre")
+	}
+
+	report.OK = report.Cache.OK && report.Snapshot.OK
+	if !report.OK {
+		log.Printf("verify app %d: drift found (cache ok=%t, snapshot ok=%t)", appID, report.Cache.OK, report.Snapshot.OK)
+	}
+	writeJSON(w, report)
+}
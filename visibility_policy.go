@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Visibility policies let a deployment hide achievements whatever Steam
+// says, one file per app in POLICIES_DIR (data/policies/105600.json):
+//
+//	{ "hide": ["KILL_THE_GUIDE"], "redact": ["BLOODBATH"] }
+//
+// Hidden achievements are dropped before any filter, count or statistic;
+// redacted ones stay in lists but lose their name, description and icons
+// and never match ?q=. Files are re-read when they change on disk, so a
+// policy takes effect without a restart; a file that stops parsing keeps
+// the previous policy. Responses shaped by a policy carry
+// X-Policy-Applied: 1 (policyApplied in /bootstrap), without saying which
+// achievements were touched.
+const defaultPoliciesDir = "data/policies"
+
+type appPolicy struct {
+	hide   map[string]bool
+	redact map[string]bool
+}
+
+type policyFile struct {
+	Hide   []string `json:"hide"`
+	Redact []string `json:"redact"`
+}
+
+type policyFileState struct {
+	modTime time.Time
+	size    int64
+	policy  *appPolicy // nil: file absent
+}
+
+type policyStore struct {
+	dir   string
+	mu    sync.Mutex
+	files map[AppID]policyFileState
+}
+
+// loadPolicyStore reads every policy of dir up front so a broken file stops
+// the startup (and the check command) instead of the first request.
+func loadPolicyStore(dir string) (*policyStore, error) {
+	ps := &policyStore{dir: dir, files: make(map[AppID]policyFileState)}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+		appID, err := parseAppID(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: file name must be an appId", filepath.Join(dir, e.Name()))
+		}
+		if _, err := ps.reload(appID); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+func readPolicyFile(path string) (*appPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw policyFile
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	p := &appPolicy{hide: make(map[string]bool, len(raw.Hide)), redact: make(map[string]bool, len(raw.Redact))}
+	for _, n := range raw.Hide {
+		if n = strings.TrimSpace(n); n != "" {
+			p.hide[n] = true
+		}
+	}
+	for _, n := range raw.Redact {
+		if n = strings.TrimSpace(n); n != "" && !p.hide[n] {
+			p.redact[n] = true
+		}
+	}
+	return p, nil
+}
+
+// reload re-reads the policy of appID when its file changed. Callers hold
+// no lock.
+func (ps *policyStore) reload(appID AppID) (*appPolicy, error) {
+	path := filepath.Join(ps.dir, appID.String()+".json")
+	info, statErr := os.Stat(path)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	prev, known := ps.files[appID]
+	if statErr != nil {
+		if !errors.Is(statErr, os.ErrNotExist) {
+			return prev.policy, statErr
+		}
+		if prev.policy != nil {
+			shapeChanges.tick()
+		}
+		ps.files[appID] = policyFileState{}
+		return nil, nil
+	}
+	if known && info.ModTime().Equal(prev.modTime) && info.Size() == prev.size {
+		return prev.policy, nil
+	}
+	p, err := readPolicyFile(path)
+	if err != nil {
+		// Remember this version so the error is reported once.
+		ps.files[appID] = policyFileState{modTime: info.ModTime(), size: info.Size(), policy: prev.policy}
+		return prev.policy, err
+	}
+	if known {
+		log.Printf("visibility policy of app %d reloaded (%d hidden, %d redacted)", appID, len(p.hide), len(p.redact))
+	}
+	ps.files[appID] = policyFileState{modTime: info.ModTime(), size: info.Size(), policy: p}
+	shapeChanges.tick()
+	return p, nil
+}
+
+// forApp is the current policy of appID, nil when it has none.
+func (ps *policyStore) forApp(appID AppID) *appPolicy {
+	if ps == nil {
+		return nil
+	}
+	p, err := ps.reload(appID)
+	if err != nil {
+		log.Printf("visibility policy of app %d: %v (keeping the previous one)", appID, err)
+	}
+	return p
+}
+
+// policyFor is forApp, flagging the response when a policy exists.
+func (s *Server) policyFor(w http.ResponseWriter, appID AppID) *appPolicy {
+	p := s.policies.forApp(appID)
+	flagPolicyApplied(w, p != nil)
+	return p
+}
+
+func flagPolicyApplied(w http.ResponseWriter, applied bool) {
+	if applied {
+		w.Header().Set("X-Policy-Applied", "1")
+	}
+}
+
+func (p *appPolicy) hidden(apiName string) bool {
+	return p != nil && p.hide[apiName]
+}
+
+func (p *appPolicy) redacted(apiName string) bool {
+	return p != nil && p.redact[apiName]
+}
+
+func redactAchievement(a *Achievement) {
+	a.Name, a.Description, a.Icon, a.IconGray, a.IconAlt = "", "", "", "", ""
+	a.DescriptionHidden = false
+	a.Redacted = true
+}
+
+// filter drops hidden achievements and redacts the others listed, on a
+// copy of items.
+func (p *appPolicy) filter(items []Achievement) []Achievement {
+	if p == nil {
+		return items
+	}
+	out := make([]Achievement, 0, len(items))
+	for _, a := range items {
+		if p.hide[a.APIName] {
+			continue
+		}
+		if p.redact[a.APIName] {
+			redactAchievement(&a)
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// visibleNames drops hidden apiNames, e.g. from schema change events.
+func (p *appPolicy) visibleNames(names []string) []string {
+	if p == nil {
+		return names
+	}
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		if !p.hide[n] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// applyPolicyToGames recounts the achievements of games that have hidden
+// ones, from the player's stored unlocks, and keeps the completion order.
+// It reports whether any game has a policy.
+func (s *Server) applyPolicyToGames(ctx context.Context, steamID SteamID, games []GameCompletion) (bool, error) {
+	applied, changed := false, false
+	for i := range games {
+		p := s.policies.forApp(games[i].AppID)
+		applied = applied || p != nil
+		if p == nil || len(p.hide) == 0 {
+			continue
+		}
+		items, err := s.readUserAchievementsFromDB(ctx, steamID, games[i].AppID)
+		if err != nil {
+			return applied, err
+		}
+		seen, total, unlocked := len(items), 0, 0
+		for _, a := range items {
+			if p.hide[a.APIName] {
+				continue
+			}
+			total++
+			if a.Achieved {
+				unlocked++
+			}
+		}
+		if seen == 0 {
+			continue // unlocks not synced yet
+		}
+		games[i].TotalAchievements, games[i].UnlockedAchievements = total, unlocked
+		games[i].CompletionPct = 0
+		if total > 0 {
+			games[i].CompletionPct = float64(unlocked) * 100 / float64(total)
+		}
+		changed = true
+	}
+	if changed {
+		sort.SliceStable(games, func(i, j int) bool {
+			if games[i].CompletionPct != games[j].CompletionPct {
+				return games[i].CompletionPct > games[j].CompletionPct
+			}
+			return games[i].Name < games[j].Name
+		})
+	}
+	return applied, nil
+}
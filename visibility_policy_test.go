@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// policyGame serves three achievements: SECRETKILL is hidden by the policy
+// and GORE redacted, on 105600 and 440.
+func policyGame(t *testing.T) (*Server, string) {
+	t.Helper()
+	f := fakeSteamGame(t)
+	f.handle("GetSchemaForGame", http.StatusOK, `{"game":{"availableGameStats":{"achievements":[
+		{"name":"A","displayName":"Facile","description":"d","icon":"https://x/a.png","icongray":"","hidden":0},
+		{"name":"SECRETKILL","displayName":"Massacre","description":"tuer","icon":"https://x/k.png","icongray":"","hidden":0},
+		{"name":"GORE","displayName":"Sanglant","description":"sang","icon":"https://x/g.png","icongray":"","hidden":0}]}}}`)
+	f.handle("GetGlobalAchievementPercentagesForApp", http.StatusOK, `{"achievementpercentages":{"achievements":[{"name":"A","percent":80},{"name":"SECRETKILL","percent":2.5},{"name":"GORE","percent":1}]}}`)
+	f.handle("GetPlayerAchievements", http.StatusOK, `{"playerstats":{"success":true,"achievements":[{"apiname":"A","achieved":1,"unlocktime":1700000000},{"apiname":"SECRETKILL","achieved":1,"unlocktime":1700000000},{"apiname":"GORE","achieved":0,"unlocktime":0}]}}`)
+
+	dir := t.TempDir()
+	for _, app := range []string{"105600", "440"} {
+		if err := os.WriteFile(filepath.Join(dir, app+".json"), []byte(`{"hide":["SECRETKILL"],"redact":["GORE"]}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overlay := filepath.Join(t.TempDir(), "overlay.json")
+	os.WriteFile(overlay, []byte(`{"apps":{"105600":{"A":{"tags":["boss"]},"SECRETKILL":{"tags":["secret","boss"]}}}}`), 0o644)
+
+	s := newTestServer(t)
+	var err error
+	if s.overlay, err = loadAchievementOverlay(overlay); err != nil {
+		t.Fatal(err)
+	}
+	if s.policies, err = loadPolicyStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func TestPolicyHiddenEverywhere(t *testing.T) {
+	s, _ := policyGame(t)
+	get := playerMux(t, s)
+	const player, friend = "76561197960287930", "76561197960287931"
+	for _, path := range []string{
+		"/api/achievements",
+		"/api/achievements?appId=440",
+		"/api/achievements?format=csv",
+		"/api/achievements?format=html",
+		"/api/achievements?sort=rarity&hidden=include",
+		"/api/achievements/stats",
+		"/api/achievements/stats?appId=440",
+		"/api/achievements/export?appId=440&format=csv",
+		"/api/achievements/export?appId=105600&format=csv&steamId=" + player,
+		"/api/bootstrap",
+		"/api/tags",
+		"/api/players/" + player + "/achievements?appId=440",
+		"/api/users/achievements?steamId=" + player + "&appId=105600",
+		"/api/users/games?steamId=" + player,
+		"/api/compare?steamids=" + player + "," + friend + "&appId=440",
+		"/api/achievements/GORE/history",
+	} {
+		w := get(path)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: %d %s", path, w.Code, w.Body)
+			continue
+		}
+		for _, leak := range []string{"SECRETKILL", "Massacre", "tuer", "k.png", "secret", "Sanglant", "sang", "g.png"} {
+			if strings.Contains(w.Body.String(), leak) {
+				t.Errorf("%s: %q in %s", path, leak, w.Body)
+			}
+		}
+		if w.Header().Get("X-Policy-Applied") != "1" {
+			t.Errorf("%s: X-Policy-Applied %q", path, w.Header().Get("X-Policy-Applied"))
+		}
+	}
+
+	// The hidden achievement is out of every denominator, not only the lists.
+	var stats struct {
+		Total int `json:"total"`
+		Tiers []struct {
+			Tier  string `json:"tier"`
+			Count int    `json:"count"`
+		} `json:"tiers"`
+	}
+	json.Unmarshal(get("/api/achievements/stats").Body.Bytes(), &stats)
+	sum := 0
+	for _, tier := range stats.Tiers {
+		sum += tier.Count
+	}
+	if stats.Total != 2 || sum != 2 {
+		t.Errorf("stats total %d, tiers sum %d; want 2", stats.Total, sum)
+	}
+	var games []struct {
+		Total    int `json:"totalAchievements"`
+		Unlocked int `json:"unlockedAchievements"`
+	}
+	json.Unmarshal(get("/api/users/games?steamId="+player).Body.Bytes(), &games)
+	if len(games) != 1 || games[0].Total != 2 {
+		t.Errorf("users/games = %+v, want 2 achievements", games)
+	}
+	if w := get("/api/tags"); strings.Contains(w.Body.String(), `"count": 2`) {
+		t.Errorf("tags count the hidden achievement: %s", w.Body)
+	}
+	if w := get("/api/achievements/SECRETKILL/history"); w.Code != http.StatusNotFound {
+		t.Errorf("history of the hidden achievement: %d %s", w.Code, w.Body)
+	}
+
+	// A redacted achievement is listed without its text and never matched.
+	var items []Achievement
+	json.Unmarshal(get("/api/achievements").Body.Bytes(), &items)
+	if len(items) != 2 || items[1].APIName != "GORE" || !items[1].Redacted || items[1].Name != "" || items[1].Icon != "" {
+		t.Errorf("achievements = %+v", items)
+	}
+	for _, q := range []string{"Sanglant", "sang", "Massacre", "SECRETKILL"} {
+		if w := get("/api/achievements?q=" + q); strings.TrimSpace(w.Body.String()) != "[]" {
+			t.Errorf("?q=%s matched %s", q, w.Body)
+		}
+	}
+	var boot struct {
+		PolicyApplied bool `json:"policyApplied"`
+	}
+	json.Unmarshal(get("/api/bootstrap").Body.Bytes(), &boot)
+	if !boot.PolicyApplied {
+		t.Errorf("bootstrap without policyApplied")
+	}
+}
+
+func TestPolicyWithoutFile(t *testing.T) {
+	s, _ := policyGame(t)
+	w := playerMux(t, s)("/api/achievements?appId=730")
+	if w.Header().Get("X-Policy-Applied") != "" {
+		t.Errorf("app without policy: X-Policy-Applied %q", w.Header().Get("X-Policy-Applied"))
+	}
+	if !strings.Contains(w.Body.String(), "SECRETKILL") {
+		t.Errorf("app without policy lost SECRETKILL: %s", w.Body)
+	}
+}
+
+func TestPolicyHotReload(t *testing.T) {
+	s, dir := policyGame(t)
+	get := playerMux(t, s)
+	path := filepath.Join(dir, "440.json")
+	later := time.Now().Add(time.Minute)
+
+	os.WriteFile(path, []byte(`{"hide":["A"]}`), 0o644)
+	os.Chtimes(path, later, later)
+	if w := get("/api/achievements?appId=440"); strings.Contains(w.Body.String(), `"apiName": "A"`) || !strings.Contains(w.Body.String(), "Massacre") {
+		t.Errorf("after the edit: %s", w.Body)
+	}
+
+	// A file that stops parsing keeps the previous policy.
+	os.WriteFile(path, []byte(`{"hide":`), 0o644)
+	os.Chtimes(path, later.Add(time.Minute), later.Add(time.Minute))
+	if w := get("/api/achievements?appId=440"); strings.Contains(w.Body.String(), `"apiName": "A"`) || w.Code != http.StatusOK {
+		t.Errorf("broken file: %d %s", w.Code, w.Body)
+	}
+
+	os.Remove(path)
+	if w := get("/api/achievements?appId=440"); w.Header().Get("X-Policy-Applied") != "" || !strings.Contains(w.Body.String(), "Sanglant") {
+		t.Errorf("after the removal: %s", w.Body)
+	}
+}
+
+func TestLoadPolicyStoreErrors(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"bad file name": {"terraria.json": `{}`},
+		"bad json":      {"105600.json": `{"hide":"A"}`},
+	} {
+		dir := t.TempDir()
+		for file, body := range files {
+			os.WriteFile(filepath.Join(dir, file), []byte(body), 0o644)
+		}
+		if _, err := loadPolicyStore(dir); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("notes"), 0o644)
+	os.WriteFile(filepath.Join(dir, "105600.json"), []byte(`{"hide":[" A ",""],"redact":["A","B"]}`), 0o644)
+	ps, err := loadPolicyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := ps.forApp(105600)
+	if !p.hidden("A") || p.redacted("A") || !p.redacted("B") || len(p.hide) != 1 {
+		t.Errorf("policy = %+v", p)
+	}
+	if ps, err := loadPolicyStore(filepath.Join(dir, "missing")); err != nil || ps.forApp(105600) != nil {
+		t.Errorf("missing dir: %v, %v", ps, err)
+	}
+}
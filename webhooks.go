@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outbound webhooks for external automation, listed in WEBHOOKS_FILE
+// (data/webhooks.json, optional):
+//
+//	[{ "name": "ci", "url": "https://ci.example/hook",
+//	   "events": ["refresh", "schema_changed", "new_rare_unlock"],
+//	   "appIds": [105600], "secret": "..." }]
+//
+// appIds is optional (every app when empty). With a secret, the body is
+// signed: X-Webhook-Signature: sha256=<hex HMAC-SHA256 of the body>.
+// new_rare_unlock fires when a synced player unlocks achievements owned by
+// fewer than WEBHOOK_RARE_PCT percent of players (5); hidden and redacted
+// achievements are left out.
+//
+// Each endpoint has its own queue and worker, so a slow or broken one does
+// not hold the others back. A delivery answered 408, 429 or 5xx, or that
+// failed in transit, is retried up to WEBHOOK_MAX_ATTEMPTS times in all
+// (5) with an exponential backoff (2s, 4s, ... capped at 5m); other
+// statuses, the last failure and a full queue send it to the dead letters,
+// the last webhookDeadLetterMax of which are listed by GET
+// /api/admin/webhooks. WEBHOOKS_TEST_MODE=1 sends nothing and keeps the
+// payloads in memory instead, for test runs to assert on
+// (GET/DELETE /api/admin/webhooks/captured).
+//
+// Payloads are described by GET /api/webhooks/schema. They are versioned;
+// within a version fields are only ever added.
+const (
+	defaultWebhooksFile       = "data/webhooks.json"
+	webhookPayloadVersion     = 1
+	webhookEventNewRareUnlock = "new_rare_unlock"
+	defaultWebhookRarePct     = 5.0
+	defaultWebhookAttempts    = 5
+	webhookRetryBase          = 2 * time.Second
+	webhookRetryMax           = 5 * time.Minute
+	webhookQueueSize          = 64
+	webhookDeadLetterMax      = 100
+	webhookCaptureMax         = 200
+)
+
+var webhookEventTypes = []string{eventRefresh, eventSchemaChanged, webhookEventNewRareUnlock}
+
+type webhookEndpointFile struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	AppIDs []AppID  `json:"appIds"`
+	Secret string   `json:"secret"`
+}
+
+type webhookEndpoint struct {
+	name   string
+	url    string
+	events map[string]bool
+	apps   map[AppID]bool // empty: every app
+	secret []byte
+
+	queue     chan webhookDelivery
+	delivered atomic.Int64
+	retried   atomic.Int64
+	failed    atomic.Int64
+}
+
+type webhookPayload struct {
+	Version int       `json:"version"`
+	ID      string    `json:"id"`
+	Event   string    `json:"event"`
+	AppID   AppID     `json:"appId"`
+	At      time.Time `json:"at"`
+	Data    any       `json:"data"`
+}
+
+type webhookRefreshData struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+type webhookSchemaChangedData struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type webhookRareUnlockData struct {
+	SteamID SteamID             `json:"steamId"`
+	Unlocks []webhookRareUnlock `json:"unlocks"`
+}
+
+type webhookRareUnlock struct {
+	APIName   string  `json:"apiName"`
+	Name      string  `json:"name"`
+	GlobalPct float64 `json:"globalPct"`
+}
+
+// webhookDelivery is one payload for one endpoint, as queued, captured or
+// dead-lettered.
+type webhookDelivery struct {
+	ID        string          `json:"id"`
+	Endpoint  string          `json:"endpoint"`
+	Event     string          `json:"event"`
+	AppID     AppID           `json:"appId"`
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature,omitempty"`
+	Attempts  int             `json:"attempts,omitempty"`
+	LastError string          `json:"lastError,omitempty"`
+	At        time.Time       `json:"at"`
+}
+
+type webhookDispatcher struct {
+	endpoints []*webhookEndpoint
+	rarePct   float64
+	attempts  int
+	capture   bool
+	client    *http.Client
+	now       func() time.Time
+
+	mu       sync.Mutex
+	dead     []webhookDelivery // oldest first
+	captured []webhookDelivery
+}
+
+// loadWebhookEndpoints reads path; a missing file means no webhooks, a
+// broken one stops the startup.
+func loadWebhookEndpoints(path string) ([]*webhookEndpoint, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw []webhookEndpointFile
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	known := make(map[string]bool, len(webhookEventTypes))
+	for _, e := range webhookEventTypes {
+		known[e] = true
+	}
+	out := make([]*webhookEndpoint, 0, len(raw))
+	names := make(map[string]bool, len(raw))
+	for i, f := range raw {
+		u, err := neturl.Parse(strings.TrimSpace(f.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s: entry %d: url must be an absolute http(s) URL", path, i)
+		}
+		ep := &webhookEndpoint{
+			name:   strings.TrimSpace(f.Name),
+			url:    u.String(),
+			events: make(map[string]bool, len(f.Events)),
+			apps:   make(map[AppID]bool, len(f.AppIDs)),
+			queue:  make(chan webhookDelivery, webhookQueueSize),
+		}
+		if ep.name == "" {
+			ep.name = u.Host
+		}
+		if names[ep.name] {
+			return nil, fmt.Errorf("%s: entry %d: duplicate name %q", path, i, ep.name)
+		}
+		names[ep.name] = true
+		for _, e := range f.Events {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if !known[e] {
+				return nil, fmt.Errorf("%s: entry %q: unknown event %q (known: %s)", path, ep.name, e, strings.Join(webhookEventTypes, ", "))
+			}
+			ep.events[e] = true
+		}
+		if len(ep.events) == 0 {
+			return nil, fmt.Errorf("%s: entry %q: no events", path, ep.name)
+		}
+		for _, id := range f.AppIDs {
+			ep.apps[id] = true
+		}
+		if f.Secret != "" {
+			ep.secret = []byte(f.Secret)
+		}
+		out = append(out, ep)
+	}
+	return out, nil
+}
+
+func newWebhookDispatcher(endpoints []*webhookEndpoint) *webhookDispatcher {
+	d := &webhookDispatcher{
+		endpoints: endpoints,
+		rarePct:   defaultWebhookRarePct,
+		attempts:  getenvInt("WEBHOOK_MAX_ATTEMPTS", defaultWebhookAttempts),
+		capture:   getenv("WEBHOOKS_TEST_MODE", "") == "1",
+		client:    &http.Client{Timeout: 10 * time.Second},
+		now:       time.Now,
+	}
+	if v := strings.TrimSpace(os.Getenv("WEBHOOK_RARE_PCT")); v != "" {
+		pct, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Printf("invalid WEBHOOK_RARE_PCT=%q, using default %g", v, defaultWebhookRarePct)
+		} else {
+			d.rarePct = pct
+		}
+	}
+	if d.attempts < 1 {
+		d.attempts = 1
+	}
+	return d
+}
+
+func (ep *webhookEndpoint) wants(event string, appID AppID) bool {
+	return ep.events[event] && (len(ep.apps) == 0 || ep.apps[appID])
+}
+
+func (ep *webhookEndpoint) sign(body []byte) string {
+	if ep.secret == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, ep.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookID() string {
+	return fmt.Sprintf("%016x%08x", rand.Uint64(), rand.Uint32())
+}
+
+// emit queues event for every endpoint that wants it. It never blocks.
+func (d *webhookDispatcher) emit(event string, appID AppID, data any) {
+	if d == nil {
+		return
+	}
+	var body []byte
+	var id string
+	now := d.now().UTC()
+	for _, ep := range d.endpoints {
+		if !ep.wants(event, appID) {
+			continue
+		}
+		if body == nil {
+			id = newWebhookID()
+			var err error
+			body, err = json.Marshal(webhookPayload{Version: webhookPayloadVersion, ID: id, Event: event, AppID: appID, At: now, Data: data})
+			if err != nil {
+				log.Printf("webhook %s app %d: %v", event, appID, err)
+				return
+			}
+		}
+		dl := webhookDelivery{ID: id, Endpoint: ep.name, Event: event, AppID: appID, Payload: body, At: now}
+		if d.capture {
+			dl.Signature = ep.sign(body)
+			d.mu.Lock()
+			d.captured = appendCapped(d.captured, dl, webhookCaptureMax)
+			d.mu.Unlock()
+			continue
+		}
+		select {
+		case ep.queue <- dl:
+		default:
+			d.deadLetter(ep, dl, errors.New("queue full"))
+		}
+	}
+}
+
+func appendCapped(list []webhookDelivery, dl webhookDelivery, max int) []webhookDelivery {
+	list = append(list, dl)
+	if len(list) > max {
+		list = append(list[:0:0], list[len(list)-max:]...)
+	}
+	return list
+}
+
+// run delivers queued payloads until ctx is done.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, ep := range d.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.worker(ctx, ep)
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *webhookDispatcher) worker(ctx context.Context, ep *webhookEndpoint) {
+	for {
+		select {
+		case <-ctx.Done():
+			if n := len(ep.queue); n > 0 {
+				log.Printf("webhook %s: %d deliveries dropped at shutdown", ep.name, n)
+			}
+			return
+		case dl := <-ep.queue:
+			d.deliver(ctx, ep, dl)
+		}
+	}
+}
+
+func (d *webhookDispatcher) deliver(ctx context.Context, ep *webhookEndpoint, dl webhookDelivery) {
+	for attempt := 1; ; attempt++ {
+		dl.Attempts = attempt
+		retry, err := d.post(ctx, ep, dl)
+		if err == nil {
+			ep.delivered.Add(1)
+			return
+		}
+		if !retry || attempt >= d.attempts {
+			d.deadLetter(ep, dl, err)
+			return
+		}
+		delay := min(webhookRetryBase<<(attempt-1), webhookRetryMax)
+		delay = delay/2 + rand.N(delay)
+		log.Printf("webhook %s: %s %s attempt %d: %v (retrying in %s)", ep.name, dl.Event, dl.ID, attempt, err, delay.Round(time.Millisecond))
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+			ep.retried.Add(1)
+		case <-ctx.Done():
+			t.Stop()
+			log.Printf("webhook %s: %s %s dropped at shutdown", ep.name, dl.Event, dl.ID)
+			return
+		}
+	}
+}
+
+// post makes one attempt and tells whether a failure is worth another.
+func (d *webhookDispatcher) post(ctx context.Context, ep *webhookEndpoint, dl webhookDelivery) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.url, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", dl.Event)
+	req.Header.Set("X-Webhook-Id", dl.ID)
+	if sig := ep.sign(dl.Payload); sig != "" {
+		req.Header.Set("X-Webhook-Signature", sig)
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		// The URL may carry a token; keep it out of logs and dead letters.
+		var uerr *neturl.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return true, err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return false, nil
+	}
+	s := res.StatusCode
+	return s == http.StatusRequestTimeout || s == http.StatusTooManyRequests || s >= 500, fmt.Errorf("status %d", s)
+}
+
+func (d *webhookDispatcher) deadLetter(ep *webhookEndpoint, dl webhookDelivery, err error) {
+	ep.failed.Add(1)
+	dl.LastError, dl.At = err.Error(), d.now().UTC()
+	log.Printf("webhook %s: %s %s dead-lettered after %d attempt(s): %v", ep.name, dl.Event, dl.ID, dl.Attempts, err)
+	d.mu.Lock()
+	d.dead = appendCapped(d.dead, dl, webhookDeadLetterMax)
+	d.mu.Unlock()
+}
+
+// emitRareUnlocks turns the new unlocks of a player sync into one
+// new_rare_unlock per app.
+func (s *Server) emitRareUnlocks(steamID SteamID, unlocks []UnlockEvent) {
+	d := s.webhooks
+	if d == nil || len(d.endpoints) == 0 {
+		return
+	}
+	byApp := make(map[AppID][]webhookRareUnlock)
+	for _, u := range unlocks {
+		// 0 means the percentage was not known when syncing.
+		if u.GlobalPct <= 0 || u.GlobalPct >= d.rarePct {
+			continue
+		}
+		policy := s.policies.forApp(u.AppID)
+		if policy.hidden(u.APIName) || policy.redacted(u.APIName) {
+			continue
+		}
+		byApp[u.AppID] = append(byApp[u.AppID], webhookRareUnlock{APIName: u.APIName, Name: u.Name, GlobalPct: u.GlobalPct})
+	}
+	for appID, rare := range byApp {
+		d.emit(webhookEventNewRareUnlock, appID, webhookRareUnlockData{SteamID: steamID, Unlocks: rare})
+	}
+}
+
+type webhookEndpointStats struct {
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	AppIDs    []AppID  `json:"appIds,omitempty"`
+	Signed    bool     `json:"signed"`
+	Queued    int      `json:"queued"`
+	Delivered int64    `json:"delivered"`
+	Retried   int64    `json:"retried"`
+	Failed    int64    `json:"failed"`
+}
+
+func (s *Server) handleAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	d := s.webhooks
+	out := struct {
+		TestMode    bool                   `json:"testMode"`
+		RarePct     float64                `json:"rarePct"`
+		MaxAttempts int                    `json:"maxAttempts"`
+		Endpoints   []webhookEndpointStats `json:"endpoints"`
+		DeadLetters []webhookDelivery      `json:"deadLetters"`
+	}{TestMode: d.capture, RarePct: d.rarePct, MaxAttempts: d.attempts, Endpoints: make([]webhookEndpointStats, 0, len(d.endpoints))}
+	for _, ep := range d.endpoints {
+		st := webhookEndpointStats{
+			Name: ep.name, URL: safeUpstreamURL(ep.url), Signed: ep.secret != nil, Queued: len(ep.queue),
+			Delivered: ep.delivered.Load(), Retried: ep.retried.Load(), Failed: ep.failed.Load(),
+		}
+		for e := range ep.events {
+			st.Events = append(st.Events, e)
+		}
+		sort.Strings(st.Events)
+		for id := range ep.apps {
+			st.AppIDs = append(st.AppIDs, id)
+		}
+		sort.Slice(st.AppIDs, func(i, j int) bool { return st.AppIDs[i] < st.AppIDs[j] })
+		out.Endpoints = append(out.Endpoints, st)
+	}
+	d.mu.Lock()
+	out.DeadLetters = newestFirst(d.dead)
+	d.mu.Unlock()
+	writeJSON(w, out)
+}
+
+// handleAdminWebhooksCaptured lists (GET) or forgets (DELETE) the payloads
+// kept by the test mode.
+func (s *Server) handleAdminWebhooksCaptured(w http.ResponseWriter, r *http.Request) {
+	d := s.webhooks
+	if !d.capture {
+		writeError(w, http.StatusConflict, "webhooks_not_in_test_mode", "Capture desactivee: demarrer avec WEBHOOKS_TEST_MODE=1")
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r.Method == http.MethodDelete {
+		n := len(d.captured)
+		d.captured = nil
+		writeJSON(w, map[string]int{"cleared": n})
+		return
+	}
+	writeJSON(w, map[string]any{"items": append(make([]webhookDelivery, 0, len(d.captured)), d.captured...)})
+}
+
+func newestFirst(list []webhookDelivery) []webhookDelivery {
+	out := make([]webhookDelivery, len(list))
+	for i, dl := range list {
+		out[len(list)-1-i] = dl
+	}
+	return out
+}
+
+// webhookSchema is the JSON Schema of the payloads of version
+// webhookPayloadVersion.
+func webhookSchema() map[string]any {
+	str := map[string]any{"type": "string"}
+	dateTime := map[string]any{"type": "string", "format": "date-time"}
+	names := map[string]any{"type": "array", "items": str}
+	data := func(event string, schema map[string]any) map[string]any {
+		return map[string]any{
+			"if":   map[string]any{"properties": map[string]any{"event": map[string]any{"const": event}}},
+			"then": map[string]any{"properties": map[string]any{"data": schema}},
+		}
+	}
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "Yboost webhook payload",
+		"description": "Body POSTed to webhook endpoints. Signed bodies carry X-Webhook-Signature: sha256=<hex HMAC-SHA256 of the body>. Within a version fields are only added.",
+		"type":        "object",
+		"required":    []string{"version", "id", "event", "appId", "at", "data"},
+		"properties": map[string]any{
+			"version": map[string]any{"const": webhookPayloadVersion},
+			"id":      map[string]any{"type": "string", "description": "Same for every endpoint receiving the event, to deduplicate retries"},
+			"event":   map[string]any{"enum": webhookEventTypes},
+			"appId":   map[string]any{"type": "integer"},
+			"at":      dateTime,
+			"data":    map[string]any{"type": "object"},
+		},
+		"allOf": []any{
+			data(eventRefresh, map[string]any{
+				"type": "object", "required": []string{"fetchedAt"},
+				"properties": map[string]any{"fetchedAt": dateTime},
+			}),
+			data(eventSchemaChanged, map[string]any{
+				"type": "object", "required": []string{"added", "removed"},
+				"properties": map[string]any{"added": names, "removed": names},
+			}),
+			data(webhookEventNewRareUnlock, map[string]any{
+				"type": "object", "required": []string{"steamId", "unlocks"},
+				"properties": map[string]any{
+					"steamId": map[string]any{"type": "string", "description": "Pseudonymized like everywhere else in the API"},
+					"unlocks": map[string]any{"type": "array", "items": map[string]any{
+						"type": "object", "required": []string{"apiName", "name", "globalPct"},
+						"properties": map[string]any{"apiName": str, "name": str, "globalPct": map[string]any{"type": "number"}},
+					}},
+				},
+			}),
+		},
+	}
+}
+
+var schemaEncoder = responseEncoder{contentType: "application/schema+json", encode: jsonEncoder.encode}
+
+func (s *Server) handleWebhookSchema(w http.ResponseWriter, r *http.Request) {
+	writeEncoded(w, http.StatusOK, schemaEncoder, webhookSchema())
+}
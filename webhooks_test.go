@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func webhookEndpoints(t *testing.T, config string) []*webhookEndpoint {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	os.WriteFile(path, []byte(config), 0o644)
+	eps, err := loadWebhookEndpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eps
+}
+
+func capturingWebhooks(t *testing.T, config string) *webhookDispatcher {
+	t.Helper()
+	t.Setenv("WEBHOOKS_TEST_MODE", "1")
+	return newWebhookDispatcher(webhookEndpoints(t, config))
+}
+
+func capturedOf(d *webhookDispatcher) []webhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]webhookDelivery(nil), d.captured...)
+}
+
+func TestLoadWebhookEndpoints(t *testing.T) {
+	eps := webhookEndpoints(t, `[
+		{"name":"ci","url":"https://ci.example/hook?token=x","events":["refresh"," Schema_Changed "],"appIds":[105600,"440"],"secret":"s"},
+		{"url":"http://bot.example/in","events":["new_rare_unlock"]}]`)
+	if len(eps) != 2 || eps[0].name != "ci" || !eps[0].events[eventSchemaChanged] || !eps[0].apps[440] || eps[0].secret == nil {
+		t.Fatalf("endpoints %+v", eps)
+	}
+	if eps[1].name != "bot.example" || len(eps[1].apps) != 0 || eps[1].secret != nil {
+		t.Errorf("defaults %+v", eps[1])
+	}
+	if !eps[0].wants(eventRefresh, 440) || eps[0].wants(eventRefresh, 730) || eps[0].wants(webhookEventNewRareUnlock, 440) || !eps[1].wants(webhookEventNewRareUnlock, 730) {
+		t.Errorf("wants filters wrong")
+	}
+
+	for name, config := range map[string]string{
+		"json":           `{"url":"https://a.example"}`,
+		"relative url":   `[{"url":"/hook","events":["refresh"]}]`,
+		"ftp url":        `[{"url":"ftp://a.example","events":["refresh"]}]`,
+		"no event":       `[{"url":"https://a.example","events":[]}]`,
+		"unknown event":  `[{"url":"https://a.example","events":["deploy"]}]`,
+		"duplicate name": `[{"url":"https://a.example/1","events":["refresh"]},{"url":"https://a.example/2","events":["refresh"]}]`,
+		"bad app id":     `[{"url":"https://a.example","events":["refresh"],"appIds":[0]}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "webhooks.json")
+		os.WriteFile(path, []byte(config), 0o644)
+		if _, err := loadWebhookEndpoints(path); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if eps, err := loadWebhookEndpoints(filepath.Join(t.TempDir(), "missing.json")); err != nil || eps != nil {
+		t.Errorf("missing file: %v, %v", eps, err)
+	}
+}
+
+// webhookReceiver answers each POST with the next status of statuses (the
+// last one repeats) and records the requests.
+type webhookReceiver struct {
+	url string
+
+	mu       sync.Mutex
+	statuses []int
+	got      []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+	rc := &webhookReceiver{statuses: statuses}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		status := rc.statuses[min(len(rc.got), len(rc.statuses)-1)]
+		rc.got, rc.bodies = append(rc.got, r), append(rc.bodies, body)
+		rc.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	rc.url = srv.URL
+	return rc
+}
+
+func (rc *webhookReceiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.got)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	flaky := newWebhookReceiver(t, http.StatusServiceUnavailable, http.StatusNoContent)
+	refused := newWebhookReceiver(t, http.StatusBadRequest)
+	down := newWebhookReceiver(t, http.StatusBadGateway)
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "2")
+	d := newWebhookDispatcher(webhookEndpoints(t, `[
+		{"name":"flaky","url":"`+flaky.url+`","events":["refresh"],"appIds":[105600],"secret":"s3cret"},
+		{"name":"refused","url":"`+refused.url+`","events":["refresh"]},
+		{"name":"down","url":"`+down.url+`","events":["refresh"]}]`))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { d.run(ctx); close(done) }()
+	defer func() { cancel(); <-done }()
+
+	d.emit(eventRefresh, 440, webhookRefreshData{})                // not for flaky
+	d.emit(eventSchemaChanged, 105600, webhookSchemaChangedData{}) // nobody wants it
+	d.emit(eventRefresh, 105600, webhookRefreshData{FetchedAt: time.Unix(1700000000, 0).UTC()})
+	deadline := time.Now().Add(10 * time.Second)
+	for (d.endpoints[0].delivered.Load() < 1 || d.endpoints[2].failed.Load() < 2) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// 503 is retried, and both attempts carry the same id and signature.
+	if flaky.count() != 2 {
+		t.Fatalf("flaky got %d POSTs, want 2", flaky.count())
+	}
+	first, retry := flaky.got[0], flaky.got[1]
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(flaky.bodies[1])
+	if sig := "sha256=" + hex.EncodeToString(mac.Sum(nil)); retry.Header.Get("X-Webhook-Signature") != sig {
+		t.Errorf("signature %q, want %q", retry.Header.Get("X-Webhook-Signature"), sig)
+	}
+	if first.Header.Get("X-Webhook-Id") == "" || first.Header.Get("X-Webhook-Id") != retry.Header.Get("X-Webhook-Id") || retry.Header.Get("X-Webhook-Event") != eventRefresh {
+		t.Errorf("headers %v / %v", first.Header, retry.Header)
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(flaky.bodies[1], &p); err != nil || p.Version != webhookPayloadVersion || p.AppID != 105600 || p.Event != eventRefresh {
+		t.Errorf("payload %s: %v", flaky.bodies[1], err)
+	}
+	if ep := d.endpoints[0]; ep.delivered.Load() != 1 || ep.retried.Load() != 1 || ep.failed.Load() != 0 {
+		t.Errorf("flaky counters %d %d %d", ep.delivered.Load(), ep.retried.Load(), ep.failed.Load())
+	}
+
+	// 400 is not retried; 502 is until WEBHOOK_MAX_ATTEMPTS.
+	if refused.count() != 2 || down.count() != 4 {
+		t.Errorf("refused got %d POSTs (want 2, no retry), down %d (want 4)", refused.count(), down.count())
+	}
+	s := &Server{webhooks: d}
+	w := httptest.NewRecorder()
+	s.handleAdminWebhooks(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/webhooks", nil))
+	var admin struct {
+		MaxAttempts int                    `json:"maxAttempts"`
+		Endpoints   []webhookEndpointStats `json:"endpoints"`
+		DeadLetters []webhookDelivery      `json:"deadLetters"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &admin); err != nil {
+		t.Fatal(err)
+	}
+	if admin.MaxAttempts != 2 || len(admin.Endpoints) != 3 || !admin.Endpoints[0].Signed || admin.Endpoints[2].Failed != 2 {
+		t.Errorf("admin endpoints %+v", admin)
+	}
+	errs := map[string][]string{}
+	for _, dl := range admin.DeadLetters {
+		errs[dl.Endpoint] = append(errs[dl.Endpoint], dl.LastError)
+	}
+	if len(admin.DeadLetters) != 4 || len(errs["refused"]) != 2 || errs["down"][0] != "status 502" || errs["flaky"] != nil {
+		t.Errorf("dead letters %+v", admin.DeadLetters)
+	}
+	for _, dl := range admin.DeadLetters {
+		if dl.Endpoint == "down" && dl.Attempts != 2 {
+			t.Errorf("down dead-lettered after %d attempts", dl.Attempts)
+		}
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	rc := newWebhookReceiver(t, http.StatusOK)
+	d := newWebhookDispatcher(webhookEndpoints(t, `[{"name":"slow","url":"`+rc.url+`","events":["refresh"]}]`))
+	// No worker: the queue only fills.
+	for range webhookQueueSize + 3 {
+		d.emit(eventRefresh, 105600, webhookRefreshData{})
+	}
+	if n := len(d.endpoints[0].queue); n != webhookQueueSize {
+		t.Errorf("queued %d", n)
+	}
+	if len(d.dead) != 3 || d.dead[0].LastError != "queue full" || d.endpoints[0].failed.Load() != 3 {
+		t.Errorf("dead letters %+v", d.dead)
+	}
+}
+
+func TestWebhookTestMode(t *testing.T) {
+	var posts atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { posts.Add(1) }))
+	defer srv.Close()
+	d := capturingWebhooks(t, `[
+		{"name":"a","url":"`+srv.URL+`","events":["refresh","new_rare_unlock"],"secret":"k"},
+		{"name":"b","url":"`+srv.URL+`","events":["refresh"],"appIds":[440]}]`)
+
+	fakeSteamGame(t)
+	s := newTestServer(t)
+	s.webhooks = d
+	if err := s.syncFromSteam(context.Background(), "french"); err != nil {
+		t.Fatal(err)
+	}
+	got := capturedOf(d)
+	if len(got) != 1 || got[0].Endpoint != "a" || got[0].Event != eventRefresh || got[0].AppID != 105600 || !strings.HasPrefix(got[0].Signature, "sha256=") {
+		t.Fatalf("captured after a sync %+v", got)
+	}
+	var p struct {
+		Data webhookRefreshData `json:"data"`
+	}
+	json.Unmarshal(got[0].Payload, &p)
+	if last, _ := s.lastSyncAt(context.Background()); !p.Data.FetchedAt.Equal(last) {
+		t.Errorf("fetchedAt %v, last sync %v", p.Data.FetchedAt, last)
+	}
+
+	call := notesMux(t, s)
+	var list struct {
+		Items []webhookDelivery `json:"items"`
+	}
+	json.Unmarshal(call("GET", "/api/v1/admin/webhooks/captured", "").Body.Bytes(), &list)
+	if len(list.Items) != 1 || list.Items[0].ID != got[0].ID {
+		t.Errorf("GET captured %+v", list)
+	}
+	if w := call("DELETE", "/api/v1/admin/webhooks/captured", ""); !strings.Contains(w.Body.String(), `"cleared": 1`) || len(capturedOf(d)) != 0 {
+		t.Errorf("DELETE captured: %s", w.Body)
+	}
+	if posts.Load() != 0 {
+		t.Errorf("the test mode sent %d POSTs", posts.Load())
+	}
+
+	s.webhooks = newWebhookDispatcher(nil)
+	s.webhooks.capture = false
+	if w := call("GET", "/api/v1/admin/webhooks/captured", ""); w.Code != http.StatusConflict {
+		t.Errorf("captured outside the test mode: %d", w.Code)
+	}
+}
+
+func TestWebhookRareUnlocks(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "105600.json"), []byte(`{"hide":["SECRET"],"redact":["GORE"]}`), 0o644)
+	s := &Server{webhooks: capturingWebhooks(t, `[{"name":"a","url":"https://a.example","events":["new_rare_unlock"]}]`)}
+	s.policies, _ = loadPolicyStore(dir)
+	s.emitRareUnlocks(76561197960287930, []UnlockEvent{
+		{AppID: 105600, APIName: "RARE", Name: "Rare", GlobalPct: 1.5},
+		{AppID: 105600, APIName: "COMMON", Name: "Commun", GlobalPct: 40},
+		{AppID: 105600, APIName: "UNKNOWN", Name: "Inconnu"},
+		{AppID: 105600, APIName: "SECRET", Name: "Secret", GlobalPct: 0.1},
+		{AppID: 105600, APIName: "GORE", Name: "Gore", GlobalPct: 0.1},
+		{AppID: 440, APIName: "HAT", Name: "Chapeau", GlobalPct: 4.9},
+	})
+	got := capturedOf(s.webhooks)
+	byApp := map[AppID][]string{}
+	for _, dl := range got {
+		var p struct {
+			Data webhookRareUnlockData `json:"data"`
+		}
+		json.Unmarshal(dl.Payload, &p)
+		if p.Data.SteamID != 76561197960287930 {
+			t.Errorf("steamId %v", p.Data.SteamID)
+		}
+		for _, u := range p.Data.Unlocks {
+			byApp[dl.AppID] = append(byApp[dl.AppID], u.APIName)
+		}
+	}
+	if len(got) != 2 || !reflect.DeepEqual(byApp, map[AppID][]string{105600: {"RARE"}, 440: {"HAT"}}) {
+		t.Errorf("rare unlocks %v", byApp)
+	}
+}
+
+// The schema lists every field the payloads send, so a field removed or
+// renamed by mistake breaks this test rather than a consumer.
+func TestWebhookSchemaMatchesPayloads(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&Server{}).handleWebhookSchema(w, httptest.NewRequest(http.MethodGet, "/api/v1/webhooks/schema", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/schema+json") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	var schema struct {
+		Required []string `json:"required"`
+		AllOf    []struct {
+			If struct {
+				Properties struct {
+					Event struct {
+						Const string `json:"const"`
+					} `json:"event"`
+				} `json:"properties"`
+			} `json:"if"`
+			Then struct {
+				Properties struct {
+					Data struct {
+						Required []string `json:"required"`
+					} `json:"data"`
+				} `json:"properties"`
+			} `json:"then"`
+		} `json:"allOf"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	keys := func(v any) []string {
+		b, _ := json.Marshal(v)
+		var m map[string]any
+		json.Unmarshal(b, &m)
+		out := make([]string, 0, len(m))
+		for k := range m {
+			out = append(out, k)
+		}
+		sort.Strings(out)
+		return out
+	}
+	sorted := func(s []string) []string { s = append([]string(nil), s...); sort.Strings(s); return s }
+	if got, want := sorted(schema.Required), keys(webhookPayload{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("payload required %v, sent %v", got, want)
+	}
+	data := map[string]any{
+		eventRefresh:              webhookRefreshData{},
+		eventSchemaChanged:        webhookSchemaChangedData{},
+		webhookEventNewRareUnlock: webhookRareUnlockData{},
+	}
+	if len(schema.AllOf) != len(webhookEventTypes) {
+		t.Errorf("%d event schemas for %d events", len(schema.AllOf), len(webhookEventTypes))
+	}
+	for _, s := range schema.AllOf {
+		event := s.If.Properties.Event.Const
+		if got, want := sorted(s.Then.Properties.Data.Required), keys(data[event]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s data required %v, sent %v", event, got, want)
+		}
+	}
+}
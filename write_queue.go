@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+const (
+	defaultWriteQueueSize = 1024
+	writeBatchWindow      = 100 * time.Millisecond
+	writeBatchMax         = 256
+)
+
+type writeOp struct {
+	query string
+	args  []any
+	fn    func(tx *sql.Tx) error // instead of query, see execTx
+	done  chan error             // nil for fire-and-forget writes
+}
+
+// writeQueue funnels the writes through a single goroutine that groups
+// them into one transaction per batch window, so handlers do not wait on
+// the disk. Writes that must be durable before answering use exec, or
+// execTx for several statements (a player sync).
+type writeQueue struct {
+	db *sql.DB
+	ch chan writeOp
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	written   atomic.Int64
+	failed    atomic.Int64
+	batches   atomic.Int64
+	queueFull atomic.Int64
+	lastBatch atomic.Int64 // duration in microseconds
+}
+
+type writeQueueStats struct {
+	Queued      int   `json:"queued"`
+	Capacity    int   `json:"capacity"`
+	Written     int64 `json:"written"`
+	Failed      int64 `json:"failed"`
+	Batches     int64 `json:"batches"`
+	QueueFull   int64 `json:"queueFull"`
+	LastBatchUs int64 `json:"lastBatchUs"`
+}
+
+func newWriteQueue(db *sql.DB, size int) *writeQueue {
+	if size <= 0 {
+		size = defaultWriteQueueSize
+	}
+	q := &writeQueue{db: db, ch: make(chan writeOp, size)}
+	q.wg.Add(1)
+	go q.run()
+	return q
+}
+
+// enqueue schedules a write without waiting for it. When the queue is full
+// the caller blocks until there is room; this is counted in queueFull.
+func (q *writeQueue) enqueue(query string, args ...any) {
+	if err := q.send(context.Background(), writeOp{query: query, args: args}); err != nil {
+		log.Printf("write queue: %v, dropped %q", err, query)
+	}
+}
+
+// exec queues a write and returns once its batch is committed.
+func (q *writeQueue) exec(ctx context.Context, query string, args ...any) error {
+	return q.wait(ctx, writeOp{query: query, args: args, done: make(chan error, 1)})
+}
+
+// execTx queues fn and returns once its batch is committed. fn runs in a
+// savepoint of the batch transaction: when it fails, none of its writes
+// are kept and the rest of the batch is.
+func (q *writeQueue) execTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return q.wait(ctx, writeOp{fn: fn, done: make(chan error, 1)})
+}
+
+func (q *writeQueue) wait(ctx context.Context, op writeOp) error {
+	if err := q.send(ctx, op); err != nil {
+		return err
+	}
+	select {
+	case err := <-op.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *writeQueue) send(ctx context.Context, op writeOp) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return apperr.ErrWriteQueueClosed
+	}
+	select {
+	case q.ch <- op:
+		return nil
+	default:
+	}
+	q.queueFull.Add(1)
+	select {
+	case q.ch <- op:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting writes and returns once every queued write is
+// committed.
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+func (q *writeQueue) run() {
+	defer q.wg.Done()
+	for first := range q.ch {
+		batch := []writeOp{first}
+		timer := time.NewTimer(writeBatchWindow)
+	collect:
+		for len(batch) < writeBatchMax {
+			select {
+			case op, ok := <-q.ch:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, op)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		q.flush(batch)
+	}
+}
+
+func (q *writeQueue) flush(batch []writeOp) {
+	start := time.Now()
+	errs := make([]error, len(batch))
+	tx, err := q.db.Begin()
+	if err == nil {
+		for i, op := range batch {
+			if op.fn != nil {
+				errs[i] = runInSavepoint(tx, op.fn)
+			} else if _, execErr := tx.Exec(op.query, op.args...); execErr != nil {
+				errs[i] = execErr
+			}
+		}
+		err = tx.Commit()
+	}
+
+	for i, op := range batch {
+		if err != nil {
+			errs[i] = err
+		}
+		if errs[i] != nil {
+			q.failed.Add(1)
+			if op.done == nil {
+				log.Printf("write queue: %v", errs[i])
+			}
+		} else {
+			q.written.Add(1)
+		}
+		if op.done != nil {
+			op.done <- errs[i]
+		}
+	}
+	q.batches.Add(1)
+	q.lastBatch.Store(time.Since(start).Microseconds())
+}
+
+func runInSavepoint(tx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if _, err := tx.Exec(`SAVEPOINT write_op`); err != nil {
+		return err
+	}
+	err := fn(tx)
+	if err != nil {
+		if _, rbErr := tx.Exec(`ROLLBACK TO write_op`); rbErr != nil {
+			return fmt.Errorf("%w (rollback: %v)", err, rbErr)
+		}
+	}
+	if _, relErr := tx.Exec(`RELEASE write_op`); relErr != nil && err == nil {
+		err = relErr
+	}
+	return err
+}
+
+func (q *writeQueue) stats() writeQueueStats {
+	return writeQueueStats{
+		Queued:      len(q.ch),
+		Capacity:    cap(q.ch),
+		Written:     q.written.Load(),
+		Failed:      q.failed.Load(),
+		Batches:     q.batches.Load(),
+		QueueFull:   q.queueFull.Load(),
+		LastBatchUs: q.lastBatch.Load(),
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"yboost-projet-25-26/internal/apperr"
+)
+
+func testWriteQueue(t *testing.T, size int) (*writeQueue, *sql.DB) {
+	t.Helper()
+	db, err := openDB(filepath.Join(t.TempDir(), "writes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE kv(k TEXT PRIMARY KEY, v TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	q := newWriteQueue(db, size)
+	t.Cleanup(q.close)
+	return q, db
+}
+
+func countKV(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM kv`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// close returns once every accepted write is committed, queued ones and
+// player syncs alike; later writes are refused.
+func TestWriteQueueShutdownFlush(t *testing.T) {
+	q, db := testWriteQueue(t, 64)
+	for i := 0; i < 50; i++ {
+		q.enqueue(`INSERT INTO kv(k, v) VALUES(?, 'x')`, fmt.Sprintf("k%d", i))
+	}
+	playerSync := writeOp{fn: func(tx *sql.Tx) error {
+		for _, k := range []string{"sync-a", "sync-b"} {
+			if _, err := tx.Exec(`INSERT INTO kv(k, v) VALUES(?, 'y')`, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, done: make(chan error, 1)}
+	if err := q.send(context.Background(), playerSync); err != nil {
+		t.Fatal(err)
+	}
+	q.close()
+	if err := <-playerSync.done; err != nil {
+		t.Fatalf("player sync: %v", err)
+	}
+	if n := countKV(t, db); n != 52 {
+		t.Fatalf("%d rows after close, want 52", n)
+	}
+	if err := q.exec(context.Background(), `INSERT INTO kv(k, v) VALUES('late', 'x')`); !errors.Is(err, apperr.ErrWriteQueueClosed) {
+		t.Errorf("write after close: %v", err)
+	}
+	q.enqueue(`INSERT INTO kv(k, v) VALUES('late', 'x')`) // logged and dropped
+	if n := countKV(t, db); n != 52 {
+		t.Errorf("write after close stored")
+	}
+}
+
+// A full queue blocks its callers, counting it, until there is room or
+// their context ends; nothing accepted is lost.
+func TestWriteQueueFull(t *testing.T) {
+	q, db := testWriteQueue(t, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go q.execTx(context.Background(), func(tx *sql.Tx) error {
+		close(started)
+		<-release
+		_, err := tx.Exec(`INSERT INTO kv(k, v) VALUES('slow', 'x')`)
+		return err
+	})
+	<-started // the writer is stuck on a slow disk
+
+	q.enqueue(`INSERT INTO kv(k, v) VALUES('queued', 'x')`) // takes the only slot
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.exec(ctx, `INSERT INTO kv(k, v) VALUES('timeout', 'x')`); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("write into a full queue: %v, want the deadline", err)
+	}
+	if st := q.stats(); st.QueueFull != 1 || st.Queued != 1 || st.Capacity != 1 {
+		t.Fatalf("stats = %+v, want one full queue event", st)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		q.enqueue(`INSERT INTO kv(k, v) VALUES('waited', 'x')`)
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("enqueue did not wait for room")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-waited
+	q.close()
+	if n := countKV(t, db); n != 3 {
+		t.Errorf("%d rows, want slow, queued and waited", n)
+	}
+	if st := q.stats(); st.QueueFull != 2 || st.Failed != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+// A failed player sync keeps none of its rows, and the writes batched
+// with it are kept.
+func TestWriteQueueExecTxRollback(t *testing.T) {
+	q, db := testWriteQueue(t, 16)
+	q.enqueue(`INSERT INTO kv(k, v) VALUES('before', 'x')`)
+	err := q.execTx(context.Background(), func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT INTO kv(k, v) VALUES('half', 'x')`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO kv(k, v) VALUES('before', 'dup')`)
+		return err
+	})
+	if err == nil {
+		t.Fatal("duplicate key accepted")
+	}
+	if err := q.exec(context.Background(), `INSERT INTO kv(k, v) VALUES('after', 'x')`); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query(`SELECT k FROM kv ORDER BY k`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		rows.Scan(&k)
+		keys = append(keys, k)
+	}
+	if fmt.Sprint(keys) != "[after before]" {
+		t.Errorf("rows = %v, want [after before]", keys)
+	}
+}